@@ -0,0 +1,828 @@
+//! Local control API
+//!
+//! `serve` exposes the running session over JSON/HTTP, so editor plugins and
+//! other tools can drive the same session as the terminal. Each request is
+//! forwarded to the daemon like a CLI command, and the reply is the daemon's
+//! result in the `--output json` envelope. `GET /v1/events` streams state
+//! changes (see `follow.rs`) as server-sent events.
+//!
+//! The server speaks just enough HTTP/1.1 for that: one request per
+//! connection, with bodies sized by `Content-Length`.
+//!
+//! Every request needs the bearer token, one made up at startup unless
+//! given, so another local user or a web page cannot drive the session. A
+//! loopback server also turns away requests for any host but this one,
+//! against DNS rebinding, and any server turns away a browser's from
+//! another origin and POSTs that are not JSON, which a page can send
+//! without a CORS preflight.
+
+use std::net::SocketAddr;
+use std::time::Duration;
+
+use serde::de::DeserializeOwned;
+use serde::Deserialize;
+use serde_json::Value;
+use tokio::io::{
+    AsyncBufRead, AsyncBufReadExt, AsyncReadExt, AsyncWrite, AsyncWriteExt, BufReader,
+};
+use tokio::net::{TcpListener, TcpStream};
+
+use crate::common::error::IpcError;
+use crate::common::{Error, Result};
+use crate::ipc::protocol::{BreakpointLocation, Command, EvaluateContext};
+use crate::ipc::DaemonClient;
+
+use super::follow::{Follower, POLL_INTERVAL};
+use super::output;
+
+/// Environment variable read for the token when `--token` is not given, so
+/// it stays out of the process list
+pub const TOKEN_VAR: &str = "DEBUGGER_API_TOKEN";
+
+const MAX_HEADER_BYTES: usize = 64 * 1024;
+const MAX_BODY_BYTES: usize = 1024 * 1024;
+
+/// Comment sent on an idle event stream so proxies keep it open
+const KEEPALIVE: Duration = Duration::from_secs(15);
+
+/// A parsed HTTP request
+#[derive(Debug, Default)]
+struct Request {
+    method: String,
+    path: String,
+    query: Vec<(String, String)>,
+    authorization: Option<String>,
+    host: Option<String>,
+    origin: Option<String>,
+    content_type: Option<String>,
+    body: Vec<u8>,
+}
+
+impl Request {
+    /// A numeric query parameter
+    fn number<T: std::str::FromStr>(
+        &self,
+        name: &str,
+    ) -> std::result::Result<Option<T>, Rejection> {
+        match self.query.iter().find(|(key, _)| key == name) {
+            Some((_, value)) => value.parse().map(Some).map_err(|_| {
+                Rejection::bad_request(format!("'{}' must be a number, not '{}'", name, value))
+            }),
+            None => Ok(None),
+        }
+    }
+
+    fn json<T: DeserializeOwned>(&self) -> std::result::Result<T, Rejection> {
+        serde_json::from_slice(&self.body)
+            .map_err(|e| Rejection::bad_request(format!("invalid request body: {}", e)))
+    }
+}
+
+/// A request turned down before it reaches the daemon
+#[derive(Debug)]
+struct Rejection {
+    status: u16,
+    error: IpcError,
+}
+
+impl Rejection {
+    fn new(status: u16, message: impl Into<String>) -> Self {
+        Self {
+            status,
+            error: IpcError {
+                code: "API".to_string(),
+                message: message.into(),
+                timeout_secs: None,
+            },
+        }
+    }
+
+    fn bad_request(message: impl Into<String>) -> Self {
+        Self::new(400, message)
+    }
+}
+
+/// What a request asks for
+#[derive(Debug)]
+enum Route {
+    /// Forward one command to the daemon
+    Command(&'static str, Command),
+    /// Source context, `set listsize` lines unless given
+    Context(Option<usize>),
+    /// Stream state changes
+    Events,
+}
+
+#[derive(Debug, Deserialize)]
+struct BreakpointBody {
+    location: String,
+    condition: Option<String>,
+    hit_count: Option<u32>,
+}
+
+#[derive(Debug, Deserialize)]
+struct EvaluateBody {
+    expression: String,
+    frame_id: Option<i64>,
+    #[serde(default)]
+    context: EvaluateContext,
+}
+
+/// Refuse to listen beyond this machine without a token of the user's own
+pub fn check_exposure(address: SocketAddr, has_token: bool) -> Result<()> {
+    if address.ip().is_loopback() || has_token {
+        Ok(())
+    } else {
+        Err(Error::Api(format!(
+            "listening on {} exposes the session to the network; set a token with --token or ${}",
+            address, TOKEN_VAR
+        )))
+    }
+}
+
+/// A token for a server started without one: 32 bytes from the OS's
+/// random number generator, in hex
+pub fn generate_token() -> Result<String> {
+    let mut bytes = [0u8; 32];
+    getrandom::fill(&mut bytes)
+        .map_err(|e| Error::Api(format!("cannot generate a token: {}", e)))?;
+    Ok(bytes.iter().map(|byte| format!("{:02x}", byte)).collect())
+}
+
+/// Bind the API's listening socket
+pub async fn bind(address: SocketAddr) -> Result<TcpListener> {
+    TcpListener::bind(address)
+        .await
+        .map_err(|e| Error::Api(format!("cannot listen on {}: {}", address, e)))
+}
+
+/// Serve requests until Ctrl+C
+pub async fn serve(listener: TcpListener, token: String) -> Result<()> {
+    let token: std::sync::Arc<str> = token.into();
+    let loopback = listener.local_addr()?.ip().is_loopback();
+    loop {
+        let stream = tokio::select! {
+            accepted = listener.accept() => accepted?.0,
+            _ = tokio::signal::ctrl_c() => return Ok(()),
+        };
+        let token = token.clone();
+        tokio::spawn(async move {
+            if let Err(e) = handle(stream, &token, loopback).await {
+                tracing::debug!("Control API connection ended: {}", e);
+            }
+        });
+    }
+}
+
+async fn handle(stream: TcpStream, token: &str, loopback: bool) -> std::io::Result<()> {
+    let (reader, mut writer) = stream.into_split();
+    let mut reader = BufReader::new(reader);
+
+    let request = match read_request(&mut reader).await {
+        Ok(request) => request,
+        Err(rejection) => return reject(&mut writer, rejection).await,
+    };
+    if !authorized(token, request.authorization.as_deref()) {
+        return reject(
+            &mut writer,
+            Rejection::new(401, "missing or wrong bearer token"),
+        )
+        .await;
+    }
+    if let Err(rejection) = screen(&request, loopback) {
+        return reject(&mut writer, rejection).await;
+    }
+
+    let route = match route(&request) {
+        Ok(route) => route,
+        Err(rejection) => return reject(&mut writer, rejection).await,
+    };
+    let mut client = match DaemonClient::connect().await {
+        Ok(client) => client,
+        Err(e) => {
+            let error = IpcError::from(&e);
+            return reply(&mut writer, "connect", Err(error)).await;
+        }
+    };
+
+    match route {
+        Route::Events => stream_events(&mut writer, &mut client).await,
+        Route::Command(name, command) => {
+            let result = forward(&mut client, command).await;
+            reply(&mut writer, name, result).await
+        }
+        Route::Context(lines) => {
+            let lines = match lines {
+                Some(lines) => lines,
+                None => match forward(&mut client, Command::Settings).await {
+                    Ok(settings) => settings["listsize"].as_u64().unwrap_or(5) as usize,
+                    Err(error) => return reply(&mut writer, "context", Err(error)).await,
+                },
+            };
+            let result = forward(&mut client, Command::Context { lines }).await;
+            reply(&mut writer, "context", result).await
+        }
+    }
+}
+
+async fn forward(
+    client: &mut DaemonClient,
+    command: Command,
+) -> std::result::Result<Value, IpcError> {
+    match client.request(command).await {
+        Ok(result) => result,
+        Err(e) => Err(IpcError::from(&e)),
+    }
+}
+
+/// Compare tokens without stopping at the first difference
+fn authorized(token: &str, authorization: Option<&str>) -> bool {
+    let Some(given) = authorization.and_then(|value| value.strip_prefix("Bearer ")) else {
+        return false;
+    };
+    given.len() == token.len()
+        && given
+            .bytes()
+            .zip(token.bytes())
+            .fold(0, |diff, (a, b)| diff | (a ^ b))
+            == 0
+}
+
+/// Turn away requests a web page could have made: for another host on a
+/// loopback server, from another origin, or POSTs that are not JSON
+fn screen(request: &Request, loopback: bool) -> std::result::Result<(), Rejection> {
+    if loopback && !request.host.as_deref().is_some_and(is_local_host) {
+        return Err(Rejection::new(403, "the Host header must name localhost"));
+    }
+    let foreign = request.origin.as_deref().is_some_and(|origin| {
+        let host = origin
+            .strip_prefix("http://")
+            .or_else(|| origin.strip_prefix("https://"));
+        !host.is_some_and(is_local_host)
+    });
+    if foreign {
+        return Err(Rejection::new(
+            403,
+            "requests from other origins are not accepted",
+        ));
+    }
+    let json = request.content_type.as_deref().is_some_and(|value| {
+        let media_type = value.split(';').next().unwrap_or("").trim();
+        media_type.eq_ignore_ascii_case("application/json")
+    });
+    if request.method == "POST" && !json {
+        return Err(Rejection::new(415, "POST bodies must be application/json"));
+    }
+    Ok(())
+}
+
+/// Whether a `host[:port]` names this machine
+fn is_local_host(host: &str) -> bool {
+    let name = match host.strip_prefix('[') {
+        Some(bracketed) => bracketed.split(']').next().unwrap_or(""),
+        None => host.rsplit_once(':').map_or(host, |(name, _)| name),
+    };
+    name.eq_ignore_ascii_case("localhost")
+        || name
+            .parse::<std::net::IpAddr>()
+            .is_ok_and(|ip| ip.is_loopback())
+}
+
+/// Daemon commands `/v1/command` passes on: those that only look at the
+/// session, so the routes above stay the only way to change it
+fn inspects_only(command: &Command) -> bool {
+    matches!(
+        command,
+        Command::Status
+            | Command::BreakpointList
+            | Command::StackTrace { .. }
+            | Command::Locals { .. }
+            | Command::Scopes { .. }
+            | Command::Variables { .. }
+            | Command::Threads
+            | Command::Modules
+            | Command::ProcSlide
+            | Command::Context { .. }
+            | Command::Disassemble { .. }
+            | Command::Find { .. }
+            | Command::Dwarf { .. }
+            | Command::WatchList
+            | Command::WatchHistory { .. }
+            | Command::StopHistory
+            | Command::WatchdogFirings
+            | Command::SampleList
+            | Command::TrackList
+            | Command::TrackHistory { .. }
+            | Command::Timeline
+            | Command::TraceLog { .. }
+            | Command::ReplayList
+            | Command::ProfileData
+            | Command::CoverageData
+            | Command::TimerData
+            | Command::BtraceList { .. }
+            | Command::Settings
+            | Command::HookList
+            | Command::EventHandlerList
+            | Command::TranscriptStatus
+            | Command::RecordingStatus
+    )
+}
+
+/// Map a request to what it asks for
+fn route(request: &Request) -> std::result::Result<Route, Rejection> {
+    let segments: Vec<&str> = request.path.trim_matches('/').split('/').collect();
+    let Some((&"v1", segments)) = segments.split_first() else {
+        return Err(not_found(request));
+    };
+
+    let id = |segment: &str| {
+        segment
+            .parse::<u32>()
+            .map_err(|_| Rejection::bad_request(format!("'{}' is not a breakpoint ID", segment)))
+    };
+
+    let (name, command) = match (request.method.as_str(), segments) {
+        ("GET", ["events"]) => return Ok(Route::Events),
+        ("GET", ["context"]) => return Ok(Route::Context(request.number("lines")?)),
+        ("GET", ["status"]) => ("status", Command::Status),
+        ("GET", ["breakpoints"]) => ("breakpoints", Command::BreakpointList),
+        ("POST", ["breakpoints"]) => {
+            let body: BreakpointBody = request.json()?;
+            let location = BreakpointLocation::parse(&body.location)
+                .map_err(|e| Rejection::bad_request(e.to_string()))?;
+            (
+                "breakpoints",
+                Command::BreakpointAdd {
+                    location,
+                    condition: body.condition,
+                    hit_count: body.hit_count,
+                },
+            )
+        }
+        ("DELETE", ["breakpoints"]) => (
+            "breakpoints",
+            Command::BreakpointRemove {
+                id: None,
+                all: true,
+            },
+        ),
+        ("DELETE", ["breakpoints", bp]) => (
+            "breakpoints",
+            Command::BreakpointRemove {
+                id: Some(id(bp)?),
+                all: false,
+            },
+        ),
+        ("POST", ["breakpoints", bp, "enable"]) => {
+            ("breakpoints", Command::BreakpointEnable { id: id(bp)? })
+        }
+        ("POST", ["breakpoints", bp, "disable"]) => {
+            ("breakpoints", Command::BreakpointDisable { id: id(bp)? })
+        }
+        ("POST", ["continue"]) => ("continue", Command::Continue),
+        ("POST", ["next"]) => ("next", Command::Next),
+        ("POST", ["step"]) => ("step", Command::StepIn),
+        ("POST", ["finish"]) => ("finish", Command::StepOut),
+        ("POST", ["pause"]) => ("pause", Command::Pause),
+        ("POST", ["await"]) => (
+            "await",
+            Command::Await {
+                timeout_secs: request.number("timeout")?.unwrap_or(30),
+            },
+        ),
+        ("GET", ["threads"]) => ("threads", Command::Threads),
+        ("GET", ["modules"]) => ("modules", Command::Modules),
+        ("GET", ["backtrace"]) => (
+            "backtrace",
+            Command::StackTrace {
+                thread_id: request.number("thread")?,
+                limit: request.number("limit")?.unwrap_or(20),
+                start: request.number("start")?.unwrap_or(0),
+                more: false,
+            },
+        ),
+        ("GET", ["locals"]) => (
+            "locals",
+            Command::Locals {
+                frame_id: request.number("frame")?,
+            },
+        ),
+        ("POST", ["evaluate"]) => {
+            let body: EvaluateBody = request.json()?;
+            (
+                "evaluate",
+                Command::Evaluate {
+                    expression: body.expression,
+                    frame_id: body.frame_id,
+                    context: body.context,
+                    limit: None,
+                },
+            )
+        }
+        ("GET", ["output"]) => (
+            "output",
+            Command::GetOutput {
+                tail: request.number("tail")?,
+                clear: false,
+                since_last_stop: false,
+            },
+        ),
+        ("POST", ["command"]) => {
+            let command: Command = request.json()?;
+            if !inspects_only(&command) {
+                let kind = request.json::<Value>()?["type"].to_string();
+                return Err(Rejection::new(
+                    403,
+                    format!("{} changes the session; /v1/command only inspects it", kind),
+                ));
+            }
+            ("command", command)
+        }
+        _ => return Err(not_found(request)),
+    };
+
+    Ok(Route::Command(name, command))
+}
+
+fn not_found(request: &Request) -> Rejection {
+    Rejection::new(
+        404,
+        format!("no route for {} {}", request.method, request.path),
+    )
+}
+
+/// HTTP status for an error the daemon reported
+fn status_for(code: &str) -> u16 {
+    match code {
+        "DAEMON_NOT_RUNNING" => 503,
+        "TIMEOUT" => 504,
+        "INVALID_LOCATION" | "INVALID_SETTING" => 400,
+        "BREAKPOINT_NOT_FOUND" | "WATCH_NOT_FOUND" | "THREAD_NOT_FOUND" | "FRAME_NOT_FOUND" => 404,
+        "SESSION_NOT_ACTIVE"
+        | "SESSION_ALREADY_ACTIVE"
+        | "INVALID_STATE"
+        | "PROGRAM_EXITED"
+        | "NOTHING_TO_UNDO" => 409,
+        "INTERNAL_ERROR" => 500,
+        _ => 422,
+    }
+}
+
+async fn read_request(
+    reader: &mut (impl AsyncBufRead + Unpin),
+) -> std::result::Result<Request, Rejection> {
+    let malformed = |e: std::io::Error| Rejection::bad_request(format!("malformed request: {}", e));
+
+    let mut lines = Vec::new();
+    let mut header_bytes = 0;
+    loop {
+        let mut line = String::new();
+        let read = reader.read_line(&mut line).await.map_err(malformed)?;
+        header_bytes += read;
+        if read == 0 || header_bytes > MAX_HEADER_BYTES {
+            return Err(Rejection::bad_request(
+                "incomplete or oversized request head",
+            ));
+        }
+        let line = line.trim_end_matches(['\r', '\n']).to_string();
+        if line.is_empty() {
+            break;
+        }
+        lines.push(line);
+    }
+
+    let mut request_line = lines
+        .first()
+        .map(|line| line.split(' '))
+        .into_iter()
+        .flatten();
+    let (Some(method), Some(target)) = (request_line.next(), request_line.next()) else {
+        return Err(Rejection::bad_request("malformed request line"));
+    };
+    let (path, query) = target.split_once('?').unwrap_or((target, ""));
+    let mut request = Request {
+        method: method.to_string(),
+        path: path.to_string(),
+        query: parse_query(query),
+        ..Default::default()
+    };
+
+    let mut content_length = 0;
+    for header in &lines[1..] {
+        let Some((name, value)) = header.split_once(':') else {
+            continue;
+        };
+        let value = value.trim();
+        if name.eq_ignore_ascii_case("content-length") {
+            content_length = value
+                .parse()
+                .map_err(|_| Rejection::bad_request("invalid Content-Length"))?;
+        } else if name.eq_ignore_ascii_case("authorization") {
+            request.authorization = Some(value.to_string());
+        } else if name.eq_ignore_ascii_case("host") {
+            request.host = Some(value.to_string());
+        } else if name.eq_ignore_ascii_case("origin") {
+            request.origin = Some(value.to_string());
+        } else if name.eq_ignore_ascii_case("content-type") {
+            request.content_type = Some(value.to_string());
+        }
+    }
+    if content_length > MAX_BODY_BYTES {
+        return Err(Rejection::new(413, "request body too large"));
+    }
+
+    request.body = vec![0; content_length];
+    reader
+        .read_exact(&mut request.body)
+        .await
+        .map_err(malformed)?;
+    Ok(request)
+}
+
+fn parse_query(query: &str) -> Vec<(String, String)> {
+    query
+        .split('&')
+        .filter(|pair| !pair.is_empty())
+        .map(|pair| {
+            let (key, value) = pair.split_once('=').unwrap_or((pair, ""));
+            (decode(key), decode(value))
+        })
+        .collect()
+}
+
+/// Undo URL percent-encoding, with `+` for space
+fn decode(text: &str) -> String {
+    let bytes = text.as_bytes();
+    let mut decoded = Vec::with_capacity(bytes.len());
+    let mut i = 0;
+    while i < bytes.len() {
+        // `from_str_radix` would take a sign, as in `%+f`
+        let escape = bytes.get(i + 1..i + 3).filter(|hex| hex.iter().all(u8::is_ascii_hexdigit));
+        match (bytes[i], escape) {
+            (b'+', _) => decoded.push(b' '),
+            (b'%', Some(hex)) => {
+                let hex = std::str::from_utf8(hex).unwrap_or_default();
+                decoded.push(u8::from_str_radix(hex, 16).unwrap_or_default());
+                i += 2;
+            }
+            (byte, _) => decoded.push(byte),
+        }
+        i += 1;
+    }
+    String::from_utf8_lossy(&decoded).into_owned()
+}
+
+async fn reply(
+    writer: &mut (impl AsyncWrite + Unpin),
+    name: &str,
+    result: std::result::Result<Value, IpcError>,
+) -> std::io::Result<()> {
+    let status = match &result {
+        Ok(_) => 200,
+        Err(error) => status_for(&error.code),
+    };
+    let body = output::render_result(name, result).map_err(std::io::Error::other)?;
+    respond(writer, status, "application/json", body.as_bytes()).await
+}
+
+async fn reject(
+    writer: &mut (impl AsyncWrite + Unpin),
+    rejection: Rejection,
+) -> std::io::Result<()> {
+    let body = output::render_result("api", Err(rejection.error)).map_err(std::io::Error::other)?;
+    respond(
+        writer,
+        rejection.status,
+        "application/json",
+        body.as_bytes(),
+    )
+    .await
+}
+
+async fn respond(
+    writer: &mut (impl AsyncWrite + Unpin),
+    status: u16,
+    content_type: &str,
+    body: &[u8],
+) -> std::io::Result<()> {
+    let head = format!(
+        "HTTP/1.1 {} {}\r\nContent-Type: {}\r\nContent-Length: {}\r\nConnection: close\r\n\r\n",
+        status,
+        reason(status),
+        content_type,
+        body.len()
+    );
+    writer.write_all(head.as_bytes()).await?;
+    writer.write_all(body).await?;
+    writer.flush().await
+}
+
+fn reason(status: u16) -> &'static str {
+    match status {
+        200 => "OK",
+        400 => "Bad Request",
+        401 => "Unauthorized",
+        403 => "Forbidden",
+        404 => "Not Found",
+        409 => "Conflict",
+        413 => "Payload Too Large",
+        415 => "Unsupported Media Type",
+        422 => "Unprocessable Entity",
+        503 => "Service Unavailable",
+        504 => "Gateway Timeout",
+        _ => "Internal Server Error",
+    }
+}
+
+/// Send an event each time the session's state changes, until the client
+/// disconnects or the daemon exits
+async fn stream_events(
+    writer: &mut (impl AsyncWrite + Unpin),
+    client: &mut DaemonClient,
+) -> std::io::Result<()> {
+    writer
+        .write_all(
+            b"HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\n\
+              Cache-Control: no-cache\r\nConnection: close\r\n\r\n",
+        )
+        .await?;
+    writer.flush().await?;
+
+    let mut follower = Follower::default();
+    let mut idle = Duration::ZERO;
+    loop {
+        let Ok(change) = follower.poll(client).await else {
+            return Ok(());
+        };
+
+        if let Some(change) = change {
+            let message = format!("event: {}\ndata: {}\n\n", change.event, change.data);
+            writer.write_all(message.as_bytes()).await?;
+            writer.flush().await?;
+            idle = Duration::ZERO;
+        } else if idle >= KEEPALIVE {
+            writer.write_all(b": keepalive\n\n").await?;
+            writer.flush().await?;
+            idle = Duration::ZERO;
+        }
+
+        tokio::time::sleep(POLL_INTERVAL).await;
+        idle += POLL_INTERVAL;
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn request(method: &str, target: &str, body: &str) -> Request {
+        let (path, query) = target.split_once('?').unwrap_or((target, ""));
+        Request {
+            method: method.to_string(),
+            path: path.to_string(),
+            query: parse_query(query),
+            host: Some("localhost:7878".to_string()),
+            content_type: Some("application/json".to_string()),
+            body: body.as_bytes().to_vec(),
+            ..Default::default()
+        }
+    }
+
+    fn command(method: &str, target: &str, body: &str) -> Command {
+        match route(&request(method, target, body)) {
+            Ok(Route::Command(_, command)) => command,
+            other => panic!("{} {} routed to {:?}", method, target, other),
+        }
+    }
+
+    #[test]
+    fn routes_map_to_daemon_commands() {
+        assert!(matches!(command("GET", "/v1/status", ""), Command::Status));
+        assert!(matches!(
+            command("DELETE", "/v1/breakpoints/3", ""),
+            Command::BreakpointRemove {
+                id: Some(3),
+                all: false
+            }
+        ));
+        assert!(matches!(
+            command("GET", "/v1/backtrace?limit=5&start=40", ""),
+            Command::StackTrace {
+                thread_id: None,
+                limit: 5,
+                start: 40,
+                more: false,
+            }
+        ));
+        assert!(matches!(
+            command(
+                "POST",
+                "/v1/breakpoints",
+                r#"{"location": "worker.c:42", "condition": "i > 3"}"#
+            ),
+            Command::BreakpointAdd {
+                location: BreakpointLocation::Line { line: 42, .. },
+                condition: Some(_),
+                hit_count: None,
+            }
+        ));
+        assert!(matches!(
+            command("POST", "/v1/command", r#"{"type": "watch_list"}"#),
+            Command::WatchList
+        ));
+        assert!(matches!(
+            route(&request("GET", "/v1/events", "")),
+            Ok(Route::Events)
+        ));
+    }
+
+    #[test]
+    fn bad_requests_are_rejected() {
+        let status =
+            |method, target, body| route(&request(method, target, body)).unwrap_err().status;
+        assert_eq!(status("GET", "/v2/status", ""), 404);
+        assert_eq!(status("POST", "/v1/status", ""), 404);
+        assert_eq!(status("DELETE", "/v1/breakpoints/first", ""), 400);
+        assert_eq!(status("GET", "/v1/backtrace?limit=all", ""), 400);
+        assert_eq!(status("POST", "/v1/evaluate", "{"), 400);
+        assert_eq!(
+            status(
+                "POST",
+                "/v1/command",
+                r#"{"type": "watch_add", "expression": "n"}"#
+            ),
+            403
+        );
+    }
+
+    #[test]
+    fn requests_a_page_could_make_are_screened() {
+        let status =
+            |request: &Request, loopback| screen(request, loopback).err().map(|r| r.status);
+        let local = request("POST", "/v1/continue", "");
+        assert_eq!(status(&local, true), None);
+
+        let mut rebound = request("GET", "/v1/status", "");
+        rebound.host = Some("attacker.example:7878".to_string());
+        assert_eq!(status(&rebound, true), Some(403));
+        assert_eq!(status(&rebound, false), None);
+        rebound.host = None;
+        assert_eq!(status(&rebound, true), Some(403));
+
+        let mut cross = request("GET", "/v1/status", "");
+        cross.origin = Some("https://attacker.example".to_string());
+        assert_eq!(status(&cross, false), Some(403));
+        cross.origin = Some("http://127.0.0.1:3000".to_string());
+        assert_eq!(status(&cross, true), None);
+
+        let mut form = request("POST", "/v1/continue", "");
+        form.content_type = Some("text/plain".to_string());
+        assert_eq!(status(&form, true), Some(415));
+        form.content_type = Some("application/json; charset=utf-8".to_string());
+        assert_eq!(status(&form, true), None);
+
+        assert!(["localhost", "127.0.0.1:7878", "[::1]:7878", "LOCALHOST"]
+            .iter()
+            .all(|host| is_local_host(host)));
+        assert!(!is_local_host("localhost.example"));
+    }
+
+    #[tokio::test]
+    async fn requests_are_parsed_from_the_wire() {
+        let wire = "POST /v1/evaluate?x=a%20b+c HTTP/1.1\r\nHost: localhost\r\n\
+                    authorization: Bearer s3cret\r\nContent-Length: 20\r\n\r\n\
+                    {\"expression\":\"n\"}  ";
+        let mut reader = BufReader::new(wire.as_bytes());
+        let request = read_request(&mut reader).await.unwrap();
+        assert_eq!(request.method, "POST");
+        assert_eq!(request.path, "/v1/evaluate");
+        assert_eq!(request.query, vec![("x".to_string(), "a b c".to_string())]);
+        assert_eq!(request.body.len(), 20);
+        assert_eq!(request.host.as_deref(), Some("localhost"));
+        assert!(authorized("s3cret", request.authorization.as_deref()));
+        assert!(!authorized("s3cre7", request.authorization.as_deref()));
+        assert!(!authorized("s3cret", None));
+    }
+
+    #[test]
+    fn only_two_hex_digits_make_an_escape() {
+        assert_eq!(decode("%41%2fx"), "A/x");
+        assert_eq!(decode("%+f%-1%4"), "% f%-1%4");
+        assert_eq!(decode("100%"), "100%");
+    }
+
+    #[test]
+    fn network_addresses_need_a_token() {
+        let local: SocketAddr = "127.0.0.1:7878".parse().unwrap();
+        let public: SocketAddr = "0.0.0.0:7878".parse().unwrap();
+        assert!(check_exposure(local, false).is_ok());
+        assert!(check_exposure(public, false).is_err());
+        assert!(check_exposure(public, true).is_ok());
+        assert_eq!(status_for("SESSION_NOT_ACTIVE"), 409);
+
+        let token = generate_token().unwrap();
+        assert_eq!(token.len(), 64);
+        assert!(token.bytes().all(|byte| byte.is_ascii_hexdigit()));
+        assert_ne!(token, generate_token().unwrap());
+    }
+}
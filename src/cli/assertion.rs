@@ -0,0 +1,123 @@
+//! Checking expressions with `assert`
+//!
+//! An assertion evaluates its expression like `print` and passes when the
+//! result is true as an `if` in a user-defined command would see it. A
+//! failure says what was expected and what the program had instead, is
+//! recorded by the daemon so `status` shows the session failed, and makes a
+//! batch exit with status 1 even when it happened in an event handler.
+
+use std::sync::Mutex;
+
+use serde::Serialize;
+
+use crate::common::{Error, Result};
+use crate::ipc::protocol::{Command, EvaluateContext, EvaluateResult};
+use crate::ipc::DaemonClient;
+
+use super::user;
+
+/// Every assertion checked in this process, for `--ci` reports
+static RESULTS: Mutex<Vec<AssertionResult>> = Mutex::new(Vec::new());
+
+/// Comparisons whose left side is shown when they fail
+const COMPARISONS: &[&str] = &["==", "!=", "<=", ">=", "<", ">"];
+
+/// How one assertion went
+#[derive(Debug, Clone, Serialize)]
+pub struct AssertionResult {
+    pub expression: String,
+    pub passed: bool,
+    /// The expression's value when it held
+    pub value: Option<String>,
+    /// What was expected and what the program had, when it did not
+    pub failure: Option<String>,
+}
+
+fn record(result: AssertionResult) {
+    if let Ok(mut results) = RESULTS.lock() {
+        results.push(result);
+    }
+}
+
+/// The assertions checked so far, in order
+pub fn results() -> Vec<AssertionResult> {
+    RESULTS.lock().map(|results| results.clone()).unwrap_or_default()
+}
+
+/// Whether an assertion has failed in this process
+pub fn any_failed() -> bool {
+    results().iter().any(|result| !result.passed)
+}
+
+/// The left side of a comparison like `count == 5`
+fn left_operand(expression: &str) -> Option<&str> {
+    COMPARISONS
+        .iter()
+        .filter_map(|op| expression.find(&format!(" {} ", op)))
+        .min()
+        .map(|end| expression[..end].trim())
+        .filter(|left| !left.is_empty())
+}
+
+async fn evaluate(client: &mut DaemonClient, expression: &str) -> Result<String> {
+    let result = client
+        .send_command(Command::Evaluate {
+            expression: expression.to_string(),
+            frame_id: None,
+            context: EvaluateContext::Watch,
+            limit: None,
+        })
+        .await?;
+    let eval: EvaluateResult = serde_json::from_value(result)?;
+    Ok(eval.result)
+}
+
+/// Evaluate an assertion; returns the value it had when it holds
+pub async fn check(client: &mut DaemonClient, expression: &str) -> Result<String> {
+    let value = evaluate(client, expression).await?;
+    if user::is_true(&value) {
+        record(AssertionResult {
+            expression: expression.to_string(),
+            passed: true,
+            value: Some(value.clone()),
+            failure: None,
+        });
+        return Ok(value);
+    }
+
+    let (expected, actual) = match left_operand(expression) {
+        Some(left) => {
+            let actual = match evaluate(client, left).await {
+                Ok(actual) => format!("{} = {}", left, actual),
+                Err(_) => value,
+            };
+            (expression.to_string(), actual)
+        }
+        None => (format!("{} to be true", expression), value),
+    };
+
+    let message = format!("expected {}, got {}", expected, actual);
+    record(AssertionResult {
+        expression: expression.to_string(),
+        passed: false,
+        value: None,
+        failure: Some(message.clone()),
+    });
+    // The failure is reported either way; the daemon only keeps count
+    let _ = client.send_command(Command::AssertFailed { message }).await;
+    Err(Error::AssertionFailed { expected, actual })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn left_operand_finds_the_first_comparison() {
+        assert_eq!(left_operand("count == 5"), Some("count"));
+        assert_eq!(left_operand("a->len <= limit"), Some("a->len"));
+        assert_eq!(left_operand("x + 1 > y == 0"), Some("x + 1"));
+        assert_eq!(left_operand("ptr"), None);
+        assert_eq!(left_operand("a<b"), None);
+    }
+}
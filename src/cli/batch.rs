@@ -0,0 +1,476 @@
+//! Batch mode
+//!
+//! `--command-file` and `-ex` run a list of commands in one invocation, and
+//! `--batch` makes that safe for shell scripts and Makefiles: nothing prompts,
+//! confirmation banners are suppressed, a session started by the batch is
+//! ended when it finishes, and the exit status reports the outcome.
+
+use std::collections::BTreeMap;
+use std::ffi::OsString;
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicBool, AtomicI64, AtomicUsize, Ordering};
+use std::sync::Mutex;
+use std::time::Instant;
+
+use serde_json::Value;
+
+use crate::commands::Commands;
+use crate::common::{paths, Error, Result};
+use crate::ipc::protocol::Command;
+use crate::ipc::DaemonClient;
+
+use super::ci::{self, CiOptions};
+use super::script::{self, Variables};
+use super::theme::{self, Element};
+use super::watchdog::{self, WatchdogOptions};
+use super::{assertion, dispatch, output};
+
+/// Set for `--batch`, so nothing waits on a terminal
+static ACTIVE: AtomicBool = AtomicBool::new(false);
+
+/// Exit code of the debuggee as last reported by `await`, or `NO_EXIT_CODE`
+static TARGET_EXIT_CODE: AtomicI64 = AtomicI64::new(NO_EXIT_CODE);
+const NO_EXIT_CODE: i64 = i64::MIN;
+
+/// Stops `await` reported at each breakpoint, for `--ci` reports
+static BREAKPOINT_HITS: Mutex<BTreeMap<u32, usize>> = Mutex::new(BTreeMap::new());
+
+/// How deeply `source` is nested, so a script that sources itself fails
+/// instead of recursing forever
+static SOURCE_DEPTH: AtomicUsize = AtomicUsize::new(0);
+const MAX_SOURCE_DEPTH: usize = 16;
+
+/// `-D` definitions of the batch or sourced file running now, which a
+/// `source` inside it inherits
+static DEFINITIONS: Mutex<Vec<String>> = Mutex::new(Vec::new());
+
+/// Where batch commands come from, in the order they run
+pub struct BatchOptions {
+    /// `--batch`: no prompts, no banners, clean up the session at the end
+    pub batch: bool,
+    /// `--command-file` scripts, run first
+    pub command_files: Vec<PathBuf>,
+    /// `-ex` commands, run after the files
+    pub commands: Vec<String>,
+    /// A subcommand given alongside the batch options, run last
+    pub command: Option<Commands>,
+    /// `--continue-on-error`: run the rest after a failure (still exit 1)
+    pub continue_on_error: bool,
+    /// `-D NAME=VALUE` values for `${NAME}` in files and `-ex` commands
+    pub definitions: Vec<String>,
+    /// `--ci`: `--batch` with a time limit and a report
+    pub ci: Option<CiOptions>,
+    /// `--watchdog`: interrupt a program that runs this long without an event
+    pub watchdog: WatchdogOptions,
+}
+
+impl BatchOptions {
+    /// Whether any batch option was given on the command line
+    pub fn requested(&self) -> bool {
+        self.batch
+            || !self.command_files.is_empty()
+            || !self.commands.is_empty()
+            || !self.definitions.is_empty()
+            || self.continue_on_error
+            || self.ci.is_some()
+    }
+}
+
+/// A batch command and where it came from
+struct BatchCommand {
+    /// Script path or `-ex`; `None` for the trailing subcommand
+    source: Option<String>,
+    line: usize,
+    command: Commands,
+}
+
+/// Whether `--batch` is in effect
+pub fn is_active() -> bool {
+    ACTIVE.load(Ordering::Relaxed)
+}
+
+/// Remember the debuggee's exit code for the batch exit status
+pub fn record_exit_code(code: i32) {
+    TARGET_EXIT_CODE.store(code.into(), Ordering::Relaxed);
+}
+
+/// Remember what a stop result reports: the exit code, or the breakpoints
+/// the program stopped at
+pub fn record_stop(result: &Value) {
+    if let Some(code) = result.get("exit_code").and_then(|v| v.as_i64()) {
+        record_exit_code(code as i32);
+    }
+    // A stop seen before is not another hit
+    if result["already_stopped"].as_bool().unwrap_or(false) {
+        return;
+    }
+    let Some(ids) = result.get("hit_breakpoint_ids").and_then(|v| v.as_array()) else {
+        return;
+    };
+    if let Ok(mut hits) = BREAKPOINT_HITS.lock() {
+        for id in ids.iter().filter_map(|id| id.as_u64()) {
+            *hits.entry(id as u32).or_default() += 1;
+        }
+    }
+}
+
+/// What running the batch's commands did
+#[derive(Default)]
+struct Outcome {
+    ran: usize,
+    failures: Vec<ci::Failure>,
+    /// Sessions the batch started a program in, and the command that did
+    sessions_started: Vec<(String, &'static str)>,
+}
+
+/// Accept GDB's single-dash `-ex` spelling by rewriting it to `--ex`
+///
+/// Arguments after `--` belong to the debuggee and are left alone.
+pub fn normalize_args(args: impl IntoIterator<Item = OsString>) -> Vec<OsString> {
+    let mut passthrough = false;
+    args.into_iter()
+        .map(|arg| {
+            if passthrough {
+                arg
+            } else if arg == "--" {
+                passthrough = true;
+                arg
+            } else if arg == "-ex" {
+                OsString::from("--ex")
+            } else {
+                arg
+            }
+        })
+        .collect()
+}
+
+/// Run the batch and return the process exit status
+///
+/// Every command is parsed before any runs. The first failing command stops
+/// the batch with status 1 (with `--continue-on-error` the rest still run),
+/// and a failed `assert`, even in an event handler, also makes it 1;
+/// otherwise the status is the debuggee's exit code if `await` saw it exit,
+/// or 0. A `--ci` run that hits its time limit exits with 124, and a run
+/// the watchdog aborted fails.
+pub async fn run(options: BatchOptions) -> i32 {
+    let started = Instant::now();
+    let batch = options.batch || options.ci.is_some();
+    ACTIVE.store(batch, Ordering::Relaxed);
+    output::set_quiet(batch);
+
+    let mut outcome = Outcome::default();
+    if let Ok(mut definitions) = DEFINITIONS.lock() {
+        definitions.clone_from(&options.definitions);
+    }
+    let commands = Variables::parse(&options.definitions).and_then(|variables| {
+        collect(
+            options.command_files,
+            options.commands,
+            options.command,
+            &variables,
+        )
+    });
+    let commands = match commands {
+        Ok(commands) => commands,
+        Err(e) => {
+            report("batch", &e);
+            outcome.failures.push(ci::Failure {
+                source: None,
+                line: 0,
+                command: "batch".to_string(),
+                error: e.to_string(),
+            });
+            Vec::new()
+        }
+    };
+
+    let watchdog = if batch {
+        watchdog::arm(&options.watchdog).await.unwrap_or_else(|e| {
+            report("watchdog", &e);
+            None
+        })
+    } else {
+        None
+    };
+
+    let commands = run_commands(commands, options.continue_on_error, &mut outcome);
+    let timed_out = match &options.ci {
+        Some(ci) => tokio::time::timeout(ci.timeout, commands).await.is_err(),
+        None => {
+            commands.await;
+            false
+        }
+    };
+
+    let firings = match watchdog {
+        Some(watchdog) => watchdog.disarm().await,
+        None => Vec::new(),
+    };
+    for firing in &firings {
+        if let Some(error) = watchdog::failure(firing) {
+            outcome.failures.push(ci::Failure {
+                source: None,
+                line: 0,
+                command: "watchdog".to_string(),
+                error,
+            });
+        }
+    }
+
+    let exit_code = match TARGET_EXIT_CODE.load(Ordering::Relaxed) {
+        NO_EXIT_CODE => None,
+        code => Some(code as i32),
+    };
+    let mut status = if timed_out {
+        ci::TIMEOUT_STATUS
+    } else if !outcome.failures.is_empty() || assertion::any_failed() {
+        1
+    } else {
+        exit_code.unwrap_or(0)
+    };
+
+    if let Some(options) = &options.ci {
+        let hits = BREAKPOINT_HITS.lock().map(|hits| hits.clone()).unwrap_or_default();
+        let results = ci::Report {
+            passed: status == 0,
+            status,
+            timed_out,
+            duration_secs: started.elapsed().as_secs_f64(),
+            exit_code,
+            commands_run: outcome.ran,
+            failures: outcome.failures,
+            assertions: assertion::results(),
+            breakpoints_hit: ci::breakpoint_hits(hits).await,
+            watchdog: firings,
+        };
+        if let Err(e) = ci::finish(options, &results) {
+            report("ci", &e);
+            status = status.max(1);
+        }
+    }
+
+    if batch {
+        for (session, started_by) in &outcome.sessions_started {
+            end_session(session, started_by).await;
+        }
+    }
+
+    status
+}
+
+/// Run the commands in order until one fails, unless `continue_on_error`
+async fn run_commands(commands: Vec<BatchCommand>, continue_on_error: bool, outcome: &mut Outcome) {
+    for command in commands {
+        let name = command.command.name();
+        let starts_session = matches!(name, "start" | "attach");
+        outcome.ran += 1;
+
+        if let Err(e) = dispatch(command.command).await {
+            match &command.source {
+                Some(path) => report_line(name, path, command.line, &e),
+                None => report(name, &e),
+            }
+            outcome.failures.push(ci::Failure {
+                source: command.source,
+                line: command.line,
+                command: name.to_string(),
+                error: e.to_string(),
+            });
+            if !continue_on_error {
+                break;
+            }
+            continue;
+        }
+
+        if starts_session {
+            let session = paths::session_name();
+            outcome.sessions_started.retain(|(started, _)| *started != session);
+            outcome.sessions_started.push((session, name));
+        }
+    }
+}
+
+/// Parse every batch command up front, so a typo on the last line does not
+/// leave a half-run batch behind
+fn collect(
+    files: Vec<PathBuf>,
+    lines: Vec<String>,
+    command: Option<Commands>,
+    variables: &Variables,
+) -> Result<Vec<BatchCommand>> {
+    let mut commands = Vec::new();
+
+    for file in &files {
+        let source = file.display().to_string();
+        for command in script::load_with(file, variables)? {
+            commands.push(BatchCommand {
+                source: Some(source.clone()),
+                line: command.line,
+                command: command.command,
+            });
+        }
+    }
+
+    for (index, text) in lines.into_iter().enumerate() {
+        let text = variables.interpolate(&text);
+        let command = script::parse_line(&text).map_err(|message| Error::Script {
+            path: "-ex".to_string(),
+            line: index + 1,
+            message,
+        })?;
+        commands.push(BatchCommand {
+            source: Some("-ex".to_string()),
+            line: index + 1,
+            command,
+        });
+    }
+
+    if let Some(command) = command {
+        commands.push(BatchCommand {
+            source: None,
+            line: 0,
+            command,
+        });
+    }
+
+    Ok(commands)
+}
+
+/// Run a script file's commands in order, for `source`
+///
+/// Like a batch, the whole file is parsed before anything runs. Returns how
+/// many commands ran; with `continue_on_error` failures are reported as they
+/// happen and the script fails at the end if any command did.
+///
+/// `definitions` add to and override those of the batch or script doing the
+/// sourcing.
+pub async fn source(path: &Path, continue_on_error: bool, definitions: &[String]) -> Result<usize> {
+    let outer = DEFINITIONS.lock().map(|defined| defined.clone()).unwrap_or_default();
+    let mut inherited = outer.clone();
+    inherited.extend_from_slice(definitions);
+    let variables = Variables::parse(&inherited)?;
+
+    if let Ok(mut defined) = DEFINITIONS.lock() {
+        *defined = inherited;
+    }
+    let result = run_source(path, continue_on_error, &variables).await;
+    if let Ok(mut defined) = DEFINITIONS.lock() {
+        *defined = outer;
+    }
+    result
+}
+
+async fn run_source(path: &Path, continue_on_error: bool, variables: &Variables) -> Result<usize> {
+    let commands = script::load_with(path, variables)?;
+    let label = path.display().to_string();
+
+    if SOURCE_DEPTH.fetch_add(1, Ordering::Relaxed) >= MAX_SOURCE_DEPTH {
+        SOURCE_DEPTH.fetch_sub(1, Ordering::Relaxed);
+        return Err(Error::Script {
+            path: label,
+            line: 1,
+            message: format!("'source' nested more than {} deep", MAX_SOURCE_DEPTH),
+        });
+    }
+
+    let total = commands.len();
+    let mut failed = Vec::new();
+    for command in commands {
+        let name = command.command.name();
+        let Err(e) = Box::pin(dispatch(command.command)).await else {
+            continue;
+        };
+        if !continue_on_error {
+            SOURCE_DEPTH.fetch_sub(1, Ordering::Relaxed);
+            // A nested script already names the file and line that failed
+            return Err(match e {
+                Error::Script { .. } if name == "source" => e,
+                e => Error::Script {
+                    path: label,
+                    line: command.line,
+                    message: e.to_string(),
+                },
+            });
+        }
+        report_line(name, &label, command.line, &e);
+        failed.push(command.line);
+    }
+    SOURCE_DEPTH.fetch_sub(1, Ordering::Relaxed);
+
+    match failed.first() {
+        None => Ok(total),
+        Some(line) => Err(Error::Script {
+            path: label,
+            line: *line,
+            message: format!("{} of {} commands failed", failed.len(), total),
+        }),
+    }
+}
+
+/// Like GDB's batch mode, kill a launched program and detach from an
+/// attached one, so the next batch run starts from a clean daemon
+async fn end_session(session: &str, started_by: &str) {
+    let command = if started_by == "attach" {
+        Command::Detach
+    } else {
+        Command::Stop
+    };
+
+    if let Ok(mut client) = DaemonClient::connect_to_session(session).await {
+        // The session may already be gone (an explicit `stop` in the batch)
+        let _ = client.send_command(command).await;
+    }
+}
+
+/// Report a failing scripted command with the file and line it came from
+fn report_line(name: &str, path: &str, line: usize, error: &Error) {
+    // JSON keeps the original error so its code survives
+    if output::is_json() {
+        report(name, error);
+    } else {
+        eprintln!(
+            "{} {}:{}: {}",
+            theme::paint_stderr(Element::Error, "Error:"),
+            path,
+            line,
+            error
+        );
+    }
+}
+
+fn report(name: &str, error: &Error) {
+    if output::is_json() {
+        output::emit_error(name, error);
+    } else {
+        eprintln!("{} {error}", theme::paint_stderr(Element::Error, "Error:"));
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn normalize_rewrites_ex_before_separator_only() {
+        let args = ["debugger", "-ex", "bt", "start", "./a.out", "--", "-ex"]
+            .map(OsString::from);
+        let normalized = normalize_args(args);
+        assert_eq!(normalized[1], "--ex");
+        assert_eq!(normalized[6], "-ex");
+    }
+
+    #[test]
+    fn collect_labels_ex_commands_and_rejects_bad_lines() {
+        let variables = Variables::default();
+        let commands =
+            collect(Vec::new(), vec!["bt".into(), "locals".into()], None, &variables).unwrap();
+        assert_eq!(commands.len(), 2);
+        assert_eq!(commands[1].line, 2);
+        assert_eq!(commands[1].source.as_deref(), Some("-ex"));
+
+        let err = collect(Vec::new(), vec!["bt".into(), "frobnicate".into()], None, &variables)
+            .err()
+            .unwrap();
+        assert!(err.to_string().starts_with("-ex:2:"));
+    }
+}
@@ -0,0 +1,82 @@
+//! Rendering `btrace list` results for the terminal
+
+use crate::ipc::protocol::{BtraceCall, BtraceInstruction};
+
+/// Function segments, one a line, indented by call depth
+pub fn calls(calls: &[BtraceCall]) -> String {
+    let mut text = String::new();
+    for call in calls {
+        text.push_str(&format!("{:>6}  {}{}", call.number, "  ".repeat(call.depth), call.function));
+        if let Some([first, last]) = call.instructions {
+            text.push_str(&format!("  inst {}-{}", first, last));
+        }
+        if let (Some(source), Some([first, last])) = (&call.source, call.lines) {
+            let name = source.rsplit('/').next().unwrap_or(source);
+            if first == last {
+                text.push_str(&format!("  at {}:{}", name, first));
+            } else {
+                text.push_str(&format!("  at {}:{}-{}", name, first, last));
+            }
+        }
+        text.push('\n');
+    }
+    text
+}
+
+/// Instructions, one a line, with gaps in the trace where they fall
+pub fn instructions(instructions: &[BtraceInstruction]) -> String {
+    let mut text = String::new();
+    for instruction in instructions {
+        let line = match (&instruction.address, &instruction.function) {
+            (None, _) => format!("{:>8}  {}", instruction.number, instruction.instruction),
+            (Some(address), Some(function)) => format!(
+                "{:>8}  {} <{}>  {}",
+                instruction.number, address, function, instruction.instruction
+            ),
+            (Some(address), None) => {
+                format!("{:>8}  {}  {}", instruction.number, address, instruction.instruction)
+            }
+        };
+        text.push_str(&line);
+        text.push('\n');
+    }
+    text
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn segments_are_indented_by_depth() {
+        let call = |number, depth, function: &str, lines| BtraceCall {
+            number,
+            depth,
+            function: function.to_string(),
+            instructions: Some([number * 10, number * 10 + 9]),
+            source: Some("/src/t.c".to_string()),
+            lines,
+        };
+        assert_eq!(
+            calls(&[call(4, 0, "main", Some([5, 8])), call(5, 1, "add", Some([2, 2]))]),
+            "     4  main  inst 40-49  at t.c:5-8\n     5    add  inst 50-59  at t.c:2\n"
+        );
+
+        let gap = BtraceInstruction {
+            number: 12,
+            address: None,
+            function: None,
+            instruction: "[decode error (1): instruction overflow]".to_string(),
+        };
+        let ret = BtraceInstruction {
+            number: 13,
+            address: Some("0x401136".to_string()),
+            function: Some("main+4".to_string()),
+            instruction: "ret".to_string(),
+        };
+        assert_eq!(
+            instructions(&[gap, ret]),
+            "      12  [decode error (1): instruction overflow]\n      13  0x401136 <main+4>  ret\n"
+        );
+    }
+}
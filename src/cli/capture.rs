@@ -0,0 +1,284 @@
+//! Capturing a command's output for `--copy` and session transcripts
+//!
+//! Stdout is redirected into a pipe for the rest of the process, the way the
+//! pager is, and a thread echoes everything to the real stdout while keeping
+//! a copy. Colors still reach a terminal; the text is delivered with them
+//! stripped. Paging is skipped while capturing. Macro recording needs only
+//! the command line and whether it succeeded, so it does not capture.
+
+use std::io::IsTerminal;
+use std::sync::Mutex;
+
+use super::{clipboard, macros, transcript};
+
+/// Where captured output goes when the command finishes
+struct Delivery {
+    copy: bool,
+    /// Command line to record in the transcript
+    transcript: Option<String>,
+    /// Command line to record in the macro, if the command succeeds
+    macro_line: Option<String>,
+    /// Whether stdout is being captured
+    capturing: bool,
+    /// Error the command failed with, printed to stderr but kept for the
+    /// transcript
+    error: Option<String>,
+}
+
+static DELIVERY: Mutex<Option<Delivery>> = Mutex::new(None);
+
+#[cfg(unix)]
+mod imp {
+    use std::fs::File;
+    use std::io::{Read, Write};
+    use std::os::unix::io::FromRawFd;
+    use std::sync::Mutex;
+    use std::thread::JoinHandle;
+
+    struct Capture {
+        saved_stdout: libc::c_int,
+        reader: JoinHandle<Vec<u8>>,
+    }
+
+    static CAPTURE: Mutex<Option<Capture>> = Mutex::new(None);
+
+    pub fn start() -> bool {
+        if is_active() {
+            return true;
+        }
+
+        let mut fds = [0 as libc::c_int; 2];
+        // SAFETY: fds has room for the two descriptors pipe() writes
+        if unsafe { libc::pipe(fds.as_mut_ptr()) } < 0 {
+            return false;
+        }
+        let [read_fd, write_fd] = fds;
+
+        let _ = std::io::stdout().flush();
+        // SAFETY: all descriptors are open; dup2 atomically replaces fd 1
+        let saved_stdout = unsafe { libc::dup(libc::STDOUT_FILENO) };
+        if saved_stdout < 0 || unsafe { libc::dup2(write_fd, libc::STDOUT_FILENO) } < 0 {
+            // SAFETY: closing descriptors this function opened
+            unsafe {
+                libc::close(read_fd);
+                libc::close(write_fd);
+            }
+            return false;
+        }
+        // SAFETY: fd 1 now holds the write end
+        unsafe {
+            libc::close(write_fd);
+        }
+
+        // SAFETY: each descriptor is owned by exactly one File from here on
+        let mut pipe = unsafe { File::from_raw_fd(read_fd) };
+        let mut terminal = unsafe { File::from_raw_fd(libc::dup(saved_stdout)) };
+        let reader = std::thread::spawn(move || {
+            let mut captured = Vec::new();
+            let mut chunk = [0u8; 8192];
+            while let Ok(n) = pipe.read(&mut chunk) {
+                if n == 0 {
+                    break;
+                }
+                let _ = terminal.write_all(&chunk[..n]);
+                captured.extend_from_slice(&chunk[..n]);
+            }
+            captured
+        });
+
+        if let Ok(mut slot) = CAPTURE.lock() {
+            *slot = Some(Capture {
+                saved_stdout,
+                reader,
+            });
+        }
+        true
+    }
+
+    pub fn is_active() -> bool {
+        CAPTURE.lock().map(|slot| slot.is_some()).unwrap_or(false)
+    }
+
+    pub fn is_terminal() -> bool {
+        CAPTURE
+            .lock()
+            .ok()
+            .and_then(|slot| slot.as_ref().map(|capture| capture.saved_stdout))
+            // SAFETY: isatty only inspects the descriptor
+            .is_some_and(|fd| unsafe { libc::isatty(fd) } == 1)
+    }
+
+    pub fn finish() -> Option<Vec<u8>> {
+        let capture = CAPTURE.lock().ok().and_then(|mut slot| slot.take())?;
+
+        let _ = std::io::stdout().flush();
+        // SAFETY: putting the original stdout back closes the pipe's last
+        // write end, which ends the reader thread
+        unsafe {
+            libc::dup2(capture.saved_stdout, libc::STDOUT_FILENO);
+            libc::close(capture.saved_stdout);
+        }
+        capture.reader.join().ok()
+    }
+}
+
+#[cfg(not(unix))]
+mod imp {
+    pub fn start() -> bool {
+        false
+    }
+
+    pub fn is_active() -> bool {
+        false
+    }
+
+    pub fn is_terminal() -> bool {
+        false
+    }
+
+    pub fn finish() -> Option<Vec<u8>> {
+        None
+    }
+}
+
+/// Capture this command's output if `--copy` asked for it or a transcript
+/// is recording (and `record` allows it), and note its command line for a
+/// macro being recorded (if `record_macro` allows it)
+pub fn begin(copy: bool, record: bool, record_macro: bool) {
+    let recording = if record || record_macro {
+        transcript::recording()
+    } else {
+        transcript::Recording::default()
+    };
+    let transcript = (record && recording.transcript).then(transcript::command_line);
+    let macro_line = if record_macro && recording.macro_name.is_some() {
+        macros::command_line()
+    } else {
+        None
+    };
+    if !copy && transcript.is_none() && macro_line.is_none() {
+        return;
+    }
+
+    let capturing = (copy || transcript.is_some()) && imp::start();
+    if copy && !capturing {
+        eprintln!("Warning: --copy is not supported here; output is not copied");
+    }
+    if let Ok(mut slot) = DELIVERY.lock() {
+        *slot = Some(Delivery {
+            copy,
+            transcript,
+            macro_line,
+            capturing,
+            error: None,
+        });
+    }
+}
+
+/// Keep the error a command failed with for the transcript
+pub fn record_error(message: String) {
+    if let Ok(mut slot) = DELIVERY.lock() {
+        if let Some(delivery) = slot.as_mut() {
+            delivery.error = Some(message);
+        }
+    }
+}
+
+/// Stop capturing and deliver the output; must run before the process exits
+pub async fn end() {
+    let Some(delivery) = DELIVERY.lock().ok().and_then(|mut slot| slot.take()) else {
+        return;
+    };
+    if let (Some(line), None) = (delivery.macro_line, &delivery.error) {
+        macros::record(line).await;
+    }
+    if !delivery.capturing {
+        return;
+    }
+    let Some(output) = finish() else {
+        return;
+    };
+
+    if delivery.copy {
+        clipboard::copy_output(&output);
+    }
+    if let Some(command_line) = delivery.transcript {
+        let output = match delivery.error {
+            Some(error) => format!("{}Error: {}\n", output, error),
+            None => output,
+        };
+        transcript::record(command_line, output).await;
+    }
+}
+
+/// Whether stdout is being captured
+pub fn is_active() -> bool {
+    imp::is_active()
+}
+
+/// Whether captured output is echoed to a terminal
+pub fn is_terminal() -> bool {
+    if is_active() {
+        imp::is_terminal()
+    } else {
+        std::io::stdout().is_terminal()
+    }
+}
+
+/// Stop capturing and return what was printed, without colors
+fn finish() -> Option<String> {
+    imp::finish().map(|captured| strip_ansi(&String::from_utf8_lossy(&captured)))
+}
+
+/// Remove ANSI escape sequences (colors, OSC strings) from text
+fn strip_ansi(text: &str) -> String {
+    let mut plain = String::with_capacity(text.len());
+    let mut chars = text.chars().peekable();
+
+    while let Some(c) = chars.next() {
+        if c != '\x1b' {
+            plain.push(c);
+            continue;
+        }
+        match chars.next() {
+            // CSI: parameters, then a final byte in @..~
+            Some('[') => {
+                for c in chars.by_ref() {
+                    if ('@'..='~').contains(&c) {
+                        break;
+                    }
+                }
+            }
+            // OSC: up to BEL or ESC \
+            Some(']') => {
+                while let Some(c) = chars.next() {
+                    if c == '\x07' {
+                        break;
+                    }
+                    if c == '\x1b' && chars.peek() == Some(&'\\') {
+                        chars.next();
+                        break;
+                    }
+                }
+            }
+            _ => {}
+        }
+    }
+
+    plain
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn strips_colors_and_osc() {
+        assert_eq!(strip_ansi("\x1b[1;31mError:\x1b[0m boom"), "Error: boom");
+        assert_eq!(
+            strip_ansi("a\x1b]8;;http://x\x07link\x1b]8;;\x1b\\b"),
+            "alinkb"
+        );
+        assert_eq!(strip_ansi("plain -> text"), "plain -> text");
+    }
+}
@@ -0,0 +1,305 @@
+//! CI mode
+//!
+//! `--ci` runs a batch as a CI job: it implies `--batch`, so nothing waits
+//! on a terminal, stops the run after `--ci-timeout` seconds, and reports the
+//! assertions checked, breakpoints hit, commands that failed and the
+//! program's exit status as a summary, a JSON report (`--report`) and JUnit
+//! XML (`--junit`) that CI dashboards can show.
+
+use std::collections::BTreeMap;
+use std::path::PathBuf;
+use std::time::Duration;
+
+use serde::Serialize;
+
+use crate::common::Result;
+use crate::ipc::protocol::{BreakpointInfo, Command, WatchdogFiring};
+use crate::ipc::DaemonClient;
+
+use super::assertion::AssertionResult;
+use super::output;
+
+/// Exit status of a run stopped by the time limit, as with timeout(1)
+pub const TIMEOUT_STATUS: i32 = 124;
+
+/// Time limit when `--ci-timeout` is not given
+pub const DEFAULT_TIMEOUT_SECS: u64 = 600;
+
+/// `--ci` and its report options
+pub struct CiOptions {
+    pub timeout: Duration,
+    /// `--junit`: where to write JUnit XML
+    pub junit: Option<PathBuf>,
+    /// `--report`: where to write the JSON report
+    pub report: Option<PathBuf>,
+}
+
+/// A batch command that failed
+#[derive(Debug, Serialize)]
+pub struct Failure {
+    /// Script path or `-ex`; `None` for the trailing subcommand
+    pub source: Option<String>,
+    pub line: usize,
+    pub command: String,
+    pub error: String,
+}
+
+/// How often the program stopped at a breakpoint
+#[derive(Debug, Serialize)]
+pub struct BreakpointHits {
+    pub id: u32,
+    pub location: Option<String>,
+    pub hits: usize,
+}
+
+/// What a CI run found
+#[derive(Debug, Serialize)]
+pub struct Report {
+    pub passed: bool,
+    /// The process exit status
+    pub status: i32,
+    pub timed_out: bool,
+    pub duration_secs: f64,
+    /// The program's exit code, if `await` saw it exit
+    pub exit_code: Option<i32>,
+    pub commands_run: usize,
+    pub failures: Vec<Failure>,
+    pub assertions: Vec<AssertionResult>,
+    pub breakpoints_hit: Vec<BreakpointHits>,
+    /// Each time the watchdog interrupted a hung program
+    pub watchdog: Vec<WatchdogFiring>,
+}
+
+/// Name breakpoint hits by location while the session is still there
+pub async fn breakpoint_hits(hits: BTreeMap<u32, usize>) -> Vec<BreakpointHits> {
+    let mut breakpoints: Vec<BreakpointInfo> = Vec::new();
+    if let Ok(mut client) = DaemonClient::connect().await {
+        if let Ok(result) = client.send_command(Command::BreakpointList).await {
+            breakpoints = serde_json::from_value(result["breakpoints"].clone()).unwrap_or_default();
+        }
+    }
+
+    hits.into_iter()
+        .map(|(id, hits)| BreakpointHits {
+            id,
+            location: breakpoints
+                .iter()
+                .find(|bp| bp.id == id)
+                .and_then(|bp| Some(format!("{}:{}", bp.source.as_ref()?, bp.line?))),
+            hits,
+        })
+        .collect()
+}
+
+/// Print the summary and write the requested report files
+pub fn finish(options: &CiOptions, report: &Report) -> Result<()> {
+    if output::is_json() {
+        output::emit("ci", report)?;
+    } else {
+        println!("{}", summary(report));
+    }
+
+    if let Some(path) = &options.report {
+        std::fs::write(path, serde_json::to_string_pretty(report)?)?;
+    }
+    if let Some(path) = &options.junit {
+        std::fs::write(path, junit(report))?;
+    }
+    Ok(())
+}
+
+/// One line saying how the run went
+pub fn summary(report: &Report) -> String {
+    let failed = report.assertions.iter().filter(|a| !a.passed).count();
+    let hits: usize = report.breakpoints_hit.iter().map(|bp| bp.hits).sum();
+
+    let mut parts = vec![format!("{} commands", report.commands_run)];
+    if !report.failures.is_empty() {
+        parts.push(format!("{} failed", report.failures.len()));
+    }
+    parts.push(match failed {
+        0 => format!("{} assertions", report.assertions.len()),
+        n => format!("{} assertions ({} failed)", report.assertions.len(), n),
+    });
+    parts.push(format!("{} breakpoint hits", hits));
+    if let Some(code) = report.exit_code {
+        parts.push(format!("exit code {}", code));
+    }
+    if !report.watchdog.is_empty() {
+        parts.push(match report.watchdog.len() {
+            1 => "watchdog fired once".to_string(),
+            n => format!("watchdog fired {} times", n),
+        });
+    }
+    if report.timed_out {
+        parts.push("timed out".to_string());
+    }
+
+    format!(
+        "CI {} in {:.1}s: {}",
+        if report.passed { "passed" } else { "FAILED" },
+        report.duration_secs,
+        parts.join(", ")
+    )
+}
+
+/// The report as JUnit XML: a test case per assertion, one for the
+/// commands and one for the program's exit status
+pub fn junit(report: &Report) -> String {
+    let mut cases = Vec::new();
+
+    for assertion in &report.assertions {
+        let mut case = format!(
+            "    <testcase classname=\"assert\" name=\"{}\"",
+            escape(&assertion.expression)
+        );
+        match &assertion.failure {
+            Some(message) => case.push_str(&format!(
+                ">\n      <failure message=\"{}\"/>\n    </testcase>",
+                escape(message)
+            )),
+            None => case.push_str("/>"),
+        }
+        cases.push((case, assertion.failure.is_some()));
+    }
+
+    let mut errors: Vec<String> = report
+        .failures
+        .iter()
+        .map(|failure| match &failure.source {
+            Some(source) => format!("{}:{}: {}", source, failure.line, failure.error),
+            None => format!("{}: {}", failure.command, failure.error),
+        })
+        .collect();
+    if report.timed_out {
+        errors.push(format!("timed out after {:.0}s", report.duration_secs));
+    }
+    cases.push(match errors.first() {
+        None => ("    <testcase classname=\"batch\" name=\"commands\"/>".to_string(), false),
+        Some(first) => (
+            format!(
+                "    <testcase classname=\"batch\" name=\"commands\">\n      \
+                 <failure message=\"{}\">{}</failure>\n    </testcase>",
+                escape(first),
+                escape(&errors.join("\n"))
+            ),
+            true,
+        ),
+    });
+
+    let exit = "    <testcase classname=\"batch\" name=\"exit status\"";
+    cases.push(match report.exit_code {
+        Some(0) => (format!("{}/>", exit), false),
+        Some(code) => (
+            format!(
+                "{}>\n      <failure message=\"the program exited with code {}\"/>\n    \
+                 </testcase>",
+                exit, code
+            ),
+            true,
+        ),
+        None => (
+            format!(
+                "{}>\n      <skipped message=\"the program did not exit\"/>\n    </testcase>",
+                exit
+            ),
+            false,
+        ),
+    });
+
+    let failures = cases.iter().filter(|(_, failed)| *failed).count();
+    let properties: String = report
+        .breakpoints_hit
+        .iter()
+        .map(|bp| {
+            let name = match &bp.location {
+                Some(location) => format!("breakpoint {} ({})", bp.id, location),
+                None => format!("breakpoint {}", bp.id),
+            };
+            format!(
+                "      <property name=\"{}\" value=\"{}\"/>\n",
+                escape(&name),
+                bp.hits
+            )
+        })
+        .collect();
+
+    let mut xml = String::from("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n");
+    xml.push_str(&format!(
+        "<testsuite name=\"debugger\" tests=\"{}\" failures=\"{}\" time=\"{:.3}\">\n",
+        cases.len(),
+        failures,
+        report.duration_secs
+    ));
+    if !properties.is_empty() {
+        xml.push_str(&format!("  <properties>\n{}  </properties>\n", properties));
+    }
+    for (case, _) in &cases {
+        xml.push_str(case);
+        xml.push('\n');
+    }
+    xml.push_str("</testsuite>\n");
+    xml
+}
+
+fn escape(text: &str) -> String {
+    text.replace('&', "&amp;")
+        .replace('<', "&lt;")
+        .replace('>', "&gt;")
+        .replace('"', "&quot;")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn report() -> Report {
+        Report {
+            passed: false,
+            status: 1,
+            timed_out: false,
+            duration_secs: 1.5,
+            exit_code: Some(0),
+            commands_run: 4,
+            failures: Vec::new(),
+            assertions: vec![
+                AssertionResult {
+                    expression: "n > 0".to_string(),
+                    passed: true,
+                    value: Some("true".to_string()),
+                    failure: None,
+                },
+                AssertionResult {
+                    expression: "p->refs == 1".to_string(),
+                    passed: false,
+                    value: None,
+                    failure: Some("expected p->refs == 1, got p->refs = 2".to_string()),
+                },
+            ],
+            breakpoints_hit: vec![BreakpointHits {
+                id: 1,
+                location: Some("main.c:12".to_string()),
+                hits: 3,
+            }],
+            watchdog: Vec::new(),
+        }
+    }
+
+    #[test]
+    fn junit_has_a_case_per_assertion_and_escapes() {
+        let xml = junit(&report());
+        assert!(xml.contains("tests=\"4\" failures=\"1\""));
+        assert!(xml.contains("name=\"n &gt; 0\"/>"));
+        assert!(xml.contains("message=\"expected p-&gt;refs == 1, got p-&gt;refs = 2\"/>"));
+        assert!(xml.contains("<property name=\"breakpoint 1 (main.c:12)\" value=\"3\"/>"));
+        assert!(xml.contains("name=\"exit status\"/>"));
+    }
+
+    #[test]
+    fn summary_names_failures() {
+        assert_eq!(
+            summary(&report()),
+            "CI FAILED in 1.5s: 4 commands, 2 assertions (1 failed), 3 breakpoint hits, exit code 0"
+        );
+    }
+}
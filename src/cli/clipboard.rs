@@ -0,0 +1,122 @@
+//! Copying command output to the clipboard with `--copy`
+//!
+//! The output is captured as it is printed (see [`super::capture`]) and, on
+//! exit, goes uncolored to `$DEBUGGER_CLIPBOARD`, else the platform's
+//! clipboard tool, else an OSC 52 escape sequence that the terminal itself
+//! copies from (which is also used over SSH, where local tools would copy on
+//! the wrong machine).
+
+use std::io::Write;
+
+use crate::common::{Error, Result};
+
+/// Clipboard tools in order of preference, with the environment they need
+const TOOLS: &[(&str, &[&str], Option<&str>)] = &[
+    ("pbcopy", &[], None),
+    ("wl-copy", &[], Some("WAYLAND_DISPLAY")),
+    ("xclip", &["-selection", "clipboard"], Some("DISPLAY")),
+    ("xsel", &["--clipboard", "--input"], Some("DISPLAY")),
+    ("clip.exe", &[], None),
+];
+
+/// Copy captured output to the clipboard, warning if that fails
+pub fn copy_output(text: &str) {
+    let text = text.trim_end();
+    if text.is_empty() {
+        return;
+    }
+
+    if let Err(e) = copy(text) {
+        eprintln!("Warning: {}", e);
+    }
+}
+
+/// Put text on the clipboard
+fn copy(text: &str) -> Result<()> {
+    if let Ok(command) = std::env::var("DEBUGGER_CLIPBOARD") {
+        return pipe_to("sh", &["-c", &command], text);
+    }
+
+    let remote =
+        std::env::var_os("SSH_TTY").is_some() || std::env::var_os("SSH_CONNECTION").is_some();
+    if !remote {
+        for (tool, args, needs) in TOOLS {
+            let usable = needs.map_or(true, |var| std::env::var_os(var).is_some());
+            if usable && which::which(tool).is_ok() {
+                return pipe_to(tool, args, text);
+            }
+        }
+    }
+
+    osc52(text)
+}
+
+fn pipe_to(program: &str, args: &[&str], text: &str) -> Result<()> {
+    let mut child = std::process::Command::new(program)
+        .args(args)
+        .stdin(std::process::Stdio::piped())
+        .stdout(std::process::Stdio::null())
+        .spawn()
+        .map_err(|e| Error::Internal(format!("cannot run {}: {}", program, e)))?;
+
+    if let Some(mut stdin) = child.stdin.take() {
+        stdin.write_all(text.as_bytes())?;
+    }
+    let status = child.wait()?;
+    if status.success() {
+        Ok(())
+    } else {
+        Err(Error::Internal(format!(
+            "{} exited with {}",
+            program, status
+        )))
+    }
+}
+
+/// Ask the terminal to copy via the OSC 52 escape sequence
+fn osc52(text: &str) -> Result<()> {
+    let mut tty = std::fs::OpenOptions::new()
+        .write(true)
+        .open("/dev/tty")
+        .map_err(|_| {
+            Error::Internal("no clipboard tool found and no terminal for OSC 52".to_string())
+        })?;
+    write!(tty, "\x1b]52;c;{}\x07", base64(text.as_bytes()))?;
+    Ok(())
+}
+
+fn base64(data: &[u8]) -> String {
+    const ALPHABET: &[u8; 64] = b"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/";
+
+    let mut encoded = String::with_capacity(data.len().div_ceil(3) * 4);
+    for chunk in data.chunks(3) {
+        let bytes = [
+            chunk[0],
+            *chunk.get(1).unwrap_or(&0),
+            *chunk.get(2).unwrap_or(&0),
+        ];
+        let n = u32::from_be_bytes([0, bytes[0], bytes[1], bytes[2]]);
+        for i in 0..4 {
+            if i <= chunk.len() {
+                encoded.push(ALPHABET[(n >> (18 - 6 * i) & 63) as usize] as char);
+            } else {
+                encoded.push('=');
+            }
+        }
+    }
+    encoded
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn base64_pads() {
+        assert_eq!(base64(b""), "");
+        assert_eq!(base64(b"f"), "Zg==");
+        assert_eq!(base64(b"fo"), "Zm8=");
+        assert_eq!(base64(b"foo"), "Zm9v");
+        assert_eq!(base64(b"#0 main"), "IzAgbWFpbg==");
+    }
+}
@@ -0,0 +1,155 @@
+//! Joining a running session from another terminal
+//!
+//! `connect` reads commands line by line and runs each against the session's
+//! daemon like a separate invocation would, while printing the state changes
+//! other clients cause (an editor's `continue`, a stop someone else's
+//! `await` is waiting for) as they happen. Any number of terminals, `serve`
+//! and `serve-mcp` clients can share one session this way.
+
+use std::io::{IsTerminal, Write};
+
+use tokio::io::{AsyncBufReadExt, BufReader};
+
+use crate::commands::{Commands, SessionCommands};
+use crate::common::{Error, Result};
+use crate::ipc::DaemonClient;
+
+use super::follow::{self, Follower, POLL_INTERVAL};
+use super::script;
+use super::user::Collector;
+use super::theme::{self, Element};
+
+/// Commands that would fight the connection for stdin, or move the
+/// commands typed here to a session other than the one connected to
+fn runnable(command: &Commands) -> bool {
+    !matches!(
+        command,
+        Commands::Connect { .. }
+            | Commands::ServeMcp { .. }
+            | Commands::Session(SessionCommands::New { .. } | SessionCommands::Switch { .. })
+    )
+}
+
+/// Commands that print the stop they waited for themselves
+fn reports_stop(command: &Commands) -> bool {
+    matches!(
+        command,
+        Commands::Await { .. } | Commands::WatchChange { .. } | Commands::BreakWhen { .. }
+    )
+}
+
+/// Run commands from stdin against `session` until `quit` or end of input
+pub async fn run(session: &str) -> Result<()> {
+    let mut client = DaemonClient::connect().await.map_err(|e| match e {
+        Error::DaemonNotRunning => Error::SessionDaemonNotRunning(session.to_string()),
+        e => e,
+    })?;
+
+    let interactive = std::io::stdin().is_terminal();
+    let mut follower = Follower::default();
+    if let Some(change) = follower.poll(&mut client).await? {
+        println!("{}", follow::describe(&change));
+    }
+    if interactive {
+        println!(
+            "Connected to session '{}'. Type commands as on the command line; \
+             'quit' disconnects and leaves the session running.",
+            session
+        );
+    }
+
+    let mut lines = BufReader::new(tokio::io::stdin()).lines();
+    loop {
+        prompt(session, interactive);
+        let line = loop {
+            tokio::select! {
+                line = lines.next_line() => break line?,
+                _ = tokio::time::sleep(POLL_INTERVAL) => {
+                    let Ok(change) = follower.poll(&mut client).await else {
+                        println!();
+                        println!("The daemon for session '{}' exited", session);
+                        return Ok(());
+                    };
+                    if let Some(change) = change {
+                        if interactive {
+                            println!();
+                        }
+                        println!("{}", follow::describe(&change));
+                        prompt(session, interactive);
+                    }
+                }
+            }
+        };
+
+        let Some(line) = line else {
+            if interactive {
+                println!();
+            }
+            return Ok(());
+        };
+        let line = line.trim();
+        if line.is_empty() || line.starts_with('#') {
+            continue;
+        }
+        if matches!(line, "quit" | "exit") {
+            return Ok(());
+        }
+
+        let mut command = match script::parse_line(line) {
+            Ok(command) if runnable(&command) => command,
+            Ok(command) => {
+                report(&format!(
+                    "'{}' cannot be run from 'connect'",
+                    command.name()
+                ));
+                continue;
+            }
+            Err(message) => {
+                report(&message);
+                continue;
+            }
+        };
+
+        if let Commands::Define { body: body @ None, .. } = &mut command {
+            let mut collector = Collector::default();
+            loop {
+                if interactive {
+                    print!("> ");
+                    let _ = std::io::stdout().flush();
+                }
+                let Some(line) = lines.next_line().await? else {
+                    report("'define' without a matching 'end'");
+                    return Ok(());
+                };
+                if collector.push(&line) {
+                    break;
+                }
+            }
+            *body = Some(collector.into_lines());
+        }
+
+        let printed_stop = reports_stop(&command);
+        if let Err(e) = Box::pin(super::dispatch(command)).await {
+            report(&e.to_string());
+        }
+        if printed_stop {
+            // Already on screen; do not report it a second time
+            follower.poll(&mut client).await?;
+        }
+    }
+}
+
+fn prompt(session: &str, interactive: bool) {
+    if interactive {
+        print!("({}) ", session);
+        let _ = std::io::stdout().flush();
+    }
+}
+
+fn report(message: &str) {
+    eprintln!(
+        "{} {}",
+        theme::paint_stderr(Element::Error, "Error:"),
+        message
+    );
+}
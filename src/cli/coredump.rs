@@ -0,0 +1,450 @@
+//! Comparing two core dumps for `core diff`
+//!
+//! Each dump is opened in turn as a session of its own and read the way
+//! `report generate` reads a stopped program: every thread's backtrace, the
+//! stopped frame's globals scope, the `--eval` expressions and, for a Go
+//! program, a heap snapshot. Threads are paired in the order the adapter
+//! lists them and compared from their outermost frame in, so a stack shows
+//! where the two runs went separate ways. Addresses change from run to run,
+//! so values are compared with any but small hex numbers masked, and a
+//! pointer only differs by being null in one dump.
+
+use std::path::Path;
+
+use serde::Serialize;
+use serde_json::Value;
+
+use crate::common::{Error, Result};
+use crate::ipc::protocol::{Command, EvaluateContext, HeapChange, StackFrameInfo, ThreadInfo};
+use crate::ipc::DaemonClient;
+
+/// Frames compared per thread
+const MAX_FRAMES: usize = 256;
+
+/// Hex numbers from here up are taken for addresses
+const MIN_ADDRESS: u64 = 0x10000;
+
+/// What a dump shows
+#[derive(Debug, Default)]
+struct Dump {
+    reason: Option<String>,
+    /// Each thread's name and frames, innermost first
+    threads: Vec<(String, Vec<String>)>,
+    globals: Vec<(String, String)>,
+    expressions: Vec<(String, String)>,
+    /// The heap snapshot taken of it
+    heap: Option<u32>,
+}
+
+/// A thread whose stacks differ, or that only one dump has
+#[derive(Debug, PartialEq, Serialize)]
+pub struct ThreadDiff {
+    /// Its place in the adapter's list of threads, from 1
+    pub thread: usize,
+    pub names: [Option<String>; 2],
+    /// How many outermost frames both stacks share
+    pub common: usize,
+    /// Each dump's frames past the shared ones, innermost first
+    pub frames: [Vec<String>; 2],
+}
+
+/// A global or expression with a different value in each dump
+#[derive(Debug, PartialEq, Serialize)]
+pub struct ValueDiff {
+    pub name: String,
+    pub values: [Option<String>; 2],
+}
+
+/// Where the two dumps differ
+#[derive(Debug, Serialize)]
+pub struct Report {
+    pub program: String,
+    pub cores: [String; 2],
+    /// Why each program stopped
+    pub reasons: [Option<String>; 2],
+    pub threads: [usize; 2],
+    pub stacks: Vec<ThreadDiff>,
+    /// Globals both dumps have
+    pub globals_compared: usize,
+    pub globals: Vec<ValueDiff>,
+    pub expressions: Vec<ValueDiff>,
+    /// The heap counters that changed, when both are Go programs
+    pub heap: Option<Vec<HeapChange>>,
+}
+
+/// Read both dumps, one session at a time, and compare them
+pub async fn diff(
+    client: &mut DaemonClient,
+    program: &Path,
+    cores: [&Path; 2],
+    adapter: Option<String>,
+    expressions: &[String],
+) -> Result<Report> {
+    let status = client.send_command(Command::Status).await?;
+    if status["session_active"].as_bool() == Some(true) {
+        return Err(Error::SessionAlreadyActive);
+    }
+
+    let first = read(client, program, cores[0], adapter.clone(), expressions).await?;
+    let second = read(client, program, cores[1], adapter, expressions).await?;
+    let heap = match (first.heap, second.heap) {
+        (Some(from), Some(to)) => {
+            let result = client
+                .send_command(Command::HeapDiff {
+                    from: Some(from),
+                    to: Some(to),
+                })
+                .await?;
+            Some(serde_json::from_value(result["changes"].clone())?)
+        }
+        _ => None,
+    };
+
+    let stacks = compare_stacks(&first.threads, &second.threads);
+    let (globals_compared, globals) = compare_values(&first.globals, &second.globals);
+    let (_, expressions) = compare_values(&first.expressions, &second.expressions);
+    Ok(Report {
+        program: program.display().to_string(),
+        cores: cores.map(|core| core.display().to_string()),
+        reasons: [first.reason, second.reason],
+        threads: [first.threads.len(), second.threads.len()],
+        stacks,
+        globals_compared,
+        globals,
+        expressions,
+        heap,
+    })
+}
+
+/// Open a dump, read it and close it again
+async fn read(
+    client: &mut DaemonClient,
+    program: &Path,
+    core: &Path,
+    adapter: Option<String>,
+    expressions: &[String],
+) -> Result<Dump> {
+    client
+        .send_command(Command::CoreOpen {
+            program: program.to_path_buf(),
+            core: core.to_path_buf(),
+            adapter,
+            slide: None,
+        })
+        .await?;
+    let dump = collect(client, core, expressions).await;
+    let stopped = client.send_command(Command::Stop).await;
+    let dump = dump?;
+    stopped?;
+    Ok(dump)
+}
+
+async fn collect(client: &mut DaemonClient, core: &Path, expressions: &[String]) -> Result<Dump> {
+    let stop = client.send_command(Command::Await { timeout_secs: 10 }).await?;
+    let mut dump = Dump {
+        reason: stop["description"].as_str().or(stop["reason"].as_str()).map(String::from),
+        ..Dump::default()
+    };
+
+    let result = client.send_command(Command::Threads).await?;
+    let threads: Vec<ThreadInfo> = serde_json::from_value(result["threads"].clone())?;
+    if threads.is_empty() {
+        return Err(Error::Core(format!("the adapter found no threads in {}", core.display())));
+    }
+    let mut top = None;
+    for thread in &threads {
+        let result = client
+            .send_command(Command::StackTrace {
+                thread_id: Some(thread.id),
+                limit: MAX_FRAMES,
+                start: 0,
+                more: false,
+            })
+            .await?;
+        let frames: Vec<StackFrameInfo> = serde_json::from_value(result["frames"].clone())?;
+        if top.is_none() || stop["thread_id"].as_i64() == Some(thread.id) {
+            top = frames.first().map(|frame| frame.id);
+        }
+        dump.threads.push((thread.name.clone(), frames.iter().map(label).collect()));
+    }
+
+    if let Some(frame_id) = top {
+        let result = client.send_command(Command::Scopes { frame_id }).await?;
+        let globals = result["scopes"].as_array().into_iter().flatten().find(|scope| {
+            scope["name"].as_str().is_some_and(|name| name.to_lowercase().contains("global"))
+        });
+        if let Some(reference) = globals.and_then(|scope| scope["variablesReference"].as_i64()) {
+            let result = client.send_command(Command::Variables { reference }).await?;
+            dump.globals = named_values(&result["variables"]);
+        }
+    }
+
+    for expression in expressions {
+        let result = client
+            .send_command(Command::Evaluate {
+                expression: expression.clone(),
+                frame_id: None,
+                context: EvaluateContext::Watch,
+                limit: None,
+            })
+            .await;
+        let value = match result {
+            Ok(value) => value["result"].as_str().unwrap_or_default().to_string(),
+            Err(e) => format!("<error: {}>", e),
+        };
+        dump.expressions.push((expression.clone(), value));
+    }
+
+    // Only Go programs have heap statistics to read
+    if let Ok(snapshot) = client.send_command(Command::HeapSnapshot).await {
+        dump.heap = snapshot["snapshot"].as_u64().map(|number| number as u32);
+    }
+    Ok(dump)
+}
+
+/// `parse_header (parse.c:88)`
+fn label(frame: &StackFrameInfo) -> String {
+    let file = frame.source.as_deref().map(|path| path.rsplit('/').next().unwrap_or(path));
+    match (file, frame.line) {
+        (Some(file), Some(line)) => format!("{} ({}:{})", frame.name, file, line),
+        _ => frame.name.clone(),
+    }
+}
+
+fn named_values(variables: &Value) -> Vec<(String, String)> {
+    variables
+        .as_array()
+        .into_iter()
+        .flatten()
+        .filter_map(|variable| {
+            let name = variable["name"].as_str()?;
+            Some((name.to_string(), variable["value"].as_str()?.to_string()))
+        })
+        .collect()
+}
+
+/// The threads whose stacks differ, paired by their place in the list
+fn compare_stacks(
+    first: &[(String, Vec<String>)],
+    second: &[(String, Vec<String>)],
+) -> Vec<ThreadDiff> {
+    let mut diffs = Vec::new();
+    for index in 0..first.len().max(second.len()) {
+        let (a, b) = (first.get(index), second.get(index));
+        let frames = |thread: Option<&(String, Vec<String>)>| -> Vec<String> {
+            let frames = thread.map(|(_, frames)| frames.as_slice()).unwrap_or_default();
+            frames.iter().map(|frame| masked(frame)).collect()
+        };
+        let (mut one, mut two) = (frames(a), frames(b));
+        let common = one.iter().rev().zip(two.iter().rev()).take_while(|(x, y)| x == y).count();
+        if a.is_some() && b.is_some() && common == one.len() && common == two.len() {
+            continue;
+        }
+        one.truncate(one.len() - common);
+        two.truncate(two.len() - common);
+        diffs.push(ThreadDiff {
+            thread: index + 1,
+            names: [a.map(|(name, _)| name.clone()), b.map(|(name, _)| name.clone())],
+            common,
+            frames: [one, two],
+        });
+    }
+    diffs
+}
+
+/// How many names both have, and those whose values differ or that only
+/// one has, in the first's order
+fn compare_values(
+    first: &[(String, String)],
+    second: &[(String, String)],
+) -> (usize, Vec<ValueDiff>) {
+    let find = |values: &[(String, String)], name: &str| {
+        values.iter().find(|(other, _)| other == name).map(|(_, value)| value.clone())
+    };
+    let mut both = 0;
+    let mut diffs = Vec::new();
+    for (name, value) in first {
+        match find(second, name) {
+            Some(other) => {
+                both += 1;
+                if masked(value) != masked(&other) {
+                    diffs.push(ValueDiff {
+                        name: name.clone(),
+                        values: [Some(value.clone()), Some(other)],
+                    });
+                }
+            }
+            None => diffs.push(ValueDiff {
+                name: name.clone(),
+                values: [Some(value.clone()), None],
+            }),
+        }
+    }
+    for (name, value) in second {
+        if find(first, name).is_none() {
+            diffs.push(ValueDiff {
+                name: name.clone(),
+                values: [None, Some(value.clone())],
+            });
+        }
+    }
+    (both, diffs)
+}
+
+/// A value with the addresses in it masked, so that two runs' pointers
+/// only differ when one is null
+fn masked(value: &str) -> String {
+    let mut text = String::with_capacity(value.len());
+    let mut rest = value;
+    while let Some(at) = rest.find("0x") {
+        text.push_str(&rest[..at]);
+        let digits = &rest[at + 2..];
+        let end = digits.find(|c: char| !c.is_ascii_hexdigit()).unwrap_or(digits.len());
+        match u64::from_str_radix(&digits[..end], 16) {
+            Ok(number) if number >= MIN_ADDRESS => text.push_str("0x…"),
+            _ => text.push_str(&rest[at..at + 2 + end]),
+        }
+        rest = &digits[end..];
+    }
+    text.push_str(rest);
+    text
+}
+
+/// The differences as a few sections, with what is the same summed up
+pub fn text(report: &Report) -> String {
+    let [first, second] = &report.cores;
+    let mut text = format!("Comparing {} and {} of {}\n", first, second, report.program);
+    for (core, reason) in report.cores.iter().zip(&report.reasons) {
+        if let Some(reason) = reason {
+            text.push_str(&format!("  {} stopped: {}\n", core, reason));
+        }
+    }
+
+    let paired = report.threads[0].min(report.threads[1]);
+    let same = paired - report.stacks.iter().filter(|diff| diff.thread <= paired).count();
+    text.push_str(&format!(
+        "\nThreads: {} and {}; {} of {} with the same stack\n",
+        report.threads[0], report.threads[1], same, paired
+    ));
+    for diff in &report.stacks {
+        let name = match &diff.names {
+            [Some(a), Some(b)] if a == b => a.clone(),
+            [a, b] => format!("{} / {}", a.as_deref().unwrap_or("-"), b.as_deref().unwrap_or("-")),
+        };
+        let only = match &diff.names {
+            [Some(_), None] => Some(first),
+            [None, Some(_)] => Some(second),
+            _ => None,
+        };
+        if let Some(core) = only {
+            text.push_str(&format!("  Thread {} ({}): only in {}\n", diff.thread, name, core));
+            continue;
+        }
+        let frames = if diff.common == 1 { "frame" } else { "frames" };
+        text.push_str(&format!(
+            "  Thread {} ({}): {} outer {} in common, then\n",
+            diff.thread, name, diff.common, frames
+        ));
+        for (core, frames) in report.cores.iter().zip(&diff.frames) {
+            let frames = if frames.is_empty() { "-".to_string() } else { frames.join(" <- ") };
+            text.push_str(&format!("    {}: {}\n", core, frames));
+        }
+    }
+
+    text.push_str(&format!(
+        "\nGlobals: {} in both, {} differ\n",
+        report.globals_compared,
+        report.globals.len()
+    ));
+    values(&mut text, &report.globals);
+    if !report.expressions.is_empty() {
+        text.push_str("\nExpressions:\n");
+        values(&mut text, &report.expressions);
+    }
+
+    match &report.heap {
+        Some(changes) => {
+            text.push_str(&format!("\nHeap: {} counters changed\n", changes.len()));
+            for change in changes.iter().take(10) {
+                text.push_str(&format!(
+                    "{:>+14}  {}  ({} -> {})\n",
+                    change.change, change.counter, change.before, change.after
+                ));
+            }
+        }
+        None => text.push_str("\nHeap: no statistics; these are read for Go programs\n"),
+    }
+    text
+}
+
+fn values(text: &mut String, diffs: &[ValueDiff]) {
+    for diff in diffs {
+        let [a, b] = &diff.values;
+        text.push_str(&format!(
+            "  {}: {} -> {}\n",
+            diff.name,
+            a.as_deref().unwrap_or("(none)"),
+            b.as_deref().unwrap_or("(none)")
+        ));
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn thread(name: &str, frames: &[&str]) -> (String, Vec<String>) {
+        (name.to_string(), frames.iter().map(|frame| frame.to_string()).collect())
+    }
+
+    fn values(pairs: &[(&str, &str)]) -> Vec<(String, String)> {
+        pairs.iter().map(|(name, value)| (name.to_string(), value.to_string())).collect()
+    }
+
+    #[test]
+    fn dumps_differ_past_their_shared_frames_and_by_null_pointers() {
+        let first = [
+            thread("main", &["parse (p.c:8)", "read (n.c:3)", "main (m.c:9)"]),
+            thread("worker", &["wait (s.c:1)", "start"]),
+        ];
+        let second = [
+            thread("main", &["read (n.c:4)", "main (m.c:9)"]),
+            thread("worker", &["wait (s.c:1)", "start"]),
+            thread("reaper", &["sleep"]),
+        ];
+        let stacks = compare_stacks(&first, &second);
+        assert_eq!(
+            stacks,
+            [
+                ThreadDiff {
+                    thread: 1,
+                    names: [Some("main".to_string()), Some("main".to_string())],
+                    common: 1,
+                    frames: [
+                        vec!["parse (p.c:8)".to_string(), "read (n.c:3)".to_string()],
+                        vec!["read (n.c:4)".to_string()],
+                    ],
+                },
+                ThreadDiff {
+                    thread: 3,
+                    names: [None, Some("reaper".to_string())],
+                    common: 0,
+                    frames: [vec![], vec!["sleep".to_string()]],
+                },
+            ]
+        );
+
+        assert_eq!(masked("(Config *) 0x00005555deadbeef"), "(Config *) 0x…");
+        assert_eq!(masked("0x0000000000000000"), "0x0000000000000000");
+        assert_eq!(masked("flags 0x10"), "flags 0x10");
+
+        let (both, diffs) = compare_values(
+            &values(&[("config", "0x7ffd1000"), ("retries", "3"), ("head", "0x0"), ("a", "1")]),
+            &values(&[("config", "0x7ffd2000"), ("retries", "5"), ("head", "0x5555a000")]),
+        );
+        assert_eq!(both, 3);
+        let names: Vec<&str> = diffs.iter().map(|diff| diff.name.as_str()).collect();
+        assert_eq!(names, ["retries", "head", "a"]);
+        assert_eq!(diffs[2].values, [Some("1".to_string()), None]);
+    }
+}
@@ -0,0 +1,100 @@
+//! Text and LCOV reports for `coverage report`
+
+use crate::ipc::protocol::FileCoverage;
+
+/// One line per file with how much of it ran and the lines that did not,
+/// then the total
+pub fn text(files: &[FileCoverage]) -> String {
+    let mut text = String::new();
+    let (mut lines, mut covered) = (0, 0);
+    for file in files {
+        lines += file.lines.len();
+        covered += file.covered.len();
+        let missing: Vec<u32> =
+            file.lines.iter().copied().filter(|line| !file.covered.contains(line)).collect();
+        text.push_str(&format!(
+            "{:>6}/{:<6} {:>5.1}%  {}",
+            file.covered.len(),
+            file.lines.len(),
+            percent(file.covered.len(), file.lines.len()),
+            file.path
+        ));
+        if !missing.is_empty() {
+            text.push_str(&format!("  not run: {}", ranges(&file.lines, &missing)));
+        }
+        text.push('\n');
+    }
+    text.push_str(&format!(
+        "{:>6}/{:<6} {:>5.1}%  total\n",
+        covered,
+        lines,
+        percent(covered, lines)
+    ));
+    text
+}
+
+/// An LCOV tracefile: every line of code with a count of 1 if it ran
+pub fn lcov(files: &[FileCoverage]) -> String {
+    let mut text = String::from("TN:\n");
+    for file in files {
+        text.push_str(&format!("SF:{}\n", file.path));
+        for line in &file.lines {
+            let hits = u32::from(file.covered.contains(line));
+            text.push_str(&format!("DA:{},{}\n", line, hits));
+        }
+        text.push_str(&format!("LF:{}\nLH:{}\n", file.lines.len(), file.covered.len()));
+        text.push_str("end_of_record\n");
+    }
+    text
+}
+
+fn percent(part: usize, whole: usize) -> f64 {
+    if whole == 0 {
+        0.0
+    } else {
+        part as f64 * 100.0 / whole as f64
+    }
+}
+
+/// `missing` as runs like `14-16, 30`, a run going on while the lines of
+/// code in between are missing too
+fn ranges(lines: &[u32], missing: &[u32]) -> String {
+    let mut runs: Vec<(u32, u32)> = Vec::new();
+    let mut previous = None;
+    for line in lines {
+        let is_missing = missing.contains(line);
+        match (runs.last_mut(), is_missing, previous) {
+            (Some(run), true, Some(true)) => run.1 = *line,
+            (_, true, _) => runs.push((*line, *line)),
+            _ => {}
+        }
+        previous = Some(is_missing);
+    }
+    runs.iter()
+        .map(|(from, to)| if from == to { from.to_string() } else { format!("{}-{}", from, to) })
+        .collect::<Vec<_>>()
+        .join(", ")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn reports_show_the_lines_that_did_not_run() {
+        let files = [FileCoverage {
+            path: "/src/t.c".to_string(),
+            lines: vec![3, 4, 6, 9, 12],
+            covered: vec![3, 12],
+        }];
+        assert_eq!(
+            text(&files),
+            "     2/5       40.0%  /src/t.c  not run: 4-9\n     2/5       40.0%  total\n"
+        );
+        assert_eq!(
+            lcov(&files),
+            "TN:\nSF:/src/t.c\nDA:3,1\nDA:4,0\nDA:6,0\nDA:9,0\nDA:12,1\n\
+             LF:5\nLH:2\nend_of_record\n"
+        );
+    }
+}
@@ -0,0 +1,165 @@
+//! Printing `dwarf` query results
+//!
+//! Every entry is printed with its `.debug_info` offset, `<0x2d>`, which
+//! `dwarf die` takes to show the entry's attributes and children.
+
+use serde_json::Value;
+
+use crate::common::Result;
+use crate::ipc::protocol::DwarfQuery;
+use crate::symbols::dwarf::{Die, FunctionEntry, InlinedEntry, LineEntry, TypeEntry};
+
+use super::theme::{self, Element};
+
+/// Print a query's result, as returned by the daemon
+pub fn print(query: &DwarfQuery, result: &Value) -> Result<()> {
+    let total = result["total"].as_u64().unwrap_or(0) as usize;
+    let shown = match query {
+        DwarfQuery::Types { filter, .. } => {
+            let types: Vec<TypeEntry> = serde_json::from_value(result["types"].clone())?;
+            if types.is_empty() {
+                println!("{}", nothing("types", filter.as_deref()));
+            }
+            for entry in &types {
+                let size = entry.size.map(|size| match size {
+                    1 => " (1 byte)".to_string(),
+                    size => format!(" ({} bytes)", size),
+                });
+                println!(
+                    "  {}  {} {}{}{}",
+                    offset(entry.offset),
+                    entry.kind,
+                    theme::paint(Element::TypeName, &entry.name),
+                    size.unwrap_or_default(),
+                    location(entry.file.as_deref(), entry.line)
+                );
+            }
+            types.len()
+        }
+        DwarfQuery::Functions { filter, .. } => {
+            let functions: Vec<FunctionEntry> =
+                serde_json::from_value(result["functions"].clone())?;
+            if functions.is_empty() {
+                println!("{}", nothing("functions", filter.as_deref()));
+            }
+            for entry in &functions {
+                println!(
+                    "  {}  {}  {:#x}-{:#x}{}",
+                    offset(entry.offset),
+                    theme::paint(Element::VariableName, &entry.name),
+                    entry.low_pc,
+                    entry.high_pc,
+                    location(entry.file.as_deref(), entry.line)
+                );
+            }
+            functions.len()
+        }
+        DwarfQuery::Lines { file, .. } => {
+            let lines: Vec<LineEntry> = serde_json::from_value(result["lines"].clone())?;
+            if lines.is_empty() {
+                match file {
+                    Some(file) => println!("No line table rows for files matching '{}'", file),
+                    None => println!("No line table rows"),
+                }
+            }
+            for row in &lines {
+                let mut place = row.file.clone();
+                match (row.line, row.column) {
+                    (Some(line), Some(column)) => place.push_str(&format!(":{}:{}", line, column)),
+                    (Some(line), None) => place.push_str(&format!(":{}", line)),
+                    (None, _) => place.push_str(" (no line)"),
+                }
+                let stmt = if row.is_stmt { "" } else { "  (not a statement)" };
+                println!("  {:#x}  {}{}", row.address, place, stmt);
+            }
+            lines.len()
+        }
+        DwarfQuery::Inlined { filter, .. } => {
+            let inlined: Vec<InlinedEntry> = serde_json::from_value(result["inlined"].clone())?;
+            if inlined.is_empty() {
+                println!("{}", nothing("inlined calls", filter.as_deref()));
+            }
+            for entry in &inlined {
+                let ranges: Vec<String> = entry
+                    .ranges
+                    .iter()
+                    .map(|(low, high)| format!("{:#x}-{:#x}", low, high))
+                    .collect();
+                let caller = entry
+                    .caller
+                    .as_ref()
+                    .map(|caller| format!(" into {}", caller))
+                    .unwrap_or_default();
+                let called = match (&entry.call_file, entry.call_line) {
+                    (Some(file), Some(line)) => format!("  called at {}:{}", file, line),
+                    (Some(file), None) => format!("  called in {}", file),
+                    _ => String::new(),
+                };
+                println!(
+                    "  {}  {}{}  {}{}",
+                    offset(entry.offset),
+                    theme::paint(Element::VariableName, &entry.name),
+                    caller,
+                    ranges.join(", "),
+                    called
+                );
+            }
+            inlined.len()
+        }
+        DwarfQuery::Die { .. } => {
+            let die: Die = serde_json::from_value(result["die"].clone())?;
+            println!("{} {}", offset(die.offset), die.tag);
+            let width = die.attributes.iter().map(|a| a.name.len()).max().unwrap_or(0);
+            for attribute in &die.attributes {
+                println!("  {:width$}  {}", attribute.name, attribute.value, width = width);
+            }
+            if !die.children.is_empty() {
+                println!("Children:");
+                for child in &die.children {
+                    match &child.name {
+                        Some(name) => println!("  {} {} {}", offset(child.offset), child.tag, name),
+                        None => println!("  {} {}", offset(child.offset), child.tag),
+                    }
+                }
+            }
+            return Ok(());
+        }
+    };
+
+    if shown < total {
+        println!("Showing {} of {}; use --limit for more", shown, total);
+    }
+    Ok(())
+}
+
+fn offset(offset: u64) -> String {
+    theme::paint(Element::LineNumber, &format!("<{:#x}>", offset))
+}
+
+/// `  file:line`, or nothing when the debug info does not say
+fn location(file: Option<&str>, line: Option<u32>) -> String {
+    match (file, line) {
+        (Some(file), Some(line)) => format!("  {}:{}", file, line),
+        (Some(file), None) => format!("  {}", file),
+        _ => String::new(),
+    }
+}
+
+fn nothing(what: &str, filter: Option<&str>) -> String {
+    match filter {
+        Some(filter) => format!("No {} matching '{}'", what, filter),
+        None => format!("No {}", what),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn locations_are_left_out_when_unknown() {
+        assert_eq!(location(Some("main.c"), Some(12)), "  main.c:12");
+        assert_eq!(location(Some("main.c"), None), "  main.c");
+        assert_eq!(location(None, Some(12)), "");
+    }
+}
@@ -0,0 +1,94 @@
+//! Opening source locations in an external editor
+//!
+//! `edit` runs `$VISUAL`, then `$EDITOR`, then `vi`, through the shell the
+//! way git does, so editor settings like `code --wait` work. Editors differ
+//! in how they take a line number; the common ones are recognized by name.
+
+use std::path::Path;
+
+use crate::common::{Error, Result};
+
+/// The editor command line from the environment
+fn editor_command() -> String {
+    std::env::var("VISUAL")
+        .or_else(|_| std::env::var("EDITOR"))
+        .ok()
+        .map(|editor| editor.trim().to_string())
+        .filter(|editor| !editor.is_empty())
+        .unwrap_or_else(|| "vi".to_string())
+}
+
+/// Arguments that open `file` at `line` in `editor`
+///
+/// Most terminal editors take `+LINE FILE`; VS Code and its forks want
+/// `--goto FILE:LINE`, and a few GUI editors accept `FILE:LINE` directly.
+fn location_args(editor: &str, file: &str, line: u32) -> Vec<String> {
+    let program = editor.split_whitespace().next().unwrap_or(editor);
+    let program = Path::new(program)
+        .file_stem()
+        .map(|name| name.to_string_lossy().into_owned())
+        .unwrap_or_default();
+
+    match program.as_str() {
+        "code" | "code-insiders" | "codium" | "cursor" => {
+            vec!["--goto".to_string(), format!("{}:{}", file, line)]
+        }
+        "subl" | "zed" | "hx" | "helix" | "idea" | "clion" | "goland" => {
+            vec![format!("{}:{}", file, line)]
+        }
+        _ => vec![format!("+{}", line), file.to_string()],
+    }
+}
+
+/// Open `file` at `line` and wait for the editor to exit
+pub fn open(file: &str, line: u32) -> Result<()> {
+    let editor = editor_command();
+    let args = location_args(&editor, file, line);
+
+    let status = shell_command(&editor, &args)
+        .status()
+        .map_err(|e| Error::Editor(format!("{}: {}", editor, e)))?;
+
+    if status.success() {
+        Ok(())
+    } else {
+        Err(Error::Editor(format!("{} exited with {}", editor, status)))
+    }
+}
+
+#[cfg(unix)]
+fn shell_command(editor: &str, args: &[String]) -> std::process::Command {
+    // `"$@"` passes the file through untouched while the editor setting
+    // itself may carry flags
+    let mut command = std::process::Command::new("sh");
+    command
+        .arg("-c")
+        .arg(format!("{} \"$@\"", editor))
+        .arg(editor)
+        .args(args);
+    command
+}
+
+#[cfg(not(unix))]
+fn shell_command(editor: &str, args: &[String]) -> std::process::Command {
+    let mut parts = editor.split_whitespace();
+    let mut command = std::process::Command::new(parts.next().unwrap_or(editor));
+    command.args(parts).args(args);
+    command
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn line_syntax_follows_the_editor() {
+        assert_eq!(location_args("vim", "a.c", 7), ["+7", "a.c"]);
+        assert_eq!(location_args("emacsclient -t", "a.c", 7), ["+7", "a.c"]);
+        assert_eq!(
+            location_args("/usr/bin/code --wait", "a.c", 7),
+            ["--goto", "a.c:7"]
+        );
+        assert_eq!(location_args("subl -w", "a.c", 7), ["a.c:7"]);
+    }
+}
@@ -0,0 +1,395 @@
+//! Running event handlers
+//!
+//! `on stop`, `on breakpoint NAME`, `on signal NAME` and `on exit` handlers
+//! are stored by the daemon and run by `await` once it reports the event,
+//! the same place `hook-post stop` runs. Debugger command handlers see the
+//! event through `${name}` variables; shell handlers get the same values in
+//! `DEBUGGER_*` environment variables and the whole event as JSON in
+//! `DEBUGGER_EVENT_JSON`.
+
+use serde::Serialize;
+use serde_json::Value;
+
+use crate::common::{Error, Result};
+use crate::ipc::protocol::{
+    BreakpointInfo, Command, EventHandlerInfo, EventKind, StackFrameInfo, StopResult,
+};
+use crate::ipc::DaemonClient;
+
+use super::{hooks, script};
+
+/// Filter that matches any breakpoint or signal
+pub const ANY: &str = "*";
+
+/// What handlers are told about an event
+#[derive(Debug, Default, Serialize)]
+pub struct EventPayload {
+    pub event: String,
+    pub reason: String,
+    pub thread: Option<i64>,
+    pub function: Option<String>,
+    pub source: Option<String>,
+    pub line: Option<u32>,
+    pub breakpoint: Option<u32>,
+    pub signal: Option<String>,
+    pub exit_code: Option<i64>,
+}
+
+impl EventPayload {
+    /// Variable names and values, for `${name}` and `DEBUGGER_NAME`
+    fn vars(&self) -> Vec<(&'static str, String)> {
+        let mut vars = vec![
+            ("event", self.event.clone()),
+            ("reason", self.reason.clone()),
+        ];
+        let optional = [
+            ("thread", self.thread.map(|t| t.to_string())),
+            ("function", self.function.clone()),
+            ("source", self.source.clone()),
+            ("line", self.line.map(|l| l.to_string())),
+            ("breakpoint", self.breakpoint.map(|b| b.to_string())),
+            ("signal", self.signal.clone()),
+            ("exit_code", self.exit_code.map(|c| c.to_string())),
+        ];
+        vars.extend(
+            optional
+                .into_iter()
+                .filter_map(|(name, value)| value.map(|value| (name, value))),
+        );
+        vars
+    }
+
+    fn lookup(&self, name: &str) -> Option<String> {
+        self.vars()
+            .into_iter()
+            .find(|(var, _)| *var == name)
+            .map(|(_, value)| value)
+    }
+}
+
+/// Fetch the event handlers stored by the daemon
+pub async fn list(client: &mut DaemonClient) -> Result<Vec<EventHandlerInfo>> {
+    let result = client.send_command(Command::EventHandlerList).await?;
+    Ok(serde_json::from_value(result["events"].clone())?)
+}
+
+/// Run the handlers for what an `await` result reports
+///
+/// Handler failures are reported as warnings: the event already happened,
+/// and later handlers should still see it.
+pub async fn fire(client: &mut DaemonClient, result: &Value) -> Result<()> {
+    if hooks::is_running() {
+        return Ok(());
+    }
+    if result
+        .get("already_stopped")
+        .and_then(|v| v.as_bool())
+        .unwrap_or(false)
+    {
+        return Ok(());
+    }
+
+    let handlers = list(client).await?;
+    if handlers.is_empty() {
+        return Ok(());
+    }
+
+    let reason = result["reason"].as_str().unwrap_or("unknown");
+    let mut payload = match reason {
+        "exited" | "terminated" => EventPayload {
+            event: EventKind::Exit.to_string(),
+            reason: reason.to_string(),
+            exit_code: result.get("exit_code").and_then(|v| v.as_i64()),
+            ..Default::default()
+        },
+        _ => stop_payload(client, serde_json::from_value(result.clone())?).await,
+    };
+
+    let breakpoints = if handlers.iter().any(|h| h.event == EventKind::Breakpoint) {
+        fetch_breakpoints(client).await
+    } else {
+        Vec::new()
+    };
+
+    for handler in &handlers {
+        if !matches(handler, &payload, &breakpoints) {
+            continue;
+        }
+        payload.event = handler.event.to_string();
+        if let Err(e) = run(handler, &payload).await {
+            eprintln!("Warning: {}", e);
+        }
+    }
+
+    Ok(())
+}
+
+/// Describe a stop, filling in the stopped frame
+async fn stop_payload(client: &mut DaemonClient, stop: StopResult) -> EventPayload {
+    let frame = client
+        .send_command(Command::StackTrace {
+            thread_id: stop.thread_id,
+            limit: 1,
+            start: 0,
+            more: false,
+        })
+        .await
+        .ok()
+        .and_then(|result| {
+            serde_json::from_value::<Vec<StackFrameInfo>>(result["frames"].clone()).ok()
+        })
+        .and_then(|frames| frames.into_iter().next());
+
+    let (function, frame_source, frame_line) = match frame {
+        Some(frame) => (Some(frame.name), frame.source, frame.line),
+        None => (None, None, None),
+    };
+
+    EventPayload {
+        event: EventKind::Stop.to_string(),
+        signal: signal_name(&stop.reason, stop.description.as_deref()),
+        reason: stop.reason,
+        thread: stop.thread_id,
+        function,
+        source: stop.source.or(frame_source),
+        line: stop.line.or(frame_line),
+        breakpoint: stop.hit_breakpoint_ids.first().copied(),
+        exit_code: None,
+    }
+}
+
+async fn fetch_breakpoints(client: &mut DaemonClient) -> Vec<BreakpointInfo> {
+    client
+        .send_command(Command::BreakpointList)
+        .await
+        .ok()
+        .and_then(|result| serde_json::from_value(result["breakpoints"].clone()).ok())
+        .unwrap_or_default()
+}
+
+/// The signal a stop was for, from adapters' descriptions such as
+/// "signal SIGSEGV" or "Signal: SIGABRT"
+fn signal_name(reason: &str, description: Option<&str>) -> Option<String> {
+    let description = description.unwrap_or("");
+    let named = description
+        .split(|c: char| !c.is_ascii_alphanumeric())
+        .find(|word| {
+            word.len() > 3
+                && word.starts_with("SIG")
+                && word.bytes().all(|b| b.is_ascii_uppercase())
+        })
+        .map(String::from);
+
+    match reason {
+        _ if named.is_some() => named,
+        "signal" | "signal-received" | "exception" if !description.is_empty() => {
+            Some(description.to_string())
+        }
+        "signal" | "signal-received" | "exception" => Some(reason.to_string()),
+        _ => None,
+    }
+}
+
+/// `SIGSEGV`, `sigsegv` and `SEGV` all name the same signal
+fn same_signal(filter: &str, signal: &str) -> bool {
+    let normalize = |name: &str| {
+        let upper = name.to_ascii_uppercase();
+        upper.strip_prefix("SIG").map(String::from).unwrap_or(upper)
+    };
+    normalize(filter) == normalize(signal)
+}
+
+/// Whether a handler applies to the event
+///
+/// A breakpoint filter matches the hit breakpoint's ID, its `file:line`, or
+/// the function the program stopped in.
+fn matches(
+    handler: &EventHandlerInfo,
+    payload: &EventPayload,
+    breakpoints: &[BreakpointInfo],
+) -> bool {
+    let exited = matches!(payload.reason.as_str(), "exited" | "terminated");
+    let filter = handler.filter.as_deref().unwrap_or(ANY);
+
+    match handler.event {
+        EventKind::Exit => exited,
+        EventKind::Stop => !exited,
+        EventKind::Signal => match &payload.signal {
+            Some(signal) => filter == ANY || same_signal(filter, signal),
+            None => false,
+        },
+        EventKind::Breakpoint if payload.reason != "breakpoint" && payload.breakpoint.is_none() => {
+            false
+        }
+        EventKind::Breakpoint => {
+            if filter == ANY
+                || payload
+                    .breakpoint
+                    .is_some_and(|id| filter == id.to_string())
+            {
+                return true;
+            }
+            let in_function = payload.function.as_deref().is_some_and(|function| {
+                function == filter || function.ends_with(&format!("::{}", filter))
+            });
+            in_function
+                || breakpoints
+                    .iter()
+                    .filter(|bp| Some(bp.id) == payload.breakpoint)
+                    .any(|bp| at_location(bp, filter))
+        }
+    }
+}
+
+/// Whether a breakpoint is at `file:line`, matching the file by whole path
+/// components from the end
+fn at_location(breakpoint: &BreakpointInfo, location: &str) -> bool {
+    let (Some(source), Some(line), Some((file, wanted))) = (
+        &breakpoint.source,
+        breakpoint.line,
+        location.rsplit_once(':'),
+    ) else {
+        return false;
+    };
+    wanted == line.to_string() && (source == file || source.ends_with(&format!("/{}", file)))
+}
+
+/// Run one handler's commands for the event
+async fn run(handler: &EventHandlerInfo, payload: &EventPayload) -> Result<()> {
+    let label = format!("on {}", handler.target());
+
+    if !handler.shell {
+        let lines: Vec<String> = handler
+            .commands
+            .iter()
+            .map(|line| script::interpolate(line, |name| payload.lookup(name)))
+            .collect();
+        return hooks::run_guarded(&label, &lines).await;
+    }
+
+    let json = serde_json::to_string(payload)?;
+    for (index, line) in handler.commands.iter().enumerate() {
+        let mut command = shell_command(line);
+        command.env("DEBUGGER_EVENT_JSON", &json);
+        for (name, value) in payload.vars() {
+            command.env(format!("DEBUGGER_{}", name.to_ascii_uppercase()), value);
+        }
+
+        let status = command.status()?;
+        if !status.success() {
+            return Err(Error::Script {
+                path: label,
+                line: index + 1,
+                message: format!("'{}' exited with {}", line, status),
+            });
+        }
+    }
+    Ok(())
+}
+
+#[cfg(unix)]
+fn shell_command(line: &str) -> std::process::Command {
+    let mut command = std::process::Command::new("sh");
+    command.arg("-c").arg(line);
+    command
+}
+
+#[cfg(not(unix))]
+fn shell_command(line: &str) -> std::process::Command {
+    let mut command = std::process::Command::new("cmd");
+    command.arg("/C").arg(line);
+    command
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn handler(event: EventKind, filter: Option<&str>) -> EventHandlerInfo {
+        EventHandlerInfo {
+            event,
+            filter: filter.map(String::from),
+            shell: false,
+            commands: vec!["backtrace".to_string()],
+        }
+    }
+
+    #[test]
+    fn signal_names_come_from_descriptions() {
+        assert_eq!(
+            signal_name("exception", Some("signal SIGSEGV")).as_deref(),
+            Some("SIGSEGV")
+        );
+        assert_eq!(
+            signal_name("signal", Some("Signal: SIGABRT (Aborted)")).as_deref(),
+            Some("SIGABRT")
+        );
+        assert_eq!(signal_name("breakpoint", Some("breakpoint 1.1")), None);
+        assert!(same_signal("segv", "SIGSEGV"));
+        assert!(!same_signal("SIGABRT", "SIGSEGV"));
+    }
+
+    #[test]
+    fn breakpoint_handlers_match_id_function_or_location() {
+        let payload = EventPayload {
+            event: "stop".to_string(),
+            reason: "breakpoint".to_string(),
+            function: Some("worker::worker_start".to_string()),
+            breakpoint: Some(2),
+            ..Default::default()
+        };
+        let breakpoints = vec![BreakpointInfo {
+            id: 2,
+            verified: true,
+            source: Some("/src/app/worker.c".to_string()),
+            line: Some(42),
+            message: None,
+            enabled: true,
+            condition: None,
+            hit_count: None,
+        }];
+
+        let matching = |filter| {
+            matches(
+                &handler(EventKind::Breakpoint, Some(filter)),
+                &payload,
+                &breakpoints,
+            )
+        };
+        assert!(matching("2"));
+        assert!(matching("worker_start"));
+        assert!(matching("worker.c:42"));
+        assert!(matching(ANY));
+        assert!(!matching("main"));
+        assert!(!matching("worker.c:43"));
+
+        assert!(matches(
+            &handler(EventKind::Stop, None),
+            &payload,
+            &breakpoints
+        ));
+        assert!(!matches(
+            &handler(EventKind::Exit, None),
+            &payload,
+            &breakpoints
+        ));
+        assert!(!matches(
+            &handler(EventKind::Signal, Some(ANY)),
+            &payload,
+            &breakpoints
+        ));
+    }
+
+    #[test]
+    fn payload_fills_variables() {
+        let payload = EventPayload {
+            event: "exit".to_string(),
+            reason: "exited".to_string(),
+            exit_code: Some(3),
+            ..Default::default()
+        };
+        assert_eq!(payload.lookup("exit_code").as_deref(), Some("3"));
+        assert_eq!(payload.lookup("thread"), None);
+        assert!(matches(&handler(EventKind::Exit, None), &payload, &[]));
+    }
+}
@@ -0,0 +1,181 @@
+//! Following session state changes made by any client
+//!
+//! Every client of a daemon shares its one session, so a change one client
+//! makes (a `continue` from an editor, a breakpoint hit while another
+//! terminal waits) is visible to all. A follower polls the session's state
+//! and turns each change into an event: `running`, `stopped` and `exited`
+//! (the last two with the `await` result), `ended` when the session goes
+//! away, and `status` for the first check and anything else.
+
+use std::time::Duration;
+
+use serde_json::Value;
+
+use crate::common::Result;
+use crate::ipc::protocol::Command;
+use crate::ipc::DaemonClient;
+
+/// How often followers check the session state
+pub const POLL_INTERVAL: Duration = Duration::from_millis(100);
+
+/// A change in the session
+#[derive(Debug)]
+pub struct Change {
+    pub event: &'static str,
+    /// The status, or the `await` result for `stopped` and `exited`
+    pub data: Value,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct State {
+    active: bool,
+    state: Option<String>,
+}
+
+/// Reports each change in the session's state once
+#[derive(Debug, Default)]
+pub struct Follower {
+    last: Option<State>,
+}
+
+impl Follower {
+    /// Check the session and return what changed since the last check
+    pub async fn poll(&mut self, client: &mut DaemonClient) -> Result<Option<Change>> {
+        let status = client.send_command(Command::Status).await?;
+        let current = State {
+            active: status["session_active"].as_bool().unwrap_or(false),
+            state: status["state"].as_str().map(String::from),
+        };
+        if self.last.as_ref() == Some(&current) {
+            return Ok(None);
+        }
+        let first = self.last.is_none();
+        self.last = Some(current.clone());
+
+        let event = match current.state.as_deref() {
+            _ if first => "status",
+            _ if !current.active => "ended",
+            Some("stopped") => "stopped",
+            Some("exited") => "exited",
+            Some("running") => "running",
+            _ => "status",
+        };
+        if !matches!(event, "stopped" | "exited") {
+            return Ok(Some(Change {
+                event,
+                data: status,
+            }));
+        }
+
+        // The session is already stopped, so this returns at once
+        Ok(Some(
+            match client
+                .send_command(Command::Await { timeout_secs: 1 })
+                .await
+            {
+                Ok(stop) => Change { event, data: stop },
+                Err(_) => Change {
+                    event: "status",
+                    data: status,
+                },
+            },
+        ))
+    }
+}
+
+/// One line describing a change, for terminals
+pub fn describe(change: &Change) -> String {
+    let data = &change.data;
+    match change.event {
+        "stopped" => {
+            let mut line = format!("Stopped: {}", data["reason"].as_str().unwrap_or("unknown"));
+            if let (Some(source), Some(number)) = (data["source"].as_str(), data["line"].as_u64()) {
+                line.push_str(&format!(" at {}:{}", source, number));
+            }
+            if let Some(thread) = data["thread_id"].as_i64() {
+                line.push_str(&format!(" (thread {})", thread));
+            }
+            let crash = &data["crash_report"];
+            if let (Some(signal), Some(path)) = (crash["signal"].as_str(), crash["path"].as_str()) {
+                line.push_str(&format!("; {}", signal));
+                if let Some(address) = crash["address"].as_str() {
+                    line.push_str(&format!(" at {}", address));
+                }
+                line.push_str(&format!(", crash report in {}", path));
+            }
+            for warning in data["warnings"].as_array().into_iter().flatten() {
+                line.push_str(&format!("; {}", warning.as_str().unwrap_or_default()));
+            }
+            line
+        }
+        "exited" => match data["exit_code"].as_i64() {
+            Some(code) => format!("Program exited with code {}", code),
+            None => "Program terminated".to_string(),
+        },
+        "running" => "Running".to_string(),
+        "ended" => "Session ended".to_string(),
+        _ => match (data["session_active"].as_bool(), data["state"].as_str()) {
+            (Some(true), Some(state)) => format!(
+                "Session: {} ({})",
+                data["program"].as_str().unwrap_or("unknown program"),
+                state
+            ),
+            _ => "Session: none".to_string(),
+        },
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    #[test]
+    fn changes_read_as_one_line() {
+        let stopped = Change {
+            event: "stopped",
+            data: json!({ "reason": "breakpoint", "source": "/src/main.c", "line": 12, "thread_id": 1 }),
+        };
+        assert_eq!(
+            describe(&stopped),
+            "Stopped: breakpoint at /src/main.c:12 (thread 1)"
+        );
+
+        let exited = Change {
+            event: "exited",
+            data: json!({ "reason": "exited", "exit_code": 3 }),
+        };
+        assert_eq!(describe(&exited), "Program exited with code 3");
+
+        let crashed = Change {
+            event: "stopped",
+            data: json!({
+                "reason": "exception",
+                "thread_id": 1,
+                "crash_report": { "signal": "SIGSEGV", "address": "0x0", "path": "/r/app.json" },
+            }),
+        };
+        assert_eq!(
+            describe(&crashed),
+            "Stopped: exception (thread 1); SIGSEGV at 0x0, crash report in /r/app.json"
+        );
+
+        let growing = Change {
+            event: "stopped",
+            data: json!({
+                "reason": "breakpoint",
+                "warnings": ["items grew 5 times in a row, from 1 to 32"],
+            }),
+        };
+        assert_eq!(
+            describe(&growing),
+            "Stopped: breakpoint; items grew 5 times in a row, from 1 to 32"
+        );
+
+        let status = Change {
+            event: "status",
+            data: json!({ "session_active": false, "state": null }),
+        };
+        assert_eq!(describe(&status), "Session: none");
+    }
+}
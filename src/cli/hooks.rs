@@ -0,0 +1,168 @@
+//! Running command hooks
+//!
+//! Hook command lists are stored by the daemon; this module decides which
+//! hooks apply to a dispatched command and runs their lines through the normal
+//! dispatcher. As in GDB, commands run from a hook never trigger hooks
+//! themselves, and a failing pre-hook keeps the hooked command from running.
+
+use std::sync::atomic::{AtomicBool, Ordering};
+
+use crate::commands::Commands;
+use crate::common::{Error, Result};
+use crate::ipc::protocol::{Command, HookInfo, HookPhase};
+use crate::ipc::state::ClientState;
+use crate::ipc::DaemonClient;
+
+use super::script;
+
+/// Hook target for the program stopping, as reported by `await`
+///
+/// Follows GDB's `hook-stop`: the name refers to the stop event, not to the
+/// `stop` command that ends the session.
+pub const STOP_EVENT: &str = "stop";
+
+/// Commands that manage the tool rather than the session cannot be hooked
+const UNHOOKABLE: &[&str] = &[
+    "daemon", "logs", "setup", "trust", "test", "serve", "serve-mcp", "connect", "define", "user",
+    "hook-pre", "hook-post", "hooks", "on", "set", "show",
+];
+
+/// Set while hook commands run, so they do not recurse into more hooks
+static RUNNING_HOOK: AtomicBool = AtomicBool::new(false);
+
+/// Resolve a user-supplied hook target to the name hooks are stored under
+pub fn resolve_target(name: &str) -> Result<String> {
+    if name == STOP_EVENT {
+        return Ok(STOP_EVENT.to_string());
+    }
+
+    match script::command_name(name) {
+        Some(canonical) if !UNHOOKABLE.contains(&canonical.as_str()) => Ok(canonical),
+        Some(canonical) => Err(Error::Config(format!("'{}' cannot be hooked", canonical))),
+        None => Err(Error::Config(format!(
+            "Unknown hook target '{}'. Use a command name or '{}'",
+            name, STOP_EVENT
+        ))),
+    }
+}
+
+/// The hook target a command runs under, if it can be hooked
+pub fn target(command: &Commands) -> Option<&'static str> {
+    match command.name() {
+        // `stop` as a hook target is the stop event, see STOP_EVENT
+        "stop" => None,
+        name if UNHOOKABLE.contains(&name) => None,
+        name => Some(name),
+    }
+}
+
+/// Check that every hook line parses before the daemon stores it
+pub fn validate(phase: HookPhase, target: &str, commands: &[String]) -> Result<()> {
+    for (index, line) in commands.iter().enumerate() {
+        script::parse_line(line).map_err(|message| hook_error(phase, target, index, message))?;
+    }
+    Ok(())
+}
+
+/// Fetch the pre and post hooks for a target
+///
+/// Returns no hooks when they cannot be read (daemon not running, or too old
+/// to know about hooks); the command itself will report any real problem.
+pub async fn fetch(target: &str) -> (Vec<String>, Vec<String>) {
+    // The daemon lists its hooked commands where this can read them, so only
+    // a command with hooks costs a round trip
+    if is_running() || !ClientState::read().hooked.contains(&target.to_string()) {
+        return (Vec::new(), Vec::new());
+    }
+
+    let Ok(hooks) = list().await else {
+        return (Vec::new(), Vec::new());
+    };
+
+    let mut pre = Vec::new();
+    let mut post = Vec::new();
+    for hook in hooks.into_iter().filter(|hook| hook.target == target) {
+        match hook.phase {
+            HookPhase::Pre => pre = hook.commands,
+            HookPhase::Post => post = hook.commands,
+        }
+    }
+    (pre, post)
+}
+
+/// List every hook stored by the daemon
+pub async fn list() -> Result<Vec<HookInfo>> {
+    let mut client = DaemonClient::connect().await?;
+    let result = client.send_command(Command::HookList).await?;
+    Ok(serde_json::from_value(result["hooks"].clone())?)
+}
+
+/// Run a hook's command lines in order, stopping at the first failure
+pub async fn run(phase: HookPhase, target: &str, commands: &[String]) -> Result<()> {
+    if commands.is_empty() {
+        return Ok(());
+    }
+
+    run_guarded(&format!("{} {}", phase, target), commands).await
+}
+
+/// Whether hook or event handler commands are running now
+pub fn is_running() -> bool {
+    RUNNING_HOOK.load(Ordering::Relaxed)
+}
+
+/// Run command lines with hooks turned off; `label` names them in errors,
+/// as in `hook-post stop:2: ...`
+pub async fn run_guarded(label: &str, commands: &[String]) -> Result<()> {
+    RUNNING_HOOK.store(true, Ordering::Relaxed);
+    let result = run_lines(label, commands).await;
+    RUNNING_HOOK.store(false, Ordering::Relaxed);
+    result
+}
+
+async fn run_lines(label: &str, commands: &[String]) -> Result<()> {
+    for (index, line) in commands.iter().enumerate() {
+        let command =
+            script::parse_line(line).map_err(|message| line_error(label, index, message))?;
+        Box::pin(super::dispatch(command))
+            .await
+            .map_err(|e| line_error(label, index, e.to_string()))?;
+    }
+    Ok(())
+}
+
+fn hook_error(phase: HookPhase, target: &str, index: usize, message: String) -> Error {
+    line_error(&format!("{} {}", phase, target), index, message)
+}
+
+fn line_error(label: &str, index: usize, message: String) -> Error {
+    Error::Script {
+        path: label.to_string(),
+        line: index + 1,
+        message,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn targets_resolve_aliases_and_stop_event() {
+        assert_eq!(resolve_target("c").unwrap(), "continue");
+        assert_eq!(resolve_target("stop").unwrap(), STOP_EVENT);
+        assert!(resolve_target("hooks").is_err());
+        assert!(resolve_target("frobnicate").is_err());
+    }
+
+    #[test]
+    fn validate_reports_bad_lines() {
+        let err = validate(
+            HookPhase::Post,
+            STOP_EVENT,
+            &["print counter".to_string(), "frobnicate".to_string()],
+        )
+        .unwrap_err();
+        assert!(err.to_string().starts_with("hook-post stop:2:"));
+    }
+}
@@ -0,0 +1,376 @@
+//! Counting what runs between two locations for `analyze hotpath`
+//!
+//! Like `watch-change`, this steps the program from the client and looks at
+//! the stack after every step. Each step counts once for the line it stops
+//! on and the function that line is in, and a function counts a call when
+//! the stack grows into it, so a loop that runs far more often than expected
+//! stands out. Stepping is slow, so the steps are capped, and a breakpoint
+//! hit on the way ends the count early.
+
+use std::collections::HashMap;
+use std::path::Path;
+
+use serde::Serialize;
+use serde_json::Value;
+
+use crate::commands::StepMode;
+use crate::common::{Error, Result};
+use crate::ipc::protocol::{BreakpointLocation, Command, StackFrameInfo};
+use crate::ipc::DaemonClient;
+use crate::symbols::base_name;
+
+/// Frames fetched after each step, enough to tell calls from returns
+const MAX_DEPTH: usize = 256;
+
+/// How to move the program between counts
+#[derive(Debug, Clone, Copy)]
+pub struct Pace {
+    pub step: StepMode,
+    pub max_steps: usize,
+    /// Seconds to wait for each step to stop
+    pub timeout: u64,
+}
+
+/// A line and the steps that stopped on it
+#[derive(Debug, PartialEq, Serialize)]
+pub struct LineCount {
+    pub file: String,
+    pub line: u32,
+    pub function: String,
+    pub count: u64,
+}
+
+/// A function, the steps taken in it and the times it was called
+#[derive(Debug, PartialEq, Serialize)]
+pub struct FunctionCount {
+    pub function: String,
+    pub steps: u64,
+    pub calls: u64,
+}
+
+/// What ran between the two locations, most run first
+#[derive(Debug, Serialize)]
+pub struct Report {
+    pub from: String,
+    pub to: String,
+    pub reached: bool,
+    pub steps: usize,
+    /// Why counting ended before `to`: a stop reason or `max_steps`
+    pub ended: Option<String>,
+    pub lines: Vec<LineCount>,
+    pub functions: Vec<FunctionCount>,
+}
+
+#[derive(Debug, Default)]
+struct Counts {
+    /// By file and line: the function and the steps
+    lines: HashMap<(String, u32), (String, u64)>,
+    /// By function: the steps and the calls
+    functions: HashMap<String, (u64, u64)>,
+    /// Stack depth at the last step
+    depth: Option<usize>,
+}
+
+impl Counts {
+    /// Count a step that stopped with `frames`, innermost first
+    fn count(&mut self, frames: &[StackFrameInfo]) {
+        let Some(top) = frames.first() else {
+            return;
+        };
+        let function = base_name(&top.name).to_string();
+        if let (Some(file), Some(line)) = (&top.source, top.line) {
+            let entry = self
+                .lines
+                .entry((file.clone(), line))
+                .or_insert_with(|| (function.clone(), 0));
+            entry.1 += 1;
+        }
+        let entry = self.functions.entry(function).or_default();
+        entry.0 += 1;
+        if self.depth.is_some_and(|depth| frames.len() > depth) {
+            entry.1 += 1;
+        }
+        self.depth = Some(frames.len());
+    }
+
+    /// The `top` most run lines and functions
+    fn hottest(self, top: usize) -> (Vec<LineCount>, Vec<FunctionCount>) {
+        let mut lines: Vec<LineCount> = self
+            .lines
+            .into_iter()
+            .map(|((file, line), (function, count))| LineCount {
+                file,
+                line,
+                function,
+                count,
+            })
+            .collect();
+        lines.sort_by(|a, b| {
+            b.count.cmp(&a.count).then_with(|| (&a.file, a.line).cmp(&(&b.file, b.line)))
+        });
+        lines.truncate(top);
+
+        let mut functions: Vec<FunctionCount> = self
+            .functions
+            .into_iter()
+            .map(|(function, (steps, calls))| FunctionCount { function, steps, calls })
+            .collect();
+        functions.sort_by(|a, b| b.steps.cmp(&a.steps).then_with(|| a.function.cmp(&b.function)));
+        functions.truncate(top);
+        (lines, functions)
+    }
+}
+
+/// Run to `from` unless the program is there, then step until `to`
+pub async fn run(
+    client: &mut DaemonClient,
+    from: &str,
+    to: &str,
+    pace: Pace,
+    top: usize,
+) -> Result<Report> {
+    let start = resolve(client, from).await?;
+    let end = resolve(client, to).await?;
+
+    let mut frames = stack(client, MAX_DEPTH).await?;
+    if !frames.first().is_some_and(|frame| is_at(&start, frame)) {
+        run_to(client, &start, from, pace.timeout).await?;
+        frames = stack(client, MAX_DEPTH).await?;
+    }
+
+    let mut counts = Counts::default();
+    counts.count(&frames);
+    let mut report = Report {
+        from: from.to_string(),
+        to: to.to_string(),
+        reached: false,
+        steps: 0,
+        ended: None,
+        lines: Vec::new(),
+        functions: Vec::new(),
+    };
+
+    while report.steps < pace.max_steps {
+        let command = match pace.step {
+            StepMode::Over => Command::Next,
+            StepMode::Into => Command::StepIn,
+        };
+        client.send_command(command).await?;
+        let stop = client
+            .send_command(Command::Await {
+                timeout_secs: pace.timeout,
+            })
+            .await?;
+        report.steps += 1;
+
+        let reason = stop["reason"].as_str().unwrap_or("").to_string();
+        if matches!(reason.as_str(), "exited" | "terminated") {
+            report.ended = Some(reason);
+            break;
+        }
+
+        let frames = stack(client, MAX_DEPTH).await?;
+        counts.count(&frames);
+        if frames.first().is_some_and(|frame| is_at(&end, frame)) {
+            report.reached = true;
+            break;
+        }
+        // The user's own breakpoints still stop the program
+        if reason == "breakpoint" {
+            report.ended = Some(reason);
+            break;
+        }
+    }
+    if !report.reached && report.ended.is_none() {
+        report.ended = Some("max_steps".to_string());
+    }
+
+    (report.lines, report.functions) = counts.hottest(top);
+    Ok(report)
+}
+
+/// The report as a summary line and two tables
+pub fn text(report: &Report) -> String {
+    let mut text = match (&report.ended, report.reached) {
+        (_, true) => format!(
+            "Stepped {} times from {} to {}\n",
+            report.steps, report.from, report.to
+        ),
+        (Some(ended), false) if ended == "max_steps" => format!(
+            "Stepped {} times from {} without reaching {}; raise --max-steps\n",
+            report.steps, report.from, report.to
+        ),
+        (ended, false) => format!(
+            "Stepped {} times from {}; stopped by {} before reaching {}\n",
+            report.steps,
+            report.from,
+            ended.as_deref().unwrap_or("?"),
+            report.to
+        ),
+    };
+    text.push_str("\nHottest lines:\n");
+    for line in &report.lines {
+        text.push_str(&format!(
+            "{:>8}  {}:{}  {}\n",
+            line.count, line.file, line.line, line.function
+        ));
+    }
+    text.push_str("\nHottest functions:\n");
+    for function in &report.functions {
+        text.push_str(&format!(
+            "{:>8}  {}  ({} calls)\n",
+            function.steps, function.function, function.calls
+        ));
+    }
+    text
+}
+
+/// A location as `break` takes it, or the line a `@marker:NAME` names
+async fn resolve(client: &mut DaemonClient, location: &str) -> Result<BreakpointLocation> {
+    let Some(name) = location.strip_prefix("@marker:") else {
+        return match BreakpointLocation::parse(location)? {
+            BreakpointLocation::Address { .. } => Err(Error::InvalidLocation(format!(
+                "'{}': hotpath takes a line or a function, not an address",
+                location
+            ))),
+            location => Ok(location),
+        };
+    };
+    let result = client
+        .send_command(Command::ResolveMarker {
+            name: name.to_string(),
+        })
+        .await?;
+    match (result["file"].as_str(), result["line"].as_u64()) {
+        (Some(file), Some(line)) => Ok(BreakpointLocation::Line {
+            file: file.into(),
+            line: line as u32,
+        }),
+        _ => Err(Error::InvalidLocation(location.to_string())),
+    }
+}
+
+/// Let the program run to `location` with a breakpoint removed again after
+async fn run_to(
+    client: &mut DaemonClient,
+    location: &BreakpointLocation,
+    text: &str,
+    timeout: u64,
+) -> Result<()> {
+    let added = client
+        .send_command(Command::BreakpointAdd {
+            location: location.clone(),
+            condition: None,
+            hit_count: None,
+        })
+        .await?;
+    let id = added["id"].as_u64().map(|id| id as u32);
+
+    let stop = match client.send_command(Command::Continue).await {
+        Ok(_) => {
+            client
+                .send_command(Command::Await {
+                    timeout_secs: timeout,
+                })
+                .await
+        }
+        Err(e) => Err(e),
+    };
+    if id.is_some() {
+        client
+            .send_command(Command::BreakpointRemove { id, all: false })
+            .await?;
+    }
+
+    let reason = stop?["reason"].as_str().unwrap_or("").to_string();
+    if matches!(reason.as_str(), "exited" | "terminated") {
+        return Err(Error::Analysis(format!("the program {} before reaching {}", reason, text)));
+    }
+    let frames = stack(client, 1).await?;
+    match frames.first() {
+        Some(frame) if is_at(location, frame) => Ok(()),
+        frame => Err(Error::Analysis(format!(
+            "the program stopped at {} before reaching {}",
+            frame.map_or_else(|| "an unknown location".to_string(), describe),
+            text
+        ))),
+    }
+}
+
+async fn stack(client: &mut DaemonClient, limit: usize) -> Result<Vec<StackFrameInfo>> {
+    let result: Value = client
+        .send_command(Command::StackTrace {
+            thread_id: None,
+            limit,
+            start: 0,
+            more: false,
+        })
+        .await?;
+    Ok(serde_json::from_value(result["frames"].clone())?)
+}
+
+/// Whether a frame is at a location, a line by the end of its path
+fn is_at(location: &BreakpointLocation, frame: &StackFrameInfo) -> bool {
+    match location {
+        BreakpointLocation::Line { file, line } => {
+            frame.line == Some(*line)
+                && frame.source.as_deref().is_some_and(|source| Path::new(source).ends_with(file))
+        }
+        BreakpointLocation::Function { name } => {
+            frame.name == *name || base_name(&frame.name) == name
+        }
+        BreakpointLocation::Address { .. } => false,
+    }
+}
+
+fn describe(frame: &StackFrameInfo) -> String {
+    match (&frame.source, frame.line) {
+        (Some(source), Some(line)) => format!("{} ({}:{})", frame.name, source, line),
+        _ => frame.name.clone(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn frame(name: &str, source: &str, line: u32) -> StackFrameInfo {
+        StackFrameInfo {
+            id: 0,
+            name: name.to_string(),
+            source: Some(source.to_string()),
+            line: Some(line),
+            column: None,
+        }
+    }
+
+    #[test]
+    fn steps_and_calls_are_counted_per_line_and_function() {
+        let main = frame("main", "/src/t.c", 10);
+        let mut counts = Counts::default();
+        counts.count(std::slice::from_ref(&main));
+        for _ in 0..3 {
+            counts.count(&[frame("add(int, int)", "/src/t.c", 3), main.clone()]);
+            counts.count(&[frame("add(int, int)", "/src/t.c", 4), main.clone()]);
+            counts.count(std::slice::from_ref(&main));
+        }
+
+        let (lines, functions) = counts.hottest(2);
+        assert_eq!(lines.len(), 2);
+        assert_eq!((lines[0].line, lines[0].count), (10, 4));
+        assert_eq!((lines[1].line, lines[1].function.as_str()), (3, "add"));
+        assert_eq!(
+            functions,
+            [
+                FunctionCount { function: "add".to_string(), steps: 6, calls: 3 },
+                FunctionCount { function: "main".to_string(), steps: 4, calls: 0 },
+            ]
+        );
+
+        let line = BreakpointLocation::parse("t.c:4").unwrap();
+        assert!(is_at(&line, &frame("add", "/src/t.c", 4)));
+        assert!(!is_at(&line, &frame("add", "/src/at.c", 4)));
+        let function = BreakpointLocation::parse("add").unwrap();
+        assert!(is_at(&function, &frame("a.out`add(int, int)", "/src/t.c", 3)));
+    }
+}
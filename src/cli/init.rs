@@ -0,0 +1,243 @@
+//! Project-local init files
+//!
+//! A `.dbginit` in the working directory holds debugger commands to run when
+//! a session starts. Because it executes commands on the user's behalf, a file
+//! only runs once its canonical path is in the trust list kept next to the
+//! config file, similar to GDB's auto-load safe-path.
+
+use std::io::{BufRead, IsTerminal, Write};
+use std::path::{Path, PathBuf};
+
+use crate::commands::{BreakpointCommands, Commands};
+use crate::common::{paths, Error, Result};
+
+use super::script::{self, ScriptCommand};
+
+/// File name looked up in the current directory
+pub const INIT_FILE_NAME: &str = ".dbginit";
+
+/// Commands from an init file, split by when they must run
+#[derive(Default)]
+pub struct InitPlan {
+    /// `set` commands, applied before the session starts
+    pub settings: Vec<ScriptCommand>,
+    /// Plain breakpoints, set before the program starts running
+    pub breakpoints: Vec<String>,
+    /// Everything else, run in order once the session exists
+    pub commands: Vec<ScriptCommand>,
+}
+
+/// Find the init file in the current directory, if any
+pub fn find() -> Option<PathBuf> {
+    let path = std::env::current_dir().ok()?.join(INIT_FILE_NAME);
+    path.is_file().then_some(path)
+}
+
+/// Load the init file if it exists and is trusted
+///
+/// An untrusted file triggers a prompt on an interactive terminal; otherwise
+/// it is skipped with a note on stderr explaining how to trust it.
+pub fn load() -> Result<Option<InitPlan>> {
+    let Some(path) = find() else {
+        return Ok(None);
+    };
+
+    if !is_trusted(&path)? && !prompt_trust(&path)? {
+        eprintln!(
+            "Skipping untrusted {}. Run 'debugger trust' to allow it.",
+            path.display()
+        );
+        return Ok(None);
+    }
+
+    let commands = script::load(&path)?;
+    for command in &commands {
+        if !allowed_in_init(&command.command) {
+            return Err(Error::Script {
+                path: path.display().to_string(),
+                line: command.line,
+                message: format!("'{}' is not allowed in {}", command.text, INIT_FILE_NAME),
+            });
+        }
+    }
+
+    Ok(Some(plan(commands)))
+}
+
+/// Split commands into settings, startup breakpoints and post-start commands
+fn plan(commands: Vec<ScriptCommand>) -> InitPlan {
+    let mut plan = InitPlan::default();
+
+    for command in commands {
+        match &command.command {
+            // Breakpoints without options can ride along with `start` so they
+            // are in place before configurationDone lets the program run.
+            Commands::Break {
+                location,
+                condition: None,
+                hit_count: None,
+            }
+            | Commands::Breakpoint(BreakpointCommands::Add {
+                location,
+                condition: None,
+                hit_count: None,
+            }) => plan.breakpoints.push(location.clone()),
+            // Settings such as substitute-path must be in place before the
+            // startup breakpoints are resolved.
+            Commands::Set { .. } => plan.settings.push(command),
+            _ => plan.commands.push(command),
+        }
+    }
+
+    plan
+}
+
+/// Commands that would start, replace, or escape the session make no sense
+/// from a file that runs as part of starting one; the servers and `connect`
+/// would also keep `start` from ever returning.
+fn allowed_in_init(command: &Commands) -> bool {
+    !matches!(
+        command,
+        Commands::Start { .. }
+            | Commands::Attach { .. }
+            | Commands::Core(_)
+            | Commands::Setup { .. }
+            | Commands::Test { .. }
+            | Commands::Trust { .. }
+            | Commands::Serve { .. }
+            | Commands::ServeMcp { .. }
+            | Commands::Daemon { .. }
+            | Commands::Connect { .. }
+            | Commands::Session(_)
+    )
+}
+
+/// Check whether an init file is in the trust list
+pub fn is_trusted(path: &Path) -> Result<bool> {
+    let canonical = path.canonicalize()?;
+    Ok(trusted_paths()?.iter().any(|trusted| *trusted == canonical))
+}
+
+/// Add an init file to the trust list
+pub fn trust(path: &Path) -> Result<PathBuf> {
+    let canonical = path.canonicalize().map_err(|e| Error::FileRead {
+        path: path.display().to_string(),
+        error: e.to_string(),
+    })?;
+
+    let mut trusted = trusted_paths()?;
+    if !trusted.contains(&canonical) {
+        trusted.push(canonical.clone());
+        save_trusted_paths(&trusted)?;
+    }
+
+    Ok(canonical)
+}
+
+/// Remove an init file from the trust list, returning whether it was present
+pub fn revoke(path: &Path) -> Result<bool> {
+    let canonical = path.canonicalize().unwrap_or_else(|_| path.to_path_buf());
+
+    let mut trusted = trusted_paths()?;
+    let before = trusted.len();
+    trusted.retain(|trusted| *trusted != canonical);
+    if trusted.len() == before {
+        return Ok(false);
+    }
+
+    save_trusted_paths(&trusted)?;
+    Ok(true)
+}
+
+/// All trusted init file paths
+pub fn trusted_paths() -> Result<Vec<PathBuf>> {
+    let Some(store) = paths::trusted_init_path() else {
+        return Ok(Vec::new());
+    };
+
+    if !store.exists() {
+        return Ok(Vec::new());
+    }
+
+    let content = std::fs::read_to_string(&store).map_err(|e| Error::FileRead {
+        path: store.display().to_string(),
+        error: e.to_string(),
+    })?;
+
+    Ok(content
+        .lines()
+        .map(str::trim)
+        .filter(|line| !line.is_empty())
+        .map(PathBuf::from)
+        .collect())
+}
+
+fn save_trusted_paths(trusted: &[PathBuf]) -> Result<()> {
+    paths::ensure_config_dir()?;
+    let store = paths::trusted_init_path()
+        .ok_or_else(|| Error::Config("Could not determine config directory".to_string()))?;
+
+    let mut content = String::new();
+    for path in trusted {
+        content.push_str(&path.to_string_lossy());
+        content.push('\n');
+    }
+
+    std::fs::write(store, content)?;
+    Ok(())
+}
+
+/// Ask on the terminal whether to trust an init file
+///
+/// Returns false without prompting in batch mode or when stdin is not a
+/// terminal, so agents and scripts never hang on a question nobody will answer.
+fn prompt_trust(path: &Path) -> Result<bool> {
+    if super::batch::is_active() || !std::io::stdin().is_terminal() {
+        return Ok(false);
+    }
+
+    eprint!("{} wants to run debugger commands. Trust it? [y/N] ", path.display());
+    std::io::stderr().flush()?;
+
+    let mut answer = String::new();
+    std::io::stdin().lock().read_line(&mut answer)?;
+
+    if matches!(answer.trim().to_ascii_lowercase().as_str(), "y" | "yes") {
+        trust(path)?;
+        Ok(true)
+    } else {
+        Ok(false)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn plain_breakpoints_run_before_start() {
+        let commands = script::parse(
+            Path::new(INIT_FILE_NAME),
+            "break main\nbreak util.c:3 --condition x\nbreakpoint add helper\nlocals\nset pagination off\n",
+        )
+        .unwrap();
+
+        let plan = plan(commands);
+        assert_eq!(plan.settings.len(), 1);
+        assert_eq!(plan.breakpoints, vec!["main", "helper"]);
+        assert_eq!(plan.commands.len(), 2);
+        assert_eq!(plan.commands[0].line, 2);
+    }
+
+    #[test]
+    fn session_commands_are_rejected() {
+        let script = "start ./a.out\nattach 42\ncore open core -p ./a.out\nsetup lldb\n\
+                      test scenario.yml\ntrust\nserve\nserve-mcp\ndaemon stop\nconnect\n\
+                      session new other\nsession switch other\n";
+        let commands = script::parse(Path::new(INIT_FILE_NAME), script).unwrap();
+        assert_eq!(commands.len(), 12);
+        for command in &commands {
+            assert!(!allowed_in_init(&command.command), "line {} was allowed", command.line);
+        }
+    }
+}
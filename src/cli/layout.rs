@@ -0,0 +1,456 @@
+//! Layouts for `context`
+//!
+//! A layout is the list of panes `context` prints, each with an optional
+//! size in lines: `layout src+asm` adds disassembly under the source and
+//! `layout threads` shows threads and the stack. Custom layouts are defined
+//! in `config.toml`, and the last layout chosen is remembered per project
+//! directory.
+
+use std::path::PathBuf;
+
+use crate::common::config::Config;
+use crate::common::settings::Settings;
+use crate::common::{paths, Error, Result};
+use crate::ipc::protocol::{
+    Command, ContextResult, InstructionInfo, StackFrameInfo, ThreadInfo, WatchInfo,
+};
+use crate::ipc::DaemonClient;
+
+use super::theme::{self, Element};
+use super::{format_variable, print_watches, source};
+
+/// Layout used until another is chosen
+pub const DEFAULT_LAYOUT: &str = "src";
+
+/// Built-in layouts and their panes
+pub const BUILTIN_LAYOUTS: &[(&str, &[&str])] = &[
+    ("src", &["source", "locals"]),
+    ("asm", &["asm", "locals"]),
+    ("src+asm", &["source", "asm:8", "locals"]),
+    ("threads", &["threads", "stack"]),
+    (
+        "full",
+        &["source", "asm:8", "stack:5", "locals", "watches", "threads"],
+    ),
+];
+
+/// Instructions shown by an `asm` pane without a size
+pub const DEFAULT_ASM_LINES: usize = 10;
+
+/// Frames shown by a `stack` pane without a size
+const DEFAULT_STACK_FRAMES: usize = 10;
+
+/// Something `context` can show
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Pane {
+    /// Source around the current line (size: lines on each side)
+    Source,
+    /// Disassembly around the current instruction
+    Asm,
+    /// The frame's local variables
+    Locals,
+    /// The stopped thread's backtrace
+    Stack,
+    /// All threads
+    Threads,
+    /// Watch expressions
+    Watches,
+}
+
+impl Pane {
+    const ALL: [Pane; 6] = [
+        Pane::Source,
+        Pane::Asm,
+        Pane::Locals,
+        Pane::Stack,
+        Pane::Threads,
+        Pane::Watches,
+    ];
+
+    fn name(self) -> &'static str {
+        match self {
+            Pane::Source => "source",
+            Pane::Asm => "asm",
+            Pane::Locals => "locals",
+            Pane::Stack => "stack",
+            Pane::Threads => "threads",
+            Pane::Watches => "watches",
+        }
+    }
+}
+
+/// A pane and how many lines it may use
+#[derive(Debug, Clone, PartialEq)]
+pub struct PaneSpec {
+    pub pane: Pane,
+    pub size: Option<usize>,
+}
+
+impl PaneSpec {
+    /// Parse `name` or `name:size`
+    fn parse(spec: &str) -> std::result::Result<Self, String> {
+        let (name, size) = match spec.split_once(':') {
+            Some((name, size)) => {
+                let size = size
+                    .parse()
+                    .map_err(|_| format!("invalid size in pane '{}'", spec))?;
+                (name, Some(size))
+            }
+            None => (spec, None),
+        };
+
+        let pane = Pane::ALL
+            .into_iter()
+            .find(|pane| pane.name() == name)
+            .ok_or_else(|| {
+                let names: Vec<_> = Pane::ALL.iter().map(|pane| pane.name()).collect();
+                format!("unknown pane '{}'. Panes: {}", name, names.join(", "))
+            })?;
+
+        Ok(Self { pane, size })
+    }
+}
+
+impl std::fmt::Display for PaneSpec {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self.size {
+            Some(size) => write!(f, "{}:{}", self.pane.name(), size),
+            None => write!(f, "{}", self.pane.name()),
+        }
+    }
+}
+
+/// A named list of panes
+#[derive(Debug, Clone)]
+pub struct Layout {
+    pub name: String,
+    pub panes: Vec<PaneSpec>,
+}
+
+impl Layout {
+    /// Look up a custom layout from the config, then a built-in one
+    pub fn resolve(name: &str, config: &Config) -> Result<Self> {
+        let specs: Vec<&str> = match config.layouts.get(name) {
+            Some(specs) => specs.iter().map(String::as_str).collect(),
+            None => BUILTIN_LAYOUTS
+                .iter()
+                .find(|(builtin, _)| *builtin == name)
+                .map(|(_, specs)| specs.to_vec())
+                .ok_or_else(|| {
+                    Error::Config(format!(
+                        "Unknown layout '{}'. Layouts: {}",
+                        name,
+                        names(config).join(", ")
+                    ))
+                })?,
+        };
+
+        let panes = specs
+            .into_iter()
+            .map(PaneSpec::parse)
+            .collect::<std::result::Result<Vec<_>, _>>()
+            .map_err(|e| Error::Config(format!("layout '{}': {}", name, e)))?;
+
+        Ok(Self {
+            name: name.to_string(),
+            panes,
+        })
+    }
+
+    /// Size of the first pane of a kind, if the layout has one
+    pub fn size(&self, pane: Pane) -> Option<Option<usize>> {
+        self.panes
+            .iter()
+            .find(|spec| spec.pane == pane)
+            .map(|spec| spec.size)
+    }
+
+    /// Panes as written in a layout definition
+    pub fn describe(&self) -> String {
+        let panes: Vec<String> = self.panes.iter().map(ToString::to_string).collect();
+        panes.join(", ")
+    }
+}
+
+/// Built-in and custom layout names
+pub fn names(config: &Config) -> Vec<String> {
+    let mut names: Vec<String> = BUILTIN_LAYOUTS
+        .iter()
+        .map(|(name, _)| name.to_string())
+        .collect();
+    let mut custom: Vec<&String> = config
+        .layouts
+        .keys()
+        .filter(|name| !names.contains(name))
+        .collect();
+    custom.sort();
+    names.extend(custom.into_iter().cloned());
+    names
+}
+
+/// The layout remembered for the current directory, or the default
+///
+/// A remembered layout that no longer resolves (a custom layout removed
+/// from the config) falls back to the default.
+pub fn current(config: &Config) -> Layout {
+    let remembered = project_dir().and_then(|project| {
+        load_choices()
+            .into_iter()
+            .find(|(dir, _)| *dir == project)
+            .map(|(_, name)| name)
+    });
+
+    remembered
+        .and_then(|name| Layout::resolve(&name, config).ok())
+        .unwrap_or_else(|| {
+            Layout::resolve(DEFAULT_LAYOUT, config).expect("default layout is built in")
+        })
+}
+
+/// Switch layouts and remember the choice for the current directory
+pub fn select(name: &str, config: &Config) -> Result<Layout> {
+    let layout = Layout::resolve(name, config)?;
+
+    if let Some(project) = project_dir() {
+        let mut choices = load_choices();
+        choices.retain(|(dir, _)| *dir != project);
+        choices.push((project, layout.name.clone()));
+        save_choices(&choices)?;
+    }
+
+    Ok(layout)
+}
+
+fn project_dir() -> Option<PathBuf> {
+    std::env::current_dir().ok()?.canonicalize().ok()
+}
+
+/// Remembered choices, one `layout<TAB>directory` per line
+fn load_choices() -> Vec<(PathBuf, String)> {
+    let Some(store) = paths::project_layouts_path() else {
+        return Vec::new();
+    };
+    let Ok(content) = std::fs::read_to_string(store) else {
+        return Vec::new();
+    };
+
+    content
+        .lines()
+        .filter_map(|line| line.split_once('\t'))
+        .map(|(name, dir)| (PathBuf::from(dir), name.to_string()))
+        .collect()
+}
+
+fn save_choices(choices: &[(PathBuf, String)]) -> Result<()> {
+    paths::ensure_config_dir()?;
+    let store = paths::project_layouts_path()
+        .ok_or_else(|| Error::Config("Could not determine config directory".to_string()))?;
+
+    let mut content = String::new();
+    for (dir, name) in choices {
+        content.push_str(name);
+        content.push('\t');
+        content.push_str(&dir.to_string_lossy());
+        content.push('\n');
+    }
+
+    std::fs::write(store, content)?;
+    Ok(())
+}
+
+/// Print the panes of a layout after the `context` header
+///
+/// Panes are separated by blank lines; one with nothing to show (no
+/// locals, say) is left out.
+pub async fn print_panes(
+    client: &mut DaemonClient,
+    layout: &Layout,
+    ctx: &ContextResult,
+    settings: &Settings,
+) {
+    let mut first = true;
+
+    for spec in &layout.panes {
+        let lines = match spec.pane {
+            Pane::Source => source::format_lines(
+                &ctx.source_lines,
+                settings.inline_values.then_some(&ctx.locals[..]),
+            ),
+            Pane::Locals => titled(
+                "Locals:",
+                ctx.locals
+                    .iter()
+                    .take(spec.size.unwrap_or(usize::MAX))
+                    .map(|var| format!("  {}", format_variable(var)))
+                    .collect(),
+            ),
+            Pane::Asm => {
+                let count = spec.size.unwrap_or(DEFAULT_ASM_LINES);
+                match disassemble(client, count).await {
+                    Ok(instructions) => titled("Disassembly:", format_instructions(&instructions)),
+                    Err(e) => vec![format!("Disassembly unavailable: {}", e)],
+                }
+            }
+            Pane::Stack => {
+                let limit = spec.size.unwrap_or(DEFAULT_STACK_FRAMES);
+                titled("Stack:", stack(client, limit).await)
+            }
+            Pane::Threads => titled("Threads:", threads(client).await),
+            Pane::Watches => {
+                let watches = watches(client).await;
+                if watches.is_empty() {
+                    Vec::new()
+                } else {
+                    // Watches print themselves to keep their highlighting
+                    if !first {
+                        println!();
+                    }
+                    println!("Watches:");
+                    print_watches(&watches, "  ");
+                    first = false;
+                    continue;
+                }
+            }
+        };
+
+        if lines.is_empty() {
+            continue;
+        }
+        if !first {
+            println!();
+        }
+        for line in lines {
+            println!("{}", line);
+        }
+        first = false;
+    }
+}
+
+/// A title over a pane's lines, or nothing if there are none
+fn titled(title: &str, lines: Vec<String>) -> Vec<String> {
+    if lines.is_empty() {
+        return lines;
+    }
+    std::iter::once(title.to_string()).chain(lines).collect()
+}
+
+/// Fetch disassembly around the selected frame's current instruction
+pub async fn disassemble(client: &mut DaemonClient, count: usize) -> Result<Vec<InstructionInfo>> {
+    let result = client.send_command(Command::Disassemble { count }).await?;
+    Ok(serde_json::from_value(result["instructions"].clone())?)
+}
+
+/// Instruction lines with the current one marked
+pub fn format_instructions(instructions: &[InstructionInfo]) -> Vec<String> {
+    instructions
+        .iter()
+        .map(|instruction| {
+            let symbol = instruction
+                .symbol
+                .as_ref()
+                .map(|symbol| format!(" <{}>", symbol))
+                .unwrap_or_default();
+            let address = theme::paint(
+                Element::LineNumber,
+                &format!("{}{}", instruction.address, symbol),
+            );
+            if instruction.is_current {
+                format!(
+                    "{} {}: {}",
+                    theme::paint(Element::CurrentLine, "->"),
+                    address,
+                    theme::paint(Element::CurrentLine, &instruction.instruction)
+                )
+            } else {
+                format!("   {}: {}", address, instruction.instruction)
+            }
+        })
+        .collect()
+}
+
+async fn stack(client: &mut DaemonClient, limit: usize) -> Vec<String> {
+    let Ok(result) = client
+        .send_command(Command::StackTrace {
+            thread_id: None,
+            limit,
+            start: 0,
+            more: false,
+        })
+        .await
+    else {
+        return Vec::new();
+    };
+    let frames: Vec<StackFrameInfo> =
+        serde_json::from_value(result["frames"].clone()).unwrap_or_default();
+
+    frames
+        .iter()
+        .enumerate()
+        .map(|(i, frame)| {
+            let source = frame.source.as_deref().unwrap_or("?");
+            let line = frame
+                .line
+                .map(|l| l.to_string())
+                .unwrap_or_else(|| "?".to_string());
+            format!("  #{} {} at {}:{}", i, frame.name, source, line)
+        })
+        .collect()
+}
+
+async fn threads(client: &mut DaemonClient) -> Vec<String> {
+    let Ok(result) = client.send_command(Command::Threads).await else {
+        return Vec::new();
+    };
+    let threads: Vec<ThreadInfo> =
+        serde_json::from_value(result["threads"].clone()).unwrap_or_default();
+
+    threads
+        .iter()
+        .map(|thread| format!("  {} - {}", thread.id, thread.name))
+        .collect()
+}
+
+async fn watches(client: &mut DaemonClient) -> Vec<WatchInfo> {
+    let Ok(result) = client.send_command(Command::WatchList).await else {
+        return Vec::new();
+    };
+    serde_json::from_value(result["watches"].clone()).unwrap_or_default()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn builtin_layouts_resolve() {
+        let config = Config::default();
+        for (name, _) in BUILTIN_LAYOUTS {
+            Layout::resolve(name, &config).unwrap();
+        }
+
+        let layout = Layout::resolve("src+asm", &config).unwrap();
+        assert_eq!(layout.size(Pane::Asm), Some(Some(8)));
+        assert_eq!(layout.size(Pane::Source), Some(None));
+        assert_eq!(layout.size(Pane::Threads), None);
+        assert_eq!(layout.describe(), "source, asm:8, locals");
+    }
+
+    #[test]
+    fn custom_layouts_come_from_config() {
+        let mut config = Config::default();
+        config.layouts.insert(
+            "dense".to_string(),
+            vec!["source:2".to_string(), "stack:3".to_string()],
+        );
+        config
+            .layouts
+            .insert("broken".to_string(), vec!["registers".to_string()]);
+
+        let layout = Layout::resolve("dense", &config).unwrap();
+        assert_eq!(layout.size(Pane::Source), Some(Some(2)));
+        assert!(names(&config).contains(&"dense".to_string()));
+
+        assert!(Layout::resolve("broken", &config).is_err());
+        assert!(Layout::resolve("nope", &config).is_err());
+    }
+}
@@ -0,0 +1,174 @@
+//! Recording and replaying command macros
+//!
+//! While `record-macro start NAME` is in effect, every command that succeeds
+//! is sent to the daemon, and `record-macro stop` saves them as a command
+//! file under the config directory. `macro run NAME` replays it like
+//! `source`, so a macro can also be copied into a repository and sourced.
+
+use std::path::PathBuf;
+
+use crate::commands::{Commands, UserCommands};
+use crate::common::{paths, Error, Result};
+use crate::ipc::protocol::Command;
+use crate::ipc::DaemonClient;
+
+use super::script;
+
+/// Extension of saved macro files
+const EXTENSION: &str = "dbg";
+
+/// Whether a command belongs in a macro
+///
+/// Commands that manage recording, the tool or its output are left out, and
+/// so is the hidden daemon entry point.
+pub fn recordable(command: &Commands) -> bool {
+    !matches!(
+        command,
+        Commands::RecordMacro(_)
+            | Commands::Macro(_)
+            | Commands::Define { .. }
+            | Commands::User(
+                UserCommands::List | UserCommands::Show { .. } | UserCommands::Delete { .. }
+            )
+            | Commands::Transcript(_)
+            | Commands::Status { .. }
+            | Commands::Logs { .. }
+            | Commands::Daemon { .. }
+            | Commands::Connect { .. }
+            | Commands::Setup { .. }
+            | Commands::Trust { .. }
+            | Commands::Test { .. }
+            | Commands::Serve { .. }
+            | Commands::ServeMcp { .. }
+    )
+}
+
+/// Options that apply to the whole invocation rather than the command, and
+/// take a value
+const GLOBAL_VALUE_OPTIONS: &[&str] = &["-o", "--output", "--session"];
+
+/// Options that apply to the whole invocation and take no value
+const GLOBAL_FLAGS: &[&str] = &["--copy"];
+
+/// This invocation's command as a script line, without global options such
+/// as `--copy`, `-o json` or `--session NAME`; `None` if it does not parse as one
+pub fn command_line() -> Option<String> {
+    let mut words = Vec::new();
+    let mut args = std::env::args().skip(1);
+    while let Some(arg) = args.next() {
+        if arg == "--" {
+            words.push(arg);
+            words.extend(args.by_ref());
+            break;
+        }
+        if GLOBAL_FLAGS.contains(&arg.as_str())
+            || GLOBAL_VALUE_OPTIONS
+                .iter()
+                .any(|option| arg.starts_with(&format!("{}=", option)))
+            || (arg.starts_with("-o") && arg.len() > 2 && !arg.starts_with("--"))
+        {
+            continue;
+        }
+        if GLOBAL_VALUE_OPTIONS.contains(&arg.as_str()) {
+            args.next();
+            continue;
+        }
+        words.push(arg);
+    }
+
+    let line = script::join_words(&words);
+    script::parse_line(&line).ok().map(|_| line)
+}
+
+/// Send a finished command to the macro being recorded
+pub async fn record(command_line: String) {
+    if let Ok(mut client) = DaemonClient::connect().await {
+        let _ = client
+            .send_command(Command::MacroRecord { command_line })
+            .await;
+    }
+}
+
+/// Check that a macro name works as a file name everywhere
+pub fn validate_name(name: &str) -> Result<()> {
+    let valid = !name.is_empty()
+        && name
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || c == '-' || c == '_');
+    if valid {
+        Ok(())
+    } else {
+        Err(Error::Macro(format!(
+            "'{}' is not a valid name; use letters, digits, '-' and '_'",
+            name
+        )))
+    }
+}
+
+fn dir() -> Result<PathBuf> {
+    paths::macros_dir()
+        .ok_or_else(|| Error::Config("cannot determine the config directory".to_string()))
+}
+
+/// Where a macro is saved
+pub fn path(name: &str) -> Result<PathBuf> {
+    validate_name(name)?;
+    Ok(dir()?.join(format!("{}.{}", name, EXTENSION)))
+}
+
+/// Path of an existing macro
+pub fn find(name: &str) -> Result<PathBuf> {
+    let path = path(name)?;
+    if path.is_file() {
+        Ok(path)
+    } else {
+        Err(Error::Macro(format!(
+            "no macro named '{}'; 'macro list' shows recorded macros",
+            name
+        )))
+    }
+}
+
+/// Save recorded commands as a command file, replacing any macro of the
+/// same name
+pub fn save(name: &str, commands: &[String]) -> Result<PathBuf> {
+    let path = path(name)?;
+    std::fs::create_dir_all(dir()?)?;
+
+    let mut content = format!("# Macro '{}', recorded by 'record-macro'\n", name);
+    for command in commands {
+        content.push_str(command);
+        content.push('\n');
+    }
+    std::fs::write(&path, content)?;
+    Ok(path)
+}
+
+/// Names of saved macros, sorted
+pub fn list() -> Result<Vec<String>> {
+    let Ok(entries) = std::fs::read_dir(dir()?) else {
+        return Ok(Vec::new());
+    };
+
+    let mut names: Vec<String> = entries
+        .filter_map(|entry| entry.ok())
+        .map(|entry| entry.path())
+        .filter(|path| path.extension().is_some_and(|ext| ext == EXTENSION))
+        .filter_map(|path| Some(path.file_stem()?.to_string_lossy().into_owned()))
+        .collect();
+    names.sort();
+    Ok(names)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn names_must_be_file_safe() {
+        assert!(validate_name("inspect-worker_2").is_ok());
+        assert!(validate_name("").is_err());
+        assert!(validate_name("../evil").is_err());
+        assert!(validate_name("two words").is_err());
+    }
+}
@@ -0,0 +1,660 @@
+//! Model Context Protocol server
+//!
+//! `serve-mcp` speaks MCP over stdin/stdout (newline-delimited JSON-RPC 2.0)
+//! so an LLM agent can drive the session as a set of tools. Each tool call
+//! is one daemon command, the same one the matching CLI command sends.
+//!
+//! Only the tools the allowlist permits are listed or run, and every call,
+//! refused or not, is appended to the audit log as one JSON object per line.
+
+use std::fs::{File, OpenOptions};
+use std::io::Write;
+use std::path::{Path, PathBuf};
+
+use serde::Deserialize;
+use serde_json::{json, Map, Value};
+use tokio::io::{AsyncBufReadExt, BufReader};
+
+use crate::common::{paths, time, Error, Result};
+use crate::ipc::protocol::{BreakpointLocation, Command, EvaluateContext};
+use crate::ipc::DaemonClient;
+
+use super::spawn;
+
+/// Protocol revisions this server can speak, newest first
+const PROTOCOL_VERSIONS: &[&str] = &["2025-06-18", "2025-03-26", "2024-11-05"];
+
+/// JSON-RPC error codes
+const PARSE_ERROR: i64 = -32700;
+const METHOD_NOT_FOUND: i64 = -32601;
+const INVALID_PARAMS: i64 = -32602;
+
+/// A tool argument
+struct Param {
+    name: &'static str,
+    /// JSON Schema type; `array` means an array of strings
+    kind: &'static str,
+    description: &'static str,
+    required: bool,
+}
+
+const fn param(name: &'static str, kind: &'static str, description: &'static str) -> Param {
+    Param {
+        name,
+        kind,
+        description,
+        required: false,
+    }
+}
+
+const fn required(name: &'static str, kind: &'static str, description: &'static str) -> Param {
+    Param {
+        name,
+        kind,
+        description,
+        required: true,
+    }
+}
+
+/// A debugger operation offered to the agent
+struct Tool {
+    name: &'static str,
+    description: &'static str,
+    /// Whether the tool only looks at the session, for `--read-only`
+    read_only: bool,
+    params: &'static [Param],
+}
+
+const TOOLS: &[Tool] = &[
+    Tool {
+        name: "status",
+        description: "Session status: program, run state, stopped thread and selected frame",
+        read_only: true,
+        params: &[],
+    },
+    Tool {
+        name: "start",
+        description: "Start debugging a program (project .dbginit files are not run)",
+        read_only: false,
+        params: &[
+            required("program", "string", "Path to the program"),
+            param("args", "array", "Arguments for the program"),
+            param("adapter", "string", "Debug adapter to use"),
+            param(
+                "stop_on_entry",
+                "boolean",
+                "Stop at the program's entry point",
+            ),
+            param("breakpoints", "array", "Breakpoints to set before it runs"),
+        ],
+    },
+    Tool {
+        name: "stop",
+        description: "End the debug session, terminating the program",
+        read_only: false,
+        params: &[],
+    },
+    Tool {
+        name: "set_breakpoint",
+        description: "Set a breakpoint at file:line or on a function",
+        read_only: false,
+        params: &[
+            required("location", "string", "file:line or function name"),
+            param(
+                "condition",
+                "string",
+                "Only stop when this expression is true",
+            ),
+            param("hit_count", "integer", "Only stop after this many hits"),
+        ],
+    },
+    Tool {
+        name: "remove_breakpoint",
+        description: "Remove a breakpoint by ID",
+        read_only: false,
+        params: &[required("id", "integer", "Breakpoint ID")],
+    },
+    Tool {
+        name: "list_breakpoints",
+        description: "List breakpoints with their IDs, locations and conditions",
+        read_only: true,
+        params: &[],
+    },
+    Tool {
+        name: "continue",
+        description: "Resume the program; use wait_for_stop to see where it stops",
+        read_only: false,
+        params: &[],
+    },
+    Tool {
+        name: "step_over",
+        description: "Step to the next line, over function calls",
+        read_only: false,
+        params: &[],
+    },
+    Tool {
+        name: "step_into",
+        description: "Step to the next line, into function calls",
+        read_only: false,
+        params: &[],
+    },
+    Tool {
+        name: "step_out",
+        description: "Run until the current function returns",
+        read_only: false,
+        params: &[],
+    },
+    Tool {
+        name: "pause",
+        description: "Pause the running program",
+        read_only: false,
+        params: &[],
+    },
+    Tool {
+        name: "wait_for_stop",
+        description: "Wait for the program to stop and report why and where",
+        read_only: true,
+        params: &[param("timeout", "integer", "Seconds to wait (default 30)")],
+    },
+    Tool {
+        name: "backtrace",
+        description: "Stack frames of a thread",
+        read_only: true,
+        params: &[
+            param(
+                "thread",
+                "integer",
+                "Thread ID (default: the selected thread)",
+            ),
+            param("limit", "integer", "Maximum number of frames (default 20)"),
+            param("start", "integer", "Innermost frames to skip (default 0)"),
+            param(
+                "more",
+                "boolean",
+                "Go on from where the last backtrace stopped, in place of start",
+            ),
+        ],
+    },
+    Tool {
+        name: "threads",
+        description: "List the program's threads",
+        read_only: true,
+        params: &[],
+    },
+    Tool {
+        name: "locals",
+        description: "Local variables of a frame",
+        read_only: true,
+        params: &[param(
+            "frame",
+            "integer",
+            "Frame ID (default: the selected frame)",
+        )],
+    },
+    Tool {
+        name: "evaluate",
+        description: "Evaluate an expression; expressions with side effects can change the program",
+        read_only: false,
+        params: &[
+            required(
+                "expression",
+                "string",
+                "Expression in the program's language",
+            ),
+            param("frame", "integer", "Frame ID (default: the selected frame)"),
+        ],
+    },
+    Tool {
+        name: "context",
+        description: "Source lines around the current position, with locals",
+        read_only: true,
+        params: &[param(
+            "lines",
+            "integer",
+            "Lines before and after (default 5)",
+        )],
+    },
+    Tool {
+        name: "output",
+        description: "The program's buffered stdout and stderr",
+        read_only: true,
+        params: &[param("tail", "integer", "Only the last N lines")],
+    },
+];
+
+/// Which tools the agent may use
+#[derive(Debug, Clone)]
+pub struct Allowlist {
+    names: Vec<&'static str>,
+}
+
+impl Allowlist {
+    /// Tools named by `--allow` (all when empty), narrowed to inspection
+    /// tools by `--read-only`
+    pub fn new(allow: &[String], read_only: bool) -> Result<Self> {
+        for name in allow {
+            if !TOOLS.iter().any(|tool| tool.name == name) {
+                return Err(Error::Config(format!(
+                    "unknown MCP tool '{}'; tools are: {}",
+                    name,
+                    TOOLS
+                        .iter()
+                        .map(|tool| tool.name)
+                        .collect::<Vec<_>>()
+                        .join(", ")
+                )));
+            }
+        }
+
+        let names = TOOLS
+            .iter()
+            .filter(|tool| allow.is_empty() || allow.iter().any(|name| name == tool.name))
+            .filter(|tool| !read_only || tool.read_only)
+            .map(|tool| tool.name)
+            .collect();
+        Ok(Self { names })
+    }
+
+    fn permits(&self, name: &str) -> bool {
+        self.names.contains(&name)
+    }
+
+    fn tools(&self) -> impl Iterator<Item = &'static Tool> + '_ {
+        TOOLS.iter().filter(|tool| self.permits(tool.name))
+    }
+}
+
+/// Default audit log location
+pub fn default_audit_path() -> Result<PathBuf> {
+    paths::log_dir()
+        .map(|dir| dir.join("mcp-audit.jsonl"))
+        .ok_or_else(|| Error::Config("cannot determine the log directory".to_string()))
+}
+
+/// Append-only record of the agent's tool calls
+struct AuditLog {
+    file: File,
+}
+
+impl AuditLog {
+    fn open(path: &Path) -> Result<Self> {
+        if let Some(dir) = path.parent() {
+            std::fs::create_dir_all(dir)?;
+        }
+        let file = OpenOptions::new().create(true).append(true).open(path)?;
+        Ok(Self { file })
+    }
+
+    fn record(&mut self, tool: &str, arguments: &Value, allowed: bool, error: Option<&str>) {
+        let entry = json!({
+            "time": time::timestamp(),
+            "tool": tool,
+            "arguments": arguments,
+            "allowed": allowed,
+            "ok": allowed && error.is_none(),
+            "error": error,
+        });
+        // An unwritable log must not stop the session, but it is worth a warning
+        if let Err(e) = writeln!(self.file, "{}", entry) {
+            eprintln!("Warning: cannot write the MCP audit log: {}", e);
+        }
+    }
+}
+
+#[derive(Debug, Deserialize)]
+struct Message {
+    id: Option<Value>,
+    method: Option<String>,
+    #[serde(default)]
+    params: Value,
+}
+
+#[derive(Debug, Deserialize)]
+struct CallParams {
+    name: String,
+    #[serde(default)]
+    arguments: Value,
+}
+
+/// A JSON-RPC error reply
+struct RpcError {
+    code: i64,
+    message: String,
+}
+
+impl RpcError {
+    fn new(code: i64, message: impl Into<String>) -> Self {
+        Self {
+            code,
+            message: message.into(),
+        }
+    }
+}
+
+/// Serve MCP on stdin/stdout until stdin closes
+pub async fn serve(allowlist: Allowlist, audit_path: &Path) -> Result<()> {
+    let mut audit = AuditLog::open(audit_path)?;
+    let mut lines = BufReader::new(tokio::io::stdin()).lines();
+
+    while let Some(line) = lines.next_line().await? {
+        if line.trim().is_empty() {
+            continue;
+        }
+        let reply = match serde_json::from_str::<Message>(&line) {
+            Ok(message) => handle(message, &allowlist, &mut audit).await,
+            Err(e) => Some(error_reply(
+                Value::Null,
+                RpcError::new(PARSE_ERROR, e.to_string()),
+            )),
+        };
+        if let Some(reply) = reply {
+            let mut stdout = std::io::stdout().lock();
+            writeln!(stdout, "{}", reply)?;
+            stdout.flush()?;
+        }
+    }
+    Ok(())
+}
+
+/// Handle one message; notifications and stray responses get no reply
+async fn handle(message: Message, allowlist: &Allowlist, audit: &mut AuditLog) -> Option<Value> {
+    let (Some(id), Some(method)) = (message.id, message.method) else {
+        return None;
+    };
+
+    let result = match method.as_str() {
+        "initialize" => Ok(initialize(&message.params)),
+        "ping" => Ok(json!({})),
+        "tools/list" => Ok(json!({
+            "tools": allowlist.tools().map(describe).collect::<Vec<_>>(),
+        })),
+        "tools/call" => match serde_json::from_value::<CallParams>(message.params) {
+            Ok(call) => call_tool(call, allowlist, audit).await,
+            Err(e) => Err(RpcError::new(INVALID_PARAMS, e.to_string())),
+        },
+        _ => Err(RpcError::new(
+            METHOD_NOT_FOUND,
+            format!("method '{}' is not supported", method),
+        )),
+    };
+
+    Some(match result {
+        Ok(result) => json!({ "jsonrpc": "2.0", "id": id, "result": result }),
+        Err(error) => error_reply(id, error),
+    })
+}
+
+fn error_reply(id: Value, error: RpcError) -> Value {
+    json!({
+        "jsonrpc": "2.0",
+        "id": id,
+        "error": { "code": error.code, "message": error.message },
+    })
+}
+
+fn initialize(params: &Value) -> Value {
+    let requested = params["protocolVersion"].as_str().unwrap_or_default();
+    let version = PROTOCOL_VERSIONS
+        .iter()
+        .find(|version| **version == requested)
+        .unwrap_or(&PROTOCOL_VERSIONS[0]);
+
+    json!({
+        "protocolVersion": version,
+        "capabilities": { "tools": {} },
+        "serverInfo": {
+            "name": env!("CARGO_PKG_NAME"),
+            "version": env!("CARGO_PKG_VERSION"),
+        },
+        "instructions": "Drives a debugger session shared with the user's terminal. \
+            Execution tools return at once; call wait_for_stop to see where the \
+            program stopped.",
+    })
+}
+
+/// A tool's MCP description, with its arguments as JSON Schema
+fn describe(tool: &Tool) -> Value {
+    let mut properties = Map::new();
+    for param in tool.params {
+        let schema = match param.kind {
+            "array" => json!({
+                "type": "array",
+                "items": { "type": "string" },
+                "description": param.description,
+            }),
+            kind => json!({ "type": kind, "description": param.description }),
+        };
+        properties.insert(param.name.to_string(), schema);
+    }
+    let required: Vec<&str> = tool
+        .params
+        .iter()
+        .filter(|param| param.required)
+        .map(|param| param.name)
+        .collect();
+
+    json!({
+        "name": tool.name,
+        "description": tool.description,
+        "inputSchema": {
+            "type": "object",
+            "properties": properties,
+            "required": required,
+        },
+        "annotations": { "readOnlyHint": tool.read_only },
+    })
+}
+
+async fn call_tool(
+    call: CallParams,
+    allowlist: &Allowlist,
+    audit: &mut AuditLog,
+) -> std::result::Result<Value, RpcError> {
+    if !TOOLS.iter().any(|tool| tool.name == call.name) {
+        audit.record(&call.name, &call.arguments, false, Some("unknown tool"));
+        return Err(RpcError::new(
+            INVALID_PARAMS,
+            format!("unknown tool '{}'", call.name),
+        ));
+    }
+    if !allowlist.permits(&call.name) {
+        audit.record(&call.name, &call.arguments, false, Some("not allowed"));
+        return Ok(tool_error(format!(
+            "tool '{}' is not allowed by this server's allowlist",
+            call.name
+        )));
+    }
+
+    let command = match command(&call.name, &call.arguments) {
+        Ok(command) => command,
+        Err(e) => {
+            audit.record(&call.name, &call.arguments, true, Some(&e.to_string()));
+            return Err(RpcError::new(INVALID_PARAMS, e.to_string()));
+        }
+    };
+
+    match run(command).await {
+        Ok(result) => {
+            audit.record(&call.name, &call.arguments, true, None);
+            let text = serde_json::to_string_pretty(&result).unwrap_or_default();
+            let mut reply = json!({
+                "content": [{ "type": "text", "text": text }],
+                "isError": false,
+            });
+            if result.is_object() {
+                reply["structuredContent"] = result;
+            }
+            Ok(reply)
+        }
+        Err(e) => {
+            let message = e.to_string();
+            audit.record(&call.name, &call.arguments, true, Some(&message));
+            Ok(tool_error(message))
+        }
+    }
+}
+
+/// A failed call reported to the agent, which can react to it
+fn tool_error(message: String) -> Value {
+    json!({
+        "content": [{ "type": "text", "text": message }],
+        "isError": true,
+    })
+}
+
+async fn run(command: Command) -> Result<Value> {
+    spawn::ensure_daemon_running().await?;
+    let mut client = DaemonClient::connect().await?;
+    client.send_command(command).await
+}
+
+/// The daemon command for a tool call
+fn command(tool: &str, args: &Value) -> Result<Command> {
+    let string = |name: &str| args[name].as_str().map(String::from);
+    let number = |name: &str| -> Result<Option<i64>> {
+        match &args[name] {
+            Value::Null => Ok(None),
+            value => value.as_i64().map(Some).ok_or_else(|| {
+                Error::Config(format!("'{}' must be an integer, not {}", name, value))
+            }),
+        }
+    };
+    let count = |name: &str, default: usize| -> Result<usize> {
+        Ok(number(name)?.map_or(default, |n| n.max(0) as usize))
+    };
+    let require =
+        |name: &str| string(name).ok_or_else(|| Error::Config(format!("'{}' is required", name)));
+    let strings = |name: &str| -> Vec<String> {
+        args[name]
+            .as_array()
+            .map(|items| {
+                items
+                    .iter()
+                    .filter_map(|item| item.as_str().map(String::from))
+                    .collect()
+            })
+            .unwrap_or_default()
+    };
+
+    Ok(match tool {
+        "status" => Command::Status,
+        "start" => {
+            // The daemon runs elsewhere; give it an absolute path
+            let program = std::env::current_dir()?.join(require("program")?);
+            Command::Start {
+                program: program.canonicalize().unwrap_or(program),
+                args: strings("args"),
+                adapter: string("adapter"),
+                stop_on_entry: args["stop_on_entry"].as_bool().unwrap_or(false),
+                initial_breakpoints: strings("breakpoints"),
+            }
+        }
+        "stop" => Command::Stop,
+        "set_breakpoint" => Command::BreakpointAdd {
+            location: BreakpointLocation::parse(&require("location")?)?,
+            condition: string("condition"),
+            hit_count: number("hit_count")?.map(|n| n.max(0) as u32),
+        },
+        "remove_breakpoint" => Command::BreakpointRemove {
+            id: Some(
+                number("id")?.ok_or_else(|| Error::Config("'id' is required".to_string()))? as u32,
+            ),
+            all: false,
+        },
+        "list_breakpoints" => Command::BreakpointList,
+        "continue" => Command::Continue,
+        "step_over" => Command::Next,
+        "step_into" => Command::StepIn,
+        "step_out" => Command::StepOut,
+        "pause" => Command::Pause,
+        "wait_for_stop" => Command::Await {
+            timeout_secs: count("timeout", 30)? as u64,
+        },
+        "backtrace" => Command::StackTrace {
+            thread_id: number("thread")?,
+            limit: count("limit", 20)?,
+            start: count("start", 0)?,
+            more: args["more"].as_bool().unwrap_or(false),
+        },
+        "threads" => Command::Threads,
+        "locals" => Command::Locals {
+            frame_id: number("frame")?,
+        },
+        "evaluate" => Command::Evaluate {
+            expression: require("expression")?,
+            frame_id: number("frame")?,
+            context: EvaluateContext::Repl,
+            limit: None,
+        },
+        "context" => Command::Context {
+            lines: count("lines", 5)?,
+        },
+        "output" => Command::GetOutput {
+            tail: number("tail")?.map(|n| n.max(0) as usize),
+            clear: false,
+            since_last_stop: false,
+        },
+        _ => return Err(Error::Config(format!("unknown tool '{}'", tool))),
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn every_tool_maps_to_a_command() {
+        let args = json!({
+            "program": "app",
+            "location": "main",
+            "id": 1,
+            "expression": "n",
+        });
+        for tool in TOOLS {
+            assert!(command(tool.name, &args).is_ok(), "{}", tool.name);
+        }
+        assert!(command("set_breakpoint", &json!({})).is_err());
+        assert!(command("backtrace", &json!({ "limit": "many" })).is_err());
+    }
+
+    #[test]
+    fn allowlist_limits_listed_tools() {
+        let all = Allowlist::new(&[], false).unwrap();
+        assert_eq!(all.tools().count(), TOOLS.len());
+
+        let read_only = Allowlist::new(&[], true).unwrap();
+        assert!(read_only.permits("backtrace"));
+        assert!(!read_only.permits("continue"));
+        assert!(!read_only.permits("evaluate"));
+
+        let some = Allowlist::new(&["locals".to_string(), "continue".to_string()], true).unwrap();
+        assert_eq!(
+            some.tools().map(|tool| tool.name).collect::<Vec<_>>(),
+            ["locals"]
+        );
+
+        assert!(Allowlist::new(&["rm".to_string()], false).is_err());
+    }
+
+    #[test]
+    fn tools_describe_their_arguments() {
+        let tool = TOOLS
+            .iter()
+            .find(|tool| tool.name == "set_breakpoint")
+            .unwrap();
+        let description = describe(tool);
+        assert_eq!(description["inputSchema"]["required"], json!(["location"]));
+        assert_eq!(
+            description["inputSchema"]["properties"]["hit_count"]["type"],
+            "integer"
+        );
+        assert_eq!(
+            initialize(&json!({}))["protocolVersion"],
+            PROTOCOL_VERSIONS[0]
+        );
+        assert_eq!(
+            initialize(&json!({ "protocolVersion": "2024-11-05" }))["protocolVersion"],
+            "2024-11-05"
+        );
+    }
+}
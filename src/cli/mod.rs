@@ -2,35 +2,270 @@
 //!
 //! Dispatches CLI commands to the daemon and formats output.
 
+pub mod api;
+pub mod assertion;
+pub mod batch;
+pub mod btrace;
+pub mod capture;
+pub mod ci;
+pub mod clipboard;
+pub mod connect;
+pub mod coredump;
+pub mod coverage;
+pub mod dwarf;
+pub mod editor;
+pub mod events;
+pub mod follow;
+pub mod hooks;
+pub mod hotpath;
+pub mod init;
+pub mod layout;
+pub mod macros;
+pub mod mcp;
+pub mod output;
+pub mod pager;
+pub mod profile;
+pub mod python;
+pub mod replay;
+pub mod report;
+pub mod sample;
+pub mod script;
+pub mod session;
+pub mod source;
+pub mod sourcepath;
 pub mod spawn;
-
-use crate::commands::{BreakpointCommands, Commands};
-use crate::common::{Error, Result};
+pub mod suggest;
+pub mod symbolicate;
+pub mod template;
+pub mod theme;
+pub mod timeline;
+pub mod timer;
+pub mod trace;
+pub mod track;
+pub mod transcript;
+pub mod until;
+pub mod user;
+pub mod watchdog;
+
+use std::path::PathBuf;
+
+use serde_json::json;
+
+use crate::commands::{
+    AnalyzeCommands, BreakpointCommands, BtraceCommands, Commands, CoreCommands, CoverageCommands,
+    CoverageFormat, DaemonCommands, HeapCommands, InfoCommands, MacroCommands, OutputCommands,
+    ProcCommands, ProfileCommands, RecordCommands, RecordMacroCommands, ReplayCommands,
+    ReportCommands, SampleCommands, SampleFormat, SessionCommands, SoftwareWatch, SymbolsCommands,
+    TimerCommands, TraceCommands, TrackCommands, TranscriptCommands, UserCommands, WatchCommands,
+};
+use crate::common::config::Config;
+use crate::common::settings::Settings;
+use crate::common::{paths, Error, Result};
 use crate::ipc::protocol::{
-    BreakpointInfo, BreakpointLocation, Command, ContextResult, EvaluateContext, EvaluateResult,
-    StackFrameInfo, StatusResult, StopResult, ThreadInfo, VariableInfo,
+    BreakpointInfo, BreakpointLocation, BtraceCall, BtraceInstruction, Command, ContextResult,
+    EvaluateContext, EvaluateResult, EventHandlerInfo, EventKind, FileCoverage, FindKind, FindMatch,
+    HeapChange, HookInfo, HookPhase, ModuleInfo, OutputLine, ProfileStack, RecordedStep,
+    SampleValue, SamplerInfo, SlideInfo, StackFrameInfo, StatusResult, StopResult, ThreadInfo,
+    TimelineEvent, TraceEntry, TrackSize, TrackerInfo, VariableInfo, WatchInfo, WatchSample,
 };
 use crate::ipc::DaemonClient;
 use crate::setup;
 use crate::testing;
 
-/// Dispatch a CLI command
+use theme::Element;
+
+/// Dispatch a CLI command, running any hooks set for it
 pub async fn dispatch(command: Commands) -> Result<()> {
+    let Some(target) = hooks::target(&command) else {
+        return execute(command).await;
+    };
+
+    let (pre, post) = hooks::fetch(target).await;
+    hooks::run(HookPhase::Pre, target, &pre).await?;
+    execute(command).await?;
+
+    // The command already succeeded, so a failing post-hook is only reported
+    if let Err(e) = hooks::run(HookPhase::Post, target, &post).await {
+        eprintln!("Warning: {}", e);
+    }
+
+    Ok(())
+}
+
+/// Frames `backtrace` asks for at once, printing each batch as it comes
+const BACKTRACE_PAGE: usize = 50;
+
+async fn execute(command: Commands) -> Result<()> {
+    let name = command.name();
+    let json = output::is_json();
+
     match command {
-        Commands::Daemon => {
+        Commands::Daemon { action: None } => {
             // Should never happen - daemon mode is handled in main
             unreachable!("Daemon command should be handled in main")
         }
 
+        Commands::Daemon {
+            action: Some(action),
+        } => {
+            let session = paths::session_name();
+            match action {
+                DaemonCommands::Start => {
+                    let already_running = DaemonClient::connect().await.is_ok();
+                    spawn::ensure_daemon_running().await?;
+                    if json {
+                        return output::emit(
+                            name,
+                            json!({
+                                "session": session,
+                                "socket": paths::socket_name(),
+                                "already_running": already_running,
+                            }),
+                        );
+                    }
+                    if already_running {
+                        println!("The daemon for session '{}' is already running", session);
+                    } else {
+                        println!("Started a daemon for session '{}'", session);
+                    }
+                    println!("Join it with 'debugger connect {}'", session);
+                    Ok(())
+                }
+                DaemonCommands::Stop => {
+                    let mut client = DaemonClient::connect().await.map_err(|e| match e {
+                        Error::DaemonNotRunning => Error::SessionDaemonNotRunning(session.clone()),
+                        e => e,
+                    })?;
+                    client.send_command(Command::Shutdown).await?;
+                    if json {
+                        output::emit(name, json!({ "session": session, "stopped": true }))?;
+                    } else {
+                        println!("Stopped the daemon for session '{}'", session);
+                    }
+                    Ok(())
+                }
+                DaemonCommands::List => {
+                    let mut sessions = Vec::new();
+                    for candidate in paths::session_names() {
+                        // A socket left behind by a daemon that died is not a session
+                        let Ok(mut client) = DaemonClient::connect_to_session(&candidate).await
+                        else {
+                            continue;
+                        };
+                        let Ok(result) = client.send_command(Command::Status).await else {
+                            continue;
+                        };
+                        let status: StatusResult = serde_json::from_value(result)?;
+                        sessions.push((candidate, status));
+                    }
+
+                    if json {
+                        let sessions: Vec<_> = sessions
+                            .iter()
+                            .map(|(session, status)| {
+                                json!({
+                                    "session": session,
+                                    "session_active": status.session_active,
+                                    "state": status.state,
+                                    "program": status.program,
+                                })
+                            })
+                            .collect();
+                        return output::emit(name, json!({ "sessions": sessions }));
+                    }
+                    if sessions.is_empty() {
+                        println!("No session daemons running");
+                    }
+                    for (candidate, status) in &sessions {
+                        let marker = if *candidate == session { "*" } else { " " };
+                        let detail = match (&status.program, &status.state) {
+                            (Some(program), Some(state)) => format!("{} ({})", program, state),
+                            _ => "no program".to_string(),
+                        };
+                        println!("{} {:<16} {}", marker, candidate, detail);
+                    }
+                    Ok(())
+                }
+            }
+        }
+
+        Commands::Connect { session } => {
+            if let Some(session) = &session {
+                paths::select_session(session)?;
+            }
+            connect::run(&paths::session_name()).await
+        }
+
+        Commands::Session(action) => match action {
+            SessionCommands::New { name: session } => {
+                session::new(&session).await?;
+                if json {
+                    output::emit(
+                        name,
+                        json!({ "session": session, "socket": paths::socket_name() }),
+                    )?;
+                } else if !output::is_quiet() {
+                    println!("Started session '{}'; commands now go to it", session);
+                }
+                Ok(())
+            }
+            SessionCommands::Switch { name: session } => {
+                session::switch(&session).await?;
+                if json {
+                    output::emit(name, json!({ "session": session }))?;
+                } else if !output::is_quiet() {
+                    println!("Switched to session '{}'", session);
+                }
+                Ok(())
+            }
+            SessionCommands::Broadcast { to, command } => {
+                let deliveries = session::broadcast(to, &command).await?;
+                if json {
+                    let sessions: Vec<_> = deliveries
+                        .iter()
+                        .map(|delivery| json!({ "session": delivery.session, "ok": delivery.ok }))
+                        .collect();
+                    output::emit(name, json!({ "sessions": sessions }))?;
+                }
+                let failed: Vec<&str> = deliveries
+                    .iter()
+                    .filter(|delivery| !delivery.ok)
+                    .map(|delivery| delivery.session.as_str())
+                    .collect();
+                if failed.is_empty() {
+                    Ok(())
+                } else {
+                    Err(Error::Broadcast(format!(
+                        "'{}' failed in {}",
+                        command.join(" "),
+                        failed.join(", ")
+                    )))
+                }
+            }
+        },
+
         Commands::Start {
             program,
             args,
             adapter,
             stop_on_entry,
-            initial_breakpoints,
+            mut initial_breakpoints,
+            no_init,
         } => {
+            let mut init_plan = if no_init { None } else { init::load()? };
+            if let Some(plan) = &init_plan {
+                initial_breakpoints.extend(plan.breakpoints.iter().cloned());
+            }
+
             spawn::ensure_daemon_running().await?;
             let mut client = DaemonClient::connect().await?;
+            // Rules `.dbginit` sets replace remembered ones for the same path
+            if let Err(e) = sourcepath::restore(&mut client).await {
+                eprintln!("Warning: could not restore substitute-path rules: {}", e);
+            }
+            if let Some(plan) = &mut init_plan {
+                run_init_commands(std::mem::take(&mut plan.settings)).await;
+            }
 
             let program = program.canonicalize().unwrap_or(program);
 
@@ -46,31 +281,83 @@ pub async fn dispatch(command: Commands) -> Result<()> {
                 })
                 .await?;
 
-            println!("Started debugging: {}", program.display());
+            if json {
+                output::emit(
+                    name,
+                    json!({
+                        "program": program.display().to_string(),
+                        "initial_breakpoints": initial_breakpoints,
+                        "stop_on_entry": stop_on_entry,
+                    }),
+                )?;
+            } else if !output::is_quiet() {
+                println!("Started debugging: {}", program.display());
+
+                if has_initial_breakpoints {
+                    println!("Set {} initial breakpoint(s)", initial_breakpoints.len());
+                }
+
+                if stop_on_entry {
+                    println!("Stopped at entry point. Use 'debugger continue' to run.");
+                } else if has_initial_breakpoints {
+                    println!("Program is running. It will stop when an initial breakpoint is hit.");
+                } else {
+                    println!("Program is running. Use 'debugger await' to wait for a stop.");
+                }
+            }
 
-            if has_initial_breakpoints {
-                println!("Set {} initial breakpoint(s)", initial_breakpoints.len());
+            if let Some(plan) = init_plan {
+                run_init_commands(plan.commands).await;
             }
 
-            if stop_on_entry {
-                println!("Stopped at entry point. Use 'debugger continue' to run.");
-            } else if has_initial_breakpoints {
-                println!("Program is running. It will stop when an initial breakpoint is hit.");
-            } else {
-                println!("Program is running. Use 'debugger await' to wait for a stop.");
+            Ok(())
+        }
+
+        Commands::Attach {
+            pid: None,
+            remote: Some(target),
+            program: Some(program),
+            adapter,
+        } => {
+            spawn::ensure_daemon_running().await?;
+            let mut client = DaemonClient::connect().await?;
+
+            let program = program.canonicalize().unwrap_or(program);
+            let result = client
+                .send_command(Command::AttachRemote {
+                    target,
+                    program,
+                    adapter,
+                })
+                .await?;
+
+            if json {
+                output::emit(name, &result)?;
+            } else if !output::is_quiet() {
+                println!(
+                    "Attached to {} under {}",
+                    result["program"].as_str().unwrap_or_default(),
+                    result["target"].as_str().unwrap_or_default()
+                );
+                println!("Program is stopped. Use 'debugger continue' to run.");
             }
 
             Ok(())
         }
 
-        Commands::Attach { pid, adapter } => {
+        Commands::Attach { pid, adapter, .. } => {
+            let pid = pid.ok_or_else(|| Error::Config("attach needs a PID".to_string()))?;
             spawn::ensure_daemon_running().await?;
             let mut client = DaemonClient::connect().await?;
 
             client.send_command(Command::Attach { pid, adapter }).await?;
 
-            println!("Attached to process {}", pid);
-            println!("Program is stopped. Use 'debugger continue' to run.");
+            if json {
+                output::emit(name, json!({ "pid": pid }))?;
+            } else if !output::is_quiet() {
+                println!("Attached to process {}", pid);
+                println!("Program is stopped. Use 'debugger continue' to run.");
+            }
 
             Ok(())
         }
@@ -81,21 +368,7 @@ pub async fn dispatch(command: Commands) -> Result<()> {
                 condition,
                 hit_count,
             } => {
-                let mut client = DaemonClient::connect().await?;
-                let loc = BreakpointLocation::parse(&location)?;
-
-                let result = client
-                    .send_command(Command::BreakpointAdd {
-                        location: loc,
-                        condition,
-                        hit_count,
-                    })
-                    .await?;
-
-                let info: BreakpointInfo = serde_json::from_value(result)?;
-                print_breakpoint_added(&info);
-
-                Ok(())
+                add_breakpoint(name, &location, condition, hit_count).await
             }
 
             BreakpointCommands::Remove { id, all } => {
@@ -105,10 +378,12 @@ pub async fn dispatch(command: Commands) -> Result<()> {
                     .send_command(Command::BreakpointRemove { id, all })
                     .await?;
 
-                if all {
-                    println!("All breakpoints removed");
+                if json {
+                    output::emit(name, json!({ "removed": id, "all": all }))?;
+                } else if all {
+                    println!("All breakpoints removed ('undo' restores them)");
                 } else if let Some(id) = id {
-                    println!("Breakpoint {} removed", id);
+                    println!("Breakpoint {} removed ('undo' restores it)", id);
                 }
 
                 Ok(())
@@ -121,7 +396,9 @@ pub async fn dispatch(command: Commands) -> Result<()> {
                 let breakpoints: Vec<BreakpointInfo> =
                     serde_json::from_value(result["breakpoints"].clone())?;
 
-                if breakpoints.is_empty() {
+                if json {
+                    output::emit(name, json!({ "breakpoints": breakpoints }))?;
+                } else if breakpoints.is_empty() {
                     println!("No breakpoints set");
                 } else {
                     println!("Breakpoints:");
@@ -138,7 +415,11 @@ pub async fn dispatch(command: Commands) -> Result<()> {
                 client
                     .send_command(Command::BreakpointEnable { id })
                     .await?;
-                println!("Breakpoint {} enabled", id);
+                if json {
+                    output::emit(name, json!({ "id": id, "enabled": true }))?;
+                } else {
+                    println!("Breakpoint {} enabled", id);
+                }
                 Ok(())
             }
 
@@ -147,115 +428,1000 @@ pub async fn dispatch(command: Commands) -> Result<()> {
                 client
                     .send_command(Command::BreakpointDisable { id })
                     .await?;
-                println!("Breakpoint {} disabled", id);
+                if json {
+                    output::emit(name, json!({ "id": id, "enabled": false }))?;
+                } else {
+                    println!("Breakpoint {} disabled", id);
+                }
                 Ok(())
             }
         },
 
-        Commands::Break {
-            location,
-            condition,
-            hit_count,
-        } => {
-            // Shorthand for breakpoint add
+        Commands::Watch(watch_cmd) => match watch_cmd {
+            WatchCommands::Add { expression } => {
+                // Watches live in the daemon, so they can be set up before a session
+                spawn::ensure_daemon_running().await?;
+                let mut client = DaemonClient::connect().await?;
+                let result = client
+                    .send_command(Command::WatchAdd {
+                        expression: expression.clone(),
+                    })
+                    .await?;
+
+                if json {
+                    output::emit(name, &result)?;
+                } else {
+                    println!("Watch {}: {}", result["id"], expression);
+                }
+                Ok(())
+            }
+
+            WatchCommands::Remove { id } => {
+                let mut client = DaemonClient::connect().await?;
+                client.send_command(Command::WatchRemove { id }).await?;
+
+                if json {
+                    output::emit(name, json!({ "removed": id }))?;
+                } else {
+                    println!("Watch {} removed", id);
+                }
+                Ok(())
+            }
+
+            WatchCommands::List => {
+                let mut client = DaemonClient::connect().await?;
+                let result = client.send_command(Command::WatchList).await?;
+                let watches: Vec<WatchInfo> = serde_json::from_value(result["watches"].clone())?;
+
+                if json {
+                    output::emit(name, json!({ "watches": watches }))?;
+                } else if watches.is_empty() {
+                    println!("No watches set");
+                } else {
+                    println!("Watches:");
+                    print_watches(&watches, "  ");
+                }
+                Ok(())
+            }
+
+            WatchCommands::History { id } => {
+                let mut client = DaemonClient::connect().await?;
+                let result = client.send_command(Command::WatchHistory { id }).await?;
+
+                if json {
+                    return output::emit(name, &result);
+                }
+
+                let history: Vec<WatchSample> =
+                    serde_json::from_value(result["history"].clone())?;
+                println!(
+                    "Watch {}: {}",
+                    id,
+                    result["expression"].as_str().unwrap_or("?")
+                );
+                if history.is_empty() {
+                    println!("  No values recorded yet");
+                }
+                let mut previous: Option<&str> = None;
+                for sample in &history {
+                    let value = sample.value.as_deref().unwrap_or("<unavailable>");
+                    let changed = previous.is_some_and(|previous| previous != value);
+                    let shown = if changed {
+                        theme::paint(Element::ChangedValue, value)
+                    } else {
+                        value.to_string()
+                    };
+                    println!("  stop {:>3}: {}", sample.stop, shown);
+                    previous = Some(value);
+                }
+                Ok(())
+            }
+        },
+
+        Commands::Sample(sample_cmd) => match sample_cmd {
+            SampleCommands::Add { expression, every } => {
+                // Like watches, samples can be set up before a session
+                spawn::ensure_daemon_running().await?;
+                let mut client = DaemonClient::connect().await?;
+                let result = client
+                    .send_command(Command::SampleAdd {
+                        expression: expression.clone(),
+                        every_ms: every.as_millis() as u64,
+                    })
+                    .await?;
+
+                if json {
+                    output::emit(name, &result)?;
+                } else {
+                    println!(
+                        "Sample {}: {} every {}ms",
+                        result["id"],
+                        expression,
+                        every.as_millis()
+                    );
+                }
+                Ok(())
+            }
+
+            SampleCommands::Remove { id } => {
+                let mut client = DaemonClient::connect().await?;
+                client.send_command(Command::SampleRemove { id }).await?;
+
+                if json {
+                    output::emit(name, json!({ "removed": id }))?;
+                } else {
+                    println!("Sample {} removed", id);
+                }
+                Ok(())
+            }
+
+            SampleCommands::List => {
+                let mut client = DaemonClient::connect().await?;
+                let result = client.send_command(Command::SampleList).await?;
+                let samples: Vec<SamplerInfo> = serde_json::from_value(result["samples"].clone())?;
+
+                if json {
+                    output::emit(name, json!({ "samples": samples }))?;
+                } else if samples.is_empty() {
+                    println!("No samples set");
+                } else {
+                    println!("Samples:");
+                    for sample in &samples {
+                        println!(
+                            "  {}: {} every {}ms, {} values, last {}",
+                            sample.id,
+                            theme::paint(Element::VariableName, &sample.expression),
+                            sample.every_ms,
+                            sample.count,
+                            sample.last.as_deref().unwrap_or("<none>")
+                        );
+                    }
+                }
+                Ok(())
+            }
+
+            SampleCommands::Export { format, file } => {
+                let mut client = DaemonClient::connect().await?;
+                let result = client.send_command(Command::SampleExport).await?;
+                let samples: Vec<SamplerInfo> = serde_json::from_value(result["samples"].clone())?;
+                let values: Vec<SampleValue> = serde_json::from_value(result["values"].clone())?;
+
+                let text = match format {
+                    SampleFormat::Csv => sample::csv(&samples, &values),
+                    SampleFormat::Json => format!("{}\n", serde_json::to_string_pretty(&result)?),
+                };
+                match file {
+                    Some(path) => {
+                        std::fs::write(&path, &text)?;
+                        if json {
+                            output::emit(name, json!({ "path": path, "values": values.len() }))?;
+                        } else {
+                            println!("{} values written to {}", values.len(), path.display());
+                        }
+                    }
+                    None if json => output::emit(name, &result)?,
+                    None => print!("{}", text),
+                }
+                Ok(())
+            }
+
+            SampleCommands::Clear => {
+                let mut client = DaemonClient::connect().await?;
+                client.send_command(Command::SampleClear).await?;
+
+                if json {
+                    output::emit(name, json!({ "cleared": true }))?;
+                } else {
+                    println!("Sampled values cleared");
+                }
+                Ok(())
+            }
+        },
+
+        Commands::Track(track_cmd) => match track_cmd {
+            TrackCommands::Size {
+                expression,
+                every,
+                threshold,
+            } => {
+                // Like samples, trackers can be set up before a session
+                spawn::ensure_daemon_running().await?;
+                let mut client = DaemonClient::connect().await?;
+                let result = client
+                    .send_command(Command::TrackAdd {
+                        expression: expression.clone(),
+                        every_ms: every.map(|every| every.as_millis() as u64),
+                        threshold,
+                    })
+                    .await?;
+
+                if json {
+                    output::emit(name, &result)?;
+                } else {
+                    let when = match every {
+                        Some(every) => format!("every {}ms", every.as_millis()),
+                        None => "at every stop".to_string(),
+                    };
+                    println!("Tracking {}: {} {}", result["id"], expression, when);
+                }
+                Ok(())
+            }
+
+            TrackCommands::Remove { id } => {
+                let mut client = DaemonClient::connect().await?;
+                client.send_command(Command::TrackRemove { id }).await?;
+
+                if json {
+                    output::emit(name, json!({ "removed": id }))?;
+                } else {
+                    println!("Tracked expression {} removed", id);
+                }
+                Ok(())
+            }
+
+            TrackCommands::List => {
+                let mut client = DaemonClient::connect().await?;
+                let result = client.send_command(Command::TrackList).await?;
+                let tracks: Vec<TrackerInfo> = serde_json::from_value(result["tracks"].clone())?;
+
+                if json {
+                    output::emit(name, json!({ "tracks": tracks }))?;
+                } else if tracks.is_empty() {
+                    println!("Nothing tracked");
+                } else {
+                    println!("Tracked sizes:");
+                    for tracker in &tracks {
+                        let when = match tracker.every_ms {
+                            Some(every_ms) => format!("every {}ms", every_ms),
+                            None => "at stops".to_string(),
+                        };
+                        let last = tracker.last.as_ref().map_or("<none>".to_string(), track::size);
+                        let growing = if tracker.growing { ", growing" } else { "" };
+                        println!(
+                            "  {}: {} {}, {} sizes, last {}{}",
+                            tracker.id,
+                            theme::paint(Element::VariableName, &tracker.expression),
+                            when,
+                            tracker.count,
+                            last,
+                            growing
+                        );
+                    }
+                }
+                Ok(())
+            }
+
+            TrackCommands::History { id } => {
+                let mut client = DaemonClient::connect().await?;
+                let result = client.send_command(Command::TrackHistory { id }).await?;
+                let sizes: Vec<TrackSize> = serde_json::from_value(result["sizes"].clone())?;
+
+                if json {
+                    output::emit(name, &result)?;
+                } else if sizes.is_empty() {
+                    println!("{} not measured yet", result["expression"].as_str().unwrap_or(""));
+                } else {
+                    println!("{}:", result["expression"].as_str().unwrap_or(""));
+                    for size in &sizes {
+                        println!("  stop {} at {}: {}", size.stop, size.time, track::size(size));
+                    }
+                }
+                Ok(())
+            }
+        },
+
+        Commands::Trace(trace_cmd) => match trace_cmd {
+            TraceCommands::Functions { pattern, follow } => {
+                let mut client = DaemonClient::connect().await?;
+                let since = trace::last_entry(&mut client).await?;
+                let result = client
+                    .send_command(Command::TraceFunctions {
+                        pattern: pattern.clone(),
+                    })
+                    .await?;
+
+                if json {
+                    output::emit(name, &result)?;
+                } else if !output::is_quiet() {
+                    let functions = result["functions"].as_array().map_or(0, Vec::len);
+                    println!("Tracing {} function(s) matching '{}'", functions, pattern);
+                }
+                if follow {
+                    trace::follow(name, since).await?;
+                }
+                Ok(())
+            }
+
+            TraceCommands::Syscalls { filter, follow } => {
+                let mut client = DaemonClient::connect().await?;
+                let since = trace::last_entry(&mut client).await?;
+                let result = client
+                    .send_command(Command::TraceSyscalls {
+                        filter: filter.clone(),
+                    })
+                    .await?;
+
+                if json {
+                    output::emit(name, &result)?;
+                } else if !output::is_quiet() {
+                    let which = filter.as_deref().unwrap_or("all");
+                    if result["returns"].as_bool().unwrap_or(false) {
+                        println!("Tracing syscalls ({})", which);
+                    } else {
+                        println!(
+                            "Tracing syscalls ({}) from /proc: only calls that block are seen, \
+                             without their results",
+                            which
+                        );
+                    }
+                }
+                if follow {
+                    trace::follow(name, since).await?;
+                }
+                Ok(())
+            }
+
+            TraceCommands::Log { follow, tail } => {
+                if follow {
+                    return trace::follow(name, 0).await;
+                }
+                let mut client = DaemonClient::connect().await?;
+                let result = client.send_command(Command::TraceLog { since: 0 }).await?;
+                let mut entries: Vec<TraceEntry> =
+                    serde_json::from_value(result["entries"].clone())?;
+                if let Some(tail) = tail {
+                    entries.drain(..entries.len().saturating_sub(tail));
+                }
+
+                if json {
+                    output::emit(name, json!({ "entries": entries }))?;
+                } else if entries.is_empty() {
+                    println!("No calls traced");
+                } else {
+                    for entry in &entries {
+                        println!("{}", trace::render(entry));
+                    }
+                }
+                Ok(())
+            }
+
+            TraceCommands::Stop => {
+                let mut client = DaemonClient::connect().await?;
+                let result = client.send_command(Command::TraceStop).await?;
+
+                if json {
+                    output::emit(name, &result)?;
+                } else {
+                    println!("Stopped tracing {} function(s)", result["functions"]);
+                    if result["syscalls"].as_bool().unwrap_or(false) {
+                        println!("Stopped tracing syscalls");
+                    }
+                }
+                Ok(())
+            }
+
+            TraceCommands::Clear => {
+                let mut client = DaemonClient::connect().await?;
+                client.send_command(Command::TraceClear).await?;
+
+                if json {
+                    output::emit(name, json!({ "cleared": true }))?;
+                } else {
+                    println!("Trace log cleared");
+                }
+                Ok(())
+            }
+        },
+
+        Commands::Record(record_cmd) => match record_cmd {
+            RecordCommands::Start { at, limit, over } => {
+                let at = at
+                    .iter()
+                    .map(|location| BreakpointLocation::parse(location))
+                    .collect::<Result<Vec<_>>>()?;
+                let mut client = DaemonClient::connect().await?;
+                let result = client
+                    .send_command(Command::RecordStart { at, limit, over })
+                    .await?;
+
+                if json {
+                    output::emit(name, &result)?;
+                } else if result["recording"].as_bool().unwrap_or(false) {
+                    println!(
+                        "Recording up to {} steps; 'await' returns when recording ends",
+                        limit
+                    );
+                } else {
+                    println!("Recorded 1 step");
+                }
+                Ok(())
+            }
+
+            RecordCommands::Stop => {
+                let mut client = DaemonClient::connect().await?;
+                let result = client.send_command(Command::RecordStop).await?;
+
+                if json {
+                    output::emit(name, &result)?;
+                } else if result["stopped"].as_bool().unwrap_or(false) {
+                    println!("Stopped recording");
+                } else {
+                    println!("No recording in progress");
+                }
+                Ok(())
+            }
+        },
+
+        Commands::Replay(replay_cmd) => match replay_cmd {
+            ReplayCommands::List { tail } => {
+                let mut client = DaemonClient::connect().await?;
+                let mut result = client.send_command(Command::ReplayList).await?;
+                if let (Some(tail), Some(steps)) = (tail, result["steps"].as_array_mut()) {
+                    steps.drain(..steps.len().saturating_sub(tail));
+                }
+
+                if json {
+                    return output::emit(name, &result);
+                }
+                let steps = result["steps"].as_array().cloned().unwrap_or_default();
+                if steps.is_empty() {
+                    println!("Nothing recorded");
+                }
+                let cursor = result["cursor"].as_u64();
+                for step in &steps {
+                    println!("{}", replay::render(step, cursor));
+                }
+                if result["recording"].as_bool().unwrap_or(false) {
+                    println!("Still recording");
+                } else if let Some(ended) = result["ended"].as_str() {
+                    println!("Recording ended: {}", ended);
+                }
+                Ok(())
+            }
+
+            ReplayCommands::Seek { step } => {
+                let mut client = DaemonClient::connect().await?;
+                let result = client
+                    .send_command(Command::ReplaySeek {
+                        step: step.step,
+                        relative: step.relative,
+                    })
+                    .await?;
+
+                let step: RecordedStep = serde_json::from_value(result)?;
+                if json {
+                    return output::emit(name, step);
+                }
+                match step.frames.first() {
+                    Some(frame) => println!(
+                        "Step {}: {} at {}:{}",
+                        step.step,
+                        frame.name,
+                        frame.source.as_deref().unwrap_or("?"),
+                        frame.line.map_or("?".to_string(), |line| line.to_string())
+                    ),
+                    None => println!("Step {}", step.step),
+                }
+                Ok(())
+            }
+
+            ReplayCommands::Exit => {
+                let mut client = DaemonClient::connect().await?;
+                let result = client.send_command(Command::ReplayExit).await?;
+
+                if json {
+                    output::emit(name, &result)?;
+                } else if result["replaying"].as_bool().unwrap_or(false) {
+                    println!("Showing the program again");
+                } else {
+                    println!("Not replaying");
+                }
+                Ok(())
+            }
+        },
+
+        Commands::Profile(profile_cmd) => match profile_cmd {
+            ProfileCommands::Start { hz } => {
+                let mut client = DaemonClient::connect().await?;
+                let result = client.send_command(Command::ProfileStart { hz }).await?;
+
+                if json {
+                    output::emit(name, &result)?;
+                } else {
+                    println!("Profiling at {} Hz; 'profile flamegraph FILE' draws it", hz);
+                }
+                Ok(())
+            }
+
+            ProfileCommands::Stop => {
+                let mut client = DaemonClient::connect().await?;
+                let result = client.send_command(Command::ProfileStop).await?;
+
+                if json {
+                    output::emit(name, &result)?;
+                } else if result["stopped"].as_bool().unwrap_or(false) {
+                    println!("Stopped profiling");
+                } else {
+                    println!("Not profiling");
+                }
+                Ok(())
+            }
+
+            ProfileCommands::Flamegraph { file } => {
+                let mut client = DaemonClient::connect().await?;
+                let result = client.send_command(Command::ProfileData).await?;
+                let stacks: Vec<ProfileStack> = serde_json::from_value(result["stacks"].clone())?;
+                if stacks.is_empty() {
+                    return Err(Error::Profile(
+                        "no stacks sampled yet; see 'profile start'".to_string(),
+                    ));
+                }
+
+                let title = format!(
+                    "Flame graph: {} samples at {} Hz",
+                    result["samples"], result["hz"]
+                );
+                std::fs::write(&file, profile::flamegraph(&stacks, &title))?;
+                if json {
+                    output::emit(
+                        name,
+                        json!({
+                            "path": file,
+                            "samples": result["samples"],
+                            "stacks": stacks.len(),
+                        }),
+                    )?;
+                } else {
+                    println!(
+                        "Flame graph of {} samples written to {}",
+                        result["samples"],
+                        file.display()
+                    );
+                }
+                Ok(())
+            }
+
+            ProfileCommands::Folded { file } => {
+                let mut client = DaemonClient::connect().await?;
+                let result = client.send_command(Command::ProfileData).await?;
+                let stacks: Vec<ProfileStack> = serde_json::from_value(result["stacks"].clone())?;
+
+                let text = profile::folded(&stacks);
+                match file {
+                    Some(path) => {
+                        std::fs::write(&path, &text)?;
+                        if json {
+                            output::emit(name, json!({ "path": path, "stacks": stacks.len() }))?;
+                        } else {
+                            println!("{} stacks written to {}", stacks.len(), path.display());
+                        }
+                    }
+                    None if json => output::emit(name, &result)?,
+                    None => print!("{}", text),
+                }
+                Ok(())
+            }
+        },
+
+        Commands::Coverage(coverage_cmd) => match coverage_cmd {
+            CoverageCommands::Start { file } => {
+                let mut client = DaemonClient::connect().await?;
+                let result = client.send_command(Command::CoverageStart { file }).await?;
+
+                if json {
+                    output::emit(name, &result)?;
+                } else {
+                    println!(
+                        "Covering {} lines in {} files; 'coverage report' shows which ran",
+                        result["lines"], result["files"]
+                    );
+                }
+                Ok(())
+            }
+
+            CoverageCommands::Stop => {
+                let mut client = DaemonClient::connect().await?;
+                let result = client.send_command(Command::CoverageStop).await?;
+
+                if json {
+                    output::emit(name, &result)?;
+                } else if result["stopped"].as_bool().unwrap_or(false) {
+                    println!("Stopped covering");
+                } else {
+                    println!("Coverage is not running");
+                }
+                Ok(())
+            }
+
+            CoverageCommands::Report { format, file } => {
+                let mut client = DaemonClient::connect().await?;
+                let result = client.send_command(Command::CoverageData).await?;
+                let files: Vec<FileCoverage> = serde_json::from_value(result["files"].clone())?;
+                if files.is_empty() {
+                    return Err(Error::Coverage(
+                        "nothing covered yet; see 'coverage start'".to_string(),
+                    ));
+                }
+
+                let text = match format {
+                    CoverageFormat::Text => coverage::text(&files),
+                    CoverageFormat::Lcov => coverage::lcov(&files),
+                };
+                match file {
+                    Some(path) => {
+                        std::fs::write(&path, &text)?;
+                        if json {
+                            output::emit(name, json!({ "path": path, "files": files.len() }))?;
+                        } else {
+                            println!(
+                                "Coverage of {} files written to {}",
+                                files.len(),
+                                path.display()
+                            );
+                        }
+                    }
+                    None if json => output::emit(name, &result)?,
+                    None => print!("{}", text),
+                }
+                Ok(())
+            }
+        },
+
+        Commands::Analyze(AnalyzeCommands::Hotpath {
+            from,
+            to,
+            step,
+            max_steps,
+            top,
+            timeout,
+        }) => {
             let mut client = DaemonClient::connect().await?;
-            let loc = BreakpointLocation::parse(&location)?;
+            let pace = hotpath::Pace {
+                step,
+                max_steps,
+                timeout,
+            };
+            let report = hotpath::run(&mut client, &from, &to, pace, top).await?;
 
-            let result = client
-                .send_command(Command::BreakpointAdd {
-                    location: loc,
-                    condition,
-                    hit_count,
-                })
-                .await?;
+            if json {
+                output::emit(name, &report)?;
+            } else {
+                print!("{}", hotpath::text(&report));
+            }
+            Ok(())
+        }
+
+        Commands::Heap(heap_cmd) => match heap_cmd {
+            HeapCommands::Snapshot => {
+                let mut client = DaemonClient::connect().await?;
+                let result = client.send_command(Command::HeapSnapshot).await?;
+
+                if json {
+                    output::emit(name, &result)?;
+                } else {
+                    let location = result["location"].as_str();
+                    println!(
+                        "Snapshot {}: {} counters{}",
+                        result["snapshot"],
+                        result["counters"],
+                        location.map(|at| format!(" in {}", at)).unwrap_or_default()
+                    );
+                }
+                Ok(())
+            }
 
-            let info: BreakpointInfo = serde_json::from_value(result)?;
-            print_breakpoint_added(&info);
+            HeapCommands::Diff { from, to, top } => {
+                let mut client = DaemonClient::connect().await?;
+                let result = client.send_command(Command::HeapDiff { from, to }).await?;
+                if json {
+                    return output::emit(name, &result);
+                }
 
+                let changes: Vec<HeapChange> = serde_json::from_value(result["changes"].clone())?;
+                println!(
+                    "Snapshot {} (stop {}) to {} (stop {}): {} counters changed",
+                    result["from"]["snapshot"],
+                    result["from"]["stop"],
+                    result["to"]["snapshot"],
+                    result["to"]["stop"],
+                    changes.len()
+                );
+                for change in changes.iter().take(top) {
+                    println!(
+                        "{:>+14}  {}  ({} -> {})",
+                        change.change, change.counter, change.before, change.after
+                    );
+                }
+                if changes.len() > top {
+                    println!("... and {} more; raise --top to see them", changes.len() - top);
+                }
+                Ok(())
+            }
+        },
+
+        Commands::Timer(timer_cmd) => {
+            let mut client = DaemonClient::connect().await?;
+            let command = match timer_cmd {
+                TimerCommands::Between { from, to } => Command::TimerStart { from, to },
+                TimerCommands::Stop => Command::TimerStop,
+                TimerCommands::Report => Command::TimerData,
+            };
+            let result = client.send_command(command).await?;
+
+            if json {
+                output::emit(name, &result)?;
+            } else if let Some(stopped) = result["stopped"].as_bool() {
+                println!("{}", if stopped { "Stopped timing" } else { "The timer is not running" });
+            } else {
+                print!("{}", timer::text(&result));
+            }
             Ok(())
         }
 
-        Commands::Continue => {
+        Commands::Btrace(btrace_cmd) => {
             let mut client = DaemonClient::connect().await?;
-            client.send_command(Command::Continue).await?;
-            println!("Continuing execution...");
+            match btrace_cmd {
+                BtraceCommands::Start { format } => {
+                    let result = client.send_command(Command::BtraceStart { format }).await?;
+                    if json {
+                        output::emit(name, &result)?;
+                    } else if !output::is_quiet() {
+                        println!("Recording branches; 'btrace list' shows the path to a stop");
+                    }
+                }
+                BtraceCommands::Stop => {
+                    let result = client.send_command(Command::BtraceStop).await?;
+                    if json {
+                        output::emit(name, &result)?;
+                    } else if !output::is_quiet() {
+                        println!("Stopped recording branches");
+                    }
+                }
+                BtraceCommands::List {
+                    instructions,
+                    count,
+                } => {
+                    let result = client
+                        .send_command(Command::BtraceList {
+                            instructions,
+                            count,
+                        })
+                        .await?;
+                    if json {
+                        return output::emit(name, &result);
+                    }
+                    let recorded = &result["recorded"];
+                    println!(
+                        "{}: {} instructions in {} function segments, {} gaps",
+                        result["format"].as_str().unwrap_or("Branch trace"),
+                        recorded["instructions"],
+                        recorded["functions"],
+                        recorded["gaps"]
+                    );
+                    if instructions {
+                        let shown: Vec<BtraceInstruction> =
+                            serde_json::from_value(result["instructions"].clone())?;
+                        print!("{}", btrace::instructions(&shown));
+                    } else {
+                        let calls: Vec<BtraceCall> =
+                            serde_json::from_value(result["calls"].clone())?;
+                        print!("{}", btrace::calls(&calls));
+                    }
+                }
+            }
             Ok(())
         }
 
-        Commands::Next => {
+        Commands::Symbols(SymbolsCommands::Add { file }) => {
+            let file = file.canonicalize().unwrap_or(file);
             let mut client = DaemonClient::connect().await?;
-            client.send_command(Command::Next).await?;
-            println!("Stepping over...");
+            let result = client.send_command(Command::SymbolsAdd { file: file.clone() }).await?;
+
+            if json {
+                output::emit(name, &result)?;
+            } else if !output::is_quiet() {
+                println!(
+                    "Reading the program's symbols from {}: {} functions, {} source files",
+                    file.display(),
+                    result["functions"],
+                    result["files"]
+                );
+                if result["adapter"] == false {
+                    println!("The adapter isn't told; find, dwarf, trace and coverage read it");
+                }
+            }
             Ok(())
         }
 
-        Commands::Step => {
+        Commands::Core(CoreCommands::Open {
+            core,
+            program,
+            adapter,
+            slide,
+        }) => {
+            spawn::ensure_daemon_running().await?;
             let mut client = DaemonClient::connect().await?;
-            client.send_command(Command::StepIn).await?;
-            println!("Stepping into...");
+
+            let program = program.canonicalize().unwrap_or(program);
+            let result = client
+                .send_command(Command::CoreOpen {
+                    program,
+                    core,
+                    adapter,
+                    slide,
+                })
+                .await?;
+
+            if json {
+                output::emit(name, &result)?;
+            } else if !output::is_quiet() {
+                println!(
+                    "Opened core dump {} of {}",
+                    result["core"].as_str().unwrap_or_default(),
+                    result["program"].as_str().unwrap_or_default()
+                );
+                println!("The program is stopped where it dumped core and cannot be resumed.");
+            }
             Ok(())
         }
 
-        Commands::Finish => {
-            let mut client = DaemonClient::connect().await?;
-            client.send_command(Command::StepOut).await?;
-            println!("Stepping out...");
-            Ok(())
+        Commands::Core(CoreCommands::Diff {
+            first,
+            second,
+            program,
+            adapter,
+            expressions,
+        }) => {
+            spawn::ensure_daemon_running().await?;
+            let mut client = DaemonClient::connect().await?;
+
+            let program = program.canonicalize().unwrap_or(program);
+            let cores = [first.as_path(), second.as_path()];
+            let report = coredump::diff(&mut client, &program, cores, adapter, &expressions).await?;
+
+            if json {
+                output::emit(name, &report)?;
+            } else {
+                print!("{}", coredump::text(&report));
+            }
+            Ok(())
+        }
+
+        Commands::Break {
+            location,
+            condition,
+            hit_count,
+        } => {
+            // Shorthand for breakpoint add
+            add_breakpoint(name, &location, condition, hit_count).await
+        }
+
+        Commands::Undo => {
+            let mut client = DaemonClient::connect().await?;
+            let result = client.send_command(Command::BreakpointUndo).await?;
+            if json {
+                return output::emit(name, result);
+            }
+
+            let ids: Vec<u32> = serde_json::from_value(result["breakpoints"].clone())?;
+            let ids: Vec<String> = ids.iter().map(u32::to_string).collect();
+            let noun = if ids.len() == 1 { "Breakpoint" } else { "Breakpoints" };
+            println!(
+                "{} {} {}",
+                noun,
+                ids.join(", "),
+                result["undone"].as_str().unwrap_or("restored")
+            );
+            Ok(())
+        }
+
+        Commands::Continue { timeout } => {
+            resume(name, Command::Continue, "Continuing execution...", timeout).await
+        }
+
+        Commands::Next { timeout } => {
+            resume(name, Command::Next, "Stepping over...", timeout).await
+        }
+
+        Commands::Step { timeout } => {
+            resume(name, Command::StepIn, "Stepping into...", timeout).await
+        }
+
+        Commands::Finish { timeout } => {
+            resume(name, Command::StepOut, "Stepping out...", timeout).await
         }
 
+        Commands::WatchChange { watch } => step_until(name, watch, until::Trigger::Change).await,
+
+        Commands::BreakWhen { watch } => step_until(name, watch, until::Trigger::True).await,
+
         Commands::Pause => {
             let mut client = DaemonClient::connect().await?;
             client.send_command(Command::Pause).await?;
-            println!("Pausing execution...");
+            print_message(name, "Pausing execution...")?;
             Ok(())
         }
 
-        Commands::Backtrace { limit, locals } => {
+        Commands::Backtrace {
+            limit,
+            start,
+            more,
+            locals,
+        } => {
             let mut client = DaemonClient::connect().await?;
 
-            let result = client
+            // Text is printed a page at a time, as the frames are unwound
+            let page = if json { limit } else { limit.min(BACKTRACE_PAGE) };
+            let mut result = client
                 .send_command(Command::StackTrace {
                     thread_id: None,
-                    limit,
+                    limit: page,
+                    start,
+                    more,
                 })
                 .await?;
+            // `--more` starts wherever the last backtrace stopped
+            let start = result["start"].as_u64().map_or(start, |start| start as usize);
+
+            if json {
+                let frames: Vec<StackFrameInfo> =
+                    serde_json::from_value(result["frames"].clone())?;
+                let mut entries = Vec::with_capacity(frames.len());
+                for frame in &frames {
+                    let mut entry = serde_json::to_value(frame)?;
+                    if locals {
+                        entry["locals"] = json!(frame_locals(&mut client, frame.id).await);
+                    }
+                    entries.push(entry);
+                }
+                return output::emit(
+                    name,
+                    json!({ "frames": entries, "start": start, "more": result["more"] }),
+                );
+            }
 
-            let frames: Vec<StackFrameInfo> = serde_json::from_value(result["frames"].clone())?;
-
-            if frames.is_empty() {
-                println!("No stack frames");
-            } else {
-                for (i, frame) in frames.iter().enumerate() {
+            replay::banner(&result);
+            let mut shown = 0;
+            loop {
+                let frames: Vec<StackFrameInfo> =
+                    serde_json::from_value(result["frames"].clone())?;
+                let more = result["more"].as_bool().unwrap_or(false);
+                if shown == 0 && frames.is_empty() {
+                    println!("No stack frames");
+                    break;
+                }
+                for frame in &frames {
                     let source = frame.source.as_deref().unwrap_or("?");
                     let line = frame.line.map(|l| l.to_string()).unwrap_or_else(|| "?".to_string());
-                    println!("#{} {} at {}:{}", i, frame.name, source, line);
+                    println!("#{} {} at {}:{}", start + shown, frame.name, source, line);
+                    shown += 1;
 
                     if locals {
-                        // Get locals for this frame
-                        let locals_result = client
-                            .send_command(Command::Locals {
-                                frame_id: Some(frame.id),
-                            })
-                            .await;
-
-                        if let Ok(result) = locals_result {
-                            if let Ok(vars) =
-                                serde_json::from_value::<Vec<VariableInfo>>(result["variables"].clone())
-                            {
-                                for var in vars {
-                                    println!(
-                                        "    {} = {}{}",
-                                        var.name,
-                                        var.value,
-                                        var.type_name
-                                            .map(|t| format!(" ({})", t))
-                                            .unwrap_or_default()
-                                    );
-                                }
-                            }
+                        for var in frame_locals(&mut client, frame.id).await {
+                            println!("    {}", format_variable(&var));
                         }
                     }
                 }
+                if !more || frames.is_empty() {
+                    break;
+                }
+                if shown >= limit {
+                    let next = start + shown;
+                    println!(
+                        "... more frames; see 'backtrace --more' or 'backtrace --start {}'",
+                        next
+                    );
+                    break;
+                }
+                std::io::Write::flush(&mut std::io::stdout())?;
+                result = client
+                    .send_command(Command::StackTrace {
+                        thread_id: None,
+                        limit: page.min(limit - shown),
+                        start: start + shown,
+                        more: false,
+                    })
+                    .await?;
             }
 
             Ok(())
@@ -270,71 +1436,143 @@ pub async fn dispatch(command: Commands) -> Result<()> {
 
             let vars: Vec<VariableInfo> = serde_json::from_value(result["variables"].clone())?;
 
-            if vars.is_empty() {
+            if json {
+                output::emit(name, json!({ "variables": vars }))?;
+            } else if vars.is_empty() {
+                replay::banner(&result);
                 println!("No local variables");
             } else {
+                replay::banner(&result);
                 println!("Local variables:");
                 for var in &vars {
-                    println!(
-                        "  {} = {}{}",
-                        var.name,
-                        var.value,
-                        var.type_name
-                            .as_ref()
-                            .map(|t| format!(" ({})", t))
-                            .unwrap_or_default()
-                    );
+                    println!("  {}", format_variable(var));
                 }
             }
 
             Ok(())
         }
 
-        Commands::Print { expression } => {
+        Commands::Print {
+            expression,
+            timeout,
+            limit,
+        } => {
             let mut client = DaemonClient::connect().await?;
+            client.set_timeout(timeout);
 
-            let result = client
+            let mut expression = expression;
+            let result = match client
                 .send_command(Command::Evaluate {
                     expression: expression.clone(),
                     frame_id: None,
                     context: EvaluateContext::Watch,
+                    limit,
                 })
-                .await?;
+                .await
+            {
+                Ok(result) => result,
+                Err(e) if !json => {
+                    let suggestions = suggest::variable(&mut client, &expression).await;
+                    let Some(first) = suggestions.first().cloned() else {
+                        return Err(e);
+                    };
+                    // A declined offer leaves the error for main to report
+                    eprintln!("{}", suggest::did_you_mean(&suggestions));
+                    if !suggest::confirm(&format!("Print '{}' instead?", first)) {
+                        return Err(e);
+                    }
+                    expression = first;
+                    client
+                        .send_command(Command::Evaluate {
+                            expression: expression.clone(),
+                            frame_id: None,
+                            context: EvaluateContext::Watch,
+                            limit,
+                        })
+                        .await?
+                }
+                Err(e) => return Err(e),
+            };
 
+            if !json {
+                replay::banner(&result);
+            }
             let eval: EvaluateResult = serde_json::from_value(result)?;
+            if json {
+                return output::emit(name, json!({ "expression": expression, "value": eval }));
+            }
             println!(
-                "{} = {}{}",
-                expression,
-                eval.result,
-                eval.type_name.map(|t| format!(" ({})", t)).unwrap_or_default()
+                "{}",
+                format_value(&expression, &eval.result, eval.type_name.as_deref())
             );
+            if let Some(at) = eval.truncated_at {
+                println!("{}", truncated(at, "--limit"));
+            }
+
+            Ok(())
+        }
 
+        Commands::Assert { expression } => {
+            let expression = expression.join(" ");
+            let mut client = DaemonClient::connect().await?;
+            let value = assertion::check(&mut client, &expression).await?;
+            if json {
+                output::emit(
+                    name,
+                    json!({ "expression": expression, "passed": true, "value": value }),
+                )?;
+            } else if !output::is_quiet() {
+                println!("Assertion passed: {}", expression);
+            }
             Ok(())
         }
 
-        Commands::Eval { expression } => {
+        Commands::Eval {
+            expression,
+            timeout,
+            limit,
+        } => {
             let mut client = DaemonClient::connect().await?;
+            client.set_timeout(timeout);
 
             let result = client
                 .send_command(Command::Evaluate {
                     expression: expression.clone(),
                     frame_id: None,
                     context: EvaluateContext::Repl,
+                    limit,
                 })
                 .await?;
 
             let eval: EvaluateResult = serde_json::from_value(result)?;
+            if json {
+                return output::emit(name, json!({ "expression": expression, "value": eval }));
+            }
             println!("{}", eval.result);
+            if let Some(at) = eval.truncated_at {
+                println!("{}", truncated(at, "--limit"));
+            }
 
             Ok(())
         }
 
         Commands::Context { lines } => {
+            let settings = fetch_settings().await;
+            let layout = layout::current(&Config::load().unwrap_or_default());
+            let lines = lines
+                .or(layout.size(layout::Pane::Source).flatten())
+                .unwrap_or(settings.listsize);
             let mut client = DaemonClient::connect().await?;
 
             let result = client.send_command(Command::Context { lines }).await?;
 
+            if !json {
+                replay::banner(&result);
+            }
             let ctx: ContextResult = serde_json::from_value(result)?;
+            if json {
+                return output::emit(name, ctx);
+            }
 
             // Print header
             if let Some(source) = &ctx.source {
@@ -348,29 +1586,232 @@ pub async fn dispatch(command: Commands) -> Result<()> {
             }
             println!();
 
-            // Print source with line numbers
-            for line in &ctx.source_lines {
-                let marker = if line.is_current { "->" } else { "  " };
-                println!("{} {:>4} | {}", marker, line.number, line.content);
+            layout::print_panes(&mut client, &layout, &ctx, &settings).await;
+
+            Ok(())
+        }
+
+        Commands::Layout { name: layout_name } => {
+            let config = Config::load()?;
+            let current = match &layout_name {
+                Some(layout_name) => layout::select(layout_name, &config)?,
+                None => layout::current(&config),
+            };
+
+            let layouts = layout::names(&config)
+                .iter()
+                .map(|name| layout::Layout::resolve(name, &config))
+                .collect::<Result<Vec<_>>>()?;
+
+            if json {
+                let layouts: Vec<_> = layouts
+                    .iter()
+                    .map(|layout| {
+                        let panes: Vec<String> =
+                            layout.panes.iter().map(ToString::to_string).collect();
+                        json!({ "name": layout.name, "panes": panes })
+                    })
+                    .collect();
+                return output::emit(name, json!({ "current": current.name, "layouts": layouts }));
             }
 
-            // Print locals
-            if !ctx.locals.is_empty() {
-                println!();
-                println!("Locals:");
-                for var in &ctx.locals {
-                    println!(
-                        "  {} = {}{}",
-                        var.name,
-                        var.value,
-                        var.type_name
-                            .as_ref()
-                            .map(|t| format!(" ({})", t))
-                            .unwrap_or_default()
-                    );
+            if layout_name.is_some() {
+                println!("Layout: {} ({})", current.name, current.describe());
+                return Ok(());
+            }
+
+            for layout in &layouts {
+                let marker = if layout.name == current.name { "*" } else { " " };
+                println!("{} {:<10} {}", marker, layout.name, layout.describe());
+            }
+
+            Ok(())
+        }
+
+        Commands::Disassemble { count } => {
+            let mut client = DaemonClient::connect().await?;
+            let instructions = layout::disassemble(&mut client, count).await?;
+
+            if json {
+                return output::emit(name, json!({ "instructions": instructions }));
+            }
+
+            for line in layout::format_instructions(&instructions) {
+                println!("{}", line);
+            }
+
+            Ok(())
+        }
+
+        Commands::Edit { frame } => {
+            let mut client = DaemonClient::connect().await?;
+
+            let (source, line) = match frame {
+                None => {
+                    let result = client.send_command(Command::Context { lines: 0 }).await?;
+                    let ctx: ContextResult = serde_json::from_value(result)?;
+                    (ctx.source, Some(ctx.line))
+                }
+                Some(n) => {
+                    let result = client
+                        .send_command(Command::StackTrace {
+                            thread_id: None,
+                            limit: n + 1,
+                            start: 0,
+                            more: false,
+                        })
+                        .await?;
+                    let frames: Vec<StackFrameInfo> =
+                        serde_json::from_value(result["frames"].clone())?;
+                    let frame = frames.into_iter().nth(n).ok_or(Error::FrameNotFound(n))?;
+                    (frame.source, frame.line)
+                }
+            };
+            let (Some(source), Some(line)) = (source, line) else {
+                return Err(Error::Editor("the frame has no source location".to_string()));
+            };
+
+            editor::open(&source, line)?;
+            if json {
+                return output::emit(name, json!({ "file": source, "line": line }));
+            }
+            Ok(())
+        }
+
+        Commands::Find { kind, query, limit } => {
+            let mut client = DaemonClient::connect().await?;
+            let query = query.join(" ");
+
+            let result = client
+                .send_command(Command::Find {
+                    kind,
+                    query: query.clone(),
+                    limit,
+                })
+                .await?;
+            let matches: Vec<FindMatch> = serde_json::from_value(result["matches"].clone())?;
+
+            if json {
+                return output::emit(name, json!({ "matches": matches }));
+            }
+
+            let what = match kind {
+                FindKind::Func => "functions",
+                FindKind::File => "files",
+            };
+            if matches.is_empty() {
+                println!("No {} matching '{}'", what, query);
+                return Ok(());
+            }
+
+            // Each result is a ready-made `break` or `context` argument
+            for found in &matches {
+                match (&found.file, found.line) {
+                    (Some(file), Some(line)) => println!(
+                        "  {}  {}:{}",
+                        theme::paint(Element::VariableName, &found.name),
+                        file,
+                        line
+                    ),
+                    _ => println!("  {}", theme::paint(Element::VariableName, &found.name)),
+                }
+            }
+
+            Ok(())
+        }
+
+        Commands::Report(ReportCommands::Generate {
+            template,
+            file,
+            expressions,
+        }) => {
+            let report = report::generate(template.as_deref(), file.as_deref(), &expressions)
+                .await?;
+            match file {
+                Some(file) => {
+                    std::fs::write(&file, &report)?;
+                    if json {
+                        return output::emit(
+                            name,
+                            json!({ "path": file.display().to_string(), "bytes": report.len() }),
+                        );
+                    }
+                    println!("Report written to {}", file.display());
                 }
+                None if json => return output::emit(name, json!({ "report": report })),
+                None => print!("{}", report),
+            }
+            Ok(())
+        }
+
+        Commands::Report(ReportCommands::Data { expressions }) => {
+            let data = report::data(&expressions).await?;
+            if json {
+                return output::emit(name, data);
+            }
+            println!("{}", serde_json::to_string_pretty(&data)?);
+            Ok(())
+        }
+
+        Commands::Dwarf { query } => {
+            let mut client = DaemonClient::connect().await?;
+            let result = client
+                .send_command(Command::Dwarf {
+                    query: query.clone(),
+                })
+                .await?;
+
+            if json {
+                return output::emit(name, result);
             }
+            dwarf::print(&query, &result)
+        }
 
+        Commands::Symbolicate {
+            binary,
+            file,
+            slide,
+        } => {
+            let symbolicator = {
+                // Drawn on stderr, and only there when it is a terminal
+                let bar = (!output::is_quiet()).then(|| {
+                    let bar = indicatif::ProgressBar::new(0);
+                    bar.set_style(
+                        indicatif::ProgressStyle::default_bar()
+                            .template("  Reading debug info [{bar:40.cyan/blue}] {pos}/{len} units")
+                            .unwrap()
+                            .progress_chars("=> "),
+                    );
+                    bar
+                });
+                let _reporting = bar.clone().map(|bar| {
+                    crate::symbols::report_progress(move |done, total| {
+                        bar.set_length(total as u64);
+                        bar.set_position(done as u64);
+                    })
+                });
+                let loaded = crate::symbols::symbolicate::Symbolicator::load(&binary);
+                if let Some(bar) = bar {
+                    bar.finish_and_clear();
+                }
+                loaded?
+            };
+            let log = symbolicate::read(file.as_deref())?;
+            let result = symbolicator.symbolicate(&log, slide);
+
+            if json {
+                return output::emit(
+                    name,
+                    json!({
+                        "binary": binary,
+                        "slide": slide,
+                        "found": result.found,
+                        "resolved": result.resolved,
+                        "lines": result.lines,
+                    }),
+                );
+            }
+            print!("{}", symbolicate::text(&log, &result));
             Ok(())
         }
 
@@ -380,7 +1821,9 @@ pub async fn dispatch(command: Commands) -> Result<()> {
             let result = client.send_command(Command::Threads).await?;
             let threads: Vec<ThreadInfo> = serde_json::from_value(result["threads"].clone())?;
 
-            if threads.is_empty() {
+            if json {
+                output::emit(name, json!({ "threads": threads }))?;
+            } else if threads.is_empty() {
                 println!("No threads");
             } else {
                 println!("Threads:");
@@ -392,6 +1835,49 @@ pub async fn dispatch(command: Commands) -> Result<()> {
             Ok(())
         }
 
+        Commands::Modules => {
+            let mut client = DaemonClient::connect().await?;
+
+            let result = client.send_command(Command::Modules).await?;
+            let modules: Vec<ModuleInfo> = serde_json::from_value(result["modules"].clone())?;
+            let pending = result["pending"].as_u64().unwrap_or(0);
+
+            if json {
+                output::emit(name, json!({ "modules": modules, "pending": pending }))?;
+            } else if modules.is_empty() {
+                println!("No modules");
+            } else {
+                if pending > 0 {
+                    println!("Modules ({} still being read):", pending);
+                } else {
+                    println!("Modules:");
+                }
+                for module in &modules {
+                    let symbols = module.symbols.to_string();
+                    let path = module.path.as_deref().unwrap_or_default();
+                    println!("  {:<13}  {:<24}  {}", symbols, module.name, path);
+                }
+            }
+
+            Ok(())
+        }
+
+        Commands::Info(InfoCommands::Proc(ProcCommands::Slide)) => {
+            let mut client = DaemonClient::connect().await?;
+            let result = client.send_command(Command::ProcSlide).await?;
+
+            if json {
+                output::emit(name, &result)?;
+            } else {
+                let slide: SlideInfo = serde_json::from_value(result)?;
+                println!("Slide:     {:#x}", slide.slide);
+                println!("Linked at: {:#x}", slide.linked_at);
+                println!("Loaded at: {:#x} ({})", slide.loaded_at, slide.source);
+            }
+
+            Ok(())
+        }
+
         Commands::Thread { id } => {
             let mut client = DaemonClient::connect().await?;
 
@@ -399,12 +1885,18 @@ pub async fn dispatch(command: Commands) -> Result<()> {
                 client
                     .send_command(Command::ThreadSelect { id })
                     .await?;
-                println!("Switched to thread {}", id);
+                if json {
+                    output::emit(name, json!({ "selected_thread": id }))?;
+                } else {
+                    println!("Switched to thread {}", id);
+                }
             } else {
                 // Show current thread info
                 let result = client.send_command(Command::Status).await?;
                 let status: StatusResult = serde_json::from_value(result)?;
-                if let Some(thread_id) = status.selected_thread {
+                if json {
+                    output::emit(name, json!({ "selected_thread": status.selected_thread }))?;
+                } else if let Some(thread_id) = status.selected_thread {
                     println!("Current thread: {}", thread_id);
                 } else {
                     println!("No thread selected");
@@ -418,10 +1910,16 @@ pub async fn dispatch(command: Commands) -> Result<()> {
             let mut client = DaemonClient::connect().await?;
 
             if let Some(n) = number {
-                client
+                let result = client
                     .send_command(Command::FrameSelect { number: n })
                     .await?;
-                println!("Switched to frame {}", n);
+                if json {
+                    output::emit(name, result)?;
+                } else {
+                    println!("Switched to frame {}", n);
+                }
+            } else if json {
+                output::emit(name, json!({ "selected": null }))?;
             } else {
                 println!("Current frame: 0 (use 'debugger backtrace' to see all frames)");
             }
@@ -432,6 +1930,9 @@ pub async fn dispatch(command: Commands) -> Result<()> {
         Commands::Up => {
             let mut client = DaemonClient::connect().await?;
             let result = client.send_command(Command::FrameUp).await?;
+            if json {
+                return output::emit(name, result);
+            }
             print_frame_nav_result(&result);
             Ok(())
         }
@@ -439,6 +1940,9 @@ pub async fn dispatch(command: Commands) -> Result<()> {
         Commands::Down => {
             let mut client = DaemonClient::connect().await?;
             let result = client.send_command(Command::FrameDown).await?;
+            if json {
+                return output::emit(name, result);
+            }
             print_frame_nav_result(&result);
             Ok(())
         }
@@ -446,7 +1950,9 @@ pub async fn dispatch(command: Commands) -> Result<()> {
         Commands::Await { timeout } => {
             let mut client = DaemonClient::connect().await?;
 
-            println!("Waiting for program to stop (timeout: {}s)...", timeout);
+            if !json && !output::is_quiet() {
+                println!("Waiting for program to stop (timeout: {}s)...", timeout);
+            }
 
             let result = client
                 .send_command(Command::Await {
@@ -454,11 +1960,23 @@ pub async fn dispatch(command: Commands) -> Result<()> {
                 })
                 .await?;
 
-            // Check if we got a stop result or already stopped
-            if result.get("already_stopped").and_then(|v| v.as_bool()).unwrap_or(false) {
-                let reason = result["reason"].as_str().unwrap_or("unknown");
+            let reason = result.get("reason").and_then(|v| v.as_str()).unwrap_or("unknown");
+            let stopped = !matches!(reason, "exited" | "terminated");
+            batch::record_stop(&result);
+
+            let (pre, post) = if stopped {
+                hooks::fetch(hooks::STOP_EVENT).await
+            } else {
+                (Vec::new(), Vec::new())
+            };
+            hooks::run(HookPhase::Pre, hooks::STOP_EVENT, &pre).await?;
+
+            if json {
+                output::emit(name, &result)?;
+            } else if result.get("already_stopped").and_then(|v| v.as_bool()).unwrap_or(false) {
+                // Check if we got a stop result or already stopped
                 println!("Program was already stopped: {}", reason);
-            } else if let Some(reason) = result.get("reason").and_then(|v| v.as_str()) {
+            } else {
                 match reason {
                     "exited" => {
                         let code = result["exit_code"].as_i64().unwrap_or(0);
@@ -468,16 +1986,90 @@ pub async fn dispatch(command: Commands) -> Result<()> {
                         println!("Program terminated");
                     }
                     _ => {
-                        let stop: StopResult = serde_json::from_value(result)?;
+                        let stop: StopResult = serde_json::from_value(result.clone())?;
                         print_stop_result(&stop);
+                        print_stop_details(&mut client).await;
                     }
                 }
             }
 
+            hooks::run(HookPhase::Post, hooks::STOP_EVENT, &post).await?;
+            events::fire(&mut client, &result).await?;
+
+            Ok(())
+        }
+
+        Commands::Timeline { tail, export } => {
+            let mut client = DaemonClient::connect().await?;
+            let mut result = client.send_command(Command::Timeline).await?;
+            let mut events: Vec<TimelineEvent> = serde_json::from_value(result["events"].clone())?;
+            if let Some(tail) = tail {
+                events.drain(..events.len().saturating_sub(tail));
+                result["events"] = serde_json::to_value(&events)?;
+            }
+
+            if let Some(path) = export {
+                std::fs::write(&path, format!("{}\n", serde_json::to_string_pretty(&result)?))?;
+                if json {
+                    output::emit(name, json!({ "path": path, "events": events.len() }))?;
+                } else {
+                    println!("{} events written to {}", events.len(), path.display());
+                }
+            } else if json {
+                output::emit(name, &result)?;
+            } else if events.is_empty() {
+                println!("Nothing has happened in the session yet");
+            } else {
+                let names: Vec<(i64, String)> = result["threads"]
+                    .as_array()
+                    .into_iter()
+                    .flatten()
+                    .filter_map(|thread| {
+                        Some((thread["id"].as_i64()?, thread["name"].as_str()?.to_string()))
+                    })
+                    .collect();
+                print!("{}", timeline::lanes(&events, &names));
+            }
+            Ok(())
+        }
+
+        Commands::Output {
+            action: Some(OutputCommands::Show { since, tail }),
+            ..
+        } => {
+            let mut client = DaemonClient::connect().await?;
+            let result = client
+                .send_command(Command::GetOutput {
+                    tail,
+                    clear: false,
+                    since_last_stop: since.is_some(),
+                })
+                .await?;
+            if json {
+                return output::emit(name, &result);
+            }
+
+            let lines: Vec<OutputLine> = serde_json::from_value(result["lines"].clone())?;
+            if lines.is_empty() {
+                match since {
+                    Some(_) => println!("(no output since stop {})", result["stop"]),
+                    None => println!("(no output)"),
+                }
+            }
+            let mut stop = 0;
+            for line in &lines {
+                if line.stop != stop {
+                    stop = line.stop;
+                    println!("-- stop {} --", stop);
+                }
+                println!("[{}] {}> {}", line.time, line.stream, line.text);
+            }
             Ok(())
         }
 
-        Commands::Output { follow, tail, clear } => {
+        Commands::Output {
+            follow, tail, clear, ..
+        } => {
             if follow {
                 use std::io::Write;
 
@@ -492,11 +2084,16 @@ pub async fn dispatch(command: Commands) -> Result<()> {
                         .send_command(Command::GetOutput {
                             tail: None,
                             clear: true,
+                            since_last_stop: false,
                         })
                         .await?;
                     let output = result["output"].as_str().unwrap_or("");
                     if !output.is_empty() {
-                        print!("{}", output);
+                        if json {
+                            output::emit(name, json!({ "output": output }))?;
+                        } else {
+                            print!("{}", output);
+                        }
                         std::io::stdout().flush()?;
                     }
 
@@ -504,33 +2101,288 @@ pub async fn dispatch(command: Commands) -> Result<()> {
                 }
             }
 
-            let mut client = DaemonClient::connect().await?;
-            let result = client
-                .send_command(Command::GetOutput { tail, clear })
-                .await?;
-
-            let output = result["output"].as_str().unwrap_or("");
-            if output.is_empty() {
-                println!("(no output)");
-            } else {
-                print!("{}", output);
+            let mut client = DaemonClient::connect().await?;
+            let result = client
+                .send_command(Command::GetOutput {
+                    tail,
+                    clear,
+                    since_last_stop: false,
+                })
+                .await?;
+
+            let output = result["output"].as_str().unwrap_or("");
+            if json {
+                output::emit(name, json!({ "output": output }))?;
+            } else if output.is_empty() {
+                println!("(no output)");
+            } else {
+                print!("{}", output);
+            }
+
+            Ok(())
+        }
+
+        Commands::Transcript(cmd) => {
+            // Recording can begin before the first session
+            if matches!(cmd, TranscriptCommands::Start { .. }) {
+                spawn::ensure_daemon_running().await?;
+            }
+            let mut client = DaemonClient::connect().await?;
+            let result = match cmd {
+                TranscriptCommands::Start { file } => {
+                    // The daemon runs elsewhere; give it an absolute path
+                    let file = std::env::current_dir()?.join(file);
+                    client.send_command(Command::TranscriptStart { path: file }).await?
+                }
+                TranscriptCommands::Stop => client.send_command(Command::TranscriptStop).await?,
+                TranscriptCommands::Annotate { note } => {
+                    client
+                        .send_command(Command::TranscriptAnnotate {
+                            note: note.join(" "),
+                        })
+                        .await?
+                }
+                TranscriptCommands::Status => {
+                    client.send_command(Command::TranscriptStatus).await?
+                }
+            };
+
+            if json {
+                return output::emit(name, result);
+            }
+
+            let path = result["path"].as_str().unwrap_or_default();
+            if result.get("note").is_some() {
+                println!("Note added to the transcript");
+            } else if result["recording"].as_bool().unwrap_or(false) {
+                println!("Recording transcript to {}", path);
+            } else if path.is_empty() {
+                println!("No transcript recording");
+            } else {
+                println!("Transcript saved to {}", path);
+            }
+            Ok(())
+        }
+
+        Commands::RecordMacro(cmd) => match cmd {
+            RecordMacroCommands::Start { name: macro_name } => {
+                macros::validate_name(&macro_name)?;
+                spawn::ensure_daemon_running().await?;
+                let mut client = DaemonClient::connect().await?;
+                client
+                    .send_command(Command::MacroStart {
+                        name: macro_name.clone(),
+                    })
+                    .await?;
+                if json {
+                    output::emit(name, json!({ "recording": true, "name": macro_name }))?;
+                } else {
+                    println!("Recording macro '{}'; 'record-macro stop' saves it", macro_name);
+                }
+                Ok(())
+            }
+            RecordMacroCommands::Stop => {
+                let mut client = DaemonClient::connect().await?;
+                let result = client.send_command(Command::MacroStop).await?;
+                let macro_name = result["name"].as_str().unwrap_or_default().to_string();
+                let commands: Vec<String> = serde_json::from_value(result["commands"].clone())?;
+                let path = macros::save(&macro_name, &commands)?;
+                if json {
+                    output::emit(
+                        name,
+                        json!({
+                            "recording": false,
+                            "name": macro_name,
+                            "path": path,
+                            "commands": commands,
+                        }),
+                    )?;
+                } else {
+                    println!(
+                        "Saved macro '{}' ({} command(s)) to {}",
+                        macro_name,
+                        commands.len(),
+                        path.display()
+                    );
+                }
+                Ok(())
+            }
+        },
+
+        Commands::Macro(cmd) => match cmd {
+            MacroCommands::Run {
+                name: macro_name,
+                continue_on_error,
+                stop_on_error: _,
+                definitions,
+            } => {
+                let path = macros::find(&macro_name)?;
+                let count = batch::source(&path, continue_on_error, &definitions).await?;
+                if json {
+                    output::emit(name, json!({ "name": macro_name, "commands": count }))?;
+                }
+                Ok(())
+            }
+            MacroCommands::List => {
+                let names = macros::list()?;
+                if json {
+                    output::emit(name, json!({ "macros": names }))?;
+                } else if names.is_empty() {
+                    println!("No macros recorded");
+                } else {
+                    for macro_name in &names {
+                        println!("{}", macro_name);
+                    }
+                }
+                Ok(())
+            }
+            MacroCommands::Show { name: macro_name } => {
+                let path = macros::find(&macro_name)?;
+                let commands: Vec<String> = script::load(&path)?
+                    .into_iter()
+                    .map(|command| command.text)
+                    .collect();
+                if json {
+                    output::emit(
+                        name,
+                        json!({ "name": macro_name, "path": path, "commands": commands }),
+                    )?;
+                } else {
+                    for command in &commands {
+                        println!("{}", command);
+                    }
+                }
+                Ok(())
+            }
+            MacroCommands::Delete { name: macro_name } => {
+                let path = macros::find(&macro_name)?;
+                std::fs::remove_file(&path)?;
+                if json {
+                    output::emit(name, json!({ "name": macro_name, "deleted": true }))?;
+                } else {
+                    println!("Deleted macro '{}'", macro_name);
+                }
+                Ok(())
+            }
+        },
+
+        Commands::Define {
+            name: command_name,
+            doc,
+            body,
+        } => {
+            user::validate_name(&command_name)?;
+            let body = match body {
+                Some(body) => body,
+                None => user::read_body(&command_name)?,
+            };
+            let path = user::define(&command_name, doc.as_deref(), &body)?;
+            if json {
+                output::emit(
+                    name,
+                    json!({ "name": command_name, "path": path, "lines": body.len() }),
+                )?;
+            } else if !output::is_quiet() {
+                println!("Defined '{}' in {}", command_name, path.display());
             }
-
             Ok(())
         }
 
-        Commands::Status => {
+        Commands::User(cmd) => match cmd {
+            UserCommands::Run {
+                name: command_name,
+                args,
+            } => {
+                let count = user::run(&command_name, &args).await?;
+                if json {
+                    output::emit(name, json!({ "name": command_name, "commands": count }))?;
+                }
+                Ok(())
+            }
+            UserCommands::List => {
+                let mut commands = Vec::new();
+                for command_name in user::list()? {
+                    let doc = user::load(&command_name)?.doc;
+                    commands.push((command_name, doc));
+                }
+                if json {
+                    let commands: Vec<_> = commands
+                        .iter()
+                        .map(|(command_name, doc)| json!({ "name": command_name, "doc": doc }))
+                        .collect();
+                    output::emit(name, json!({ "commands": commands }))?;
+                } else if commands.is_empty() {
+                    println!("No user commands defined");
+                } else {
+                    let width = commands.iter().map(|(n, _)| n.len()).max().unwrap_or(0);
+                    for (command_name, doc) in &commands {
+                        match doc {
+                            Some(doc) => println!("{:width$}  {}", command_name, doc),
+                            None => println!("{}", command_name),
+                        }
+                    }
+                }
+                Ok(())
+            }
+            UserCommands::Show { name: command_name } => {
+                let definition = user::load(&command_name)?;
+                if json {
+                    output::emit(
+                        name,
+                        json!({
+                            "name": command_name,
+                            "path": definition.path,
+                            "doc": definition.doc,
+                            "body": definition.body,
+                        }),
+                    )?;
+                } else {
+                    if let Some(doc) = &definition.doc {
+                        println!("# {}", doc);
+                    }
+                    println!("define {}", command_name);
+                    for line in user::indent(&definition.body) {
+                        println!("  {}", line);
+                    }
+                    println!("end");
+                }
+                Ok(())
+            }
+            UserCommands::Delete { name: command_name } => {
+                let path = user::find(&command_name)?;
+                std::fs::remove_file(&path)?;
+                if json {
+                    output::emit(name, json!({ "name": command_name, "deleted": true }))?;
+                } else {
+                    println!("Deleted command '{}'", command_name);
+                }
+                Ok(())
+            }
+        },
+
+        Commands::Status { line } => {
             match DaemonClient::connect().await {
                 Ok(mut client) => {
                     let result = client.send_command(Command::Status).await?;
                     let status: StatusResult = serde_json::from_value(result)?;
 
+                    if json {
+                        return output::emit(name, status);
+                    }
+                    if line {
+                        println!("{}", status_line(&status));
+                        return Ok(());
+                    }
+
                     println!("Daemon: running");
                     if status.session_active {
                         println!("Session: active");
                         if let Some(program) = status.program {
                             println!("Program: {}", program);
                         }
+                        if let Some(pid) = status.pid {
+                            println!("PID: {}", pid);
+                        }
                         if let Some(adapter) = status.adapter {
                             println!("Adapter: {}", adapter);
                         }
@@ -543,10 +2395,33 @@ pub async fn dispatch(command: Commands) -> Result<()> {
                         if let Some(thread) = status.stopped_thread {
                             println!("Stopped thread: {}", thread);
                         }
+                        if let Some(thread) = status.selected_thread {
+                            if Some(thread) != status.stopped_thread {
+                                println!("Selected thread: {}", thread);
+                            }
+                        }
+                        if let (Some(index), Some(function)) =
+                            (status.selected_frame, &status.function)
+                        {
+                            println!("Frame: #{} {}", index, function);
+                        }
+                        println!("Breakpoints: {}", status.breakpoints);
+                        if status.failed_assertions > 0 {
+                            println!("Failed assertions: {}", status.failed_assertions);
+                        }
                     } else {
                         println!("Session: none");
                     }
                 }
+                Err(Error::DaemonNotRunning) if json => {
+                    return output::emit(
+                        name,
+                        json!({ "daemon_running": false, "session_active": false }),
+                    );
+                }
+                Err(Error::DaemonNotRunning) if line => {
+                    println!("no session");
+                }
                 Err(Error::DaemonNotRunning) => {
                     println!("Daemon: not running");
                     println!("Session: none");
@@ -560,21 +2435,169 @@ pub async fn dispatch(command: Commands) -> Result<()> {
         Commands::Stop => {
             let mut client = DaemonClient::connect().await?;
             client.send_command(Command::Stop).await?;
-            println!("Debug session stopped");
+            print_message(name, "Debug session stopped")?;
             Ok(())
         }
 
         Commands::Detach => {
             let mut client = DaemonClient::connect().await?;
             client.send_command(Command::Detach).await?;
-            println!("Detached from process (process continues running)");
+            print_message(name, "Detached from process (process continues running)")?;
             Ok(())
         }
 
         Commands::Restart => {
             let mut client = DaemonClient::connect().await?;
             client.send_command(Command::Restart).await?;
-            println!("Program restarted");
+            print_message(name, "Program restarted")?;
+            Ok(())
+        }
+
+        Commands::HookPre { target, commands } => {
+            set_hook(name, HookPhase::Pre, &target, commands).await
+        }
+
+        Commands::HookPost { target, commands } => {
+            set_hook(name, HookPhase::Post, &target, commands).await
+        }
+
+        Commands::Hooks => {
+            let (hooks, handlers) = match hooks::list().await {
+                Ok(hooks) => {
+                    let mut client = DaemonClient::connect().await?;
+                    (hooks, events::list(&mut client).await?)
+                }
+                Err(Error::DaemonNotRunning) => (Vec::new(), Vec::new()),
+                Err(e) => return Err(e),
+            };
+
+            if json {
+                output::emit(name, json!({ "hooks": hooks, "events": handlers }))?;
+            } else if hooks.is_empty() && handlers.is_empty() {
+                println!("No hooks set");
+            } else {
+                for hook in &hooks {
+                    println!("{} {}:", hook.phase, hook.target);
+                    for command in &hook.commands {
+                        println!("  {}", command);
+                    }
+                }
+                for handler in &handlers {
+                    let shell = if handler.shell { " (shell)" } else { "" };
+                    println!("on {}{}:", handler.target(), shell);
+                    for command in &handler.commands {
+                        println!("  {}", command);
+                    }
+                }
+            }
+
+            Ok(())
+        }
+
+        Commands::On { event, args, shell } => set_event_handler(name, event, args, shell).await,
+
+        Commands::Source {
+            file,
+            continue_on_error,
+            stop_on_error: _,
+            definitions,
+        } => {
+            let count = batch::source(&file, continue_on_error, &definitions).await?;
+            if json {
+                output::emit(
+                    name,
+                    json!({ "file": file.display().to_string(), "commands": count }),
+                )?;
+            }
+            Ok(())
+        }
+
+        Commands::Python { script, args } => {
+            python::run(&script, &args)?;
+            if json {
+                output::emit(name, json!({ "script": script.display().to_string() }))?;
+            }
+            Ok(())
+        }
+
+        Commands::Serve { listen, token } => {
+            let token = token
+                .or_else(|| std::env::var(api::TOKEN_VAR).ok())
+                .filter(|token| !token.is_empty());
+            api::check_exposure(listen, token.is_some())?;
+            spawn::ensure_daemon_running().await?;
+
+            let listener = api::bind(listen).await?;
+            let url = format!("http://{}/v1/", listener.local_addr()?);
+            let (token, generated) = match token {
+                Some(token) => (token, false),
+                None => (api::generate_token()?, true),
+            };
+            if json {
+                output::emit(name, json!({ "url": url, "token": generated.then_some(&token) }))?;
+            } else {
+                println!("Serving the control API on {} (Ctrl+C stops)", url);
+                if generated {
+                    println!("Send 'Authorization: Bearer {}' with each request", token);
+                }
+            }
+            api::serve(listener, token).await
+        }
+
+        Commands::ServeMcp {
+            allow,
+            read_only,
+            audit_log,
+        } => {
+            let allowlist = mcp::Allowlist::new(&allow, read_only)?;
+            let audit_log = match audit_log {
+                Some(path) => path,
+                None => mcp::default_audit_path()?,
+            };
+            // Stdout carries the protocol
+            eprintln!("MCP server ready; auditing tool calls to {}", audit_log.display());
+            mcp::serve(allowlist, &audit_log).await
+        }
+
+        Commands::Set { name: setting, value } => {
+            spawn::ensure_daemon_running().await?;
+            let mut client = DaemonClient::connect().await?;
+            let result = client
+                .send_command(Command::Set {
+                    name: setting.clone(),
+                    args: value,
+                })
+                .await?;
+
+            let settings: Settings = serde_json::from_value(result)?;
+            if setting == "substitute-path" {
+                sourcepath::remember(&settings.substitute_path)?;
+            }
+            if json {
+                return output::emit(name, &settings);
+            }
+            for (setting, value) in settings.show(Some(&setting))? {
+                print_message(name, &format!("{} is {}", setting, value))?;
+            }
+            Ok(())
+        }
+
+        Commands::Show { name: setting } => {
+            let settings = fetch_settings().await;
+            let entries = settings.show(setting.as_deref())?;
+
+            if json {
+                let all = serde_json::to_value(&settings)?;
+                let shown: serde_json::Map<_, _> = entries
+                    .iter()
+                    .map(|(setting, _)| (setting.to_string(), all[*setting].clone()))
+                    .collect();
+                return output::emit(name, shown);
+            }
+
+            for (setting, value) in entries {
+                println!("{}: {}", setting, value);
+            }
             Ok(())
         }
 
@@ -586,10 +2609,20 @@ pub async fn dispatch(command: Commands) -> Result<()> {
             if let Some(path) = log_path {
                 if clear {
                     logging::truncate_daemon_log()?;
+                    if json {
+                        return output::emit(name, json!({ "path": path, "cleared": true }));
+                    }
                     println!("Daemon log cleared: {}", path.display());
                     return Ok(());
                 }
 
+                if json && !follow {
+                    let content = std::fs::read_to_string(&path).unwrap_or_default();
+                    let all_lines: Vec<&str> = content.lines().collect();
+                    let start = all_lines.len().saturating_sub(lines);
+                    return output::emit(name, json!({ "path": path, "lines": &all_lines[start..] }));
+                }
+
                 if !path.exists() {
                     println!("No daemon log file found at: {}", path.display());
                     println!("The daemon may not have been started yet.");
@@ -656,14 +2689,57 @@ pub async fn dispatch(command: Commands) -> Result<()> {
                 path,
                 force,
                 dry_run,
-                json,
+                // Setup has printed its own JSON since before the global flag
+                json: json || output::is_json(),
             };
             setup::run(opts).await
         }
 
+        Commands::Trust { path, revoke, list } => {
+            if list {
+                let trusted = init::trusted_paths()?;
+                if json {
+                    output::emit(name, json!({ "trusted": trusted }))?;
+                } else if trusted.is_empty() {
+                    println!("No trusted init files");
+                } else {
+                    println!("Trusted init files:");
+                    for path in &trusted {
+                        println!("  {}", path.display());
+                    }
+                }
+                return Ok(());
+            }
+
+            let path = path.unwrap_or_else(|| PathBuf::from(init::INIT_FILE_NAME));
+            if revoke {
+                let revoked = init::revoke(&path)?;
+                if json {
+                    output::emit(name, json!({ "path": path, "trusted": false, "changed": revoked }))?;
+                } else if revoked {
+                    println!("No longer trusting {}", path.display());
+                } else {
+                    println!("{} was not trusted", path.display());
+                }
+            } else {
+                let trusted = init::trust(&path)?;
+                if json {
+                    output::emit(name, json!({ "path": trusted, "trusted": true }))?;
+                } else {
+                    println!("Trusted {}", trusted.display());
+                }
+            }
+
+            Ok(())
+        }
+
         Commands::Test { path, verbose } => {
             let result = testing::run_scenario(&path, verbose).await?;
+            if json {
+                output::emit(name, &result)?;
+            }
 
+            capture::end().await;
             if result.passed {
                 std::process::exit(0);
             } else {
@@ -673,6 +2749,411 @@ pub async fn dispatch(command: Commands) -> Result<()> {
     }
 }
 
+/// Current settings from the daemon, or the configured defaults when no
+/// daemon is running yet
+pub async fn fetch_settings() -> Settings {
+    if let Ok(mut client) = DaemonClient::connect().await {
+        if let Ok(result) = client.send_command(Command::Settings).await {
+            if let Ok(settings) = serde_json::from_value(result) {
+                return settings;
+            }
+        }
+    }
+
+    Config::load()
+        .map(|config| Settings::from_config(&config))
+        .unwrap_or_default()
+}
+
+/// `status --line`: what the next command applies to, on one line
+///
+/// `prog (pid 4242) | stopped: breakpoint | thread 1 | #0 main | 2 breakpoints | lldb-dap`
+fn status_line(status: &StatusResult) -> String {
+    if !status.session_active {
+        return "no session".to_string();
+    }
+
+    let mut parts = Vec::new();
+
+    let program = status
+        .program
+        .as_deref()
+        .map(|program| {
+            std::path::Path::new(program)
+                .file_name()
+                .map(|name| name.to_string_lossy().into_owned())
+                .unwrap_or_else(|| program.to_string())
+        })
+        .unwrap_or_else(|| "?".to_string());
+    parts.push(match status.pid {
+        Some(pid) => format!("{} (pid {})", program, pid),
+        None => program,
+    });
+
+    let state = status.state.as_deref().unwrap_or("unknown");
+    parts.push(match &status.stopped_reason {
+        Some(reason) if state == "stopped" => format!("{}: {}", state, reason),
+        _ => state.to_string(),
+    });
+
+    if let Some(thread) = status.selected_thread {
+        parts.push(format!("thread {}", thread));
+    }
+    if let (Some(index), Some(function)) = (status.selected_frame, &status.function) {
+        parts.push(format!("#{} {}", index, function));
+    }
+
+    parts.push(match status.breakpoints {
+        1 => "1 breakpoint".to_string(),
+        n => format!("{} breakpoints", n),
+    });
+    match status.failed_assertions {
+        0 => {}
+        1 => parts.push("1 failed assertion".to_string()),
+        n => parts.push(format!("{} failed assertions", n)),
+    }
+    if let Some(adapter) = &status.adapter {
+        parts.push(adapter.clone());
+    }
+
+    parts.join(" | ")
+}
+
+/// Replace the command list for a hook, starting the daemon if needed
+async fn set_hook(name: &str, phase: HookPhase, target: &str, commands: Vec<String>) -> Result<()> {
+    let target = hooks::resolve_target(target)?;
+    hooks::validate(phase, &target, &commands)?;
+
+    spawn::ensure_daemon_running().await?;
+    let mut client = DaemonClient::connect().await?;
+
+    let count = commands.len();
+    client
+        .send_command(Command::HookSet {
+            phase,
+            target: target.clone(),
+            commands: commands.clone(),
+        })
+        .await?;
+
+    if output::is_json() {
+        return output::emit(name, HookInfo { phase, target, commands });
+    }
+
+    if count == 0 {
+        print_message(name, &format!("Removed {} {}", phase, target))
+    } else {
+        print_message(name, &format!("Set {} {} ({} command(s))", phase, target, count))
+    }
+}
+
+async fn set_event_handler(
+    name: &str,
+    event: EventKind,
+    mut commands: Vec<String>,
+    shell: bool,
+) -> Result<()> {
+    let filter = if event.takes_filter() {
+        if commands.is_empty() {
+            return Err(Error::Config(format!(
+                "'on {}' needs a name first, or '{}' for any",
+                event,
+                events::ANY
+            )));
+        }
+        Some(commands.remove(0))
+    } else {
+        None
+    };
+    let handler = EventHandlerInfo {
+        event,
+        filter,
+        shell,
+        commands,
+    };
+    let label = format!("on {}", handler.target());
+    if !shell {
+        for (index, line) in handler.commands.iter().enumerate() {
+            script::parse_line(line).map_err(|message| Error::Script {
+                path: label.clone(),
+                line: index + 1,
+                message,
+            })?;
+        }
+    }
+
+    spawn::ensure_daemon_running().await?;
+    let mut client = DaemonClient::connect().await?;
+    client
+        .send_command(Command::EventHandlerSet {
+            handler: handler.clone(),
+        })
+        .await?;
+
+    if output::is_json() {
+        return output::emit(name, handler);
+    }
+
+    if handler.commands.is_empty() {
+        print_message(name, &format!("Removed {}", label))
+    } else {
+        print_message(
+            name,
+            &format!("Set {} ({} command(s))", label, handler.commands.len()),
+        )
+    }
+}
+
+/// Run `watch-change` or `break-when` and report where the program stopped
+async fn step_until(name: &str, watch: SoftwareWatch, trigger: until::Trigger) -> Result<()> {
+    let expression = watch.expression.join(" ");
+    let pace = until::Pace {
+        step: watch.step,
+        sample: watch.sample.map(std::time::Duration::from_millis),
+        max_steps: watch.max_steps,
+        timeout: watch.timeout,
+    };
+    let mut client = DaemonClient::connect().await?;
+    if !output::is_json() && !output::is_quiet() {
+        let mode = if pace.sample.is_some() { "Sampling" } else { "Stepping" };
+        let goal = match trigger {
+            until::Trigger::Change => "changes",
+            until::Trigger::True => "is true",
+        };
+        println!("{} until '{}' {}...", mode, expression, goal);
+    }
+
+    let outcome = until::run(&mut client, &expression, trigger, pace).await?;
+    if let Some(stop) = &outcome.stop {
+        batch::record_stop(stop);
+    }
+    if output::is_json() {
+        return output::emit(name, &outcome);
+    }
+
+    let shown = |value: &Option<String>| value.clone().unwrap_or_else(|| "<unavailable>".into());
+    let reason = outcome
+        .stop
+        .as_ref()
+        .and_then(|stop| stop["reason"].as_str())
+        .unwrap_or("")
+        .to_string();
+    let reason = reason.as_str();
+    match (outcome.triggered, trigger) {
+        (true, _) if outcome.steps == 0 => {
+            println!("'{}' is already true: {}", expression, shown(&outcome.new_value))
+        }
+        (true, until::Trigger::Change) => println!(
+            "'{}' changed after {} step(s): {} -> {}",
+            expression,
+            outcome.steps,
+            shown(&outcome.old_value),
+            shown(&outcome.new_value)
+        ),
+        (true, until::Trigger::True) => println!(
+            "'{}' became true after {} step(s): {}",
+            expression,
+            outcome.steps,
+            shown(&outcome.new_value)
+        ),
+        (false, _) if matches!(reason, "exited" | "terminated") => {
+            println!("Program {} after {} step(s)", reason, outcome.steps)
+        }
+        (false, _) if reason == "breakpoint" => println!(
+            "Hit a breakpoint after {} step(s); '{}' is {}",
+            outcome.steps,
+            expression,
+            shown(&outcome.new_value)
+        ),
+        (false, _) => println!(
+            "'{}' did not {} within {} step(s)",
+            expression,
+            match trigger {
+                until::Trigger::Change => "change",
+                until::Trigger::True => "become true",
+            },
+            outcome.steps
+        ),
+    }
+
+    if let Some(stop) = outcome.stop {
+        if !matches!(reason, "exited" | "terminated") {
+            if let Ok(stop) = serde_json::from_value::<StopResult>(stop) {
+                if let (Some(source), Some(line)) = (&stop.source, stop.line) {
+                    println!("  Location: {}:{}", source, line);
+                }
+            }
+        }
+    }
+    Ok(())
+}
+
+/// Run the post-start commands from a project init file
+///
+/// Like GDB with a failing `.gdbinit`, the first error stops the script but
+/// leaves the freshly started session in place.
+async fn run_init_commands(commands: Vec<script::ScriptCommand>) {
+    let total = commands.len();
+    for (index, command) in commands.into_iter().enumerate() {
+        if let Err(e) = Box::pin(dispatch(command.command)).await {
+            eprintln!("Warning: {}:{}: {}", init::INIT_FILE_NAME, command.line, e);
+            let skipped = total - index - 1;
+            if skipped > 0 {
+                eprintln!("Skipped the remaining {} init command(s)", skipped);
+            }
+            break;
+        }
+    }
+}
+
+/// Resume the program; with a timeout, also wait that long for it to stop
+/// and report the stop as `await` does
+async fn resume(name: &str, command: Command, message: &str, timeout: Option<u64>) -> Result<()> {
+    let mut client = DaemonClient::connect().await?;
+    client.set_timeout(timeout);
+    client.send_command(command).await?;
+    print_message(name, message)?;
+    match timeout {
+        Some(timeout) => Box::pin(dispatch(Commands::Await { timeout })).await,
+        None => Ok(()),
+    }
+}
+
+/// Print the confirmation for a command whose only result is success
+///
+/// JSON output carries an empty object; `ok` in the envelope says it all.
+/// Batch mode drops the text entirely.
+fn print_message(name: &str, message: &str) -> Result<()> {
+    if output::is_json() {
+        output::emit(name, json!({}))
+    } else {
+        if !output::is_quiet() {
+            println!("{}", message);
+        }
+        Ok(())
+    }
+}
+
+/// Fetch a frame's locals for `backtrace --locals`, empty if unavailable
+async fn frame_locals(client: &mut DaemonClient, frame_id: i64) -> Vec<VariableInfo> {
+    let Ok(result) = client
+        .send_command(Command::Locals {
+            frame_id: Some(frame_id),
+        })
+        .await
+    else {
+        return Vec::new();
+    };
+
+    serde_json::from_value(result["variables"].clone()).unwrap_or_default()
+}
+
+fn format_variable(var: &VariableInfo) -> String {
+    let formatted = format_value(&var.name, &var.value, var.type_name.as_deref());
+    match var.truncated_at {
+        Some(at) => format!("{} {}", formatted, truncated(at, "print --limit")),
+        None => formatted,
+    }
+}
+
+/// Marks a value cut short by the `print-limit` budget
+fn truncated(at: usize, raise: &str) -> String {
+    format!("[truncated at {} bytes, use {} to raise]", at, raise)
+}
+
+/// Format `name = value (type)` with theme colors
+fn format_value(name: &str, value: &str, type_name: Option<&str>) -> String {
+    let type_suffix = type_name
+        .map(|t| format!(" {}", theme::paint(Element::TypeName, &format!("({})", t))))
+        .unwrap_or_default();
+    format!(
+        "{} = {}{}",
+        theme::paint(Element::VariableName, name),
+        value,
+        type_suffix
+    )
+}
+
+/// Print the parts of the stop banner chosen with `set stop-frame`,
+/// `set stop-locals` and `set stop-context`
+///
+/// The banner itself has already been printed, so a frame without source
+/// just leaves these out.
+async fn print_stop_details(client: &mut DaemonClient) {
+    print_stop_watches(client).await;
+
+    let settings = fetch_settings().await;
+    if !settings.stop_frame && !settings.stop_locals && settings.stop_context == 0 {
+        return;
+    }
+
+    let Ok(result) = client
+        .send_command(Command::Context {
+            lines: settings.stop_context,
+        })
+        .await
+    else {
+        return;
+    };
+    let Ok(ctx) = serde_json::from_value::<ContextResult>(result) else {
+        return;
+    };
+
+    if settings.stop_frame {
+        if let Some(function) = &ctx.function {
+            println!("  In function: {}", function);
+        }
+    }
+    if settings.stop_locals {
+        for var in &ctx.locals {
+            println!("    {}", format_variable(var));
+        }
+    }
+    if settings.stop_context > 0 {
+        println!();
+        source::print_lines(
+            &ctx.source_lines,
+            settings.inline_values.then_some(&ctx.locals[..]),
+        );
+    }
+}
+
+/// Show watch values after a stop, like GDB's `display`
+async fn print_stop_watches(client: &mut DaemonClient) {
+    let Ok(result) = client.send_command(Command::WatchList).await else {
+        return;
+    };
+    let watches: Vec<WatchInfo> =
+        serde_json::from_value(result["watches"].clone()).unwrap_or_default();
+    if !watches.is_empty() {
+        println!("  Watches:");
+        print_watches(&watches, "    ");
+    }
+}
+
+/// Print watches, highlighting values that changed since the previous stop
+fn print_watches(watches: &[WatchInfo], indent: &str) {
+    for watch in watches {
+        let value = match (&watch.value, &watch.error) {
+            (Some(value), _) if watch.changed => format!(
+                "{} (was {})",
+                theme::paint(Element::ChangedValue, value),
+                watch.previous.as_deref().unwrap_or("?")
+            ),
+            (Some(value), _) => value.clone(),
+            (None, Some(error)) => format!("<{}>", error),
+            (None, None) => "<unavailable>".to_string(),
+        };
+        println!(
+            "{}{}: {} = {}",
+            indent,
+            watch.id,
+            theme::paint(Element::VariableName, &watch.expression),
+            value
+        );
+    }
+}
+
 /// Print the result of a frame navigation command (up/down)
 fn print_frame_nav_result(result: &serde_json::Value) {
     let frame_index = result["selected"].as_u64().unwrap_or(0);
@@ -689,6 +3170,69 @@ fn print_frame_nav_result(result: &serde_json::Value) {
     }
 }
 
+/// Add a breakpoint for `break` / `breakpoint add`
+///
+/// A location missing from the program gets suggestions, and on a terminal
+/// an offer to move the breakpoint to the closest one.
+async fn add_breakpoint(
+    name: &str,
+    location: &str,
+    condition: Option<String>,
+    hit_count: Option<u32>,
+) -> Result<()> {
+    let mut client = DaemonClient::connect().await?;
+    let loc = BreakpointLocation::parse(location)?;
+
+    let result = client
+        .send_command(Command::BreakpointAdd {
+            location: loc.clone(),
+            condition: condition.clone(),
+            hit_count,
+        })
+        .await?;
+    let info: BreakpointInfo = serde_json::from_value(result)?;
+
+    let suggestions = if info.verified {
+        Vec::new()
+    } else {
+        suggest::breakpoint(&mut client, &loc).await
+    };
+
+    if output::is_json() {
+        let mut data = serde_json::to_value(&info)?;
+        if !suggestions.is_empty() {
+            data["suggestions"] = json!(suggestions);
+        }
+        return output::emit(name, data);
+    }
+
+    print_breakpoint_added(&info);
+    let Some(first) = suggestions.first() else {
+        return Ok(());
+    };
+    println!("{}", suggest::did_you_mean(&suggestions));
+    if !suggest::confirm(&format!("Move breakpoint {} to {}?", info.id, first)) {
+        return Ok(());
+    }
+
+    client
+        .send_command(Command::BreakpointRemove {
+            id: Some(info.id),
+            all: false,
+        })
+        .await?;
+    let result = client
+        .send_command(Command::BreakpointAdd {
+            location: BreakpointLocation::parse(first)?,
+            condition,
+            hit_count,
+        })
+        .await?;
+    print_breakpoint_added(&serde_json::from_value(result)?);
+
+    Ok(())
+}
+
 fn print_breakpoint_added(info: &BreakpointInfo) {
     if info.verified {
         println!(
@@ -712,6 +3256,7 @@ fn print_breakpoint(info: &BreakpointInfo) {
     } else {
         "○"
     };
+    let status = theme::paint(Element::BreakpointMarker, status);
 
     let location = match (&info.source, info.line) {
         (Some(source), Some(line)) => format!("{}:{}", source, line),
@@ -740,7 +3285,7 @@ fn print_breakpoint(info: &BreakpointInfo) {
 fn print_stop_result(stop: &StopResult) {
     match stop.reason.as_str() {
         "breakpoint" => {
-            println!("Stopped at breakpoint");
+            println!("{}", theme::paint(Element::BreakpointMarker, "Stopped at breakpoint"));
             if !stop.hit_breakpoint_ids.is_empty() {
                 println!("  Breakpoint IDs: {:?}", stop.hit_breakpoint_ids);
             }
@@ -768,4 +3313,84 @@ fn print_stop_result(stop: &StopResult) {
     if let (Some(source), Some(line)) = (&stop.source, stop.line) {
         println!("  Location: {}:{}", source, line);
     }
+    if let Some(crash) = &stop.crash_report {
+        let mut fault = crash.signal.clone();
+        if let Some(address) = &crash.address {
+            fault.push_str(&format!(" at {}", address));
+        }
+        if let Some(mapping) = &crash.mapping {
+            fault.push_str(&format!(" ({})", mapping));
+        }
+        println!("  Crash: {}", fault);
+        if let Some(function) = &crash.function {
+            match &crash.location {
+                Some(location) => println!("  In: {} ({})", function, location),
+                None => println!("  In: {}", function),
+            }
+        }
+        let threads = if crash.threads == 1 { "thread" } else { "threads" };
+        println!("  Crash report ({} {}): {}", crash.threads, threads, crash.path);
+    }
+    for warning in &stop.warnings {
+        println!("  Warning: {}", warning);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use serde_json::json;
+
+    use super::status_line;
+    use crate::ipc::protocol::StatusResult;
+
+    fn status(fields: serde_json::Value) -> StatusResult {
+        let mut status = json!({
+            "daemon_running": true,
+            "session_active": true,
+            "state": null,
+            "program": null,
+            "adapter": null,
+            "stopped_thread": null,
+            "stopped_reason": null,
+        });
+        status.as_object_mut().unwrap().extend(fields.as_object().unwrap().clone());
+        serde_json::from_value(status).unwrap()
+    }
+
+    #[test]
+    fn status_line_shows_what_commands_apply_to() {
+        let stopped = status(json!({
+            "state": "stopped",
+            "program": "/home/me/build/prog",
+            "pid": 4242,
+            "stopped_reason": "breakpoint",
+            "selected_thread": 1,
+            "selected_frame": 0,
+            "function": "main",
+            "breakpoints": 2,
+            "adapter": "lldb-dap",
+        }));
+        assert_eq!(
+            status_line(&stopped),
+            "prog (pid 4242) | stopped: breakpoint | thread 1 | #0 main | 2 breakpoints | lldb-dap"
+        );
+
+        let running = status(json!({
+            "state": "running",
+            "program": "prog",
+            "stopped_reason": "breakpoint",
+            "breakpoints": 1,
+            "failed_assertions": 3,
+        }));
+        assert_eq!(status_line(&running), "prog | running | 1 breakpoint | 3 failed assertions");
+    }
+
+    #[test]
+    fn status_line_fills_in_what_is_unknown() {
+        let bare = status(json!({ "function": "main", "failed_assertions": 1 }));
+        assert_eq!(status_line(&bare), "? | unknown | 0 breakpoints | 1 failed assertion");
+
+        let idle = status(json!({ "session_active": false, "program": "prog" }));
+        assert_eq!(status_line(&idle), "no session");
+    }
 }
@@ -0,0 +1,144 @@
+//! Output formats
+//!
+//! Text output is written for people and agents reading a terminal. With
+//! `--output json` every command instead prints one JSON object per line,
+//! wrapped in a versioned envelope (see docs/JSON_OUTPUT.md), so tools and
+//! tests never need to scrape the text form.
+
+use std::sync::atomic::{AtomicBool, Ordering};
+
+use serde::Serialize;
+
+use crate::common::error::IpcError;
+use crate::common::{Error, Result};
+
+/// Version of the JSON envelope and payload shapes
+///
+/// Bump this whenever a field is removed or changes meaning; adding fields
+/// does not require a bump.
+pub const SCHEMA_VERSION: u32 = 1;
+
+/// How command results are printed
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, clap::ValueEnum)]
+pub enum OutputFormat {
+    /// Human-readable text
+    #[default]
+    Text,
+    /// One JSON object per line
+    Json,
+}
+
+/// Set once from the command line; scripts and hooks inherit it
+static JSON: AtomicBool = AtomicBool::new(false);
+
+/// Select the output format for this process
+pub fn set_format(format: OutputFormat) {
+    JSON.store(format == OutputFormat::Json, Ordering::Relaxed);
+}
+
+/// Whether commands should print JSON instead of text
+pub fn is_json() -> bool {
+    JSON.load(Ordering::Relaxed)
+}
+
+/// Set in batch mode to drop confirmations and hints, keeping results
+static QUIET: AtomicBool = AtomicBool::new(false);
+
+/// Suppress text banners such as "Continuing execution..."
+pub fn set_quiet(quiet: bool) {
+    QUIET.store(quiet, Ordering::Relaxed);
+}
+
+/// Whether text banners are suppressed
+pub fn is_quiet() -> bool {
+    QUIET.load(Ordering::Relaxed)
+}
+
+#[derive(Serialize)]
+struct Envelope<'a, T: Serialize> {
+    schema_version: u32,
+    command: &'a str,
+    ok: bool,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    data: Option<T>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    error: Option<IpcError>,
+}
+
+/// Print a successful result
+pub fn emit<T: Serialize>(command: &str, data: T) -> Result<()> {
+    println!("{}", render(command, Some(data), None)?);
+    Ok(())
+}
+
+/// Print a failed command, using the same error codes as the daemon
+pub fn emit_error(command: &str, error: &Error) {
+    // Rendering a plain string and error code cannot fail
+    if let Ok(line) = render::<()>(command, None, Some(IpcError::from(error))) {
+        println!("{}", line);
+    }
+}
+
+/// A daemon result in the envelope, as one line of JSON
+pub fn render_result(
+    command: &str,
+    result: std::result::Result<serde_json::Value, IpcError>,
+) -> Result<String> {
+    match result {
+        Ok(data) => render(command, Some(data), None),
+        Err(error) => render::<()>(command, None, Some(error)),
+    }
+}
+
+fn render<T: Serialize>(command: &str, data: Option<T>, error: Option<IpcError>) -> Result<String> {
+    Ok(serde_json::to_string(&Envelope {
+        schema_version: SCHEMA_VERSION,
+        command,
+        ok: error.is_none(),
+        data,
+        error,
+    })?)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn envelope_carries_version_and_data() {
+        let line = render("print", Some(serde_json::json!({ "result": "42" })), None).unwrap();
+        let value: serde_json::Value = serde_json::from_str(&line).unwrap();
+        assert_eq!(value["schema_version"], SCHEMA_VERSION);
+        assert_eq!(value["command"], "print");
+        assert_eq!(value["ok"], true);
+        assert_eq!(value["data"]["result"], "42");
+        assert!(value.get("error").is_none());
+    }
+
+    #[test]
+    fn errors_use_ipc_codes() {
+        let line = render::<()>("locals", None, Some(IpcError::from(&Error::SessionNotActive)))
+            .unwrap();
+        let value: serde_json::Value = serde_json::from_str(&line).unwrap();
+        assert_eq!(value["ok"], false);
+        assert_eq!(value["error"]["code"], "SESSION_NOT_ACTIVE");
+        assert!(value.get("data").is_none());
+    }
+
+    #[test]
+    fn timeouts_carry_their_limit() {
+        let error = IpcError::from(&Error::AwaitTimeout(5));
+        let line = render::<()>("await", None, Some(error.clone())).unwrap();
+        let value: serde_json::Value = serde_json::from_str(&line).unwrap();
+        assert_eq!(value["error"]["code"], "TIMEOUT");
+        assert_eq!(value["error"]["timeout_secs"], 5);
+
+        // A timeout relayed by the daemon keeps its limit and message
+        let relayed = IpcError::from(&Error::from(error.clone()));
+        assert_eq!(relayed.timeout_secs, Some(5));
+        assert_eq!(relayed.message, error.message);
+
+        let line = render::<()>("locals", None, Some(IpcError::from(&Error::SessionNotActive)));
+        assert!(!line.unwrap().contains("timeout_secs"));
+    }
+}
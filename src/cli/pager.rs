@@ -0,0 +1,183 @@
+//! Paging long output
+//!
+//! Like git, the pager is an external process that stdout is redirected into
+//! for the rest of the command: `$DEBUGGER_PAGER`, then `$PAGER`, then `less`.
+//! `less` runs with `-FRX` unless `$LESS` says otherwise, so output that fits
+//! on one screen is printed as usual and nothing waits for a keypress. Search,
+//! scrolling and quitting are the pager's own.
+
+use std::io::IsTerminal;
+
+use crate::commands::{
+    BreakpointCommands, BtraceCommands, Commands, CoverageCommands, ProfileCommands, ReplayCommands,
+    ReportCommands, SampleCommands, TraceCommands, TrackCommands, WatchCommands,
+};
+
+use crate::common::config::Config;
+use crate::common::settings::Settings;
+use crate::ipc::state::ClientState;
+
+use super::{batch, output};
+
+/// Commands whose output can run past a screen
+pub fn pages(command: &Commands) -> bool {
+    match command {
+        Commands::Backtrace { .. }
+        | Commands::Locals
+        | Commands::Print { .. }
+        | Commands::Eval { .. }
+        | Commands::Context { .. }
+        | Commands::Disassemble { .. }
+        | Commands::Find { .. }
+        | Commands::Dwarf { .. }
+        | Commands::Report(
+            ReportCommands::Generate { file: None, .. } | ReportCommands::Data { .. },
+        )
+        | Commands::Threads
+        | Commands::Modules
+        | Commands::Timeline { export: None, .. }
+        | Commands::Hooks
+        | Commands::Show { .. }
+        | Commands::Breakpoint(BreakpointCommands::List)
+        | Commands::Watch(WatchCommands::List | WatchCommands::History { .. })
+        | Commands::Sample(SampleCommands::List | SampleCommands::Export { file: None, .. })
+        | Commands::Track(TrackCommands::List | TrackCommands::History { .. })
+        | Commands::Trace(TraceCommands::Log { follow: false, .. })
+        | Commands::Replay(ReplayCommands::List { .. })
+        | Commands::Btrace(BtraceCommands::List { .. })
+        | Commands::Profile(ProfileCommands::Folded { file: None })
+        | Commands::Coverage(CoverageCommands::Report { file: None, .. }) => true,
+        Commands::Output { follow, .. } | Commands::Logs { follow, .. } => !follow,
+        _ => false,
+    }
+}
+
+/// The pager command line, or `None` when paging is disabled by setting the
+/// pager to an empty string or `cat`
+fn pager_command() -> Option<String> {
+    let pager = std::env::var("DEBUGGER_PAGER")
+        .or_else(|_| std::env::var("PAGER"))
+        .unwrap_or_else(|_| "less".to_string());
+    let pager = pager.trim();
+
+    if pager.is_empty() || pager == "cat" {
+        None
+    } else {
+        Some(pager.to_string())
+    }
+}
+
+#[cfg(unix)]
+mod imp {
+    use std::io::Write;
+    use std::os::unix::io::AsRawFd;
+    use std::process::{Child, Stdio};
+    use std::sync::Mutex;
+
+    static PAGER: Mutex<Option<Child>> = Mutex::new(None);
+
+    pub fn start(pager: &str) -> bool {
+        let mut command = std::process::Command::new("sh");
+        command.arg("-c").arg(pager).stdin(Stdio::piped());
+        if std::env::var_os("LESS").is_none() {
+            command.env("LESS", "FRX");
+        }
+
+        let Ok(mut child) = command.spawn() else {
+            return false;
+        };
+        let Some(pipe) = child.stdin.take() else {
+            let _ = child.kill();
+            return false;
+        };
+
+        let _ = std::io::stdout().flush();
+        // SAFETY: both descriptors are open; dup2 atomically replaces fd 1
+        if unsafe { libc::dup2(pipe.as_raw_fd(), libc::STDOUT_FILENO) } < 0 {
+            let _ = child.kill();
+            return false;
+        }
+        drop(pipe);
+
+        // Quitting the pager early closes the pipe. Dying quietly on SIGPIPE,
+        // as `ls | head` does, beats a "failed printing to stdout" panic.
+        // SAFETY: restoring the default disposition has no preconditions
+        unsafe {
+            libc::signal(libc::SIGPIPE, libc::SIG_DFL);
+        }
+
+        if let Ok(mut slot) = PAGER.lock() {
+            *slot = Some(child);
+        }
+        true
+    }
+
+    pub fn is_active() -> bool {
+        PAGER.lock().map(|slot| slot.is_some()).unwrap_or(false)
+    }
+
+    pub fn finish() {
+        let Some(mut child) = PAGER.lock().ok().and_then(|mut slot| slot.take()) else {
+            return;
+        };
+
+        let _ = std::io::stdout().flush();
+        // SAFETY: closing our copy of the pipe is what lets the pager see EOF
+        unsafe {
+            libc::close(libc::STDOUT_FILENO);
+        }
+        let _ = child.wait();
+    }
+}
+
+#[cfg(not(unix))]
+mod imp {
+    pub fn start(_pager: &str) -> bool {
+        false
+    }
+
+    pub fn is_active() -> bool {
+        false
+    }
+
+    pub fn finish() {}
+}
+
+/// Start the pager for a command if it applies
+///
+/// Paging needs a terminal on stdout, text output, no `--batch`, and
+/// `set pagination on`, as the daemon publishes it in its client state or,
+/// with no daemon running, as configured.
+pub fn start_for(command: &Commands) {
+    if !pages(command)
+        || !std::io::stdout().is_terminal()
+        || output::is_json()
+        || batch::is_active()
+    {
+        return;
+    }
+
+    let pagination = ClientState::read().pagination.unwrap_or_else(|| {
+        Config::load()
+            .map(|config| Settings::from_config(&config))
+            .unwrap_or_default()
+            .pagination
+    });
+    if !pagination {
+        return;
+    }
+
+    if let Some(pager) = pager_command() {
+        imp::start(&pager);
+    }
+}
+
+/// Whether stdout currently goes to a pager
+pub fn is_active() -> bool {
+    imp::is_active()
+}
+
+/// Wait for the pager to exit; must run before the process exits
+pub fn finish() {
+    imp::finish()
+}
@@ -0,0 +1,160 @@
+//! Folded stacks and flame graphs for `profile`
+
+use std::collections::BTreeMap;
+use std::fmt::Write;
+
+use crate::ipc::protocol::ProfileStack;
+
+/// Width of the flame graph in pixels
+const WIDTH: f64 = 1200.0;
+
+/// Height of one frame
+const FRAME_HEIGHT: f64 = 16.0;
+
+/// Room above the frames for the title
+const TOP: f64 = 36.0;
+
+/// Room below them
+const BOTTOM: f64 = 8.0;
+
+/// Width of a character of the labels, roughly
+const CHAR_WIDTH: f64 = 7.0;
+
+/// Frames narrower than this are left out
+const MIN_WIDTH: f64 = 0.1;
+
+/// One `a;b;c count` line per stack, the format flame graph tools read
+pub fn folded(stacks: &[ProfileStack]) -> String {
+    stacks
+        .iter()
+        .map(|stack| format!("{} {}\n", stack.frames.join(";"), stack.count))
+        .collect()
+}
+
+/// A function and everything sampled while it was on the stack there
+#[derive(Default)]
+struct Node {
+    count: u64,
+    children: BTreeMap<String, Node>,
+}
+
+impl Node {
+    fn depth(&self) -> usize {
+        self.children.values().map(|child| child.depth() + 1).max().unwrap_or(0)
+    }
+}
+
+/// The stacks as an SVG flame graph: callers below their callees, each
+/// frame as wide as its share of the samples
+pub fn flamegraph(stacks: &[ProfileStack], title: &str) -> String {
+    let mut root = Node::default();
+    for stack in stacks {
+        root.count += stack.count;
+        let mut node = &mut root;
+        for frame in &stack.frames {
+            node = node.children.entry(frame.clone()).or_default();
+            node.count += stack.count;
+        }
+    }
+
+    let height = TOP + (root.depth() + 1) as f64 * FRAME_HEIGHT + BOTTOM;
+    let mut svg = String::new();
+    let _ = writeln!(
+        svg,
+        r##"<?xml version="1.0" standalone="no"?>
+<svg version="1.1" width="{w}" height="{h}" viewBox="0 0 {w} {h}" xmlns="http://www.w3.org/2000/svg">
+<style>text {{ font-family: monospace; font-size: 12px; fill: #000; }} rect:hover {{ stroke: #000; }}</style>
+<rect x="0" y="0" width="{w}" height="{h}" fill="#eeeeee"/>
+<text x="{x}" y="24" text-anchor="middle" style="font-size: 17px">{title}</text>"##,
+        w = WIDTH,
+        h = height,
+        x = WIDTH / 2.0,
+        title = escape(title),
+    );
+    if root.count > 0 {
+        let scale = (WIDTH - 20.0) / root.count as f64;
+        let base = height - BOTTOM - FRAME_HEIGHT;
+        draw(&mut svg, "all", &root, root.count, 10.0, base, scale);
+    }
+    svg.push_str("</svg>\n");
+    svg
+}
+
+/// Draw a frame at `x` and its callees above it
+fn draw(svg: &mut String, name: &str, node: &Node, total: u64, x: f64, y: f64, scale: f64) {
+    let width = node.count as f64 * scale;
+    if width < MIN_WIDTH {
+        return;
+    }
+    let percent = node.count as f64 * 100.0 / total as f64;
+    let _ = writeln!(
+        svg,
+        r#"<g><title>{name} ({count} samples, {percent:.2}%)</title><rect x="{x:.1}" y="{y:.1}" width="{width:.1}" height="{height:.1}" fill="{fill}" rx="2" ry="2"/>"#,
+        name = escape(name),
+        count = node.count,
+        height = FRAME_HEIGHT - 1.0,
+        fill = color(name),
+    );
+    let fits = ((width - 6.0) / CHAR_WIDTH) as usize;
+    if fits >= 3 {
+        let label = if name.chars().count() <= fits {
+            name.to_string()
+        } else {
+            format!("{}..", name.chars().take(fits - 2).collect::<String>())
+        };
+        let _ = writeln!(
+            svg,
+            r#"<text x="{:.1}" y="{:.1}">{}</text>"#,
+            x + 3.0,
+            y + FRAME_HEIGHT - 4.5,
+            escape(&label)
+        );
+    }
+    svg.push_str("</g>\n");
+
+    let mut at = x;
+    for (child_name, child) in &node.children {
+        draw(svg, child_name, child, total, at, y - FRAME_HEIGHT, scale);
+        at += child.count as f64 * scale;
+    }
+}
+
+/// A warm color that stays the same for a name from one graph to the next
+fn color(name: &str) -> String {
+    // FNV-1a
+    let hash = name.bytes().fold(0xcbf29ce484222325u64, |hash, byte| {
+        (hash ^ byte as u64).wrapping_mul(0x100000001b3)
+    });
+    let r = 205 + (hash % 50);
+    let g = (hash >> 8) % 230;
+    let b = (hash >> 16) % 55;
+    format!("rgb({},{},{})", r, g, b)
+}
+
+fn escape(text: &str) -> String {
+    text.replace('&', "&amp;").replace('<', "&lt;").replace('>', "&gt;").replace('"', "&quot;")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn stack(frames: &[&str], count: u64) -> ProfileStack {
+        ProfileStack { frames: frames.iter().map(|frame| frame.to_string()).collect(), count }
+    }
+
+    #[test]
+    fn callees_sit_on_their_callers() {
+        let stacks = [stack(&["main"], 1), stack(&["main", "parse<T>"], 3)];
+        assert_eq!(folded(&stacks), "main 1\nmain;parse<T> 3\n");
+
+        let svg = flamegraph(&stacks, "Profile");
+        assert!(svg.contains("<title>all (4 samples, 100.00%)</title>"));
+        assert!(svg.contains("<title>main (4 samples, 100.00%)</title>"));
+        assert!(svg.contains("<title>parse&lt;T&gt; (3 samples, 75.00%)</title>"));
+        // Frames stack upwards from the bottom, one row per depth
+        assert!(svg.contains(r#"<rect x="10.0" y="68.0" width="1180.0""#));
+        assert!(svg.contains(r#"<rect x="10.0" y="36.0" width="885.0""#));
+        assert!(svg.ends_with("</svg>\n"));
+    }
+}
@@ -0,0 +1,82 @@
+//! Python scripting bridge
+//!
+//! `debugger python script.py` runs the script with a `dbg` module on its
+//! path. The module drives the session by running this same binary with
+//! `--output json`, so there is no interpreter to embed and scripts get the
+//! daemon's results exactly as the CLI does.
+
+use std::ffi::OsString;
+use std::path::Path;
+
+use crate::common::{Error, Result};
+
+/// The `dbg` module, written next to nothing else in a temporary directory
+const MODULE: &str = include_str!("python/dbg.py");
+
+/// The interpreter: `$DEBUGGER_PYTHON`, then `python3`, then `python`
+fn interpreter() -> Result<OsString> {
+    if let Some(python) = std::env::var_os("DEBUGGER_PYTHON").filter(|p| !p.is_empty()) {
+        return Ok(python);
+    }
+
+    ["python3", "python"]
+        .iter()
+        .find_map(|name| which::which(name).ok())
+        .map(OsString::from)
+        .ok_or_else(|| {
+            Error::Python("no python3 or python on PATH; set DEBUGGER_PYTHON".to_string())
+        })
+}
+
+/// `PYTHONPATH` with the module directory in front of any existing entries
+fn python_path(module_dir: &Path) -> Result<OsString> {
+    let mut paths = vec![module_dir.to_path_buf()];
+    if let Some(existing) = std::env::var_os("PYTHONPATH") {
+        paths.extend(std::env::split_paths(&existing));
+    }
+    std::env::join_paths(paths).map_err(|e| Error::Python(e.to_string()))
+}
+
+/// Run `script` with `args` and wait for it, failing if it exits non-zero
+pub fn run(script: &Path, args: &[String]) -> Result<()> {
+    if !script.is_file() {
+        return Err(Error::FileRead {
+            path: script.display().to_string(),
+            error: "not a file".to_string(),
+        });
+    }
+
+    let python = interpreter()?;
+    let module_dir = tempfile::tempdir()?;
+    std::fs::write(module_dir.path().join("dbg.py"), MODULE)?;
+
+    let status = std::process::Command::new(&python)
+        .arg(script)
+        .args(args)
+        .env("PYTHONPATH", python_path(module_dir.path())?)
+        .env("DEBUGGER_EXE", std::env::current_exe()?)
+        .status()
+        .map_err(|e| Error::Python(format!("{}: {}", python.to_string_lossy(), e)))?;
+
+    if status.success() {
+        Ok(())
+    } else {
+        Err(Error::Python(format!(
+            "{} exited with {}",
+            script.display(),
+            status
+        )))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn module_dir_comes_first_on_python_path() {
+        let path = python_path(Path::new("/tmp/dbg-module")).unwrap();
+        let first = std::env::split_paths(&path).next().unwrap();
+        assert_eq!(first, Path::new("/tmp/dbg-module"));
+    }
+}
@@ -0,0 +1,256 @@
+"""Session API for scripts run with `debugger python`
+
+Every call runs the debugger CLI with `--output json` against the same
+daemon, so scripts see exactly what the CLI sees and hooks still apply.
+Names follow GDB's Python API where one exists (`execute`,
+`parse_and_eval`, `breakpoints`) so GDB scripts port with small changes.
+"""
+
+import json
+import os
+import re
+import shlex
+import subprocess
+import sys
+
+__all__ = [
+    "DebuggerError",
+    "Value",
+    "run",
+    "execute",
+    "parse_and_eval",
+    "evaluate",
+    "locals",
+    "backtrace",
+    "threads",
+    "context",
+    "status",
+    "breakpoints",
+    "dwarf",
+    "set_breakpoint",
+    "remove_breakpoint",
+    "cont",
+    "next",
+    "step",
+    "finish",
+    "pause",
+    "wait",
+    "register_pretty_printer",
+    "format_value",
+]
+
+_EXE = os.environ.get("DEBUGGER_EXE", "debugger")
+
+
+class DebuggerError(Exception):
+    """A command failed; `code` is the JSON error code, e.g. SESSION_NOT_ACTIVE"""
+
+    def __init__(self, code, message):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+
+
+def _words(command):
+    return shlex.split(command) if isinstance(command, str) else list(command)
+
+
+def run(*args):
+    """Run one CLI command and return its JSON `data`
+
+    `run("backtrace", "--limit", "5")` is `debugger -o json backtrace --limit 5`.
+    """
+    proc = subprocess.run(
+        [_EXE, "--output", "json", *map(str, args)],
+        stdout=subprocess.PIPE,
+        stdin=subprocess.DEVNULL,
+        text=True,
+    )
+    lines = [line for line in proc.stdout.splitlines() if line.startswith("{")]
+    if not lines:
+        raise DebuggerError("INTERNAL_ERROR", "no output from: " + " ".join(args))
+
+    # Hooks print their own results first; the command's is the last line
+    envelope = json.loads(lines[-1])
+    if not envelope.get("ok"):
+        error = envelope.get("error") or {}
+        raise DebuggerError(error.get("code", "INTERNAL_ERROR"), error.get("message", ""))
+    return envelope.get("data")
+
+
+def execute(command, to_string=False):
+    """Run a command line as typed at the shell, like `gdb.execute`
+
+    The text output is printed, or returned when `to_string` is true.
+    """
+    proc = subprocess.run(
+        [_EXE, *_words(command)],
+        stdout=subprocess.PIPE if to_string else None,
+        stderr=subprocess.PIPE,
+        stdin=subprocess.DEVNULL,
+        text=True,
+    )
+    if proc.returncode != 0:
+        message = proc.stderr.strip()
+        if message.startswith("Error: "):
+            message = message[len("Error: "):]
+        raise DebuggerError("COMMAND_FAILED", message)
+    if proc.stderr:
+        sys.stderr.write(proc.stderr)
+    return proc.stdout if to_string else None
+
+
+class Value:
+    """An evaluated expression
+
+    `str()` gives the adapter's rendering; indexing evaluates a member or
+    element of the same expression, as `gdb.Value` does, so
+    `value["next"]["len"]` reads `(expr).next.len`.
+    """
+
+    def __init__(self, expression, result, type_name=None, variables_reference=0):
+        self.expression = expression
+        self.result = result
+        self.type = type_name
+        self.variables_reference = variables_reference
+
+    def __getitem__(self, key):
+        if isinstance(key, int):
+            return parse_and_eval("({})[{}]".format(self.expression, key))
+        return parse_and_eval("({}).{}".format(self.expression, key))
+
+    def __str__(self):
+        return self.result
+
+    def __repr__(self):
+        return "Value({!r}, {!r}, type={!r})".format(self.expression, self.result, self.type)
+
+    def __int__(self):
+        return int(self.result.split()[0], 0)
+
+    def __float__(self):
+        return float(self.result)
+
+    def __bool__(self):
+        return self.result not in ("0", "false", "False", "nil", "None", "0x0")
+
+    def __eq__(self, other):
+        if isinstance(other, Value):
+            return self.result == other.result
+        return self.result == str(other)
+
+    __hash__ = None
+
+
+def parse_and_eval(expression):
+    """Evaluate an expression in the selected frame, like `gdb.parse_and_eval`"""
+    data = run("print", expression)["value"]
+    return Value(
+        expression,
+        data["result"],
+        data.get("type_name"),
+        data.get("variables_reference", 0),
+    )
+
+
+def evaluate(expression):
+    """Evaluate an expression that may have side effects (`eval`)"""
+    return run("eval", expression)["value"]
+
+
+def locals():
+    """Variables of the selected frame: [{name, value, type_name, ...}]"""
+    return run("locals")["variables"]
+
+
+def backtrace(limit=20):
+    """Stack frames of the selected thread: [{id, name, source, line, column}]"""
+    return run("backtrace", "--limit", limit)["frames"]
+
+
+def threads():
+    return run("threads")["threads"]
+
+
+def context(lines=None):
+    args = ["context"] if lines is None else ["context", "--lines", lines]
+    return run(*args)
+
+
+def status():
+    return run("status")
+
+
+def breakpoints():
+    """Breakpoints as dicts: [{id, verified, enabled, source, line, ...}]"""
+    return run("breakpoint", "list")["breakpoints"]
+
+
+def dwarf(query, *args):
+    """Query the debug info: `dwarf("types", "--filter", "Worker")["types"]`"""
+    return run("dwarf", query, *args)
+
+
+def set_breakpoint(location, condition=None, hit_count=None):
+    """Set a breakpoint at `file:line` or a function name and return it"""
+    args = ["break", location]
+    if condition is not None:
+        args += ["--condition", condition]
+    if hit_count is not None:
+        args += ["--hit-count", hit_count]
+    return run(*args)
+
+
+def remove_breakpoint(breakpoint_id):
+    return run("breakpoint", "remove", breakpoint_id)
+
+
+def cont():
+    """Resume the program (`continue` is a Python keyword)"""
+    run("continue")
+
+
+def next():
+    run("next")
+
+
+def step():
+    run("step")
+
+
+def finish():
+    run("finish")
+
+
+def pause():
+    run("pause")
+
+
+def wait(timeout=300):
+    """Wait for the next stop and return it: {reason, thread_id, source, line, ...}"""
+    return run("await", "--timeout", timeout)
+
+
+_printers = []
+
+
+def register_pretty_printer(type_pattern, printer):
+    """Format values whose type matches `type_pattern` with `printer`
+
+    `printer` is called with a `Value` and may return a string, or an object
+    with a GDB-style `to_string()` method.
+    """
+    _printers.append((re.compile(type_pattern), printer))
+
+
+def format_value(value):
+    """Render a Value (or expression) through the registered pretty-printers"""
+    if isinstance(value, str):
+        value = parse_and_eval(value)
+    for pattern, printer in _printers:
+        if value.type and pattern.search(value.type):
+            printed = printer(value)
+            if hasattr(printed, "to_string"):
+                printed = printed.to_string()
+            return str(printed)
+    return str(value)
@@ -0,0 +1,51 @@
+//! Printing recorded steps for `replay list` and the replayed views
+
+use serde_json::Value;
+
+/// One entry of `replay list` as a line; `>` marks the step the views show
+/// and `locals` the steps with a snapshot
+pub fn render(step: &Value, cursor: Option<u64>) -> String {
+    let number = step["step"].as_u64().unwrap_or_default();
+    let marker = if cursor == Some(number) { ">" } else { " " };
+    let location = match (step["source"].as_str(), step["line"].as_u64()) {
+        (Some(source), Some(line)) => format!(" at {}:{}", source, line),
+        _ => String::new(),
+    };
+    format!(
+        "{}{:>6} [{}] {}{}{}",
+        marker,
+        number,
+        step["thread_id"],
+        step["function"].as_str().unwrap_or("?"),
+        location,
+        if step["snapshot"].as_bool().unwrap_or(false) { "  (locals)" } else { "" }
+    )
+}
+
+/// Say so when a view shows a recorded step rather than the program
+pub fn banner(result: &Value) {
+    if let Some(step) = result["replay_step"].as_u64() {
+        println!("Replaying step {} ('replay exit' to see the program)", step);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn steps_show_where_the_program_was() {
+        let step = serde_json::json!({
+            "step": 12,
+            "thread_id": 1,
+            "function": "run",
+            "source": "/src/t.c",
+            "line": 10,
+            "snapshot": true,
+        });
+        assert_eq!(render(&step, Some(12)), ">    12 [1] run at /src/t.c:10  (locals)");
+
+        let bare = serde_json::json!({ "step": 3, "thread_id": 1, "function": "memcpy" });
+        assert_eq!(render(&bare, Some(12)), "      3 [1] memcpy");
+    }
+}
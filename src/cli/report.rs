@@ -0,0 +1,197 @@
+//! Session reports for `report generate`
+//!
+//! A report is a template (see [`super::template`]) rendered with what the
+//! daemon knows about the session: status, the stops so far, a backtrace of
+//! every thread, locals, watches, breakpoints, recent program output, the
+//! expressions given with `--eval`, and where the report was made.
+//! `report data` prints that data as JSON, for writing templates. Without
+//! `--template` the built-in Markdown report is used, or the HTML one when
+//! the report is written to an `.html` file.
+
+use std::path::Path;
+
+use serde_json::{json, Value};
+
+use crate::common::time::timestamp;
+use crate::common::{Error, Result};
+use crate::ipc::protocol::{Command, EvaluateContext};
+use crate::ipc::DaemonClient;
+
+use super::template::Template;
+
+const MARKDOWN: &str = include_str!("report/report.md.tmpl");
+const HTML: &str = include_str!("report/report.html.tmpl");
+
+/// Frames shown per thread
+const BACKTRACE_LIMIT: usize = 32;
+
+/// Lines of program output included
+const OUTPUT_LINES: usize = 50;
+
+/// Render a report with `template`, or the built-in one for `file`'s format
+pub async fn generate(
+    template: Option<&Path>,
+    file: Option<&Path>,
+    expressions: &[String],
+) -> Result<String> {
+    let (name, text) = match template {
+        Some(path) => {
+            let text = std::fs::read_to_string(path).map_err(|e| Error::FileRead {
+                path: path.display().to_string(),
+                error: e.to_string(),
+            })?;
+            (path.display().to_string(), text)
+        }
+        None if file.is_some_and(is_html) => ("report.html.tmpl".to_string(), HTML.to_string()),
+        None => ("report.md.tmpl".to_string(), MARKDOWN.to_string()),
+    };
+    let html = match template {
+        Some(path) => is_html(path),
+        None => file.is_some_and(is_html),
+    };
+
+    let template = Template::parse(&name, &text)?;
+    template.render(&data(expressions).await?, html)
+}
+
+/// Whether a report or template is HTML: `crash.html`, `crash.html.tmpl`
+fn is_html(path: &Path) -> bool {
+    let name = path.file_name().unwrap_or_default().to_string_lossy();
+    let name = name.strip_suffix(".tmpl").unwrap_or(&name);
+    name.ends_with(".html") || name.ends_with(".htm")
+}
+
+/// Everything a template can use
+pub async fn data(expressions: &[String]) -> Result<Value> {
+    let mut client = DaemonClient::connect().await?;
+    let session = client.send_command(Command::Status).await?;
+    let active = session["session_active"].as_bool().unwrap_or(false);
+    let stopped = session["state"].as_str() == Some("stopped");
+
+    let mut data = json!({
+        "session": session,
+        "environment": environment(),
+        "stops": [],
+        "threads": [],
+        "locals": [],
+        "expressions": [],
+        "watches": [],
+        "breakpoints": [],
+        "output": "",
+    });
+    if !active {
+        return Ok(data);
+    }
+
+    data["stops"] = field(&mut client, Command::StopHistory, "stops").await;
+    data["breakpoints"] = field(&mut client, Command::BreakpointList, "breakpoints").await;
+    data["watches"] = field(&mut client, Command::WatchList, "watches").await;
+    let output = field(
+        &mut client,
+        Command::GetOutput {
+            tail: Some(OUTPUT_LINES),
+            clear: false,
+            since_last_stop: false,
+        },
+        "output",
+    )
+    .await;
+    data["output"] = json!(output.as_str().unwrap_or_default().trim_end());
+
+    if stopped {
+        data["threads"] = threads(&mut client).await;
+        data["locals"] = field(&mut client, Command::Locals { frame_id: None }, "variables").await;
+    }
+
+    let mut evaluated = Vec::new();
+    for expression in expressions {
+        let result = client
+            .send_command(Command::Evaluate {
+                expression: expression.clone(),
+                frame_id: None,
+                context: EvaluateContext::Watch,
+                limit: None,
+            })
+            .await;
+        evaluated.push(match result {
+            Ok(value) => json!({
+                "expression": expression,
+                "value": value["result"],
+                "type": value["type_name"],
+                "error": null,
+            }),
+            Err(e) => json!({
+                "expression": expression,
+                "value": null,
+                "type": null,
+                "error": e.to_string(),
+            }),
+        });
+    }
+    data["expressions"] = Value::Array(evaluated);
+
+    Ok(data)
+}
+
+/// One field of a command's result, or null if the command fails
+async fn field(client: &mut DaemonClient, command: Command, name: &str) -> Value {
+    client
+        .send_command(command)
+        .await
+        .map(|mut result| result[name].take())
+        .unwrap_or(Value::Null)
+}
+
+/// Every thread with its backtrace
+async fn threads(client: &mut DaemonClient) -> Value {
+    let Value::Array(mut threads) = field(client, Command::Threads, "threads").await else {
+        return json!([]);
+    };
+    for thread in &mut threads {
+        let frames = field(
+            client,
+            Command::StackTrace {
+                thread_id: thread["id"].as_i64(),
+                limit: BACKTRACE_LIMIT,
+                start: 0,
+                more: false,
+            },
+            "frames",
+        )
+        .await;
+        thread["frames"] = frames;
+    }
+    Value::Array(threads)
+}
+
+fn environment() -> Value {
+    json!({
+        "generated": timestamp(),
+        "debugger_version": env!("CARGO_PKG_VERSION"),
+        "os": std::env::consts::OS,
+        "arch": std::env::consts::ARCH,
+        "cwd": std::env::current_dir().ok().map(|dir| dir.display().to_string()),
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn built_in_templates_render_an_idle_daemon() {
+        let data = json!({
+            "session": { "session_active": false },
+            "environment": environment(),
+            "stops": [], "threads": [], "locals": [], "expressions": [],
+            "watches": [], "breakpoints": [], "output": "",
+        });
+        for (name, text) in [("report.md.tmpl", MARKDOWN), ("report.html.tmpl", HTML)] {
+            let report = Template::parse(name, text).unwrap().render(&data, false).unwrap();
+            assert!(report.contains("No debug session"), "{}:\n{}", name, report);
+        }
+        assert!(is_html(Path::new("out/crash.html")));
+        assert!(is_html(Path::new("crash.html.tmpl")));
+        assert!(!is_html(Path::new("crash.md.tmpl")));
+    }
+}
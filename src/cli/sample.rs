@@ -0,0 +1,86 @@
+//! Exporting sampled values for `sample export`
+
+use crate::ipc::protocol::{SampleValue, SamplerInfo};
+
+/// Column names of the CSV export
+const HEADER: &str = "elapsed_ms,time,id,expression,value,error";
+
+/// One row per value, oldest first
+pub fn csv(samples: &[SamplerInfo], values: &[SampleValue]) -> String {
+    let mut text = format!("{}\n", HEADER);
+    for value in values {
+        let expression = samples
+            .iter()
+            .find(|sample| sample.id == value.id)
+            .map(|sample| sample.expression.as_str())
+            .unwrap_or_default();
+        text.push_str(&format!(
+            "{},{},{},{},{},{}\n",
+            value.elapsed_ms,
+            value.time,
+            value.id,
+            field(expression),
+            field(value.value.as_deref().unwrap_or_default()),
+            field(value.error.as_deref().unwrap_or_default()),
+        ));
+    }
+    text
+}
+
+/// Quote a field that holds a comma, quote or line break
+fn field(text: &str) -> String {
+    if text.contains([',', '"', '\n', '\r']) {
+        format!("\"{}\"", text.replace('"', "\"\""))
+    } else {
+        text.to_string()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::parse_interval;
+    use std::time::Duration;
+
+    #[test]
+    fn csv_quotes_fields_that_need_it() {
+        let samples = [SamplerInfo {
+            id: 1,
+            expression: "pair.first, pair.second".to_string(),
+            every_ms: 100,
+            count: 2,
+            last: None,
+        }];
+        let value = |elapsed_ms, value: Option<&str>, error: Option<&str>| SampleValue {
+            id: 1,
+            elapsed_ms,
+            time: "2026-01-25 14:03:07".to_string(),
+            value: value.map(String::from),
+            error: error.map(String::from),
+        };
+        let text = csv(
+            &samples,
+            &[
+                value(0, Some("\"ab\""), None),
+                value(100, None, Some("no frame")),
+            ],
+        );
+        let lines: Vec<&str> = text.lines().collect();
+        assert_eq!(lines[0], HEADER);
+        assert_eq!(
+            lines[1],
+            "0,2026-01-25 14:03:07,1,\"pair.first, pair.second\",\"\"\"ab\"\"\","
+        );
+        assert!(lines[2].ends_with(",,no frame"));
+    }
+
+    #[test]
+    fn intervals_take_a_unit() {
+        assert_eq!(parse_interval("100ms"), Ok(Duration::from_millis(100)));
+        assert_eq!(parse_interval("2s"), Ok(Duration::from_secs(2)));
+        assert_eq!(parse_interval("1.5m"), Ok(Duration::from_secs(90)));
+        assert_eq!(parse_interval("250"), Ok(Duration::from_millis(250)));
+        assert!(parse_interval("0ms").is_err());
+        assert!(parse_interval("5h").is_err());
+    }
+}
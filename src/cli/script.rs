@@ -0,0 +1,419 @@
+//! Command scripts
+//!
+//! Parses debugger command lines (as written in `.dbginit` and similar files)
+//! into the same clap `Commands` the CLI accepts, so a scripted `break main.c:10`
+//! behaves exactly like typing `debugger break main.c:10`.
+
+use std::collections::HashMap;
+use std::path::Path;
+
+use clap::{CommandFactory, Parser};
+
+use crate::commands::Commands;
+use crate::common::{Error, Result};
+
+use super::user;
+
+/// Wrapper that lets clap parse a single script line without a binary name
+#[derive(Parser)]
+#[command(no_binary_name = true, disable_help_flag = true, disable_version_flag = true)]
+struct ScriptLine {
+    #[command(subcommand)]
+    command: Commands,
+}
+
+/// A parsed command with the 1-based line it came from
+pub struct ScriptCommand {
+    pub line: usize,
+    pub text: String,
+    pub command: Commands,
+}
+
+/// Values for `${name}` in sourced scripts and batch files
+///
+/// `-D NAME=VALUE` definitions come first, then `${script_dir}` (the
+/// directory of the script being read), then the environment.
+#[derive(Debug, Default, Clone)]
+pub struct Variables {
+    defined: HashMap<String, String>,
+}
+
+impl Variables {
+    /// Parse `NAME=VALUE` definitions
+    pub fn parse(definitions: &[String]) -> Result<Self> {
+        let mut defined = HashMap::new();
+        for definition in definitions {
+            match definition.split_once('=') {
+                Some((name, value)) if !name.is_empty() => {
+                    defined.insert(name.to_string(), value.to_string());
+                }
+                _ => {
+                    return Err(Error::Config(format!(
+                        "expected NAME=VALUE, got '{}'",
+                        definition
+                    )))
+                }
+            }
+        }
+        Ok(Self { defined })
+    }
+
+    /// Replace `${name}` in a command line that is not from a file
+    pub fn interpolate(&self, line: &str) -> String {
+        interpolate(line, |name| self.lookup(name, Path::new("")))
+    }
+
+    fn lookup(&self, name: &str, script: &Path) -> Option<String> {
+        if let Some(value) = self.defined.get(name) {
+            return Some(value.clone());
+        }
+        if name == "script_dir" {
+            let dir = script.parent().filter(|dir| !dir.as_os_str().is_empty());
+            return Some(dir.unwrap_or(Path::new(".")).display().to_string());
+        }
+        std::env::var(name).ok()
+    }
+}
+
+/// Read and parse every command in a script file
+///
+/// Blank lines and lines starting with `#` are skipped, and a `define` takes
+/// the lines up to its `end` as its body. Parsing stops at the first invalid
+/// line so that no command runs from a half-understood script.
+pub fn load(path: &Path) -> Result<Vec<ScriptCommand>> {
+    parse_lines(path, &read(path)?, None)
+}
+
+/// Like `load`, replacing `${name}` in each line first
+pub fn load_with(path: &Path, variables: &Variables) -> Result<Vec<ScriptCommand>> {
+    parse_lines(path, &read(path)?, Some(variables))
+}
+
+fn read(path: &Path) -> Result<String> {
+    std::fs::read_to_string(path).map_err(|e| Error::FileRead {
+        path: path.display().to_string(),
+        error: e.to_string(),
+    })
+}
+
+/// Parse script content; `path` is only used in error messages
+pub fn parse(path: &Path, content: &str) -> Result<Vec<ScriptCommand>> {
+    parse_lines(path, content, None)
+}
+
+fn parse_lines(
+    path: &Path,
+    content: &str,
+    variables: Option<&Variables>,
+) -> Result<Vec<ScriptCommand>> {
+    let mut commands = Vec::new();
+    let mut defined = Vec::new();
+    let mut lines = content.lines().enumerate();
+
+    while let Some((index, raw)) = lines.next() {
+        let text = raw.trim();
+        if text.is_empty() || text.starts_with('#') {
+            continue;
+        }
+        let text = match variables {
+            Some(variables) => interpolate(text, |name| variables.lookup(name, path)),
+            None => text.to_string(),
+        };
+        let text = text.as_str();
+
+        let mut command = parse_words(text, |word| {
+            defined.iter().any(|name| name == word) || user::exists(word)
+        })
+        .map_err(|message| Error::Script {
+            path: path.display().to_string(),
+            line: index + 1,
+            message,
+        })?;
+
+        // The body is kept as written; its `${argN}` are filled in per call
+        if let Commands::Define {
+            name,
+            body: body @ None,
+            ..
+        } = &mut command
+        {
+            let mut collector = user::Collector::default();
+            if !lines.by_ref().any(|(_, raw)| collector.push(raw)) {
+                return Err(Error::Script {
+                    path: path.display().to_string(),
+                    line: index + 1,
+                    message: "'define' without a matching 'end'".to_string(),
+                });
+            }
+            *body = Some(collector.into_lines());
+            // Later lines may already call it
+            defined.push(name.clone());
+        }
+
+        commands.push(ScriptCommand {
+            line: index + 1,
+            text: text.to_string(),
+            command,
+        });
+    }
+
+    Ok(commands)
+}
+
+/// Parse a single command line into a CLI command
+pub fn parse_line(line: &str) -> std::result::Result<Commands, String> {
+    parse_words(line, user::exists)
+}
+
+/// `parse_line`, with `is_user` telling which unknown names are user commands
+fn parse_words(
+    line: &str,
+    is_user: impl Fn(&str) -> bool,
+) -> std::result::Result<Commands, String> {
+    let mut words = split_words(line)?;
+
+    // `NAME ARGS` runs the user-defined command NAME
+    let user_command = words
+        .first()
+        .is_some_and(|word| command_name(word).is_none() && is_user(word));
+    if user_command {
+        words.splice(0..0, ["user".to_string(), "run".to_string()]);
+    }
+
+    let command = ScriptLine::try_parse_from(words)
+        .map(|parsed| parsed.command)
+        .map_err(|e| {
+            // Clap renders a multi-line usage block; the first line carries the
+            // actual problem and reads well after a "file:line:" prefix.
+            let rendered = e.to_string();
+            rendered
+                .lines()
+                .next()
+                .unwrap_or("invalid command")
+                .trim_start_matches("error: ")
+                .to_string()
+        })?;
+
+    // The hidden daemon entry point only makes sense as the binary's argv
+    if matches!(command, Commands::Daemon { action: None }) {
+        return Err("'daemon' cannot be run from a script".to_string());
+    }
+
+    Ok(command)
+}
+
+/// Resolve a command name or alias (`c`, `bt`) to its canonical name
+pub fn command_name(name: &str) -> Option<String> {
+    ScriptLine::command()
+        .find_subcommand(name)
+        .map(|command| command.get_name().to_string())
+}
+
+/// Replace `${name}` in a command line with `lookup(name)`
+///
+/// Names `lookup` does not know are left as written, so a stray `${x}` shows
+/// up in the command's error instead of silently becoming empty.
+pub fn interpolate(line: &str, lookup: impl Fn(&str) -> Option<String>) -> String {
+    let mut result = String::with_capacity(line.len());
+    let mut rest = line;
+
+    while let Some(start) = rest.find("${") {
+        let Some(len) = rest[start + 2..].find('}') else {
+            break;
+        };
+        let name = &rest[start + 2..start + 2 + len];
+        result.push_str(&rest[..start]);
+        match lookup(name) {
+            Some(value) => result.push_str(&value),
+            None => result.push_str(&rest[start..start + 3 + len]),
+        }
+        rest = &rest[start + 3 + len..];
+    }
+
+    result.push_str(rest);
+    result
+}
+
+/// Join words into a line that `split_words` splits back into them,
+/// single-quoting any word with spaces, quotes or backslashes
+pub fn join_words(words: &[String]) -> String {
+    words
+        .iter()
+        .map(|word| {
+            let plain = !word.is_empty()
+                && !word.contains(|c: char| c.is_whitespace() || matches!(c, '"' | '\'' | '\\'));
+            if plain {
+                word.clone()
+            } else if !word.contains('\'') {
+                format!("'{}'", word)
+            } else {
+                // Single quotes cannot be escaped inside single quotes
+                format!("\"{}\"", word.replace('\\', "\\\\").replace('"', "\\\""))
+            }
+        })
+        .collect::<Vec<_>>()
+        .join(" ")
+}
+
+/// Split a line into words, honoring single quotes, double quotes and
+/// backslash escapes the way a POSIX shell would for simple cases.
+pub fn split_words(line: &str) -> std::result::Result<Vec<String>, String> {
+    let mut words = Vec::new();
+    let mut current = String::new();
+    let mut in_word = false;
+    let mut chars = line.chars();
+
+    while let Some(c) = chars.next() {
+        match c {
+            '\'' => {
+                in_word = true;
+                loop {
+                    match chars.next() {
+                        Some('\'') => break,
+                        Some(c) => current.push(c),
+                        None => return Err("unterminated single quote".to_string()),
+                    }
+                }
+            }
+            '"' => {
+                in_word = true;
+                loop {
+                    match chars.next() {
+                        Some('"') => break,
+                        Some('\\') => match chars.next() {
+                            Some(c @ ('"' | '\\')) => current.push(c),
+                            Some(c) => {
+                                current.push('\\');
+                                current.push(c);
+                            }
+                            None => return Err("unterminated double quote".to_string()),
+                        },
+                        Some(c) => current.push(c),
+                        None => return Err("unterminated double quote".to_string()),
+                    }
+                }
+            }
+            '\\' => {
+                in_word = true;
+                if let Some(c) = chars.next() {
+                    current.push(c);
+                }
+            }
+            c if c.is_whitespace() => {
+                if in_word {
+                    words.push(std::mem::take(&mut current));
+                    in_word = false;
+                }
+            }
+            c => {
+                in_word = true;
+                current.push(c);
+            }
+        }
+    }
+
+    if in_word {
+        words.push(current);
+    }
+
+    Ok(words)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn split_words_handles_quotes() {
+        assert_eq!(
+            split_words(r#"break main.c:10 --condition "x > 5""#).unwrap(),
+            vec!["break", "main.c:10", "--condition", "x > 5"]
+        );
+        assert_eq!(split_words("print 'a b'").unwrap(), vec!["print", "a b"]);
+        assert_eq!(split_words(r"print a\ b").unwrap(), vec!["print", "a b"]);
+        assert!(split_words("print \"open").is_err());
+    }
+
+    #[test]
+    fn join_words_round_trips() {
+        let words: Vec<String> = ["print", "a b", "it's", r"C:\dir", "\"q\"", ""]
+            .iter()
+            .map(|w| w.to_string())
+            .collect();
+        assert_eq!(split_words(&join_words(&words)).unwrap(), words);
+    }
+
+    #[test]
+    fn parse_skips_comments_and_reports_line_numbers() {
+        let script = "# comment\n\nbreak main\nfrobnicate\n";
+        let err = parse(Path::new(".dbginit"), script).err().unwrap();
+        match err {
+            Error::Script { line, .. } => assert_eq!(line, 4),
+            other => panic!("unexpected error: {other}"),
+        }
+
+        let commands = parse(Path::new(".dbginit"), "# only\nb main\n").unwrap();
+        assert_eq!(commands.len(), 1);
+        assert_eq!(commands[0].line, 2);
+        assert!(matches!(commands[0].command, Commands::Break { .. }));
+    }
+
+    #[test]
+    fn assert_takes_the_rest_of_the_line() {
+        match parse_line("assert item->refs > -1").unwrap() {
+            Commands::Assert { expression } => assert_eq!(expression.join(" "), "item->refs > -1"),
+            _ => panic!("not an assert"),
+        }
+    }
+
+    #[test]
+    fn interpolate_replaces_known_names() {
+        let lookup = |name: &str| (name == "line").then(|| "42".to_string());
+        assert_eq!(interpolate("break main.c:${line}", lookup), "break main.c:42");
+        assert_eq!(interpolate("print ${x} ${line}", lookup), "print ${x} 42");
+        assert_eq!(interpolate("print ${line", lookup), "print ${line");
+    }
+
+    #[test]
+    fn load_with_substitutes_definitions_and_script_dir() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("recipe.dbg");
+        std::fs::write(&path, "break ${file}:${line}\nsource ${script_dir}/more.dbg\n").unwrap();
+
+        let variables = Variables::parse(&["file=main.c".into(), "line=7".into()]).unwrap();
+        let commands = load_with(&path, &variables).unwrap();
+        assert_eq!(commands[0].text, "break main.c:7");
+        assert_eq!(
+            commands[1].text,
+            format!("source {}/more.dbg", dir.path().display())
+        );
+
+        assert!(Variables::parse(&["no-equals".into()]).is_err());
+    }
+
+    #[test]
+    fn define_takes_the_lines_up_to_its_end() {
+        let script = "define twice\nif ${argc}\nnext\nend\nnext\nend\nbt\n";
+        let commands = parse(Path::new(".dbginit"), script).unwrap();
+        assert_eq!(commands.len(), 2);
+        assert!(parse(Path::new(".dbginit"), &format!("{script}twice\n")).is_ok());
+        match &commands[0].command {
+            Commands::Define { name, body, .. } => {
+                assert_eq!(name, "twice");
+                assert_eq!(body.as_ref().unwrap().len(), 4);
+            }
+            _ => panic!("expected a definition"),
+        }
+        assert_eq!(commands[1].line, 7);
+
+        let err = parse(Path::new(".dbginit"), "define open\nnext\n").err().unwrap();
+        assert!(err.to_string().contains("without a matching 'end'"));
+    }
+
+    #[test]
+    fn command_name_resolves_aliases() {
+        assert_eq!(command_name("c").as_deref(), Some("continue"));
+        assert_eq!(command_name("bt").as_deref(), Some("backtrace"));
+        assert_eq!(command_name("frobnicate"), None);
+    }
+}
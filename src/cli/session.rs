@@ -0,0 +1,153 @@
+//! Several sessions from one invocation
+//!
+//! Debugging a client and its server together takes one named session per
+//! program. `session new` spawns a session's daemon and selects it, so the
+//! `start`, `break` and other commands after it go to that session, and
+//! `session switch` goes back to one started earlier; like `--session`, the
+//! choice lasts for the rest of the invocation. `session broadcast` runs one
+//! command in several sessions at once, each as its own `debugger --session
+//! NAME` process, and labels every line they print with the session it came
+//! from.
+
+use std::process::Stdio;
+
+use serde_json::Value;
+use tokio::io::{AsyncBufReadExt, AsyncRead, BufReader};
+use tokio::process::Command as Process;
+
+use crate::common::{paths, Error, Result};
+use crate::ipc::DaemonClient;
+
+use super::{output, spawn};
+
+/// How a broadcast command ended in one session
+#[derive(Debug)]
+pub struct Delivery {
+    pub session: String,
+    pub ok: bool,
+}
+
+/// Start a daemon for `name` and select it
+pub async fn new(name: &str) -> Result<()> {
+    if DaemonClient::connect_to_session(name).await.is_ok() {
+        return Err(Error::SessionExists(name.to_string()));
+    }
+    paths::select_session(name)?;
+    spawn::ensure_daemon_running().await
+}
+
+/// Select `name`, which must already be running
+pub async fn switch(name: &str) -> Result<()> {
+    DaemonClient::connect_to_session(name)
+        .await
+        .map_err(|e| match e {
+            Error::DaemonNotRunning => Error::SessionDaemonNotRunning(name.to_string()),
+            e => e,
+        })?;
+    paths::select_session(name)
+}
+
+/// Sessions whose daemon answers, skipping sockets left by dead daemons
+pub async fn running() -> Vec<String> {
+    let mut sessions = Vec::new();
+    for name in paths::session_names() {
+        if DaemonClient::connect_to_session(&name).await.is_ok() {
+            sessions.push(name);
+        }
+    }
+    sessions
+}
+
+/// Run `command` in each of `to` (or every running session) at once,
+/// relaying their output as it arrives
+pub async fn broadcast(to: Vec<String>, command: &[String]) -> Result<Vec<Delivery>> {
+    let sessions = if to.is_empty() { running().await } else { to };
+    if sessions.is_empty() {
+        return Err(Error::Broadcast("no sessions are running".to_string()));
+    }
+    if let Some(name) = sessions.iter().find(|name| !paths::is_valid_session_name(name)) {
+        return Err(Error::Broadcast(format!("'{}' is not a valid session name", name)));
+    }
+
+    let exe = std::env::current_exe()?;
+    let json = output::is_json();
+    let mut runs = Vec::new();
+    for session in sessions {
+        let mut process = Process::new(&exe);
+        process.arg("--session").arg(&session);
+        if json {
+            process.args(["--output", "json"]);
+        }
+        process
+            .args(command)
+            .stdin(Stdio::null())
+            .stdout(Stdio::piped())
+            .stderr(Stdio::piped());
+        runs.push(tokio::spawn(run(session, process, json)));
+    }
+
+    let mut deliveries = Vec::new();
+    for run in runs {
+        deliveries.push(run.await.map_err(|e| Error::Internal(e.to_string()))??);
+    }
+    Ok(deliveries)
+}
+
+/// Run one session's copy of the command, relaying both of its streams
+async fn run(session: String, mut process: Process, json: bool) -> Result<Delivery> {
+    let mut child = process.spawn()?;
+    tokio::join!(
+        relay(&session, child.stdout.take(), json, false),
+        relay(&session, child.stderr.take(), false, true),
+    );
+    let status = child.wait().await?;
+    Ok(Delivery {
+        session,
+        ok: status.success(),
+    })
+}
+
+async fn relay(session: &str, stream: Option<impl AsyncRead + Unpin>, json: bool, stderr: bool) {
+    let Some(stream) = stream else {
+        return;
+    };
+    let mut lines = BufReader::new(stream).lines();
+    while let Ok(Some(line)) = lines.next_line().await {
+        let line = label(session, &line, json);
+        if stderr {
+            eprintln!("{}", line);
+        } else {
+            println!("{}", line);
+        }
+    }
+}
+
+/// Mark a line of a session's output with its name: a `[name]` prefix, or a
+/// "session" field on a JSON object
+fn label(session: &str, line: &str, json: bool) -> String {
+    if json {
+        if let Ok(Value::Object(mut object)) = serde_json::from_str::<Value>(line) {
+            object.insert("session".to_string(), Value::String(session.to_string()));
+            return Value::Object(object).to_string();
+        }
+    }
+    format!("[{}] {}", session, line)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn lines_are_labelled_with_their_session() {
+        assert_eq!(label("server", "Stopped at main", false), "[server] Stopped at main");
+
+        let line = label("client", r#"{"command":"status","ok":true}"#, true);
+        let value: Value = serde_json::from_str(&line).unwrap();
+        assert_eq!(value["session"], "client");
+        assert_eq!(value["command"], "status");
+
+        // Anything that is not an envelope keeps the text prefix
+        assert_eq!(label("client", "panic: oops", true), "[client] panic: oops");
+    }
+}
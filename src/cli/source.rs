@@ -0,0 +1,106 @@
+//! Source listings for `context` and the stop banner
+//!
+//! With `set inline-values on`, each line is followed by the current values
+//! of the locals it mentions, the way IDEs show inline hints.
+
+use crate::ipc::protocol::{SourceLine, VariableInfo};
+
+use super::theme::{self, Element};
+
+/// Longest value shown inline before it is cut off
+const MAX_INLINE_VALUE: usize = 40;
+
+/// Print source lines with line numbers, marking the current line
+///
+/// `locals` enables inline values.
+pub fn print_lines(lines: &[SourceLine], locals: Option<&[VariableInfo]>) {
+    for line in format_lines(lines, locals) {
+        println!("{}", line);
+    }
+}
+
+/// Source lines as printed by [`print_lines`]
+pub fn format_lines(lines: &[SourceLine], locals: Option<&[VariableInfo]>) -> Vec<String> {
+    lines
+        .iter()
+        .map(|line| {
+            let number = theme::paint(Element::LineNumber, &format!("{:>4}", line.number));
+            let hints = locals
+                .and_then(|locals| inline_values(&line.content, locals))
+                .map(|hints| format!("  {}", theme::paint(Element::InlineValue, &hints)))
+                .unwrap_or_default();
+
+            if line.is_current {
+                format!(
+                    "{} {} | {}{}",
+                    theme::paint(Element::CurrentLine, "->"),
+                    number,
+                    theme::paint(Element::CurrentLine, &line.content),
+                    hints
+                )
+            } else {
+                format!("   {} | {}{}", number, line.content, hints)
+            }
+        })
+        .collect()
+}
+
+/// `// a = 1, b = 2` for the locals named on a line, in order of first use
+fn inline_values(content: &str, locals: &[VariableInfo]) -> Option<String> {
+    let mut seen: Vec<&str> = Vec::new();
+    for word in content.split(|c: char| !(c.is_alphanumeric() || c == '_')) {
+        if !word.is_empty() && !seen.contains(&word) {
+            seen.push(word);
+        }
+    }
+
+    let values: Vec<String> = seen
+        .into_iter()
+        .filter_map(|word| locals.iter().find(|var| var.name == word))
+        .map(|var| format!("{} = {}", var.name, truncate(&var.value)))
+        .collect();
+
+    if values.is_empty() {
+        None
+    } else {
+        Some(format!("// {}", values.join(", ")))
+    }
+}
+
+fn truncate(value: &str) -> String {
+    // Multi-line values (structs in some adapters) stay on one line
+    let value = value.lines().next().unwrap_or("");
+    if value.chars().count() > MAX_INLINE_VALUE {
+        let cut: String = value.chars().take(MAX_INLINE_VALUE - 3).collect();
+        format!("{}...", cut)
+    } else {
+        value.to_string()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn var(name: &str, value: &str) -> VariableInfo {
+        VariableInfo {
+            name: name.to_string(),
+            value: value.to_string(),
+            type_name: None,
+            variables_reference: 0,
+            truncated_at: None,
+        }
+    }
+
+    #[test]
+    fn inline_values_match_whole_identifiers() {
+        let locals = [var("i", "3"), var("sum", "6"), var("s", "\"x\"")];
+
+        assert_eq!(
+            inline_values("    sum += values[i] + sum_of(i);", &locals).as_deref(),
+            Some("// sum = 6, i = 3")
+        );
+        assert_eq!(inline_values("return total;", &locals), None);
+        assert_eq!(truncate(&"a".repeat(50)).len(), MAX_INLINE_VALUE);
+    }
+}
@@ -0,0 +1,89 @@
+//! Remembering `set substitute-path` rules for each project
+//!
+//! The daemon's rules last until it exits; so that they need not be set
+//! again for every daemon, the rules in place after each `set
+//! substitute-path` are saved for the current directory, the way `layout`
+//! remembers its choice, and `start` sets them again before `.dbginit` runs.
+
+use std::path::PathBuf;
+
+use crate::common::settings::SubstitutePath;
+use crate::common::{paths, Error, Result};
+use crate::ipc::protocol::Command;
+use crate::ipc::DaemonClient;
+
+/// Save the daemon's rules as the current directory's
+pub fn remember(rules: &[SubstitutePath]) -> Result<()> {
+    let Some(project) = project_dir() else {
+        return Ok(());
+    };
+    let mut saved = load();
+    saved.retain(|(dir, _)| *dir != project);
+    saved.extend(rules.iter().map(|rule| (project.clone(), rule.clone())));
+    save(&saved)
+}
+
+/// Set the current directory's remembered rules in the daemon
+pub async fn restore(client: &mut DaemonClient) -> Result<()> {
+    let Some(project) = project_dir() else {
+        return Ok(());
+    };
+    for (_, rule) in load().into_iter().filter(|(dir, _)| *dir == project) {
+        client
+            .send_command(Command::Set {
+                name: "substitute-path".to_string(),
+                args: vec![
+                    rule.from.to_string_lossy().into_owned(),
+                    rule.to.to_string_lossy().into_owned(),
+                ],
+            })
+            .await?;
+    }
+    Ok(())
+}
+
+fn project_dir() -> Option<PathBuf> {
+    std::env::current_dir().ok()?.canonicalize().ok()
+}
+
+/// Remembered rules, one `directory<TAB>from<TAB>to` per line
+fn load() -> Vec<(PathBuf, SubstitutePath)> {
+    let Some(store) = paths::project_substitute_paths_path() else {
+        return Vec::new();
+    };
+    let Ok(content) = std::fs::read_to_string(store) else {
+        return Vec::new();
+    };
+
+    content
+        .lines()
+        .filter_map(|line| {
+            let mut fields = line.splitn(3, '\t');
+            let (dir, from, to) = (fields.next()?, fields.next()?, fields.next()?);
+            let rule = SubstitutePath {
+                from: PathBuf::from(from),
+                to: PathBuf::from(to),
+            };
+            Some((PathBuf::from(dir), rule))
+        })
+        .collect()
+}
+
+fn save(saved: &[(PathBuf, SubstitutePath)]) -> Result<()> {
+    paths::ensure_config_dir()?;
+    let store = paths::project_substitute_paths_path()
+        .ok_or_else(|| Error::Config("Could not determine config directory".to_string()))?;
+
+    let mut content = String::new();
+    for (dir, rule) in saved {
+        for field in [dir, &rule.from, &rule.to] {
+            content.push_str(&field.to_string_lossy());
+            content.push('\t');
+        }
+        content.pop();
+        content.push('\n');
+    }
+
+    std::fs::write(store, content)?;
+    Ok(())
+}
@@ -3,6 +3,7 @@
 //! Automatically spawns the daemon process when needed, using the same binary
 //! with the hidden `daemon` subcommand.
 
+use std::path::Path;
 use std::time::Duration;
 
 use crate::common::{paths, Error, Result};
@@ -13,12 +14,22 @@ const SPAWN_TIMEOUT_SECS: u64 = 5;
 
 /// Ensure the daemon is running, spawning it if necessary
 pub async fn ensure_daemon_running() -> Result<()> {
+    // Get path to current executable
+    let exe_path = std::env::current_exe().map_err(|e| {
+        Error::Internal(format!("Failed to get current executable path: {}", e))
+    })?;
+    ensure_daemon_running_from(&exe_path).await
+}
+
+/// Ensure the daemon is running, spawning it from the debugger binary at
+/// `exe_path` if necessary
+pub async fn ensure_daemon_running_from(exe_path: &Path) -> Result<()> {
     // Try to connect first
     match DaemonClient::connect().await {
         Ok(_) => return Ok(()), // Already running
         Err(Error::DaemonNotRunning) => {
             // Need to spawn
-            spawn_daemon().await?;
+            spawn_daemon(exe_path).await?;
         }
         Err(e) => return Err(e),
     }
@@ -27,14 +38,9 @@ pub async fn ensure_daemon_running() -> Result<()> {
 }
 
 /// Spawn the daemon process
-async fn spawn_daemon() -> Result<()> {
+async fn spawn_daemon(exe_path: &Path) -> Result<()> {
     tracing::debug!("Spawning daemon process");
 
-    // Get path to current executable
-    let exe_path = std::env::current_exe().map_err(|e| {
-        Error::Internal(format!("Failed to get current executable path: {}", e))
-    })?;
-
     // Ensure socket directory exists
     paths::ensure_socket_dir()?;
 
@@ -54,7 +60,7 @@ async fn spawn_daemon() -> Result<()> {
         let dev_null_out = File::create("/dev/null")
             .map_err(|e| Error::Internal(format!("Failed to open /dev/null for write: {}", e)))?;
         
-        std::process::Command::new(&exe_path)
+        std::process::Command::new(exe_path)
             .arg("daemon")
             .stdin(std::process::Stdio::from(dev_null))
             .stdout(std::process::Stdio::from(dev_null_out.try_clone().unwrap()))
@@ -69,7 +75,7 @@ async fn spawn_daemon() -> Result<()> {
         use std::os::windows::process::CommandExt;
         const DETACHED_PROCESS: u32 = 0x00000008;
         const CREATE_NEW_PROCESS_GROUP: u32 = 0x00000200;
-        std::process::Command::new(&exe_path)
+        std::process::Command::new(exe_path)
             .arg("daemon")
             .stdin(std::process::Stdio::null())
             .stdout(std::process::Stdio::null())
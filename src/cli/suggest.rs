@@ -0,0 +1,106 @@
+//! "Did you mean" suggestions for names that do not resolve
+//!
+//! Mistyped subcommands are caught by clap, which already names the closest
+//! one; on a terminal we also offer to run it. Breakpoint locations are
+//! checked against the program's symbol index and `print` identifiers
+//! against the frame's locals. Offers are never made in batch mode, with
+//! JSON output, or without a terminal, so agents get the suggestions as data
+//! and scripts never wait on a question.
+
+use std::io::{BufRead, IsTerminal, Write};
+
+use crate::ipc::protocol::{BreakpointLocation, Command, FindKind, VariableInfo};
+use crate::ipc::DaemonClient;
+use crate::symbols::fuzzy;
+
+use super::{batch, output};
+
+/// Suggestions shown at most
+pub const MAX_SUGGESTIONS: usize = 3;
+
+/// Ask a yes/no question on the terminal; no means no answer was possible
+pub fn confirm(question: &str) -> bool {
+    if batch::is_active() || output::is_json() || !std::io::stdin().is_terminal() {
+        return false;
+    }
+
+    eprint!("{} [y/N] ", question);
+    if std::io::stderr().flush().is_err() {
+        return false;
+    }
+
+    let mut answer = String::new();
+    if std::io::stdin().lock().read_line(&mut answer).is_err() {
+        return false;
+    }
+    matches!(answer.trim().to_ascii_lowercase().as_str(), "y" | "yes")
+}
+
+/// Locations close to a breakpoint location that is not in the program
+///
+/// Empty when the location exists (the breakpoint is only pending) or the
+/// symbols cannot be read.
+pub async fn breakpoint(client: &mut DaemonClient, location: &BreakpointLocation) -> Vec<String> {
+    let (kind, name) = match location {
+        BreakpointLocation::Function { name } => (FindKind::Func, name.clone()),
+        BreakpointLocation::Line { file, .. } => {
+            (FindKind::File, file.to_string_lossy().into_owned())
+        }
+        BreakpointLocation::Address { .. } => return Vec::new(),
+    };
+
+    let Ok(result) = client
+        .send_command(Command::Suggest {
+            kind,
+            name,
+            limit: MAX_SUGGESTIONS,
+        })
+        .await
+    else {
+        return Vec::new();
+    };
+    let suggestions: Vec<String> =
+        serde_json::from_value(result["suggestions"].clone()).unwrap_or_default();
+
+    match location {
+        BreakpointLocation::Function { .. } | BreakpointLocation::Address { .. } => suggestions,
+        BreakpointLocation::Line { line, .. } => suggestions
+            .into_iter()
+            .map(|file| format!("{}:{}", file, line))
+            .collect(),
+    }
+}
+
+/// Locals close to an identifier that failed to evaluate
+pub async fn variable(client: &mut DaemonClient, expression: &str) -> Vec<String> {
+    let is_identifier = !expression.is_empty()
+        && expression
+            .chars()
+            .all(|c| c.is_alphanumeric() || c == '_' || c == '$');
+    if !is_identifier {
+        return Vec::new();
+    }
+
+    let Ok(result) = client
+        .send_command(Command::Locals { frame_id: None })
+        .await
+    else {
+        return Vec::new();
+    };
+    let locals: Vec<VariableInfo> =
+        serde_json::from_value(result["variables"].clone()).unwrap_or_default();
+
+    fuzzy::closest(
+        expression,
+        locals.iter().map(|var| var.name.as_str()),
+        MAX_SUGGESTIONS,
+    )
+    .into_iter()
+    .map(String::from)
+    .collect()
+}
+
+/// `Did you mean: a, b?`
+pub fn did_you_mean(suggestions: &[String]) -> String {
+    format!("Did you mean: {}?", suggestions.join(", "))
+}
@@ -0,0 +1,114 @@
+//! Reading logs for `symbolicate` and printing them resolved
+//!
+//! The log is printed as it was given, and under each line that has
+//! addresses in the binary goes where each of them is.
+
+use std::io::{IsTerminal, Read};
+use std::path::Path;
+
+use crate::common::{Error, Result};
+use crate::symbols::symbolicate::{Frame, Symbolicated};
+
+use super::theme::{self, Element};
+
+/// The log in `file`, or pasted in, or piped to standard input
+pub fn read(file: Option<&Path>) -> Result<String> {
+    if let Some(path) = file.filter(|path| *path != Path::new("-")) {
+        return std::fs::read_to_string(path).map_err(|e| Error::FileRead {
+            path: path.display().to_string(),
+            error: e.to_string(),
+        });
+    }
+    let mut stdin = std::io::stdin();
+    if stdin.is_terminal() {
+        eprintln!("Paste the log, then press Ctrl-D on an empty line");
+    }
+    let mut log = String::new();
+    stdin.read_to_string(&mut log)?;
+    Ok(log)
+}
+
+/// The log with each placed address under its line
+pub fn text(log: &str, result: &Symbolicated) -> String {
+    let mut text = String::new();
+    let mut resolved = result.lines.iter().peekable();
+    for (index, line) in log.lines().enumerate() {
+        text.push_str(line);
+        text.push('\n');
+        let Some(placed) = resolved.next_if(|placed| placed.number == index + 1) else {
+            continue;
+        };
+        let indent: String = line.chars().take_while(|c| c.is_whitespace()).collect();
+        for frame in &placed.frames {
+            text.push_str(&format!("{}    -> {}\n", indent, describe(frame)));
+        }
+    }
+
+    if result.found == 0 {
+        text.push_str("No addresses found in the log\n");
+    } else if result.resolved == 0 {
+        let addresses = match result.found {
+            1 => "The address is not".to_string(),
+            found => format!("None of the {} addresses are", found),
+        };
+        text.push_str(&format!("{} in the binary; is --slide needed?\n", addresses));
+    }
+    text
+}
+
+/// `+0x1d  check inlined into main.main+0x1d at main.go:8`
+fn describe(frame: &Frame) -> String {
+    let mut function = String::new();
+    for inlined in &frame.inlined {
+        function.push_str(&format!("{} inlined into ", inlined));
+    }
+    function.push_str(&frame.function);
+    let location = match (&frame.file, frame.line) {
+        (Some(file), Some(line)) => format!(" at {}:{}", file, line),
+        (Some(file), None) => format!(" in {}", file),
+        _ => String::new(),
+    };
+    format!(
+        "{}  {}+{:#x}{}",
+        frame.text,
+        theme::paint(Element::VariableName, &function),
+        frame.offset,
+        location
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::symbols::symbolicate::LogLine;
+
+    #[test]
+    fn placed_addresses_go_under_their_lines() {
+        let log = "goroutine 1 [running]:\nmain.main()\n\t/build/main.go:8 +0x1d\nexit status 2";
+        let frame = Frame {
+            text: "+0x1d".to_string(),
+            address: 0x40103d,
+            function: "main.main".to_string(),
+            offset: 0x1d,
+            inlined: vec!["main.check".to_string()],
+            file: Some("/src/main.go".to_string()),
+            line: Some(8),
+        };
+        let result = Symbolicated {
+            lines: vec![LogLine { number: 3, text: String::new(), frames: vec![frame] }],
+            found: 1,
+            resolved: 1,
+        };
+        let printed = text(log, &result);
+        let lines: Vec<&str> = printed.lines().collect();
+        assert_eq!(lines.len(), 5);
+        assert_eq!(lines[2], "\t/build/main.go:8 +0x1d");
+        assert!(lines[3].starts_with("\t    -> +0x1d  "));
+        assert!(lines[3].contains("main.check inlined into main.main"));
+        assert!(lines[3].ends_with("+0x1d at /src/main.go:8"));
+        assert_eq!(lines[4], "exit status 2");
+
+        let none = Symbolicated { found: 2, ..Symbolicated::default() };
+        assert!(text("0x10 0x20", &none).ends_with("is --slide needed?\n"));
+    }
+}
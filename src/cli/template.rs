@@ -0,0 +1,630 @@
+//! Text templates for `report generate`
+//!
+//! The syntax is a small part of Go's text/template, enough for reports:
+//! `{{.stops}}` prints a field of the current value, `{{.}}` the value itself
+//! and `{{$.session}}` a field of the top-level data. `{{if X}}`,
+//! `{{with X}}` (which makes X the current value) and `{{range X}}` take an
+//! optional `{{else}}` and end with `{{end}}`. `{{- ` and ` -}}` trim the
+//! whitespace before or after an action, and `{{/* ... */}}` is a comment.
+//!
+//! Functions are called Go-style, `{{len .stops}}`, or at the end of a
+//! pipeline, `{{.source | default "unknown"}}`: `len`, `not`, `eq`, `ne`,
+//! `default`, `join`, `json` and `html`. Templates for HTML reports escape
+//! every value they print.
+
+use serde_json::Value;
+
+use crate::common::{Error, Result};
+
+/// A parsed template
+#[derive(Debug)]
+pub struct Template {
+    name: String,
+    nodes: Vec<Node>,
+}
+
+#[derive(Debug)]
+enum Node {
+    Text(String),
+    Print(Pipeline),
+    Block {
+        kind: BlockKind,
+        pipeline: Pipeline,
+        body: Vec<Node>,
+        otherwise: Vec<Node>,
+    },
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum BlockKind {
+    If,
+    With,
+    Range,
+}
+
+impl BlockKind {
+    fn keyword(self) -> &'static str {
+        match self {
+            BlockKind::If => "if",
+            BlockKind::With => "with",
+            BlockKind::Range => "range",
+        }
+    }
+}
+
+/// Commands separated by `|`, each one's value passed to the next as its
+/// last argument
+#[derive(Debug)]
+struct Pipeline {
+    line: usize,
+    stages: Vec<Vec<Arg>>,
+}
+
+#[derive(Debug)]
+enum Arg {
+    /// `.a.b`, or `$.a.b` from the top-level data
+    Field { root: bool, path: Vec<String> },
+    Literal(Value),
+    Function(String),
+}
+
+/// A block being parsed
+struct Open {
+    kind: BlockKind,
+    pipeline: Pipeline,
+    body: Vec<Node>,
+    otherwise: Option<Vec<Node>>,
+}
+
+impl Template {
+    /// Parse a template; `name` is used in error messages
+    pub fn parse(name: &str, text: &str) -> Result<Self> {
+        let error = |line: usize, message: String| {
+            Error::Report(format!("{}:{}: {}", name, line, message))
+        };
+
+        let mut stack: Vec<Open> = Vec::new();
+        let mut nodes: Vec<Node> = Vec::new();
+        let mut rest = text;
+        let mut line = 1;
+        let mut trim_next = false;
+
+        loop {
+            let (before, action) = match rest.find("{{") {
+                Some(start) => (&rest[..start], Some(start)),
+                None => (rest, None),
+            };
+            let mut literal = if trim_next { before.trim_start() } else { before };
+            line += before.matches('\n').count();
+
+            let Some(start) = action else {
+                push_text(&mut stack, &mut nodes, literal);
+                break;
+            };
+
+            let inner_start = start + 2;
+            let end = find_close(&rest[inner_start..])
+                .map(|end| inner_start + end)
+                .ok_or_else(|| error(line, "unclosed action".to_string()))?;
+            let mut inner = &rest[inner_start..end];
+            if let Some(trimmed) = inner.strip_prefix('-').filter(|_| starts_with_space(inner)) {
+                literal = literal.trim_end();
+                inner = trimmed;
+            }
+            trim_next = inner.ends_with('-') && ends_with_space(inner);
+            if trim_next {
+                inner = &inner[..inner.len() - 1];
+            }
+            push_text(&mut stack, &mut nodes, literal);
+
+            let action_line = line;
+            line += inner.matches('\n').count();
+            rest = &rest[end + 2..];
+
+            let inner = inner.trim();
+            if inner.starts_with("/*") && inner.ends_with("*/") {
+                continue;
+            }
+            let (keyword, tail) = match inner.split_once(char::is_whitespace) {
+                Some((keyword, tail)) => (keyword, tail.trim()),
+                None => (inner, ""),
+            };
+            let kind = match keyword {
+                "if" => Some(BlockKind::If),
+                "with" => Some(BlockKind::With),
+                "range" => Some(BlockKind::Range),
+                _ => None,
+            };
+
+            if let Some(kind) = kind {
+                let pipeline = parse_pipeline(tail, action_line)
+                    .map_err(|message| error(action_line, message))?;
+                stack.push(Open {
+                    kind,
+                    pipeline,
+                    body: Vec::new(),
+                    otherwise: None,
+                });
+            } else if inner == "else" {
+                match stack.last_mut() {
+                    Some(open) if open.otherwise.is_none() => open.otherwise = Some(Vec::new()),
+                    Some(open) => {
+                        let keyword = open.kind.keyword();
+                        let message = format!("second {{{{else}}}} in {{{{{}}}}}", keyword);
+                        return Err(error(action_line, message));
+                    }
+                    None => {
+                        return Err(error(action_line, "{{else}} outside a block".to_string()))
+                    }
+                }
+            } else if inner == "end" {
+                let open = stack
+                    .pop()
+                    .ok_or_else(|| error(action_line, "{{end}} without a block".to_string()))?;
+                let node = Node::Block {
+                    kind: open.kind,
+                    pipeline: open.pipeline,
+                    body: open.body,
+                    otherwise: open.otherwise.unwrap_or_default(),
+                };
+                push_node(&mut stack, &mut nodes, node);
+            } else {
+                let pipeline = parse_pipeline(inner, action_line)
+                    .map_err(|message| error(action_line, message))?;
+                push_node(&mut stack, &mut nodes, Node::Print(pipeline));
+            }
+        }
+
+        if let Some(open) = stack.last() {
+            let message = format!("{{{{{}}}}} is never ended", open.kind.keyword());
+            return Err(error(open.pipeline.line, message));
+        }
+
+        Ok(Self {
+            name: name.to_string(),
+            nodes,
+        })
+    }
+
+    /// Render the template with `data`, escaping printed values for HTML
+    /// when `html` is set
+    pub fn render(&self, data: &Value, html: bool) -> Result<String> {
+        let mut out = String::new();
+        let context = Context {
+            name: &self.name,
+            root: data,
+            html,
+        };
+        context.run(&self.nodes, data, &mut out)?;
+        Ok(out)
+    }
+}
+
+fn push_node(stack: &mut [Open], nodes: &mut Vec<Node>, node: Node) {
+    match stack.last_mut() {
+        Some(open) => match &mut open.otherwise {
+            Some(otherwise) => otherwise.push(node),
+            None => open.body.push(node),
+        },
+        None => nodes.push(node),
+    }
+}
+
+fn push_text(stack: &mut [Open], nodes: &mut Vec<Node>, text: &str) {
+    if !text.is_empty() {
+        push_node(stack, nodes, Node::Text(text.to_string()));
+    }
+}
+
+fn starts_with_space(inner: &str) -> bool {
+    inner[1..].starts_with(char::is_whitespace)
+}
+
+fn ends_with_space(inner: &str) -> bool {
+    inner[..inner.len() - 1].ends_with(char::is_whitespace)
+}
+
+/// Offset of the `}}` closing an action, skipping quoted strings
+fn find_close(text: &str) -> Option<usize> {
+    let bytes = text.as_bytes();
+    let mut quoted = false;
+    let mut i = 0;
+    while i < bytes.len() {
+        match bytes[i] {
+            b'\\' if quoted => i += 1,
+            b'"' => quoted = !quoted,
+            b'}' if !quoted && bytes.get(i + 1) == Some(&b'}') => return Some(i),
+            _ => {}
+        }
+        i += 1;
+    }
+    None
+}
+
+fn parse_pipeline(text: &str, line: usize) -> std::result::Result<Pipeline, String> {
+    let mut stages = vec![Vec::new()];
+    for word in split_words(text)? {
+        let arg = match word {
+            Word::Pipe => {
+                stages.push(Vec::new());
+                continue;
+            }
+            Word::Quoted(text) => Arg::Literal(Value::String(text)),
+            Word::Bare(word) => parse_arg(&word)?,
+        };
+        stages.last_mut().unwrap().push(arg);
+    }
+
+    if stages.iter().any(Vec::is_empty) {
+        return Err(format!("missing command in '{}'", text));
+    }
+    for stage in &stages {
+        match &stage[..] {
+            [Arg::Function(_), ..] => {}
+            [_] => {}
+            _ => return Err(format!("'{}' has arguments but is not a function", text)),
+        }
+    }
+    for stage in &stages[1..] {
+        if !matches!(stage[0], Arg::Function(_)) {
+            return Err(format!("only functions can follow '|' in '{}'", text));
+        }
+    }
+    Ok(Pipeline { line, stages })
+}
+
+fn parse_arg(word: &str) -> std::result::Result<Arg, String> {
+    let field = |path: &str, root: bool| Arg::Field {
+        root,
+        path: path
+            .split('.')
+            .filter(|part| !part.is_empty())
+            .map(String::from)
+            .collect(),
+    };
+
+    Ok(if word == "$" {
+        field("", true)
+    } else if let Some(path) = word.strip_prefix("$.") {
+        field(path, true)
+    } else if let Some(path) = word.strip_prefix('.') {
+        field(path, false)
+    } else if let Ok(number) = word.parse::<i64>() {
+        Arg::Literal(Value::from(number))
+    } else if let Ok(number) = word.parse::<f64>() {
+        Arg::Literal(Value::from(number))
+    } else {
+        match word {
+            "true" => Arg::Literal(Value::Bool(true)),
+            "false" => Arg::Literal(Value::Bool(false)),
+            "nil" => Arg::Literal(Value::Null),
+            name if FUNCTIONS.contains(&name) => Arg::Function(name.to_string()),
+            name => return Err(format!("unknown function '{}'", name)),
+        }
+    })
+}
+
+const FUNCTIONS: &[&str] = &["len", "not", "eq", "ne", "default", "join", "json", "html"];
+
+enum Word {
+    Pipe,
+    Quoted(String),
+    Bare(String),
+}
+
+fn split_words(text: &str) -> std::result::Result<Vec<Word>, String> {
+    let mut words = Vec::new();
+    let mut chars = text.chars().peekable();
+    while let Some(&c) = chars.peek() {
+        if c.is_whitespace() {
+            chars.next();
+        } else if c == '|' {
+            chars.next();
+            words.push(Word::Pipe);
+        } else if c == '"' {
+            chars.next();
+            let mut quoted = String::new();
+            loop {
+                match chars.next() {
+                    Some('"') => break,
+                    Some('\\') => match chars.next() {
+                        Some('n') => quoted.push('\n'),
+                        Some('t') => quoted.push('\t'),
+                        Some(other) => quoted.push(other),
+                        None => return Err("unterminated string".to_string()),
+                    },
+                    Some(other) => quoted.push(other),
+                    None => return Err("unterminated string".to_string()),
+                }
+            }
+            words.push(Word::Quoted(quoted));
+        } else {
+            let mut word = String::new();
+            while let Some(&c) = chars.peek() {
+                if c.is_whitespace() || c == '|' || c == '"' {
+                    break;
+                }
+                word.push(c);
+                chars.next();
+            }
+            words.push(Word::Bare(word));
+        }
+    }
+    Ok(words)
+}
+
+struct Context<'a> {
+    name: &'a str,
+    root: &'a Value,
+    html: bool,
+}
+
+impl Context<'_> {
+    fn run(&self, nodes: &[Node], dot: &Value, out: &mut String) -> Result<()> {
+        for node in nodes {
+            match node {
+                Node::Text(text) => out.push_str(text),
+                Node::Print(pipeline) => {
+                    let value = self.eval(pipeline, dot)?;
+                    let text = text(&value);
+                    // `html` has escaped the value already
+                    if self.html && !pipeline.ends_with("html") {
+                        out.push_str(&escape_html(&text));
+                    } else {
+                        out.push_str(&text);
+                    }
+                }
+                Node::Block {
+                    kind,
+                    pipeline,
+                    body,
+                    otherwise,
+                } => {
+                    let value = self.eval(pipeline, dot)?;
+                    match kind {
+                        BlockKind::If if truthy(&value) => self.run(body, dot, out)?,
+                        BlockKind::With if truthy(&value) => self.run(body, &value, out)?,
+                        BlockKind::Range if truthy(&value) => {
+                            let items: Vec<&Value> = match &value {
+                                Value::Array(items) => items.iter().collect(),
+                                Value::Object(fields) => fields.values().collect(),
+                                _ => {
+                                    return Err(self.error(
+                                        pipeline.line,
+                                        format!("cannot range over {}", text(&value)),
+                                    ))
+                                }
+                            };
+                            for item in items {
+                                self.run(body, item, out)?;
+                            }
+                        }
+                        _ => self.run(otherwise, dot, out)?,
+                    }
+                }
+            }
+        }
+        Ok(())
+    }
+
+    fn eval(&self, pipeline: &Pipeline, dot: &Value) -> Result<Value> {
+        let mut piped: Option<Value> = None;
+        for stage in &pipeline.stages {
+            let value = match &stage[..] {
+                [Arg::Function(name), args @ ..] => {
+                    let mut values: Vec<Value> =
+                        args.iter().map(|arg| self.arg(arg, dot)).collect();
+                    values.extend(piped.take());
+                    call(name, &values).map_err(|message| self.error(pipeline.line, message))?
+                }
+                [arg] => self.arg(arg, dot),
+                _ => unreachable!("checked when parsing"),
+            };
+            piped = Some(value);
+        }
+        Ok(piped.unwrap_or(Value::Null))
+    }
+
+    fn arg(&self, arg: &Arg, dot: &Value) -> Value {
+        match arg {
+            Arg::Field { root, path } => {
+                let mut value = if *root { self.root } else { dot };
+                for part in path {
+                    value = match value {
+                        Value::Object(fields) => fields.get(part).unwrap_or(&Value::Null),
+                        Value::Array(items) => part
+                            .parse::<usize>()
+                            .ok()
+                            .and_then(|index| items.get(index))
+                            .unwrap_or(&Value::Null),
+                        _ => &Value::Null,
+                    };
+                }
+                value.clone()
+            }
+            Arg::Literal(value) => value.clone(),
+            Arg::Function(_) => unreachable!("checked when parsing"),
+        }
+    }
+
+    fn error(&self, line: usize, message: String) -> Error {
+        Error::Report(format!("{}:{}: {}", self.name, line, message))
+    }
+}
+
+impl Pipeline {
+    fn ends_with(&self, function: &str) -> bool {
+        matches!(self.stages.last().and_then(|stage| stage.first()),
+            Some(Arg::Function(name)) if name == function)
+    }
+}
+
+fn call(name: &str, args: &[Value]) -> std::result::Result<Value, String> {
+    let arity = |count: usize| {
+        if args.len() == count {
+            Ok(())
+        } else {
+            Err(format!("{} takes {} argument(s), got {}", name, count, args.len()))
+        }
+    };
+
+    Ok(match name {
+        "len" => {
+            arity(1)?;
+            let len = match &args[0] {
+                Value::Array(items) => items.len(),
+                Value::Object(fields) => fields.len(),
+                Value::String(text) => text.chars().count(),
+                Value::Null => 0,
+                other => return Err(format!("len of {}", text(other))),
+            };
+            Value::from(len)
+        }
+        "not" => {
+            arity(1)?;
+            Value::Bool(!truthy(&args[0]))
+        }
+        "eq" | "ne" => {
+            arity(2)?;
+            let equal = match (args[0].as_f64(), args[1].as_f64()) {
+                (Some(a), Some(b)) => a == b,
+                _ => args[0] == args[1],
+            };
+            Value::Bool(equal == (name == "eq"))
+        }
+        "default" => {
+            arity(2)?;
+            if truthy(&args[1]) {
+                args[1].clone()
+            } else {
+                args[0].clone()
+            }
+        }
+        "join" => {
+            arity(2)?;
+            let separator = text(&args[0]);
+            match &args[1] {
+                Value::Array(items) => {
+                    let items: Vec<String> = items.iter().map(text).collect();
+                    Value::String(items.join(&separator))
+                }
+                Value::Null => Value::String(String::new()),
+                other => return Err(format!("join of {}", text(other))),
+            }
+        }
+        "json" => {
+            arity(1)?;
+            Value::String(args[0].to_string())
+        }
+        "html" => {
+            arity(1)?;
+            Value::String(escape_html(&text(&args[0])))
+        }
+        _ => unreachable!("checked when parsing"),
+    })
+}
+
+/// Go's truth: false, 0, nil and empty strings, lists and maps are false
+fn truthy(value: &Value) -> bool {
+    match value {
+        Value::Null => false,
+        Value::Bool(value) => *value,
+        Value::Number(number) => number.as_f64().is_some_and(|n| n != 0.0),
+        Value::String(text) => !text.is_empty(),
+        Value::Array(items) => !items.is_empty(),
+        Value::Object(fields) => !fields.is_empty(),
+    }
+}
+
+/// A value as printed: strings as they are, nothing for nil, JSON for lists
+/// and maps
+fn text(value: &Value) -> String {
+    match value {
+        Value::Null => String::new(),
+        Value::String(text) => text.clone(),
+        other => other.to_string(),
+    }
+}
+
+fn escape_html(text: &str) -> String {
+    let mut escaped = String::with_capacity(text.len());
+    for c in text.chars() {
+        match c {
+            '&' => escaped.push_str("&amp;"),
+            '<' => escaped.push_str("&lt;"),
+            '>' => escaped.push_str("&gt;"),
+            '"' => escaped.push_str("&quot;"),
+            '\'' => escaped.push_str("&#39;"),
+            c => escaped.push(c),
+        }
+    }
+    escaped
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    fn render(template: &str, data: Value) -> String {
+        Template::parse("test", template)
+            .unwrap()
+            .render(&data, false)
+            .unwrap()
+    }
+
+    #[test]
+    fn fields_blocks_and_functions() {
+        let data = json!({
+            "program": "/tmp/app",
+            "stops": [
+                { "number": 1, "reason": "breakpoint", "line": 12 },
+                { "number": 2, "reason": "signal", "line": null },
+            ],
+            "watches": [],
+        });
+        let template = "{{.program}}: {{len .stops}} stops\n\
+            {{- range .stops}}\n#{{.number}} {{.reason}} in {{$.program}} at \
+            {{.line | default \"?\"}}{{end}}\n\
+            {{with .watches}}watched{{else}}no watches{{end}}{{/* comment */}}";
+        assert_eq!(
+            render(template, data),
+            "/tmp/app: 2 stops\n#1 breakpoint in /tmp/app at 12\n\
+             #2 signal in /tmp/app at ?\nno watches"
+        );
+
+        assert_eq!(
+            render("{{if not .x}}none{{end}} {{join \", \" .list}} {{json .map}}",
+                json!({ "list": ["a", 1], "map": { "k": true } })),
+            "none a, 1 {\"k\":true}"
+        );
+        assert_eq!(render("{{if ne .n 2}}odd{{else}}two{{end}}", json!({ "n": 2.0 })), "two");
+    }
+
+    #[test]
+    fn html_reports_escape_values() {
+        let template = Template::parse("t.html", "<b>{{.name}}</b> {{.name | html}}").unwrap();
+        assert_eq!(
+            template.render(&json!({ "name": "a<b>" }), true).unwrap(),
+            "<b>a&lt;b&gt;</b> a&lt;b&gt;"
+        );
+    }
+
+    #[test]
+    fn errors_name_the_line() {
+        let error = Template::parse("crash.md.tmpl", "ok\n{{if .x}}\n{{frobnicate .y}}\n")
+            .unwrap_err()
+            .to_string();
+        assert!(error.contains("crash.md.tmpl:3: unknown function 'frobnicate'"), "{}", error);
+
+        let error = Template::parse("t", "{{range .x}}\n").unwrap_err().to_string();
+        assert!(error.contains("t:1: {{range}} is never ended"), "{}", error);
+
+        let error = Template::parse("t", "{{end}}").unwrap_err().to_string();
+        assert!(error.contains("{{end}} without a block"), "{}", error);
+
+        let template = Template::parse("t", "\n{{range .x}}{{end}}").unwrap();
+        let error = template.render(&json!({ "x": 3 }), false).unwrap_err().to_string();
+        assert!(error.contains("t:2: cannot range over 3"), "{}", error);
+    }
+}
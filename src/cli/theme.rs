@@ -0,0 +1,441 @@
+//! Color themes for text output
+//!
+//! A theme maps semantic elements (breakpoint marker, current line, type
+//! name, ...) to styles. Built-in themes cover dark, light, solarized and
+//! high-contrast terminals; custom themes are TOML files that override any
+//! elements of a base theme. Colors are only used when the stream is a
+//! terminal, `NO_COLOR` is unset, and output is not JSON.
+
+use std::collections::HashMap;
+use std::io::IsTerminal;
+use std::path::{Path, PathBuf};
+use std::sync::OnceLock;
+
+use colored::{Color, Colorize};
+use serde::Deserialize;
+
+use crate::common::config::Config;
+use crate::common::{paths, Error, Result};
+
+use super::{capture, output, pager};
+
+/// Built-in theme names, in the order `auto` considers them
+pub const BUILTIN_THEMES: &[&str] = &["dark", "light", "solarized", "high-contrast"];
+
+/// Parts of the output a theme can style
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash)]
+pub enum Element {
+    /// Breakpoint status markers and "Stopped at breakpoint"
+    BreakpointMarker,
+    /// The `->` marker and text of the current source line
+    CurrentLine,
+    /// Source line numbers
+    LineNumber,
+    /// Variable and expression names
+    VariableName,
+    /// Type names shown after values
+    TypeName,
+    /// Error messages
+    Error,
+    /// Values shown after source lines by `set inline-values`
+    InlineValue,
+    /// Watch values that changed since the previous stop
+    ChangedValue,
+}
+
+impl Element {
+    const ALL: [Element; 8] = [
+        Element::BreakpointMarker,
+        Element::CurrentLine,
+        Element::LineNumber,
+        Element::VariableName,
+        Element::TypeName,
+        Element::Error,
+        Element::InlineValue,
+        Element::ChangedValue,
+    ];
+
+    /// Key used for the element in theme files
+    fn key(self) -> &'static str {
+        match self {
+            Element::BreakpointMarker => "breakpoint_marker",
+            Element::CurrentLine => "current_line",
+            Element::LineNumber => "line_number",
+            Element::VariableName => "variable_name",
+            Element::TypeName => "type_name",
+            Element::Error => "error",
+            Element::InlineValue => "inline_value",
+            Element::ChangedValue => "changed_value",
+        }
+    }
+}
+
+/// A parsed style such as `bold bright_red on_black` or `#268bd2`
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct Style {
+    foreground: Option<Color>,
+    background: Option<Color>,
+    bold: bool,
+    dimmed: bool,
+    italic: bool,
+    underline: bool,
+}
+
+impl Style {
+    /// Parse a space-separated style spec
+    pub fn parse(spec: &str) -> std::result::Result<Self, String> {
+        let mut style = Style::default();
+
+        for word in spec.split_whitespace() {
+            match word {
+                "bold" => style.bold = true,
+                "dim" | "dimmed" => style.dimmed = true,
+                "italic" => style.italic = true,
+                "underline" => style.underline = true,
+                "none" | "plain" => {}
+                _ => {
+                    if let Some(name) = word.strip_prefix("on_") {
+                        style.background = Some(parse_color(name)?);
+                    } else {
+                        style.foreground = Some(parse_color(word)?);
+                    }
+                }
+            }
+        }
+
+        Ok(style)
+    }
+
+    fn apply(&self, text: &str) -> String {
+        let mut painted = text.normal();
+        if let Some(color) = self.foreground {
+            painted = painted.color(color);
+        }
+        if let Some(color) = self.background {
+            painted = painted.on_color(color);
+        }
+        if self.bold {
+            painted = painted.bold();
+        }
+        if self.dimmed {
+            painted = painted.dimmed();
+        }
+        if self.italic {
+            painted = painted.italic();
+        }
+        if self.underline {
+            painted = painted.underline();
+        }
+        painted.to_string()
+    }
+}
+
+fn parse_color(name: &str) -> std::result::Result<Color, String> {
+    if let Some(hex) = name.strip_prefix('#') {
+        let channel = |range: std::ops::Range<usize>| {
+            hex.get(range)
+                .and_then(|digits| u8::from_str_radix(digits, 16).ok())
+        };
+        if hex.len() == 6 {
+            if let (Some(r), Some(g), Some(b)) = (channel(0..2), channel(2..4), channel(4..6)) {
+                return Ok(Color::TrueColor { r, g, b });
+            }
+        }
+        return Err(format!("invalid hex color '#{}', expected #rrggbb", hex));
+    }
+
+    let color = match name {
+        "black" => Color::Black,
+        "red" => Color::Red,
+        "green" => Color::Green,
+        "yellow" => Color::Yellow,
+        "blue" => Color::Blue,
+        "magenta" => Color::Magenta,
+        "cyan" => Color::Cyan,
+        "white" => Color::White,
+        "bright_black" | "gray" | "grey" => Color::BrightBlack,
+        "bright_red" => Color::BrightRed,
+        "bright_green" => Color::BrightGreen,
+        "bright_yellow" => Color::BrightYellow,
+        "bright_blue" => Color::BrightBlue,
+        "bright_magenta" => Color::BrightMagenta,
+        "bright_cyan" => Color::BrightCyan,
+        "bright_white" => Color::BrightWhite,
+        _ => return Err(format!("unknown color or attribute '{}'", name)),
+    };
+    Ok(color)
+}
+
+/// A complete theme with a style for every element
+#[derive(Debug, Clone)]
+pub struct Theme {
+    styles: HashMap<Element, Style>,
+}
+
+impl Theme {
+    /// Look up a built-in theme by name
+    pub fn builtin(name: &str) -> Option<Self> {
+        let specs: [&str; 8] = match name {
+            "dark" => [
+                "bold bright_red",
+                "bold bright_yellow",
+                "bright_black",
+                "bright_cyan",
+                "bright_blue",
+                "bold bright_red",
+                "italic bright_black",
+                "bold bright_green",
+            ],
+            "light" => [
+                "bold red",
+                "bold blue",
+                "bright_black",
+                "magenta",
+                "blue",
+                "bold red",
+                "italic bright_black",
+                "bold green",
+            ],
+            "solarized" => [
+                "bold #dc322f",
+                "bold #b58900",
+                "#586e75",
+                "#2aa198",
+                "#6c71c4",
+                "bold #dc322f",
+                "italic #93a1a1",
+                "bold #859900",
+            ],
+            "high-contrast" => [
+                "bold bright_white on_red",
+                "bold black on_bright_yellow",
+                "bright_white",
+                "bold bright_cyan",
+                "bold bright_green",
+                "bold underline bright_red",
+                "bold bright_magenta",
+                "bold black on_bright_green",
+            ],
+            _ => return None,
+        };
+
+        let styles = Element::ALL
+            .into_iter()
+            .zip(specs)
+            .map(|(element, spec)| {
+                (
+                    element,
+                    Style::parse(spec).expect("built-in theme styles are valid"),
+                )
+            })
+            .collect();
+
+        Some(Self { styles })
+    }
+
+    /// Resolve a theme setting: `auto`, a built-in name, a theme file in the
+    /// config directory's `themes/` folder, or a path to a theme file
+    pub fn resolve(setting: &str) -> Result<Self> {
+        if setting == "auto" {
+            return Ok(Self::builtin(detect_background()).expect("detected theme is built in"));
+        }
+
+        if let Some(theme) = Self::builtin(setting) {
+            return Ok(theme);
+        }
+
+        let installed =
+            paths::config_dir().map(|dir| dir.join("themes").join(format!("{}.toml", setting)));
+        match installed {
+            Some(path) if path.is_file() => Self::load(&path),
+            _ => {
+                let path = PathBuf::from(setting);
+                if path.is_file() {
+                    Self::load(&path)
+                } else {
+                    Err(Error::Config(format!(
+                        "Unknown theme '{}'. Built-in themes: {}",
+                        setting,
+                        BUILTIN_THEMES.join(", ")
+                    )))
+                }
+            }
+        }
+    }
+
+    /// Load a custom theme file
+    pub fn load(path: &Path) -> Result<Self> {
+        let content = std::fs::read_to_string(path).map_err(|e| Error::FileRead {
+            path: path.display().to_string(),
+            error: e.to_string(),
+        })?;
+
+        Self::parse(&content)
+            .map_err(|message| Error::ConfigParse(format!("{}: {}", path.display(), message)))
+    }
+
+    /// Parse theme file content
+    ///
+    /// Keys are element names (`breakpoint_marker = "bold red"`); `base`
+    /// names the built-in theme supplying unset elements (default: `auto`).
+    fn parse(content: &str) -> std::result::Result<Self, String> {
+        #[derive(Deserialize)]
+        struct ThemeFile {
+            base: Option<String>,
+            #[serde(flatten)]
+            elements: HashMap<String, String>,
+        }
+
+        let file: ThemeFile = toml::from_str(content).map_err(|e| e.to_string())?;
+
+        let base = file.base.as_deref().unwrap_or("auto");
+        let base = if base == "auto" {
+            detect_background()
+        } else {
+            base
+        };
+        let mut theme =
+            Self::builtin(base).ok_or_else(|| format!("unknown base theme '{}'", base))?;
+
+        for (key, spec) in &file.elements {
+            let element = Element::ALL
+                .into_iter()
+                .find(|element| element.key() == key)
+                .ok_or_else(|| format!("unknown theme element '{}'", key))?;
+            let style = Style::parse(spec).map_err(|e| format!("{}: {}", key, e))?;
+            theme.styles.insert(element, style);
+        }
+
+        Ok(theme)
+    }
+
+    fn style(&self, element: Element) -> &Style {
+        &self.styles[&element]
+    }
+}
+
+/// Pick a built-in theme from the terminal background
+///
+/// Uses `COLORFGBG` ("fg;bg", set by rxvt, Konsole, iTerm2 and others):
+/// backgrounds 7 and 15 are light. Anything else, or no hint, means dark.
+fn detect_background() -> &'static str {
+    let hint = std::env::var("COLORFGBG").ok();
+    let background = hint
+        .as_deref()
+        .and_then(|value| value.rsplit(';').next())
+        .and_then(|bg| bg.parse::<u8>().ok());
+
+    match background {
+        Some(7 | 15) => "light",
+        _ => "dark",
+    }
+}
+
+/// The active theme, with whether each stream should be colored
+struct Active {
+    theme: Theme,
+    stdout: bool,
+    stderr: bool,
+}
+
+static ACTIVE: OnceLock<Active> = OnceLock::new();
+
+fn active() -> &'static Active {
+    ACTIVE.get_or_init(|| {
+        let colors = std::env::var_os("NO_COLOR").is_none() && !output::is_json();
+
+        let setting = Config::load()
+            .map(|config| config.display.theme)
+            .unwrap_or_else(|_| "auto".to_string());
+        let theme = Theme::resolve(&setting).unwrap_or_else(|e| {
+            eprintln!("Warning: {}", e);
+            Theme::builtin(detect_background()).expect("detected theme is built in")
+        });
+
+        // A pager started on a terminal shows colors too, as does output
+        // captured for `--copy` or a transcript on its way to a terminal;
+        // `colored` only sees a pipe, so it has to be told.
+        let paged = pager::is_active();
+        let terminal = paged || capture::is_terminal();
+        if colors && terminal && !std::io::stdout().is_terminal() {
+            colored::control::set_override(true);
+        }
+
+        Active {
+            theme,
+            stdout: colors && terminal,
+            stderr: colors && std::io::stderr().is_terminal(),
+        }
+    })
+}
+
+/// Style text bound for stdout
+pub fn paint(element: Element, text: &str) -> String {
+    let active = active();
+    if active.stdout {
+        active.theme.style(element).apply(text)
+    } else {
+        text.to_string()
+    }
+}
+
+/// Style text bound for stderr
+pub fn paint_stderr(element: Element, text: &str) -> String {
+    let active = active();
+    if active.stderr {
+        active.theme.style(element).apply(text)
+    } else {
+        text.to_string()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn styles_parse_colors_and_attributes() {
+        let style = Style::parse("bold bright_red on_#002b36").unwrap();
+        assert!(style.bold);
+        assert_eq!(style.foreground, Some(Color::BrightRed));
+        assert_eq!(
+            style.background,
+            Some(Color::TrueColor {
+                r: 0,
+                g: 0x2b,
+                b: 0x36
+            })
+        );
+
+        assert!(Style::parse("blinking").is_err());
+        assert!(Style::parse("#12345").is_err());
+    }
+
+    #[test]
+    fn builtin_themes_are_complete() {
+        for name in BUILTIN_THEMES {
+            let theme = Theme::builtin(name).unwrap();
+            assert_eq!(theme.styles.len(), Element::ALL.len(), "{name}");
+        }
+    }
+
+    #[test]
+    fn custom_theme_overrides_base() {
+        let theme = Theme::parse("base = \"light\"\ntype_name = \"#6c71c4\"\n").unwrap();
+        assert_eq!(
+            theme.style(Element::TypeName).foreground,
+            Some(Color::TrueColor {
+                r: 0x6c,
+                g: 0x71,
+                b: 0xc4
+            })
+        );
+        assert_eq!(
+            theme.style(Element::Error),
+            Theme::builtin("light").unwrap().style(Element::Error)
+        );
+
+        assert!(Theme::parse("breakpoint = \"red\"\n").is_err());
+        assert!(Theme::parse("base = \"neon\"\n").is_err());
+    }
+}
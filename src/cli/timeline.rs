@@ -0,0 +1,103 @@
+//! Rendering `timeline` events for the terminal
+//!
+//! Each event is a row, in the order it happened. Events on a thread go in
+//! that thread's lane, in the order the threads first appear; output,
+//! samples and the exit go in the program's lane on the right.
+
+use crate::ipc::protocol::TimelineEvent;
+
+/// Characters of an event shown in its lane
+const LANE_WIDTH: usize = 30;
+
+/// The events as rows under a header naming the lanes
+pub fn lanes(events: &[TimelineEvent], names: &[(i64, String)]) -> String {
+    let mut threads: Vec<i64> = Vec::new();
+    for thread in events.iter().filter_map(|event| event.thread_id) {
+        if !threads.contains(&thread) {
+            threads.push(thread);
+        }
+    }
+
+    let mut header = vec![format!("{:>9}", "time")];
+    for thread in &threads {
+        let label = match names.iter().find(|(id, _)| id == thread) {
+            Some((_, name)) => format!("thread {} ({})", thread, name),
+            None => format!("thread {}", thread),
+        };
+        header.push(cell(&label));
+    }
+    header.push("program".to_string());
+    let mut text = row(header);
+
+    for event in events {
+        let mut cells = vec![format!("{:>9}", seconds(event.elapsed_ms))];
+        let lane = event.thread_id.and_then(|id| threads.iter().position(|t| *t == id));
+        for index in 0..threads.len() {
+            cells.push(cell(if lane == Some(index) { &event.text } else { "" }));
+        }
+        cells.push(if lane.is_none() { event.text.clone() } else { String::new() });
+        text.push_str(&row(cells));
+    }
+    text
+}
+
+/// `+1.234s`
+fn seconds(elapsed_ms: u64) -> String {
+    format!("+{}.{:03}s", elapsed_ms / 1000, elapsed_ms % 1000)
+}
+
+/// Text padded or cut to the lane's width
+fn cell(text: &str) -> String {
+    let count = text.chars().count();
+    if count > LANE_WIDTH {
+        let kept: String = text.chars().take(LANE_WIDTH - 3).collect();
+        format!("{}...", kept)
+    } else {
+        format!("{}{}", text, " ".repeat(LANE_WIDTH - count))
+    }
+}
+
+/// The cells of a row, leaving off the empty lanes at its end
+fn row(mut cells: Vec<String>) -> String {
+    while cells.last().is_some_and(|cell| cell.trim().is_empty()) {
+        cells.pop();
+    }
+    format!("{}\n", cells.join(" | ").trim_end())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::ipc::protocol::TimelineKind;
+
+    #[test]
+    fn threads_get_lanes_and_output_goes_right() {
+        let event = |elapsed_ms, kind, thread_id, text: &str| TimelineEvent {
+            elapsed_ms,
+            time: String::new(),
+            kind,
+            thread_id,
+            stop: 0,
+            text: text.to_string(),
+        };
+        let events = [
+            event(5, TimelineKind::Stop, Some(2), "breakpoint 1 in worker"),
+            event(1250, TimelineKind::Output, None, "stdout: worker 2 done"),
+            event(1300, TimelineKind::Stop, Some(1), "breakpoint 2 in main"),
+        ];
+        let text = lanes(&events, &[(2, "worker".to_string())]);
+        let lines: Vec<&str> = text.lines().collect();
+        assert_eq!(lines.len(), 4);
+        assert_eq!(
+            lines[0],
+            format!("     time | {} | {} | program", cell("thread 2 (worker)"), cell("thread 1"))
+        );
+        assert_eq!(lines[1], "  +0.005s | breakpoint 1 in worker");
+        assert_eq!(
+            lines[2],
+            format!("  +1.250s | {} | {} | stdout: worker 2 done", cell(""), cell(""))
+        );
+        assert!(lines[3].starts_with(&format!("  +1.300s | {} | breakpoint 2 in main", cell(""))));
+        assert_eq!(cell(&"x".repeat(40)).len(), LANE_WIDTH);
+    }
+}
@@ -0,0 +1,62 @@
+//! Rendering `timer` results for the terminal
+
+use serde_json::Value;
+
+/// The timings as a few lines: the locations, the intervals and the hits
+pub fn text(data: &Value) -> String {
+    let (Some(from), Some(to)) = (data["from"].as_str(), data["to"].as_str()) else {
+        return "Nothing timed yet; start with 'timer between FROM TO'\n".to_string();
+    };
+    let mut text = format!("{} to {}: {} intervals\n", from, to, data["intervals"]);
+    if let (Some(min), Some(avg), Some(max)) = (
+        data["min_ms"].as_f64(),
+        data["avg_ms"].as_f64(),
+        data["max_ms"].as_f64(),
+    ) {
+        text.push_str(&format!(
+            "  min {:.3} ms  avg {:.3} ms  max {:.3} ms  total {:.3} ms\n",
+            min,
+            avg,
+            max,
+            data["total_ms"].as_f64().unwrap_or_default()
+        ));
+    }
+    text.push_str(&format!(
+        "  hits: {} {}, {} {}\n",
+        from, data["from_hits"], to, data["to_hits"]
+    ));
+    if data["timing"].as_bool() == Some(true) {
+        text.push_str("  Timing; 'timer report' shows more as the program runs\n");
+    }
+    text
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    #[test]
+    fn timings_show_intervals_and_hits() {
+        assert!(text(&json!({ "from": null, "to": null })).starts_with("Nothing timed"));
+
+        let data = json!({
+            "timing": false,
+            "from": "t.c:8",
+            "to": "add",
+            "from_hits": 3,
+            "to_hits": 2,
+            "intervals": 2,
+            "min_ms": 1.0,
+            "avg_ms": 1.5,
+            "max_ms": 2.0,
+            "total_ms": 3.0,
+        });
+        assert_eq!(
+            text(&data),
+            "t.c:8 to add: 2 intervals\n\
+             \x20 min 1.000 ms  avg 1.500 ms  max 2.000 ms  total 3.000 ms\n\
+             \x20 hits: t.c:8 3, add 2\n"
+        );
+    }
+}
@@ -0,0 +1,116 @@
+//! Printing the trace log for `trace functions` and `trace log`
+
+use std::time::Duration;
+
+use crate::common::Result;
+use crate::ipc::protocol::{Command, TraceEntry, TraceKind};
+use crate::ipc::DaemonClient;
+
+use super::output;
+
+/// How often `--follow` asks the daemon for new entries
+const POLL_INTERVAL: Duration = Duration::from_millis(200);
+
+/// One entry as a line: calls show their arguments, returns how long the
+/// call took, both indented by how deep the call is; syscalls are marked
+/// with `=>` and `<=`
+pub fn render(entry: &TraceEntry) -> String {
+    let indent = "  ".repeat(entry.depth);
+    let event = match entry.kind {
+        TraceKind::Call => format!(
+            "-> {}({})",
+            entry.function,
+            entry.arguments.as_deref().unwrap_or_default()
+        ),
+        TraceKind::Return => match entry.duration_us {
+            Some(us) => format!("<- {} {}", entry.function, millis(us)),
+            None => format!("<- {}", entry.function),
+        },
+        TraceKind::Syscall => format!(
+            "=> {}({})",
+            entry.function,
+            entry.arguments.as_deref().unwrap_or_default()
+        ),
+        TraceKind::SyscallReturn => format!(
+            "<= {} = {} {}",
+            entry.function,
+            entry.result.as_deref().unwrap_or("?"),
+            millis(entry.duration_us.unwrap_or_default())
+        ),
+    };
+    format!(
+        "{:>12} [{}] {}{}",
+        millis(entry.elapsed_us),
+        entry.thread_id,
+        indent,
+        event
+    )
+}
+
+fn millis(us: u64) -> String {
+    format!("{:.3}ms", us as f64 / 1000.0)
+}
+
+/// Number of the latest entry, for following only what comes after it
+pub async fn last_entry(client: &mut DaemonClient) -> Result<u64> {
+    let result = client.send_command(Command::TraceLog { since: u64::MAX }).await?;
+    Ok(result["last"].as_u64().unwrap_or(0))
+}
+
+/// Print entries after `since` as they are logged, until the program exits
+pub async fn follow(name: &str, mut since: u64) -> Result<()> {
+    let mut client = DaemonClient::connect().await?;
+    loop {
+        let result = client.send_command(Command::TraceLog { since }).await?;
+        let entries: Vec<TraceEntry> = serde_json::from_value(result["entries"].clone())?;
+        if let Some(last) = entries.last() {
+            since = last.seq;
+            if output::is_json() {
+                output::emit(name, serde_json::json!({ "entries": entries }))?;
+            } else {
+                for entry in &entries {
+                    println!("{}", render(entry));
+                }
+            }
+        }
+        if !result["running"].as_bool().unwrap_or(false) {
+            return Ok(());
+        }
+        tokio::time::sleep(POLL_INTERVAL).await;
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn calls_and_returns_line_up_by_depth() {
+        let entry = |kind, depth, arguments: Option<&str>, duration_us| TraceEntry {
+            seq: 1,
+            elapsed_us: 412,
+            thread_id: 1,
+            depth,
+            kind,
+            function: "main.step".to_string(),
+            arguments: arguments.map(String::from),
+            duration_us,
+            result: None,
+        };
+        assert_eq!(
+            render(&entry(TraceKind::Call, 1, Some("i=0, n=3"), None)),
+            "     0.412ms [1]   -> main.step(i=0, n=3)"
+        );
+        assert_eq!(
+            render(&entry(TraceKind::Return, 1, None, Some(118))),
+            "     0.412ms [1]   <- main.step 0.118ms"
+        );
+
+        let syscall = TraceEntry {
+            function: "write".to_string(),
+            result: Some("-1 EPIPE".to_string()),
+            ..entry(TraceKind::SyscallReturn, 2, None, Some(25))
+        };
+        assert_eq!(render(&syscall), "     0.412ms [1]     <= write = -1 EPIPE 0.025ms");
+    }
+}
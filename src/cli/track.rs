@@ -0,0 +1,48 @@
+//! Rendering `track size` measurements for the terminal
+
+use crate::ipc::protocol::TrackSize;
+
+/// A measurement as `len 3, cap 4, 32 bytes`, leaving out what isn't known
+pub fn size(size: &TrackSize) -> String {
+    if let Some(error) = &size.error {
+        return format!("<error: {}>", error);
+    }
+    let parts: Vec<String> = [
+        size.len.map(|len| format!("len {}", len)),
+        size.cap.map(|cap| format!("cap {}", cap)),
+        size.bytes.map(|bytes| format!("{} bytes", bytes)),
+    ]
+    .into_iter()
+    .flatten()
+    .collect();
+    if parts.is_empty() {
+        "<no size>".to_string()
+    } else {
+        parts.join(", ")
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn sizes_leave_out_what_is_unknown() {
+        let mut measured = TrackSize {
+            stop: 1,
+            time: String::new(),
+            len: Some(3),
+            cap: Some(4),
+            bytes: Some(32),
+            error: None,
+        };
+        assert_eq!(size(&measured), "len 3, cap 4, 32 bytes");
+        measured.cap = None;
+        measured.bytes = None;
+        assert_eq!(size(&measured), "len 3");
+        measured.len = None;
+        assert_eq!(size(&measured), "<no size>");
+        measured.error = Some("undefined name".to_string());
+        assert_eq!(size(&measured), "<error: undefined name>");
+    }
+}
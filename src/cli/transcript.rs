@@ -0,0 +1,52 @@
+//! Recording CLI commands into the daemon's session transcript
+//!
+//! The daemon writes the transcript; each CLI invocation checks whether one is
+//! recording and, if so, captures its output and sends it along with the
+//! command line when it finishes. Macros are recorded the same way, minus
+//! the output.
+
+use crate::ipc::protocol::Command;
+use crate::ipc::state::ClientState;
+use crate::ipc::DaemonClient;
+
+use super::script;
+
+/// What the daemon is recording
+#[derive(Debug, Default)]
+pub struct Recording {
+    pub transcript: bool,
+    /// Name of the macro being recorded
+    pub macro_name: Option<String>,
+}
+
+/// Whether the daemon is recording a transcript or a macro
+///
+/// Reads the state the daemon shares beside its socket rather than asking
+/// it, so commands cost no extra round trip; with no daemon running there
+/// is nothing to record to.
+pub fn recording() -> Recording {
+    let state = ClientState::read();
+    Recording {
+        transcript: state.transcript,
+        macro_name: state.macro_name,
+    }
+}
+
+/// This invocation's arguments as they would be typed, quoting any with
+/// spaces or quotes in them
+pub fn command_line() -> String {
+    let args: Vec<String> = std::env::args().skip(1).collect();
+    script::join_words(&args)
+}
+
+/// Send a finished command and its output to the transcript
+pub async fn record(command_line: String, output: String) {
+    if let Ok(mut client) = DaemonClient::connect().await {
+        let _ = client
+            .send_command(Command::TranscriptRecord {
+                command_line,
+                output,
+            })
+            .await;
+    }
+}
@@ -0,0 +1,257 @@
+//! Stopping when an expression changes or becomes true
+//!
+//! `watch-change` and `break-when` work on any adapter, hardware watchpoints
+//! or not: they step the program (or let it run and pause it every few
+//! milliseconds with `--sample`) and evaluate the expression after each stop.
+//! That is far slower than a real watchpoint, so the number of steps is
+//! capped, and a breakpoint hit on the way ends the search early.
+
+use std::time::Duration;
+
+use serde::Serialize;
+use serde_json::Value;
+
+use crate::commands::StepMode;
+use crate::common::Result;
+use crate::ipc::protocol::{Command, EvaluateContext, EvaluateResult};
+use crate::ipc::DaemonClient;
+
+use super::user;
+
+/// What ends the search
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Trigger {
+    /// The value differs from the value when the search started
+    Change,
+    /// The value is true (non-zero)
+    True,
+}
+
+/// How to move the program between evaluations
+#[derive(Debug, Clone, Copy)]
+pub struct Pace {
+    pub step: StepMode,
+    /// Run for this long between evaluations instead of stepping
+    pub sample: Option<Duration>,
+    pub max_steps: usize,
+    /// Seconds to wait for each step or pause to stop
+    pub timeout: u64,
+}
+
+/// How the search ended
+#[derive(Debug, Serialize)]
+pub struct Outcome {
+    pub expression: String,
+    pub triggered: bool,
+    pub steps: usize,
+    /// Value when the search started; `None` if it could not be evaluated
+    pub old_value: Option<String>,
+    pub new_value: Option<String>,
+    /// The last `await` result, with the stop reason and location
+    pub stop: Option<Value>,
+}
+
+/// Step or sample until `trigger` holds for `expression`
+pub async fn run(
+    client: &mut DaemonClient,
+    expression: &str,
+    trigger: Trigger,
+    pace: Pace,
+) -> Result<Outcome> {
+    let old_value = evaluate(client, expression).await;
+    let mut outcome = Outcome {
+        expression: expression.to_string(),
+        triggered: trigger == Trigger::True && old_value.as_deref().is_some_and(user::is_true),
+        steps: 0,
+        new_value: old_value.clone(),
+        old_value,
+        stop: None,
+    };
+
+    while !outcome.triggered && outcome.steps < pace.max_steps {
+        let stop = advance(client, pace).await?;
+        outcome.steps += 1;
+
+        let reason = stop["reason"].as_str().unwrap_or("").to_string();
+        outcome.stop = Some(stop);
+        if matches!(reason.as_str(), "exited" | "terminated") {
+            break;
+        }
+
+        outcome.new_value = evaluate(client, expression).await;
+        outcome.triggered = match trigger {
+            // Leaving the expression's scope is not a change
+            Trigger::Change => {
+                outcome.new_value.is_some() && outcome.new_value != outcome.old_value
+            }
+            Trigger::True => outcome.new_value.as_deref().is_some_and(user::is_true),
+        };
+
+        // The user's own breakpoints still stop the program
+        if reason == "breakpoint" {
+            break;
+        }
+    }
+
+    Ok(outcome)
+}
+
+/// Move the program once and wait for it to stop
+async fn advance(client: &mut DaemonClient, pace: Pace) -> Result<Value> {
+    match pace.sample {
+        Some(interval) => {
+            client.send_command(Command::Continue).await?;
+            tokio::time::sleep(interval).await;
+            // The program may have stopped on its own meanwhile
+            let _ = client.send_command(Command::Pause).await;
+        }
+        None => {
+            let command = match pace.step {
+                StepMode::Over => Command::Next,
+                StepMode::Into => Command::StepIn,
+            };
+            client.send_command(command).await?;
+        }
+    }
+
+    client
+        .send_command(Command::Await {
+            timeout_secs: pace.timeout,
+        })
+        .await
+}
+
+async fn evaluate(client: &mut DaemonClient, expression: &str) -> Option<String> {
+    let result = client
+        .send_command(Command::Evaluate {
+            expression: expression.to_string(),
+            frame_id: None,
+            context: EvaluateContext::Watch,
+            limit: None,
+        })
+        .await
+        .ok()?;
+    serde_json::from_value::<EvaluateResult>(result)
+        .ok()
+        .map(|eval| eval.result)
+}
+
+#[cfg(all(test, unix))]
+mod tests {
+    use interprocess::local_socket::traits::tokio::Listener as _;
+    use interprocess::local_socket::{GenericFilePath, ListenerOptions, ToFsName};
+    use serde_json::json;
+
+    use super::{run, Outcome, Pace, Trigger};
+    use crate::commands::StepMode;
+    use crate::ipc::protocol::{Command, Request, Response};
+    use crate::ipc::transport;
+    use crate::ipc::DaemonClient;
+
+    /// A program with `values[n]` as the expression's value after `n` steps
+    /// (the last one for ever after), stopping for `reasons[n - 1]`
+    /// ("step" past the end) on the nth
+    struct Program {
+        values: &'static [&'static str],
+        reasons: &'static [&'static str],
+    }
+
+    /// Run `trigger` on `program` behind a fake daemon, returning the
+    /// outcome and how many times the program was stepped
+    async fn search(program: Program, trigger: Trigger, max_steps: usize) -> (Outcome, usize) {
+        let dir = tempfile::tempdir().unwrap();
+        let name = dir.path().join("daemon.sock").to_string_lossy().into_owned();
+        let listener = ListenerOptions::new()
+            .name(name.clone().to_fs_name::<GenericFilePath>().unwrap())
+            .create_tokio()
+            .unwrap();
+
+        let daemon = tokio::spawn(async move {
+            let stream = listener.accept().await.unwrap();
+            let (mut reader, mut writer) = tokio::io::split(stream);
+            let mut steps = 0;
+            while let Ok(message) = transport::recv_message(&mut reader).await {
+                let request: Request = serde_json::from_slice(&message).unwrap();
+                let result = match request.command {
+                    Command::Next => {
+                        steps += 1;
+                        json!({})
+                    }
+                    Command::Await { .. } => {
+                        let reason = program.reasons.get(steps - 1).copied().unwrap_or("step");
+                        json!({ "reason": reason })
+                    }
+                    Command::Evaluate { .. } => {
+                        let value = program.values[steps.min(program.values.len() - 1)];
+                        json!({ "result": value, "type_name": null, "variables_reference": 0 })
+                    }
+                    command => panic!("unexpected {:?}", command),
+                };
+                let response = serde_json::to_vec(&Response::success(request.id, result)).unwrap();
+                transport::send_message(&mut writer, &response).await.unwrap();
+            }
+            steps
+        });
+
+        let mut client = DaemonClient::connect_to(&name).await.unwrap();
+        let pace = Pace { step: StepMode::Over, sample: None, max_steps, timeout: 1 };
+        let outcome = run(&mut client, "x", trigger, pace).await.unwrap();
+        drop(client);
+        (outcome, daemon.await.unwrap())
+    }
+
+    #[tokio::test]
+    async fn a_change_is_a_new_value() {
+        let program = Program { values: &["3", "3", "0"], reasons: &[] };
+        let (outcome, steps) = search(program, Trigger::Change, 10).await;
+        assert!(outcome.triggered);
+        assert_eq!(steps, 2);
+        assert_eq!(outcome.old_value.as_deref(), Some("3"));
+        assert_eq!(outcome.new_value.as_deref(), Some("0"));
+    }
+
+    #[tokio::test]
+    async fn true_is_a_non_zero_value() {
+        let program = Program { values: &["0", "0", "4"], reasons: &[] };
+        let (outcome, steps) = search(program, Trigger::True, 10).await;
+        assert!(outcome.triggered);
+        assert_eq!(steps, 2);
+
+        // Already true needs no steps, where a change would
+        let program = Program { values: &["4", "0"], reasons: &[] };
+        let (outcome, steps) = search(program, Trigger::True, 10).await;
+        assert!(outcome.triggered);
+        assert_eq!((steps, outcome.steps), (0, 0));
+
+        let program = Program { values: &["4", "0"], reasons: &[] };
+        let (outcome, steps) = search(program, Trigger::Change, 10).await;
+        assert!(outcome.triggered);
+        assert_eq!(steps, 1);
+    }
+
+    #[tokio::test]
+    async fn a_breakpoint_hit_ends_the_search() {
+        let program = Program { values: &["0"], reasons: &["step", "breakpoint"] };
+        let (outcome, steps) = search(program, Trigger::True, 10).await;
+        assert!(!outcome.triggered);
+        assert_eq!(steps, 2);
+        assert_eq!(outcome.stop.unwrap()["reason"], "breakpoint");
+    }
+
+    #[tokio::test]
+    async fn the_search_ends_when_the_program_exits() {
+        let program = Program { values: &["1", "2"], reasons: &["exited"] };
+        let (outcome, steps) = search(program, Trigger::Change, 10).await;
+        assert!(!outcome.triggered);
+        assert_eq!(steps, 1);
+        assert_eq!(outcome.new_value.as_deref(), Some("1"));
+    }
+
+    #[tokio::test]
+    async fn steps_are_capped() {
+        let program = Program { values: &["0"], reasons: &[] };
+        let (outcome, steps) = search(program, Trigger::Change, 5).await;
+        assert!(!outcome.triggered);
+        assert_eq!((steps, outcome.steps), (5, 5));
+    }
+}
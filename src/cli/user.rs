@@ -0,0 +1,632 @@
+//! User-defined commands
+//!
+//! `define NAME`, a body of debugger commands and `end` save a new command
+//! under the config directory, GDB style; `NAME ARGS...` then runs it like a
+//! built-in. In the body `${arg0}`, `${arg1}`, ... are the arguments,
+//! `${argc}` their count and `${args}` all of them. `if EXPR` / `else` /
+//! `end` and `while EXPR` / `end` branch and loop on expressions the debuggee
+//! evaluates as `print` would; a condition is false when it is zero, `false`
+//! or a null pointer. Conditions on numbers alone, such as `${argc} > 1`, are
+//! decided without asking the debuggee, so they work before `start`.
+
+use std::io::{BufRead, IsTerminal, Write};
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicUsize, Ordering};
+
+use crate::common::{paths, Error, Result};
+use crate::ipc::protocol::{Command, EvaluateContext, EvaluateResult};
+use crate::ipc::DaemonClient;
+
+use super::{batch, dispatch, script};
+
+/// Extension of saved command files
+const EXTENSION: &str = "dbg";
+
+/// Prefix of the line holding a command's description
+const DOC_PREFIX: &str = "# doc: ";
+
+/// How deeply user commands may call each other, so a command that calls
+/// itself without a way out fails instead of recursing forever
+static DEPTH: AtomicUsize = AtomicUsize::new(0);
+const MAX_DEPTH: usize = 16;
+
+/// Most times one `while` loop repeats in one run
+pub const MAX_ITERATIONS: usize = 10_000;
+
+/// A compiled body line
+#[derive(Debug, PartialEq)]
+enum Step {
+    /// Run a command line
+    Run { line: usize, text: String },
+    /// Go on to the next step if `condition` holds, otherwise jump to `to`
+    Unless {
+        line: usize,
+        condition: String,
+        to: usize,
+    },
+    /// Continue at `to`; backwards for the end of a `while`
+    Jump { to: usize },
+}
+
+/// A block that has not seen its `end` yet
+enum Open {
+    /// `test` is the `Unless` step, `skip` the jump over the `else` part
+    If {
+        line: usize,
+        test: usize,
+        skip: Option<usize>,
+    },
+    While { line: usize, test: usize },
+}
+
+/// Collects the lines of a definition up to the `end` that closes it
+#[derive(Debug, Default)]
+pub struct Collector {
+    lines: Vec<String>,
+    depth: usize,
+}
+
+impl Collector {
+    /// Add a line; `true` once it was the closing `end`, which is not kept
+    pub fn push(&mut self, line: &str) -> bool {
+        let line = line.trim();
+        match keyword(line).map(|(word, _)| word) {
+            Some("if" | "while") => self.depth += 1,
+            Some("end") if self.depth == 0 => return true,
+            Some("end") => self.depth -= 1,
+            _ => {}
+        }
+        if !line.is_empty() {
+            self.lines.push(line.to_string());
+        }
+        false
+    }
+
+    /// The body collected so far
+    pub fn into_lines(self) -> Vec<String> {
+        self.lines
+    }
+}
+
+/// A saved command's description and body
+pub struct Definition {
+    pub path: PathBuf,
+    pub doc: Option<String>,
+    pub body: Vec<String>,
+}
+
+/// Split a control line into its keyword and the rest
+fn keyword(line: &str) -> Option<(&str, &str)> {
+    let word = line.split_whitespace().next()?;
+    matches!(word, "if" | "while" | "else" | "end").then(|| (word, line[word.len()..].trim()))
+}
+
+/// Turn a body into steps, checking that its blocks are closed
+fn compile(content: &str) -> std::result::Result<Vec<Step>, (usize, String)> {
+    let mut steps = Vec::new();
+    let mut open = Vec::new();
+
+    for (index, raw) in content.lines().enumerate() {
+        let line = index + 1;
+        let text = raw.trim();
+        if text.is_empty() || text.starts_with('#') {
+            continue;
+        }
+
+        let Some((word, rest)) = keyword(text) else {
+            steps.push(Step::Run {
+                line,
+                text: text.to_string(),
+            });
+            continue;
+        };
+        match word {
+            "if" | "while" => {
+                if rest.is_empty() {
+                    return Err((line, format!("'{}' needs a condition", word)));
+                }
+                let test = steps.len();
+                steps.push(Step::Unless {
+                    line,
+                    condition: rest.to_string(),
+                    to: 0,
+                });
+                open.push(if word == "if" {
+                    Open::If {
+                        line,
+                        test,
+                        skip: None,
+                    }
+                } else {
+                    Open::While { line, test }
+                });
+            }
+            "else" => match open.last_mut() {
+                Some(Open::If {
+                    test, skip: skip @ None, ..
+                }) => {
+                    *skip = Some(steps.len());
+                    steps.push(Step::Jump { to: 0 });
+                    let after = steps.len();
+                    patch(&mut steps, *test, after);
+                }
+                Some(Open::If { .. }) => return Err((line, "'else' after 'else'".to_string())),
+                Some(Open::While { .. }) => {
+                    return Err((line, "'else' inside 'while'".to_string()))
+                }
+                None => return Err((line, "'else' without 'if'".to_string())),
+            },
+            _ => match open.pop() {
+                Some(Open::If { test, skip, .. }) => {
+                    let after = steps.len();
+                    patch(&mut steps, skip.unwrap_or(test), after);
+                }
+                Some(Open::While { test, .. }) => {
+                    steps.push(Step::Jump { to: test });
+                    let after = steps.len();
+                    patch(&mut steps, test, after);
+                }
+                None => return Err((line, "'end' without 'if' or 'while'".to_string())),
+            },
+        }
+    }
+
+    match open.last() {
+        None => Ok(steps),
+        Some(Open::If { line, .. }) => Err((*line, "'if' without a matching 'end'".to_string())),
+        Some(Open::While { line, .. }) => {
+            Err((*line, "'while' without a matching 'end'".to_string()))
+        }
+    }
+}
+
+fn patch(steps: &mut [Step], step: usize, target: usize) {
+    match &mut steps[step] {
+        Step::Unless { to, .. } | Step::Jump { to } => *to = target,
+        Step::Run { .. } => {}
+    }
+}
+
+/// Whether an evaluated condition counts as true
+///
+/// Zero in any base, `false`, null pointers and empty results are false;
+/// anything else, such as `65 'A'` or a string, is true.
+pub fn is_true(value: &str) -> bool {
+    let mut value = value.trim();
+    // A leading type, as in `(int *) 0x0`
+    if value.starts_with('(') {
+        if let Some(end) = value.find(") ") {
+            value = value[end + 2..].trim_start();
+        }
+    }
+
+    let Some(first) = value.split_whitespace().next() else {
+        return false;
+    };
+    match first {
+        "false" | "False" | "nullptr" | "NULL" | "nil" | "None" => false,
+        _ => match first.strip_prefix("0x").or_else(|| first.strip_prefix("0X")) {
+            Some(hex) => u128::from_str_radix(hex, 16).map_or(true, |n| n != 0),
+            None => first.parse::<f64>().map_or(true, |n| n != 0.0),
+        },
+    }
+}
+
+/// The value of a condition made only of integers, optionally compared
+fn constant(condition: &str) -> Option<bool> {
+    let words: Vec<&str> = condition.split_whitespace().collect();
+    let number = |word: &str| word.parse::<i64>().ok();
+    match words[..] {
+        [value] => number(value).map(|value| value != 0),
+        [left, op, right] => {
+            let (left, right) = (number(left)?, number(right)?);
+            match op {
+                "==" => Some(left == right),
+                "!=" => Some(left != right),
+                "<" => Some(left < right),
+                "<=" => Some(left <= right),
+                ">" => Some(left > right),
+                ">=" => Some(left >= right),
+                _ => None,
+            }
+        }
+        _ => None,
+    }
+}
+
+/// Check that a command name works as a file name and does not hide a
+/// built-in command
+pub fn validate_name(name: &str) -> Result<()> {
+    let valid = !name.is_empty()
+        && name
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || c == '-' || c == '_');
+    if !valid {
+        return Err(Error::UserCommand(format!(
+            "'{}' is not a valid name; use letters, digits, '-' and '_'",
+            name
+        )));
+    }
+    if let Some(builtin) = script::command_name(name) {
+        return Err(Error::UserCommand(format!(
+            "'{}' is the built-in command '{}'",
+            name, builtin
+        )));
+    }
+    Ok(())
+}
+
+fn dir() -> Result<PathBuf> {
+    paths::user_commands_dir()
+        .ok_or_else(|| Error::Config("cannot determine the config directory".to_string()))
+}
+
+fn path(name: &str) -> Result<PathBuf> {
+    validate_name(name)?;
+    Ok(dir()?.join(format!("{}.{}", name, EXTENSION)))
+}
+
+/// Whether `name` is a defined command
+pub fn exists(name: &str) -> bool {
+    path(name).is_ok_and(|path| path.is_file())
+}
+
+/// Path of an existing user command
+pub fn find(name: &str) -> Result<PathBuf> {
+    let path = path(name)?;
+    if path.is_file() {
+        Ok(path)
+    } else {
+        Err(Error::UserCommand(format!(
+            "no command named '{}'; 'user list' shows defined commands",
+            name
+        )))
+    }
+}
+
+/// Read a definition's body from stdin up to its `end`, for `define` typed
+/// at a shell
+pub fn read_body(name: &str) -> Result<Vec<String>> {
+    let interactive = std::io::stdin().is_terminal();
+    if interactive && batch::is_active() {
+        return Err(Error::UserCommand(format!(
+            "'define {}' needs its body in a command file in batch mode",
+            name
+        )));
+    }
+    if interactive {
+        eprintln!(
+            "Type commands for '{}', one per line. End with a line saying just 'end'.",
+            name
+        );
+    }
+
+    let mut collector = Collector::default();
+    let mut lines = std::io::stdin().lock().lines();
+    loop {
+        if interactive {
+            eprint!("> ");
+            let _ = std::io::stderr().flush();
+        }
+        match lines.next() {
+            Some(line) => {
+                if collector.push(&line?) {
+                    return Ok(collector.into_lines());
+                }
+            }
+            None => {
+                return Err(Error::UserCommand(format!(
+                    "the definition of '{}' ended without 'end'",
+                    name
+                )))
+            }
+        }
+    }
+}
+
+/// Check a body and save it, replacing any command of the same name
+pub fn define(name: &str, doc: Option<&str>, body: &[String]) -> Result<PathBuf> {
+    let path = path(name)?;
+    let content = body.join("\n");
+    let steps = compile(&content).map_err(|(line, message)| {
+        Error::UserCommand(format!("line {} of '{}': {}", line, name, message))
+    })?;
+
+    for step in &steps {
+        let Step::Run { line, text } = step else {
+            continue;
+        };
+        let word = text.split_whitespace().next().unwrap_or_default();
+        let known = match script::command_name(word) {
+            Some(builtin) => builtin != "define",
+            None => word == name || exists(word),
+        };
+        if !known {
+            let message = if word == "define" {
+                "'define' cannot be used inside a definition".to_string()
+            } else {
+                format!("unknown command '{}'", word)
+            };
+            return Err(Error::UserCommand(format!(
+                "line {} of '{}': {}",
+                line, name, message
+            )));
+        }
+    }
+
+    let mut saved = String::new();
+    if let Some(doc) = doc {
+        saved.push_str(DOC_PREFIX);
+        saved.push_str(doc);
+        saved.push('\n');
+    }
+    saved.push_str(&indent(body).join("\n"));
+    saved.push('\n');
+
+    std::fs::create_dir_all(dir()?)?;
+    std::fs::write(&path, saved)?;
+    Ok(path)
+}
+
+/// Body lines indented by block depth, as saved and shown
+pub fn indent(body: &[String]) -> Vec<String> {
+    let mut depth = 0usize;
+    body.iter()
+        .map(|line| {
+            let word = keyword(line).map(|(word, _)| word);
+            if matches!(word, Some("else" | "end")) {
+                depth = depth.saturating_sub(1);
+            }
+            let indented = format!("{}{}", "  ".repeat(depth), line);
+            if matches!(word, Some("if" | "while" | "else")) {
+                depth += 1;
+            }
+            indented
+        })
+        .collect()
+}
+
+/// Load a saved command
+pub fn load(name: &str) -> Result<Definition> {
+    let path = find(name)?;
+    let content = std::fs::read_to_string(&path).map_err(|e| Error::FileRead {
+        path: path.display().to_string(),
+        error: e.to_string(),
+    })?;
+
+    let mut doc = None;
+    let mut body = Vec::new();
+    for line in content.lines() {
+        if let Some(text) = line.strip_prefix(DOC_PREFIX) {
+            doc = Some(text.to_string());
+        } else if !line.trim().is_empty() {
+            body.push(line.trim().to_string());
+        }
+    }
+    Ok(Definition { path, doc, body })
+}
+
+/// Names of defined commands, sorted
+pub fn list() -> Result<Vec<String>> {
+    let Ok(entries) = std::fs::read_dir(dir()?) else {
+        return Ok(Vec::new());
+    };
+
+    let mut names: Vec<String> = entries
+        .filter_map(|entry| entry.ok())
+        .map(|entry| entry.path())
+        .filter(|path| path.extension().is_some_and(|ext| ext == EXTENSION))
+        .filter_map(|path| path.file_stem()?.to_str().map(String::from))
+        .collect();
+    names.sort();
+    Ok(names)
+}
+
+/// Run a user command with `args`; returns how many commands ran
+pub async fn run(name: &str, args: &[String]) -> Result<usize> {
+    let path = find(name)?;
+    let content = std::fs::read_to_string(&path).map_err(|e| Error::FileRead {
+        path: path.display().to_string(),
+        error: e.to_string(),
+    })?;
+    let steps = compile(&content).map_err(|(line, message)| Error::Script {
+        path: path.display().to_string(),
+        line,
+        message,
+    })?;
+
+    if DEPTH.fetch_add(1, Ordering::Relaxed) >= MAX_DEPTH {
+        DEPTH.fetch_sub(1, Ordering::Relaxed);
+        return Err(Error::UserCommand(format!(
+            "'{}' nested more than {} deep",
+            name, MAX_DEPTH
+        )));
+    }
+    let result = execute(&path, &steps, args).await;
+    DEPTH.fetch_sub(1, Ordering::Relaxed);
+    result
+}
+
+async fn execute(path: &Path, steps: &[Step], args: &[String]) -> Result<usize> {
+    let label = path.display().to_string();
+    let error = |line: usize, message: String| Error::Script {
+        path: label.clone(),
+        line,
+        message,
+    };
+    let lookup = |name: &str| match name {
+        "argc" => Some(args.len().to_string()),
+        "args" => Some(script::join_words(args)),
+        _ => {
+            let index: usize = name.strip_prefix("arg")?.parse().ok()?;
+            args.get(index).cloned()
+        }
+    };
+
+    let mut iterations = vec![0usize; steps.len()];
+    let mut count = 0;
+    let mut next = 0;
+    while let Some(step) = steps.get(next) {
+        match step {
+            Step::Run { line, text } => {
+                let text = script::interpolate(text, lookup);
+                let command = script::parse_line(&text).map_err(|message| error(*line, message))?;
+                let name = command.name();
+                if let Err(e) = Box::pin(dispatch(command)).await {
+                    // A nested command or script already names where it failed
+                    return Err(match e {
+                        Error::Script { .. } if matches!(name, "source" | "user") => e,
+                        e => error(*line, e.to_string()),
+                    });
+                }
+                count += 1;
+                next += 1;
+            }
+            Step::Unless {
+                line,
+                condition,
+                to,
+            } => {
+                let condition = script::interpolate(condition, lookup);
+                let holds = match constant(&condition) {
+                    Some(holds) => holds,
+                    None => is_true(&evaluate(&condition).await.map_err(|e| {
+                        error(*line, format!("cannot evaluate '{}': {}", condition, e))
+                    })?),
+                };
+                next = if holds { next + 1 } else { *to };
+            }
+            Step::Jump { to } => {
+                if *to < next {
+                    iterations[*to] += 1;
+                    if iterations[*to] >= MAX_ITERATIONS {
+                        let Step::Unless { line, .. } = &steps[*to] else {
+                            unreachable!("loops jump back to their condition");
+                        };
+                        return Err(error(
+                            *line,
+                            format!("'while' repeated {} times; stopping", MAX_ITERATIONS),
+                        ));
+                    }
+                    // A loop whose commands all finish at once must still let
+                    // a `--ci` time limit interrupt it
+                    tokio::task::yield_now().await;
+                }
+                next = *to;
+            }
+        }
+    }
+    Ok(count)
+}
+
+async fn evaluate(expression: &str) -> Result<String> {
+    let mut client = DaemonClient::connect().await?;
+    let result = client
+        .send_command(Command::Evaluate {
+            expression: expression.to_string(),
+            frame_id: None,
+            context: EvaluateContext::Watch,
+            limit: None,
+        })
+        .await?;
+    let eval: EvaluateResult = serde_json::from_value(result)?;
+    Ok(eval.result)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn collector_stops_at_the_closing_end() {
+        let mut collector = Collector::default();
+        for line in ["while $i < 3", "  print $i", "end"] {
+            assert!(!collector.push(line));
+        }
+        assert!(collector.push("end"));
+        assert_eq!(collector.into_lines(), vec!["while $i < 3", "print $i", "end"]);
+    }
+
+    #[test]
+    fn compile_links_branches_and_loops() {
+        let steps = compile("if x\nprint a\nelse\nprint b\nend\nwhile y\nnext\nend").unwrap();
+        let unless = |line, condition: &str, to| Step::Unless {
+            line,
+            condition: condition.to_string(),
+            to,
+        };
+        let run = |line, text: &str| Step::Run {
+            line,
+            text: text.to_string(),
+        };
+        assert_eq!(
+            steps,
+            vec![
+                unless(1, "x", 3),
+                run(2, "print a"),
+                Step::Jump { to: 4 },
+                run(4, "print b"),
+                unless(6, "y", 7),
+                run(7, "next"),
+                Step::Jump { to: 4 },
+            ]
+        );
+    }
+
+    #[test]
+    fn compile_reports_unbalanced_blocks() {
+        assert_eq!(compile("print a\nend").unwrap_err().0, 2);
+        assert_eq!(compile("while x\nnext").unwrap_err().0, 1);
+        assert!(compile("while x\nelse\nend").is_err());
+        assert!(compile("if\nend").is_err());
+    }
+
+    #[test]
+    fn conditions_follow_c_truthiness() {
+        let false_values = [
+            "0",
+            "0x0",
+            "0.0",
+            "(int *) 0x0000000000000000",
+            "false",
+            "nullptr",
+            "0 '\\000'",
+            "0 '\\0'",
+            "",
+        ];
+        for value in false_values {
+            assert!(!is_true(value), "{value}");
+        }
+        for value in ["1", "-2", "65 'A'", "0x7ffd1234", "true", "\"text\""] {
+            assert!(is_true(value), "{value}");
+        }
+    }
+
+    #[test]
+    fn argument_conditions_need_no_session() {
+        assert_eq!(constant("2"), Some(true));
+        assert_eq!(constant("0 >= 1"), Some(false));
+        assert_eq!(constant("3 != 1"), Some(true));
+        assert_eq!(constant("i < 3"), None);
+        assert_eq!(constant("1 + 1"), None);
+    }
+
+    #[test]
+    fn indent_follows_blocks() {
+        let body: Vec<String> = ["if x", "print a", "else", "print b", "end"]
+            .iter()
+            .map(|line| line.to_string())
+            .collect();
+        assert_eq!(
+            indent(&body),
+            vec!["if x", "  print a", "else", "  print b", "end"]
+        );
+    }
+
+    #[test]
+    fn names_cannot_hide_builtins() {
+        assert!(validate_name("print-workers").is_ok());
+        assert!(validate_name("bt").is_err());
+        assert!(validate_name("a/b").is_err());
+    }
+}
@@ -0,0 +1,194 @@
+//! The watchdog in `--batch` and `--ci` runs
+//!
+//! A run arms the daemon's watchdog (`set watchdog`) from `--watchdog` and
+//! `--watchdog-policy` or the `[watchdog]` config, so a program that hangs
+//! is interrupted and leaves every thread's backtrace behind instead of
+//! running into the CI job's time limit. Each interrupt is printed as the
+//! daemon reports it, kept for the `--ci` report, and the daemon's previous
+//! settings come back when the run ends.
+
+use std::sync::Mutex;
+use std::time::Duration;
+
+use tokio::task::JoinHandle;
+
+use crate::common::config::{Config, WatchdogPolicy};
+use crate::common::settings::Settings;
+use crate::common::Result;
+use crate::ipc::protocol::{Command, WatchdogFiring};
+use crate::ipc::DaemonClient;
+
+use super::theme::{self, Element};
+use super::{output, spawn};
+
+/// How often the daemon is asked whether the watchdog fired
+const POLL_INTERVAL: Duration = Duration::from_secs(1);
+
+/// Interrupts reported so far in this run
+static FIRINGS: Mutex<Vec<WatchdogFiring>> = Mutex::new(Vec::new());
+
+/// `--watchdog` and `--watchdog-policy`, overriding the config
+#[derive(Debug, Default)]
+pub struct WatchdogOptions {
+    pub idle_secs: Option<u64>,
+    pub policy: Option<WatchdogPolicy>,
+}
+
+/// An armed watchdog and the settings to restore
+pub struct Watchdog {
+    previous: Settings,
+    reporter: JoinHandle<()>,
+}
+
+/// Arm the daemon's watchdog for a run, unless it is configured off
+pub async fn arm(options: &WatchdogOptions) -> Result<Option<Watchdog>> {
+    let config = Config::load().map(|config| config.watchdog).unwrap_or_default();
+    let idle_secs = options.idle_secs.unwrap_or(config.idle_secs);
+    if idle_secs == 0 {
+        return Ok(None);
+    }
+    let policy = options.policy.unwrap_or(config.policy);
+
+    spawn::ensure_daemon_running().await?;
+    let mut client = DaemonClient::connect().await?;
+    let previous: Settings =
+        serde_json::from_value(client.send_command(Command::Settings).await?)?;
+    set(&mut client, "watchdog", idle_secs.to_string()).await?;
+    set(&mut client, "watchdog-policy", policy.to_string()).await?;
+
+    Ok(Some(Watchdog {
+        previous,
+        reporter: tokio::spawn(report()),
+    }))
+}
+
+impl Watchdog {
+    /// Put the daemon's settings back and return every interrupt of the run
+    pub async fn disarm(self) -> Vec<WatchdogFiring> {
+        self.reporter.abort();
+        if let Ok(mut client) = DaemonClient::connect().await {
+            collect(&mut client).await;
+            for name in ["watchdog", "watchdog-policy"] {
+                if let Ok(shown) = self.previous.show(Some(name)) {
+                    let _ = set(&mut client, name, shown[0].1.clone()).await;
+                }
+            }
+        }
+        FIRINGS.lock().map(|mut firings| std::mem::take(&mut *firings)).unwrap_or_default()
+    }
+}
+
+async fn set(client: &mut DaemonClient, name: &str, value: String) -> Result<()> {
+    client
+        .send_command(Command::Set {
+            name: name.to_string(),
+            args: vec![value],
+        })
+        .await?;
+    Ok(())
+}
+
+/// Print each interrupt as the daemon reports it, until aborted
+async fn report() {
+    loop {
+        tokio::time::sleep(POLL_INTERVAL).await;
+        if let Ok(mut client) = DaemonClient::connect().await {
+            collect(&mut client).await;
+        }
+    }
+}
+
+async fn collect(client: &mut DaemonClient) {
+    let Ok(mut result) = client.send_command(Command::WatchdogFirings).await else {
+        return;
+    };
+    let firings: Vec<WatchdogFiring> =
+        serde_json::from_value(result["firings"].take()).unwrap_or_default();
+    for firing in firings {
+        if output::is_json() {
+            let _ = output::emit("watchdog", &firing);
+        } else {
+            let label = theme::paint_stderr(Element::Error, "Watchdog:");
+            eprintln!("{} {}", label, describe(&firing));
+        }
+        if let Ok(mut all) = FIRINGS.lock() {
+            all.push(firing);
+        }
+    }
+}
+
+/// What an interrupt found: one line, then each thread's backtrace
+pub fn describe(firing: &WatchdogFiring) -> String {
+    let action = match firing.policy {
+        WatchdogPolicy::Resume => "resumed it",
+        WatchdogPolicy::Abort => "ended the session",
+    };
+    let mut text = format!(
+        "the program ran {}s without an event; interrupted it at {} and {}",
+        firing.idle_secs, firing.time, action
+    );
+    if let Some(error) = &firing.error {
+        text.push_str(&format!(" ({})", error));
+    }
+    for thread in &firing.threads {
+        text.push_str(&format!("\nThread {} ({}):", thread.id, thread.name));
+        for (index, frame) in thread.frames.iter().enumerate() {
+            text.push_str(&format!("\n  #{} {}", index, frame.name));
+            if let (Some(source), Some(line)) = (&frame.source, frame.line) {
+                text.push_str(&format!(" at {}:{}", source, line));
+            }
+        }
+    }
+    text
+}
+
+/// The interrupt as a failed batch command, for a run it aborted
+pub fn failure(firing: &WatchdogFiring) -> Option<String> {
+    (firing.policy == WatchdogPolicy::Abort).then(|| {
+        format!(
+            "the program ran {}s without an event and was aborted",
+            firing.idle_secs
+        )
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::ipc::protocol::{StackFrameInfo, ThreadBacktrace};
+
+    #[test]
+    fn describe_lists_every_thread() {
+        let frame = |name: &str, line| StackFrameInfo {
+            id: 0,
+            name: name.to_string(),
+            source: Some("/src/worker.c".to_string()),
+            line: Some(line),
+            column: Some(1),
+        };
+        let firing = WatchdogFiring {
+            time: "2026-01-25 14:03:07".to_string(),
+            idle_secs: 30,
+            policy: WatchdogPolicy::Abort,
+            threads: vec![
+                ThreadBacktrace {
+                    id: 1,
+                    name: "main".to_string(),
+                    frames: vec![frame("pthread_join", 80), frame("main", 12)],
+                },
+                ThreadBacktrace {
+                    id: 2,
+                    name: "worker".to_string(),
+                    frames: vec![frame("spin", 41)],
+                },
+            ],
+            error: None,
+        };
+
+        let text = describe(&firing);
+        assert!(text.starts_with("the program ran 30s without an event;"));
+        assert!(text.contains("\nThread 1 (main):\n  #0 pthread_join at /src/worker.c:80"));
+        assert!(text.contains("\nThread 2 (worker):\n  #0 spin at /src/worker.c:41"));
+        assert!(failure(&firing).is_some());
+    }
+}
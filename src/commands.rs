@@ -4,6 +4,9 @@
 
 use clap::Subcommand;
 use std::path::PathBuf;
+use std::time::Duration;
+
+use crate::ipc::protocol::{BtraceFormat, DwarfQuery, EventKind, FindKind};
 
 #[derive(Subcommand)]
 pub enum Commands {
@@ -24,16 +27,31 @@ pub enum Commands {
         #[arg(long)]
         stop_on_entry: bool,
 
-        /// Set initial breakpoint(s) before program starts (file:line or function name)
+        /// Set initial breakpoint(s) before program starts (file:line, function name or
+        /// @marker:NAME)
         /// Can be specified multiple times: --break main --break src/file.c:42
         #[arg(long = "break", short = 'b')]
         initial_breakpoints: Vec<String>,
+
+        /// Do not run the project's .dbginit file
+        #[arg(long)]
+        no_init: bool,
     },
 
-    /// Attach to a running process
+    /// Attach to a running process, or to a program under a gdbserver stub
     Attach {
         /// Process ID to attach to
-        pid: u32,
+        #[arg(required_unless_present = "remote")]
+        pid: Option<u32>,
+
+        /// Connect to a gdbserver-protocol stub instead, such as gdbserver
+        /// or `qemu-aarch64 -g PORT`
+        #[arg(long, value_name = "HOST:PORT", conflicts_with = "pid", requires = "program")]
+        remote: Option<String>,
+
+        /// The binary running under the stub, for its symbols
+        #[arg(long, requires = "remote")]
+        program: Option<PathBuf>,
 
         /// Debug adapter to use (default: lldb-dap)
         #[arg(long)]
@@ -44,10 +62,66 @@ pub enum Commands {
     #[command(subcommand)]
     Breakpoint(BreakpointCommands),
 
+    /// Watch expressions, shown after every stop
+    #[command(subcommand)]
+    Watch(WatchCommands),
+
+    /// Record expressions' values periodically while the program runs
+    #[command(subcommand)]
+    Sample(SampleCommands),
+
+    /// Track containers' sizes and warn about ones that keep growing
+    #[command(subcommand)]
+    Track(TrackCommands),
+
+    /// Log calls to and returns from functions while the program runs
+    #[command(subcommand)]
+    Trace(TraceCommands),
+
+    /// Step through the program line by line, recording where it goes
+    #[command(subcommand)]
+    Record(RecordCommands),
+
+    /// Look at the steps of a recording in place of the program
+    #[command(subcommand)]
+    Replay(ReplayCommands),
+
+    /// Sample every thread's stack while the program runs, for a flame graph
+    #[command(subcommand)]
+    Profile(ProfileCommands),
+
+    /// See which source lines the program runs
+    #[command(subcommand)]
+    Coverage(CoverageCommands),
+
+    /// Find where the program spends its steps
+    #[command(subcommand)]
+    Analyze(AnalyzeCommands),
+
+    /// Compare a Go program's heap statistics between stops
+    #[command(subcommand)]
+    Heap(HeapCommands),
+
+    /// Measure the time the program takes between two locations
+    #[command(subcommand)]
+    Timer(TimerCommands),
+
+    /// Open and compare core dumps
+    #[command(subcommand)]
+    Core(CoreCommands),
+
+    /// Record the program's branches in hardware and show the path to a stop
+    #[command(subcommand)]
+    Btrace(BtraceCommands),
+
+    /// Read the program's symbols from a separate debug file
+    #[command(subcommand)]
+    Symbols(SymbolsCommands),
+
     /// Shorthand for 'breakpoint add'
     #[command(name = "break", alias = "b")]
     Break {
-        /// Location: file:line or function name
+        /// Location: file:line, function name, @marker:NAME or *ADDRESS
         location: String,
 
         /// Condition for the breakpoint
@@ -59,25 +133,62 @@ pub enum Commands {
         hit_count: Option<u32>,
     },
 
+    /// Undo the last breakpoint removal, enable or disable
+    Undo,
+
     /// Continue execution
     #[command(alias = "c")]
-    Continue,
+    Continue {
+        /// Wait this many seconds for the program to stop, and fail if it
+        /// does not, instead of returning at once
+        #[arg(long, value_name = "SECS")]
+        timeout: Option<u64>,
+    },
 
     /// Step over (execute current line, step over function calls)
     #[command(alias = "n")]
-    Next,
+    Next {
+        /// Wait this many seconds for the program to stop, and fail if it
+        /// does not, instead of returning at once
+        #[arg(long, value_name = "SECS")]
+        timeout: Option<u64>,
+    },
 
     /// Step into (execute current line, step into function calls)
     #[command(alias = "s")]
-    Step,
+    Step {
+        /// Wait this many seconds for the program to stop, and fail if it
+        /// does not, instead of returning at once
+        #[arg(long, value_name = "SECS")]
+        timeout: Option<u64>,
+    },
 
     /// Step out (run until current function returns)
     #[command(alias = "out")]
-    Finish,
+    Finish {
+        /// Wait this many seconds for the program to stop, and fail if it
+        /// does not, instead of returning at once
+        #[arg(long, value_name = "SECS")]
+        timeout: Option<u64>,
+    },
 
     /// Pause execution
     Pause,
 
+    /// Step until an expression's value changes (a software watchpoint)
+    #[command(name = "watch-change")]
+    WatchChange {
+        #[command(flatten)]
+        watch: SoftwareWatch,
+    },
+
+    /// Step until an expression becomes true
+    #[command(name = "break-when")]
+    BreakWhen {
+        #[command(flatten)]
+        watch: SoftwareWatch,
+    },
+
     /// Print stack trace
     #[command(alias = "bt")]
     Backtrace {
@@ -85,6 +196,14 @@ pub enum Commands {
         #[arg(long, default_value = "20")]
         limit: usize,
 
+        /// Skip this many innermost frames, to page through a deep stack
+        #[arg(long, default_value = "0")]
+        start: usize,
+
+        /// Go on from where the last backtrace of the thread stopped
+        #[arg(long, conflicts_with = "start")]
+        more: bool,
+
         /// Show local variables for each frame
         #[arg(long)]
         locals: bool,
@@ -98,25 +217,118 @@ pub enum Commands {
     Print {
         /// Expression to evaluate
         expression: String,
+        /// Give up after this many seconds instead of the `command-timeout`
+        /// setting
+        #[arg(long, value_name = "SECS")]
+        timeout: Option<u64>,
+        /// Show this much of the value, like 1M, instead of the
+        /// `print-limit` setting; 0 for all of it
+        #[arg(long, value_name = "BYTES", value_parser = crate::common::settings::parse_bytes)]
+        limit: Option<usize>,
     },
 
     /// Evaluate expression (can have side effects)
     Eval {
         /// Expression to evaluate
         expression: String,
+        /// Give up after this many seconds instead of the `command-timeout`
+        /// setting
+        #[arg(long, value_name = "SECS")]
+        timeout: Option<u64>,
+        /// Show this much of the value, like 1M, instead of the
+        /// `print-limit` setting; 0 for all of it
+        #[arg(long, value_name = "BYTES", value_parser = crate::common::settings::parse_bytes)]
+        limit: Option<usize>,
+    },
+
+    /// Fail, and mark the session failed, unless an expression is true
+    Assert {
+        /// Expression that should be true, e.g. count == 5
+        #[arg(required = true, num_args = 1.., trailing_var_arg = true, allow_hyphen_values = true)]
+        expression: Vec<String>,
     },
 
     /// Show current position with source context and variables
     #[command(alias = "where")]
     Context {
-        /// Number of context lines to show
-        #[arg(long, default_value = "5")]
-        lines: usize,
+        /// Number of context lines to show [default: `set listsize`, 5]
+        #[arg(long)]
+        lines: Option<usize>,
+    },
+
+    /// Choose the panes `context` shows, or list layouts
+    Layout {
+        /// Layout to use: src, asm, src+asm, threads, full, or one from the config
+        name: Option<String>,
+    },
+
+    /// Disassemble around the current instruction
+    #[command(alias = "disas")]
+    Disassemble {
+        /// Number of instructions to show
+        #[arg(long, default_value = "10")]
+        count: usize,
+    },
+
+    /// Open the current frame's source line in $VISUAL or $EDITOR
+    Edit {
+        /// Frame number from `backtrace` [default: selected frame]
+        frame: Option<usize>,
+    },
+
+    /// Fuzzy search functions or source files, e.g. `find func wrkr strt`
+    Find {
+        /// What to search: func or file
+        #[arg(value_enum)]
+        kind: FindKind,
+
+        /// Words to match against names, in any order
+        #[arg(required = true)]
+        query: Vec<String>,
+
+        /// Maximum number of results
+        #[arg(long, default_value = "10")]
+        limit: usize,
+    },
+
+    /// Query the program's debug info: types, functions, line tables,
+    /// inlined calls, or one entry, e.g. `dwarf types --filter Worker`
+    Dwarf {
+        #[command(subcommand)]
+        query: DwarfQuery,
+    },
+
+    /// Put functions and source lines to the addresses in a backtrace, Go
+    /// panic or other log captured elsewhere, from a copy of the binary
+    Symbolicate {
+        /// The binary the log came from, with its symbols
+        #[arg(long)]
+        binary: PathBuf,
+
+        /// The log to read; without it, paste the log into the terminal
+        file: Option<PathBuf>,
+
+        /// Where a position-independent binary was loaded, minus where it
+        /// was linked, to take off absolute addresses
+        #[arg(
+            long,
+            default_value = "0",
+            value_parser = crate::symbols::symbolicate::parse_address
+        )]
+        slide: u64,
     },
 
     /// List all threads
     Threads,
 
+    /// List the executable and shared libraries, and whether their symbols
+    /// have been read yet
+    Modules,
+
+    /// Show what the debugged process is like
+    #[command(subcommand)]
+    Info(InfoCommands),
+
     /// Switch to a specific thread
     Thread {
         /// Thread ID to switch to
@@ -143,7 +355,11 @@ pub enum Commands {
     },
 
     /// Get debuggee stdout/stderr output
+    #[command(args_conflicts_with_subcommands = true)]
     Output {
+        #[command(subcommand)]
+        action: Option<OutputCommands>,
+
         /// Stream output continuously
         #[arg(long, conflicts_with_all = ["tail", "clear"])]
         follow: bool,
@@ -157,8 +373,58 @@ pub enum Commands {
         clear: bool,
     },
 
+    /// Show the session's stops, output, threads and samples in the order
+    /// they happened, a lane per thread
+    Timeline {
+        /// Show only the last N events
+        #[arg(long)]
+        tail: Option<usize>,
+
+        /// Write the events as JSON to this file instead of printing them
+        #[arg(long, value_name = "FILE")]
+        export: Option<PathBuf>,
+    },
+
+    /// Record commands, their output and debuggee output to a file
+    #[command(subcommand)]
+    Transcript(TranscriptCommands),
+
+    /// Write a report of the session from a template
+    #[command(subcommand)]
+    Report(ReportCommands),
+
+    /// Record the commands you run into a named macro
+    #[command(name = "record-macro", subcommand)]
+    RecordMacro(RecordMacroCommands),
+
+    /// Replay and manage recorded macros
+    #[command(subcommand)]
+    Macro(MacroCommands),
+
+    /// Define a command from debugger commands, ended by a line with 'end'
+    Define {
+        /// Command name: letters, digits, '-' and '_'
+        name: String,
+
+        /// One-line description shown by 'user list'
+        #[arg(long)]
+        doc: Option<String>,
+
+        /// Body lines, when the definition comes from a script
+        #[arg(skip)]
+        body: Option<Vec<String>>,
+    },
+
+    /// Run and manage user-defined commands
+    #[command(subcommand)]
+    User(UserCommands),
+
     /// Get daemon/session status
-    Status,
+    Status {
+        /// Print one line for a shell prompt or status bar
+        #[arg(long)]
+        line: bool,
+    },
 
     /// Stop debugging (terminates debuggee and session)
     Stop,
@@ -169,6 +435,116 @@ pub enum Commands {
     /// Restart program (re-launch with same arguments)
     Restart,
 
+    /// Set commands to run before a command ('stop' means every program stop)
+    #[command(name = "hook-pre")]
+    HookPre {
+        /// Command name, or 'stop'
+        target: String,
+
+        /// Command lines to run, each quoted; none removes the hook
+        commands: Vec<String>,
+    },
+
+    /// Set commands to run after a command ('stop' means every program stop)
+    #[command(name = "hook-post")]
+    HookPost {
+        /// Command name, or 'stop'
+        target: String,
+
+        /// Command lines to run, each quoted; none removes the hook
+        commands: Vec<String>,
+    },
+
+    /// List command hooks
+    Hooks,
+
+    /// Run commands on a program event, e.g. `on breakpoint worker_start "print item"`
+    On {
+        /// Event: stop, breakpoint, exit or signal
+        #[arg(value_enum)]
+        event: EventKind,
+
+        /// For breakpoint and signal, first which one: ID, function, file:line
+        /// or signal name ('*' for any). Then command lines, each quoted; none
+        /// removes the handler
+        args: Vec<String>,
+
+        /// Run the lines with the shell, the event in DEBUGGER_* variables
+        #[arg(long)]
+        shell: bool,
+    },
+
+    /// Run the commands in a file, one per line as in .dbginit
+    Source {
+        /// Command file to run
+        file: PathBuf,
+
+        /// Run the remaining commands after one fails (the script still fails)
+        #[arg(long, conflicts_with = "stop_on_error")]
+        continue_on_error: bool,
+
+        /// Stop at the first failing command [default]
+        #[arg(long)]
+        stop_on_error: bool,
+
+        /// Define ${NAME} for the script (repeatable)
+        #[arg(long = "define", short = 'D', value_name = "NAME=VALUE")]
+        definitions: Vec<String>,
+    },
+
+    /// Run a Python script with the `dbg` session module importable
+    Python {
+        /// Script to run
+        script: PathBuf,
+
+        /// Arguments passed to the script as sys.argv[1:]
+        #[arg(trailing_var_arg = true, allow_hyphen_values = true)]
+        args: Vec<String>,
+    },
+
+    /// Serve the session over a local JSON/HTTP API for editors and tools
+    Serve {
+        /// Address to listen on
+        #[arg(long, default_value = "127.0.0.1:7878")]
+        listen: std::net::SocketAddr,
+
+        /// Token requests must send as `Authorization: Bearer TOKEN`
+        /// [default: $DEBUGGER_API_TOKEN, else a new one, printed]
+        #[arg(long)]
+        token: Option<String>,
+    },
+
+    /// Serve debugger tools to an LLM agent over MCP on stdin/stdout
+    ServeMcp {
+        /// Only offer these tools (comma-separated or repeatable) [default: all]
+        #[arg(long, value_delimiter = ',', value_name = "TOOL")]
+        allow: Vec<String>,
+
+        /// Only offer tools that inspect the session without changing it
+        #[arg(long)]
+        read_only: bool,
+
+        /// Append every tool call to this file [default: mcp-audit.jsonl in the log directory]
+        #[arg(long, value_name = "FILE")]
+        audit_log: Option<PathBuf>,
+    },
+
+    /// Change a debugger setting (see 'show' for the list)
+    Set {
+        /// Setting name
+        name: String,
+
+        /// New value
+        #[arg(allow_hyphen_values = true)]
+        value: Vec<String>,
+    },
+
+    /// Show debugger settings
+    Show {
+        /// Setting to show (default: all)
+        name: Option<String>,
+    },
+
     /// View daemon logs (for debugging)
     Logs {
         /// Number of lines to show (default: 50)
@@ -184,9 +560,24 @@ pub enum Commands {
         clear: bool,
     },
 
-    /// [Hidden] Run in daemon mode - spawned automatically
-    #[command(hide = true)]
-    Daemon,
+    /// Start, stop or list session daemons; with no subcommand, run one in
+    /// the foreground (other commands spawn it automatically)
+    Daemon {
+        #[command(subcommand)]
+        action: Option<DaemonCommands>,
+    },
+
+    /// Join a running session and type commands against it, seeing what
+    /// other clients do as it happens
+    Connect {
+        /// Session to join [default: --session, or "default"]
+        session: Option<String>,
+    },
+
+    /// Drive several sessions from one invocation: start them, switch
+    /// between them, and run a command in all of them at once
+    #[command(subcommand)]
+    Session(SessionCommands),
 
     /// Install and manage debug adapters
     Setup {
@@ -230,6 +621,20 @@ pub enum Commands {
         json: bool,
     },
 
+    /// Trust a project .dbginit file so it runs on session start
+    Trust {
+        /// Init file to trust (default: ./.dbginit)
+        path: Option<PathBuf>,
+
+        /// Remove the file from the trust list instead
+        #[arg(long, conflicts_with = "list")]
+        revoke: bool,
+
+        /// List trusted init files
+        #[arg(long)]
+        list: bool,
+    },
+
     /// Execute a test scenario defined in a YAML file
     Test {
         /// Path to the YAML test scenario file
@@ -241,11 +646,771 @@ pub enum Commands {
     },
 }
 
+impl Commands {
+    /// Canonical command name, as typed on the command line
+    pub fn name(&self) -> &'static str {
+        match self {
+            Self::Start { .. } => "start",
+            Self::Attach { .. } => "attach",
+            Self::Breakpoint(_) => "breakpoint",
+            Self::Watch(_) => "watch",
+            Self::Sample(_) => "sample",
+            Self::Track(_) => "track",
+            Self::Trace(_) => "trace",
+            Self::Record(_) => "record",
+            Self::Replay(_) => "replay",
+            Self::Profile(_) => "profile",
+            Self::Coverage(_) => "coverage",
+            Self::Analyze(_) => "analyze",
+            Self::Heap(_) => "heap",
+            Self::Timer(_) => "timer",
+            Self::Core(_) => "core",
+            Self::Btrace(_) => "btrace",
+            Self::Symbols(_) => "symbols",
+            Self::Break { .. } => "break",
+            Self::Undo => "undo",
+            Self::Continue { .. } => "continue",
+            Self::Next { .. } => "next",
+            Self::Step { .. } => "step",
+            Self::Finish { .. } => "finish",
+            Self::Pause => "pause",
+            Self::WatchChange { .. } => "watch-change",
+            Self::BreakWhen { .. } => "break-when",
+            Self::Backtrace { .. } => "backtrace",
+            Self::Locals => "locals",
+            Self::Print { .. } => "print",
+            Self::Eval { .. } => "eval",
+            Self::Assert { .. } => "assert",
+            Self::Context { .. } => "context",
+            Self::Layout { .. } => "layout",
+            Self::Disassemble { .. } => "disassemble",
+            Self::Edit { .. } => "edit",
+            Self::Find { .. } => "find",
+            Self::Dwarf { .. } => "dwarf",
+            Self::Symbolicate { .. } => "symbolicate",
+            Self::Threads => "threads",
+            Self::Modules => "modules",
+            Self::Info(_) => "info",
+            Self::Thread { .. } => "thread",
+            Self::Frame { .. } => "frame",
+            Self::Up => "up",
+            Self::Down => "down",
+            Self::Await { .. } => "await",
+            Self::Output { .. } => "output",
+            Self::Timeline { .. } => "timeline",
+            Self::Transcript(_) => "transcript",
+            Self::Report(_) => "report",
+            Self::RecordMacro(_) => "record-macro",
+            Self::Macro(_) => "macro",
+            Self::Define { .. } => "define",
+            Self::User(_) => "user",
+            Self::Status { .. } => "status",
+            Self::Stop => "stop",
+            Self::Detach => "detach",
+            Self::Restart => "restart",
+            Self::HookPre { .. } => "hook-pre",
+            Self::HookPost { .. } => "hook-post",
+            Self::Hooks => "hooks",
+            Self::On { .. } => "on",
+            Self::Source { .. } => "source",
+            Self::Python { .. } => "python",
+            Self::Serve { .. } => "serve",
+            Self::ServeMcp { .. } => "serve-mcp",
+            Self::Set { .. } => "set",
+            Self::Show { .. } => "show",
+            Self::Logs { .. } => "logs",
+            Self::Daemon { .. } => "daemon",
+            Self::Connect { .. } => "connect",
+            Self::Session(_) => "session",
+            Self::Setup { .. } => "setup",
+            Self::Trust { .. } => "trust",
+            Self::Test { .. } => "test",
+        }
+    }
+}
+
+/// The expression `watch-change` and `break-when` evaluate, and how they
+/// move the program between evaluations
+#[derive(clap::Args)]
+pub struct SoftwareWatch {
+    /// Expression to evaluate after every step
+    #[arg(required = true, num_args = 1.., allow_hyphen_values = true)]
+    pub expression: Vec<String>,
+
+    /// Step over or into calls between evaluations
+    #[arg(long, value_enum, default_value_t = StepMode::Over)]
+    pub step: StepMode,
+
+    /// Run for this many milliseconds between evaluations instead of
+    /// stepping
+    #[arg(long, value_name = "MS")]
+    pub sample: Option<u64>,
+
+    /// Give up after this many steps or samples
+    #[arg(long, default_value = "10000")]
+    pub max_steps: usize,
+
+    /// Seconds to wait for each step to stop
+    #[arg(long, default_value = "30")]
+    pub timeout: u64,
+}
+
+/// How `watch-change` and `break-when` move the program
+#[derive(Debug, Clone, Copy, PartialEq, Eq, clap::ValueEnum)]
+pub enum StepMode {
+    /// Step over calls, staying in the current function
+    Over,
+    /// Step into calls
+    Into,
+}
+
+#[derive(Subcommand)]
+pub enum WatchCommands {
+    /// Add a watch expression
+    Add {
+        /// Expression to evaluate at every stop
+        expression: String,
+    },
+
+    /// Remove a watch expression
+    Remove {
+        /// Watch ID to remove
+        id: u32,
+    },
+
+    /// Show watch values, marking those changed since the previous stop
+    List,
+
+    /// Show the values a watch had at each stop
+    History {
+        /// Watch ID
+        id: u32,
+    },
+}
+
+#[derive(Subcommand)]
+pub enum SampleCommands {
+    /// Sample an expression while the program runs, pausing it briefly
+    /// each time
+    Add {
+        /// Expression to evaluate
+        expression: String,
+
+        /// How often to sample, e.g. 100ms, 2s or 1m
+        #[arg(long, value_parser = parse_interval, default_value = "1s")]
+        every: Duration,
+    },
+
+    /// Stop sampling an expression
+    Remove {
+        /// Sample ID to remove
+        id: u32,
+    },
+
+    /// Show the sampled expressions and their latest values
+    List,
+
+    /// Print or write every sampled value
+    Export {
+        /// Output format
+        #[arg(value_enum, default_value_t = SampleFormat::Csv)]
+        format: SampleFormat,
+
+        /// Write to this file instead of printing
+        #[arg(long, short = 'f')]
+        file: Option<PathBuf>,
+    },
+
+    /// Drop the sampled values, keeping the expressions
+    Clear,
+}
+
+#[derive(Subcommand)]
+pub enum TrackCommands {
+    /// Measure a container's length, capacity and bytes at every stop, and
+    /// warn at stops once it has grown several times in a row
+    Size {
+        /// Expression for the container, e.g. a slice, list or vector
+        expression: String,
+
+        /// Measure this often while the program runs instead, e.g. 500ms or
+        /// 2s, pausing it briefly each time
+        #[arg(long, value_parser = parse_interval)]
+        every: Option<Duration>,
+
+        /// Growths in a row before warning; 0 never warns
+        #[arg(long, default_value_t = 5)]
+        threshold: u32,
+    },
+
+    /// Stop tracking a container
+    Remove {
+        /// Tracked expression ID
+        id: u32,
+    },
+
+    /// Show the tracked containers and their latest sizes
+    List,
+
+    /// Show every size a container was measured at
+    History {
+        /// Tracked expression ID
+        id: u32,
+    },
+}
+
+#[derive(Subcommand)]
+pub enum TraceCommands {
+    /// Log every call to the functions matching a glob, with its arguments,
+    /// and its return, without stopping the program
+    Functions {
+        /// Function names, with * and ? wildcards, e.g. 'main.*'
+        pattern: String,
+
+        /// Keep printing the log until the program exits
+        #[arg(long, short)]
+        follow: bool,
+    },
+
+    /// Log the program's syscalls with their arguments and results, among
+    /// the traced calls
+    Syscalls {
+        /// Only these, comma-separated: file, desc, net, process, memory,
+        /// signal, ipc, or syscall names
+        #[arg(long)]
+        filter: Option<String>,
+
+        /// Keep printing the log until the program exits
+        #[arg(long, short)]
+        follow: bool,
+    },
+
+    /// Show the calls and syscalls logged so far
+    Log {
+        /// Keep printing new entries until the program exits
+        #[arg(long, short, conflicts_with = "tail")]
+        follow: bool,
+
+        /// Show only the last N entries
+        #[arg(long)]
+        tail: Option<usize>,
+    },
+
+    /// Stop tracing, keeping the log
+    Stop,
+
+    /// Drop the log
+    Clear,
+}
+
+#[derive(Subcommand)]
+pub enum RecordCommands {
+    /// Single-step from the current stop, keeping the stack at every step
+    /// and the locals at the snapshot points, until the limit, a stop of
+    /// another kind or the end of the program
+    Start {
+        /// Snapshot the locals only here (file:line or function); repeat for
+        /// several points; without it, at every step
+        #[arg(long, value_name = "LOCATION")]
+        at: Vec<String>,
+
+        /// Steps to record at most
+        #[arg(long, default_value_t = 10_000)]
+        limit: u64,
+
+        /// Step over calls instead of into them
+        #[arg(long)]
+        over: bool,
+    },
+
+    /// Stop recording, leaving the program where it is
+    Stop,
+}
+
+#[derive(Subcommand)]
+pub enum ReplayCommands {
+    /// List the recorded steps
+    List {
+        /// Show only the last N steps
+        #[arg(long)]
+        tail: Option<usize>,
+    },
+
+    /// Point context, locals, backtrace and print at a recorded step
+    Seek {
+        /// Step number, or +N / -N to move from the current one
+        #[arg(allow_hyphen_values = true, value_parser = parse_seek)]
+        step: SeekTarget,
+    },
+
+    /// Point the views back at the program
+    Exit,
+}
+
+#[derive(Subcommand)]
+pub enum ProfileCommands {
+    /// Start sampling stacks, dropping the previous profile
+    Start {
+        /// Samples per second; each one pauses the program briefly
+        #[arg(long, default_value_t = 99)]
+        hz: u32,
+    },
+
+    /// Stop sampling, keeping the profile
+    Stop,
+
+    /// Draw the profile as an SVG flame graph
+    Flamegraph {
+        /// SVG file to write
+        file: PathBuf,
+    },
+
+    /// Print or write the profile as folded stacks, one 'a;b;c count' line
+    /// per stack
+    Folded {
+        /// Write to this file instead of printing
+        #[arg(long, short = 'f')]
+        file: Option<PathBuf>,
+    },
+}
+
+#[derive(Subcommand)]
+pub enum CoverageCommands {
+    /// Put a one-time breakpoint on every line of code, dropping the
+    /// previous coverage
+    Start {
+        /// Only cover source files whose path contains this
+        #[arg(long)]
+        file: Option<String>,
+    },
+
+    /// Remove the breakpoints left, keeping what was covered
+    Stop,
+
+    /// Show which lines ran, per file
+    Report {
+        /// Output format
+        #[arg(long, value_enum, default_value_t = CoverageFormat::Text)]
+        format: CoverageFormat,
+
+        /// Write to this file instead of printing
+        #[arg(long, short = 'f')]
+        file: Option<PathBuf>,
+    },
+}
+
+#[derive(Subcommand)]
+pub enum OutputCommands {
+    /// Show the output line by line, each with the time it arrived and its
+    /// stream, and where the program stopped in between
+    Show {
+        /// Only the output since this point
+        #[arg(long, value_enum)]
+        since: Option<OutputSince>,
+
+        /// Show only the last N lines
+        #[arg(long)]
+        tail: Option<usize>,
+    },
+}
+
+/// Where `output show --since` starts
+#[derive(Debug, Clone, Copy, PartialEq, Eq, clap::ValueEnum)]
+pub enum OutputSince {
+    /// The latest stop
+    LastStop,
+}
+
+#[derive(Subcommand)]
+pub enum AnalyzeCommands {
+    /// Step from one location to another, counting how often each line and
+    /// function runs, and show the most run
+    Hotpath {
+        /// Where to start counting: file:line, function or @marker:NAME;
+        /// the program runs there first unless it is already there
+        #[arg(long)]
+        from: String,
+
+        /// Where to stop counting
+        #[arg(long)]
+        to: String,
+
+        /// Step over or into calls
+        #[arg(long, value_enum, default_value_t = StepMode::Into)]
+        step: StepMode,
+
+        /// Give up after this many steps
+        #[arg(long, default_value = "10000")]
+        max_steps: usize,
+
+        /// How many lines and functions to show
+        #[arg(long, default_value = "10")]
+        top: usize,
+
+        /// Seconds to wait for each step to stop
+        #[arg(long, default_value = "30")]
+        timeout: u64,
+    },
+}
+
+#[derive(Subcommand)]
+pub enum HeapCommands {
+    /// Read the Go runtime's heap statistics at the current stop
+    Snapshot,
+
+    /// Show the statistics that changed between two snapshots, by default
+    /// the last two
+    Diff {
+        /// Snapshot to compare from
+        from: Option<u32>,
+
+        /// Snapshot to compare to
+        to: Option<u32>,
+
+        /// How many changes to show
+        #[arg(long, default_value = "20")]
+        top: usize,
+    },
+}
+
+#[derive(Subcommand)]
+pub enum TimerCommands {
+    /// Time each way the program takes from one location to another while
+    /// it runs, dropping the previous timings
+    Between {
+        /// Where the clock starts: file:line, function or @marker:NAME
+        from: String,
+
+        /// Where it stops
+        to: String,
+    },
+
+    /// Remove the timer's breakpoints, keeping the timings
+    Stop,
+
+    /// Show the fastest, average and slowest way so far
+    Report,
+}
+
+#[derive(Subcommand)]
+pub enum CoreCommands {
+    /// Open a core dump, stopped where the program dumped core
+    Open {
+        /// Core dump file
+        core: PathBuf,
+
+        /// The program that dumped core
+        #[arg(long, short)]
+        program: PathBuf,
+
+        /// Debug adapter to use (default: lldb-dap)
+        #[arg(long)]
+        adapter: Option<String>,
+
+        /// Where a position-independent program was loaded, minus where it
+        /// was linked, for when the debugger can't tell from the core
+        #[arg(long, value_parser = crate::symbols::symbolicate::parse_address)]
+        slide: Option<u64>,
+    },
+
+    /// Compare the thread stacks, globals and heap statistics of two core
+    /// dumps of the same program
+    Diff {
+        /// Core dump of the run to compare from
+        first: PathBuf,
+
+        /// Core dump of the run to compare to
+        second: PathBuf,
+
+        /// The program that dumped both
+        #[arg(long, short)]
+        program: PathBuf,
+
+        /// Debug adapter to use (default: lldb-dap)
+        #[arg(long)]
+        adapter: Option<String>,
+
+        /// Also compare an expression's value where each dump stopped
+        /// (repeatable)
+        #[arg(long = "eval", value_name = "EXPR")]
+        expressions: Vec<String>,
+    },
+}
+
+#[derive(Subcommand)]
+pub enum BtraceCommands {
+    /// Have GDB record every branch the program takes, with Intel PT or BTS
+    Start {
+        /// How the CPU records branches
+        #[arg(long, value_enum, default_value_t = BtraceFormat::Pt)]
+        format: BtraceFormat,
+    },
+
+    /// Stop recording branches and drop the trace
+    Stop,
+
+    /// Show the recorded path to the current stop, a line per function
+    /// segment indented by call depth
+    List {
+        /// Show instructions instead of function segments
+        #[arg(long, short)]
+        instructions: bool,
+
+        /// How many of the last segments or instructions to show
+        #[arg(long, short = 'n', default_value = "30")]
+        count: u32,
+    },
+}
+
+#[derive(Subcommand)]
+pub enum InfoCommands {
+    /// Show how the process was laid out in memory
+    #[command(subcommand)]
+    Proc(ProcCommands),
+}
+
+#[derive(Subcommand)]
+pub enum ProcCommands {
+    /// Show where a position-independent program was loaded and how far that
+    /// is from where it was linked, the slide `symbolicate --slide` takes
+    Slide,
+}
+
+#[derive(Subcommand)]
+pub enum SymbolsCommands {
+    /// Read the program's symbols from FILE, as when a stripped program's
+    /// debug file is somewhere `debug-file-directory` doesn't name
+    Add {
+        /// Debug file, as `objcopy --only-keep-debug` or a debug info
+        /// package leaves it
+        file: PathBuf,
+    },
+}
+
+/// How `coverage report` writes the lines
+#[derive(Debug, Clone, Copy, PartialEq, Eq, clap::ValueEnum)]
+pub enum CoverageFormat {
+    /// Lines run per file, with the ranges that did not run
+    Text,
+    /// LCOV tracefile, for genhtml and coverage services
+    Lcov,
+}
+
+/// A step to replay, by number or relative to the current one
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct SeekTarget {
+    pub step: i64,
+    pub relative: bool,
+}
+
+/// Parse `42`, `+1` or `-3`
+pub fn parse_seek(text: &str) -> Result<SeekTarget, String> {
+    let relative = text.starts_with(['+', '-']);
+    text.trim_start_matches('+')
+        .parse()
+        .map(|step| SeekTarget { step, relative })
+        .map_err(|_| format!("'{}' is not a step number like 42, +1 or -3", text))
+}
+
+/// How `sample export` writes the values
+#[derive(Debug, Clone, Copy, PartialEq, Eq, clap::ValueEnum)]
+pub enum SampleFormat {
+    /// One row per value: elapsed_ms,time,id,expression,value,error
+    Csv,
+    /// The samples and their values as JSON
+    Json,
+}
+
+/// Parse an interval such as `100ms`, `2s` or `1m`; a bare number is
+/// milliseconds
+pub fn parse_interval(text: &str) -> Result<Duration, String> {
+    let (number, unit) = match text.find(|c: char| !c.is_ascii_digit() && c != '.') {
+        Some(at) => text.split_at(at),
+        None => (text, "ms"),
+    };
+    let millis = match unit {
+        "ms" => 1.0,
+        "s" => 1000.0,
+        "m" => 60_000.0,
+        _ => return Err(format!("unknown unit '{}'; use ms, s or m", unit)),
+    };
+    match number.parse::<f64>() {
+        Ok(n) if n * millis >= 1.0 => Ok(Duration::from_millis((n * millis) as u64)),
+        Ok(_) => Err("the interval must be at least 1ms".to_string()),
+        Err(_) => Err(format!("'{}' is not an interval like 100ms or 2s", text)),
+    }
+}
+
+#[derive(Subcommand)]
+pub enum TranscriptCommands {
+    /// Start recording to a file (appended to if it exists)
+    Start {
+        /// Transcript file
+        file: PathBuf,
+    },
+
+    /// Stop recording
+    Stop,
+
+    /// Add a note to the transcript
+    Annotate {
+        /// Note text
+        #[arg(required = true)]
+        note: Vec<String>,
+    },
+
+    /// Show whether a transcript is recording
+    Status,
+}
+
+#[derive(Subcommand)]
+pub enum ReportCommands {
+    /// Render a report of the session: stops, backtraces, locals, watches,
+    /// breakpoints and output
+    Generate {
+        /// Template to render, e.g. crash.md.tmpl [default: built-in Markdown,
+        /// or HTML for a FILE ending in .html]
+        #[arg(long)]
+        template: Option<PathBuf>,
+
+        /// Write the report here instead of printing it
+        file: Option<PathBuf>,
+
+        /// Evaluate an expression for the report (repeatable)
+        #[arg(long = "eval", value_name = "EXPR")]
+        expressions: Vec<String>,
+    },
+
+    /// Print the data templates are rendered with, as JSON
+    Data {
+        /// Evaluate an expression for the report (repeatable)
+        #[arg(long = "eval", value_name = "EXPR")]
+        expressions: Vec<String>,
+    },
+}
+
+#[derive(Subcommand)]
+pub enum RecordMacroCommands {
+    /// Start recording; every command that succeeds is added
+    Start {
+        /// Macro name: letters, digits, '-' and '_'
+        name: String,
+    },
+
+    /// Stop recording and save the macro
+    Stop,
+}
+
+#[derive(Subcommand)]
+pub enum DaemonCommands {
+    /// Start a headless daemon for the selected session
+    Start,
+
+    /// Shut down the selected session's daemon, ending its session
+    Stop,
+
+    /// List running session daemons
+    List,
+}
+
+#[derive(Subcommand)]
+pub enum SessionCommands {
+    /// Start a daemon for a new session and make it the current one for
+    /// the rest of the invocation
+    New {
+        /// Session name (letters, digits, '-' and '_')
+        name: String,
+    },
+
+    /// Make a running session the current one for the rest of the invocation
+    Switch {
+        /// Session name
+        name: String,
+    },
+
+    /// Run a command in several sessions at once, prefixing each line of
+    /// its output with the session's name
+    Broadcast {
+        /// Sessions to run it in, comma-separated [default: every running
+        /// session]
+        #[arg(long, value_delimiter = ',', value_name = "NAMES")]
+        to: Vec<String>,
+
+        /// The command and its arguments, e.g. 'continue' or 'print x'
+        #[arg(required = true, trailing_var_arg = true, allow_hyphen_values = true)]
+        command: Vec<String>,
+    },
+}
+
+#[derive(Subcommand)]
+pub enum MacroCommands {
+    /// Replay a macro's commands
+    Run {
+        /// Macro name
+        name: String,
+
+        /// Run the remaining commands after one fails (the macro still fails)
+        #[arg(long, conflicts_with = "stop_on_error")]
+        continue_on_error: bool,
+
+        /// Stop at the first failing command [default]
+        #[arg(long)]
+        stop_on_error: bool,
+
+        /// Define ${NAME} for the macro (repeatable)
+        #[arg(long = "define", short = 'D', value_name = "NAME=VALUE")]
+        definitions: Vec<String>,
+    },
+
+    /// List recorded macros
+    List,
+
+    /// Print a macro's commands
+    Show {
+        /// Macro name
+        name: String,
+    },
+
+    /// Delete a macro
+    Delete {
+        /// Macro name
+        name: String,
+    },
+}
+
+#[derive(Subcommand)]
+pub enum UserCommands {
+    /// Run a user-defined command (typing its name does the same)
+    Run {
+        /// Command name
+        name: String,
+
+        /// Arguments, available as ${arg0}, ${arg1}, ...
+        #[arg(trailing_var_arg = true, allow_hyphen_values = true)]
+        args: Vec<String>,
+    },
+
+    /// List user-defined commands
+    List,
+
+    /// Print a command's definition
+    Show {
+        /// Command name
+        name: String,
+    },
+
+    /// Delete a user-defined command
+    Delete {
+        /// Command name
+        name: String,
+    },
+}
+
 #[derive(Subcommand)]
 pub enum BreakpointCommands {
     /// Add a breakpoint
     Add {
-        /// Location: file:line or function name
+        /// Location: file:line, function name, @marker:NAME or *ADDRESS
         location: String,
 
         /// Condition for the breakpoint
@@ -1,8 +1,9 @@
 //! Configuration file handling
 
-use serde::Deserialize;
+use serde::{Deserialize, Serialize};
 use std::collections::HashMap;
 use std::path::PathBuf;
+use std::time::Duration;
 
 use super::paths::config_path;
 use super::Result;
@@ -22,6 +23,14 @@ pub struct Config {
     #[serde(default)]
     pub timeouts: Timeouts,
 
+    /// Retrying connections to the daemon
+    #[serde(default)]
+    pub retry: RetryConfig,
+
+    /// Interrupting a hung program in `--batch` and `--ci` runs
+    #[serde(default)]
+    pub watchdog: WatchdogConfig,
+
     /// Daemon settings
     #[serde(default)]
     pub daemon: DaemonConfig,
@@ -29,6 +38,19 @@ pub struct Config {
     /// Output buffer settings
     #[serde(default)]
     pub output: OutputConfig,
+
+    /// Text output appearance
+    #[serde(default)]
+    pub display: DisplayConfig,
+
+    /// Custom `context` layouts: name to panes, e.g. `["source:3", "stack"]`
+    #[serde(default)]
+    pub layouts: HashMap<String, Vec<String>>,
+
+    /// Handlers run when the program stops, hits a breakpoint, gets a signal
+    /// or exits, as with `on`
+    #[serde(default)]
+    pub events: Vec<EventConfig>,
 }
 
 /// Transport mode for debug adapter communication
@@ -107,6 +129,11 @@ pub struct Timeouts {
     /// Default timeout for await command
     #[serde(default = "default_await")]
     pub await_default_secs: u64,
+
+    /// Default for `set command-timeout`; 0 lets commands run as long as
+    /// they take
+    #[serde(default)]
+    pub command_secs: u64,
 }
 
 impl Default for Timeouts {
@@ -115,6 +142,7 @@ impl Default for Timeouts {
             dap_initialize_secs: default_dap_initialize(),
             dap_request_secs: default_dap_request(),
             await_default_secs: default_await(),
+            command_secs: 0,
         }
     }
 }
@@ -129,6 +157,91 @@ fn default_await() -> u64 {
     300
 }
 
+/// How often to try connecting to the daemon before giving up, for daemons
+/// slow to accept or sockets forwarded over a flaky link; a missing socket
+/// means no daemon is running and is not retried
+#[derive(Debug, Clone, Deserialize)]
+pub struct RetryConfig {
+    /// Connection attempts, including the first; 1 never retries
+    #[serde(default = "default_retry_attempts")]
+    pub attempts: u32,
+
+    /// Wait before the first retry, doubling after each one
+    #[serde(default = "default_retry_delay")]
+    pub delay_ms: u64,
+
+    /// Longest wait between two attempts
+    #[serde(default = "default_retry_max_delay")]
+    pub max_delay_ms: u64,
+}
+
+impl Default for RetryConfig {
+    fn default() -> Self {
+        Self {
+            attempts: default_retry_attempts(),
+            delay_ms: default_retry_delay(),
+            max_delay_ms: default_retry_max_delay(),
+        }
+    }
+}
+
+impl RetryConfig {
+    /// The wait before each retry, in order
+    pub fn delays(&self) -> impl Iterator<Item = Duration> + '_ {
+        let max = self.max_delay_ms.max(self.delay_ms);
+        (0..self.attempts.saturating_sub(1)).map(move |retry| {
+            let factor = 1u64.checked_shl(retry).unwrap_or(u64::MAX);
+            Duration::from_millis(self.delay_ms.saturating_mul(factor).min(max))
+        })
+    }
+}
+
+fn default_retry_attempts() -> u32 {
+    1
+}
+fn default_retry_delay() -> u64 {
+    100
+}
+fn default_retry_max_delay() -> u64 {
+    2000
+}
+
+/// Watchdog for `--batch` and `--ci` runs, overridden by `--watchdog` and
+/// `--watchdog-policy`
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct WatchdogConfig {
+    /// Seconds the program may run without an event before it is
+    /// interrupted; 0 turns the watchdog off
+    #[serde(default)]
+    pub idle_secs: u64,
+
+    /// What to do once every thread's backtrace is captured
+    #[serde(default)]
+    pub policy: WatchdogPolicy,
+}
+
+/// What the watchdog does with a hung program after capturing backtraces
+#[derive(
+    Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize, clap::ValueEnum,
+)]
+#[serde(rename_all = "lowercase")]
+pub enum WatchdogPolicy {
+    /// Let it run on; the watchdog fires again if it stays quiet
+    Resume,
+    /// End the session, failing the run
+    #[default]
+    Abort,
+}
+
+impl std::fmt::Display for WatchdogPolicy {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.write_str(match self {
+            Self::Resume => "resume",
+            Self::Abort => "abort",
+        })
+    }
+}
+
 /// Daemon configuration
 #[derive(Debug, Deserialize)]
 pub struct DaemonConfig {
@@ -177,6 +290,51 @@ fn default_max_bytes() -> usize {
     10
 }
 
+/// An `[[events]]` entry
+#[derive(Debug, Deserialize)]
+pub struct EventConfig {
+    /// The event as written after `on`: "stop", "exit", "breakpoint main",
+    /// "signal SIGSEGV"
+    pub on: String,
+
+    /// Debugger commands to run
+    #[serde(default)]
+    pub commands: Vec<String>,
+
+    /// Shell commands to run, with the event in `DEBUGGER_*` variables
+    #[serde(default)]
+    pub shell: Vec<String>,
+}
+
+/// Text output appearance
+#[derive(Debug, Deserialize)]
+pub struct DisplayConfig {
+    /// Color theme: "auto", a built-in name, or a theme file
+    #[serde(default = "default_theme")]
+    pub theme: String,
+
+    /// Page long output on a terminal (`set pagination` overrides)
+    #[serde(default = "default_pagination")]
+    pub pagination: bool,
+}
+
+impl Default for DisplayConfig {
+    fn default() -> Self {
+        Self {
+            theme: default_theme(),
+            pagination: default_pagination(),
+        }
+    }
+}
+
+fn default_theme() -> String {
+    "auto".to_string()
+}
+
+fn default_pagination() -> bool {
+    true
+}
+
 impl Config {
     /// Load configuration from the default config file
     ///
@@ -295,3 +453,20 @@ pub fn adapter_fallback_names(name: &str) -> Vec<String> {
         _ => vec![name.to_string()],
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn retry_delays_double_up_to_the_limit() {
+        let retry = RetryConfig {
+            attempts: 5,
+            delay_ms: 400,
+            max_delay_ms: 1000,
+        };
+        let delays: Vec<u64> = retry.delays().map(|d| d.as_millis() as u64).collect();
+        assert_eq!(delays, [400, 800, 1000, 1000]);
+        assert_eq!(RetryConfig::default().delays().count(), 0);
+    }
+}
@@ -16,6 +16,15 @@ pub enum Error {
     #[error("Daemon not running. Start a session with 'debugger start <program>'")]
     DaemonNotRunning,
 
+    #[error("No daemon is running session '{0}'. Start one with 'debugger --session {0} daemon start'")]
+    SessionDaemonNotRunning(String),
+
+    #[error("Session '{0}' is already running. Select it with 'debugger session switch {0}'")]
+    SessionExists(String),
+
+    #[error("Broadcast: {0}")]
+    Broadcast(String),
+
     #[error("Failed to spawn daemon: timed out waiting for socket after {0} seconds")]
     DaemonSpawnTimeout(u64),
 
@@ -55,6 +64,17 @@ pub enum Error {
     #[error("DAP protocol error: {0}")]
     DapProtocol(String),
 
+    #[error(
+        "The adapter's answer was {bytes} bytes, over the {limit} a message may be; \
+         print part of the value (an element, a slice or a field) instead"
+    )]
+    DapMessageTooLarge {
+        bytes: usize,
+        limit: usize,
+        /// The request it answered, if it could be found
+        request_seq: Option<i64>,
+    },
+
     #[error("DAP request '{command}' failed: {message}")]
     DapRequestFailed { command: String, message: String },
 
@@ -71,6 +91,22 @@ pub enum Error {
     #[error("Failed to set breakpoint at {location}: {reason}")]
     BreakpointFailed { location: String, reason: String },
 
+    #[error("Nothing to undo: no breakpoint has been removed, enabled or disabled")]
+    NothingToUndo,
+
+    #[error("Watch {id} not found")]
+    WatchNotFound { id: u32 },
+
+    #[error("Sample {id} not found")]
+    SampleNotFound { id: u32 },
+
+    #[error("Tracked expression {id} not found")]
+    TrackNotFound { id: u32 },
+
+    // === Symbol Errors ===
+    #[error("Cannot read symbols: {0}")]
+    Symbols(String),
+
     // === Execution Errors ===
     #[error("Cannot {action} while program is {state}")]
     InvalidState { action: String, state: String },
@@ -88,6 +124,10 @@ pub enum Error {
     #[error("Await timed out after {0} seconds. Program may still be running - use 'debugger status' to check")]
     AwaitTimeout(u64),
 
+    /// A timeout the daemon reported, with its message
+    #[error("{message}")]
+    TimedOut { message: String, secs: u64 },
+
     // === Configuration Errors ===
     #[error("Configuration error: {0}")]
     Config(String),
@@ -95,6 +135,71 @@ pub enum Error {
     #[error("Invalid configuration file: {0}")]
     ConfigParse(String),
 
+    #[error("Invalid setting: {0}")]
+    InvalidSetting(String),
+
+    #[error("{path}:{line}: {message}")]
+    Script {
+        path: String,
+        line: usize,
+        message: String,
+    },
+
+    // === Transcript Errors ===
+    #[error("Transcript: {0}")]
+    Transcript(String),
+
+    #[error("Macro: {0}")]
+    Macro(String),
+
+    #[error("Report: {0}")]
+    Report(String),
+
+    #[error("Trace: {0}")]
+    Trace(String),
+
+    #[error("Replay: {0}")]
+    Replay(String),
+
+    #[error("Profile: {0}")]
+    Profile(String),
+
+    #[error("Coverage: {0}")]
+    Coverage(String),
+
+    #[error("Analysis: {0}")]
+    Analysis(String),
+
+    #[error("Heap: {0}")]
+    Heap(String),
+
+    #[error("Timer: {0}")]
+    Timer(String),
+
+    #[error("Core dump: {0}")]
+    Core(String),
+
+    #[error("Branch trace: {0}")]
+    Btrace(String),
+
+    #[error("User command: {0}")]
+    UserCommand(String),
+
+    // === Editor Errors ===
+    #[error("Cannot open editor: {0}")]
+    Editor(String),
+
+    // === Scripting Errors ===
+    #[error("Python script failed: {0}")]
+    Python(String),
+
+    #[error("Assertion failed: expected {expected}, got {actual}")]
+    AssertionFailed { expected: String, actual: String },
+
+    // === Control API Errors ===
+    #[error("Control API: {0}")]
+    Api(String),
+
     // === IO Errors ===
     #[error("IO error: {0}")]
     Io(#[from] io::Error),
@@ -154,30 +259,67 @@ impl Error {
 pub struct IpcError {
     pub code: String,
     pub message: String,
+    /// The limit that was reached, for `TIMEOUT` errors
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub timeout_secs: Option<u64>,
 }
 
 impl From<&Error> for IpcError {
     fn from(e: &Error) -> Self {
         let code = match e {
-            Error::DaemonNotRunning => "DAEMON_NOT_RUNNING",
+            Error::DaemonNotRunning | Error::SessionDaemonNotRunning(_) => "DAEMON_NOT_RUNNING",
+            Error::SessionExists(_) => "SESSION_EXISTS",
+            Error::Broadcast(_) => "BROADCAST",
             Error::SessionNotActive => "SESSION_NOT_ACTIVE",
             Error::SessionAlreadyActive => "SESSION_ALREADY_ACTIVE",
             Error::AdapterNotFound { .. } => "ADAPTER_NOT_FOUND",
             Error::InvalidLocation(_) => "INVALID_LOCATION",
             Error::BreakpointNotFound { .. } => "BREAKPOINT_NOT_FOUND",
+            Error::NothingToUndo => "NOTHING_TO_UNDO",
+            Error::WatchNotFound { .. } => "WATCH_NOT_FOUND",
+            Error::SampleNotFound { .. } => "SAMPLE_NOT_FOUND",
+            Error::TrackNotFound { .. } => "TRACK_NOT_FOUND",
             Error::InvalidState { .. } => "INVALID_STATE",
             Error::ThreadNotFound(_) => "THREAD_NOT_FOUND",
             Error::FrameNotFound(_) => "FRAME_NOT_FOUND",
-            Error::Timeout(_) | Error::AwaitTimeout(_) => "TIMEOUT",
+            Error::Timeout(_) | Error::AwaitTimeout(_) | Error::TimedOut { .. } => "TIMEOUT",
             Error::ProgramExited(_) => "PROGRAM_EXITED",
             Error::DapRequestFailed { .. } => "DAP_REQUEST_FAILED",
+            Error::DapMessageTooLarge { .. } => "VALUE_TOO_LARGE",
+            Error::InvalidSetting(_) => "INVALID_SETTING",
+            Error::Symbols(_) => "SYMBOLS",
+            Error::Editor(_) => "EDITOR",
+            Error::Transcript(_) => "TRANSCRIPT",
+            Error::Macro(_) => "MACRO",
+            Error::Report(_) => "REPORT",
+            Error::Trace(_) => "TRACE",
+            Error::Replay(_) => "REPLAY",
+            Error::Profile(_) => "PROFILE",
+            Error::Coverage(_) => "COVERAGE",
+            Error::Analysis(_) => "ANALYSIS",
+            Error::Heap(_) => "HEAP",
+            Error::Timer(_) => "TIMER",
+            Error::Core(_) => "CORE",
+            Error::Btrace(_) => "BTRACE",
+            Error::UserCommand(_) => "USER_COMMAND",
+            Error::Python(_) => "PYTHON",
+            Error::AssertionFailed { .. } => "ASSERTION_FAILED",
+            Error::Api(_) => "API",
             _ => "INTERNAL_ERROR",
         }
         .to_string();
 
+        let timeout_secs = match e {
+            Error::Timeout(secs) | Error::AwaitTimeout(secs) | Error::TimedOut { secs, .. } => {
+                Some(*secs)
+            }
+            _ => None,
+        };
+
         Self {
             code,
             message: e.to_string(),
+            timeout_secs,
         }
     }
 }
@@ -188,7 +330,10 @@ impl From<IpcError> for Error {
         match e.code.as_str() {
             "SESSION_NOT_ACTIVE" => Error::SessionNotActive,
             "SESSION_ALREADY_ACTIVE" => Error::SessionAlreadyActive,
-            "TIMEOUT" => Error::Timeout(0),
+            "TIMEOUT" => Error::TimedOut {
+                secs: e.timeout_secs.unwrap_or(0),
+                message: e.message,
+            },
             _ => Error::DaemonCommunication(e.message),
         }
     }
@@ -38,7 +38,8 @@ pub fn init_cli() {
 /// Initialize tracing for the daemon (file + stderr logging)
 ///
 /// The daemon logs to both:
-/// 1. A log file at `~/.local/share/debugger-cli/logs/daemon.log`
+/// 1. A log file at `~/.local/share/debugger-cli/logs/daemon.log` (`daemon-<session>.log`
+///    for a named session)
 /// 2. stderr (inherited from spawning process for early errors)
 ///
 /// Log level controlled by `RUST_LOG`, default is TRACE for daemon to capture DAP messages.
@@ -52,7 +53,7 @@ pub fn init_daemon() -> Option<PathBuf> {
     let log_path = if let Some(log_dir) = paths::log_dir() {
         // Ensure log directory exists
         if std::fs::create_dir_all(&log_dir).is_ok() {
-            let log_file = log_dir.join("daemon.log");
+            let log_file = log_dir.join(paths::daemon_log_name());
 
             // Create or append to log file
             match std::fs::OpenOptions::new()
@@ -113,9 +114,9 @@ pub fn init_daemon() -> Option<PathBuf> {
     log_path
 }
 
-/// Get the path to the daemon log file
+/// Get the path to the daemon log file of the selected session
 pub fn daemon_log_path() -> Option<PathBuf> {
-    paths::log_dir().map(|d| d.join("daemon.log"))
+    paths::daemon_log_path()
 }
 
 /// Truncate the daemon log file (useful before debugging sessions)
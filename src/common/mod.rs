@@ -4,6 +4,8 @@ pub mod config;
 pub mod error;
 pub mod logging;
 pub mod paths;
+pub mod settings;
+pub mod time;
 
 pub use error::{Error, Result};
 
@@ -9,45 +9,140 @@ use std::path::PathBuf;
 /// Name used for the IPC socket/pipe
 const SOCKET_NAME: &str = "debugger-cli";
 
+/// Environment variable selecting a named session; `--session` sets it, and
+/// the daemons the CLI spawns inherit it
+pub const SESSION_VAR: &str = "DEBUGGER_SESSION";
+
+/// Session used when none is named
+pub const DEFAULT_SESSION: &str = "default";
+
+/// Prefix of named sessions' socket files
+#[cfg(unix)]
+const SESSION_PREFIX: &str = "session-";
+
+/// Whether a session name can be used in socket and log file names
+pub fn is_valid_session_name(name: &str) -> bool {
+    !name.is_empty()
+        && name.len() <= 64
+        && name
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || c == '-' || c == '_')
+}
+
+/// Select the session for this process and the daemons it spawns
+pub fn select_session(name: &str) -> crate::common::Result<()> {
+    if !is_valid_session_name(name) {
+        return Err(crate::common::Error::Config(format!(
+            "'{}' is not a valid session name; use letters, digits, '-' and '_'",
+            name
+        )));
+    }
+    std::env::set_var(SESSION_VAR, name);
+    Ok(())
+}
+
+/// The selected session: `$DEBUGGER_SESSION` if it is a valid name
+pub fn session_name() -> String {
+    std::env::var(SESSION_VAR)
+        .ok()
+        .filter(|name| is_valid_session_name(name))
+        .unwrap_or_else(|| DEFAULT_SESSION.to_string())
+}
+
 /// Get the socket/pipe path for IPC communication
 ///
 /// Platform-specific:
 /// - Unix: `$XDG_RUNTIME_DIR/debugger-cli/daemon.sock` or `/tmp/debugger-cli-<uid>/daemon.sock`
 /// - Windows: Named pipe path (handled by interprocess crate)
+///
+/// Named sessions use `session-<name>.sock` in the same directory.
 #[cfg(unix)]
 pub fn socket_path() -> PathBuf {
+    socket_path_for(&session_name())
+}
+
+/// Socket path for a given session
+#[cfg(unix)]
+pub fn socket_path_for(session: &str) -> PathBuf {
+    let file = if session == DEFAULT_SESSION {
+        "daemon.sock".to_string()
+    } else {
+        format!("{}{}.sock", SESSION_PREFIX, session)
+    };
+    socket_dir().join(file)
+}
+
+#[cfg(unix)]
+fn socket_dir() -> PathBuf {
     // Try XDG_RUNTIME_DIR first (preferred on Linux)
     if let Ok(runtime_dir) = std::env::var("XDG_RUNTIME_DIR") {
-        return PathBuf::from(runtime_dir)
-            .join(SOCKET_NAME)
-            .join("daemon.sock");
+        return PathBuf::from(runtime_dir).join(SOCKET_NAME);
     }
 
     // Fallback to /tmp with uid for security
     let uid = unsafe { libc::getuid() };
-    PathBuf::from(format!("/tmp/{}-{}", SOCKET_NAME, uid)).join("daemon.sock")
+    PathBuf::from(format!("/tmp/{}-{}", SOCKET_NAME, uid))
 }
 
 #[cfg(windows)]
 pub fn socket_path() -> PathBuf {
     // On Windows, we return a path that will be converted to a named pipe
     // The interprocess crate handles the \\.\pipe\ prefix
-    let username = std::env::var("USERNAME").unwrap_or_else(|_| "default".to_string());
-    PathBuf::from(format!("{}-{}", SOCKET_NAME, username))
+    PathBuf::from(socket_name())
 }
 
 /// Get the socket name for interprocess LocalSocketName
 ///
 /// Returns a string suitable for use with interprocess crate's local socket API
-#[cfg(unix)]
 pub fn socket_name() -> String {
-    socket_path().to_string_lossy().into_owned()
+    socket_name_for(&session_name())
+}
+
+/// Socket name for a given session
+#[cfg(unix)]
+pub fn socket_name_for(session: &str) -> String {
+    socket_path_for(session).to_string_lossy().into_owned()
 }
 
 #[cfg(windows)]
-pub fn socket_name() -> String {
+pub fn socket_name_for(session: &str) -> String {
     let username = std::env::var("USERNAME").unwrap_or_else(|_| "default".to_string());
-    format!("{}-{}", SOCKET_NAME, username)
+    if session == DEFAULT_SESSION {
+        format!("{}-{}", SOCKET_NAME, username)
+    } else {
+        format!("{}-{}-{}", SOCKET_NAME, username, session)
+    }
+}
+
+/// Sessions that have a socket, sorted, whether or not a daemon still
+/// answers on it
+#[cfg(unix)]
+pub fn session_names() -> Vec<String> {
+    let Ok(entries) = std::fs::read_dir(socket_dir()) else {
+        return Vec::new();
+    };
+    let mut names: Vec<String> = entries
+        .filter_map(|entry| entry.ok())
+        .filter_map(|entry| {
+            let file = entry.file_name().to_string_lossy().into_owned();
+            let stem = file.strip_suffix(".sock")?;
+            if stem == "daemon" {
+                return Some(DEFAULT_SESSION.to_string());
+            }
+            stem.strip_prefix(SESSION_PREFIX)
+                .filter(|name| is_valid_session_name(name))
+                .map(String::from)
+        })
+        .collect();
+    names.sort();
+    names
+}
+
+/// Named pipes cannot be listed portably, so only the selected session is
+/// reported
+#[cfg(windows)]
+pub fn session_names() -> Vec<String> {
+    vec![session_name()]
 }
 
 /// Ensure the socket directory exists with proper permissions
@@ -76,6 +171,18 @@ pub fn ensure_socket_dir() -> io::Result<PathBuf> {
     Ok(PathBuf::new())
 }
 
+/// File beside the socket where the daemon keeps what CLI invocations need
+/// to know before they run
+#[cfg(unix)]
+pub fn client_state_path() -> PathBuf {
+    socket_path().with_extension("state")
+}
+
+#[cfg(windows)]
+pub fn client_state_path() -> PathBuf {
+    std::env::temp_dir().join(format!("{}.state", socket_name()))
+}
+
 /// Remove the socket file if it exists (for cleanup)
 #[cfg(unix)]
 pub fn remove_socket() -> io::Result<()> {
@@ -108,12 +215,64 @@ pub fn config_path() -> Option<PathBuf> {
     config_dir().map(|dir| dir.join("config.toml"))
 }
 
+/// Get the path to the list of trusted project init files
+pub fn trusted_init_path() -> Option<PathBuf> {
+    config_dir().map(|dir| dir.join("trusted-init"))
+}
+
+/// Get the path to the `context` layout remembered for each project
+pub fn project_layouts_path() -> Option<PathBuf> {
+    config_dir().map(|dir| dir.join("project-layouts"))
+}
+
+/// Get the path to the `substitute-path` rules remembered for each project
+pub fn project_substitute_paths_path() -> Option<PathBuf> {
+    config_dir().map(|dir| dir.join("project-substitute-paths"))
+}
+
+/// Get the directory recorded macros are saved in
+pub fn macros_dir() -> Option<PathBuf> {
+    config_dir().map(|dir| dir.join("macros"))
+}
+
+/// Get the directory `define` saves user-defined commands in
+pub fn user_commands_dir() -> Option<PathBuf> {
+    config_dir().map(|dir| dir.join("commands"))
+}
+
+/// File name of the selected session's daemon log
+pub fn daemon_log_name() -> String {
+    let session = session_name();
+    if session == DEFAULT_SESSION {
+        "daemon.log".to_string()
+    } else {
+        format!("daemon-{}.log", session)
+    }
+}
+
+/// Get the path to the selected session's daemon log
+pub fn daemon_log_path() -> Option<PathBuf> {
+    log_dir().map(|dir| dir.join(daemon_log_name()))
+}
+
 /// Get the path to the log directory
 pub fn log_dir() -> Option<PathBuf> {
     directories::ProjectDirs::from("", "", SOCKET_NAME)
         .map(|dirs| dirs.data_dir().join("logs"))
 }
 
+/// Get the directory crash reports are written to
+pub fn crash_reports_dir() -> Option<PathBuf> {
+    directories::ProjectDirs::from("", "", SOCKET_NAME)
+        .map(|dirs| dirs.data_dir().join("crash-reports"))
+}
+
+/// Get the directory symbol indexes are kept in, by build ID
+pub fn symbol_cache_dir() -> Option<PathBuf> {
+    directories::ProjectDirs::from("", "", SOCKET_NAME)
+        .map(|dirs| dirs.cache_dir().join("symbols"))
+}
+
 /// Ensure the configuration directory exists
 pub fn ensure_config_dir() -> io::Result<Option<PathBuf>> {
     if let Some(dir) = config_dir() {
@@ -141,4 +300,18 @@ mod tests {
         let dir = config_dir();
         assert!(dir.is_some());
     }
+
+    #[test]
+    fn test_session_names_are_checked() {
+        assert!(is_valid_session_name("server-2"));
+        assert!(!is_valid_session_name(""));
+        assert!(!is_valid_session_name("../other"));
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_named_sessions_get_their_own_socket() {
+        assert!(socket_path_for(DEFAULT_SESSION).ends_with("daemon.sock"));
+        assert!(socket_path_for("server").ends_with("session-server.sock"));
+    }
 }
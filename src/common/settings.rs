@@ -0,0 +1,460 @@
+//! Debugger settings changed with `set` and shown with `show`
+//!
+//! The daemon owns the live values so a setting applies to every later CLI
+//! invocation, the way GDB's `set` lasts for the debugger's lifetime. Defaults
+//! come from the config file; per-project values belong in `.dbginit`.
+
+use std::path::{Path, PathBuf};
+
+use serde::{Deserialize, Serialize};
+
+use super::config::{Config, WatchdogPolicy};
+use super::{Error, Result};
+use crate::symbols::debug_file;
+
+/// Where `print-limit` starts: GDB's default `max-value-size`
+const DEFAULT_PRINT_LIMIT: usize = 64 * 1024;
+
+/// Current values of all settings
+///
+/// Serialized with the same kebab-case names `set` accepts.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "kebab-case")]
+pub struct Settings {
+    /// Page long output through `$PAGER`
+    pub pagination: bool,
+    /// Source path rewrites, applied in order
+    pub substitute_path: Vec<SubstitutePath>,
+    /// Lines shown on each side of the current line by `context`
+    pub listsize: usize,
+    /// Lines of source shown on each side of the stop location by `await`;
+    /// 0 shows none
+    pub stop_context: usize,
+    /// Show the stopped frame's function in the stop banner
+    pub stop_frame: bool,
+    /// Show the stopped frame's variables in the stop banner
+    pub stop_locals: bool,
+    /// Annotate source listings with the values of locals on each line
+    pub inline_values: bool,
+    /// Seconds the daemon gives each command before failing it with
+    /// `TIMEOUT`; 0 for no limit
+    pub command_timeout: u64,
+    /// Seconds the program may run without an event before the watchdog
+    /// interrupts it; 0 turns the watchdog off
+    pub watchdog: u64,
+    /// Whether the watchdog resumes the program or ends the session
+    pub watchdog_policy: WatchdogPolicy,
+    /// Write a triage report when the program crashes
+    pub crash_report: bool,
+    /// Bytes of a value `print`, `locals` and the like pass on before cutting
+    /// it short; 0 for no limit
+    pub print_limit: usize,
+    /// Directories separate debug files of stripped binaries are looked for
+    /// in, by build ID and by `.gnu_debuglink`
+    pub debug_file_directory: Vec<PathBuf>,
+}
+
+/// A source path rewrite rule: paths under `from` (as recorded in the debug
+/// info) are found under `to` on this machine
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct SubstitutePath {
+    pub from: PathBuf,
+    pub to: PathBuf,
+}
+
+impl Default for Settings {
+    fn default() -> Self {
+        Self::from_config(&Config::default())
+    }
+}
+
+impl Settings {
+    /// Setting names accepted by `set` and `show`
+    pub const NAMES: &'static [&'static str] = &[
+        "pagination",
+        "substitute-path",
+        "listsize",
+        "stop-context",
+        "stop-frame",
+        "stop-locals",
+        "inline-values",
+        "command-timeout",
+        "watchdog",
+        "watchdog-policy",
+        "crash-report",
+        "print-limit",
+        "debug-file-directory",
+    ];
+
+    /// Settings as configured in the config file
+    pub fn from_config(config: &Config) -> Self {
+        Self {
+            pagination: config.display.pagination,
+            substitute_path: Vec::new(),
+            listsize: 5,
+            stop_context: 0,
+            stop_frame: false,
+            stop_locals: false,
+            inline_values: false,
+            command_timeout: config.timeouts.command_secs,
+            // `[watchdog] idle_secs` arms it only for batch runs
+            watchdog: 0,
+            watchdog_policy: config.watchdog.policy,
+            crash_report: false,
+            print_limit: DEFAULT_PRINT_LIMIT,
+            debug_file_directory: vec![PathBuf::from(debug_file::DEFAULT_DIRECTORY)],
+        }
+    }
+
+    /// Change a setting from its `set` arguments
+    pub fn set(&mut self, name: &str, args: &[String]) -> Result<()> {
+        match name {
+            "pagination" => self.pagination = parse_bool(name, args)?,
+            "substitute-path" => self.set_substitute_path(args)?,
+            "listsize" => self.listsize = parse_count(name, args)?,
+            "stop-context" => self.stop_context = parse_count(name, args)?,
+            "stop-frame" => self.stop_frame = parse_bool(name, args)?,
+            "stop-locals" => self.stop_locals = parse_bool(name, args)?,
+            "inline-values" => self.inline_values = parse_bool(name, args)?,
+            "command-timeout" => self.command_timeout = parse_seconds(name, args)?,
+            "watchdog" => self.watchdog = parse_seconds(name, args)?,
+            "watchdog-policy" => self.watchdog_policy = parse_policy(name, args)?,
+            "crash-report" => self.crash_report = parse_bool(name, args)?,
+            "print-limit" => self.print_limit = parse_limit(name, args)?,
+            "debug-file-directory" => self.debug_file_directory = parse_directories(name, args)?,
+            _ => return Err(unknown_setting(name)),
+        }
+        Ok(())
+    }
+
+    /// Render one setting (or all of them) as name/value pairs for `show`
+    pub fn show(&self, name: Option<&str>) -> Result<Vec<(&'static str, String)>> {
+        let names: Vec<&'static str> = match name {
+            Some(name) => vec![Self::NAMES
+                .iter()
+                .copied()
+                .find(|known| *known == name)
+                .ok_or_else(|| unknown_setting(name))?],
+            None => Self::NAMES.to_vec(),
+        };
+
+        Ok(names
+            .into_iter()
+            .map(|name| (name, self.render(name)))
+            .collect())
+    }
+
+    fn render(&self, name: &str) -> String {
+        match name {
+            "pagination" => on_off(self.pagination),
+            "substitute-path" if self.substitute_path.is_empty() => "none".to_string(),
+            "substitute-path" => self
+                .substitute_path
+                .iter()
+                .map(|rule| format!("{} -> {}", rule.from.display(), rule.to.display()))
+                .collect::<Vec<_>>()
+                .join(", "),
+            "listsize" => self.listsize.to_string(),
+            "stop-context" => self.stop_context.to_string(),
+            "stop-frame" => on_off(self.stop_frame),
+            "stop-locals" => on_off(self.stop_locals),
+            "inline-values" => on_off(self.inline_values),
+            "command-timeout" if self.command_timeout == 0 => "none".to_string(),
+            "command-timeout" => format!("{}s", self.command_timeout),
+            "watchdog" if self.watchdog == 0 => "none".to_string(),
+            "watchdog" => format!("{}s", self.watchdog),
+            "watchdog-policy" => self.watchdog_policy.to_string(),
+            "crash-report" => on_off(self.crash_report),
+            "print-limit" if self.print_limit == 0 => "none".to_string(),
+            "print-limit" => format_bytes(self.print_limit),
+            "debug-file-directory" if self.debug_file_directory.is_empty() => "none".to_string(),
+            "debug-file-directory" => std::env::join_paths(&self.debug_file_directory)
+                .map(|paths| paths.to_string_lossy().into_owned())
+                .unwrap_or_default(),
+            _ => String::new(),
+        }
+    }
+
+    /// `set substitute-path FROM TO` adds a rule, replacing any rule for the
+    /// same FROM as GDB does; `set substitute-path FROM` removes it
+    fn set_substitute_path(&mut self, args: &[String]) -> Result<()> {
+        match args {
+            [from, to] => {
+                let rule = SubstitutePath {
+                    from: PathBuf::from(from),
+                    to: PathBuf::from(to),
+                };
+                match self.substitute_path.iter_mut().find(|r| r.from == rule.from) {
+                    Some(existing) => *existing = rule,
+                    None => self.substitute_path.push(rule),
+                }
+                Ok(())
+            }
+            [from] => {
+                let before = self.substitute_path.len();
+                self.substitute_path.retain(|rule| rule.from != Path::new(from));
+                if self.substitute_path.len() == before {
+                    Err(Error::InvalidSetting(format!(
+                        "no substitute-path rule for '{}'",
+                        from
+                    )))
+                } else {
+                    Ok(())
+                }
+            }
+            _ => Err(Error::InvalidSetting(
+                "usage: set substitute-path FROM [TO]".to_string(),
+            )),
+        }
+    }
+
+    /// Map a path reported by the debug adapter to the local source tree
+    pub fn local_path(&self, path: &str) -> String {
+        self.substitute_path
+            .iter()
+            .find_map(|rule| rewrite(Path::new(path), &rule.from, &rule.to))
+            .map(|path| path.to_string_lossy().into_owned())
+            .unwrap_or_else(|| path.to_string())
+    }
+
+    /// Map a local source path back to the path the debug info records, so
+    /// breakpoints set in the local tree reach the adapter
+    pub fn remote_path(&self, path: &Path) -> PathBuf {
+        self.substitute_path
+            .iter()
+            .find_map(|rule| rewrite(path, &rule.to, &rule.from))
+            .unwrap_or_else(|| path.to_path_buf())
+    }
+}
+
+/// Replace the `from` prefix of `path` with `to`, matching whole components
+/// so `/build/src` does not rewrite `/build/srcs`
+fn rewrite(path: &Path, from: &Path, to: &Path) -> Option<PathBuf> {
+    let rest = path.strip_prefix(from).ok()?;
+    if rest.as_os_str().is_empty() {
+        Some(to.to_path_buf())
+    } else {
+        Some(to.join(rest))
+    }
+}
+
+fn unknown_setting(name: &str) -> Error {
+    Error::InvalidSetting(format!(
+        "unknown setting '{}'. Settings: {}",
+        name,
+        Settings::NAMES.join(", ")
+    ))
+}
+
+fn parse_bool(name: &str, args: &[String]) -> Result<bool> {
+    match args {
+        [value] => match value.to_ascii_lowercase().as_str() {
+            "on" | "true" | "yes" | "1" => Ok(true),
+            "off" | "false" | "no" | "0" => Ok(false),
+            _ => Err(Error::InvalidSetting(format!(
+                "{} expects on or off, got '{}'",
+                name, value
+            ))),
+        },
+        _ => Err(Error::InvalidSetting(format!("usage: set {} on|off", name))),
+    }
+}
+
+fn parse_count(name: &str, args: &[String]) -> Result<usize> {
+    match args {
+        [value] => value.parse().map_err(|_| {
+            Error::InvalidSetting(format!(
+                "{} expects a number of lines, got '{}'",
+                name, value
+            ))
+        }),
+        _ => Err(Error::InvalidSetting(format!("usage: set {} N", name))),
+    }
+}
+
+/// A number of seconds; `none` or `off` for no limit
+fn parse_seconds(name: &str, args: &[String]) -> Result<u64> {
+    match args {
+        [value] if matches!(value.as_str(), "none" | "off") => Ok(0),
+        [value] => value.trim_end_matches('s').parse().map_err(|_| {
+            Error::InvalidSetting(format!(
+                "{} expects a number of seconds, got '{}'",
+                name, value
+            ))
+        }),
+        _ => Err(Error::InvalidSetting(format!("usage: set {} SECONDS|none", name))),
+    }
+}
+
+fn parse_limit(name: &str, args: &[String]) -> Result<usize> {
+    match args {
+        [value] if matches!(value.as_str(), "none" | "off") => Ok(0),
+        [value] => {
+            parse_bytes(value).map_err(|e| Error::InvalidSetting(format!("{}: {}", name, e)))
+        }
+        _ => Err(Error::InvalidSetting(format!("usage: set {} BYTES|none", name))),
+    }
+}
+
+/// A size in bytes, like `4096`, `64K`, `16M` or `1G`
+pub fn parse_bytes(value: &str) -> std::result::Result<usize, String> {
+    let (digits, scale) = match value.trim().to_ascii_uppercase() {
+        upper if upper.ends_with('K') => (upper.trim_end_matches('K').to_string(), 1 << 10),
+        upper if upper.ends_with('M') => (upper.trim_end_matches('M').to_string(), 1 << 20),
+        upper if upper.ends_with('G') => (upper.trim_end_matches('G').to_string(), 1 << 30),
+        upper => (upper, 1),
+    };
+    digits
+        .parse::<usize>()
+        .ok()
+        .and_then(|count| count.checked_mul(scale))
+        .ok_or_else(|| format!("expected a size like 4096, 64K or 16M, got '{}'", value))
+}
+
+/// A size as `parse_bytes` reads it, in the largest unit that divides it
+pub fn format_bytes(bytes: usize) -> String {
+    match bytes {
+        0 => "0".to_string(),
+        _ if bytes.trailing_zeros() >= 30 => format!("{}G", bytes >> 30),
+        _ if bytes.trailing_zeros() >= 20 => format!("{}M", bytes >> 20),
+        _ if bytes.trailing_zeros() >= 10 => format!("{}K", bytes >> 10),
+        _ => bytes.to_string(),
+    }
+}
+
+/// Directories separated by `:` as GDB takes them, or given one by one;
+/// `none` for no directories
+fn parse_directories(name: &str, args: &[String]) -> Result<Vec<PathBuf>> {
+    match args {
+        [] => Err(Error::InvalidSetting(format!("usage: set {} DIR[:DIR...]|none", name))),
+        [value] if value == "none" => Ok(Vec::new()),
+        _ => Ok(args
+            .iter()
+            .flat_map(std::env::split_paths)
+            .filter(|path| !path.as_os_str().is_empty())
+            .collect()),
+    }
+}
+
+fn parse_policy(name: &str, args: &[String]) -> Result<WatchdogPolicy> {
+    match args {
+        [value] if value == "resume" => Ok(WatchdogPolicy::Resume),
+        [value] if value == "abort" => Ok(WatchdogPolicy::Abort),
+        _ => Err(Error::InvalidSetting(format!("usage: set {} resume|abort", name))),
+    }
+}
+
+fn on_off(value: bool) -> String {
+    if value { "on" } else { "off" }.to_string()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn set_parses_booleans() {
+        let mut settings = Settings::default();
+        assert!(settings.pagination);
+
+        settings.set("pagination", &["off".to_string()]).unwrap();
+        assert!(!settings.pagination);
+        assert_eq!(settings.show(Some("pagination")).unwrap()[0].1, "off");
+
+        assert!(settings.set("pagination", &["maybe".to_string()]).is_err());
+        assert!(settings.set("pagination", &[]).is_err());
+        assert!(settings.set("colour", &["on".to_string()]).is_err());
+
+        settings.set("stop-context", &["3".to_string()]).unwrap();
+        assert_eq!(settings.stop_context, 3);
+        assert!(settings.set("listsize", &["-1".to_string()]).is_err());
+
+        settings.set("command-timeout", &["30s".to_string()]).unwrap();
+        assert_eq!(settings.command_timeout, 30);
+        assert_eq!(settings.show(Some("command-timeout")).unwrap()[0].1, "30s");
+        settings.set("command-timeout", &["none".to_string()]).unwrap();
+        assert_eq!(settings.show(Some("command-timeout")).unwrap()[0].1, "none");
+
+        settings.set("watchdog-policy", &["resume".to_string()]).unwrap();
+        assert_eq!(settings.watchdog_policy, WatchdogPolicy::Resume);
+        assert!(settings.set("watchdog-policy", &["retry".to_string()]).is_err());
+
+        assert_eq!(settings.show(Some("print-limit")).unwrap()[0].1, "64K");
+        settings.set("print-limit", &["16m".to_string()]).unwrap();
+        assert_eq!(settings.print_limit, 16 << 20);
+        settings.set("print-limit", &["1000".to_string()]).unwrap();
+        assert_eq!(settings.show(Some("print-limit")).unwrap()[0].1, "1000");
+        settings.set("print-limit", &["none".to_string()]).unwrap();
+        assert_eq!(settings.show(Some("print-limit")).unwrap()[0].1, "none");
+        assert!(settings.set("print-limit", &["lots".to_string()]).is_err());
+
+        assert_eq!(settings.show(Some("debug-file-directory")).unwrap()[0].1, "/usr/lib/debug");
+        let directories = ["/opt/debug:/usr/lib/debug".to_string(), "/srv/debug".to_string()];
+        settings.set("debug-file-directory", &directories).unwrap();
+        assert_eq!(
+            settings.debug_file_directory,
+            [
+                PathBuf::from("/opt/debug"),
+                PathBuf::from("/usr/lib/debug"),
+                PathBuf::from("/srv/debug")
+            ]
+        );
+        assert_eq!(
+            settings.show(Some("debug-file-directory")).unwrap()[0].1,
+            "/opt/debug:/usr/lib/debug:/srv/debug"
+        );
+        settings.set("debug-file-directory", &["none".to_string()]).unwrap();
+        assert_eq!(settings.show(Some("debug-file-directory")).unwrap()[0].1, "none");
+        assert!(settings.set("debug-file-directory", &[]).is_err());
+    }
+
+    #[test]
+    fn line_counts_are_whole_numbers() {
+        let mut settings = Settings::default();
+        assert_eq!((settings.listsize, settings.stop_context), (5, 0));
+
+        for name in ["listsize", "stop-context"] {
+            settings.set(name, &["0".to_string()]).unwrap();
+            assert_eq!(settings.show(Some(name)).unwrap()[0].1, "0");
+            settings.set(name, &["12".to_string()]).unwrap();
+            assert_eq!(settings.show(Some(name)).unwrap()[0].1, "12");
+
+            for value in ["-1", "2.5", "ten", "", "99999999999999999999"] {
+                assert!(settings.set(name, &[value.to_string()]).is_err(), "{} {}", name, value);
+            }
+            assert!(settings.set(name, &[]).is_err());
+            assert!(settings.set(name, &["1".to_string(), "2".to_string()]).is_err());
+            assert_eq!(settings.show(Some(name)).unwrap()[0].1, "12");
+        }
+        assert_eq!((settings.listsize, settings.stop_context), (12, 12));
+    }
+
+    #[test]
+    fn substitute_path_rewrites_whole_components() {
+        let mut settings = Settings::default();
+        let args = |args: &[&str]| args.iter().map(|a| a.to_string()).collect::<Vec<_>>();
+        settings
+            .set("substitute-path", &args(&["/build/src", "/home/me/project"]))
+            .unwrap();
+
+        assert_eq!(
+            settings.local_path("/build/src/lib/main.c"),
+            "/home/me/project/lib/main.c"
+        );
+        assert_eq!(settings.local_path("/build/srcs/main.c"), "/build/srcs/main.c");
+        assert_eq!(
+            settings.remote_path(Path::new("/home/me/project/main.c")),
+            PathBuf::from("/build/src/main.c")
+        );
+
+        settings
+            .set("substitute-path", &args(&["/build/src", "/srv/checkout"]))
+            .unwrap();
+        assert_eq!(settings.substitute_path.len(), 1);
+        assert_eq!(settings.local_path("/build/src/a.c"), "/srv/checkout/a.c");
+
+        settings.set("substitute-path", &args(&["/build/src"])).unwrap();
+        assert!(settings.substitute_path.is_empty());
+        assert!(settings.set("substitute-path", &args(&["/build/src"])).is_err());
+    }
+}
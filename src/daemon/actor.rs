@@ -12,11 +12,30 @@ use std::time::Duration;
 use tokio::sync::{mpsc, oneshot, watch};
 
 use crate::common::config::Config;
-use crate::dap::StoppedEventBody;
-use crate::ipc::protocol::{Command, Response};
+use crate::common::settings::Settings;
+use crate::common::error::IpcError;
+use crate::common::{Error, Result};
+use crate::dap::{Event, StoppedEventBody};
+use crate::ipc::protocol::{Command, CrashSummary, Response, WatchdogFiring};
+use crate::ipc::state::ClientState;
 
+use super::coverage::Coverage;
+use super::crash;
 use super::handler;
+use super::heap::Heap;
+use super::hooks::Hooks;
+use super::modules;
+use super::profile::Profiler;
+use super::relocation;
+use super::replay::Recorder;
+use super::samples::Samples;
 use super::session::{DebugSession, SessionState};
+use super::timer::Timer;
+use super::trace::Tracer;
+use super::tracks::Tracks;
+use super::transcript::Transcript;
+use super::watchdog;
+use super::watches::Watches;
 
 /// How often the actor reduces DAP events when no commands arrive.
 const EVENT_TICK: Duration = Duration::from_millis(100);
@@ -25,6 +44,8 @@ const EVENT_TICK: Duration = Duration::from_millis(100);
 pub struct ActorRequest {
     pub id: u64,
     pub command: Command,
+    /// The client's limit for this command, overriding `command-timeout`
+    pub timeout_secs: Option<u64>,
     pub reply: oneshot::Sender<Response>,
 }
 
@@ -39,6 +60,9 @@ pub struct SessionSnapshot {
     pub stopped_reason: Option<String>,
     pub stopped_thread: Option<i64>,
     pub exit_code: Option<i32>,
+    pub crash_report: Option<CrashSummary>,
+    /// Tracked containers that keep growing
+    pub warnings: Vec<String>,
 }
 
 /// Run the session actor until every request sender is dropped.
@@ -51,24 +75,198 @@ pub async fn run(
     snapshots: watch::Sender<SessionSnapshot>,
 ) {
     let mut session: Option<DebugSession> = None;
+    // Hooks, settings and watches belong to the daemon rather than the session so they
+    // carry over when a session is stopped and a new one started.
+    let mut hooks = Hooks::from_config(&config);
+    let mut settings = Settings::from_config(&config);
+    let mut watches = Watches::default();
+    let mut transcript: Option<Transcript> = None;
+    let mut recording_macro: Option<MacroRecording> = None;
+    let mut firings: Vec<WatchdogFiring> = Vec::new();
+    let mut samples = Samples::default();
+    let mut tracks = Tracks::default();
+    let mut tracer = Tracer::default();
+    let mut recorder = Recorder::default();
+    let mut profiler = Profiler::default();
+    let mut coverage = Coverage::default();
+    let mut timer = Timer::default();
+    let mut heap = Heap::default();
     let mut tick = tokio::time::interval(EVENT_TICK);
     tick.set_missed_tick_behavior(tokio::time::MissedTickBehavior::Skip);
+    let mut shared = None;
+    share(&mut shared, &transcript, &recording_macro, &hooks, &settings);
 
     loop {
+        let profile_due = profiler.due();
+        let timing = timer.is_active();
         tokio::select! {
             request = requests.recv() => {
-                let Some(ActorRequest { id, command, reply }) = request else {
+                let Some(ActorRequest { id, command, timeout_secs, reply }) = request else {
                     break;
                 };
 
-                reduce_events(&mut session).await;
-                let response = handler::handle_command(&mut session, &config, id, command).await;
-                publish(&snapshots, &session);
+                reduce_events(&mut session, &mut transcript).await;
+                relocation::rebase(&mut session).await;
+                record_output(&mut transcript, tracer.resolve(&mut session).await);
+                record_output(&mut transcript, recorder.advance(&mut session, &settings).await);
+                record_output(&mut transcript, coverage.resolve(&mut session).await);
+                record_output(&mut transcript, timer.resolve(&mut session).await);
+                let response = match command {
+                    Command::TranscriptStart { .. }
+                    | Command::TranscriptStop
+                    | Command::TranscriptStatus
+                    | Command::TranscriptAnnotate { .. }
+                    | Command::TranscriptRecord { .. }
+                    | Command::RecordingStatus => {
+                        match handle_transcript(&mut transcript, &recording_macro, command) {
+                            Ok(result) => Response::success(id, result),
+                            Err(e) => Response::error(id, IpcError::from(&e)),
+                        }
+                    }
+                    Command::WatchdogFirings => Response::success(
+                        id,
+                        serde_json::json!({ "firings": std::mem::take(&mut firings) }),
+                    ),
+                    Command::MacroStart { .. }
+                    | Command::MacroStop
+                    | Command::MacroRecord { .. } => {
+                        match handle_macro(&mut recording_macro, command) {
+                            Ok(result) => Response::success(id, result),
+                            Err(e) => Response::error(id, IpcError::from(&e)),
+                        }
+                    }
+                    Command::SampleAdd { .. }
+                    | Command::SampleRemove { .. }
+                    | Command::SampleList
+                    | Command::SampleExport
+                    | Command::SampleClear => match handle_samples(&mut samples, command) {
+                        Ok(result) => Response::success(id, result),
+                        Err(e) => Response::error(id, IpcError::from(&e)),
+                    },
+                    Command::TrackAdd { .. }
+                    | Command::TrackRemove { .. }
+                    | Command::TrackList
+                    | Command::TrackHistory { .. } => match handle_tracks(&mut tracks, command) {
+                        Ok(result) => Response::success(id, result),
+                        Err(e) => Response::error(id, IpcError::from(&e)),
+                    },
+                    Command::TraceFunctions { .. }
+                    | Command::TraceSyscalls { .. }
+                    | Command::TraceLog { .. }
+                    | Command::TraceStop
+                    | Command::TraceClear => {
+                        match handle_trace(&mut tracer, &mut session, command).await {
+                            Ok(result) => Response::success(id, result),
+                            Err(e) => Response::error(id, IpcError::from(&e)),
+                        }
+                    }
+                    Command::RecordStart { .. }
+                    | Command::RecordStop
+                    | Command::ReplayList
+                    | Command::ReplaySeek { .. }
+                    | Command::ReplayExit => {
+                        match handle_replay(&mut recorder, &mut session, &settings, command).await {
+                            Ok(result) => Response::success(id, result),
+                            Err(e) => Response::error(id, IpcError::from(&e)),
+                        }
+                    }
+                    Command::ProfileStart { .. }
+                    | Command::ProfileStop
+                    | Command::ProfileData => match handle_profile(&mut profiler, command) {
+                        Ok(result) => Response::success(id, result),
+                        Err(e) => Response::error(id, IpcError::from(&e)),
+                    },
+                    Command::CoverageStart { .. }
+                    | Command::CoverageStop
+                    | Command::CoverageData => {
+                        match handle_coverage(&mut coverage, &mut session, &settings, command).await
+                        {
+                            Ok(result) => Response::success(id, result),
+                            Err(e) => Response::error(id, IpcError::from(&e)),
+                        }
+                    }
+                    Command::TimerStart { .. } | Command::TimerStop | Command::TimerData => {
+                        match handle_timer(&mut timer, &mut session, &settings, command).await {
+                            Ok(result) => Response::success(id, result),
+                            Err(e) => Response::error(id, IpcError::from(&e)),
+                        }
+                    }
+                    Command::HeapSnapshot | Command::HeapDiff { .. } => {
+                        match handle_heap(&mut heap, &mut session, command).await {
+                            Ok(result) => Response::success(id, result),
+                            Err(e) => Response::error(id, IpcError::from(&e)),
+                        }
+                    }
+                    command => match recorder.answer(&command) {
+                        Some(Ok(result)) => Response::success(id, result),
+                        Some(Err(e)) => Response::error(id, IpcError::from(&e)),
+                        None => {
+                            let limit = timeout_secs.unwrap_or(settings.command_timeout);
+                            let handled = handler::handle_command(
+                                &mut session,
+                                &mut hooks,
+                                &mut settings,
+                                &mut watches,
+                                &config,
+                                id,
+                                command,
+                            );
+                            if limit == 0 {
+                                handled.await
+                            } else {
+                                // Dropping the handler abandons its DAP request; a late
+                                // response finds nobody waiting and is discarded
+                                tokio::time::timeout(Duration::from_secs(limit), handled)
+                                    .await
+                                    .unwrap_or_else(|_| {
+                                        Response::error(id, IpcError::from(&Error::Timeout(limit)))
+                                    })
+                            }
+                        }
+                    },
+                };
+                crash::check(&mut session, &settings).await;
+                tracks.at_stop(&mut session).await;
+                record_stops(&mut transcript, &session);
+                publish(&snapshots, &session, &tracks);
+                share(&mut shared, &transcript, &recording_macro, &hooks, &settings);
                 let _ = reply.send(response);
             }
             _ = tick.tick() => {
-                reduce_events(&mut session).await;
-                publish(&snapshots, &session);
+                reduce_events(&mut session, &mut transcript).await;
+                relocation::rebase(&mut session).await;
+                record_output(&mut transcript, tracer.resolve(&mut session).await);
+                record_output(&mut transcript, recorder.advance(&mut session, &settings).await);
+                record_output(&mut transcript, coverage.resolve(&mut session).await);
+                record_output(&mut transcript, timer.resolve(&mut session).await);
+                if let Some(firing) = watchdog::check(&mut session, &settings).await {
+                    firings.push(firing);
+                }
+                samples.sample(&mut session).await;
+                tracks.sample(&mut session).await;
+                modules::load_next(&mut session).await;
+                crash::check(&mut session, &settings).await;
+                tracks.at_stop(&mut session).await;
+                record_stops(&mut transcript, &session);
+                publish(&snapshots, &session, &tracks);
+            }
+            event = next_event(&mut session), if timing => {
+                // The timer's clock stops when the stop arrives, not at the tick
+                record_output(&mut transcript, event.into_iter().collect());
+                record_output(&mut transcript, timer.resolve(&mut session).await);
+                crash::check(&mut session, &settings).await;
+                tracks.at_stop(&mut session).await;
+                record_stops(&mut transcript, &session);
+                publish(&snapshots, &session, &tracks);
+            }
+            _ = sleep_until(profile_due) => {
+                // Sampling stacks needs a quicker beat than the tick's
+                reduce_events(&mut session, &mut transcript).await;
+                profiler.sample(&mut session).await;
+                crash::check(&mut session, &settings).await;
+                tracks.at_stop(&mut session).await;
+                record_stops(&mut transcript, &session);
+                publish(&snapshots, &session, &tracks);
             }
         }
     }
@@ -77,17 +275,390 @@ pub async fn run(
     if let Some(mut active) = session.take() {
         let _ = active.stop().await;
     }
+    if let Some(transcript) = transcript.take() {
+        transcript.stop();
+    }
+    ClientState::remove();
+}
+
+async fn reduce_events(session: &mut Option<DebugSession>, transcript: &mut Option<Transcript>) {
+    let Some(active) = session.as_mut() else {
+        return;
+    };
+
+    match active.process_events().await {
+        Ok(events) => record_output(transcript, events),
+        Err(e) => tracing::warn!("Error processing events: {}", e),
+    }
+}
+
+/// Add the stops the user sees to the transcript, after the output before them
+fn record_stops(transcript: &mut Option<Transcript>, session: &Option<DebugSession>) {
+    if let (Some(transcript), Some(sess)) = (transcript.as_mut(), session.as_ref()) {
+        transcript.record_stops(sess.stop_count(), sess.stops());
+    }
+}
+
+/// Copy the program's output among `events` to the transcript
+fn record_output(transcript: &mut Option<Transcript>, events: Vec<Event>) {
+    let Some(transcript) = transcript.as_mut() else {
+        return;
+    };
+    for event in events {
+        if let Event::Output(body) = event {
+            let category = body.category.as_deref().unwrap_or("console");
+            if matches!(category, "stdout" | "stderr") {
+                transcript.record_output(category, &body.output);
+            }
+        }
+    }
+}
+
+async fn handle_trace(
+    tracer: &mut Tracer,
+    session: &mut Option<DebugSession>,
+    command: Command,
+) -> Result<serde_json::Value> {
+    match command {
+        Command::TraceFunctions { pattern } => {
+            let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
+            let (functions, breakpoints) = tracer.add(sess, &pattern).await?;
+            Ok(serde_json::json!({
+                "pattern": pattern,
+                "functions": functions,
+                "breakpoints": breakpoints,
+            }))
+        }
+        Command::TraceSyscalls { filter } => {
+            let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
+            let returns = tracer.add_syscalls(sess, filter.as_deref()).await?;
+            Ok(serde_json::json!({ "filter": filter, "returns": returns }))
+        }
+        Command::TraceLog { since } => Ok(serde_json::json!({
+            "entries": tracer.entries(since),
+            "last": tracer.last_seq(),
+            "running": session
+                .as_ref()
+                .is_some_and(|sess| sess.state() != SessionState::Exited),
+        })),
+        Command::TraceStop => {
+            let functions = tracer.stop(session).await?;
+            let syscalls = tracer.stop_syscalls(session.as_mut()).await?;
+            Ok(serde_json::json!({ "functions": functions, "syscalls": syscalls }))
+        }
+        Command::TraceClear => {
+            tracer.clear();
+            Ok(serde_json::json!({ "cleared": true }))
+        }
+        _ => Err(Error::Internal("not a tracing command".to_string())),
+    }
+}
+
+async fn handle_replay(
+    recorder: &mut Recorder,
+    session: &mut Option<DebugSession>,
+    settings: &Settings,
+    command: Command,
+) -> Result<serde_json::Value> {
+    match command {
+        Command::RecordStart { at, limit, over } => {
+            let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
+            recorder.start(sess, settings, at, limit, over).await?;
+            Ok(serde_json::json!({ "limit": limit, "recording": recorder.is_recording() }))
+        }
+        Command::RecordStop => Ok(serde_json::json!({ "stopped": recorder.stop(session) })),
+        Command::ReplayList => Ok(recorder.list()),
+        Command::ReplaySeek { step, relative } => {
+            Ok(serde_json::to_value(recorder.seek(step, relative)?)?)
+        }
+        Command::ReplayExit => Ok(serde_json::json!({ "replaying": recorder.exit() })),
+        _ => Err(Error::Internal("not a recording command".to_string())),
+    }
+}
+
+async fn handle_coverage(
+    coverage: &mut Coverage,
+    session: &mut Option<DebugSession>,
+    settings: &Settings,
+    command: Command,
+) -> Result<serde_json::Value> {
+    match command {
+        Command::CoverageStart { file } => {
+            let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
+            let (files, lines) = coverage.start(sess, settings, file.as_deref()).await?;
+            Ok(serde_json::json!({ "files": files, "lines": lines }))
+        }
+        Command::CoverageStop => {
+            Ok(serde_json::json!({ "stopped": coverage.stop(session.as_mut()).await }))
+        }
+        Command::CoverageData => Ok(coverage.data(settings)),
+        _ => Err(Error::Internal("not a coverage command".to_string())),
+    }
+}
+
+async fn handle_timer(
+    timer: &mut Timer,
+    session: &mut Option<DebugSession>,
+    settings: &Settings,
+    command: Command,
+) -> Result<serde_json::Value> {
+    match command {
+        Command::TimerStart { from, to } => {
+            let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
+            timer.start(sess, settings, &from, &to).await?;
+            Ok(timer.data())
+        }
+        Command::TimerStop => {
+            let stopped = timer.stop(session.as_mut()).await?;
+            Ok(serde_json::json!({ "stopped": stopped }))
+        }
+        Command::TimerData => Ok(timer.data()),
+        _ => Err(Error::Internal("not a timer command".to_string())),
+    }
+}
+
+async fn handle_heap(
+    heap: &mut Heap,
+    session: &mut Option<DebugSession>,
+    command: Command,
+) -> Result<serde_json::Value> {
+    match command {
+        Command::HeapSnapshot => {
+            let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
+            heap.snapshot(sess).await
+        }
+        Command::HeapDiff { from, to } => heap.diff(from, to),
+        _ => Err(Error::Internal("not a heap command".to_string())),
+    }
 }
 
-async fn reduce_events(session: &mut Option<DebugSession>) {
-    if let Some(active) = session.as_mut() {
-        if let Err(e) = active.process_events().await {
-            tracing::warn!("Error processing events: {}", e);
+fn handle_samples(samples: &mut Samples, command: Command) -> Result<serde_json::Value> {
+    match command {
+        Command::SampleAdd {
+            expression,
+            every_ms,
+        } => {
+            let id = samples.add(expression.clone(), Duration::from_millis(every_ms));
+            Ok(serde_json::json!({ "id": id, "expression": expression, "every_ms": every_ms }))
+        }
+        Command::SampleRemove { id } => {
+            if samples.remove(id) {
+                Ok(serde_json::json!({ "removed": id }))
+            } else {
+                Err(Error::SampleNotFound { id })
+            }
+        }
+        Command::SampleList => Ok(serde_json::json!({ "samples": samples.list() })),
+        Command::SampleExport => Ok(serde_json::json!({
+            "samples": samples.list(),
+            "values": samples.values(),
+        })),
+        Command::SampleClear => {
+            samples.clear();
+            Ok(serde_json::json!({ "cleared": true }))
         }
+        _ => Err(Error::Internal("not a sampling command".to_string())),
     }
 }
 
-fn publish(snapshots: &watch::Sender<SessionSnapshot>, session: &Option<DebugSession>) {
+fn handle_tracks(tracks: &mut Tracks, command: Command) -> Result<serde_json::Value> {
+    match command {
+        Command::TrackAdd {
+            expression,
+            every_ms,
+            threshold,
+        } => {
+            let every = every_ms.map(Duration::from_millis);
+            let id = tracks.add(expression.clone(), every, threshold);
+            Ok(serde_json::json!({
+                "id": id,
+                "expression": expression,
+                "every_ms": every_ms,
+                "threshold": threshold,
+            }))
+        }
+        Command::TrackRemove { id } => {
+            if tracks.remove(id) {
+                Ok(serde_json::json!({ "removed": id }))
+            } else {
+                Err(Error::TrackNotFound { id })
+            }
+        }
+        Command::TrackList => Ok(serde_json::json!({ "tracks": tracks.list() })),
+        Command::TrackHistory { id } => {
+            let (expression, sizes) = tracks.history(id).ok_or(Error::TrackNotFound { id })?;
+            Ok(serde_json::json!({ "id": id, "expression": expression, "sizes": sizes }))
+        }
+        _ => Err(Error::Internal("not a size tracking command".to_string())),
+    }
+}
+
+fn handle_profile(profiler: &mut Profiler, command: Command) -> Result<serde_json::Value> {
+    match command {
+        Command::ProfileStart { hz } => {
+            profiler.start(hz)?;
+            Ok(serde_json::json!({ "hz": hz }))
+        }
+        Command::ProfileStop => Ok(serde_json::json!({ "stopped": profiler.stop() })),
+        Command::ProfileData => Ok(profiler.data()),
+        _ => Err(Error::Internal("not a profiling command".to_string())),
+    }
+}
+
+/// The session's next event, or `None` after a tick without one
+async fn next_event(session: &mut Option<DebugSession>) -> Option<Event> {
+    let Some(active) = session.as_mut() else {
+        return std::future::pending().await;
+    };
+    let deadline = tokio::time::Instant::now() + EVENT_TICK;
+    let event = active.next_event(deadline).await;
+    if event.is_none() {
+        // A closed channel answers at once; don't spin on it
+        tokio::time::sleep_until(deadline).await;
+    }
+    event
+}
+
+/// Wait until `due`, or forever if nothing is due
+async fn sleep_until(due: Option<tokio::time::Instant>) {
+    match due {
+        Some(due) => tokio::time::sleep_until(due).await,
+        None => std::future::pending().await,
+    }
+}
+
+/// Commands being recorded into a macro
+struct MacroRecording {
+    name: String,
+    commands: Vec<String>,
+}
+
+/// Run a transcript command against the daemon's transcript
+fn handle_transcript(
+    transcript: &mut Option<Transcript>,
+    recording_macro: &Option<MacroRecording>,
+    command: Command,
+) -> Result<serde_json::Value> {
+    match command {
+        Command::TranscriptStart { path } => {
+            if let Some(current) = transcript.as_ref() {
+                return Err(Error::Transcript(format!(
+                    "already recording to {}",
+                    current.path().display()
+                )));
+            }
+            *transcript = Some(Transcript::start(&path)?);
+            Ok(serde_json::json!({ "recording": true, "path": path }))
+        }
+        Command::TranscriptStop => {
+            let current = transcript.take().ok_or_else(not_recording)?;
+            let path = current.path().to_path_buf();
+            current.stop();
+            Ok(serde_json::json!({ "recording": false, "path": path }))
+        }
+        Command::TranscriptStatus => Ok(serde_json::json!({
+            "recording": transcript.is_some(),
+            "path": transcript.as_ref().map(|current| current.path()),
+        })),
+        Command::TranscriptAnnotate { note } => {
+            transcript.as_mut().ok_or_else(not_recording)?.annotate(&note);
+            Ok(serde_json::json!({ "note": note }))
+        }
+        Command::TranscriptRecord { command_line, output } => {
+            // Commands can finish after another client stopped the transcript
+            if let Some(current) = transcript.as_mut() {
+                current.record_command(&command_line, &output);
+            }
+            Ok(serde_json::json!({}))
+        }
+        Command::RecordingStatus => Ok(serde_json::json!({
+            "transcript": transcript.as_ref().map(|current| current.path()),
+            "macro": recording_macro.as_ref().map(|recording| &recording.name),
+        })),
+        _ => Err(Error::Internal("not a transcript command".to_string())),
+    }
+}
+
+/// Run a macro recording command
+///
+/// The daemon only collects the command lines; the CLI saves them when
+/// recording stops, so macros outlive the daemon.
+fn handle_macro(
+    recording_macro: &mut Option<MacroRecording>,
+    command: Command,
+) -> Result<serde_json::Value> {
+    match command {
+        Command::MacroStart { name } => {
+            if let Some(current) = recording_macro.as_ref() {
+                return Err(Error::Macro(format!("already recording '{}'", current.name)));
+            }
+            *recording_macro = Some(MacroRecording {
+                name: name.clone(),
+                commands: Vec::new(),
+            });
+            Ok(serde_json::json!({ "recording": true, "name": name }))
+        }
+        Command::MacroStop => {
+            let recording = recording_macro.take().ok_or_else(|| {
+                Error::Macro("not recording; start with 'record-macro start <name>'".to_string())
+            })?;
+            Ok(serde_json::json!({
+                "recording": false,
+                "name": recording.name,
+                "commands": recording.commands,
+            }))
+        }
+        Command::MacroRecord { command_line } => {
+            if let Some(recording) = recording_macro.as_mut() {
+                recording.commands.push(command_line);
+            }
+            Ok(serde_json::json!({}))
+        }
+        _ => Err(Error::Internal("not a macro command".to_string())),
+    }
+}
+
+fn not_recording() -> Error {
+    Error::Transcript("not recording; start with 'transcript start <file>'".to_string())
+}
+
+/// Rewrite the state CLI invocations read when it has changed, before the
+/// command that changed it is answered
+fn share(
+    shared: &mut Option<ClientState>,
+    transcript: &Option<Transcript>,
+    recording_macro: &Option<MacroRecording>,
+    hooks: &Hooks,
+    settings: &Settings,
+) {
+    let mut hooked: Vec<String> = hooks.list().into_iter().map(|hook| hook.target).collect();
+    hooked.sort();
+    hooked.dedup();
+    let state = ClientState {
+        transcript: transcript.is_some(),
+        macro_name: recording_macro.as_ref().map(|recording| recording.name.clone()),
+        hooked,
+        pagination: Some(settings.pagination),
+    };
+    if shared.as_ref() == Some(&state) {
+        return;
+    }
+    if let Err(e) = state.write() {
+        tracing::warn!("Failed to write the daemon's client state: {}", e);
+    }
+    *shared = Some(state);
+}
+
+fn publish(
+    snapshots: &watch::Sender<SessionSnapshot>,
+    session: &Option<DebugSession>,
+    tracks: &Tracks,
+) {
+    // A stop the tracer has not looked at yet may never have happened as
+    // far as anyone else is concerned
+    if session.as_ref().is_some_and(|active| active.held_stop().is_some()) {
+        return;
+    }
     let snapshot = match session {
         Some(active) => SessionSnapshot {
             session_active: true,
@@ -96,6 +667,8 @@ fn publish(snapshots: &watch::Sender<SessionSnapshot>, session: &Option<DebugSes
             stopped_reason: active.stopped_reason().map(String::from),
             stopped_thread: active.stopped_thread(),
             exit_code: active.exit_code(),
+            crash_report: active.crash_report().cloned(),
+            warnings: tracks.warnings(),
         },
         None => SessionSnapshot::default(),
     };
@@ -0,0 +1,259 @@
+//! Hardware branch tracing for `btrace`
+//!
+//! `btrace start` has GDB record the program's branches with `record btrace`,
+//! which programs the CPU through perf: Intel Processor Trace, or the older
+//! Branch Trace Store. The program runs at nearly full speed and GDB decodes
+//! the trace only when asked, so after a stop `btrace list` shows the exact
+//! path that led there, as function segments or as instructions, without
+//! stepping back. Only GDB decodes these traces, and only for programs on a
+//! Linux host with perf events.
+
+use std::path::Path;
+
+use serde_json::{json, Value};
+
+use crate::common::{Error, Result};
+use crate::ipc::protocol::{BtraceCall, BtraceFormat, BtraceInstruction};
+
+use super::session::{DebugSession, SessionState};
+use super::trace::gdb_command;
+
+/// Where the kernel lists the Intel PT event when the CPU has it
+const INTEL_PT: &str = "/sys/bus/event_source/devices/intel_pt";
+
+/// How much the kernel lets unprivileged programs use perf
+const PERF_PARANOID: &str = "/proc/sys/kernel/perf_event_paranoid";
+
+/// What `info record` says about a trace
+#[derive(Debug, Default, PartialEq)]
+struct Totals {
+    format: Option<String>,
+    instructions: u64,
+    functions: u64,
+    gaps: u64,
+}
+
+/// Start recording branches in the given format
+pub async fn start(sess: &mut DebugSession, format: BtraceFormat) -> Result<Value> {
+    require_gdb(sess)?;
+    if !cfg!(target_os = "linux") {
+        return Err(Error::Btrace("branch tracing needs Linux perf events".to_string()));
+    }
+    if format == BtraceFormat::Pt && !Path::new(INTEL_PT).exists() {
+        return Err(Error::Btrace(format!(
+            "this CPU or kernel has no Intel PT ({} is missing); try --format bts",
+            INTEL_PT
+        )));
+    }
+    let command = match format {
+        BtraceFormat::Pt => "record btrace pt",
+        BtraceFormat::Bts => "record btrace bts",
+    };
+    if let Err(e) = gdb_command(sess, command).await {
+        let mut message = format!("GDB could not start branch tracing: {}", e);
+        let paranoid = std::fs::read_to_string(PERF_PARANOID).ok();
+        if let Some(level) = paranoid.and_then(|level| level.trim().parse::<i32>().ok()) {
+            if level > 2 {
+                let hint = format!("; {} is {}, so perf may need it at 2", PERF_PARANOID, level);
+                message.push_str(&hint);
+            }
+        }
+        return Err(Error::Btrace(message));
+    }
+    Ok(json!({ "format": format, "recording": true }))
+}
+
+/// Stop recording and drop the trace
+pub async fn stop(sess: &mut DebugSession) -> Result<Value> {
+    require_gdb(sess)?;
+    totals(sess).await?;
+    gdb_command(sess, "record stop").await?;
+    Ok(json!({ "stopped": true }))
+}
+
+/// The last `count` function segments, or instructions, before the stop
+pub async fn list(sess: &mut DebugSession, instructions: bool, count: u32) -> Result<Value> {
+    require_gdb(sess)?;
+    if sess.state() != SessionState::Stopped {
+        return Err(Error::invalid_state("btrace list", &sess.state().to_string()));
+    }
+    let totals = totals(sess).await?;
+    let recorded = json!({
+        "instructions": totals.instructions,
+        "functions": totals.functions,
+        "gaps": totals.gaps,
+    });
+    let last = if instructions { totals.instructions } else { totals.functions };
+    let first = last.saturating_sub(u64::from(count.max(1)) - 1).max(1);
+
+    if instructions {
+        let shown = if last == 0 {
+            Vec::new()
+        } else {
+            let command = format!("record instruction-history {},{}", first, last);
+            let output = sess.evaluate(&command, None, "repl").await?.result;
+            output.lines().filter_map(instruction).collect()
+        };
+        return Ok(json!({ "format": totals.format, "recorded": recorded, "instructions": shown }));
+    }
+    let calls = if last == 0 {
+        Vec::new()
+    } else {
+        let command = format!("record function-call-history /ilc {},{}", first, last);
+        let output = sess.evaluate(&command, None, "repl").await?.result;
+        output.lines().filter_map(call).collect()
+    };
+    Ok(json!({ "format": totals.format, "recorded": recorded, "calls": calls }))
+}
+
+fn require_gdb(sess: &DebugSession) -> Result<()> {
+    match sess.adapter_name() {
+        "gdb" | "cuda-gdb" => Ok(()),
+        adapter => Err(Error::Btrace(format!(
+            "branch tracing needs GDB; this session uses {}",
+            adapter
+        ))),
+    }
+}
+
+/// What has been recorded, or an error if nothing is being recorded
+async fn totals(sess: &mut DebugSession) -> Result<Totals> {
+    let output = gdb_command(sess, "info record").await?;
+    parse_totals(&output).ok_or_else(|| {
+        Error::Btrace("not recording branches; start with 'btrace start'".to_string())
+    })
+}
+
+/// `info record` for a branch trace:
+///
+/// ```text
+/// Active record target: record-btrace
+/// Recording format: Intel Processor Trace.
+/// Buffer size: 16kB.
+/// Recorded 158 instructions in 5 functions (0 gaps) for thread 1 (process 4242).
+/// ```
+fn parse_totals(output: &str) -> Option<Totals> {
+    if !output.contains("record-btrace") {
+        return None;
+    }
+    let mut totals = Totals::default();
+    for line in output.lines() {
+        if let Some(format) = line.strip_prefix("Recording format: ") {
+            totals.format = Some(format.trim_end_matches('.').to_string());
+        }
+        if let Some(rest) = line.strip_prefix("Recorded ") {
+            let words: Vec<&str> = rest.split_whitespace().collect();
+            let number = |word: Option<&&str>| {
+                word.and_then(|word| word.trim_start_matches('(').parse().ok())
+            };
+            totals.instructions = number(words.first()).unwrap_or(0);
+            totals.functions = number(words.get(3)).unwrap_or(0);
+            totals.gaps = number(words.get(5)).unwrap_or(0);
+        }
+    }
+    Some(totals)
+}
+
+/// A line of `record function-call-history /ilc`, indented two spaces a
+/// call level: `12\t  main\tinst 40,58\tat t.c:8,10`
+fn call(line: &str) -> Option<BtraceCall> {
+    let (number, rest) = line.split_once('\t')?;
+    let number = number.trim().parse().ok()?;
+    let mut fields = rest.split('\t');
+    let name = fields.next()?;
+    let depth = (name.len() - name.trim_start().len()) / 2;
+    let mut call = BtraceCall {
+        number,
+        depth,
+        function: name.trim().to_string(),
+        instructions: None,
+        source: None,
+        lines: None,
+    };
+    for field in fields {
+        if let Some(range) = field.strip_prefix("inst ") {
+            call.instructions = range_of(range);
+        } else if let Some(at) = field.strip_prefix("at ") {
+            let (source, lines) = at.rsplit_once(':')?;
+            call.source = Some(source.to_string());
+            call.lines = range_of(lines);
+        }
+    }
+    Some(call)
+}
+
+/// `40,58`, or `8` for a range of one
+fn range_of<T: std::str::FromStr + Copy>(text: &str) -> Option<[T; 2]> {
+    let (first, last) = text.split_once(',').unwrap_or((text, text));
+    Some([first.trim().parse().ok()?, last.trim().parse().ok()?])
+}
+
+/// A line of `record instruction-history`:
+/// `40\t   0x0000000000401136 <main+4>:\tmov    $0x0,%eax`, with `=> ` for
+/// the replay position; a gap in the trace has its message in place of the
+/// instruction
+fn instruction(line: &str) -> Option<BtraceInstruction> {
+    let (number, rest) = line.split_once('\t')?;
+    let number = number.trim().parse().ok()?;
+    let rest = rest.trim_start().trim_start_matches("=> ");
+    if !rest.starts_with("0x") {
+        return Some(BtraceInstruction {
+            number,
+            address: None,
+            function: None,
+            instruction: rest.trim().to_string(),
+        });
+    }
+    let (address, after) = rest.split_once(char::is_whitespace).unwrap_or((rest, ""));
+    let (function, text) = match after.trim_start().strip_prefix('<') {
+        Some(symbol) => {
+            let (function, text) = symbol.split_once(">:")?;
+            (Some(function.to_string()), text)
+        }
+        None => (None, after),
+    };
+    Some(BtraceInstruction {
+        number,
+        address: Some(address.trim_end_matches(':').to_string()),
+        function,
+        instruction: text.trim().to_string(),
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn gdb_histories_are_parsed() {
+        let info = "Active record target: record-btrace\n\
+                    Recording format: Intel Processor Trace.\n\
+                    Buffer size: 16kB.\n\
+                    Recorded 158 instructions in 5 functions (1 gaps) for thread 1 (process 42).";
+        let totals = parse_totals(info).unwrap();
+        assert_eq!(totals.format.as_deref(), Some("Intel Processor Trace"));
+        assert_eq!((totals.instructions, totals.functions, totals.gaps), (158, 5, 1));
+        assert_eq!(parse_totals("No recording is currently active."), None);
+
+        let outer = call("4\tmain\tinst 1,20\tat /src/t.c:5,8").unwrap();
+        assert_eq!((outer.depth, outer.function.as_str()), (0, "main"));
+        assert_eq!(outer.instructions, Some([1, 20]));
+        assert_eq!((outer.source.as_deref(), outer.lines), (Some("/src/t.c"), Some([5, 8])));
+        let inner = call("5\t  add\tinst 21,24\tat /src/t.c:2").unwrap();
+        assert_eq!((inner.depth, inner.lines), (1, Some([2, 2])));
+        assert_eq!(call("5\t    ??\tinst 25,30").unwrap().source, None);
+
+        let mov = instruction("40\t   0x0000000000401136 <main+4>:\tmov    $0x0,%eax").unwrap();
+        assert_eq!(mov.address.as_deref(), Some("0x0000000000401136"));
+        assert_eq!(mov.function.as_deref(), Some("main+4"));
+        assert_eq!(mov.instruction, "mov    $0x0,%eax");
+        let current = instruction("41\t=> 0x000000000040113b <main+9>:\tret").unwrap();
+        assert_eq!((current.number, current.instruction.as_str()), (41, "ret"));
+        let gap = instruction("42\t[decode error (1): instruction overflow]").unwrap();
+        assert_eq!(gap.address, None);
+        assert_eq!(gap.instruction, "[decode error (1): instruction overflow]");
+        let bare = instruction("43\t   0x0000000000401140:\tnop").unwrap();
+        assert_eq!((bare.address.as_deref(), bare.function), (Some("0x0000000000401140"), None));
+        assert!(instruction("not a history line").is_none());
+    }
+}
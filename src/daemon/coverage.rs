@@ -0,0 +1,275 @@
+//! Line coverage for `coverage`
+//!
+//! `coverage start` puts a breakpoint on every line the line table says has
+//! code, in the source files found on disk. Each one is removed the first
+//! time it is hit and its line marked as run, so the program slows down only
+//! for code it has not run before. Like the tracer's, these stops never reach
+//! `await` or the stop history unless one of the user's breakpoints is on the
+//! same line. Coverage outlives the session, so it can be reported after the
+//! program exits.
+
+use std::collections::{BTreeMap, BTreeSet};
+use std::path::{Path, PathBuf};
+
+use serde_json::{json, Value};
+
+use crate::common::settings::Settings;
+use crate::common::{Error, Result};
+use crate::dap::{Event, StoppedEventBody};
+use crate::ipc::protocol::FileCoverage;
+use crate::symbols::{base_name, dwarf};
+
+use super::session::{DebugSession, SessionState, BURST};
+
+/// Breakpoints set at once; a whole program's lines need narrowing
+pub const MAX_LINES: usize = 50_000;
+
+/// A source file's lines with code, by their path in the debug info
+#[derive(Debug, Default)]
+struct File {
+    /// Lines the adapter put a breakpoint on
+    executable: BTreeSet<u32>,
+    covered: BTreeSet<u32>,
+    /// Breakpoints not hit yet: the line asked for and the line it is on
+    pending: Vec<(u32, u32)>,
+}
+
+impl File {
+    /// Mark `line` as run, retiring its breakpoints; false if none was on it
+    fn hit(&mut self, line: u32) -> bool {
+        let before = self.pending.len();
+        self.pending.retain(|(_, placed)| *placed != line);
+        if self.pending.len() == before {
+            return false;
+        }
+        self.covered.insert(line);
+        true
+    }
+}
+
+/// The lines seen to run, per file
+#[derive(Debug, Default)]
+pub struct Coverage {
+    files: BTreeMap<PathBuf, File>,
+    active: bool,
+}
+
+impl Coverage {
+    /// Put a breakpoint on every line of code in the files whose path
+    /// contains `filter`, dropping the previous coverage; returns how many
+    /// files and lines are covered
+    pub async fn start(
+        &mut self,
+        sess: &mut DebugSession,
+        settings: &Settings,
+        filter: Option<&str>,
+    ) -> Result<(usize, usize)> {
+        if self.active {
+            return Err(Error::Coverage("coverage is already running".to_string()));
+        }
+        if !sess.trace_functions().is_empty()
+            || sess.catching_syscalls()
+            || sess.recording()
+            || sess.timing()
+        {
+            return Err(Error::Coverage(
+                "the tracer, recorder or timer would take the coverage stops; stop it first"
+                    .to_string(),
+            ));
+        }
+
+        let path = sess.symbols_path();
+        let mut lines: BTreeMap<PathBuf, BTreeSet<u32>> = BTreeMap::new();
+        for row in dwarf::lines(&path, filter)? {
+            if let (true, Some(line)) = (row.is_stmt, row.line.filter(|line| *line > 0)) {
+                lines.entry(PathBuf::from(row.file)).or_default().insert(line);
+            }
+        }
+        // Headers of the toolchain and libraries are rarely wanted, and
+        // seldom on disk
+        lines.retain(|file, _| Path::new(&settings.local_path(&file.to_string_lossy())).exists());
+        let total: usize = lines.values().map(BTreeSet::len).sum();
+        if total == 0 {
+            return Err(Error::Coverage(match filter {
+                Some(filter) => format!("no lines of code in source files matching '{}'", filter),
+                None => "the program has no line table for source files on disk".to_string(),
+            }));
+        }
+        if total > MAX_LINES {
+            return Err(Error::Coverage(format!(
+                "{} lines in {} files to cover, more than {}; narrow them with --file",
+                total,
+                lines.len(),
+                MAX_LINES
+            )));
+        }
+
+        self.files.clear();
+        self.active = true;
+        for (file, lines) in lines {
+            let placed = match sess.set_coverage_lines(&file, lines.into_iter().collect()).await {
+                Ok(placed) => placed,
+                Err(e) => {
+                    self.stop(Some(sess)).await;
+                    return Err(e);
+                }
+            };
+            let pending: Vec<(u32, u32)> = placed
+                .into_iter()
+                .filter_map(|(line, placed)| placed.map(|placed| (line, placed)))
+                .collect();
+            if pending.is_empty() {
+                continue;
+            }
+            self.files.insert(
+                file,
+                File {
+                    executable: pending.iter().map(|(_, placed)| *placed).collect(),
+                    covered: BTreeSet::new(),
+                    pending,
+                },
+            );
+        }
+        let lines = self.files.values().map(|file| file.executable.len()).sum();
+        Ok((self.files.len(), lines))
+    }
+
+    /// Remove the breakpoints left, keeping what was covered; false if
+    /// coverage was not running
+    pub async fn stop(&mut self, session: Option<&mut DebugSession>) -> bool {
+        if !std::mem::take(&mut self.active) {
+            return false;
+        }
+        for file in self.files.values_mut() {
+            file.pending.clear();
+        }
+        if let Some(sess) = session {
+            for file in sess.coverage_files() {
+                if let Err(e) = sess.set_coverage_lines(&file, Vec::new()).await {
+                    tracing::warn!("Could not remove coverage breakpoints: {}", e);
+                }
+            }
+        }
+        true
+    }
+
+    /// Mark the lines hit since the last look and let the program run on;
+    /// returns the events handled meanwhile
+    pub async fn resolve(&mut self, session: &mut Option<DebugSession>) -> Vec<Event> {
+        let mut events = Vec::new();
+        if !self.active {
+            return events;
+        }
+        let Some(sess) = session.as_mut() else {
+            // The breakpoints went with the session
+            self.active = false;
+            return events;
+        };
+        if matches!(sess.state(), SessionState::Exited | SessionState::Terminating) {
+            self.active = false;
+            return events;
+        }
+
+        let deadline = tokio::time::Instant::now() + BURST;
+        let mut followed = false;
+        loop {
+            let Some(stop) = sess.held_stop().cloned() else {
+                // Code that has not run before often comes in runs
+                if !followed || sess.state() != SessionState::Running {
+                    break;
+                }
+                match sess.next_event(deadline).await {
+                    Some(event) => {
+                        events.push(event);
+                        continue;
+                    }
+                    None => break,
+                }
+            };
+            match self.follow(sess, &stop).await {
+                Ok(true) => followed = true,
+                Ok(false) => {
+                    sess.release_stop();
+                    break;
+                }
+                Err(e) => {
+                    tracing::warn!("Could not follow a coverage breakpoint: {}", e);
+                    sess.release_stop();
+                    break;
+                }
+            }
+        }
+        events
+    }
+
+    /// Mark the line of a held stop as run and resume the program; false if
+    /// the stop is for the user to see
+    async fn follow(&mut self, sess: &mut DebugSession, stop: &StoppedEventBody) -> Result<bool> {
+        let frames = sess.stack_trace(stop.thread_id, 1).await?;
+        let Some(top) = frames.first() else {
+            return Ok(false);
+        };
+        let Some(path) = top.source.as_ref().and_then(|source| source.path.as_deref()) else {
+            return Ok(false);
+        };
+        let path = PathBuf::from(path);
+        let Some(file) = self.files.get_mut(&path) else {
+            return Ok(false);
+        };
+        if !file.hit(top.line) {
+            return Ok(false);
+        }
+        let lines = file.pending.iter().map(|(line, _)| *line).collect();
+        sess.set_coverage_lines(&path, lines).await?;
+        if !sess.covering() {
+            self.active = false;
+        }
+
+        if sess.stepping() || sess.user_breakpoint_at(top, base_name(&top.name)) {
+            return Ok(false);
+        }
+        sess.continue_execution().await?;
+        Ok(true)
+    }
+
+    /// Every file's lines of code and those that ran, by local path
+    pub fn data(&self, settings: &Settings) -> Value {
+        let files: Vec<FileCoverage> = self
+            .files
+            .iter()
+            .map(|(path, file)| FileCoverage {
+                path: settings.local_path(&path.to_string_lossy()),
+                lines: file.executable.iter().copied().collect(),
+                covered: file.covered.iter().copied().collect(),
+            })
+            .collect();
+        json!({ "covering": self.active, "files": files })
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn a_hit_retires_every_breakpoint_on_its_line() {
+        // Lines 11 and 12 have no code of their own, so both land on 13
+        let mut file = File {
+            executable: [10, 13].into(),
+            covered: BTreeSet::new(),
+            pending: vec![(10, 10), (11, 13), (12, 13), (13, 13)],
+        };
+        assert!(file.hit(13));
+        assert_eq!(file.pending, [(10, 10)]);
+        assert!(!file.hit(13));
+        assert!(!file.hit(20));
+
+        let mut coverage = Coverage::default();
+        coverage.files.insert(PathBuf::from("/src/t.c"), file);
+        let data = coverage.data(&Settings::default());
+        assert_eq!(
+            data["files"],
+            json!([{ "path": "/src/t.c", "lines": [10, 13], "covered": [13] }])
+        );
+    }
+}
@@ -0,0 +1,334 @@
+//! Crash triage reports for `set crash-report on`
+//!
+//! When the program stops for a signal, exception or panic, the daemon
+//! collects what a crash is usually triaged from before anyone looks at the
+//! stop: the faulting address and what is mapped there, a backtrace of every
+//! thread, the crashing frame's locals and registers, and the loaded
+//! modules. The report is written as JSON to the crash reports directory,
+//! and its gist goes out with the stop so `await` and `connect` show it.
+//! Mappings and, for adapters without a `modules` request, modules come
+//! from `/proc/PID/maps`, so they are only known for local Linux programs.
+
+use std::path::PathBuf;
+
+use serde_json::{json, Value};
+
+use crate::common::settings::Settings;
+use crate::common::time::timestamp;
+use crate::common::{paths, Error, Result};
+use crate::dap::{StackFrame, StoppedEventBody};
+use crate::ipc::protocol::{CrashSummary, StackFrameInfo, VariableInfo};
+
+use super::session::{bounded, DebugSession, SessionState};
+
+/// Frames kept per thread
+const MAX_FRAMES: usize = 64;
+
+/// GDB's convenience variable for the faulting address of a SIGSEGV
+const SI_ADDR: &str = "$_siginfo._sifields._sigfault.si_addr";
+
+/// Stop reasons adapters give a crash
+pub const CRASH_REASONS: &[&str] = &["exception", "signal", "signal-received", "panic"];
+
+/// Write a report for the current stop if it is a crash, once per stop
+pub async fn check(session: &mut Option<DebugSession>, settings: &Settings) {
+    let Some(sess) = session.as_mut() else {
+        return;
+    };
+    if !settings.crash_report
+        || sess.state() != SessionState::Stopped
+        || sess.held_stop().is_some()
+        || sess.crash_triaged()
+    {
+        return;
+    }
+    let Some(stop) = sess.last_stop().cloned() else {
+        return;
+    };
+    if !CRASH_REASONS.contains(&stop.reason.as_str()) {
+        return;
+    }
+
+    let number = sess.stop_count();
+    match triage(sess, settings, &stop, number).await {
+        Ok(summary) => {
+            tracing::info!("Crash report written to {}", summary.path);
+            sess.set_crash_report(Some(summary));
+        }
+        Err(e) => {
+            tracing::warn!("Could not write a crash report: {}", e);
+            sess.set_crash_report(None);
+        }
+    }
+}
+
+/// Collect the report for a crash stop and write it
+async fn triage(
+    sess: &mut DebugSession,
+    settings: &Settings,
+    stop: &StoppedEventBody,
+    number: u64,
+) -> Result<CrashSummary> {
+    let signal = signal(&stop.reason, stop.description.as_deref());
+    let mut address = stop.description.as_deref().and_then(fault_address);
+    if address.is_none() && matches!(signal.as_str(), "SIGSEGV" | "SIGBUS") {
+        let si_addr = sess.evaluate(SI_ADDR, None, "watch").await;
+        address = si_addr.ok().and_then(|value| fault_address(&format!("addr {}", value.result)));
+    }
+    let maps = sess
+        .process_id()
+        .and_then(|pid| std::fs::read_to_string(format!("/proc/{}/maps", pid)).ok());
+    let mapping = address.zip(maps.as_deref()).map(|(address, maps)| mapping(maps, address));
+
+    let mut threads = Vec::new();
+    let mut top: Option<StackFrame> = None;
+    for thread in sess.get_threads().await? {
+        let frames = sess.stack_trace(Some(thread.id), MAX_FRAMES).await.unwrap_or_default();
+        let crashing = stop.thread_id.is_none_or(|id| id == thread.id);
+        if crashing && top.is_none() {
+            top = frames.first().cloned();
+        }
+        let frames: Vec<StackFrameInfo> =
+            frames.iter().map(|frame| frame_info(settings, frame)).collect();
+        threads.push(json!({ "id": thread.id, "name": thread.name, "frames": frames }));
+    }
+
+    let (mut locals, mut registers) = (Vec::new(), Vec::new());
+    if let Some(frame) = &top {
+        for scope in sess.get_scopes(Some(frame.id)).await.unwrap_or_default() {
+            let variables =
+                sess.get_variables(scope.variables_reference).await.unwrap_or_default();
+            if !scope.name.to_ascii_lowercase().contains("register") {
+                if !scope.expensive {
+                    let limit = settings.print_limit;
+                    locals.extend(variables.iter().map(|v| variable_info(v, limit)));
+                }
+                continue;
+            }
+            for variable in variables {
+                // lldb-dap groups registers into sets
+                if variable.variables_reference > 0 && variable.name.contains("Registers") {
+                    let set = sess.get_variables(variable.variables_reference).await;
+                    registers.extend(set.unwrap_or_default().iter().map(register));
+                } else {
+                    registers.push(register(&variable));
+                }
+            }
+        }
+    }
+
+    let mut modules: Vec<Value> = sess
+        .modules()
+        .await
+        .unwrap_or_default()
+        .into_iter()
+        .map(|module| {
+            json!({
+                "name": module.name,
+                "path": module.path,
+                "address_range": module.address_range,
+            })
+        })
+        .collect();
+    if modules.is_empty() {
+        modules = maps.as_deref().map(mapped_files).unwrap_or_default();
+    }
+
+    let function = top.as_ref().map(|frame| frame.name.clone());
+    let location = top.as_ref().and_then(|frame| {
+        let info = frame_info(settings, frame);
+        info.source.map(|source| format!("{}:{}", source, frame.line))
+    });
+    let address = address.map(|address| format!("{:#x}", address));
+    let time = timestamp();
+    let report = json!({
+        "time": time,
+        "program": sess.program(),
+        "pid": sess.process_id(),
+        "stop": number,
+        "reason": stop.reason,
+        "description": stop.description,
+        "signal": signal,
+        "thread_id": stop.thread_id,
+        "address": address,
+        "mapping": mapping,
+        "function": function,
+        "location": location,
+        "threads": threads,
+        "locals": locals,
+        "registers": registers,
+        "modules": modules,
+    });
+
+    let dir = paths::crash_reports_dir()
+        .ok_or_else(|| Error::Config("no data directory for crash reports".to_string()))?;
+    std::fs::create_dir_all(&dir)?;
+    let program = sess.program().file_stem().unwrap_or_default().to_string_lossy();
+    let stamp: String = time.chars().filter(char::is_ascii_digit).collect();
+    let path: PathBuf = dir.join(format!("{}-{}-{}.json", program, stamp, number));
+    std::fs::write(&path, serde_json::to_string_pretty(&report)?)?;
+
+    Ok(CrashSummary {
+        path: path.display().to_string(),
+        signal,
+        address,
+        mapping,
+        function,
+        location,
+        threads: threads.len(),
+    })
+}
+
+/// The signal a stop was for, from descriptions such as "signal SIGSEGV",
+/// or the description or reason itself
+fn signal(reason: &str, description: Option<&str>) -> String {
+    let description = description.unwrap_or("").trim();
+    let named = description.split(|c: char| !c.is_ascii_alphanumeric()).find(|word| {
+        word.len() > 3 && word.starts_with("SIG") && word.bytes().all(|b| b.is_ascii_uppercase())
+    });
+    match named {
+        Some(name) => name.to_string(),
+        None if description.is_empty() => reason.to_string(),
+        None => description.lines().next().unwrap_or(description).to_string(),
+    }
+}
+
+/// The address in descriptions such as "fault address: 0x0" or Go's
+/// "addr=0x0 pc=0x4566a1"
+fn fault_address(description: &str) -> Option<u64> {
+    let lower = description.to_ascii_lowercase();
+    lower.match_indices("addr").find_map(|(at, _)| {
+        let rest = lower[at + 4..].trim_start_matches("ess");
+        let rest = rest.trim_start_matches([':', '=', ' ']);
+        let hex = rest.strip_prefix("0x")?;
+        let end = hex.find(|c: char| !c.is_ascii_hexdigit()).unwrap_or(hex.len());
+        u64::from_str_radix(&hex[..end], 16).ok()
+    })
+}
+
+/// What `/proc/PID/maps` has at `address`: the file or `[heap]`-style name
+/// and the permissions, or `unmapped`
+fn mapping(maps: &str, address: u64) -> String {
+    maps.lines()
+        .filter_map(map_entry)
+        .find(|entry| entry.start <= address && address < entry.end)
+        .map(|entry| match entry.path {
+            "" => format!("anonymous ({})", entry.perms),
+            path => format!("{} ({})", path, entry.perms),
+        })
+        .unwrap_or_else(|| "unmapped".to_string())
+}
+
+/// The files mapped into the process, each with the range it spans
+fn mapped_files(maps: &str) -> Vec<Value> {
+    let mut files: Vec<(&str, u64, u64)> = Vec::new();
+    for entry in maps.lines().filter_map(map_entry) {
+        if !entry.path.starts_with('/') {
+            continue;
+        }
+        match files.iter_mut().find(|(path, _, _)| *path == entry.path) {
+            Some(file) => file.2 = file.2.max(entry.end),
+            None => files.push((entry.path, entry.start, entry.end)),
+        }
+    }
+    files
+        .into_iter()
+        .map(|(path, start, end)| {
+            let name = path.rsplit('/').next().unwrap_or(path);
+            let range = format!("{:#x}-{:#x}", start, end);
+            json!({ "name": name, "path": path, "address_range": range })
+        })
+        .collect()
+}
+
+pub(super) struct MapEntry<'a> {
+    pub start: u64,
+    pub end: u64,
+    pub perms: &'a str,
+    /// Where in the file the mapping starts
+    pub offset: u64,
+    pub path: &'a str,
+}
+
+/// `55d0c0a00000-55d0c0a21000 r-xp 00000000 08:01 1234  /usr/bin/app`
+pub(super) fn map_entry(line: &str) -> Option<MapEntry<'_>> {
+    let mut fields = line.splitn(6, ' ');
+    let (start, end) = fields.next()?.split_once('-')?;
+    let perms = fields.next()?;
+    let offset = fields.next()?;
+    let path = fields.nth(2).unwrap_or("").trim();
+    Some(MapEntry {
+        start: u64::from_str_radix(start, 16).ok()?,
+        end: u64::from_str_radix(end, 16).ok()?,
+        perms,
+        offset: u64::from_str_radix(offset, 16).ok()?,
+        path,
+    })
+}
+
+fn frame_info(settings: &Settings, frame: &StackFrame) -> StackFrameInfo {
+    StackFrameInfo {
+        id: frame.id,
+        name: frame.name.clone(),
+        source: frame
+            .source
+            .as_ref()
+            .and_then(|source| source.path.as_deref())
+            .map(|path| settings.local_path(path)),
+        line: Some(frame.line),
+        column: Some(frame.column),
+    }
+}
+
+fn variable_info(variable: &crate::dap::Variable, limit: usize) -> VariableInfo {
+    let mut value = variable.value.clone();
+    let truncated_at = bounded(&mut value, limit);
+    VariableInfo {
+        name: variable.name.clone(),
+        value,
+        type_name: variable.type_name.clone(),
+        variables_reference: variable.variables_reference,
+        truncated_at,
+    }
+}
+
+fn register(variable: &crate::dap::Variable) -> Value {
+    json!({ "name": variable.name, "value": variable.value })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn faults_are_placed_in_the_process_map() {
+        assert_eq!(signal("exception", Some("signal SIGSEGV: invalid address")), "SIGSEGV");
+        assert_eq!(signal("panic", Some("panic: boom\ngoroutine 1")), "panic: boom");
+        assert_eq!(signal("signal", None), "signal");
+
+        let lldb = "signal SIGSEGV: address not mapped to object (fault address: 0x10)";
+        assert_eq!(fault_address(lldb), Some(0x10));
+        let go = "invalid memory address or nil pointer dereference\n\
+                  [signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x4566a1]";
+        assert_eq!(fault_address(go), Some(0));
+        assert_eq!(fault_address("EXC_BAD_ACCESS (code=1, address=0xdead)"), Some(0xdead));
+        assert_eq!(fault_address("signal SIGABRT"), None);
+
+        let maps = "\
+55d0c0a00000-55d0c0a21000 r-xp 00000000 08:01 1234                       /usr/bin/app
+55d0c0c21000-55d0c0c22000 rw-p 00021000 08:01 1234                       /usr/bin/app
+55d0c1000000-55d0c1021000 rw-p 00000000 00:00 0                          [heap]
+7f0000000000-7f0000001000 rw-p 00000000 00:00 0
+7f1000000000-7f1000200000 r-xp 00000000 08:01 99                         /usr/lib/libc.so.6";
+        assert_eq!(mapping(maps, 0), "unmapped");
+        assert_eq!(mapping(maps, 0x55d0c0a00010), "/usr/bin/app (r-xp)");
+        assert_eq!(mapping(maps, 0x55d0c1000000), "[heap] (rw-p)");
+        assert_eq!(mapping(maps, 0x7f0000000800), "anonymous (rw-p)");
+
+        let files = mapped_files(maps);
+        assert_eq!(files.len(), 2);
+        assert_eq!(files[0]["name"], "app");
+        assert_eq!(files[0]["address_range"], "0x55d0c0a00000-0x55d0c0c22000");
+        assert_eq!(files[1]["path"], "/usr/lib/libc.so.6");
+    }
+}
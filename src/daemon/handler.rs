@@ -2,24 +2,38 @@
 //!
 //! Translates IPC commands into session operations and DAP requests.
 
+use std::collections::HashMap;
+use std::path::{Path, PathBuf};
+
 use serde_json::json;
 
-use crate::common::{config::Config, error::IpcError, Error, Result};
+use crate::common::{config::Config, error::IpcError, settings::Settings, Error, Result};
 use crate::ipc::protocol::{
-    BreakpointLocation, Command, ContextResult, EvaluateContext, EvaluateResult, Response,
-    SourceLine, StackFrameInfo, StatusResult, ThreadInfo, VariableInfo,
+    BreakpointInfo, BreakpointLocation, Command, ContextResult, DwarfQuery, EvaluateContext,
+    EvaluateResult, FindKind, FindMatch, InstructionInfo, OutputLine, Response, SourceLine,
+    StackFrameInfo, StatusResult, StopRecord, ThreadInfo, TimelineEvent, TimelineKind,
+    VariableInfo,
 };
+use crate::symbols::{self, dwarf, markers, SymbolIndex};
 
-use super::session::DebugSession;
+use super::btrace;
+use super::hooks::Hooks;
+use super::modules;
+use super::relocation;
+use super::session::{bounded, DebugSession, OutputEvent, SessionState};
+use super::watches::Watches;
 
 /// Handle an IPC command
 pub async fn handle_command(
     session: &mut Option<DebugSession>,
+    hooks: &mut Hooks,
+    settings: &mut Settings,
+    watches: &mut Watches,
     config: &Config,
     id: u64,
     command: Command,
 ) -> Response {
-    match handle_command_inner(session, config, command).await {
+    match handle_command_inner(session, hooks, settings, watches, config, command).await {
         Ok(result) => Response::success(id, result),
         Err(e) => Response::error(id, IpcError::from(&e)),
     }
@@ -27,6 +41,9 @@ pub async fn handle_command(
 
 async fn handle_command_inner(
     session: &mut Option<DebugSession>,
+    hooks: &mut Hooks,
+    settings: &mut Settings,
+    watches: &mut Watches,
     config: &Config,
     command: Command,
 ) -> Result<serde_json::Value> {
@@ -43,9 +60,40 @@ async fn handle_command_inner(
                 return Err(Error::SessionAlreadyActive);
             }
 
-            let new_session =
+            // `@marker:NAME`s are read from the binary before there is a session
+            let index = initial_breakpoints
+                .iter()
+                .any(|location| markers::reference(location).is_some())
+                .then(|| {
+                    let binary = symbols::binary_path(&program);
+                    let path = symbols::symbols_path(&binary, &settings.debug_file_directory);
+                    SymbolIndex::load(&path).ok()
+                })
+                .flatten();
+
+            // Breakpoints name local files; the adapter needs build paths
+            let initial_breakpoints = initial_breakpoints
+                .iter()
+                .map(|location| {
+                    let parsed = BreakpointLocation::parse(location)?;
+                    marker_line(index.as_ref(), &program, settings, parsed)
+                })
+                .map(|location| match location? {
+                    BreakpointLocation::Line { file, line } => Ok(BreakpointLocation::Line {
+                        file: settings.remote_path(&file),
+                        line,
+                    }
+                    .to_string()),
+                    location @ (BreakpointLocation::Function { .. }
+                    | BreakpointLocation::Address { .. }) => Ok(location.to_string()),
+                })
+                .collect::<Result<Vec<_>>>()?;
+
+            let mut new_session =
                 DebugSession::launch(config, &program, args, adapter, stop_on_entry, initial_breakpoints).await?;
+            new_session.set_debug_directories(&settings.debug_file_directory);
             *session = Some(new_session);
+            watches.clear_history();
 
             Ok(json!({
                 "status": "started",
@@ -58,8 +106,10 @@ async fn handle_command_inner(
                 return Err(Error::SessionAlreadyActive);
             }
 
-            let new_session = DebugSession::attach(config, pid, adapter).await?;
+            let mut new_session = DebugSession::attach(config, pid, adapter).await?;
+            new_session.set_debug_directories(&settings.debug_file_directory);
             *session = Some(new_session);
+            watches.clear_history();
 
             Ok(json!({
                 "status": "attached",
@@ -67,6 +117,55 @@ async fn handle_command_inner(
             }))
         }
 
+        Command::AttachRemote {
+            target,
+            program,
+            adapter,
+        } => {
+            if session.is_some() {
+                return Err(Error::SessionAlreadyActive);
+            }
+
+            let mut new_session =
+                DebugSession::attach_remote(config, &target, &program, adapter).await?;
+            new_session.set_debug_directories(&settings.debug_file_directory);
+            *session = Some(new_session);
+            watches.clear_history();
+
+            Ok(json!({
+                "status": "attached",
+                "target": target,
+                "program": program.display().to_string()
+            }))
+        }
+
+        Command::CoreOpen {
+            program,
+            core,
+            adapter,
+            slide,
+        } => {
+            if session.is_some() {
+                return Err(Error::SessionAlreadyActive);
+            }
+
+            let mut new_session = DebugSession::open_core(config, &program, &core, adapter).await?;
+            new_session.set_debug_directories(&settings.debug_file_directory);
+            if let Some(slide) = slide {
+                let binary = symbols::binary_path(&program);
+                new_session.set_slide(relocation::given(&binary, slide)).await?;
+            }
+            *session = Some(new_session);
+            watches.clear_history();
+
+            Ok(json!({
+                "status": "opened",
+                "program": program.display().to_string(),
+                "core": core.display().to_string(),
+                "slide": slide,
+            }))
+        }
+
         Command::Detach => {
             let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
             sess.detach().await?;
@@ -100,6 +199,7 @@ async fn handle_command_inner(
 
         Command::Status => {
             let result = if let Some(sess) = session {
+                let frame = sess.selected_frame();
                 StatusResult {
                     daemon_running: true,
                     session_active: true,
@@ -109,6 +209,16 @@ async fn handle_command_inner(
                     selected_thread: sess.get_selected_thread(),
                     stopped_thread: sess.stopped_thread(),
                     stopped_reason: sess.stopped_reason().map(String::from),
+                    pid: sess.process_id(),
+                    selected_frame: frame.map(|(index, _)| index),
+                    function: frame.map(|(_, name)| name.to_string()),
+                    breakpoints: sess
+                        .list_breakpoints()
+                        .iter()
+                        .filter(|bp| bp.enabled)
+                        .count(),
+                    failed_assertions: sess.failed_assertions().len(),
+                    idle_secs: sess.idle_time().map(|idle| idle.as_secs()),
                 }
             } else {
                 StatusResult {
@@ -120,6 +230,12 @@ async fn handle_command_inner(
                     selected_thread: None,
                     stopped_thread: None,
                     stopped_reason: None,
+                    pid: None,
+                    selected_frame: None,
+                    function: None,
+                    breakpoints: 0,
+                    failed_assertions: 0,
+                    idle_secs: None,
                 }
             };
 
@@ -133,6 +249,7 @@ async fn handle_command_inner(
             hit_count,
         } => {
             let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
+            let location = session_marker_line(sess, settings, location)?;
 
             // Check capabilities before using advanced features
             if matches!(location, BreakpointLocation::Function { .. })
@@ -156,8 +273,16 @@ async fn handle_command_inner(
                 ));
             }
 
+            let location = match location {
+                BreakpointLocation::Line { file, line } => BreakpointLocation::Line {
+                    file: settings.remote_path(&file),
+                    line,
+                },
+                location => location,
+            };
+
             let info = sess.add_breakpoint(location, condition, hit_count).await?;
-            Ok(serde_json::to_value(info)?)
+            Ok(serde_json::to_value(local_breakpoint(settings, info))?)
         }
 
         Command::BreakpointRemove { id, all } => {
@@ -178,7 +303,11 @@ async fn handle_command_inner(
 
         Command::BreakpointList => {
             let sess = session.as_ref().ok_or(Error::SessionNotActive)?;
-            let breakpoints = sess.list_breakpoints();
+            let breakpoints: Vec<_> = sess
+                .list_breakpoints()
+                .into_iter()
+                .map(|info| local_breakpoint(settings, info))
+                .collect();
             Ok(json!({ "breakpoints": breakpoints }))
         }
 
@@ -194,6 +323,12 @@ async fn handle_command_inner(
             Ok(json!({ "disabled": id }))
         }
 
+        Command::BreakpointUndo => {
+            let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
+            let (ids, action) = sess.undo_breakpoint_change().await?;
+            Ok(json!({ "undone": action, "breakpoints": ids }))
+        }
+
         // === Execution Control ===
         Command::Continue => {
             let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
@@ -226,22 +361,27 @@ async fn handle_command_inner(
         }
 
         // === State Inspection ===
-        Command::StackTrace { thread_id, limit } => {
+        Command::StackTrace {
+            thread_id,
+            limit,
+            start,
+            more,
+        } => {
             let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
-            let frames = sess.stack_trace(thread_id, limit).await?;
+            let (start, frames, more) = sess.backtrace_page(thread_id, start, limit, more).await?;
 
             let frame_infos: Vec<StackFrameInfo> = frames
                 .iter()
                 .map(|f| StackFrameInfo {
                     id: f.id,
                     name: f.name.clone(),
-                    source: f.source.as_ref().and_then(|s| s.path.clone()),
+                    source: frame_source(settings, f),
                     line: Some(f.line),
                     column: Some(f.column),
                 })
                 .collect();
 
-            Ok(json!({ "frames": frame_infos }))
+            Ok(json!({ "frames": frame_infos, "start": start, "more": more }))
         }
 
         Command::Locals { frame_id } => {
@@ -250,12 +390,7 @@ async fn handle_command_inner(
 
             let var_infos: Vec<VariableInfo> = vars
                 .iter()
-                .map(|v| VariableInfo {
-                    name: v.name.clone(),
-                    value: v.value.clone(),
-                    type_name: v.type_name.clone(),
-                    variables_reference: v.variables_reference,
-                })
+                .map(|v| variable_info(v, settings.print_limit))
                 .collect();
 
             Ok(json!({ "variables": var_infos }))
@@ -265,6 +400,7 @@ async fn handle_command_inner(
             expression,
             frame_id,
             context,
+            limit,
         } => {
             let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
             let ctx_str = match context {
@@ -272,15 +408,23 @@ async fn handle_command_inner(
                 EvaluateContext::Repl => "repl",
                 EvaluateContext::Hover => "hover",
             };
-            let result = sess.evaluate(&expression, frame_id, ctx_str).await?;
+            let mut result = sess.evaluate(&expression, frame_id, ctx_str).await?;
+            let truncated_at = bounded(&mut result.result, limit.unwrap_or(settings.print_limit));
 
             Ok(serde_json::to_value(EvaluateResult {
                 result: result.result,
                 type_name: result.type_name,
                 variables_reference: result.variables_reference,
+                truncated_at,
             })?)
         }
 
+        Command::AssertFailed { message } => {
+            let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
+            sess.record_failed_assertion(message);
+            Ok(json!({ "failed_assertions": sess.failed_assertions().len() }))
+        }
+
         Command::Scopes { frame_id } => {
             let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
             let scopes = sess.get_scopes(Some(frame_id)).await?;
@@ -293,12 +437,7 @@ async fn handle_command_inner(
 
             let var_infos: Vec<VariableInfo> = vars
                 .iter()
-                .map(|v| VariableInfo {
-                    name: v.name.clone(),
-                    value: v.value.clone(),
-                    type_name: v.type_name.clone(),
-                    variables_reference: v.variables_reference,
-                })
+                .map(|v| variable_info(v, settings.print_limit))
                 .collect();
 
             Ok(json!({ "variables": var_infos }))
@@ -321,6 +460,19 @@ async fn handle_command_inner(
             Ok(json!({ "threads": thread_infos }))
         }
 
+        Command::Modules => {
+            let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
+            let list = modules::list(sess).await?;
+            let pending = sess.deferred_symbols().map_or(0, |deferred| deferred.pending());
+            Ok(json!({ "modules": list, "pending": pending }))
+        }
+
+        Command::ProcSlide => {
+            let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
+            let slide = relocation::slide(sess).await?;
+            Ok(serde_json::to_value(slide)?)
+        }
+
         Command::ThreadSelect { id } => {
             let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
             sess.select_thread(id).await?;
@@ -330,21 +482,21 @@ async fn handle_command_inner(
         Command::FrameSelect { number } => {
             let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
             let frame = sess.select_frame(number).await?;
-            Ok(create_frame_response(&frame, number))
+            Ok(create_frame_response(settings, &frame, number))
         }
 
         Command::FrameUp => {
             let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
             let frame = sess.frame_up().await?;
             let index = sess.get_current_frame_index();
-            Ok(create_frame_response(&frame, index))
+            Ok(create_frame_response(settings, &frame, index))
         }
 
         Command::FrameDown => {
             let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
             let frame = sess.frame_down().await?;
             let index = sess.get_current_frame_index();
-            Ok(create_frame_response(&frame, index))
+            Ok(create_frame_response(settings, &frame, index))
         }
 
         // === Context ===
@@ -356,29 +508,21 @@ async fn handle_command_inner(
             let frame = sess.select_frame(frame_index).await?;
 
             // Read source file
-            let source_path = frame
-                .source
-                .as_ref()
-                .and_then(|s| s.path.as_ref())
+            let source_path = frame_source(settings, &frame)
                 .ok_or_else(|| Error::Internal("No source file available".to_string()))?;
 
-            let source_lines = read_source_context(source_path, frame.line, lines)?;
+            let source_lines = read_source_context(&source_path, frame.line, lines)?;
 
             // Get locals
             let vars = sess.get_locals(Some(frame.id)).await.unwrap_or_default();
             let locals: Vec<VariableInfo> = vars
                 .iter()
-                .map(|v| VariableInfo {
-                    name: v.name.clone(),
-                    value: v.value.clone(),
-                    type_name: v.type_name.clone(),
-                    variables_reference: v.variables_reference,
-                })
+                .map(|v| variable_info(v, settings.print_limit))
                 .collect();
 
             let result = ContextResult {
                 thread_id: sess.stopped_thread().unwrap_or(1),
-                source: Some(source_path.clone()),
+                source: Some(source_path),
                 line: frame.line,
                 column: Some(frame.column),
                 function: Some(frame.name.clone()),
@@ -389,6 +533,179 @@ async fn handle_command_inner(
             Ok(serde_json::to_value(result)?)
         }
 
+        Command::Disassemble { count } => {
+            let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
+
+            // Show a little of what led up to the current instruction
+            let before = count / 4;
+            let (instructions, pc) = sess.disassemble(before, count).await?;
+
+            let pc = parse_address(&pc);
+            let instructions: Vec<InstructionInfo> = instructions
+                .into_iter()
+                .map(|instruction| InstructionInfo {
+                    is_current: pc.is_some() && parse_address(&instruction.address) == pc,
+                    address: instruction.address,
+                    instruction: instruction.instruction,
+                    symbol: instruction.symbol,
+                    line: instruction.line,
+                })
+                .collect();
+
+            Ok(json!({ "instructions": instructions }))
+        }
+
+        // === Branch tracing ===
+        Command::BtraceStart { format } => {
+            let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
+            btrace::start(sess, format).await
+        }
+
+        Command::BtraceStop => {
+            let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
+            btrace::stop(sess).await
+        }
+
+        Command::BtraceList {
+            instructions,
+            count,
+        } => {
+            let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
+            btrace::list(sess, instructions, count).await
+        }
+
+        // === Symbols ===
+        Command::SymbolsAdd { file } => {
+            let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
+            let adapter = sess.add_debug_file(&file).await?;
+            let index = sess.symbols()?;
+            Ok(json!({
+                "file": file.display().to_string(),
+                "functions": index.functions.len(),
+                "files": index.files.len(),
+                "adapter": adapter,
+            }))
+        }
+
+        Command::Find { kind, query, limit } => {
+            let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
+            let index = sess.symbols()?;
+
+            let matches: Vec<FindMatch> = match kind {
+                FindKind::Func => {
+                    let found = index.find_functions(&query, limit);
+                    let declarations = index.declarations(found.iter().map(|m| m.item));
+                    found
+                        .into_iter()
+                        .zip(declarations)
+                        .map(|(m, declaration)| {
+                            let (file, line) = declaration.unzip();
+                            FindMatch {
+                                name: m.item.name.clone(),
+                                file: file
+                                    .map(|file| settings.local_path(&file.to_string_lossy())),
+                                line,
+                                score: m.score,
+                            }
+                        })
+                        .collect()
+                }
+                FindKind::File => index
+                    .find_files(&query, limit)
+                    .into_iter()
+                    .map(|m| FindMatch {
+                        name: settings.local_path(&m.item.to_string_lossy()),
+                        file: None,
+                        line: None,
+                        score: m.score,
+                    })
+                    .collect(),
+            };
+
+            Ok(json!({ "matches": matches }))
+        }
+
+        Command::Dwarf { query } => {
+            let sess = session.as_ref().ok_or(Error::SessionNotActive)?;
+            let path = sess.symbols_path();
+            let local = |file: Option<String>| file.map(|file| settings.local_path(&file));
+
+            match query {
+                DwarfQuery::Types { filter, limit } => {
+                    let mut types = dwarf::types(&path, filter.as_deref())?;
+                    let total = types.len();
+                    types.truncate(limit);
+                    for entry in &mut types {
+                        entry.file = local(entry.file.take());
+                    }
+                    Ok(json!({ "types": types, "total": total }))
+                }
+                DwarfQuery::Functions { filter, limit } => {
+                    let mut functions = dwarf::functions(&path, filter.as_deref())?;
+                    let total = functions.len();
+                    functions.truncate(limit);
+                    for entry in &mut functions {
+                        entry.file = local(entry.file.take());
+                    }
+                    Ok(json!({ "functions": functions, "total": total }))
+                }
+                DwarfQuery::Lines { file, limit } => {
+                    let mut lines = dwarf::lines(&path, file.as_deref())?;
+                    let total = lines.len();
+                    lines.truncate(limit);
+                    for entry in &mut lines {
+                        entry.file = settings.local_path(&entry.file);
+                    }
+                    Ok(json!({ "lines": lines, "total": total }))
+                }
+                DwarfQuery::Inlined { filter, limit } => {
+                    let mut inlined = dwarf::inlined(&path, filter.as_deref())?;
+                    let total = inlined.len();
+                    inlined.truncate(limit);
+                    for entry in &mut inlined {
+                        entry.call_file = local(entry.call_file.take());
+                    }
+                    Ok(json!({ "inlined": inlined, "total": total }))
+                }
+                DwarfQuery::Die { offset } => Ok(json!({ "die": dwarf::die(&path, offset)? })),
+            }
+        }
+
+        Command::Suggest { kind, name, limit } => {
+            let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
+            let index = sess.symbols()?;
+
+            let suggestions: Vec<String> = match kind {
+                FindKind::Func => index
+                    .suggest_functions(&name, limit)
+                    .into_iter()
+                    .map(String::from)
+                    .collect(),
+                FindKind::File => index
+                    .suggest_files(&name, limit)
+                    .into_iter()
+                    .map(|path| settings.local_path(&path.to_string_lossy()))
+                    .collect(),
+            };
+
+            Ok(json!({ "suggestions": suggestions }))
+        }
+
+        Command::ResolveMarker { name } => {
+            let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
+            let location = BreakpointLocation::Function {
+                name: format!("{}{}", markers::PREFIX, name),
+            };
+            match session_marker_line(sess, settings, location)? {
+                BreakpointLocation::Line { file, line } => {
+                    Ok(json!({ "name": name, "file": file, "line": line }))
+                }
+                BreakpointLocation::Function { .. } | BreakpointLocation::Address { .. } => {
+                    Err(Error::InvalidLocation(name))
+                }
+            }
+        }
+
         // === Async ===
         Command::Await { .. } => {
             // Await is handled by the connection task in the server, which
@@ -400,7 +717,11 @@ async fn handle_command_inner(
         }
 
         // === Output ===
-        Command::GetOutput { tail, clear } => {
+        Command::GetOutput {
+            tail,
+            clear,
+            since_last_stop,
+        } => {
             let sess = session.as_mut().ok_or(Error::SessionNotActive)?;
             // Make output visible immediately instead of waiting for the daemon's
             // periodic event-processing tick.
@@ -408,7 +729,11 @@ async fn handle_command_inner(
             // `--tail` is documented in lines, while the DAP emits arbitrary
             // output chunks. Read the full bounded buffer first, then trim the
             // concatenated stream by lines so chunk boundaries are invisible.
-            let events = sess.get_output(clear);
+            let mut events = sess.get_output(clear);
+            if since_last_stop {
+                let last = sess.stop_count();
+                events.retain(|event| event.stop >= last);
+            }
 
             let all_output: String = events.iter().map(|e| e.output.as_str()).collect();
             let output = tail
@@ -420,17 +745,200 @@ async fn handle_command_inner(
                     json!({
                         "category": event.category,
                         "output": event.output,
+                        "time": event.time,
+                        "stop": event.stop,
                     })
                 })
                 .collect();
+            let mut lines = output_lines(&events);
+            if let Some(line_count) = tail {
+                lines.drain(..lines.len().saturating_sub(line_count));
+            }
 
             Ok(json!({
                 "output": output,
                 "count": events.len(),
                 "events": event_details,
+                "lines": lines,
+                "stop": sess.stop_count(),
             }))
         }
 
+        // === Watches ===
+        Command::WatchAdd { expression } => {
+            let id = watches.add(expression.clone());
+            Ok(json!({ "id": id, "expression": expression }))
+        }
+
+        Command::WatchRemove { id } => {
+            if watches.remove(id) {
+                Ok(json!({ "removed": id }))
+            } else {
+                Err(Error::WatchNotFound { id })
+            }
+        }
+
+        Command::WatchList => {
+            let mut infos = Vec::new();
+            for (id, expression) in watches.expressions() {
+                let info = match session.as_mut() {
+                    Some(sess) if sess.state() == SessionState::Stopped => {
+                        let stop = sess.stop_count();
+                        match sess.evaluate(&expression, None, "watch").await {
+                            Ok(result) => watches.record(id, stop, Some(result.result)),
+                            Err(e) => watches.record(id, stop, None).map(|mut info| {
+                                info.error = Some(e.to_string());
+                                info
+                            }),
+                        }
+                    }
+                    Some(sess) => watches.unavailable(id, format!("program is {}", sess.state())),
+                    None => watches.unavailable(id, "no debug session".to_string()),
+                };
+                infos.extend(info);
+            }
+            Ok(json!({ "watches": infos }))
+        }
+
+        Command::StopHistory => {
+            let stops: Vec<StopRecord> = session
+                .as_ref()
+                .map(|sess| {
+                    sess.stops()
+                        .cloned()
+                        .map(|mut stop| {
+                            stop.source = stop.source.map(|path| settings.local_path(&path));
+                            stop
+                        })
+                        .collect()
+                })
+                .unwrap_or_default();
+            Ok(json!({ "stops": stops }))
+        }
+
+        Command::Timeline => {
+            let sess = session.as_ref().ok_or(Error::SessionNotActive)?;
+            let stops: HashMap<u64, &StopRecord> =
+                sess.stops().map(|stop| (stop.number, stop)).collect();
+            let events: Vec<TimelineEvent> = sess
+                .timeline()
+                .events()
+                .cloned()
+                .map(|mut event| {
+                    // Where a stop was is only known once its stack is fetched
+                    let stop = stops.get(&event.stop);
+                    if let (TimelineKind::Stop | TimelineKind::Signal, Some(stop)) =
+                        (event.kind, stop)
+                    {
+                        if let Some(function) = &stop.function {
+                            event.text.push_str(&format!(" in {}", function));
+                        }
+                        if let (Some(source), Some(line)) = (&stop.source, stop.line) {
+                            let path = settings.local_path(source);
+                            let name = path.rsplit('/').next().unwrap_or(&path).to_string();
+                            event.text.push_str(&format!(" ({}:{})", name, line));
+                        }
+                    }
+                    event
+                })
+                .collect();
+            let threads: Vec<serde_json::Value> = sess
+                .known_threads()
+                .iter()
+                .map(|thread| json!({ "id": thread.id, "name": thread.name }))
+                .collect();
+            Ok(json!({ "events": events, "threads": threads }))
+        }
+
+        Command::WatchHistory { id } => {
+            let (expression, history) = watches
+                .history(id)
+                .ok_or(Error::WatchNotFound { id })?;
+            Ok(json!({ "id": id, "expression": expression, "history": history }))
+        }
+
+        // === Settings ===
+        Command::Set { name, args } => {
+            settings.set(&name, &args)?;
+            if let Some(sess) = session.as_mut() {
+                sess.set_debug_directories(&settings.debug_file_directory);
+            }
+            Ok(serde_json::to_value(&*settings)?)
+        }
+
+        Command::Settings => Ok(serde_json::to_value(&*settings)?),
+
+        // === Hooks ===
+        Command::HookSet {
+            phase,
+            target,
+            commands,
+        } => {
+            hooks.set(phase, target, commands);
+            Ok(json!({ "hooks": hooks.list() }))
+        }
+
+        Command::HookList => Ok(json!({ "hooks": hooks.list() })),
+
+        Command::EventHandlerSet { handler } => {
+            hooks.set_event(handler);
+            Ok(json!({ "events": hooks.events() }))
+        }
+
+        Command::EventHandlerList => Ok(json!({ "events": hooks.events() })),
+
+        // === Transcript ===
+        Command::TranscriptStart { .. }
+        | Command::TranscriptStop
+        | Command::TranscriptStatus
+        | Command::TranscriptAnnotate { .. }
+        | Command::TranscriptRecord { .. }
+        | Command::RecordingStatus
+        | Command::MacroStart { .. }
+        | Command::MacroStop
+        | Command::MacroRecord { .. }
+        | Command::WatchdogFirings
+        | Command::SampleAdd { .. }
+        | Command::SampleRemove { .. }
+        | Command::SampleList
+        | Command::SampleExport
+        | Command::SampleClear
+        | Command::TrackAdd { .. }
+        | Command::TrackRemove { .. }
+        | Command::TrackList
+        | Command::TrackHistory { .. }
+        | Command::TraceFunctions { .. }
+        | Command::TraceSyscalls { .. }
+        | Command::TraceLog { .. }
+        | Command::TraceStop
+        | Command::TraceClear
+        | Command::RecordStart { .. }
+        | Command::RecordStop
+        | Command::ReplayList
+        | Command::ReplaySeek { .. }
+        | Command::ReplayExit
+        | Command::ProfileStart { .. }
+        | Command::ProfileStop
+        | Command::ProfileData
+        | Command::CoverageStart { .. }
+        | Command::CoverageStop
+        | Command::CoverageData
+        | Command::TimerStart { .. }
+        | Command::TimerStop
+        | Command::TimerData
+        | Command::HeapSnapshot
+        | Command::HeapDiff { .. } => {
+            // The actor owns the transcript so it can also record debuggee
+            // output as events are reduced; macros are recorded beside it,
+            // the watchdog, samplers, tracer, recorder, profiler, coverage
+            // and timer run on its tick, and heap snapshots outlive sessions.
+            Err(Error::Internal(
+                "recording, watchdog, sampling, tracing, profiling, coverage, timer and heap \
+                 commands must be handled by the session actor"
+                    .to_string(),
+            ))
+        }
+
         // === Shutdown ===
         Command::Shutdown => {
             // Signal daemon to exit
@@ -440,11 +948,15 @@ async fn handle_command_inner(
 }
 
 /// Create a JSON response for frame navigation commands
-fn create_frame_response(frame: &crate::dap::StackFrame, index: usize) -> serde_json::Value {
+fn create_frame_response(
+    settings: &Settings,
+    frame: &crate::dap::StackFrame,
+    index: usize,
+) -> serde_json::Value {
     let frame_info = StackFrameInfo {
         id: frame.id,
         name: frame.name.clone(),
-        source: frame.source.as_ref().and_then(|s| s.path.clone()),
+        source: frame_source(settings, frame),
         line: Some(frame.line),
         column: Some(frame.column),
     };
@@ -455,8 +967,100 @@ fn create_frame_response(frame: &crate::dap::StackFrame, index: usize) -> serde_
     })
 }
 
+/// Parse a DAP memory reference or instruction address (`0x...` or decimal)
+fn parse_address(address: &str) -> Option<u64> {
+    match address.strip_prefix("0x").or_else(|| address.strip_prefix("0X")) {
+        Some(hex) => u64::from_str_radix(hex, 16).ok(),
+        None => address.parse().ok(),
+    }
+}
+
+/// `location` with a `@marker:NAME` it names replaced by the marker's line,
+/// found in the source files of the binary's line table, or in the program
+/// itself when it is a script with no symbols to read
+pub(super) fn marker_line(
+    index: Option<&SymbolIndex>,
+    program: &Path,
+    settings: &Settings,
+    location: BreakpointLocation,
+) -> Result<BreakpointLocation> {
+    let BreakpointLocation::Function { name } = &location else {
+        return Ok(location);
+    };
+    let Some(marker) = markers::reference(name) else {
+        return Ok(location);
+    };
+    let (file, line) = match index {
+        Some(index) => index.find_marker(marker, |path| {
+            PathBuf::from(settings.local_path(&path.to_string_lossy()))
+        })?,
+        None => {
+            let found = markers::Markers::read(program).map_err(|_| {
+                Error::InvalidLocation(format!(
+                    "no symbols in {} to find '{}' in",
+                    program.display(),
+                    name
+                ))
+            })?;
+            let location = found.find(marker)?;
+            (location.file.clone(), location.line)
+        }
+    };
+    Ok(BreakpointLocation::Line { file, line })
+}
+
+/// [`marker_line`] for a session's program, whose symbols it caches
+pub(super) fn session_marker_line(
+    sess: &mut DebugSession,
+    settings: &Settings,
+    location: BreakpointLocation,
+) -> Result<BreakpointLocation> {
+    let is_marker = matches!(&location, BreakpointLocation::Function { name }
+        if markers::reference(name).is_some());
+    if !is_marker {
+        return Ok(location);
+    }
+    let program = sess.program().to_path_buf();
+    marker_line(sess.symbols().ok(), &program, settings, location)
+}
+
+/// A variable as the CLI shows it, its value cut to `limit` bytes
+fn variable_info(variable: &crate::dap::Variable, limit: usize) -> VariableInfo {
+    let mut value = variable.value.clone();
+    let truncated_at = bounded(&mut value, limit);
+    VariableInfo {
+        name: variable.name.clone(),
+        value,
+        type_name: variable.type_name.clone(),
+        variables_reference: variable.variables_reference,
+        truncated_at,
+    }
+}
+
+/// A frame's source path, rewritten by `set substitute-path`
+fn frame_source(settings: &Settings, frame: &crate::dap::StackFrame) -> Option<String> {
+    frame
+        .source
+        .as_ref()
+        .and_then(|s| s.path.as_deref())
+        .map(|path| settings.local_path(path))
+}
+
+/// Report a breakpoint's file as a local path
+///
+/// The session keys breakpoints by the adapter's paths so breakpoint events
+/// match; only what the user sees is rewritten.
+fn local_breakpoint(settings: &Settings, mut info: BreakpointInfo) -> BreakpointInfo {
+    info.source = info.source.map(|source| settings.local_path(&source));
+    info
+}
+
 /// Read source file and return lines around the current position
-fn read_source_context(path: &str, current_line: u32, context: usize) -> Result<Vec<SourceLine>> {
+pub(super) fn read_source_context(
+    path: &str,
+    current_line: u32,
+    context: usize,
+) -> Result<Vec<SourceLine>> {
     let content = std::fs::read_to_string(path).map_err(|e| Error::FileRead {
         path: path.to_string(),
         error: e.to_string(),
@@ -501,6 +1105,40 @@ fn tail_output_lines(output: &str, line_count: usize) -> String {
     result
 }
 
+/// Output chunks as whole lines, a line left open continuing in the next
+/// chunk of the same stream
+fn output_lines(events: &[OutputEvent]) -> Vec<OutputLine> {
+    let mut lines: Vec<OutputLine> = Vec::new();
+    // Per stream, the line still waiting for its newline
+    let mut open: HashMap<&str, usize> = HashMap::new();
+    for event in events {
+        for piece in event.output.split_inclusive('\n') {
+            let text = piece.trim_end_matches(['\n', '\r']);
+            let index = match open.get(event.category.as_str()) {
+                Some(&index) => {
+                    lines[index].text.push_str(text);
+                    index
+                }
+                None => {
+                    lines.push(OutputLine {
+                        time: event.time.clone(),
+                        stream: event.category.clone(),
+                        stop: event.stop,
+                        text: text.to_string(),
+                    });
+                    lines.len() - 1
+                }
+            };
+            if piece.ends_with('\n') {
+                open.remove(event.category.as_str());
+            } else {
+                open.insert(&event.category, index);
+            }
+        }
+    }
+    lines
+}
+
 #[cfg(test)]
 mod tests {
     use super::tail_output_lines;
@@ -512,6 +1150,17 @@ mod tests {
         assert_eq!(tail_output_lines("only", 3), "only");
     }
 
+    #[test]
+    fn values_over_the_budget_are_cut_on_a_character_boundary() {
+        let mut value = "ab€cd".to_string();
+        assert_eq!(super::bounded(&mut value, 4), Some(4));
+        assert_eq!(value, "ab");
+        let mut value = "abc".to_string();
+        assert_eq!(super::bounded(&mut value, 3), None);
+        assert_eq!(super::bounded(&mut value, 0), None);
+        assert_eq!(value, "abc");
+    }
+
     #[test]
     fn source_context_handles_adapter_lines_beyond_the_file() {
         let directory = tempfile::tempdir().unwrap();
@@ -522,4 +1171,40 @@ mod tests {
         assert_eq!(context.len(), 2);
         assert!(!context.iter().any(|line| line.is_current));
     }
+
+    #[test]
+    fn output_chunks_become_lines_per_stream() {
+        let event = |category: &str, output: &str, stop| super::OutputEvent {
+            category: category.to_string(),
+            output: output.to_string(),
+            time: format!("t{}", stop),
+            stop,
+        };
+        let lines = super::output_lines(&[
+            event("stdout", "loading", 0),
+            event("stderr", "warning: slow\n", 0),
+            event("stdout", " done\r\nstep 1\n", 1),
+            event("stdout", "step 2\n", 2),
+        ]);
+        let lines: Vec<_> = lines
+            .iter()
+            .map(|line| (line.stream.as_str(), line.stop, line.text.as_str()))
+            .collect();
+        assert_eq!(
+            lines,
+            [
+                ("stdout", 0, "loading done"),
+                ("stderr", 0, "warning: slow"),
+                ("stdout", 1, "step 1"),
+                ("stdout", 2, "step 2"),
+            ]
+        );
+    }
+
+    #[test]
+    fn addresses_parse_as_hex_or_decimal() {
+        assert_eq!(super::parse_address("0x401000"), Some(0x401000));
+        assert_eq!(super::parse_address("4198400"), Some(0x401000));
+        assert_eq!(super::parse_address("main+4"), None);
+    }
 }
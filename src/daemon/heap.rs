@@ -0,0 +1,226 @@
+//! Go heap statistics for `heap snapshot` and `heap diff`
+//!
+//! A snapshot reads every number under the Go runtime's `runtime.memstats`
+//! through the adapter, the same counters `runtime.ReadMemStats` reports
+//! from, without calling into the program. Their names follow the runtime's
+//! own fields, so they change between Go versions. The heap doesn't record
+//! objects' types, but the allocation and free counts per size class
+//! (`by_size`, or `smallAllocCount` and `smallFreeCount` in newer runtimes)
+//! are there. Snapshots belong to the daemon, so a diff can span sessions.
+
+use std::collections::BTreeMap;
+
+use serde_json::{json, Value};
+
+use crate::common::{Error, Result};
+use crate::ipc::protocol::HeapChange;
+
+use super::session::DebugSession;
+
+/// The runtime's statistics
+const ROOT: &str = "runtime.memstats";
+
+/// The GC's ring buffers of pause times, which churn without meaning growth
+const SKIPPED: &[&str] = &["pause_ns", "pause_end"];
+
+/// How deep to follow structs and arrays under the root
+const MAX_DEPTH: usize = 6;
+
+/// Counters kept per snapshot
+const MAX_COUNTERS: usize = 4096;
+
+/// Snapshots kept, the oldest dropped first
+const MAX_SNAPSHOTS: usize = 64;
+
+#[derive(Debug)]
+struct Snapshot {
+    number: u32,
+    /// The session's stop count when it was taken
+    stop: u64,
+    location: Option<String>,
+    counters: BTreeMap<String, i64>,
+}
+
+impl Snapshot {
+    fn summary(&self) -> Value {
+        json!({
+            "snapshot": self.number,
+            "stop": self.stop,
+            "location": self.location,
+            "counters": self.counters.len(),
+        })
+    }
+}
+
+/// The snapshots taken so far
+#[derive(Debug, Default)]
+pub struct Heap {
+    snapshots: Vec<Snapshot>,
+    taken: u32,
+}
+
+impl Heap {
+    /// Read the runtime's statistics at the current stop
+    pub async fn snapshot(&mut self, sess: &mut DebugSession) -> Result<Value> {
+        let root = sess.evaluate(ROOT, None, "watch").await.map_err(|e| {
+            Error::Heap(format!("could not read {} ({}); is this a Go program?", ROOT, e))
+        })?;
+        let mut counters = BTreeMap::new();
+        let mut pending = vec![(String::new(), root.variables_reference, 0)];
+        while let Some((prefix, reference, depth)) = pending.pop() {
+            if reference <= 0 || depth >= MAX_DEPTH {
+                continue;
+            }
+            for variable in sess.get_variables(reference).await? {
+                if SKIPPED.contains(&variable.name.as_str()) {
+                    continue;
+                }
+                let name = child_name(&prefix, &variable.name);
+                if variable.variables_reference > 0 {
+                    pending.push((name, variable.variables_reference, depth + 1));
+                } else if let Some(value) = number(&variable.value) {
+                    if counters.len() < MAX_COUNTERS {
+                        counters.insert(name, value);
+                    }
+                }
+            }
+        }
+        if counters.is_empty() {
+            return Err(Error::Heap(format!("{} has no counters the adapter shows", ROOT)));
+        }
+
+        let location = sess.stack_trace(None, 1).await?.first().map(|frame| {
+            match frame.source.as_ref().and_then(|source| source.path.as_ref()) {
+                Some(path) => format!("{} at {}:{}", frame.name, path, frame.line),
+                None => frame.name.clone(),
+            }
+        });
+        self.taken += 1;
+        let snapshot = Snapshot {
+            number: self.taken,
+            stop: sess.stop_count(),
+            location,
+            counters,
+        };
+        let summary = snapshot.summary();
+        self.snapshots.push(snapshot);
+        if self.snapshots.len() > MAX_SNAPSHOTS {
+            self.snapshots.remove(0);
+        }
+        Ok(summary)
+    }
+
+    /// The counters that changed from one snapshot to another, by how much
+    /// they changed; the last two snapshots unless given
+    pub fn diff(&self, from: Option<u32>, to: Option<u32>) -> Result<Value> {
+        let to = match to {
+            Some(number) => self.get(number)?,
+            None => self.snapshots.last().ok_or_else(|| {
+                Error::Heap("no snapshots yet; take one with 'heap snapshot'".to_string())
+            })?,
+        };
+        let from = match from {
+            Some(number) => self.get(number)?,
+            None => self
+                .snapshots
+                .iter()
+                .rev()
+                .find(|snapshot| snapshot.number < to.number)
+                .ok_or_else(|| {
+                    Error::Heap(format!(
+                        "no snapshot before snapshot {} to compare it with",
+                        to.number
+                    ))
+                })?,
+        };
+
+        let mut changes: Vec<HeapChange> = from
+            .counters
+            .iter()
+            .filter_map(|(counter, before)| {
+                let after = *to.counters.get(counter)?;
+                (after != *before).then(|| HeapChange {
+                    counter: counter.clone(),
+                    before: *before,
+                    after,
+                    change: after.saturating_sub(*before),
+                })
+            })
+            .collect();
+        changes.sort_by(|a, b| {
+            b.change.unsigned_abs().cmp(&a.change.unsigned_abs()).then(a.counter.cmp(&b.counter))
+        });
+        Ok(json!({ "from": from.summary(), "to": to.summary(), "changes": changes }))
+    }
+
+    fn get(&self, number: u32) -> Result<&Snapshot> {
+        self.snapshots.iter().find(|snapshot| snapshot.number == number).ok_or_else(|| {
+            Error::Heap(format!(
+                "no snapshot {}; there are {}",
+                number,
+                self.snapshots
+                    .iter()
+                    .map(|snapshot| snapshot.number.to_string())
+                    .collect::<Vec<_>>()
+                    .join(", ")
+            ))
+        })
+    }
+}
+
+/// `stats[0].inHeap` style names, with array elements as the adapter
+/// names them
+fn child_name(prefix: &str, name: &str) -> String {
+    match (prefix.is_empty(), name.starts_with('[')) {
+        (true, _) | (false, true) => format!("{}{}", prefix, name),
+        (false, false) => format!("{}.{}", prefix, name),
+    }
+}
+
+/// A whole number as adapters render one; pointers and floats aren't
+/// counters
+fn number(value: &str) -> Option<i64> {
+    value.split_whitespace().next()?.parse().ok()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn snapshot(number: u32, counters: &[(&str, i64)]) -> Snapshot {
+        Snapshot {
+            number,
+            stop: number as u64,
+            location: None,
+            counters: counters.iter().map(|(name, value)| (name.to_string(), *value)).collect(),
+        }
+    }
+
+    #[test]
+    fn diffs_show_the_largest_changes_first() {
+        let mut heap = Heap::default();
+        assert!(heap.diff(None, None).is_err());
+        heap.snapshots.push(snapshot(1, &[("heap_alloc", 4096), ("numgc", 2), ("sys", 100)]));
+        assert!(heap.diff(None, None).is_err());
+        heap.snapshots.push(snapshot(
+            2,
+            &[("heap_alloc", 1024), ("numgc", 3), ("sys", 100), ("by_size[3].nmalloc", 9)],
+        ));
+
+        let diff = heap.diff(None, None).unwrap();
+        assert_eq!((&diff["from"]["snapshot"], &diff["to"]["snapshot"]), (&json!(1), &json!(2)));
+        let changes: Vec<HeapChange> = serde_json::from_value(diff["changes"].clone()).unwrap();
+        let changes: Vec<(&str, i64)> =
+            changes.iter().map(|change| (change.counter.as_str(), change.change)).collect();
+        assert_eq!(changes, [("heap_alloc", -3072), ("numgc", 1)]);
+        assert!(heap.diff(Some(2), Some(1)).is_ok());
+        assert!(heap.diff(Some(7), None).is_err());
+
+        assert_eq!(child_name("", "heapStats"), "heapStats");
+        assert_eq!(child_name("heapStats.stats", "[0]"), "heapStats.stats[0]");
+        assert_eq!(child_name("heapStats.stats[0]", "inHeap"), "heapStats.stats[0].inHeap");
+        assert_eq!(number("123"), Some(123));
+        assert_eq!(number("0xc000012000"), None);
+        assert_eq!(number("0.25"), None);
+    }
+}
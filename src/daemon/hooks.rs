@@ -0,0 +1,161 @@
+//! Command hooks
+//!
+//! The daemon only stores hook command lists; the CLI fetches and runs them
+//! around the commands it dispatches. Keeping them here means a hook defined
+//! once (for example from `.dbginit`) applies to every later invocation.
+//! Event handlers (`on stop`, `on signal SIGSEGV`) are stored the same way,
+//! seeded from the config file's `[[events]]`.
+
+use std::collections::BTreeMap;
+
+use clap::ValueEnum;
+
+use crate::common::config::Config;
+use crate::ipc::protocol::{EventHandlerInfo, EventKind, HookInfo, HookPhase};
+
+/// Hook command lists keyed by phase and target, and event handlers
+#[derive(Debug, Default)]
+pub struct Hooks {
+    lists: BTreeMap<(HookPhase, String), Vec<String>>,
+    events: Vec<EventHandlerInfo>,
+}
+
+impl Hooks {
+    /// Hooks with the event handlers declared in the config file
+    ///
+    /// Entries that do not name a known event are logged and skipped, so a
+    /// typo in the config does not keep the daemon from starting.
+    pub fn from_config(config: &Config) -> Self {
+        let mut hooks = Self::default();
+        for entry in &config.events {
+            let (event, filter) = match parse_event(&entry.on) {
+                Ok(parsed) => parsed,
+                Err(e) => {
+                    tracing::warn!("Ignoring [[events]] entry '{}': {}", entry.on, e);
+                    continue;
+                }
+            };
+            for (shell, commands) in [(false, &entry.commands), (true, &entry.shell)] {
+                if !commands.is_empty() {
+                    hooks.set_event(EventHandlerInfo {
+                        event,
+                        filter: filter.clone(),
+                        shell,
+                        commands: commands.clone(),
+                    });
+                }
+            }
+        }
+        hooks
+    }
+
+    /// Replace a hook's commands; an empty list removes the hook
+    pub fn set(&mut self, phase: HookPhase, target: String, commands: Vec<String>) {
+        if commands.is_empty() {
+            self.lists.remove(&(phase, target));
+        } else {
+            self.lists.insert((phase, target), commands);
+        }
+    }
+
+    /// All hooks, ordered by phase and then target
+    pub fn list(&self) -> Vec<HookInfo> {
+        self.lists
+            .iter()
+            .map(|((phase, target), commands)| HookInfo {
+                phase: *phase,
+                target: target.clone(),
+                commands: commands.clone(),
+            })
+            .collect()
+    }
+
+    /// Replace the handler for an event, filter and kind of command; an empty
+    /// command list removes it
+    pub fn set_event(&mut self, handler: EventHandlerInfo) {
+        let existing = self.events.iter().position(|h| {
+            h.event == handler.event && h.filter == handler.filter && h.shell == handler.shell
+        });
+        match (existing, handler.commands.is_empty()) {
+            (Some(index), true) => {
+                self.events.remove(index);
+            }
+            (Some(index), false) => self.events[index] = handler,
+            (None, true) => {}
+            (None, false) => self.events.push(handler),
+        }
+    }
+
+    /// Event handlers in the order they were set
+    pub fn events(&self) -> &[EventHandlerInfo] {
+        &self.events
+    }
+}
+
+/// Parse an event as written after `on`, e.g. `breakpoint worker_start`
+fn parse_event(spec: &str) -> Result<(EventKind, Option<String>), String> {
+    let mut words = spec.split_whitespace();
+    let name = words.next().ok_or("no event given")?;
+    let event = EventKind::from_str(name, true).map_err(|_| {
+        format!("unknown event '{}'; events are stop, breakpoint, exit, signal", name)
+    })?;
+
+    let filter = words.collect::<Vec<_>>().join(" ");
+    match (event.takes_filter(), filter.is_empty()) {
+        (true, true) => Err(format!("'{}' needs a name, or '*' for any", event)),
+        (true, false) => Ok((event, Some(filter))),
+        (false, true) => Ok((event, None)),
+        (false, false) => Err(format!("'{}' takes no name", event)),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn empty_list_removes_hook() {
+        let mut hooks = Hooks::default();
+        hooks.set(HookPhase::Post, "stop".into(), vec!["print x".into()]);
+        hooks.set(HookPhase::Pre, "continue".into(), vec!["locals".into()]);
+        assert_eq!(hooks.list().len(), 2);
+        assert_eq!(hooks.list()[0].phase, HookPhase::Pre);
+
+        hooks.set(HookPhase::Post, "stop".into(), Vec::new());
+        let remaining = hooks.list();
+        assert_eq!(remaining.len(), 1);
+        assert_eq!(remaining[0].target, "continue");
+    }
+
+    #[test]
+    fn event_handlers_replace_by_event_filter_and_kind() {
+        let handler = |filter: Option<&str>, shell, commands: &[&str]| EventHandlerInfo {
+            event: EventKind::Signal,
+            filter: filter.map(String::from),
+            shell,
+            commands: commands.iter().map(|c| c.to_string()).collect(),
+        };
+        let mut hooks = Hooks::default();
+        hooks.set_event(handler(Some("SIGSEGV"), false, &["backtrace"]));
+        hooks.set_event(handler(Some("SIGSEGV"), true, &["notify-send crash"]));
+        hooks.set_event(handler(Some("SIGSEGV"), false, &["locals"]));
+        assert_eq!(hooks.events().len(), 2);
+        assert_eq!(hooks.events()[0].commands, vec!["locals"]);
+
+        hooks.set_event(handler(Some("SIGSEGV"), true, &[]));
+        assert_eq!(hooks.events().len(), 1);
+        assert!(!hooks.events()[0].shell);
+    }
+
+    #[test]
+    fn parse_event_checks_filters() {
+        assert_eq!(parse_event("stop").unwrap(), (EventKind::Stop, None));
+        assert_eq!(
+            parse_event("Signal SIGSEGV").unwrap(),
+            (EventKind::Signal, Some("SIGSEGV".to_string()))
+        );
+        assert!(parse_event("breakpoint").is_err());
+        assert!(parse_event("exit 0").is_err());
+        assert!(parse_event("crash").is_err());
+    }
+}
@@ -4,9 +4,27 @@
 //! persistent debug sessions across CLI invocations.
 
 mod actor;
+mod btrace;
+mod coverage;
+mod crash;
 mod handler;
+mod heap;
+mod hooks;
+mod modules;
+mod profile;
+mod relocation;
+mod replay;
+mod samples;
 mod server;
 mod session;
+mod syscalls;
+mod timeline;
+mod timer;
+mod trace;
+mod tracks;
+mod transcript;
+mod watchdog;
+mod watches;
 
 use crate::common::Result;
 
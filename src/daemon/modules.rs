@@ -0,0 +1,343 @@
+//! Shared libraries whose symbols are read after `attach`
+//!
+//! GDB reads the symbols of every shared library as it attaches, which in a
+//! process with hundreds of them keeps `attach` waiting for minutes. An
+//! attaching GDB session is told to leave them (`set auto-solib-add off`),
+//! and the actor reads one library at a time on its tick while the program is
+//! stopped, so commands are answered in between and breakpoints in a library
+//! resolve once it has been read. The libraries are listed again once all of
+//! them have been asked for, and GDB is then told to read the ones the
+//! program loads from then on itself (`set auto-solib-add on`), so a pending
+//! breakpoint in a library loaded later resolves as it loads. lldb is told
+//! to read a module's debug info when a breakpoint, frame or lookup first
+//! needs it (`symbols.load-on-demand`). `modules` shows how far each module
+//! has got.
+
+use std::path::Path;
+
+use crate::common::Result;
+use crate::dap::Module;
+use crate::ipc::protocol::{ModuleInfo, SymbolState};
+
+use super::session::{DebugSession, SessionState};
+
+/// Sent to GDB before it attaches
+pub const GDB_DEFER: &str = "set auto-solib-add off";
+
+/// Sent to GDB once the libraries loaded at attach have been read
+const GDB_RESUME: &str = "set auto-solib-add on";
+
+/// Sent to lldb before it attaches
+pub const LLDB_DEFER: &str = "settings set symbols.load-on-demand true";
+
+/// The libraries an attached GDB has been told to leave unread
+#[derive(Debug)]
+pub struct DeferredSymbols {
+    /// In the order GDB lists them, which is the order they were loaded
+    libraries: Vec<Library>,
+    /// Set when the program may have loaded libraries since they were listed
+    stale: bool,
+    /// Set when libraries have been asked for since they were listed
+    unchecked: bool,
+    /// Set once GDB reads new libraries' symbols itself again
+    resumed: bool,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+struct Library {
+    path: String,
+    state: SymbolState,
+}
+
+impl DeferredSymbols {
+    pub fn new() -> Self {
+        Self {
+            libraries: Vec::new(),
+            stale: true,
+            unchecked: false,
+            resumed: false,
+        }
+    }
+
+    /// The program ran, and may have loaded more libraries
+    pub fn ran(&mut self) {
+        self.stale = true;
+    }
+
+    /// Libraries still to be read
+    pub fn pending(&self) -> usize {
+        self.libraries
+            .iter()
+            .filter(|library| library.state == SymbolState::Deferred)
+            .count()
+    }
+
+    /// Whether the libraries must be listed again to know their states
+    fn needs_listing(&self) -> bool {
+        self.stale || self.unchecked
+    }
+
+    fn next(&self) -> Option<String> {
+        self.libraries
+            .iter()
+            .find(|library| library.state == SymbolState::Deferred)
+            .map(|library| library.path.clone())
+    }
+
+    fn attempted(&mut self, path: &str) {
+        if let Some(library) = self.libraries.iter_mut().find(|library| library.path == path) {
+            library.state = SymbolState::Failed;
+        }
+        self.unchecked = true;
+    }
+
+    /// Take in `info sharedlibrary`. A library still unread after it was
+    /// asked for stays failed, so it isn't asked for on every tick.
+    fn update(&mut self, listing: &str) {
+        let previous = std::mem::take(&mut self.libraries);
+        self.libraries = listing
+            .lines()
+            .filter_map(library)
+            .map(|mut library| {
+                let failed = previous.iter().any(|before| {
+                    before.path == library.path && before.state == SymbolState::Failed
+                });
+                if failed && library.state == SymbolState::Deferred {
+                    library.state = SymbolState::Failed;
+                }
+                library
+            })
+            .collect();
+        self.stale = false;
+        self.unchecked = false;
+    }
+}
+
+/// Read the next library's symbols. The libraries are listed first if the
+/// program has run since they were listed, and again once each has been
+/// asked for, when GDB is told to read new ones itself. Does nothing while
+/// the program runs, or once GDB reads them itself.
+pub async fn load_next(session: &mut Option<DebugSession>) {
+    let Some(sess) = session.as_mut() else {
+        return;
+    };
+    if sess.state() != SessionState::Stopped {
+        return;
+    }
+    let Some(deferred) = sess.deferred_symbols().filter(|deferred| !deferred.resumed) else {
+        return;
+    };
+    let next = if deferred.stale { None } else { deferred.next() };
+    let Some(path) = next else {
+        if !deferred.needs_listing() {
+            return;
+        }
+        if let Err(e) = refresh(sess).await {
+            tracing::debug!("Could not list the shared libraries: {}", e);
+        } else if sess.deferred_symbols().is_some_and(|deferred| deferred.pending() == 0) {
+            resume(sess).await;
+        }
+        return;
+    };
+    if let Some(deferred) = sess.deferred_symbols_mut() {
+        deferred.attempted(&path);
+    }
+    tracing::debug!("Reading the symbols of {}", path);
+    let command = format!("sharedlibrary {}", pattern(&path));
+    if let Err(e) = sess.evaluate(&command, None, "repl").await {
+        tracing::debug!("Could not read the symbols of {}: {}", path, e);
+    }
+}
+
+/// Have GDB read the symbols of libraries the program loads from now on
+async fn resume(sess: &mut DebugSession) {
+    match sess.evaluate(GDB_RESUME, None, "repl").await {
+        Ok(_) => {
+            if let Some(deferred) = sess.deferred_symbols_mut() {
+                deferred.resumed = true;
+            }
+        }
+        Err(e) => tracing::debug!("Could not have GDB read new libraries itself: {}", e),
+    }
+}
+
+/// The session's modules, with the libraries still to be read among them
+pub async fn list(sess: &mut DebugSession) -> Result<Vec<ModuleInfo>> {
+    if sess.state() == SessionState::Stopped
+        && sess.deferred_symbols().is_some_and(DeferredSymbols::needs_listing)
+    {
+        refresh(sess).await?;
+    }
+    let gdb = matches!(sess.adapter_name(), "gdb" | "cuda-gdb");
+    let mut modules: Vec<ModuleInfo> = sess
+        .modules()
+        .await?
+        .into_iter()
+        .map(|module| module_info(module, gdb))
+        .collect();
+    let libraries = sess.deferred_symbols().map(|deferred| deferred.libraries.as_slice());
+    for library in libraries.unwrap_or_default() {
+        match modules.iter_mut().find(|module| module.path.as_ref() == Some(&library.path)) {
+            Some(module) => module.symbols = library.state,
+            None => modules.push(ModuleInfo {
+                name: file_name(&library.path),
+                path: Some(library.path.clone()),
+                address_range: None,
+                symbols: library.state,
+            }),
+        }
+    }
+    Ok(modules)
+}
+
+async fn refresh(sess: &mut DebugSession) -> Result<()> {
+    let listing = sess.evaluate("info sharedlibrary", None, "repl").await?.result;
+    if let Some(deferred) = sess.deferred_symbols_mut() {
+        deferred.update(&listing);
+    }
+    Ok(())
+}
+
+/// A module as the adapter reports it. GDB only lists files whose symbols it
+/// has read, and says nothing of their state.
+fn module_info(module: Module, gdb: bool) -> ModuleInfo {
+    let symbols = match module.symbol_status.as_deref().map(str::to_ascii_lowercase) {
+        Some(status) if status.contains("not found") || status.contains("no symbols") => {
+            SymbolState::NoDebugInfo
+        }
+        Some(status) if status.contains("loaded") => SymbolState::Loaded,
+        None if gdb => SymbolState::Loaded,
+        _ => SymbolState::Unknown,
+    };
+    ModuleInfo {
+        name: module.name,
+        path: module.path,
+        address_range: module.address_range,
+        symbols,
+    }
+}
+
+/// A line of `info sharedlibrary`, like
+/// `0x00007ffff7dbc700  0x00007ffff7f4e93d  Yes (*)     /lib/libc.so.6`;
+/// a library whose symbols are unread has no addresses
+fn library(line: &str) -> Option<Library> {
+    let mut rest = line.trim_start();
+    while let Some(address) = rest.strip_prefix("0x") {
+        rest = address.trim_start_matches(|c: char| c.is_ascii_hexdigit()).trim_start();
+    }
+    let (state, path) = if let Some(path) = rest.strip_prefix("Yes (*)") {
+        (SymbolState::NoDebugInfo, path)
+    } else if let Some(path) = rest.strip_prefix("Yes") {
+        (SymbolState::Loaded, path)
+    } else if let Some(path) = rest.strip_prefix("No") {
+        (SymbolState::Deferred, path)
+    } else {
+        return None;
+    };
+    // The header's "Shared Object Library" doesn't start with a space
+    if !path.starts_with(char::is_whitespace) {
+        return None;
+    }
+    let path = path.trim();
+    (!path.is_empty()).then(|| Library {
+        path: path.to_string(),
+        state,
+    })
+}
+
+/// A regex for `sharedlibrary` that matches only `path`. GDB's regexes
+/// take `(`, `|` and `{` literally unless escaped.
+fn pattern(path: &str) -> String {
+    let mut pattern = String::from("^");
+    for c in path.chars() {
+        if matches!(c, '\\' | '.' | '*' | '+' | '?' | '[' | '^' | '$') {
+            pattern.push('\\');
+        }
+        pattern.push(c);
+    }
+    pattern.push('$');
+    pattern
+}
+
+fn file_name(path: &str) -> String {
+    Path::new(path)
+        .file_name()
+        .map(|name| name.to_string_lossy().into_owned())
+        .unwrap_or_else(|| path.to_string())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const LISTING: &str = "\
+From                To                  Syms Read   Shared Object Library
+0x00007ffff7fc5090  0x00007ffff7fee335  Yes         /lib64/ld-linux-x86-64.so.2
+                                        No          /lib/x86_64-linux-gnu/libm.so.6
+0x00007ffff7dbc700  0x00007ffff7f4e93d  Yes (*)     /lib/x86_64-linux-gnu/libc.so.6
+                                        No          /opt/app/lib/libplugin++.so
+(*): Shared library is missing debugging information.
+";
+
+    #[test]
+    fn unread_libraries_are_read_in_order_and_failures_not_retried() {
+        let mut deferred = DeferredSymbols::new();
+        assert!(deferred.stale);
+        deferred.update(LISTING);
+        assert!(!deferred.stale);
+        let states: Vec<_> = deferred
+            .libraries
+            .iter()
+            .map(|library| (file_name(&library.path), library.state))
+            .collect();
+        assert_eq!(
+            states,
+            [
+                ("ld-linux-x86-64.so.2".to_string(), SymbolState::Loaded),
+                ("libm.so.6".to_string(), SymbolState::Deferred),
+                ("libc.so.6".to_string(), SymbolState::NoDebugInfo),
+                ("libplugin++.so".to_string(), SymbolState::Deferred),
+            ]
+        );
+        assert_eq!(deferred.pending(), 2);
+        assert_eq!(deferred.next().as_deref(), Some("/lib/x86_64-linux-gnu/libm.so.6"));
+
+        // Both are asked for before they are listed again: libm is read, and
+        // GDB can't read the plugin
+        deferred.attempted("/lib/x86_64-linux-gnu/libm.so.6");
+        assert!(deferred.needs_listing());
+        assert_eq!(deferred.next().as_deref(), Some("/opt/app/lib/libplugin++.so"));
+        deferred.attempted("/opt/app/lib/libplugin++.so");
+        assert_eq!(deferred.next(), None);
+        deferred.update(&LISTING.replacen("No  ", "Yes ", 1));
+        assert!(!deferred.needs_listing());
+        assert_eq!(deferred.pending(), 0);
+        assert_eq!(deferred.libraries[1].state, SymbolState::Loaded);
+        assert_eq!(deferred.libraries[3].state, SymbolState::Failed);
+
+        deferred.ran();
+        assert!(deferred.stale);
+    }
+
+    #[test]
+    fn patterns_match_only_the_library() {
+        assert_eq!(pattern("/lib/libc.so.6"), r"^/lib/libc\.so\.6$");
+        assert_eq!(pattern("/opt/lib(x)/libg++.so"), r"^/opt/lib(x)/libg\+\+\.so$");
+    }
+
+    #[test]
+    fn states_come_from_the_adapters_words() {
+        let module = |status: Option<&str>| Module {
+            id: serde_json::json!(1),
+            name: "libc.so.6".to_string(),
+            path: None,
+            address_range: None,
+            symbol_status: status.map(str::to_string),
+        };
+        assert_eq!(module_info(module(Some("Symbols loaded.")), false).symbols, SymbolState::Loaded);
+        let missing = module_info(module(Some("Symbols not found.")), false);
+        assert_eq!(missing.symbols, SymbolState::NoDebugInfo);
+        assert_eq!(module_info(module(None), true).symbols, SymbolState::Loaded);
+        assert_eq!(module_info(module(None), false).symbols, SymbolState::Unknown);
+    }
+}
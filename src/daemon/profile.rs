@@ -0,0 +1,175 @@
+//! Stack sampling for `profile`
+//!
+//! While a profile runs the actor interrupts the program `hz` times a second,
+//! takes every thread's stack and resumes it, counting how often each stack
+//! was seen for `profile flamegraph`. Like the samplers', these pauses never
+//! reach `await` or the stop history. Threads are counted whether they run or
+//! wait, so the profile shows where wall-clock time goes rather than CPU time.
+//! The profile outlives the session, so it can be drawn after the program
+//! exits.
+
+use std::collections::HashMap;
+use std::time::Duration;
+
+use serde_json::{json, Value};
+use tokio::time::Instant;
+
+use crate::common::{Error, Result};
+use crate::ipc::protocol::ProfileStack;
+use crate::symbols::base_name;
+
+use super::session::{DebugSession, SessionState};
+
+/// The fastest sampling rate; every sample pauses the program
+pub const MAX_HZ: u32 = 1000;
+
+/// Frames kept per stack, counted from the innermost
+const MAX_FRAMES: usize = 128;
+
+#[derive(Debug)]
+struct Active {
+    every: Duration,
+    due: Instant,
+}
+
+/// The stacks seen so far and, while profiling, when to look next
+#[derive(Debug, Default)]
+pub struct Profiler {
+    active: Option<Active>,
+    hz: u32,
+    /// Times the program was paused
+    samples: u64,
+    stacks: HashMap<Vec<String>, u64>,
+}
+
+impl Profiler {
+    /// Start sampling `hz` times a second, dropping the previous profile
+    pub fn start(&mut self, hz: u32) -> Result<()> {
+        if !(1..=MAX_HZ).contains(&hz) {
+            return Err(Error::Profile(format!("sample between 1 and {} times a second", MAX_HZ)));
+        }
+        self.active = Some(Active {
+            every: Duration::from_secs(1) / hz,
+            due: Instant::now(),
+        });
+        self.hz = hz;
+        self.samples = 0;
+        self.stacks.clear();
+        Ok(())
+    }
+
+    /// Stop sampling, keeping the profile; false if it was not running
+    pub fn stop(&mut self) -> bool {
+        self.active.take().is_some()
+    }
+
+    /// When the next sample is due, if profiling
+    pub fn due(&self) -> Option<Instant> {
+        self.active.as_ref().map(|active| active.due)
+    }
+
+    /// Interrupt the program to take every thread's stack, if a sample is due
+    pub async fn sample(&mut self, session: &mut Option<DebugSession>) {
+        let now = Instant::now();
+        let Some(active) = self.active.as_mut().filter(|active| active.due <= now) else {
+            return;
+        };
+        // A slow sample pushes the next one back rather than bunching them up
+        active.due = (active.due + active.every).max(now);
+        let Some(sess) = session.as_mut() else {
+            return;
+        };
+        if sess.state() != SessionState::Running {
+            return;
+        }
+
+        let interrupted = match sess.interrupt(DebugSession::INTERRUPT_TIMEOUT).await {
+            Ok(interrupted) => interrupted,
+            Err(e) => {
+                tracing::warn!("Could not interrupt the program to profile: {}", e);
+                return;
+            }
+        };
+        if interrupted {
+            match sess.get_threads().await {
+                Ok(threads) => {
+                    self.samples += 1;
+                    for thread in threads {
+                        match sess.stack_trace(Some(thread.id), MAX_FRAMES).await {
+                            Ok(frames) => {
+                                let names = frames.iter().rev().map(|frame| base_name(&frame.name));
+                                self.count(names)
+                            }
+                            Err(e) => tracing::debug!("No stack for thread {}: {}", thread.id, e),
+                        }
+                    }
+                }
+                Err(e) => tracing::warn!("Could not list the threads to profile: {}", e),
+            }
+            if let Err(e) = sess.continue_execution().await {
+                tracing::warn!("Could not resume the program after profiling: {}", e);
+            }
+        }
+    }
+
+    /// Count one sighting of a stack, given outermost first
+    fn count<'a>(&mut self, frames: impl Iterator<Item = &'a str>) {
+        let frames: Vec<String> = frames.map(String::from).collect();
+        if !frames.is_empty() {
+            *self.stacks.entry(frames).or_default() += 1;
+        }
+    }
+
+    /// The profile for `profile flamegraph`, stacks in order
+    pub fn data(&self) -> Value {
+        let mut stacks: Vec<ProfileStack> = self
+            .stacks
+            .iter()
+            .map(|(frames, count)| ProfileStack { frames: frames.clone(), count: *count })
+            .collect();
+        stacks.sort_by(|a, b| a.frames.cmp(&b.frames));
+        json!({
+            "profiling": self.active.is_some(),
+            "hz": self.hz,
+            "samples": self.samples,
+            "stacks": stacks,
+        })
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn stacks_are_counted_and_restarting_drops_them() {
+        let mut profiler = Profiler::default();
+        assert!(profiler.start(0).is_err());
+        profiler.start(99).unwrap();
+        assert_eq!(profiler.active.as_ref().unwrap().every, Duration::from_nanos(10_101_010));
+
+        profiler.count(["main", "parse"].into_iter());
+        profiler.count(["main"].into_iter());
+        profiler.count(["main", "parse"].into_iter());
+        profiler.count([].into_iter());
+
+        let data = profiler.data();
+        let stacks: Vec<ProfileStack> = serde_json::from_value(data["stacks"].clone()).unwrap();
+        assert_eq!(
+            stacks,
+            [
+                ProfileStack { frames: vec!["main".into()], count: 1 },
+                ProfileStack { frames: vec!["main".into(), "parse".into()], count: 2 },
+            ]
+        );
+        assert_eq!(data["profiling"], true);
+
+        assert!(profiler.stop());
+        assert!(!profiler.stop());
+        assert!(profiler.due().is_none());
+        assert_eq!(profiler.data()["stacks"].as_array().unwrap().len(), 2);
+
+        profiler.start(10).unwrap();
+        assert!(profiler.stacks.is_empty());
+    }
+}
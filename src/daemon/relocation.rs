@@ -0,0 +1,253 @@
+//! Where a position-independent program was loaded, for `break *ADDRESS`
+//! and `info proc slide`
+//!
+//! A PIE is linked at or near 0 and loaded wherever ASLR puts it, so an
+//! address read from the binary, as `dwarf`, `objdump` or a log give them,
+//! only names an instruction in the process once the slide, the address it
+//! was loaded at minus the address it was linked at, is added. An address
+//! breakpoint given with `start` is kept at its address in the binary and
+//! sent to the adapter once the slide is known, which is by the first stop;
+//! one given once the program runs is an address in the process, as a
+//! backtrace shows it, and is refused until the slide is known. The load
+//! address comes from `/proc/PID/maps` for
+//! a local program, from GDB's `info proc mappings` for a remote stub or a
+//! core dump, or from the adapter's modules; `core open --slide` gives it
+//! when none of them can.
+
+use std::fs;
+use std::path::Path;
+
+use object::{Object, ObjectSection, ObjectSegment};
+
+use crate::common::{Error, Result};
+use crate::dap::Module;
+use crate::ipc::protocol::{SlideInfo, SlideSource};
+use crate::symbols;
+
+use super::crash::map_entry;
+use super::session::{DebugSession, SessionState};
+
+/// Mappings start on a page
+const PAGE: u64 = 0x1000;
+
+/// The slide of a binary that only loads where it was linked, or `None`
+/// for a position-independent one, whose slide is known once it is loaded
+pub fn fixed(binary: &Path) -> Option<SlideInfo> {
+    let (pie, linked_at) = layout(binary)?;
+    (!pie).then_some(SlideInfo {
+        slide: 0,
+        linked_at,
+        loaded_at: linked_at,
+        pie,
+        source: SlideSource::Binary,
+    })
+}
+
+/// The slide given with `core open --slide`
+pub fn given(binary: &Path, slide: u64) -> SlideInfo {
+    let (pie, linked_at) = layout(binary).unwrap_or((true, 0));
+    SlideInfo {
+        slide,
+        linked_at,
+        loaded_at: linked_at.wrapping_add(slide),
+        pie,
+        source: SlideSource::Given,
+    }
+}
+
+/// The program's slide, worked out the first time it is asked for once the
+/// program is loaded, when the address breakpoints waiting for it are sent
+pub async fn slide(sess: &mut DebugSession) -> Result<SlideInfo> {
+    if let Some(slide) = sess.slide() {
+        return Ok(slide);
+    }
+    let slide = find(sess).await?;
+    sess.set_slide(slide).await?;
+    Ok(slide)
+}
+
+/// Send the address breakpoints waiting for the slide, once per stop until
+/// it is known. Does nothing while the program runs.
+pub async fn rebase(session: &mut Option<DebugSession>) {
+    let Some(sess) = session.as_mut() else {
+        return;
+    };
+    if sess.state() != SessionState::Stopped || !sess.take_slide_attempt() {
+        return;
+    }
+    match slide(sess).await {
+        Ok(slide) => tracing::debug!("Address breakpoints moved by {:#x}", slide.slide),
+        Err(e) => tracing::debug!("Address breakpoints wait for the slide: {}", e),
+    }
+}
+
+async fn find(sess: &mut DebugSession) -> Result<SlideInfo> {
+    let binary = symbols::binary_path(sess.program());
+    let (pie, linked_at) = layout(&binary)
+        .ok_or_else(|| Error::Symbols(format!("cannot read {}", binary.display())))?;
+    // An attached program's maps name the file /proc/<pid>/exe links to
+    let binary = fs::canonicalize(&binary).unwrap_or(binary);
+
+    let mut found = None;
+    if let Some(pid) = sess.process_id() {
+        if let Ok(maps) = fs::read_to_string(format!("/proc/{}/maps", pid)) {
+            found = maps_load_address(&maps, &binary).map(|at| (at, SlideSource::ProcMaps));
+        }
+    }
+    let gdb = matches!(sess.adapter_name(), "gdb" | "cuda-gdb");
+    if found.is_none() && gdb {
+        if let Ok(listing) = sess.evaluate("info proc mappings", None, "repl").await {
+            found = mappings_load_address(&listing.result, &binary)
+                .map(|at| (at, SlideSource::Mappings));
+        }
+    }
+    if found.is_none() {
+        // GDB's modules give where their code starts, not their first page
+        let code = if gdb { code_offset(&binary, linked_at) } else { Some(0) };
+        if let (Some(code), Ok(modules)) = (code, sess.modules().await) {
+            found = module_load_address(&modules, &binary)
+                .and_then(|at| at.checked_sub(code))
+                .map(|at| (at, SlideSource::Modules));
+        }
+    }
+
+    let (loaded_at, source) = found.ok_or_else(|| {
+        Error::Symbols(format!(
+            "no load address for {} until the program stops; \
+             a core dump's can be given with 'core open --slide'",
+            binary.display()
+        ))
+    })?;
+    Ok(SlideInfo {
+        slide: loaded_at.wrapping_sub(linked_at),
+        linked_at,
+        loaded_at,
+        pie,
+        source,
+    })
+}
+
+/// Whether the binary is position-independent, and the lowest page it was
+/// linked at
+fn layout(binary: &Path) -> Option<(bool, u64)> {
+    let data = object::ReadCache::new(fs::File::open(binary).ok()?);
+    let file = object::File::parse(&data).ok()?;
+    let pie = match file.flags() {
+        object::FileFlags::MachO { flags } => flags & object::macho::MH_PIE != 0,
+        _ => file.kind() == object::ObjectKind::Dynamic,
+    };
+    // Mach-O's __PAGEZERO maps nothing from the file
+    let linked_at = file
+        .segments()
+        .filter(|segment| segment.file_range().1 > 0)
+        .map(|segment| segment.address())
+        .min()
+        .unwrap_or(0);
+    Some((pie, linked_at & !(PAGE - 1)))
+}
+
+/// How far past the first page the binary's code was linked
+fn code_offset(binary: &Path, linked_at: u64) -> Option<u64> {
+    let data = object::ReadCache::new(fs::File::open(binary).ok()?);
+    let file = object::File::parse(&data).ok()?;
+    let text = file.section_by_name(".text").or_else(|| file.section_by_name("__text"))?;
+    text.address().checked_sub(linked_at)
+}
+
+/// Where `/proc/PID/maps` has the start of the binary
+fn maps_load_address(maps: &str, binary: &Path) -> Option<u64> {
+    maps.lines()
+        .filter_map(map_entry)
+        .filter(|entry| entry.offset == 0 && same_file(entry.path, binary))
+        .map(|entry| entry.start)
+        .min()
+}
+
+/// Where GDB's `info proc mappings` has the start of the binary, from lines
+/// like `0x555555554000 0x555555555000 0x1000 0x0 r--p /usr/bin/app`; GDB
+/// before 12 leaves out the permissions
+fn mappings_load_address(listing: &str, binary: &Path) -> Option<u64> {
+    let hex = |text: &str| u64::from_str_radix(text.strip_prefix("0x")?, 16).ok();
+    listing
+        .lines()
+        .filter_map(|line| {
+            let fields: Vec<&str> = line.split_whitespace().collect();
+            let (start, offset, path) = (fields.first()?, fields.get(3)?, fields.last()?);
+            let ours = fields.len() >= 5 && hex(offset) == Some(0) && same_file(path, binary);
+            ours.then(|| hex(start)).flatten()
+        })
+        .min()
+}
+
+/// Where the adapter's module for the binary starts: lldb gives its header's
+/// address as its range
+fn module_load_address(modules: &[Module], binary: &Path) -> Option<u64> {
+    modules
+        .iter()
+        .filter(|module| module.path.as_deref().is_some_and(|path| same_file(path, binary)))
+        .find_map(|module| {
+            let range = module.address_range.as_deref()?.trim();
+            let hex = range.strip_prefix("0x").unwrap_or(range);
+            let end = hex.find(|c: char| !c.is_ascii_hexdigit()).unwrap_or(hex.len());
+            u64::from_str_radix(&hex[..end], 16).ok()
+        })
+}
+
+fn same_file(path: &str, binary: &Path) -> bool {
+    let path = Path::new(path);
+    path == binary || fs::canonicalize(path).is_ok_and(|path| path == binary)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn load_addresses_are_where_the_binary_starts() {
+        let binary = Path::new("/usr/bin/app");
+        let maps = "\
+555555554000-555555555000 r--p 00000000 08:01 1234                       /usr/bin/app
+555555555000-555555556000 r-xp 00001000 08:01 1234                       /usr/bin/app
+7ffff7dbc000-7ffff7de2000 r--p 00000000 08:01 99                         /lib/libc.so.6
+7ffffffde000-7ffffffff000 rw-p 00000000 00:00 0                          [stack]
+";
+        assert_eq!(maps_load_address(maps, binary), Some(0x5555_5555_4000));
+        assert_eq!(maps_load_address(maps, Path::new("/usr/bin/other")), None);
+
+        let gdb = "\
+process 4242
+Mapped address spaces:
+
+          Start Addr           End Addr       Size     Offset  Perms  objfile
+      0x555555554000     0x555555555000     0x1000        0x0  r--p   /usr/bin/app
+      0x555555555000     0x555555556000     0x1000     0x1000  r-xp   /usr/bin/app
+";
+        assert_eq!(mappings_load_address(gdb, binary), Some(0x5555_5555_4000));
+        let old = "      0x555555554000     0x555555555000     0x1000        0x0 /usr/bin/app";
+        assert_eq!(mappings_load_address(old, binary), Some(0x5555_5555_4000));
+
+        let module = |path: &str, range: &str| Module {
+            id: serde_json::json!(1),
+            name: "app".to_string(),
+            path: Some(path.to_string()),
+            address_range: Some(range.to_string()),
+            symbol_status: None,
+        };
+        let modules = [
+            module("/lib/libc.so.6", "0x00007ffff7dbc000"),
+            module("/usr/bin/app", "0x0000555555554000"),
+        ];
+        assert_eq!(module_load_address(&modules, binary), Some(0x5555_5555_4000));
+    }
+
+    #[test]
+    fn the_test_binary_is_laid_out_as_linked() {
+        let exe = std::env::current_exe().unwrap();
+        let (pie, linked_at) = layout(&exe).unwrap();
+        assert_eq!(linked_at % PAGE, 0);
+        assert!(code_offset(&exe, linked_at).is_some_and(|code| code > 0));
+        assert_eq!(fixed(&exe).is_some(), !pie);
+        let given = given(&exe, 0x1000);
+        assert_eq!((given.slide, given.loaded_at), (0x1000, linked_at + 0x1000));
+    }
+}
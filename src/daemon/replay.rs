@@ -0,0 +1,485 @@
+//! Line-by-line recording, for adapters without reverse execution
+//!
+//! `record start` single-steps the program from the current stop, keeping
+//! the stack at every step and the innermost frame's locals at the chosen
+//! points, so a stretch of the run can be looked over again without running
+//! it again. `replay seek N` points `context`, `locals`, `backtrace` and
+//! `print` at step N; only the views move, the program stays where recording
+//! left it until `replay exit`. As with the tracer's, the recorder's stops
+//! never reach `await` or the stop history; the one recording ends at counts
+//! as an ordinary stop. Every line costs a step and a backtrace, so record a
+//! few thousand lines rather than a whole run.
+
+use std::path::Path;
+
+use serde_json::{json, Value};
+
+use crate::common::settings::Settings;
+use crate::common::{Error, Result};
+use crate::dap::{Event, StackFrame};
+use crate::ipc::protocol::{
+    BreakpointLocation, Command, ContextResult, EvaluateResult, RecordedStep, StackFrameInfo,
+    VariableInfo,
+};
+use crate::symbols::{base_name, symbolicate::parse_address};
+
+use super::handler::read_source_context;
+use super::session::{bounded, DebugSession, SessionState, BURST};
+
+/// Steps one recording may take
+pub const MAX_STEPS: u64 = 100_000;
+
+/// Frames kept per step
+const MAX_FRAMES: usize = 32;
+
+/// A recording in progress
+#[derive(Debug)]
+struct Active {
+    /// Where to take snapshots of the locals, everywhere if empty
+    at: Vec<BreakpointLocation>,
+    limit: u64,
+    over: bool,
+}
+
+/// The recorded steps and the one the views show
+#[derive(Debug, Default)]
+pub struct Recorder {
+    steps: Vec<RecordedStep>,
+    active: Option<Active>,
+    /// Why the last recording ended
+    ended: Option<String>,
+    /// Index of the step being replayed
+    cursor: Option<usize>,
+}
+
+impl Recorder {
+    /// Record the current stop as step 1 and take the first step, dropping
+    /// the previous recording
+    pub async fn start(
+        &mut self,
+        sess: &mut DebugSession,
+        settings: &Settings,
+        at: Vec<BreakpointLocation>,
+        limit: u64,
+        over: bool,
+    ) -> Result<()> {
+        if sess.state() != SessionState::Stopped {
+            return Err(Error::Replay("the program must be stopped to record".to_string()));
+        }
+        if !sess.trace_functions().is_empty() || sess.catching_syscalls() {
+            return Err(Error::Replay(
+                "tracing steps the program too; run 'trace stop' first".to_string(),
+            ));
+        }
+        if sess.covering() {
+            return Err(Error::Replay(
+                "coverage breakpoints would end the steps; run 'coverage stop' first".to_string(),
+            ));
+        }
+        if sess.timing() {
+            return Err(Error::Replay(
+                "timer breakpoints would end the steps; run 'timer stop' first".to_string(),
+            ));
+        }
+        if !(1..=MAX_STEPS).contains(&limit) {
+            return Err(Error::Replay(format!("record between 1 and {} steps", MAX_STEPS)));
+        }
+        let thread_id = sess
+            .stopped_thread()
+            .ok_or_else(|| Error::Replay("no thread is stopped".to_string()))?;
+
+        self.steps.clear();
+        self.cursor = None;
+        self.ended = None;
+        self.active = Some(Active { at, limit, over });
+        match self.record(sess, settings, thread_id).await {
+            Ok(()) if limit == 1 => {
+                self.finish(sess, "recorded 1 step");
+                return Ok(());
+            }
+            Ok(()) => {}
+            Err(e) => {
+                self.active = None;
+                return Err(e);
+            }
+        }
+        sess.set_recording(true);
+        if let Err(e) = self.step(sess).await {
+            self.finish(sess, format!("could not step: {}", e));
+            return Err(e);
+        }
+        Ok(())
+    }
+
+    /// End the recording, leaving the program where it is; false if none
+    /// was in progress
+    pub fn stop(&mut self, session: &mut Option<DebugSession>) -> bool {
+        if self.active.is_none() {
+            return false;
+        }
+        match session.as_mut() {
+            Some(sess) => self.finish(sess, "stopped by 'record stop'"),
+            None => self.active = None,
+        }
+        true
+    }
+
+    pub fn is_recording(&self) -> bool {
+        self.active.is_some()
+    }
+
+    /// Record the stops the program made since the last look and step on;
+    /// returns the events handled meanwhile
+    pub async fn advance(
+        &mut self,
+        session: &mut Option<DebugSession>,
+        settings: &Settings,
+    ) -> Vec<Event> {
+        let mut events = Vec::new();
+        let Some(limit) = self.active.as_ref().map(|active| active.limit) else {
+            return events;
+        };
+        let Some(sess) = session.as_mut() else {
+            self.active = None;
+            self.ended = Some("the session ended".to_string());
+            return events;
+        };
+
+        let deadline = tokio::time::Instant::now() + BURST;
+        loop {
+            match sess.state() {
+                SessionState::Stopped => {}
+                SessionState::Running => match sess.next_event(deadline).await {
+                    Some(event) => {
+                        events.push(event);
+                        continue;
+                    }
+                    None => break,
+                },
+                _ => {
+                    self.finish(sess, "the program exited");
+                    break;
+                }
+            }
+            let Some(stop) = sess.held_stop().cloned() else {
+                self.finish(sess, "the program stopped");
+                break;
+            };
+            let thread_id = stop.thread_id.or(sess.stopped_thread()).unwrap_or(1);
+            if let Err(e) = self.record(sess, settings, thread_id).await {
+                self.finish(sess, format!("could not read the stack: {}", e));
+                break;
+            }
+            if stop.reason != "step" {
+                self.finish(sess, format!("the program stopped ({})", stop.reason));
+                break;
+            }
+            if self.steps.len() as u64 >= limit {
+                self.finish(sess, format!("recorded {} steps", limit));
+                break;
+            }
+            if let Err(e) = self.step(sess).await {
+                self.finish(sess, format!("could not step: {}", e));
+                break;
+            }
+            if tokio::time::Instant::now() >= deadline {
+                break;
+            }
+        }
+        events
+    }
+
+    async fn step(&self, sess: &mut DebugSession) -> Result<()> {
+        match &self.active {
+            Some(active) if active.over => sess.next().await,
+            _ => sess.step_in().await,
+        }
+    }
+
+    /// Keep where the thread is, with its locals if this is a snapshot point
+    async fn record(
+        &mut self,
+        sess: &mut DebugSession,
+        settings: &Settings,
+        thread_id: i64,
+    ) -> Result<()> {
+        let frames = sess.stack_trace(Some(thread_id), MAX_FRAMES).await?;
+        let mut locals = None;
+        if let Some(top) = frames.first() {
+            if self.active.as_ref().is_some_and(|active| snapshot_at(&active.at, top)) {
+                let variables = sess.get_locals(Some(top.id)).await.unwrap_or_default();
+                locals = Some(
+                    variables
+                        .into_iter()
+                        .map(|mut v| VariableInfo {
+                            truncated_at: bounded(&mut v.value, settings.print_limit),
+                            name: v.name,
+                            value: v.value,
+                            type_name: v.type_name,
+                            // Nothing can be expanded once the program moves on
+                            variables_reference: 0,
+                        })
+                        .collect(),
+                );
+            }
+        }
+        self.steps.push(RecordedStep {
+            step: self.steps.len() as u64 + 1,
+            thread_id,
+            frames: frames.iter().map(|frame| frame_info(settings, frame)).collect(),
+            locals,
+        });
+        Ok(())
+    }
+
+    /// Stop recording and let the stop the program is at be seen
+    fn finish(&mut self, sess: &mut DebugSession, reason: impl Into<String>) {
+        self.active = None;
+        self.ended = Some(reason.into());
+        sess.set_recording(false);
+        sess.release_stop();
+    }
+
+    /// The steps without their frames and snapshots
+    pub fn list(&self) -> Value {
+        let steps: Vec<Value> = self
+            .steps
+            .iter()
+            .map(|step| {
+                let top = step.frames.first();
+                json!({
+                    "step": step.step,
+                    "thread_id": step.thread_id,
+                    "function": top.map(|frame| frame.name.as_str()),
+                    "source": top.and_then(|frame| frame.source.as_deref()),
+                    "line": top.and_then(|frame| frame.line),
+                    "snapshot": step.locals.is_some(),
+                })
+            })
+            .collect();
+        json!({
+            "steps": steps,
+            "recording": self.is_recording(),
+            "ended": self.ended,
+            "cursor": self.cursor.map(|index| index + 1),
+        })
+    }
+
+    /// Point the views at a step, by number or counting from the one they
+    /// show (the last if none)
+    pub fn seek(&mut self, step: i64, relative: bool) -> Result<&RecordedStep> {
+        if self.steps.is_empty() {
+            return Err(Error::Replay("nothing is recorded; see 'record start'".to_string()));
+        }
+        let target = if relative {
+            self.cursor.unwrap_or(self.steps.len() - 1) as i64 + 1 + step
+        } else {
+            step
+        };
+        if target < 1 || target as usize > self.steps.len() {
+            return Err(Error::Replay(format!(
+                "there is no step {}; steps run from 1 to {}",
+                target,
+                self.steps.len()
+            )));
+        }
+        let index = target as usize - 1;
+        self.cursor = Some(index);
+        Ok(&self.steps[index])
+    }
+
+    /// Point the views back at the program; false if they already were
+    pub fn exit(&mut self) -> bool {
+        self.cursor.take().is_some()
+    }
+
+    /// The answer to a view command from the step being replayed, or `None`
+    /// to let the program answer it
+    pub fn answer(&self, command: &Command) -> Option<Result<Value>> {
+        let step = &self.steps[self.cursor?];
+        let top = step.frames.first();
+        let answer = match command {
+            Command::StackTrace { more: true, .. } => Err(Error::Replay(
+                "the replay shows the recorded frames; page through them with \
+                 'backtrace --start N'"
+                    .to_string(),
+            )),
+            Command::StackTrace { limit, start, .. } => {
+                let frames: Vec<&StackFrameInfo> =
+                    step.frames.iter().skip(*start).take(*limit).collect();
+                let more = step.frames.len() > start.saturating_add(*limit);
+                Ok(json!({ "frames": frames, "start": start, "more": more }))
+            }
+            Command::Locals { frame_id } => match &step.locals {
+                // Only the innermost frame's are recorded
+                Some(_) if frame_id.is_some() && *frame_id != top.map(|frame| frame.id) => {
+                    Ok(json!({ "variables": [] }))
+                }
+                Some(locals) => Ok(json!({ "variables": locals })),
+                None => Err(Error::Replay(format!(
+                    "step {} has no snapshot of the locals; see 'record start --at'",
+                    step.step
+                ))),
+            },
+            Command::Evaluate { expression, .. } => step
+                .locals
+                .iter()
+                .flatten()
+                .find(|local| local.name == expression.trim())
+                .ok_or_else(|| {
+                    Error::Replay(format!(
+                        "'{}' was not recorded at step {}; 'replay exit' to evaluate it in \
+                         the program",
+                        expression, step.step
+                    ))
+                })
+                .and_then(|local| {
+                    Ok(serde_json::to_value(EvaluateResult {
+                        result: local.value.clone(),
+                        type_name: local.type_name.clone(),
+                        variables_reference: 0,
+                        truncated_at: local.truncated_at,
+                    })?)
+                }),
+            Command::Context { lines } => context(step, *lines),
+            _ => return None,
+        };
+        Some(answer.map(|mut value| {
+            value["replay_step"] = json!(step.step);
+            value
+        }))
+    }
+}
+
+fn context(step: &RecordedStep, lines: usize) -> Result<Value> {
+    let top = step
+        .frames
+        .first()
+        .ok_or_else(|| Error::Replay(format!("step {} has no stack", step.step)))?;
+    let source = top
+        .source
+        .clone()
+        .ok_or_else(|| Error::Replay(format!("step {} has no source file", step.step)))?;
+    let line = top.line.unwrap_or(1);
+    Ok(serde_json::to_value(ContextResult {
+        thread_id: step.thread_id,
+        source_lines: read_source_context(&source, line, lines)?,
+        source: Some(source),
+        line,
+        column: top.column,
+        function: Some(top.name.clone()),
+        locals: step.locals.clone().unwrap_or_default(),
+    })?)
+}
+
+/// Whether the locals are kept at `frame`: at every step when no points are
+/// given, else where one of them is
+fn snapshot_at(at: &[BreakpointLocation], frame: &StackFrame) -> bool {
+    if at.is_empty() {
+        return true;
+    }
+    let path = frame.source.as_ref().and_then(|source| source.path.as_deref());
+    at.iter().any(|location| match location {
+        BreakpointLocation::Line { file, line } => {
+            *line == frame.line && path.is_some_and(|path| Path::new(path).ends_with(file))
+        }
+        BreakpointLocation::Function { name } => base_name(&frame.name) == name,
+        BreakpointLocation::Address { address } => {
+            let pc = frame.instruction_pointer_reference.as_deref();
+            pc.and_then(|pc| parse_address(pc).ok()) == Some(*address)
+        }
+    })
+}
+
+fn frame_info(settings: &Settings, frame: &StackFrame) -> StackFrameInfo {
+    StackFrameInfo {
+        id: frame.id,
+        name: frame.name.clone(),
+        source: frame
+            .source
+            .as_ref()
+            .and_then(|source| source.path.as_deref())
+            .map(|path| settings.local_path(path)),
+        line: Some(frame.line),
+        column: Some(frame.column),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn step(number: u64, line: u32, locals: Option<Vec<VariableInfo>>) -> RecordedStep {
+        RecordedStep {
+            step: number,
+            thread_id: 1,
+            frames: vec![StackFrameInfo {
+                id: 1000 + number as i64,
+                name: "run".to_string(),
+                source: Some("/src/t.c".to_string()),
+                line: Some(line),
+                column: Some(1),
+            }],
+            locals,
+        }
+    }
+
+    #[test]
+    fn seeking_moves_the_views_over_the_steps() {
+        let mut recorder = Recorder::default();
+        assert!(recorder.seek(1, false).is_err());
+
+        let i = |value: &str| VariableInfo {
+            name: "i".to_string(),
+            value: value.to_string(),
+            type_name: Some("int".to_string()),
+            variables_reference: 0,
+            truncated_at: None,
+        };
+        recorder.steps = vec![
+            step(1, 10, Some(vec![i("0")])),
+            step(2, 11, None),
+            step(3, 10, Some(vec![i("1")])),
+        ];
+        let locals = Command::Locals { frame_id: None };
+        let print = Command::Evaluate {
+            expression: "i".to_string(),
+            frame_id: None,
+            context: crate::ipc::protocol::EvaluateContext::Watch,
+            limit: None,
+        };
+        assert!(recorder.answer(&locals).is_none());
+
+        // Relative seeks start from the last step, where the program is
+        assert_eq!(recorder.seek(-2, true).unwrap().step, 1);
+        assert_eq!(recorder.answer(&print).unwrap().unwrap()["result"], "0");
+        assert_eq!(recorder.seek(1, true).unwrap().step, 2);
+        assert!(recorder.answer(&locals).unwrap().is_err());
+        assert_eq!(recorder.seek(3, false).unwrap().step, 3);
+        let answer = recorder.answer(&print).unwrap().unwrap();
+        assert_eq!(answer["result"], "1");
+        assert_eq!(answer["replay_step"], 3);
+        let backtrace = |start| Command::StackTrace {
+            thread_id: None,
+            limit: 1,
+            start,
+            more: false,
+        };
+        let answer = recorder.answer(&backtrace(0)).unwrap().unwrap();
+        assert_eq!(answer["frames"][0]["line"], 10);
+        assert_eq!(answer["more"], false);
+        assert_eq!(recorder.answer(&backtrace(1)).unwrap().unwrap()["frames"], json!([]));
+        let more = Command::StackTrace {
+            thread_id: None,
+            limit: 1,
+            start: 0,
+            more: true,
+        };
+        assert!(recorder.answer(&more).unwrap().is_err());
+        assert!(recorder.seek(1, true).is_err());
+        assert!(recorder.answer(&Command::Threads).is_none());
+
+        assert!(recorder.exit());
+        assert!(recorder.answer(&locals).is_none());
+    }
+}
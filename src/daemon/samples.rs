@@ -0,0 +1,198 @@
+//! Periodic expression sampling
+//!
+//! `sample add` records an expression's value every so often while the
+//! program runs, as a time series for `sample export`. When a sampler is due
+//! the actor interrupts the program on its tick, evaluates every expression
+//! that is due and resumes it; like the watchdog's, that pause never reaches
+//! `await` or the stop history. Samplers and their values outlive sessions,
+//! so a run can still be exported after the program exits.
+
+use std::collections::VecDeque;
+use std::time::{Duration, Instant};
+
+use crate::common::time::timestamp;
+use crate::ipc::protocol::{SampleValue, SamplerInfo, TimelineKind};
+
+use super::session::{DebugSession, SessionState};
+
+/// Values kept per expression
+const MAX_VALUES: usize = 100_000;
+
+#[derive(Debug)]
+struct Sampler {
+    id: u32,
+    expression: String,
+    every: Duration,
+    due: Instant,
+    values: VecDeque<SampleValue>,
+}
+
+/// The sampled expressions and their values
+#[derive(Debug, Default)]
+pub struct Samples {
+    next_id: u32,
+    entries: Vec<Sampler>,
+    /// When sampling began, for `elapsed_ms`
+    origin: Option<Instant>,
+}
+
+impl Samples {
+    /// Sample an expression every `every` and return its ID
+    pub fn add(&mut self, expression: String, every: Duration) -> u32 {
+        let now = Instant::now();
+        self.origin.get_or_insert(now);
+        self.next_id += 1;
+        self.entries.push(Sampler {
+            id: self.next_id,
+            expression,
+            every,
+            due: now,
+            values: VecDeque::new(),
+        });
+        self.next_id
+    }
+
+    /// Stop sampling an expression; returns whether it existed
+    pub fn remove(&mut self, id: u32) -> bool {
+        let before = self.entries.len();
+        self.entries.retain(|sampler| sampler.id != id);
+        self.entries.len() != before
+    }
+
+    /// The sampled expressions, in the order they were added
+    pub fn list(&self) -> Vec<SamplerInfo> {
+        self.entries
+            .iter()
+            .map(|sampler| SamplerInfo {
+                id: sampler.id,
+                expression: sampler.expression.clone(),
+                every_ms: sampler.every.as_millis() as u64,
+                count: sampler.values.len(),
+                last: sampler.values.back().and_then(|value| value.value.clone()),
+            })
+            .collect()
+    }
+
+    /// Every expression's values, oldest first
+    pub fn values(&self) -> Vec<SampleValue> {
+        let mut values: Vec<SampleValue> = self
+            .entries
+            .iter()
+            .flat_map(|sampler| sampler.values.iter().cloned())
+            .collect();
+        values.sort_by_key(|value| value.elapsed_ms);
+        values
+    }
+
+    /// Drop the values and start the clock again
+    pub fn clear(&mut self) {
+        for sampler in &mut self.entries {
+            sampler.values.clear();
+        }
+        self.origin = (!self.entries.is_empty()).then(Instant::now);
+    }
+
+    /// Interrupt the program to evaluate the expressions that are due
+    pub async fn sample(&mut self, session: &mut Option<DebugSession>) {
+        let now = Instant::now();
+        if !self.entries.iter().any(|sampler| sampler.due <= now) {
+            return;
+        }
+        let Some(sess) = session.as_mut() else {
+            return;
+        };
+        if sess.state() != SessionState::Running {
+            return;
+        }
+
+        let interrupted = match sess.interrupt(DebugSession::INTERRUPT_TIMEOUT).await {
+            Ok(interrupted) => interrupted,
+            Err(e) => {
+                tracing::warn!("Could not interrupt the program to sample: {}", e);
+                return;
+            }
+        };
+        if sess.state() == SessionState::Stopped {
+            let due: Vec<(u32, String)> = self
+                .entries
+                .iter()
+                .filter(|sampler| sampler.due <= now)
+                .map(|sampler| (sampler.id, sampler.expression.clone()))
+                .collect();
+            for (id, expression) in due {
+                let value = sess
+                    .evaluate(&expression, None, "watch")
+                    .await
+                    .map(|result| result.result)
+                    .map_err(|e| e.to_string());
+                let text = match &value {
+                    Ok(value) => format!("{} = {}", expression, value),
+                    Err(error) => format!("{}: {}", expression, error),
+                };
+                let stop = sess.stop_count();
+                sess.timeline_mut().push(TimelineKind::Sample, None, stop, text);
+                self.record(id, now, value);
+            }
+        }
+        if interrupted {
+            if let Err(e) = sess.continue_execution().await {
+                tracing::warn!("Could not resume the program after sampling: {}", e);
+            }
+        }
+    }
+
+    /// Add a value taken at `at` and schedule the next one
+    fn record(&mut self, id: u32, at: Instant, value: Result<String, String>) {
+        let origin = *self.origin.get_or_insert(at);
+        let Some(sampler) = self.entries.iter_mut().find(|sampler| sampler.id == id) else {
+            return;
+        };
+        let (value, error) = match value {
+            Ok(value) => (Some(value), None),
+            Err(error) => (None, Some(error)),
+        };
+        sampler.values.push_back(SampleValue {
+            id,
+            elapsed_ms: at.saturating_duration_since(origin).as_millis() as u64,
+            time: timestamp(),
+            value,
+            error,
+        });
+        if sampler.values.len() > MAX_VALUES {
+            sampler.values.pop_front();
+        }
+        sampler.due = at + sampler.every;
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn values_interleave_by_time_and_schedule_the_next_sample() {
+        let mut samples = Samples::default();
+        let fast = samples.add("counter".into(), Duration::from_millis(100));
+        let slow = samples.add("queue->len".into(), Duration::from_secs(1));
+        let start = samples.origin.unwrap();
+
+        samples.record(fast, start, Ok("1".into()));
+        samples.record(slow, start + Duration::from_millis(50), Err("no symbol".into()));
+        samples.record(fast, start + Duration::from_millis(100), Ok("2".into()));
+
+        let values = samples.values();
+        let order: Vec<(u32, u64)> = values.iter().map(|v| (v.id, v.elapsed_ms)).collect();
+        assert_eq!(order, [(fast, 0), (slow, 50), (fast, 100)]);
+        assert_eq!(values[1].error.as_deref(), Some("no symbol"));
+
+        let list = samples.list();
+        assert_eq!(list[0].count, 2);
+        assert_eq!(list[0].last.as_deref(), Some("2"));
+        assert_eq!(samples.entries[1].due, start + Duration::from_millis(1050));
+
+        samples.clear();
+        assert!(samples.values().is_empty());
+        assert!(samples.remove(slow));
+        assert!(!samples.remove(slow));
+    }
+}
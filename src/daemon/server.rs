@@ -224,6 +224,7 @@ async fn handle_client(stream: transport::platform::Stream, mut shared: Shared)
                     IpcError {
                         code: "INVALID_REQUEST".to_string(),
                         message: e.to_string(),
+                        timeout_secs: None,
                     },
                 );
                 if send_response(&mut writer, &response).await.is_err() {
@@ -251,7 +252,7 @@ async fn handle_client(stream: transport::platform::Stream, mut shared: Shared)
                     Err(e) => Response::error(request.id, IpcError::from(&e)),
                 }
             }
-            command => dispatch(request.id, command, &shared).await,
+            command => dispatch(request.id, command, request.timeout_secs, &shared).await,
         };
 
         if send_response(&mut writer, &response).await.is_err() {
@@ -275,11 +276,17 @@ async fn send_response(
 }
 
 /// Forward a command to the session actor and wait for its reply.
-async fn dispatch(id: u64, command: Command, shared: &Shared) -> Response {
+async fn dispatch(
+    id: u64,
+    command: Command,
+    timeout_secs: Option<u64>,
+    shared: &Shared,
+) -> Response {
     let (reply_tx, reply_rx) = oneshot::channel();
     let request = ActorRequest {
         id,
         command,
+        timeout_secs,
         reply: reply_tx,
     };
 
@@ -359,6 +366,8 @@ async fn build_stop_result(
             source,
             line,
             column,
+            crash_report: snapshot.crash_report.clone().map(Box::new),
+            warnings: snapshot.warnings.clone(),
         },
         // Stopped without an adapter event (attach, stop-on-entry).
         None => StopResult {
@@ -373,6 +382,8 @@ async fn build_stop_result(
             source,
             line,
             column,
+            crash_report: snapshot.crash_report.clone().map(Box::new),
+            warnings: snapshot.warnings.clone(),
         },
     };
 
@@ -386,7 +397,10 @@ async fn fetch_stop_location(shared: &Shared) -> (Option<String>, Option<u32>, O
         Command::StackTrace {
             thread_id: None,
             limit: 1,
+            start: 0,
+            more: false,
         },
+        None,
         shared,
     )
     .await;
@@ -5,15 +5,26 @@
 
 use std::collections::{HashMap, VecDeque};
 use std::path::{Path, PathBuf};
+use std::time::{Duration, Instant};
 
 use tokio::sync::mpsc;
 
 use crate::common::{config::{adapter_fallback_names, Config, TransportMode}, Error, Result};
 use crate::dap::{
     self, Breakpoint, Capabilities, DapClient, Event, FunctionBreakpoint, LaunchArguments,
-    AttachArguments, Scope, SourceBreakpoint, StackFrame, StoppedEventBody, Thread, Variable,
+    AttachArguments, InstructionBreakpoint, Module, Scope, SourceBreakpoint, StackFrame,
+    StoppedEventBody, Thread, Variable,
 };
-use crate::ipc::protocol::{BreakpointInfo, BreakpointLocation};
+use crate::common::time::timestamp;
+use crate::ipc::protocol::{
+    BreakpointInfo, BreakpointLocation, CrashSummary, SlideInfo, SlideSource, StopRecord,
+    TimelineKind,
+};
+use crate::symbols::{self, cache, SymbolIndex};
+
+use super::modules::{self, DeferredSymbols};
+use super::relocation;
+use super::timeline::{self, Timeline};
 
 /// Debug session state
 #[derive(Debug, Clone, Copy, PartialEq, Eq)]
@@ -39,6 +50,29 @@ impl std::fmt::Display for SessionState {
     }
 }
 
+/// Most breakpoint changes `undo` remembers
+const MAX_UNDO: usize = 50;
+
+/// Stops kept for `report`, newest last
+const MAX_STOPS: usize = 100;
+
+/// How long an opened core dump has to report the signal it stopped for
+const CORE_STOP_WAIT: Duration = Duration::from_secs(2);
+
+/// How long the tracer, recorder, coverage and timer keep following the
+/// program before the actor gets back to its commands
+pub const BURST: Duration = Duration::from_millis(50);
+
+/// A breakpoint change that `undo` reverts
+#[derive(Debug, Clone)]
+enum BreakpointChange {
+    /// Breakpoints removed by `breakpoint remove`, kept to be restored
+    /// under their old IDs
+    Removed(Vec<StoredBreakpoint>),
+    /// A breakpoint enabled or disabled, with its previous state
+    Enabled { id: u32, previous: bool },
+}
+
 /// Stored breakpoint information
 #[derive(Debug, Clone)]
 struct StoredBreakpoint {
@@ -57,6 +91,10 @@ struct StoredBreakpoint {
 pub struct OutputEvent {
     pub category: String,
     pub output: String,
+    /// UTC time it arrived
+    pub time: String,
+    /// The session's stop count when it arrived, 0 before the first stop
+    pub stop: u64,
 }
 
 /// Bounded, in-memory buffer for debuggee output.
@@ -82,7 +120,7 @@ impl OutputBuffer {
         }
     }
 
-    fn push(&mut self, category: &str, output: &str) {
+    fn push(&mut self, category: &str, output: &str, stop: u64) {
         if self.max_events == 0 || self.max_bytes == 0 {
             return;
         }
@@ -108,6 +146,8 @@ impl OutputBuffer {
         self.events.push_back(OutputEvent {
             category: category.to_string(),
             output,
+            time: timestamp(),
+            stop,
         });
         self.current_bytes += output_bytes;
     }
@@ -142,6 +182,34 @@ fn truncate_utf8_to_bytes(value: &str, max_bytes: usize) -> String {
     value[..end].to_string()
 }
 
+/// An address breakpoint as the adapter is sent it, at its address in the
+/// process
+fn instruction_breakpoint(bp: &StoredBreakpoint, slide: u64) -> InstructionBreakpoint {
+    let address = match &bp.location {
+        BreakpointLocation::Address { address } => *address,
+        _ => 0,
+    };
+    InstructionBreakpoint {
+        instruction_reference: format!("{:#x}", address.wrapping_add(slide)),
+        condition: bp.condition.clone(),
+        hit_condition: bp.hit_count.map(|n| n.to_string()),
+    }
+}
+
+/// Cut a value the adapter rendered to at most `limit` bytes, 0 meaning no
+/// limit, on a character boundary; the limit if it was cut
+pub(super) fn bounded(value: &mut String, limit: usize) -> Option<usize> {
+    if limit == 0 || value.len() <= limit {
+        return None;
+    }
+    let mut end = limit;
+    while !value.is_char_boundary(end) {
+        end -= 1;
+    }
+    value.truncate(end);
+    Some(limit)
+}
+
 /// Debug session managing a DAP connection
 pub struct DebugSession {
     /// DAP client connection
@@ -162,6 +230,8 @@ pub struct DebugSession {
     source_breakpoints: HashMap<PathBuf, Vec<StoredBreakpoint>>,
     /// Function breakpoints
     function_breakpoints: Vec<StoredBreakpoint>,
+    /// Address breakpoints, at their address in the binary
+    address_breakpoints: Vec<StoredBreakpoint>,
     /// Next breakpoint ID
     next_bp_id: u32,
     /// Cached threads
@@ -176,19 +246,78 @@ pub struct DebugSession {
     last_stop: Option<StoppedEventBody>,
     /// Hit breakpoint IDs from last stop
     hit_breakpoints: Vec<u32>,
+    /// Stopped events seen so far, numbering stops for watch history
+    stop_count: u64,
+    /// The latest stops, oldest first
+    stops: VecDeque<StopRecord>,
+    /// When the adapter last sent an event, or the program last resumed
+    last_event: Instant,
+    /// Set while the daemon pauses the program for itself
+    interrupting: bool,
+    /// Whether the current stop is the daemon's own pause, which is left out
+    /// of the stop history
+    own_stop: bool,
+    /// Functions the tracer has breakpoints on, after the user's own
+    trace_functions: Vec<String>,
+    /// Lines coverage has breakpoints on and has yet to see run, after the
+    /// user's own in each file
+    coverage_lines: HashMap<PathBuf, Vec<u32>>,
+    /// Where the timer has breakpoints, after the user's own
+    timer_locations: Vec<BreakpointLocation>,
+    /// The crash report written for the current stop
+    crash_report: Option<CrashSummary>,
+    /// Whether the current stop has been looked at for a crash report
+    crash_triaged: bool,
+    /// Whether the current stop may be the tracer's, left for it to look at
+    /// before anyone else sees it
+    held: bool,
+    /// Set while the tracer steps out of a traced call
+    trace_stepping: bool,
+    /// Whether the tracer has a syscall catchpoint set
+    catching_syscalls: bool,
+    /// Set while one of the user's steps is in progress
+    stepping: bool,
+    /// Whether the recorder is stepping the program, holding every stop
+    recording: bool,
     /// Current frame index (0 = top of stack)
     current_frame_index: usize,
     /// Current frame ID (for variable inspection)
     current_frame: Option<i64>,
     /// Cached stack frames for current stop
     cached_frames: Vec<StackFrame>,
+    /// The thread asked for and the frame `backtrace --more` goes on from,
+    /// until the next stop
+    backtrace_cursor: Option<(Option<i64>, usize)>,
     /// Bounded output buffer
     output_buffer: OutputBuffer,
+    /// Stops, output, threads and samples on one time axis
+    timeline: Timeline,
     /// Exit code if program exited
     exit_code: Option<i32>,
+    /// Functions and source files of the program, read on first `find`
+    symbols: Option<SymbolIndex>,
+    /// Where separate debug files are looked for, from `debug-file-directory`
+    debug_directories: Vec<PathBuf>,
+    /// The debug file given with `symbols add`, read in place of any found
+    debug_file: Option<PathBuf>,
+    /// Shared libraries an attached GDB left for the actor to read
+    deferred_symbols: Option<DeferredSymbols>,
+    /// Debuggee process ID, from the adapter's `process` event or `attach`
+    process_id: Option<u32>,
+    /// How far the program was loaded from where it was linked, once known
+    slide: Option<SlideInfo>,
+    /// The stop the slide was last looked for at
+    slide_tried: Option<u64>,
+    /// Breakpoint removals and enable/disable changes, for `undo`, newest last
+    breakpoint_undo: Vec<BreakpointChange>,
+    /// What each failed `assert` expected and got
+    failed_assertions: Vec<String>,
 }
 
 impl DebugSession {
+    /// How long an interrupted program has to report the stop
+    pub const INTERRUPT_TIMEOUT: Duration = Duration::from_secs(5);
+
     /// Create a new debug session by launching a program
     #[tracing::instrument(skip(config), fields(adapter = %adapter_name.as_deref().unwrap_or("default")))]
     pub async fn launch(
@@ -201,41 +330,15 @@ impl DebugSession {
     ) -> Result<Self> {
         let adapter_name = adapter_name.unwrap_or_else(|| config.defaults.adapter.clone());
 
-        let adapter_config = config.get_adapter(&adapter_name).ok_or_else(|| {
-            let searched = adapter_fallback_names(&adapter_name);
-            Error::adapter_not_found(&adapter_name, &searched)
-        })?;
-
         tracing::info!(
             program = %program.display(),
             adapter = %adapter_name,
-            adapter_path = %adapter_config.path.display(),
-            adapter_args = ?adapter_config.args,
-            transport = ?adapter_config.transport,
             stop_on_entry,
             "Launching debug session"
         );
 
-        tracing::debug!("Spawning DAP adapter process");
-        let mut client = match adapter_config.transport {
-            TransportMode::Stdio => {
-                DapClient::spawn(&adapter_config.path, &adapter_config.args).await?
-            }
-            TransportMode::Tcp => {
-                DapClient::spawn_tcp(&adapter_config.path, &adapter_config.args, &adapter_config.spawn_style).await?
-            }
-        };
-
-        // Take the event receiver
-        // Initialize the adapter with timeout
-        let init_timeout = std::time::Duration::from_secs(config.timeouts.dap_initialize_secs);
-        let request_timeout = std::time::Duration::from_secs(config.timeouts.dap_request_secs);
-        client.set_request_timeout(request_timeout);
-
-        // Initialize the adapter with timeout
-        tracing::debug!(timeout_secs = init_timeout.as_secs(), "Sending DAP initialize request");
-        let capabilities = client.initialize_with_timeout(&adapter_name, init_timeout).await?;
-        tracing::debug!(?capabilities, "DAP adapter initialized");
+        let (mut client, capabilities, request_timeout) =
+            Self::spawn_adapter(config, &adapter_name).await?;
 
         // Launch the program (DAP: launch must come before initialized event)
         let cwd = std::env::current_dir()
@@ -272,6 +375,11 @@ impl DebugSession {
             mode: if is_go { Some("exec".to_string()) } else { None },
             // Delve uses stopAtEntry instead of stopOnEntry
             stop_at_entry: if is_go && stop_on_entry { Some(true) } else { None },
+            // The program's output comes back as output events with the
+            // session's, rather than on Delve's stdout where nobody reads it
+            output_mode: if is_go { Some("remote".to_string()) } else { None },
+            core_file_path: None,
+            show_global_variables: None,
             // GDB-based adapters (gdb, cuda-gdb) use stopAtBeginningOfMainSubprogram
             stop_at_beginning_of_main_subprogram: if (adapter_name == "gdb" || adapter_name == "cuda-gdb") && stop_on_entry { Some(true) } else { None },
             // js-debug specific - type selects the debugger (pwa-node for Node.js)
@@ -281,6 +389,7 @@ impl DebugSession {
             runtime_executable: None,
             runtime_args: None,
             skip_files: None,
+            output_capture: if is_js_debug { Some("std".to_string()) } else { None },
         };
 
         tracing::debug!(
@@ -307,7 +416,10 @@ impl DebugSession {
         // This is required for adapters that don't support stopOnEntry (e.g., cdt-gdb-adapter)
         let mut source_breakpoints = HashMap::new();
         let mut function_breakpoints = Vec::new();
+        let mut address_breakpoints = Vec::new();
         let mut next_bp_id = 1;
+        // A PIE's address breakpoints wait for it to be loaded
+        let slide = relocation::fixed(&symbols::binary_path(program));
 
         if !initial_breakpoints.is_empty() {
             tracing::debug!(count = initial_breakpoints.len(), "Setting initial breakpoints");
@@ -364,6 +476,18 @@ impl DebugSession {
                             message: None,
                         });
                     }
+                    BreakpointLocation::Address { .. } => {
+                        address_breakpoints.push(StoredBreakpoint {
+                            id: bp_id,
+                            location,
+                            condition: None,
+                            hit_count: None,
+                            enabled: true,
+                            verified: false,
+                            actual_line: None,
+                            message: None,
+                        });
+                    }
                 }
             }
 
@@ -394,6 +518,26 @@ impl DebugSession {
                     stored.message = result.message.clone();
                 }
             }
+
+            // Set address breakpoints, if the program loads where it was linked
+            if !address_breakpoints.is_empty() {
+                if !capabilities.supports_instruction_breakpoints {
+                    return Err(Error::Internal(
+                        "Debug adapter does not support address breakpoints".to_string(),
+                    ));
+                }
+                if let Some(slide) = &slide {
+                    let instruction_bps = address_breakpoints
+                        .iter()
+                        .map(|bp| instruction_breakpoint(bp, slide.slide))
+                        .collect();
+                    let results = client.set_instruction_breakpoints(instruction_bps).await?;
+                    for (stored, result) in address_breakpoints.iter_mut().zip(results.iter()) {
+                        stored.verified = result.verified;
+                        stored.message = result.message.clone();
+                    }
+                }
+            }
         }
 
         // Signal configuration done - this tells the adapter to start execution
@@ -424,6 +568,7 @@ impl DebugSession {
             launched: true,
             source_breakpoints,
             function_breakpoints,
+            address_breakpoints,
             next_bp_id,
             threads: Vec::new(),
             selected_thread: None,
@@ -431,14 +576,40 @@ impl DebugSession {
             stopped_reason: None,
             last_stop: None,
             hit_breakpoints: Vec::new(),
+            stop_count: 0,
+            stops: VecDeque::new(),
+            last_event: Instant::now(),
+            interrupting: false,
+            own_stop: false,
+            trace_functions: Vec::new(),
+            coverage_lines: HashMap::new(),
+            timer_locations: Vec::new(),
+            crash_report: None,
+            crash_triaged: false,
+            held: false,
+            trace_stepping: false,
+            catching_syscalls: false,
+            stepping: false,
+            recording: false,
             current_frame_index: 0,
             current_frame: None,
             cached_frames: Vec::new(),
+            backtrace_cursor: None,
+            deferred_symbols: None,
             output_buffer: OutputBuffer::new(
                 config.output.max_events,
                 config.output.max_bytes_mb * 1024 * 1024,
             ),
+            timeline: Timeline::default(),
             exit_code: None,
+            symbols: None,
+            debug_directories: Vec::new(),
+            debug_file: None,
+            process_id: None,
+            slide,
+            slide_tried: None,
+            breakpoint_undo: Vec::new(),
+            failed_assertions: Vec::new(),
         })
     }
 
@@ -450,39 +621,30 @@ impl DebugSession {
     ) -> Result<Self> {
         let adapter_name = adapter_name.unwrap_or_else(|| config.defaults.adapter.clone());
 
-        let adapter_config = config.get_adapter(&adapter_name).ok_or_else(|| {
-            let searched = adapter_fallback_names(&adapter_name);
-            Error::adapter_not_found(&adapter_name, &searched)
-        })?;
+        tracing::info!(pid, adapter = %adapter_name, "Attaching to process");
 
-        tracing::info!(
-            pid,
-            adapter = %adapter_name,
-            transport = ?adapter_config.transport,
-            "Attaching to process"
-        );
+        let (mut client, capabilities, request_timeout) =
+            Self::spawn_adapter(config, &adapter_name).await?;
 
-        let mut client = match adapter_config.transport {
-            TransportMode::Stdio => {
-                DapClient::spawn(&adapter_config.path, &adapter_config.args).await?
-            }
-            TransportMode::Tcp => {
-                DapClient::spawn_tcp(&adapter_config.path, &adapter_config.args, &adapter_config.spawn_style).await?
+        // Shared libraries' symbols are read after attaching rather than during
+        let deferring = match adapter_name.as_str() {
+            "gdb" | "cuda-gdb" => {
+                let deferred = client.evaluate(modules::GDB_DEFER, None, "repl").await;
+                if let Err(e) = &deferred {
+                    tracing::debug!("Reading every library's symbols on attach: {}", e);
+                }
+                deferred.is_ok()
             }
+            _ => false,
         };
-
-        // Get configured timeouts
-        let init_timeout = std::time::Duration::from_secs(config.timeouts.dap_initialize_secs);
-        let request_timeout = std::time::Duration::from_secs(config.timeouts.dap_request_secs);
-        client.set_request_timeout(request_timeout);
-
-        let capabilities = client.initialize_with_timeout(&adapter_name, init_timeout).await?;
+        let lldb = matches!(adapter_name.as_str(), "lldb" | "lldb-dap" | "codelldb");
 
         // Attach to the process (DAP: attach must come before initialized event)
         client
             .attach(AttachArguments {
-                pid,
-                wait_for: None,
+                pid: Some(pid),
+                init_commands: lldb.then(|| vec![modules::LLDB_DEFER.to_string()]),
+                ..AttachArguments::default()
             })
             .await?;
 
@@ -492,36 +654,219 @@ impl DebugSession {
         // Signal configuration done
         client.configuration_done().await?;
 
+        // Attached processes start stopped
+        let program = PathBuf::from(format!("pid:{}", pid));
+        let mut session =
+            Self::stopped(config, client, capabilities, adapter_name, program, "attach")?;
+        session.process_id = Some(pid);
+        session.deferred_symbols = deferring.then(DeferredSymbols::new);
+        Ok(session)
+    }
+
+    /// Create a new debug session for `program` running under a gdbserver
+    /// stub at `target` (`HOST:PORT`), such as gdbserver or `qemu-user -g`
+    pub async fn attach_remote(
+        config: &Config,
+        target: &str,
+        program: &Path,
+        adapter_name: Option<String>,
+    ) -> Result<Self> {
+        let adapter_name = adapter_name.unwrap_or_else(|| config.defaults.adapter.clone());
+
+        let (host, port) = target
+            .rsplit_once(':')
+            .and_then(|(host, port)| Some((host, port.parse::<u16>().ok()?)))
+            .ok_or_else(|| Error::Config(format!("'{}' is not HOST:PORT", target)))?;
+
+        tracing::info!(
+            target,
+            program = %program.display(),
+            adapter = %adapter_name,
+            "Attaching to a remote stub"
+        );
+
+        let (mut client, capabilities, request_timeout) =
+            Self::spawn_adapter(config, &adapter_name).await?;
+
+        // GDB reads `target`, lldb-dap the gdb-remote pair; each ignores the other
+        client
+            .attach(AttachArguments {
+                program: Some(program.to_string_lossy().into_owned()),
+                target: Some(target.to_string()),
+                gdb_remote_port: Some(port),
+                gdb_remote_hostname: Some(host.to_string()),
+                ..AttachArguments::default()
+            })
+            .await?;
+
+        client.wait_initialized_with_timeout(request_timeout).await?;
+        client.configuration_done().await?;
+
+        // A stub holds the program until the debugger says to go
+        let program = program.to_path_buf();
+        Self::stopped(config, client, capabilities, adapter_name, program, "attach")
+    }
+
+    /// Create a new debug session for a core dump of `program`
+    pub async fn open_core(
+        config: &Config,
+        program: &Path,
+        core: &Path,
+        adapter_name: Option<String>,
+    ) -> Result<Self> {
+        let adapter_name = adapter_name.unwrap_or_else(|| config.defaults.adapter.clone());
+
+        tracing::info!(
+            program = %program.display(),
+            core = %core.display(),
+            adapter = %adapter_name,
+            "Opening core dump"
+        );
+
+        let (mut client, capabilities, request_timeout) =
+            Self::spawn_adapter(config, &adapter_name).await?;
+
+        let program_path = program.to_string_lossy().into_owned();
+        let core_path = core.to_string_lossy().into_owned();
+        if matches!(adapter_name.as_str(), "go" | "delve" | "dlv") {
+            // Delve opens cores with a launch in core mode
+            client
+                .launch(LaunchArguments {
+                    program: program_path,
+                    mode: Some("core".to_string()),
+                    core_file_path: Some(core_path),
+                    show_global_variables: Some(true),
+                    ..LaunchArguments::default()
+                })
+                .await?;
+        } else {
+            // lldb-dap and CodeLLDB load the core in place of attaching
+            client
+                .attach(AttachArguments {
+                    program: Some(program_path),
+                    core_file: Some(core_path),
+                    ..AttachArguments::default()
+                })
+                .await?;
+        }
+
+        client.wait_initialized_with_timeout(request_timeout).await?;
+        client.configuration_done().await?;
+
+        let program = program.to_path_buf();
+        let mut session =
+            Self::stopped(config, client, capabilities, adapter_name, program, "core")?;
+        // Adapters that report it do so after configurationDone
+        let deadline = tokio::time::Instant::now() + CORE_STOP_WAIT;
+        while session.last_stop.is_none() && session.next_event(deadline).await.is_some() {}
+        Ok(session)
+    }
+
+    /// Spawn the adapter configured as `adapter_name` and initialize it,
+    /// returning the client, what the adapter can do and the request timeout
+    /// the client was given
+    async fn spawn_adapter(
+        config: &Config,
+        adapter_name: &str,
+    ) -> Result<(DapClient, Capabilities, Duration)> {
+        let adapter_config = config.get_adapter(adapter_name).ok_or_else(|| {
+            let searched = adapter_fallback_names(adapter_name);
+            Error::adapter_not_found(adapter_name, &searched)
+        })?;
+
+        tracing::debug!(
+            adapter_path = %adapter_config.path.display(),
+            adapter_args = ?adapter_config.args,
+            transport = ?adapter_config.transport,
+            "Spawning DAP adapter process"
+        );
+        let mut client = match adapter_config.transport {
+            TransportMode::Stdio => {
+                DapClient::spawn(&adapter_config.path, &adapter_config.args).await?
+            }
+            TransportMode::Tcp => {
+                DapClient::spawn_tcp(&adapter_config.path, &adapter_config.args, &adapter_config.spawn_style).await?
+            }
+        };
+
+        let init_timeout = Duration::from_secs(config.timeouts.dap_initialize_secs);
+        let request_timeout = Duration::from_secs(config.timeouts.dap_request_secs);
+        client.set_request_timeout(request_timeout);
+
+        tracing::debug!(timeout_secs = init_timeout.as_secs(), "Sending DAP initialize request");
+        let capabilities = client.initialize_with_timeout(adapter_name, init_timeout).await?;
+        tracing::debug!(?capabilities, "DAP adapter initialized");
+        Ok((client, capabilities, request_timeout))
+    }
+
+    /// A session for a program that is stopped from the start, once the
+    /// adapter is configured
+    fn stopped(
+        config: &Config,
+        mut client: DapClient,
+        capabilities: Capabilities,
+        adapter_name: String,
+        program: PathBuf,
+        stopped_reason: &str,
+    ) -> Result<Self> {
         // Take the event receiver (must be done after wait_initialized)
         let events_rx = client
             .take_event_receiver()
             .ok_or_else(|| Error::Internal("Failed to get event receiver".to_string()))?;
+        let slide = relocation::fixed(&symbols::binary_path(&program));
 
         Ok(Self {
             client,
             events_rx,
-            state: SessionState::Stopped, // Attached processes start stopped
+            state: SessionState::Stopped,
             capabilities,
-            program: PathBuf::from(format!("pid:{}", pid)),
+            program,
             adapter_name,
             launched: false,
             source_breakpoints: HashMap::new(),
             function_breakpoints: Vec::new(),
+            address_breakpoints: Vec::new(),
             next_bp_id: 1,
             threads: Vec::new(),
             selected_thread: None,
             stopped_thread: None,
-            stopped_reason: Some("attach".to_string()),
+            stopped_reason: Some(stopped_reason.to_string()),
             last_stop: None,
             hit_breakpoints: Vec::new(),
+            stop_count: 0,
+            stops: VecDeque::new(),
+            last_event: Instant::now(),
+            interrupting: false,
+            own_stop: false,
+            trace_functions: Vec::new(),
+            coverage_lines: HashMap::new(),
+            timer_locations: Vec::new(),
+            crash_report: None,
+            crash_triaged: false,
+            held: false,
+            trace_stepping: false,
+            catching_syscalls: false,
+            stepping: false,
+            recording: false,
             current_frame_index: 0,
             current_frame: None,
             cached_frames: Vec::new(),
+            backtrace_cursor: None,
+            deferred_symbols: None,
             output_buffer: OutputBuffer::new(
                 config.output.max_events,
                 config.output.max_bytes_mb * 1024 * 1024,
             ),
+            timeline: Timeline::default(),
             exit_code: None,
+            symbols: None,
+            debug_directories: Vec::new(),
+            debug_file: None,
+            process_id: None,
+            slide,
+            slide_tried: None,
+            breakpoint_undo: Vec::new(),
+            failed_assertions: Vec::new(),
         })
     }
 
@@ -540,6 +885,75 @@ impl DebugSession {
         &self.program
     }
 
+    /// The program's symbol index, read from the binary on first use
+    pub fn symbols(&mut self) -> Result<&SymbolIndex> {
+        if self.symbols.is_none() {
+            self.symbols = Some(SymbolIndex::load(&self.symbols_path())?);
+        }
+        Ok(self.symbols.as_ref().expect("symbol index was just loaded"))
+    }
+
+    /// The file the program's symbols and DWARF are read from: the file
+    /// given with `symbols add`, the separate debug file of a stripped
+    /// program, or the program
+    pub fn symbols_path(&self) -> PathBuf {
+        match &self.debug_file {
+            Some(file) => file.clone(),
+            None => symbols::symbols_path(
+                &symbols::binary_path(&self.program),
+                &self.debug_directories,
+            ),
+        }
+    }
+
+    /// Look for separate debug files in `directories` from now on
+    pub fn set_debug_directories(&mut self, directories: &[PathBuf]) {
+        if self.debug_directories != directories {
+            self.debug_directories = directories.to_vec();
+            self.symbols = None;
+        }
+    }
+
+    /// Read the program's symbols from `file`, and have the adapter read
+    /// them too. Only GDB and lldb can be told; whether the adapter was is
+    /// returned.
+    pub async fn add_debug_file(&mut self, file: &Path) -> Result<bool> {
+        let program = symbols::binary_path(&self.program);
+        if let (Some(theirs), Some(ours)) = (cache::build_id(file), cache::build_id(&program)) {
+            if theirs != ours {
+                return Err(Error::Symbols(format!(
+                    "{} is from another build: its build ID is {}, the program's {}",
+                    file.display(),
+                    theirs,
+                    ours
+                )));
+            }
+        }
+        let index = SymbolIndex::load(file)?;
+        let command = match self.adapter_name.as_str() {
+            "gdb" | "cuda-gdb" => Some(format!("symbol-file \"{}\"", file.display())),
+            "lldb" | "lldb-dap" | "codelldb" => {
+                Some(format!("target symbols add \"{}\"", file.display()))
+            }
+            _ => None,
+        };
+        if let Some(command) = &command {
+            self.evaluate(command, None, "repl").await?;
+        }
+        self.debug_file = Some(file.to_path_buf());
+        self.symbols = Some(index);
+        Ok(command.is_some())
+    }
+
+    /// The shared libraries whose symbols are read after `attach`, if any are
+    pub fn deferred_symbols(&self) -> Option<&DeferredSymbols> {
+        self.deferred_symbols.as_ref()
+    }
+
+    pub fn deferred_symbols_mut(&mut self) -> Option<&mut DeferredSymbols> {
+        self.deferred_symbols.as_mut()
+    }
+
     /// Get adapter name
     pub fn adapter_name(&self) -> &str {
         &self.adapter_name
@@ -550,6 +964,56 @@ impl DebugSession {
         self.stopped_thread
     }
 
+    /// Debuggee process ID, if the adapter reported one
+    pub fn process_id(&self) -> Option<u32> {
+        self.process_id
+    }
+
+    /// How far the program was loaded from where it was linked, once known
+    pub fn slide(&self) -> Option<SlideInfo> {
+        self.slide
+    }
+
+    /// Take the program's slide as known, and send the address breakpoints
+    /// that were waiting for it
+    pub async fn set_slide(&mut self, slide: SlideInfo) -> Result<()> {
+        self.slide = Some(slide);
+        if self.address_breakpoints.is_empty() {
+            return Ok(());
+        }
+        self.send_address_breakpoints().await
+    }
+
+    /// Whether to look for the slide at this stop: once per stop, while
+    /// address breakpoints wait for it
+    pub fn take_slide_attempt(&mut self) -> bool {
+        if self.slide.is_some()
+            || self.address_breakpoints.is_empty()
+            || self.slide_tried == Some(self.stop_count)
+        {
+            return false;
+        }
+        self.slide_tried = Some(self.stop_count);
+        true
+    }
+
+    /// Index and function name of the selected frame, if frames are cached
+    pub fn selected_frame(&self) -> Option<(usize, &str)> {
+        self.cached_frames
+            .get(self.current_frame_index)
+            .map(|frame| (self.current_frame_index, frame.name.as_str()))
+    }
+
+    /// Number of the current (or most recent) stop
+    pub fn stop_count(&self) -> u64 {
+        self.stop_count
+    }
+
+    /// The latest stops, oldest first
+    pub fn stops(&self) -> impl Iterator<Item = &StopRecord> {
+        self.stops.iter()
+    }
+
     /// Get stopped reason
     pub fn stopped_reason(&self) -> Option<&str> {
         self.stopped_reason.as_deref()
@@ -560,6 +1024,22 @@ impl DebugSession {
         self.exit_code
     }
 
+    /// Remember a failed `assert`; the session counts as failed from now on
+    pub fn record_failed_assertion(&mut self, message: String) {
+        self.failed_assertions.push(message);
+    }
+
+    /// How long the program has run without the adapter sending an event,
+    /// or `None` unless it is running
+    pub fn idle_time(&self) -> Option<Duration> {
+        (self.state == SessionState::Running).then(|| self.last_event.elapsed())
+    }
+
+    /// What the failed `assert`s of this session expected and got
+    pub fn failed_assertions(&self) -> &[String] {
+        &self.failed_assertions
+    }
+
     /// Process pending events
     pub async fn process_events(&mut self) -> Result<Vec<Event>> {
         let mut events = Vec::new();
@@ -572,6 +1052,36 @@ impl DebugSession {
         Ok(events)
     }
 
+    /// Pause the program for the daemon's own use (the watchdog, sampling)
+    /// and handle events until it stops, so no `await` sees the stop
+    ///
+    /// Returns false if the program stopped for another reason meanwhile,
+    /// such as a breakpoint; that stop counts like any other and the program
+    /// should be left stopped.
+    pub async fn interrupt(&mut self, timeout: Duration) -> Result<bool> {
+        self.pause().await?;
+        self.interrupting = true;
+        let stopped = self.wait_for_stop(timeout).await;
+        self.interrupting = false;
+        stopped?;
+        Ok(self.state == SessionState::Stopped && self.own_stop && !self.held)
+    }
+
+    /// Handle events until the program stops or ends
+    async fn wait_for_stop(&mut self, timeout: Duration) -> Result<()> {
+        let deadline = tokio::time::Instant::now() + timeout;
+        while self.state == SessionState::Running {
+            match tokio::time::timeout_at(deadline, self.events_rx.recv()).await {
+                Ok(Some(event)) => self.handle_event(&event),
+                Ok(None) => {
+                    return Err(Error::Internal("debug adapter closed its events".to_string()))
+                }
+                Err(_) => return Err(Error::Timeout(timeout.as_secs())),
+            }
+        }
+        Ok(())
+    }
+
     /// Drain and process any pending events without collecting them
     /// This ensures we don't lose state updates from events while clearing the queue
     fn drain_pending_events(&mut self) {
@@ -582,6 +1092,7 @@ impl DebugSession {
 
     /// Handle a single event
     fn handle_event(&mut self, event: &Event) {
+        self.last_event = Instant::now();
         match event {
             Event::Stopped(body) => {
                 self.state = SessionState::Stopped;
@@ -590,28 +1101,46 @@ impl DebugSession {
                 self.stopped_reason = Some(body.reason.clone());
                 self.last_stop = Some(body.clone());
                 self.hit_breakpoints = body.hit_breakpoint_ids.clone();
+                self.own_stop = self.interrupting && body.reason == "pause";
+                self.held = !self.own_stop && self.may_be_traced(&body.reason);
+                if self.held {
+                    // Counted by `release_stop` if it turns out not to be the tracer's
+                    self.own_stop = true;
+                } else if !self.own_stop {
+                    self.record_stop(body);
+                }
                 // Reset frame tracking on stop - user starts at top of stack
                 self.current_frame = None;
                 self.current_frame_index = 0;
                 self.cached_frames.clear();
+                self.backtrace_cursor = None;
                 tracing::debug!("Stopped: {:?}", body);
             }
             Event::Continued { thread_id, .. } => {
                 self.state = SessionState::Running;
+                self.held = false;
                 self.selected_thread = None;
                 self.stopped_thread = None;
                 self.stopped_reason = None;
                 self.last_stop = None;
                 self.hit_breakpoints.clear();
+                self.crash_report = None;
+                self.crash_triaged = false;
                 self.current_frame = None;
                 self.current_frame_index = 0;
                 self.cached_frames.clear();
+                self.backtrace_cursor = None;
+                if let Some(deferred) = &mut self.deferred_symbols {
+                    deferred.ran();
+                }
                 tracing::debug!("Continued: thread {}", thread_id);
             }
             Event::Exited(body) => {
                 self.state = SessionState::Exited;
                 self.selected_thread = None;
                 self.exit_code = Some(body.exit_code);
+                let text = format!("exited with code {}", body.exit_code);
+                self.timeline.push(TimelineKind::Exit, None, self.stop_count, text);
                 tracing::info!("Program exited with code {}", body.exit_code);
             }
             Event::Terminated(_) => {
@@ -619,12 +1148,21 @@ impl DebugSession {
                 self.selected_thread = None;
                 tracing::info!("Session terminated");
             }
+            Event::Process(body) => {
+                if let Some(pid) = body.system_process_id {
+                    self.process_id = Some(pid);
+                }
+                tracing::debug!("Process {}: {:?}", body.name, body.system_process_id);
+            }
             Event::Output(body) => {
                 let category = body.category.clone().unwrap_or_else(|| "console".to_string());
                 self.buffer_output(&category, &body.output);
             }
             Event::Thread(body) => {
                 tracing::debug!("Thread {}: {}", body.thread_id, body.reason);
+                let text = format!("thread {}", body.reason);
+                let stop = self.stop_count;
+                self.timeline.push(TimelineKind::Thread, Some(body.thread_id), stop, text);
                 // Update thread list if needed
                 if body.reason == "exited" {
                     self.threads.retain(|t| t.id != body.thread_id);
@@ -669,7 +1207,10 @@ impl DebugSession {
 
     /// Buffer output for later retrieval.
     fn buffer_output(&mut self, category: &str, output: &str) {
-        self.output_buffer.push(category, output);
+        self.output_buffer.push(category, output, self.stop_count);
+        if matches!(category, "stdout" | "stderr") {
+            self.timeline.push_output(category, output, self.stop_count);
+        }
     }
 
     /// Add a breakpoint
@@ -748,15 +1289,49 @@ impl DebugSession {
                 // Update verification status
                 self.update_function_breakpoint_status(&results);
 
+                let info = self.get_breakpoint_info(bp_id)?;
+                Ok(info)
+            }
+            BreakpointLocation::Address { address } => {
+                if !self.capabilities.supports_instruction_breakpoints {
+                    return Err(Error::Internal(
+                        "Debug adapter does not support address breakpoints".to_string(),
+                    ));
+                }
+                // Once the program runs, addresses are the process's, so the
+                // slide must be known to keep the binary's
+                if self.slide.is_none() {
+                    relocation::slide(self).await?;
+                }
+                let address = address.wrapping_sub(self.slide.map_or(0, |slide| slide.slide));
+                let stored = StoredBreakpoint {
+                    id: bp_id,
+                    location: BreakpointLocation::Address { address },
+                    condition: condition.clone(),
+                    hit_count,
+                    enabled: true,
+                    verified: false,
+                    actual_line: None,
+                    message: None,
+                };
+
+                self.address_breakpoints.push(stored);
+                if let Err(error) = self.send_address_breakpoints().await {
+                    self.address_breakpoints.retain(|breakpoint| breakpoint.id != bp_id);
+                    return Err(error);
+                }
+
                 let info = self.get_breakpoint_info(bp_id)?;
                 Ok(info)
             }
         }
     }
 
-    /// Collect source breakpoints for a file
+    /// Collect source breakpoints for a file, coverage's and the timer's
+    /// after the user's
     fn collect_source_breakpoints(&self, file: &Path) -> Vec<SourceBreakpoint> {
-        self.source_breakpoints
+        let user: Vec<SourceBreakpoint> = self
+            .source_breakpoints
             .get(file)
             .map(|bps| {
                 bps.iter()
@@ -776,10 +1351,38 @@ impl DebugSession {
                     })
                     .collect()
             })
-            .unwrap_or_default()
+            .unwrap_or_default();
+        let own = self.own_line_breakpoints(file, &user);
+        user.into_iter()
+            .chain(own.into_iter().map(|line| SourceBreakpoint {
+                line,
+                column: None,
+                condition: None,
+                hit_condition: None,
+                log_message: None,
+            }))
+            .collect()
     }
 
-    /// Collect function breakpoints
+    /// The coverage and timer lines of a file that none of the user's
+    /// breakpoints is on
+    fn own_line_breakpoints(&self, file: &Path, user: &[SourceBreakpoint]) -> Vec<u32> {
+        let timer = self.timer_locations.iter().filter_map(|location| match location {
+            BreakpointLocation::Line { file: at, line } if at == file => Some(*line),
+            _ => None,
+        });
+        self.coverage_lines
+            .get(file)
+            .into_iter()
+            .flatten()
+            .copied()
+            .chain(timer)
+            .filter(|line| !user.iter().any(|bp| bp.line == *line))
+            .collect()
+    }
+
+    /// Collect function breakpoints, the tracer's and the timer's after the
+    /// user's
     fn collect_function_breakpoints(&self) -> Vec<FunctionBreakpoint> {
         self.function_breakpoints
             .iter()
@@ -795,9 +1398,58 @@ impl DebugSession {
                     hit_condition: bp.hit_count.map(|n| n.to_string()),
                 }
             })
+            .chain(self.own_function_breakpoints())
             .collect()
     }
 
+    /// The functions the tracer and the timer have breakpoints on, the
+    /// timer's unless the user has one there
+    fn own_function_breakpoints(&self) -> Vec<FunctionBreakpoint> {
+        let user = |name: &String| {
+            self.function_breakpoints.iter().any(|bp| {
+                let at = match &bp.location {
+                    BreakpointLocation::Function { name } => Some(name),
+                    BreakpointLocation::Line { .. } | BreakpointLocation::Address { .. } => None,
+                };
+                bp.enabled && at == Some(name)
+            })
+        };
+        let timer = self.timer_locations.iter().filter_map(|location| match location {
+            BreakpointLocation::Function { name } if !user(name) => Some(name),
+            _ => None,
+        });
+        self.trace_functions
+            .iter()
+            .chain(timer)
+            .map(|name| FunctionBreakpoint {
+                name: name.clone(),
+                condition: None,
+                hit_condition: None,
+            })
+            .collect()
+    }
+
+    /// Send the enabled address breakpoints at their address in the
+    /// process; they wait while the slide isn't known
+    async fn send_address_breakpoints(&mut self) -> Result<()> {
+        let Some(slide) = self.slide else {
+            return Ok(());
+        };
+        let instruction_bps = self
+            .address_breakpoints
+            .iter()
+            .filter(|bp| bp.enabled)
+            .map(|bp| instruction_breakpoint(bp, slide.slide))
+            .collect();
+        let results = self.client.set_instruction_breakpoints(instruction_bps).await?;
+        let enabled = self.address_breakpoints.iter_mut().filter(|bp| bp.enabled);
+        for (stored_bp, result) in enabled.zip(results.iter()) {
+            stored_bp.verified = result.verified;
+            stored_bp.message = result.message.clone();
+        }
+        Ok(())
+    }
+
     /// Update source breakpoint status from adapter response
     fn update_source_breakpoint_status(&mut self, file: &Path, results: &[Breakpoint]) {
         if let Some(stored) = self.source_breakpoints.get_mut(file) {
@@ -857,9 +1509,38 @@ impl DebugSession {
             });
         }
 
+        // Search address breakpoints
+        if let Some(bp) = self.address_breakpoints.iter().find(|bp| bp.id == id) {
+            return Ok(self.address_breakpoint_info(bp));
+        }
+
         Err(Error::BreakpointNotFound { id })
     }
 
+    /// An address breakpoint as `breakpoint list` shows it: at its address
+    /// in the process once the slide is known, and in the binary until then
+    fn address_breakpoint_info(&self, bp: &StoredBreakpoint) -> BreakpointInfo {
+        let address = match &bp.location {
+            BreakpointLocation::Address { address } => *address,
+            _ => 0,
+        };
+        let slide = self.slide.map(|slide| slide.slide);
+        let pending = "waiting for the program's load address; sent at its first stop";
+        BreakpointInfo {
+            id: bp.id,
+            verified: bp.verified,
+            source: Some(format!("*{:#x}", address.wrapping_add(slide.unwrap_or(0)))),
+            line: None,
+            message: match slide {
+                Some(_) => bp.message.clone(),
+                None => Some(pending.to_string()),
+            },
+            enabled: bp.enabled,
+            condition: bp.condition.clone(),
+            hit_count: bp.hit_count,
+        }
+    }
+
     /// Remove a breakpoint by ID
     pub async fn remove_breakpoint(&mut self, id: u32) -> Result<()> {
         // Find and remove from source breakpoints
@@ -879,6 +1560,7 @@ impl DebugSession {
                 }
                 return Err(error);
             }
+            self.record_breakpoint_change(BreakpointChange::Removed(vec![removed]));
             return Ok(());
         }
 
@@ -890,6 +1572,18 @@ impl DebugSession {
                 self.function_breakpoints.insert(pos, removed);
                 return Err(error);
             }
+            self.record_breakpoint_change(BreakpointChange::Removed(vec![removed]));
+            return Ok(());
+        }
+
+        // Try address breakpoints
+        if let Some(pos) = self.address_breakpoints.iter().position(|bp| bp.id == id) {
+            let removed = self.address_breakpoints.remove(pos);
+            if let Err(error) = self.send_address_breakpoints().await {
+                self.address_breakpoints.insert(pos, removed);
+                return Err(error);
+            }
+            self.record_breakpoint_change(BreakpointChange::Removed(vec![removed]));
             return Ok(());
         }
 
@@ -897,18 +1591,149 @@ impl DebugSession {
     }
 
     /// Remove all breakpoints
+    ///
+    /// What was removed can be restored with `undo`, even if an adapter
+    /// request fails partway.
     pub async fn remove_all_breakpoints(&mut self) -> Result<()> {
+        let mut removed = Vec::new();
+        let result = self.clear_breakpoints(&mut removed).await;
+        if !removed.is_empty() {
+            self.record_breakpoint_change(BreakpointChange::Removed(removed));
+        }
+        result
+    }
+
+    async fn clear_breakpoints(&mut self, removed: &mut Vec<StoredBreakpoint>) -> Result<()> {
         // Clear source breakpoints
         let files: Vec<_> = self.source_breakpoints.keys().cloned().collect();
         for file in files {
-            self.client.set_breakpoints(&file, vec![]).await?;
-            self.source_breakpoints.remove(&file);
+            let own = self
+                .own_line_breakpoints(&file, &[])
+                .into_iter()
+                .map(|line| SourceBreakpoint {
+                    line,
+                    column: None,
+                    condition: None,
+                    hit_condition: None,
+                    log_message: None,
+                })
+                .collect();
+            self.client.set_breakpoints(&file, own).await?;
+            removed.extend(self.source_breakpoints.remove(&file).unwrap_or_default());
         }
 
         // Clear function breakpoints
-        self.client.set_function_breakpoints(vec![]).await?;
-        self.function_breakpoints.clear();
+        let user = std::mem::take(&mut self.function_breakpoints);
+        let own = self.own_function_breakpoints();
+        if let Err(e) = self.client.set_function_breakpoints(own).await {
+            self.function_breakpoints = user;
+            return Err(e);
+        }
+        removed.extend(user);
+
+        // Clear address breakpoints
+        let addresses = std::mem::take(&mut self.address_breakpoints);
+        if let Err(e) = self.send_address_breakpoints().await {
+            self.address_breakpoints = addresses;
+            return Err(e);
+        }
+        removed.extend(addresses);
+
+        Ok(())
+    }
+
+    fn record_breakpoint_change(&mut self, change: BreakpointChange) {
+        if self.breakpoint_undo.len() == MAX_UNDO {
+            self.breakpoint_undo.remove(0);
+        }
+        self.breakpoint_undo.push(change);
+    }
+
+    /// Revert the latest breakpoint removal or enable/disable
+    ///
+    /// Removed breakpoints come back with their old IDs, conditions and
+    /// hit counts. Returns the IDs of the breakpoints affected and what was
+    /// done to them.
+    pub async fn undo_breakpoint_change(&mut self) -> Result<(Vec<u32>, &'static str)> {
+        let change = self.breakpoint_undo.pop().ok_or(Error::NothingToUndo)?;
+
+        let result = match &change {
+            BreakpointChange::Removed(breakpoints) => self
+                .restore_breakpoints(breakpoints)
+                .await
+                .map(|ids| (ids, "restored")),
+            BreakpointChange::Enabled { id, previous } => self
+                .apply_breakpoint_enabled(*id, *previous)
+                .await
+                .map(|()| {
+                    let action = if *previous { "enabled" } else { "disabled" };
+                    (vec![*id], action)
+                }),
+        };
+
+        // A failed undo stays undoable
+        if result.is_err() {
+            self.breakpoint_undo.push(change);
+        }
+        result
+    }
+
+    async fn restore_breakpoints(&mut self, breakpoints: &[StoredBreakpoint]) -> Result<Vec<u32>> {
+        let mut files = Vec::new();
+        let mut functions = false;
+        let mut addresses = false;
+
+        for bp in breakpoints {
+            let mut bp = bp.clone();
+            bp.verified = false;
+            match &bp.location {
+                BreakpointLocation::Line { file, .. } => {
+                    if !files.contains(file) {
+                        files.push(file.clone());
+                    }
+                    self.source_breakpoints
+                        .entry(file.clone())
+                        .or_default()
+                        .push(bp);
+                }
+                BreakpointLocation::Function { .. } => {
+                    functions = true;
+                    self.function_breakpoints.push(bp);
+                }
+                BreakpointLocation::Address { .. } => {
+                    addresses = true;
+                    self.address_breakpoints.push(bp);
+                }
+            }
+        }
+
+        let ids: Vec<u32> = breakpoints.iter().map(|bp| bp.id).collect();
+        let mut result = self.resend_breakpoints(&files, functions).await;
+        if result.is_ok() && addresses {
+            result = self.send_address_breakpoints().await;
+        }
+        if result.is_err() {
+            for bps in self.source_breakpoints.values_mut() {
+                bps.retain(|bp| !ids.contains(&bp.id));
+            }
+            self.source_breakpoints.retain(|_, bps| !bps.is_empty());
+            self.function_breakpoints.retain(|bp| !ids.contains(&bp.id));
+            self.address_breakpoints.retain(|bp| !ids.contains(&bp.id));
+        }
+        result.map(|()| ids)
+    }
 
+    async fn resend_breakpoints(&mut self, files: &[PathBuf], functions: bool) -> Result<()> {
+        for file in files {
+            let source_bps = self.collect_source_breakpoints(file);
+            let results = self.client.set_breakpoints(file, source_bps).await?;
+            self.update_source_breakpoint_status(file, &results);
+        }
+        if functions {
+            let func_bps = self.collect_function_breakpoints();
+            let results = self.client.set_function_breakpoints(func_bps).await?;
+            self.update_function_breakpoint_status(&results);
+        }
         Ok(())
     }
 
@@ -950,6 +1775,10 @@ impl DebugSession {
             });
         }
 
+        for bp in &self.address_breakpoints {
+            result.push(self.address_breakpoint_info(bp));
+        }
+
         result
     }
 
@@ -964,6 +1793,10 @@ impl DebugSession {
         let thread_id = self.get_thread_id().await?;
         self.client.continue_execution(thread_id).await?;
         self.state = SessionState::Running;
+        self.last_event = Instant::now();
+        self.held = false;
+        self.trace_stepping = false;
+        self.stepping = false;
         self.selected_thread = None;
         self.stopped_thread = None;
         self.stopped_reason = None;
@@ -985,6 +1818,10 @@ impl DebugSession {
         let thread_id = self.get_thread_id().await?;
         self.client.next(thread_id).await?;
         self.state = SessionState::Running;
+        self.last_event = Instant::now();
+        self.held = false;
+        self.trace_stepping = false;
+        self.stepping = true;
         self.selected_thread = None;
         self.stopped_thread = None;
         self.stopped_reason = None;
@@ -1006,6 +1843,10 @@ impl DebugSession {
         let thread_id = self.get_thread_id().await?;
         self.client.step_in(thread_id).await?;
         self.state = SessionState::Running;
+        self.last_event = Instant::now();
+        self.held = false;
+        self.trace_stepping = false;
+        self.stepping = true;
         self.selected_thread = None;
         self.stopped_thread = None;
         self.stopped_reason = None;
@@ -1027,6 +1868,10 @@ impl DebugSession {
         let thread_id = self.get_thread_id().await?;
         self.client.step_out(thread_id).await?;
         self.state = SessionState::Running;
+        self.last_event = Instant::now();
+        self.held = false;
+        self.trace_stepping = false;
+        self.stepping = true;
         self.selected_thread = None;
         self.stopped_thread = None;
         self.stopped_reason = None;
@@ -1038,6 +1883,309 @@ impl DebugSession {
         Ok(())
     }
 
+    /// Put breakpoints on the functions being traced, replacing the previous
+    /// ones, and return how many the adapter could set
+    pub async fn set_trace_functions(&mut self, names: Vec<String>) -> Result<usize> {
+        let previous = std::mem::replace(&mut self.trace_functions, names);
+        let results = match self
+            .client
+            .set_function_breakpoints(self.collect_function_breakpoints())
+            .await
+        {
+            Ok(results) => results,
+            Err(e) => {
+                self.trace_functions = previous;
+                return Err(e);
+            }
+        };
+        self.update_function_breakpoint_status(&results);
+        let user = self.function_breakpoints.iter().filter(|bp| bp.enabled).count();
+        Ok(results.iter().skip(user).filter(|bp| bp.verified).count())
+    }
+
+    /// Put coverage breakpoints on `lines` of `file`, replacing the file's
+    /// previous ones, and return the line the adapter set each one on, if it
+    /// could
+    pub async fn set_coverage_lines(
+        &mut self,
+        file: &Path,
+        lines: Vec<u32>,
+    ) -> Result<Vec<(u32, Option<u32>)>> {
+        let previous = if lines.is_empty() {
+            self.coverage_lines.remove(file)
+        } else {
+            self.coverage_lines.insert(file.to_path_buf(), lines)
+        };
+        let breakpoints = self.collect_source_breakpoints(file);
+        let results = match self.client.set_breakpoints(file, breakpoints).await {
+            Ok(results) => results,
+            Err(e) => {
+                match previous {
+                    Some(previous) => self.coverage_lines.insert(file.to_path_buf(), previous),
+                    None => self.coverage_lines.remove(file),
+                };
+                return Err(e);
+            }
+        };
+        self.update_source_breakpoint_status(file, &results);
+
+        let enabled = |bps: &Vec<StoredBreakpoint>| bps.iter().filter(|bp| bp.enabled).count();
+        let user = self.source_breakpoints.get(file).map_or(0, enabled);
+        let sent = self.collect_source_breakpoints(file).split_off(user);
+        Ok(sent
+            .iter()
+            .zip(results.iter().skip(user))
+            .map(|(bp, result)| {
+                (bp.line, result.verified.then(|| result.line.unwrap_or(bp.line)))
+            })
+            .collect())
+    }
+
+    /// The files coverage has breakpoints in
+    pub fn coverage_files(&self) -> Vec<PathBuf> {
+        self.coverage_lines.keys().cloned().collect()
+    }
+
+    /// Whether coverage has breakpoints left to be hit
+    pub fn covering(&self) -> bool {
+        !self.coverage_lines.is_empty()
+    }
+
+    /// Put the timer's breakpoints at `locations`, replacing the previous
+    /// ones, and return where the adapter set each one, if it could
+    pub async fn set_timer_locations(
+        &mut self,
+        locations: Vec<BreakpointLocation>,
+    ) -> Result<Vec<Option<BreakpointLocation>>> {
+        let previous = std::mem::replace(&mut self.timer_locations, locations);
+        let mut files: Vec<PathBuf> = Vec::new();
+        let mut functions = false;
+        for location in previous.iter().chain(&self.timer_locations) {
+            match location {
+                BreakpointLocation::Line { file, .. } if !files.contains(file) => {
+                    files.push(file.clone())
+                }
+                BreakpointLocation::Line { .. } | BreakpointLocation::Address { .. } => {}
+                BreakpointLocation::Function { .. } => functions = true,
+            }
+        }
+
+        let mut placed = vec![None; self.timer_locations.len()];
+        for file in files {
+            let breakpoints = self.collect_source_breakpoints(&file);
+            let results = self.client.set_breakpoints(&file, breakpoints.clone()).await?;
+            self.update_source_breakpoint_status(&file, &results);
+            for (index, location) in self.timer_locations.iter().enumerate() {
+                let BreakpointLocation::Line { file: at, line } = location else {
+                    continue;
+                };
+                if *at != file {
+                    continue;
+                }
+                // On one of the user's lines the user's breakpoint stands in
+                let sent = breakpoints.iter().rposition(|bp| bp.line == *line);
+                placed[index] = sent
+                    .and_then(|sent| results.get(sent))
+                    .filter(|result| result.verified)
+                    .map(|result| BreakpointLocation::Line {
+                        file: file.clone(),
+                        line: result.line.unwrap_or(*line),
+                    });
+            }
+        }
+        if functions {
+            let breakpoints = self.collect_function_breakpoints();
+            let results = self.client.set_function_breakpoints(breakpoints.clone()).await?;
+            self.update_function_breakpoint_status(&results);
+            for (index, location) in self.timer_locations.iter().enumerate() {
+                let BreakpointLocation::Function { name } = location else {
+                    continue;
+                };
+                let sent = breakpoints.iter().rposition(|bp| bp.name == *name);
+                if sent.and_then(|sent| results.get(sent)).is_some_and(|result| result.verified) {
+                    placed[index] = Some(location.clone());
+                }
+            }
+        }
+        Ok(placed)
+    }
+
+    /// Whether the timer has breakpoints set
+    pub fn timing(&self) -> bool {
+        !self.timer_locations.is_empty()
+    }
+
+    /// Keep the crash report written for the current stop, shown with it
+    /// until the program goes on; `None` if writing it failed
+    pub fn set_crash_report(&mut self, summary: Option<CrashSummary>) {
+        self.crash_report = summary;
+        self.crash_triaged = true;
+    }
+
+    pub fn crash_report(&self) -> Option<&CrashSummary> {
+        self.crash_report.as_ref()
+    }
+
+    /// Whether a crash report was tried for the current stop
+    pub fn crash_triaged(&self) -> bool {
+        self.crash_triaged
+    }
+
+    /// Whether a stop with this reason could be the tracer's, the
+    /// recorder's, coverage's or the timer's
+    fn may_be_traced(&self, reason: &str) -> bool {
+        if self.recording {
+            return true;
+        }
+        if self.covering() && reason == "breakpoint" {
+            return true;
+        }
+        if self.timing() && matches!(reason, "breakpoint" | "function breakpoint") {
+            return true;
+        }
+        // Adapters differ in the reason they give a catchpoint
+        let signal = matches!(reason, "exception" | "signal");
+        if self.catching_syscalls && !signal && !matches!(reason, "entry" | "pause") {
+            return true;
+        }
+        !self.trace_functions.is_empty()
+            && (matches!(reason, "breakpoint" | "function breakpoint")
+                || (reason == "step" && self.trace_stepping))
+    }
+
+    /// Hold every stop for the tracer while it catches syscalls
+    pub fn set_catching_syscalls(&mut self, catching: bool) {
+        self.catching_syscalls = catching;
+    }
+
+    /// Whether the tracer has a syscall catchpoint set
+    pub fn catching_syscalls(&self) -> bool {
+        self.catching_syscalls
+    }
+
+    /// Whether the tracer is stepping out of a traced call
+    pub fn trace_stepping(&self) -> bool {
+        self.trace_stepping
+    }
+
+    /// Hold every stop for the recorder while it steps the program
+    pub fn set_recording(&mut self, recording: bool) {
+        self.recording = recording;
+    }
+
+    /// Whether the recorder is stepping the program
+    pub fn recording(&self) -> bool {
+        self.recording
+    }
+
+    /// Whether the user is stepping, so a stop on the way is theirs to see
+    pub fn stepping(&self) -> bool {
+        self.stepping
+    }
+
+    /// A stop the tracer has yet to look at
+    pub fn held_stop(&self) -> Option<&StoppedEventBody> {
+        self.last_stop.as_ref().filter(|_| self.held)
+    }
+
+    /// Let the held stop through as an ordinary one, the tracer having no
+    /// use for it
+    pub fn release_stop(&mut self) {
+        if !self.held {
+            return;
+        }
+        self.held = false;
+        self.own_stop = false;
+        if let Some(body) = self.last_stop.clone() {
+            self.record_stop(&body);
+        }
+    }
+
+    /// The functions being traced
+    pub fn trace_functions(&self) -> &[String] {
+        &self.trace_functions
+    }
+
+    /// Whether one of the user's own breakpoints is at `frame`, by its
+    /// function or by its source line
+    pub fn user_breakpoint_at(&self, frame: &StackFrame, function: &str) -> bool {
+        let by_function = self.function_breakpoints.iter().any(|bp| {
+            bp.enabled
+                && matches!(&bp.location, BreakpointLocation::Function { name } if name == function)
+        });
+        let path = frame.source.as_ref().and_then(|source| source.path.as_deref());
+        // Breakpoints are kept under the path they were set with, often
+        // relative, while frames have the full one
+        let by_line = path.is_some_and(|path| {
+            self.source_breakpoints
+                .iter()
+                .filter(|(file, _)| Path::new(path).ends_with(file))
+                .flat_map(|(_, bps)| bps)
+                .any(|bp| {
+                    let line = match &bp.location {
+                        BreakpointLocation::Line { line, .. } => Some(*line),
+                        _ => None,
+                    };
+                    bp.enabled && bp.actual_line.or(line) == Some(frame.line)
+                })
+        });
+        by_function || by_line
+    }
+
+    /// Wait until `deadline` for the next event and handle it
+    pub async fn next_event(&mut self, deadline: tokio::time::Instant) -> Option<Event> {
+        let event = tokio::time::timeout_at(deadline, self.events_rx.recv()).await.ok()??;
+        self.handle_event(&event);
+        Some(event)
+    }
+
+    /// Step out of a traced call, so the tracer sees it return
+    pub async fn step_out_traced(&mut self) -> Result<()> {
+        self.step_out().await?;
+        self.trace_stepping = true;
+        self.stepping = false;
+        Ok(())
+    }
+
+    /// Number a stop and add it to the stop history
+    fn record_stop(&mut self, body: &StoppedEventBody) {
+        self.stop_count += 1;
+        if self.stops.len() == MAX_STOPS {
+            self.stops.pop_front();
+        }
+        self.stops.push_back(StopRecord {
+            number: self.stop_count,
+            time: timestamp(),
+            reason: body.reason.clone(),
+            description: body.description.clone(),
+            thread_id: body.thread_id,
+            breakpoints: body.hit_breakpoint_ids.clone(),
+            function: None,
+            source: None,
+            line: None,
+        });
+        let mut text = body.reason.clone();
+        for id in &body.hit_breakpoint_ids {
+            text.push_str(&format!(" {}", id));
+        }
+        let kind = timeline::stop_kind(&body.reason);
+        self.timeline.push(kind, body.thread_id, self.stop_count, text);
+    }
+
+    /// The threads as the adapter last listed them
+    pub fn known_threads(&self) -> &[Thread] {
+        &self.threads
+    }
+
+    /// Stops, output, threads and samples on one time axis
+    pub fn timeline(&self) -> &Timeline {
+        &self.timeline
+    }
+
+    pub fn timeline_mut(&mut self) -> &mut Timeline {
+        &mut self.timeline
+    }
+
     /// Pause execution
     pub async fn pause(&mut self) -> Result<()> {
         if self.state != SessionState::Running {
@@ -1055,6 +2203,17 @@ impl DebugSession {
         &mut self,
         requested_thread: Option<i64>,
         limit: usize,
+    ) -> Result<Vec<StackFrame>> {
+        self.stack_trace_from(requested_thread, 0, limit).await
+    }
+
+    /// Like [`Self::stack_trace`], but from the `start`th frame out, for
+    /// paging through deep stacks
+    pub async fn stack_trace_from(
+        &mut self,
+        requested_thread: Option<i64>,
+        start: usize,
+        limit: usize,
     ) -> Result<Vec<StackFrame>> {
         self.ensure_stopped()?;
 
@@ -1062,7 +2221,51 @@ impl DebugSession {
             Some(thread_id) => thread_id,
             None => self.get_thread_id().await?,
         };
-        self.client.stack_trace(thread_id, limit as i64).await
+        let frames = self.client.stack_trace_from(thread_id, start as i64, limit as i64).await?;
+
+        // The first backtrace of the stopped thread tells where the stop was
+        let top = frames.first().filter(|_| start == 0);
+        if let (Some(stop), Some(top)) = (self.stops.back_mut(), top) {
+            let current = !self.own_stop && stop.thread_id.is_none_or(|id| id == thread_id);
+            if current && stop.function.is_none() {
+                stop.function = Some(top.name.clone());
+                stop.source = top.source.as_ref().and_then(|s| s.path.clone());
+                stop.line = Some(top.line);
+            }
+        }
+        Ok(frames)
+    }
+
+    /// `limit` frames of `thread`'s stack from `start` or, with `more`, from
+    /// where the last backtrace of it since the stop was cut short
+    ///
+    /// Returns the index of the first frame, the frames and whether the
+    /// stack goes on past them.
+    pub async fn backtrace_page(
+        &mut self,
+        thread: Option<i64>,
+        start: usize,
+        limit: usize,
+        more: bool,
+    ) -> Result<(usize, Vec<StackFrame>, bool)> {
+        let start = match more {
+            false => start,
+            true => self
+                .backtrace_cursor
+                .filter(|(asked, _)| *asked == thread)
+                .map(|(_, next)| next)
+                .ok_or_else(|| Error::InvalidState {
+                    action: "continue the backtrace".to_string(),
+                    state: "not partway through one; run 'backtrace' first".to_string(),
+                })?,
+        };
+        // One frame past the limit tells whether the stack goes on
+        let levels = limit.saturating_add(1);
+        let mut frames = self.stack_trace_from(thread, start, levels).await?;
+        let more = frames.len() > limit;
+        frames.truncate(limit);
+        self.backtrace_cursor = more.then_some((thread, start + frames.len()));
+        Ok((start, frames, more))
     }
 
     /// Get threads
@@ -1071,6 +2274,14 @@ impl DebugSession {
         Ok(self.threads.clone())
     }
 
+    /// Get the loaded modules, none if the adapter can't list them
+    pub async fn modules(&mut self) -> Result<Vec<Module>> {
+        if !self.capabilities.supports_modules_request {
+            return Ok(Vec::new());
+        }
+        self.client.modules().await
+    }
+
     /// Get scopes for current frame
     pub async fn get_scopes(&mut self, frame_id: Option<i64>) -> Result<Vec<Scope>> {
         self.ensure_stopped()?;
@@ -1169,8 +2380,22 @@ impl DebugSession {
     /// before calling this method. If the adapter doesn't support restart, the
     /// user should be instructed to use 'debugger stop' then 'debugger start'.
     pub async fn restart(&mut self) -> Result<()> {
+        // A PIE may load elsewhere this time; its address breakpoints wait
+        // for the next stop
+        let moves = self.slide.is_some_and(|slide| slide.pie && slide.source != SlideSource::Given);
+        if moves && !self.address_breakpoints.is_empty() {
+            self.client.set_instruction_breakpoints(Vec::new()).await?;
+        }
         self.client.restart(false).await?;
+        if moves {
+            self.slide = None;
+            self.slide_tried = None;
+        }
         self.state = SessionState::Running;
+        self.last_event = Instant::now();
+        self.held = false;
+        self.trace_stepping = false;
+        self.stepping = false;
         // Clear frame/stop state since we're restarting
         self.stopped_thread = None;
         self.stopped_reason = None;
@@ -1197,6 +2422,7 @@ impl DebugSession {
         self.current_frame_index = 0;
         self.current_frame = None;
         self.cached_frames.clear();
+        self.backtrace_cursor = None;
 
         Ok(())
     }
@@ -1210,12 +2436,15 @@ impl DebugSession {
     pub async fn select_frame(&mut self, frame_index: usize) -> Result<StackFrame> {
         self.ensure_stopped()?;
 
-        // Fetch frames if not cached or if requesting beyond cache
-        if self.cached_frames.is_empty() || frame_index >= self.cached_frames.len() {
+        // Fetch only the frames past the cache, so going deep into a long
+        // stack doesn't unwind its top again each time
+        if frame_index >= self.cached_frames.len() {
             let thread_id = self.get_thread_id().await?;
-            // Fetch enough frames to include the requested one
-            let needed = (frame_index + 1).max(20);
-            self.cached_frames = self.client.stack_trace(thread_id, needed as i64).await?;
+            let have = self.cached_frames.len();
+            let needed = (frame_index + 1).max(20) - have;
+            let frames =
+                self.client.stack_trace_from(thread_id, have as i64, needed as i64).await?;
+            self.cached_frames.extend(frames);
         }
 
         if frame_index >= self.cached_frames.len() {
@@ -1228,6 +2457,32 @@ impl DebugSession {
         Ok(self.cached_frames[frame_index].clone())
     }
 
+    /// Disassemble around the selected frame's instruction pointer
+    ///
+    /// Returns the instructions and the address of the current one.
+    pub async fn disassemble(
+        &mut self,
+        before: usize,
+        count: usize,
+    ) -> Result<(Vec<dap::DisassembledInstruction>, String)> {
+        if !self.capabilities.supports_disassemble_request {
+            return Err(Error::Internal(
+                "Debug adapter does not support disassembly".to_string(),
+            ));
+        }
+
+        let frame = self.select_frame(self.current_frame_index).await?;
+        let pc = frame.instruction_pointer_reference.ok_or_else(|| {
+            Error::Internal("Frame has no instruction pointer to disassemble".to_string())
+        })?;
+
+        let instructions = self
+            .client
+            .disassemble(&pc, -(before as i64), count as i64)
+            .await?;
+        Ok((instructions, pc))
+    }
+
     /// Move up the stack (to caller frame)
     pub async fn frame_up(&mut self) -> Result<StackFrame> {
         let new_index = self.current_frame_index + 1;
@@ -1258,8 +2513,26 @@ impl DebugSession {
         self.set_breakpoint_enabled(id, false).await
     }
 
-    /// Set breakpoint enabled state
+    /// Set breakpoint enabled state, recording the change for `undo`
     async fn set_breakpoint_enabled(&mut self, id: u32, enabled: bool) -> Result<()> {
+        let previous = self
+            .source_breakpoints
+            .values()
+            .flatten()
+            .chain(&self.function_breakpoints)
+            .chain(&self.address_breakpoints)
+            .find(|bp| bp.id == id)
+            .map(|bp| bp.enabled)
+            .ok_or(Error::BreakpointNotFound { id })?;
+
+        self.apply_breakpoint_enabled(id, enabled).await?;
+        if previous != enabled {
+            self.record_breakpoint_change(BreakpointChange::Enabled { id, previous });
+        }
+        Ok(())
+    }
+
+    async fn apply_breakpoint_enabled(&mut self, id: u32, enabled: bool) -> Result<()> {
         // Find and update the breakpoint
         let mut source_breakpoint = None;
 
@@ -1273,10 +2546,14 @@ impl DebugSession {
         }
 
         let mut function_previous_enabled = None;
+        let mut address_previous_enabled = None;
         if source_breakpoint.is_none() {
             if let Some(bp) = self.function_breakpoints.iter_mut().find(|bp| bp.id == id) {
                 function_previous_enabled = Some(bp.enabled);
                 bp.enabled = enabled;
+            } else if let Some(bp) = self.address_breakpoints.iter_mut().find(|bp| bp.id == id) {
+                address_previous_enabled = Some(bp.enabled);
+                bp.enabled = enabled;
             } else {
                 return Err(Error::BreakpointNotFound { id });
             }
@@ -1311,6 +2588,13 @@ impl DebugSession {
                 }
             };
             self.update_function_breakpoint_status(&results);
+        } else if let Some(previous_enabled) = address_previous_enabled {
+            if let Err(error) = self.send_address_breakpoints().await {
+                if let Some(bp) = self.address_breakpoints.iter_mut().find(|bp| bp.id == id) {
+                    bp.enabled = previous_enabled;
+                }
+                return Err(error);
+            }
         }
 
         Ok(())
@@ -1373,19 +2657,218 @@ impl DebugSession {
 
 #[cfg(test)]
 mod tests {
-    use super::OutputBuffer;
+    use std::collections::{HashMap, VecDeque};
+    use std::path::{Path, PathBuf};
+    use std::sync::atomic::{AtomicBool, Ordering};
+    use std::sync::Arc;
+    use std::time::Instant;
+
+    use serde_json::{json, Value};
+    use tokio::io::BufReader;
+    use tokio::net::TcpListener;
+
+    use super::{
+        BreakpointLocation, DebugSession, OutputBuffer, SessionState, StackFrame, StoredBreakpoint,
+        Timeline, MAX_UNDO,
+    };
+    use crate::common::config::TcpSpawnStyle;
+    use crate::common::Error;
+    use crate::dap::{codec, Capabilities, DapClient};
+
+    /// Frames on the stack of the fake adapter's program
+    const STACK_DEPTH: usize = 7;
+
+    /// A client whose adapter verifies every breakpoint it is sent, or
+    /// refuses every request while `refuse` is set
+    async fn fake_client(refuse: Arc<AtomicBool>) -> DapClient {
+        let listener = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let addr = listener.local_addr().unwrap();
+        tokio::spawn(async move {
+            let (stream, _) = listener.accept().await.unwrap();
+            let (read_half, mut write_half) = tokio::io::split(stream);
+            let mut reader = BufReader::new(read_half);
+            while let Ok(message) = codec::read_message(&mut reader).await {
+                let request: Value = serde_json::from_str(&message).unwrap();
+                let arguments = &request["arguments"];
+                let body = match request["command"].as_str() {
+                    Some("stackTrace") => {
+                        let start = arguments["startFrame"].as_u64().unwrap() as usize;
+                        let levels = arguments["levels"].as_u64().unwrap() as usize;
+                        let frames: Vec<Value> = (start..STACK_DEPTH.min(start + levels))
+                            .map(|i| json!({ "id": i, "name": "f", "line": 1, "column": 1 }))
+                            .collect();
+                        json!({ "stackFrames": frames })
+                    }
+                    _ => {
+                        let sent = arguments["breakpoints"].as_array().map_or(0, Vec::len);
+                        json!({ "breakpoints": vec![json!({ "verified": true }); sent] })
+                    }
+                };
+                let response = json!({
+                    "seq": 0,
+                    "type": "response",
+                    "request_seq": request["seq"],
+                    "command": request["command"],
+                    "success": !refuse.load(Ordering::SeqCst),
+                    "message": "refused",
+                    "body": body,
+                });
+                codec::write_message(&mut write_half, &response.to_string()).await.unwrap();
+            }
+        });
+
+        // The "adapter" only says where the test is listening
+        let script = format!("echo 'listening at: {}'; sleep 60", addr);
+        let args = ["-c".to_string(), script];
+        DapClient::spawn_tcp(Path::new("sh"), &args, &TcpSpawnStyle::TcpListen).await.unwrap()
+    }
+
+    /// A stopped session with a function breakpoint for each ID
+    async fn fake_session(ids: &[u32], refuse: Arc<AtomicBool>) -> DebugSession {
+        let mut client = fake_client(refuse).await;
+        let events_rx = client.take_event_receiver().unwrap();
+        let function_breakpoints = ids
+            .iter()
+            .map(|&id| StoredBreakpoint {
+                id,
+                location: BreakpointLocation::Function { name: format!("f{}", id) },
+                condition: None,
+                hit_count: None,
+                enabled: true,
+                verified: true,
+                actual_line: None,
+                message: None,
+            })
+            .collect();
+
+        DebugSession {
+            client,
+            events_rx,
+            state: SessionState::Stopped,
+            capabilities: Capabilities::default(),
+            program: PathBuf::from("fake"),
+            adapter_name: "fake".to_string(),
+            launched: true,
+            source_breakpoints: HashMap::new(),
+            function_breakpoints,
+            address_breakpoints: Vec::new(),
+            next_bp_id: ids.iter().max().map_or(1, |id| id + 1),
+            threads: Vec::new(),
+            selected_thread: None,
+            stopped_thread: None,
+            stopped_reason: None,
+            last_stop: None,
+            hit_breakpoints: Vec::new(),
+            stop_count: 0,
+            stops: VecDeque::new(),
+            last_event: Instant::now(),
+            interrupting: false,
+            own_stop: false,
+            trace_functions: Vec::new(),
+            coverage_lines: HashMap::new(),
+            timer_locations: Vec::new(),
+            crash_report: None,
+            crash_triaged: false,
+            held: false,
+            trace_stepping: false,
+            catching_syscalls: false,
+            stepping: false,
+            recording: false,
+            current_frame_index: 0,
+            current_frame: None,
+            cached_frames: Vec::new(),
+            backtrace_cursor: None,
+            deferred_symbols: None,
+            output_buffer: OutputBuffer::new(16, 1024),
+            timeline: Timeline::default(),
+            exit_code: None,
+            symbols: None,
+            debug_directories: Vec::new(),
+            debug_file: None,
+            process_id: None,
+            slide: None,
+            slide_tried: None,
+            breakpoint_undo: Vec::new(),
+            failed_assertions: Vec::new(),
+        }
+    }
+
+    fn enabled(session: &DebugSession, id: u32) -> bool {
+        session.list_breakpoints().iter().any(|bp| bp.id == id && bp.enabled)
+    }
+
+    #[tokio::test]
+    async fn undo_remembers_only_the_latest_changes() {
+        let mut session = fake_session(&[1], Arc::default()).await;
+        for _ in 0..MAX_UNDO / 2 + 3 {
+            session.disable_breakpoint(1).await.unwrap();
+            session.enable_breakpoint(1).await.unwrap();
+        }
+        assert_eq!(session.breakpoint_undo.len(), MAX_UNDO);
+
+        for _ in 0..MAX_UNDO {
+            session.undo_breakpoint_change().await.unwrap();
+        }
+        assert!(matches!(
+            session.undo_breakpoint_change().await,
+            Err(Error::NothingToUndo)
+        ));
+    }
+
+    #[tokio::test]
+    async fn a_failed_undo_can_be_retried() {
+        let refuse = Arc::new(AtomicBool::new(false));
+        let mut session = fake_session(&[1], refuse.clone()).await;
+        session.disable_breakpoint(1).await.unwrap();
+
+        refuse.store(true, Ordering::SeqCst);
+        assert!(session.undo_breakpoint_change().await.is_err());
+        assert_eq!(session.breakpoint_undo.len(), 1);
+        assert!(!enabled(&session, 1));
+
+        refuse.store(false, Ordering::SeqCst);
+        let (ids, action) = session.undo_breakpoint_change().await.unwrap();
+        assert_eq!((ids, action), (vec![1], "enabled"));
+        assert!(enabled(&session, 1));
+        assert!(session.breakpoint_undo.is_empty());
+    }
+
+    #[tokio::test]
+    async fn removed_breakpoints_come_back_under_their_ids() {
+        let mut session = fake_session(&[3, 7], Arc::default()).await;
+        session.remove_all_breakpoints().await.unwrap();
+        assert!(session.list_breakpoints().is_empty());
+
+        let (ids, action) = session.undo_breakpoint_change().await.unwrap();
+        assert_eq!((ids, action), (vec![3, 7], "restored"));
+        let mut listed: Vec<u32> = session.list_breakpoints().iter().map(|bp| bp.id).collect();
+        listed.sort();
+        assert_eq!(listed, vec![3, 7]);
+        assert_eq!(session.next_bp_id, 8);
+    }
+
+    #[tokio::test]
+    async fn enabling_an_enabled_breakpoint_records_nothing() {
+        let mut session = fake_session(&[1], Arc::default()).await;
+        session.enable_breakpoint(1).await.unwrap();
+        assert!(session.breakpoint_undo.is_empty());
+
+        session.disable_breakpoint(1).await.unwrap();
+        session.disable_breakpoint(1).await.unwrap();
+        assert_eq!(session.breakpoint_undo.len(), 1);
+    }
 
     #[test]
     fn clearing_output_resets_byte_accounting() {
         let mut buffer = OutputBuffer::new(4, 4);
-        buffer.push("stdout", "abcd");
+        buffer.push("stdout", "abcd", 0);
 
         let drained = buffer.take(true);
         assert_eq!(drained.len(), 1);
         assert_eq!(drained[0].output, "abcd");
         assert_eq!(buffer.current_bytes, 0);
 
-        buffer.push("stdout", "xyz");
+        buffer.push("stdout", "xyz", 0);
         assert_eq!(buffer.current_bytes, 3);
         assert_eq!(buffer.take(false)[0].output, "xyz");
     }
@@ -1393,7 +2876,7 @@ mod tests {
     #[test]
     fn output_is_truncated_on_a_utf8_boundary() {
         let mut buffer = OutputBuffer::new(4, 5);
-        buffer.push("stdout", "ééé");
+        buffer.push("stdout", "ééé", 0);
 
         let output = buffer.take(false);
         assert_eq!(output.len(), 1);
@@ -1404,7 +2887,41 @@ mod tests {
     #[test]
     fn zero_sized_buffers_discard_output() {
         let mut buffer = OutputBuffer::new(0, 32);
-        buffer.push("stdout", "discard me");
+        buffer.push("stdout", "discard me", 0);
         assert!(buffer.take(false).is_empty());
     }
+
+    #[tokio::test]
+    async fn backtrace_pages_go_on_to_the_outermost_frame() {
+        let mut session = fake_session(&[], Arc::default()).await;
+        let page = |(start, frames, more): (usize, Vec<StackFrame>, bool)| {
+            (start, frames.iter().map(|f| f.id).collect::<Vec<_>>(), more)
+        };
+
+        let first = session.backtrace_page(Some(1), 0, 3, false).await.unwrap();
+        assert_eq!(page(first), (0, vec![0, 1, 2], true));
+        let second = session.backtrace_page(Some(1), 0, 3, true).await.unwrap();
+        assert_eq!(page(second), (3, vec![3, 4, 5], true));
+
+        // The last page is short and ends the backtrace
+        let last = session.backtrace_page(Some(1), 0, 3, true).await.unwrap();
+        assert_eq!(page(last), (6, vec![6], false));
+        assert!(session.backtrace_page(Some(1), 0, 3, true).await.is_err());
+    }
+
+    #[tokio::test]
+    async fn backtrace_pages_are_per_thread() {
+        let mut session = fake_session(&[], Arc::default()).await;
+        assert!(session.backtrace_page(Some(1), 0, 3, true).await.is_err());
+
+        session.backtrace_page(Some(1), 2, 2, false).await.unwrap();
+        assert!(session.backtrace_page(Some(2), 0, 2, true).await.is_err());
+        let more = session.backtrace_page(Some(1), 0, 2, true).await.unwrap();
+        assert_eq!(more.0, 4);
+
+        // A page that reaches the outermost frame exactly leaves nothing more
+        let exact = session.backtrace_page(Some(1), 4, 3, false).await.unwrap();
+        assert!(!exact.2);
+        assert!(session.backtrace_page(Some(1), 0, 3, true).await.is_err());
+    }
 }
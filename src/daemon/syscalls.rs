@@ -0,0 +1,610 @@
+//! Syscall tracing
+//!
+//! `trace syscalls` logs the program's syscalls into the trace log, between
+//! the traced calls they happen in. With GDB on x86-64 the tracer sets a
+//! syscall catchpoint and reads the registers at every entry and return, so
+//! nothing is missed and each return has its value. Other adapters give no
+//! way to stop at syscalls, so on Linux a thread watches the program's
+//! `/proc/PID/task/TID/syscall` files instead: that sees the calls a thread
+//! is blocked in (reads, waits, connects), calls that return at once are
+//! gone before it looks, and return values are never known.
+
+use std::collections::HashMap;
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::Arc;
+use std::time::{Duration, Instant};
+
+use tokio::sync::mpsc;
+
+use crate::common::{Error, Result};
+
+/// How often the watcher reads the `/proc` files
+const POLL_INTERVAL: Duration = Duration::from_millis(2);
+
+/// Bytes of a string or buffer argument shown
+const MAX_STRING: usize = 48;
+
+/// Groups of syscalls `--filter` can pick, after strace's classes
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Class {
+    File,
+    Desc,
+    Net,
+    Process,
+    Memory,
+    Signal,
+    Ipc,
+}
+
+impl Class {
+    fn parse(name: &str) -> Option<Class> {
+        Some(match name {
+            "file" => Class::File,
+            "desc" => Class::Desc,
+            "net" | "network" => Class::Net,
+            "process" => Class::Process,
+            "memory" => Class::Memory,
+            "signal" => Class::Signal,
+            "ipc" => Class::Ipc,
+            _ => return None,
+        })
+    }
+}
+
+/// How an argument is shown
+#[derive(Debug, Clone, Copy)]
+enum Arg {
+    /// Signed decimal
+    Int,
+    /// Unsigned decimal, for sizes and counts
+    Size,
+    /// Pointers and flags
+    Hex,
+    /// File modes
+    Oct,
+    /// A NUL-terminated string
+    Str,
+    /// Bytes being written, as many as the next argument says
+    Buf,
+    /// A directory descriptor, where -100 is `AT_FDCWD`
+    DirFd,
+}
+
+struct Syscall {
+    nr: u64,
+    name: &'static str,
+    classes: &'static [Class],
+    args: &'static [Arg],
+}
+
+const fn sys(
+    nr: u64,
+    name: &'static str,
+    classes: &'static [Class],
+    args: &'static [Arg],
+) -> Syscall {
+    Syscall { nr, name, classes, args }
+}
+
+use Arg::{Buf, DirFd, Hex, Int, Oct, Size, Str};
+use Class::{Desc, File, Ipc, Memory, Net, Process, Signal};
+
+#[cfg(target_arch = "x86_64")]
+#[rustfmt::skip]
+const SYSCALLS: &[Syscall] = &[
+    sys(0, "read", &[Desc], &[Int, Hex, Size]),
+    sys(1, "write", &[Desc], &[Int, Buf, Size]),
+    sys(2, "open", &[File, Desc], &[Str, Hex, Oct]),
+    sys(3, "close", &[Desc], &[Int]),
+    sys(4, "stat", &[File], &[Str, Hex]),
+    sys(5, "fstat", &[Desc], &[Int, Hex]),
+    sys(6, "lstat", &[File], &[Str, Hex]),
+    sys(7, "poll", &[Desc], &[Hex, Size, Int]),
+    sys(8, "lseek", &[Desc], &[Int, Int, Int]),
+    sys(9, "mmap", &[Memory, Desc], &[Hex, Size, Hex, Hex, Int, Hex]),
+    sys(10, "mprotect", &[Memory], &[Hex, Size, Hex]),
+    sys(11, "munmap", &[Memory], &[Hex, Size]),
+    sys(12, "brk", &[Memory], &[Hex]),
+    sys(13, "rt_sigaction", &[Signal], &[Int, Hex, Hex]),
+    sys(14, "rt_sigprocmask", &[Signal], &[Int, Hex, Hex]),
+    sys(16, "ioctl", &[Desc], &[Int, Hex, Hex]),
+    sys(17, "pread64", &[Desc], &[Int, Hex, Size, Int]),
+    sys(18, "pwrite64", &[Desc], &[Int, Buf, Size, Int]),
+    sys(19, "readv", &[Desc], &[Int, Hex, Size]),
+    sys(20, "writev", &[Desc], &[Int, Hex, Size]),
+    sys(21, "access", &[File], &[Str, Hex]),
+    sys(22, "pipe", &[Desc], &[Hex]),
+    sys(23, "select", &[Desc], &[Int, Hex, Hex, Hex, Hex]),
+    sys(24, "sched_yield", &[], &[]),
+    sys(25, "mremap", &[Memory], &[Hex, Size, Size, Hex, Hex]),
+    sys(28, "madvise", &[Memory], &[Hex, Size, Int]),
+    sys(29, "shmget", &[Ipc], &[Int, Size, Hex]),
+    sys(30, "shmat", &[Ipc, Memory], &[Int, Hex, Hex]),
+    sys(31, "shmctl", &[Ipc], &[Int, Int, Hex]),
+    sys(32, "dup", &[Desc], &[Int]),
+    sys(33, "dup2", &[Desc], &[Int, Int]),
+    sys(35, "nanosleep", &[], &[Hex, Hex]),
+    sys(39, "getpid", &[], &[]),
+    sys(41, "socket", &[Net], &[Int, Int, Int]),
+    sys(42, "connect", &[Net, Desc], &[Int, Hex, Size]),
+    sys(43, "accept", &[Net, Desc], &[Int, Hex, Hex]),
+    sys(44, "sendto", &[Net, Desc], &[Int, Buf, Size, Hex, Hex, Size]),
+    sys(45, "recvfrom", &[Net, Desc], &[Int, Hex, Size, Hex, Hex, Hex]),
+    sys(46, "sendmsg", &[Net, Desc], &[Int, Hex, Hex]),
+    sys(47, "recvmsg", &[Net, Desc], &[Int, Hex, Hex]),
+    sys(48, "shutdown", &[Net, Desc], &[Int, Int]),
+    sys(49, "bind", &[Net, Desc], &[Int, Hex, Size]),
+    sys(50, "listen", &[Net, Desc], &[Int, Int]),
+    sys(51, "getsockname", &[Net, Desc], &[Int, Hex, Hex]),
+    sys(52, "getpeername", &[Net, Desc], &[Int, Hex, Hex]),
+    sys(53, "socketpair", &[Net], &[Int, Int, Int, Hex]),
+    sys(54, "setsockopt", &[Net, Desc], &[Int, Int, Int, Hex, Size]),
+    sys(55, "getsockopt", &[Net, Desc], &[Int, Int, Int, Hex, Hex]),
+    sys(56, "clone", &[Process], &[Hex, Hex, Hex, Hex, Hex]),
+    sys(57, "fork", &[Process], &[]),
+    sys(58, "vfork", &[Process], &[]),
+    sys(59, "execve", &[File, Process], &[Str, Hex, Hex]),
+    sys(60, "exit", &[Process], &[Int]),
+    sys(61, "wait4", &[Process], &[Int, Hex, Hex, Hex]),
+    sys(62, "kill", &[Signal, Process], &[Int, Int]),
+    sys(63, "uname", &[], &[Hex]),
+    sys(64, "semget", &[Ipc], &[Int, Int, Hex]),
+    sys(65, "semop", &[Ipc], &[Int, Hex, Size]),
+    sys(66, "semctl", &[Ipc], &[Int, Int, Int, Hex]),
+    sys(67, "shmdt", &[Ipc, Memory], &[Hex]),
+    sys(68, "msgget", &[Ipc], &[Int, Hex]),
+    sys(69, "msgsnd", &[Ipc], &[Int, Hex, Size, Hex]),
+    sys(70, "msgrcv", &[Ipc], &[Int, Hex, Size, Int, Hex]),
+    sys(71, "msgctl", &[Ipc], &[Int, Int, Hex]),
+    sys(72, "fcntl", &[Desc], &[Int, Int, Hex]),
+    sys(74, "fsync", &[Desc], &[Int]),
+    sys(77, "ftruncate", &[Desc], &[Int, Int]),
+    sys(78, "getdents", &[Desc], &[Int, Hex, Size]),
+    sys(79, "getcwd", &[File], &[Hex, Size]),
+    sys(80, "chdir", &[File], &[Str]),
+    sys(82, "rename", &[File], &[Str, Str]),
+    sys(83, "mkdir", &[File], &[Str, Oct]),
+    sys(84, "rmdir", &[File], &[Str]),
+    sys(87, "unlink", &[File], &[Str]),
+    sys(89, "readlink", &[File], &[Str, Hex, Size]),
+    sys(90, "chmod", &[File], &[Str, Oct]),
+    sys(96, "gettimeofday", &[], &[Hex, Hex]),
+    sys(102, "getuid", &[], &[]),
+    sys(186, "gettid", &[], &[]),
+    sys(200, "tkill", &[Signal], &[Int, Int]),
+    sys(202, "futex", &[], &[Hex, Int, Int, Hex, Hex, Int]),
+    sys(217, "getdents64", &[Desc], &[Int, Hex, Size]),
+    sys(228, "clock_gettime", &[], &[Int, Hex]),
+    sys(230, "clock_nanosleep", &[], &[Int, Int, Hex, Hex]),
+    sys(231, "exit_group", &[Process], &[Int]),
+    sys(232, "epoll_wait", &[Desc], &[Int, Hex, Int, Int]),
+    sys(233, "epoll_ctl", &[Desc], &[Int, Int, Int, Hex]),
+    sys(234, "tgkill", &[Signal], &[Int, Int, Int]),
+    sys(257, "openat", &[File, Desc], &[DirFd, Str, Hex, Oct]),
+    sys(258, "mkdirat", &[File, Desc], &[DirFd, Str, Oct]),
+    sys(262, "newfstatat", &[File, Desc], &[DirFd, Str, Hex, Hex]),
+    sys(263, "unlinkat", &[File, Desc], &[DirFd, Str, Hex]),
+    sys(267, "readlinkat", &[File, Desc], &[DirFd, Str, Hex, Size]),
+    sys(270, "pselect6", &[Desc], &[Int, Hex, Hex, Hex, Hex, Hex]),
+    sys(271, "ppoll", &[Desc], &[Hex, Size, Hex, Hex, Size]),
+    sys(281, "epoll_pwait", &[Desc], &[Int, Hex, Int, Int, Hex, Size]),
+    sys(288, "accept4", &[Net, Desc], &[Int, Hex, Hex, Hex]),
+    sys(290, "eventfd2", &[Desc], &[Size, Hex]),
+    sys(291, "epoll_create1", &[Desc], &[Hex]),
+    sys(292, "dup3", &[Desc], &[Int, Int, Hex]),
+    sys(293, "pipe2", &[Desc], &[Hex, Hex]),
+    sys(318, "getrandom", &[], &[Hex, Size, Hex]),
+    sys(332, "statx", &[File, Desc], &[DirFd, Str, Hex, Hex, Hex]),
+    sys(435, "clone3", &[Process], &[Hex, Size]),
+    sys(439, "faccessat2", &[File, Desc], &[DirFd, Str, Hex, Hex]),
+];
+
+#[cfg(target_arch = "aarch64")]
+#[rustfmt::skip]
+const SYSCALLS: &[Syscall] = &[
+    sys(17, "getcwd", &[File], &[Hex, Size]),
+    sys(19, "eventfd2", &[Desc], &[Size, Hex]),
+    sys(20, "epoll_create1", &[Desc], &[Hex]),
+    sys(21, "epoll_ctl", &[Desc], &[Int, Int, Int, Hex]),
+    sys(22, "epoll_pwait", &[Desc], &[Int, Hex, Int, Int, Hex, Size]),
+    sys(23, "dup", &[Desc], &[Int]),
+    sys(24, "dup3", &[Desc], &[Int, Int, Hex]),
+    sys(25, "fcntl", &[Desc], &[Int, Int, Hex]),
+    sys(29, "ioctl", &[Desc], &[Int, Hex, Hex]),
+    sys(34, "mkdirat", &[File, Desc], &[DirFd, Str, Oct]),
+    sys(35, "unlinkat", &[File, Desc], &[DirFd, Str, Hex]),
+    sys(38, "renameat", &[File, Desc], &[DirFd, Str, DirFd, Str]),
+    sys(48, "faccessat", &[File, Desc], &[DirFd, Str, Hex]),
+    sys(49, "chdir", &[File], &[Str]),
+    sys(56, "openat", &[File, Desc], &[DirFd, Str, Hex, Oct]),
+    sys(57, "close", &[Desc], &[Int]),
+    sys(59, "pipe2", &[Desc], &[Hex, Hex]),
+    sys(61, "getdents64", &[Desc], &[Int, Hex, Size]),
+    sys(62, "lseek", &[Desc], &[Int, Int, Int]),
+    sys(63, "read", &[Desc], &[Int, Hex, Size]),
+    sys(64, "write", &[Desc], &[Int, Buf, Size]),
+    sys(65, "readv", &[Desc], &[Int, Hex, Size]),
+    sys(66, "writev", &[Desc], &[Int, Hex, Size]),
+    sys(67, "pread64", &[Desc], &[Int, Hex, Size, Int]),
+    sys(68, "pwrite64", &[Desc], &[Int, Buf, Size, Int]),
+    sys(72, "pselect6", &[Desc], &[Int, Hex, Hex, Hex, Hex, Hex]),
+    sys(73, "ppoll", &[Desc], &[Hex, Size, Hex, Hex, Size]),
+    sys(78, "readlinkat", &[File, Desc], &[DirFd, Str, Hex, Size]),
+    sys(79, "newfstatat", &[File, Desc], &[DirFd, Str, Hex, Hex]),
+    sys(80, "fstat", &[Desc], &[Int, Hex]),
+    sys(82, "fsync", &[Desc], &[Int]),
+    sys(93, "exit", &[Process], &[Int]),
+    sys(94, "exit_group", &[Process], &[Int]),
+    sys(98, "futex", &[], &[Hex, Int, Int, Hex, Hex, Int]),
+    sys(101, "nanosleep", &[], &[Hex, Hex]),
+    sys(113, "clock_gettime", &[], &[Int, Hex]),
+    sys(115, "clock_nanosleep", &[], &[Int, Int, Hex, Hex]),
+    sys(124, "sched_yield", &[], &[]),
+    sys(129, "kill", &[Signal, Process], &[Int, Int]),
+    sys(130, "tkill", &[Signal], &[Int, Int]),
+    sys(131, "tgkill", &[Signal], &[Int, Int, Int]),
+    sys(134, "rt_sigaction", &[Signal], &[Int, Hex, Hex]),
+    sys(135, "rt_sigprocmask", &[Signal], &[Int, Hex, Hex]),
+    sys(160, "uname", &[], &[Hex]),
+    sys(172, "getpid", &[], &[]),
+    sys(178, "gettid", &[], &[]),
+    sys(198, "socket", &[Net], &[Int, Int, Int]),
+    sys(199, "socketpair", &[Net], &[Int, Int, Int, Hex]),
+    sys(200, "bind", &[Net, Desc], &[Int, Hex, Size]),
+    sys(201, "listen", &[Net, Desc], &[Int, Int]),
+    sys(202, "accept", &[Net, Desc], &[Int, Hex, Hex]),
+    sys(203, "connect", &[Net, Desc], &[Int, Hex, Size]),
+    sys(204, "getsockname", &[Net, Desc], &[Int, Hex, Hex]),
+    sys(205, "getpeername", &[Net, Desc], &[Int, Hex, Hex]),
+    sys(206, "sendto", &[Net, Desc], &[Int, Buf, Size, Hex, Hex, Size]),
+    sys(207, "recvfrom", &[Net, Desc], &[Int, Hex, Size, Hex, Hex, Hex]),
+    sys(208, "setsockopt", &[Net, Desc], &[Int, Int, Int, Hex, Size]),
+    sys(209, "getsockopt", &[Net, Desc], &[Int, Int, Int, Hex, Hex]),
+    sys(210, "shutdown", &[Net, Desc], &[Int, Int]),
+    sys(211, "sendmsg", &[Net, Desc], &[Int, Hex, Hex]),
+    sys(212, "recvmsg", &[Net, Desc], &[Int, Hex, Hex]),
+    sys(214, "brk", &[Memory], &[Hex]),
+    sys(215, "munmap", &[Memory], &[Hex, Size]),
+    sys(216, "mremap", &[Memory], &[Hex, Size, Size, Hex, Hex]),
+    sys(220, "clone", &[Process], &[Hex, Hex, Hex, Hex, Hex]),
+    sys(221, "execve", &[File, Process], &[Str, Hex, Hex]),
+    sys(222, "mmap", &[Memory, Desc], &[Hex, Size, Hex, Hex, Int, Hex]),
+    sys(226, "mprotect", &[Memory], &[Hex, Size, Hex]),
+    sys(233, "madvise", &[Memory], &[Hex, Size, Int]),
+    sys(242, "accept4", &[Net, Desc], &[Int, Hex, Hex, Hex]),
+    sys(260, "wait4", &[Process], &[Int, Hex, Hex, Hex]),
+    sys(278, "getrandom", &[], &[Hex, Size, Hex]),
+    sys(291, "statx", &[File, Desc], &[DirFd, Str, Hex, Hex, Hex]),
+    sys(435, "clone3", &[Process], &[Hex, Size]),
+];
+
+#[cfg(not(any(target_arch = "x86_64", target_arch = "aarch64")))]
+const SYSCALLS: &[Syscall] = &[];
+
+/// Names of the errno values syscalls most often fail with
+const ERRNOS: &[(i64, &str)] = &[
+    (1, "EPERM"),
+    (2, "ENOENT"),
+    (3, "ESRCH"),
+    (4, "EINTR"),
+    (5, "EIO"),
+    (9, "EBADF"),
+    (10, "ECHILD"),
+    (11, "EAGAIN"),
+    (12, "ENOMEM"),
+    (13, "EACCES"),
+    (14, "EFAULT"),
+    (16, "EBUSY"),
+    (17, "EEXIST"),
+    (20, "ENOTDIR"),
+    (21, "EISDIR"),
+    (22, "EINVAL"),
+    (24, "EMFILE"),
+    (25, "ENOTTY"),
+    (28, "ENOSPC"),
+    (32, "EPIPE"),
+    (38, "ENOSYS"),
+    (88, "ENOTSOCK"),
+    (98, "EADDRINUSE"),
+    (99, "EADDRNOTAVAIL"),
+    (101, "ENETUNREACH"),
+    (104, "ECONNRESET"),
+    (106, "EISCONN"),
+    (107, "ENOTCONN"),
+    (110, "ETIMEDOUT"),
+    (111, "ECONNREFUSED"),
+    (113, "EHOSTUNREACH"),
+    (115, "EINPROGRESS"),
+];
+
+fn lookup(nr: u64) -> Option<&'static Syscall> {
+    SYSCALLS.iter().find(|syscall| syscall.nr == nr)
+}
+
+/// A syscall's name, or its number for ones this table lacks
+pub fn name(nr: u64) -> String {
+    lookup(nr).map_or_else(|| format!("syscall_{}", nr), |syscall| syscall.name.to_string())
+}
+
+/// Which syscalls to log: every one, or those in the named classes or with
+/// the given names
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct Filter {
+    classes: Vec<Class>,
+    names: Vec<String>,
+}
+
+impl Filter {
+    /// Parse a comma-separated list of classes and syscall names
+    pub fn parse(spec: Option<&str>) -> Result<Filter> {
+        let mut filter = Filter::default();
+        for word in spec.unwrap_or_default().split(',').map(str::trim) {
+            if word.is_empty() {
+                continue;
+            }
+            if let Some(class) = Class::parse(word) {
+                filter.classes.push(class);
+            } else if SYSCALLS.iter().any(|syscall| syscall.name == word) {
+                filter.names.push(word.to_string());
+            } else {
+                return Err(Error::Trace(format!(
+                    "'{}' is neither a syscall nor one of file, desc, net, process, memory, \
+                     signal, ipc",
+                    word
+                )));
+            }
+        }
+        Ok(filter)
+    }
+
+    /// Whether every syscall passes
+    pub fn is_all(&self) -> bool {
+        self.classes.is_empty() && self.names.is_empty()
+    }
+
+    /// Whether syscall `nr` passes
+    pub fn matches(&self, nr: u64) -> bool {
+        if self.is_all() {
+            return true;
+        }
+        lookup(nr).is_some_and(|syscall| {
+            self.names.iter().any(|name| name == syscall.name)
+                || syscall.classes.iter().any(|class| self.classes.contains(class))
+        })
+    }
+
+    /// Names of the syscalls that pass, empty for every one
+    pub fn syscall_names(&self) -> Vec<&'static str> {
+        if self.is_all() {
+            return Vec::new();
+        }
+        SYSCALLS
+            .iter()
+            .filter(|syscall| self.matches(syscall.nr))
+            .map(|syscall| syscall.name)
+            .collect()
+    }
+}
+
+/// A syscall's arguments as strace would show them, reading strings out of
+/// the memory of process `pid`
+pub fn arguments(pid: Option<u32>, nr: u64, args: &[u64; 6]) -> String {
+    let Some(syscall) = lookup(nr) else {
+        return args.iter().map(|arg| format!("{:#x}", arg)).collect::<Vec<_>>().join(", ");
+    };
+    let shown: Vec<String> = syscall
+        .args
+        .iter()
+        .zip(args)
+        .enumerate()
+        .map(|(index, (kind, &value))| match kind {
+            Int => (value as i64).to_string(),
+            Size => value.to_string(),
+            Hex => format!("{:#x}", value),
+            Oct if value == 0 => "0".to_string(),
+            Oct => format!("0{:o}", value),
+            DirFd if value as i64 == -100 => "AT_FDCWD".to_string(),
+            DirFd => (value as i64).to_string(),
+            Str => quoted(pid, value, None),
+            Buf => quoted(pid, value, args.get(index + 1).map(|&len| len as usize)),
+        })
+        .collect();
+    shown.join(", ")
+}
+
+/// A string argument in quotes, or its address if it cannot be read
+fn quoted(pid: Option<u32>, address: u64, len: Option<usize>) -> String {
+    if address == 0 {
+        return "NULL".to_string();
+    }
+    let want = len.unwrap_or(MAX_STRING).min(MAX_STRING);
+    let Some(mut bytes) = pid.and_then(|pid| read_memory(pid, address, want)) else {
+        return format!("{:#x}", address);
+    };
+    let cut = match len {
+        Some(len) => len > bytes.len(),
+        None => match bytes.iter().position(|&b| b == 0) {
+            Some(end) => {
+                bytes.truncate(end);
+                false
+            }
+            None => true,
+        },
+    };
+    format!("{:?}{}", String::from_utf8_lossy(&bytes), if cut { "..." } else { "" })
+}
+
+#[cfg(target_os = "linux")]
+fn read_memory(pid: u32, address: u64, len: usize) -> Option<Vec<u8>> {
+    use std::os::unix::fs::FileExt;
+
+    // Stay within the page, as the next one may not be mapped
+    let len = len.min(4096 - (address % 4096) as usize);
+    let file = std::fs::File::open(format!("/proc/{}/mem", pid)).ok()?;
+    let mut bytes = vec![0; len];
+    let read = file.read_at(&mut bytes, address).ok()?;
+    bytes.truncate(read);
+    Some(bytes)
+}
+
+#[cfg(not(target_os = "linux"))]
+fn read_memory(_pid: u32, _address: u64, _len: usize) -> Option<Vec<u8>> {
+    None
+}
+
+/// A syscall's return value: the errno name for failures, and addresses in
+/// hex for the ones that map memory
+pub fn result(nr: u64, value: i64) -> String {
+    if (-4095..0).contains(&value) {
+        return match ERRNOS.iter().find(|(errno, _)| *errno == -value) {
+            Some((_, name)) => format!("-1 {}", name),
+            None => format!("-1 errno {}", -value),
+        };
+    }
+    match lookup(nr).map(|syscall| syscall.name) {
+        Some("mmap" | "mremap" | "brk" | "shmat") => format!("{:#x}", value),
+        _ => value.to_string(),
+    }
+}
+
+/// A change the watcher saw in one of the program's threads
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum Observed {
+    Entered { thread_id: i64, at: Instant, nr: u64, arguments: String },
+    Left { thread_id: i64, at: Instant },
+}
+
+/// A thread reading the program's `/proc` files, for adapters that cannot
+/// stop at syscalls
+#[derive(Debug)]
+pub struct ProcWatch {
+    stop: Arc<AtomicBool>,
+    events: mpsc::UnboundedReceiver<Observed>,
+}
+
+impl ProcWatch {
+    /// Watch process `pid`'s threads for the syscalls `filter` passes
+    pub fn start(pid: u32, filter: Filter) -> Result<ProcWatch> {
+        let tasks = format!("/proc/{}/task", pid);
+        std::fs::read_dir(&tasks)
+            .and_then(|mut entries| match entries.next() {
+                Some(entry) => std::fs::read_to_string(entry?.path().join("syscall")),
+                None => Ok(String::new()),
+            })
+            .map_err(|e| Error::Trace(format!("cannot read {}: {}", tasks, e)))?;
+
+        let stop = Arc::new(AtomicBool::new(false));
+        let (sender, events) = mpsc::unbounded_channel();
+        let stopped = stop.clone();
+        std::thread::spawn(move || watch(pid, &filter, &stopped, &sender));
+        Ok(ProcWatch { stop, events })
+    }
+
+    /// What was seen since the last call
+    pub fn drain(&mut self) -> Vec<Observed> {
+        let mut observed = Vec::new();
+        while let Ok(change) = self.events.try_recv() {
+            observed.push(change);
+        }
+        observed
+    }
+}
+
+impl Drop for ProcWatch {
+    fn drop(&mut self) {
+        self.stop.store(true, Ordering::Relaxed);
+    }
+}
+
+fn watch(pid: u32, filter: &Filter, stop: &AtomicBool, sender: &mpsc::UnboundedSender<Observed>) {
+    let tasks = format!("/proc/{}/task", pid);
+    // The syscall each thread was last seen in
+    let mut current: HashMap<i64, (u64, [u64; 6])> = HashMap::new();
+    while !stop.load(Ordering::Relaxed) {
+        let Ok(entries) = std::fs::read_dir(&tasks) else {
+            // The program exited
+            return;
+        };
+        let mut threads = Vec::new();
+        for entry in entries.flatten() {
+            let Some(thread_id) = entry.file_name().to_str().and_then(|s| s.parse().ok()) else {
+                continue;
+            };
+            threads.push(thread_id);
+            let now = std::fs::read_to_string(entry.path().join("syscall"))
+                .ok()
+                .and_then(|text| parse(&text))
+                .filter(|(nr, _)| filter.matches(*nr));
+            let before = current.get(&thread_id).copied();
+            if now == before {
+                continue;
+            }
+            let at = Instant::now();
+            if before.is_some() {
+                current.remove(&thread_id);
+                let _ = sender.send(Observed::Left { thread_id, at });
+            }
+            if let Some((nr, args)) = now {
+                current.insert(thread_id, (nr, args));
+                let arguments = arguments(Some(pid), nr, &args);
+                let _ = sender.send(Observed::Entered { thread_id, at, nr, arguments });
+            }
+        }
+        current.retain(|thread_id, _| {
+            let alive = threads.contains(thread_id);
+            if !alive {
+                let at = Instant::now();
+                let _ = sender.send(Observed::Left { thread_id: *thread_id, at });
+            }
+            alive
+        });
+        std::thread::sleep(POLL_INTERVAL);
+    }
+}
+
+/// The syscall number and arguments in a thread's `syscall` file, which
+/// says `running` or starts with -1 when the thread is not in one
+fn parse(text: &str) -> Option<(u64, [u64; 6])> {
+    let mut fields = text.split_whitespace();
+    let nr = fields.next()?.parse::<u64>().ok()?;
+    let mut args = [0; 6];
+    for arg in &mut args {
+        *arg = u64::from_str_radix(fields.next()?.trim_start_matches("0x"), 16).ok()?;
+    }
+    Some((nr, args))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn the_syscall_file_gives_number_and_arguments() {
+        assert_eq!(parse("running\n"), None);
+        assert_eq!(parse("-1 0x7ffd5e1c8a48 0x7f3b2c1e5a9d\n"), None);
+        assert_eq!(
+            parse("7 0x7ffd5e1c8b10 0x1 0xffffffffffffffff 0x0 0x0 0x0 0x7ffd5e1c8a48 0x7f3b\n"),
+            Some((7, [0x7ffd5e1c8b10, 1, u64::MAX, 0, 0, 0]))
+        );
+    }
+
+    #[test]
+    fn filters_take_classes_and_names() {
+        assert!(Filter::parse(None).unwrap().is_all());
+        assert!(Filter::parse(Some("sockets")).is_err());
+
+        let filter = Filter::parse(Some("net, close")).unwrap();
+        let names = filter.syscall_names();
+        assert!(names.contains(&"connect") && names.contains(&"close"));
+        assert!(!names.contains(&"openat"));
+    }
+
+    #[test]
+    fn results_name_the_error() {
+        let close = SYSCALLS.iter().find(|s| s.name == "close").unwrap().nr;
+        assert_eq!(result(close, 0), "0");
+        assert_eq!(result(close, -9), "-1 EBADF");
+        assert_eq!(result(close, -200), "-1 errno 200");
+        assert_eq!(arguments(None, close, &[u64::MAX, 0, 0, 0, 0, 0]), "-1");
+    }
+}
@@ -0,0 +1,92 @@
+//! The session's events on one time axis for `timeline`
+//!
+//! The session notes each stop the user sees, the program's output, threads
+//! starting and exiting and the program's exit as it handles the adapter's
+//! events, and the sampler adds every value it takes, each with the
+//! milliseconds since the session began. Events are kept in the order they
+//! happened, so a stop and the output after it never trade places even
+//! within the same millisecond.
+
+use std::collections::VecDeque;
+use std::time::Instant;
+
+use crate::common::time::timestamp;
+use crate::ipc::protocol::{TimelineEvent, TimelineKind};
+
+use super::crash::CRASH_REASONS;
+
+/// Events kept, the oldest dropped first
+const MAX_EVENTS: usize = 10_000;
+
+/// The session's events, oldest first
+#[derive(Debug)]
+pub struct Timeline {
+    origin: Instant,
+    events: VecDeque<TimelineEvent>,
+}
+
+impl Default for Timeline {
+    fn default() -> Self {
+        Self {
+            origin: Instant::now(),
+            events: VecDeque::new(),
+        }
+    }
+}
+
+impl Timeline {
+    /// Add an event that happened just now
+    pub fn push(&mut self, kind: TimelineKind, thread_id: Option<i64>, stop: u64, text: String) {
+        self.events.push_back(TimelineEvent {
+            elapsed_ms: self.origin.elapsed().as_millis() as u64,
+            time: timestamp(),
+            kind,
+            thread_id,
+            stop,
+            text,
+        });
+        if self.events.len() > MAX_EVENTS {
+            self.events.pop_front();
+        }
+    }
+
+    /// The program's output, a line an event
+    pub fn push_output(&mut self, stream: &str, output: &str, stop: u64) {
+        for line in output.lines().filter(|line| !line.trim().is_empty()) {
+            self.push(TimelineKind::Output, None, stop, format!("{}: {}", stream, line));
+        }
+    }
+
+    pub fn events(&self) -> impl Iterator<Item = &TimelineEvent> {
+        self.events.iter()
+    }
+}
+
+/// Whether a stop is for a signal, exception or panic
+pub fn stop_kind(reason: &str) -> TimelineKind {
+    if CRASH_REASONS.contains(&reason) {
+        TimelineKind::Signal
+    } else {
+        TimelineKind::Stop
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn output_is_split_into_lines_in_order() {
+        let mut timeline = Timeline::default();
+        timeline.push(TimelineKind::Stop, Some(2), 1, "breakpoint 1".into());
+        timeline.push_output("stdout", "worker 2 done\n\nworker 1 done\n", 1);
+
+        let events: Vec<&TimelineEvent> = timeline.events().collect();
+        assert_eq!(events.len(), 3);
+        assert_eq!(events[1].text, "stdout: worker 2 done");
+        assert_eq!((events[2].kind, events[2].thread_id), (TimelineKind::Output, None));
+        assert!(events[0].elapsed_ms <= events[2].elapsed_ms);
+        assert_eq!(stop_kind("signal"), TimelineKind::Signal);
+        assert_eq!(stop_kind("breakpoint"), TimelineKind::Stop);
+    }
+}
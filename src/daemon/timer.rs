@@ -0,0 +1,287 @@
+//! Wall time between two locations for `timer between`
+//!
+//! The timer puts a breakpoint at both locations. A thread's clock starts as
+//! the program is resumed at `from` and stops when the same thread next
+//! stops at `to`, and the time in between counts as one interval. Like the
+//! tracer's, these stops never reach `await` or the stop history unless one
+//! of the user's breakpoints is at the same place. While the timer runs the
+//! actor takes the adapter's events as they come rather than on its tick, so
+//! an interval is only off by what one stop costs.
+
+use std::collections::HashMap;
+use std::path::Path;
+use std::time::Duration;
+
+use serde_json::{json, Value};
+use tokio::time::Instant;
+
+use crate::common::settings::Settings;
+use crate::common::{Error, Result};
+use crate::dap::{Event, StackFrame, StoppedEventBody};
+use crate::ipc::protocol::BreakpointLocation;
+use crate::symbols::base_name;
+
+use super::handler::session_marker_line;
+use super::session::{DebugSession, SessionState, BURST};
+
+/// One of the two locations, as given and as the adapter placed it
+#[derive(Debug, Clone)]
+struct End {
+    text: String,
+    at: BreakpointLocation,
+}
+
+/// The intervals measured so far
+#[derive(Debug, Default)]
+struct Stats {
+    count: u64,
+    total: Duration,
+    min: Option<Duration>,
+    max: Option<Duration>,
+}
+
+impl Stats {
+    fn add(&mut self, interval: Duration) {
+        self.count += 1;
+        self.total += interval;
+        self.min = Some(self.min.map_or(interval, |min| min.min(interval)));
+        self.max = Some(self.max.map_or(interval, |max| max.max(interval)));
+    }
+}
+
+/// The two locations and the times between them
+#[derive(Debug, Default)]
+pub struct Timer {
+    ends: Option<(End, End)>,
+    active: bool,
+    /// When each thread left `from`
+    started: HashMap<i64, Instant>,
+    stats: Stats,
+    from_hits: u64,
+    to_hits: u64,
+}
+
+impl Timer {
+    /// Put breakpoints at `from` and `to` and time the way between them,
+    /// dropping the previous timings
+    pub async fn start(
+        &mut self,
+        sess: &mut DebugSession,
+        settings: &Settings,
+        from: &str,
+        to: &str,
+    ) -> Result<()> {
+        if self.active {
+            return Err(Error::Timer("the timer is already running".to_string()));
+        }
+        if !sess.trace_functions().is_empty()
+            || sess.catching_syscalls()
+            || sess.recording()
+            || sess.covering()
+        {
+            return Err(Error::Timer(
+                "the tracer, recorder or coverage would take the timer's stops; stop it first"
+                    .to_string(),
+            ));
+        }
+        let locations = vec![locate(sess, settings, from)?, locate(sess, settings, to)?];
+
+        let placed = match sess.set_timer_locations(locations).await {
+            Ok(placed) => placed,
+            Err(e) => {
+                let _ = sess.set_timer_locations(Vec::new()).await;
+                return Err(e);
+            }
+        };
+        let mut placed = placed.into_iter();
+        let (Some(Some(from_at)), Some(Some(to_at))) = (placed.next(), placed.next()) else {
+            let _ = sess.set_timer_locations(Vec::new()).await;
+            return Err(Error::Timer(format!(
+                "the adapter could not set breakpoints at both {} and {}",
+                from, to
+            )));
+        };
+
+        *self = Timer {
+            ends: Some((
+                End { text: from.to_string(), at: from_at },
+                End { text: to.to_string(), at: to_at },
+            )),
+            active: true,
+            ..Timer::default()
+        };
+        Ok(())
+    }
+
+    /// Remove the breakpoints, keeping the timings; false if the timer was
+    /// not running
+    pub async fn stop(&mut self, session: Option<&mut DebugSession>) -> Result<bool> {
+        if !std::mem::take(&mut self.active) {
+            return Ok(false);
+        }
+        self.started.clear();
+        if let Some(sess) = session {
+            sess.set_timer_locations(Vec::new()).await?;
+        }
+        Ok(true)
+    }
+
+    /// Whether the breakpoints are set, so stops should be seen promptly
+    pub fn is_active(&self) -> bool {
+        self.active
+    }
+
+    /// Start or stop the clock for the stops since the last look and let
+    /// the program run on; returns the events handled meanwhile
+    pub async fn resolve(&mut self, session: &mut Option<DebugSession>) -> Vec<Event> {
+        let mut events = Vec::new();
+        if !self.active {
+            return events;
+        }
+        let Some(sess) = session.as_mut() else {
+            // The breakpoints went with the session
+            self.active = false;
+            return events;
+        };
+
+        let deadline = Instant::now() + BURST;
+        let mut followed = false;
+        loop {
+            if matches!(sess.state(), SessionState::Exited | SessionState::Terminating) {
+                self.active = false;
+                break;
+            }
+            let Some(stop) = sess.held_stop().cloned() else {
+                if !followed || sess.state() != SessionState::Running {
+                    break;
+                }
+                match sess.next_event(deadline).await {
+                    Some(event) => {
+                        events.push(event);
+                        continue;
+                    }
+                    None => break,
+                }
+            };
+            match self.follow(sess, &stop).await {
+                Ok(true) => followed = true,
+                Ok(false) => {
+                    sess.release_stop();
+                    break;
+                }
+                Err(e) => {
+                    tracing::warn!("Could not follow a timer breakpoint: {}", e);
+                    sess.release_stop();
+                    break;
+                }
+            }
+        }
+        events
+    }
+
+    /// Count a held stop at either location and resume the program; false
+    /// if the stop is for the user to see
+    async fn follow(&mut self, sess: &mut DebugSession, stop: &StoppedEventBody) -> Result<bool> {
+        // Before asking for the stack, which takes a round trip of its own
+        let stopped = Instant::now();
+        let Some((from, to)) = &self.ends else {
+            return Ok(false);
+        };
+        let frames = sess.stack_trace(stop.thread_id, 1).await?;
+        let Some(top) = frames.first() else {
+            return Ok(false);
+        };
+        let (at_from, at_to) = (is_at(&from.at, top), is_at(&to.at, top));
+        if !at_from && !at_to {
+            return Ok(false);
+        }
+
+        let thread = stop.thread_id.unwrap_or_default();
+        if at_to {
+            self.to_hits += 1;
+            if let Some(started) = self.started.remove(&thread) {
+                self.stats.add(stopped - started);
+            }
+        }
+        if at_from {
+            self.from_hits += 1;
+        }
+        // The clock would run on while the user looks at the stop
+        if sess.stepping() || sess.user_breakpoint_at(top, base_name(&top.name)) {
+            self.started.remove(&thread);
+            return Ok(false);
+        }
+        if at_from {
+            self.started.insert(thread, Instant::now());
+        }
+        sess.continue_execution().await?;
+        Ok(true)
+    }
+
+    /// The locations, hit counts and intervals in milliseconds
+    pub fn data(&self) -> Value {
+        let ms = |duration: Duration| duration.as_secs_f64() * 1000.0;
+        let avg = (self.stats.count > 0).then(|| ms(self.stats.total) / self.stats.count as f64);
+        let (from, to) = match &self.ends {
+            Some((from, to)) => (Some(from.text.as_str()), Some(to.text.as_str())),
+            None => (None, None),
+        };
+        json!({
+            "timing": self.active,
+            "from": from,
+            "to": to,
+            "from_hits": self.from_hits,
+            "to_hits": self.to_hits,
+            "intervals": self.stats.count,
+            "min_ms": self.stats.min.map(ms),
+            "avg_ms": avg,
+            "max_ms": self.stats.max.map(ms),
+            "total_ms": ms(self.stats.total),
+        })
+    }
+}
+
+/// A location as `break` takes it, or the line a `@marker:NAME` names
+fn locate(sess: &mut DebugSession, settings: &Settings, text: &str) -> Result<BreakpointLocation> {
+    match BreakpointLocation::parse(text)? {
+        BreakpointLocation::Address { .. } => Err(Error::InvalidLocation(format!(
+            "'{}': the timer takes a line or a function, not an address",
+            text
+        ))),
+        location => session_marker_line(sess, settings, location),
+    }
+}
+
+/// Whether a frame is at a location, a line by the end of its path
+fn is_at(location: &BreakpointLocation, frame: &StackFrame) -> bool {
+    match location {
+        BreakpointLocation::Line { file, line } => {
+            let path = frame.source.as_ref().and_then(|source| source.path.as_deref());
+            *line == frame.line && path.is_some_and(|path| Path::new(path).ends_with(file))
+        }
+        BreakpointLocation::Function { name } => base_name(&frame.name) == name,
+        BreakpointLocation::Address { .. } => false,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn intervals_are_summarised_in_milliseconds() {
+        let mut timer = Timer::default();
+        assert_eq!(timer.data()["intervals"], 0);
+        assert_eq!(timer.data()["avg_ms"], Value::Null);
+
+        for ms in [10, 30, 20] {
+            timer.stats.add(Duration::from_millis(ms));
+        }
+        let data = timer.data();
+        assert_eq!(data["intervals"], 3);
+        assert_eq!(data["min_ms"], 10.0);
+        assert_eq!(data["avg_ms"], 20.0);
+        assert_eq!(data["max_ms"], 30.0);
+        assert_eq!(data["total_ms"], 60.0);
+    }
+}
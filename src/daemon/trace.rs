@@ -0,0 +1,557 @@
+//! Function call tracing
+//!
+//! `trace functions GLOB` puts a breakpoint on every function whose name
+//! matches. When one is hit the tracer logs the call with its arguments and
+//! steps out of it, logging the return and how long the call took, then lets
+//! the program run on, like a small uftrace. The session holds any stop that
+//! could be the tracer's until the tracer has looked at it, so `await` and
+//! the stop history only see the program's other stops. Every traced call
+//! costs two stops; the program runs slower but never stays stopped.
+//!
+//! Returns are found by stepping out, so a stop at one of the user's own
+//! breakpoints inside a traced call loses that call's return. The next
+//! traced event on the thread notices from the stack depth and closes the
+//! calls it missed.
+//!
+//! `trace syscalls` adds the program's syscalls to the same log, indented
+//! under the traced call they happen in; `syscalls` says how they are seen.
+
+use std::collections::{HashMap, VecDeque};
+use std::time::Instant;
+
+use crate::common::{Error, Result};
+use crate::dap::{Event, StoppedEventBody};
+use crate::ipc::protocol::{TraceEntry, TraceKind};
+use crate::symbols::{base_name, dwarf};
+
+use super::session::{DebugSession, SessionState, BURST};
+use super::syscalls::{self, Filter, Observed, ProcWatch};
+
+/// Functions traced at once
+const MAX_FUNCTIONS: usize = 500;
+
+/// Entries kept in the log
+const MAX_ENTRIES: usize = 100_000;
+
+/// Frames fetched to tell how deep a call is
+const MAX_FRAMES: usize = 1024;
+
+/// Registers holding a syscall's arguments on x86-64
+const ARGUMENT_REGISTERS: [&str; 6] = ["$rdi", "$rsi", "$rdx", "$r10", "$r8", "$r9"];
+
+/// A traced call that has not returned yet
+#[derive(Debug)]
+struct OpenCall {
+    function: String,
+    /// Stack depth inside the call
+    frames: usize,
+    started: Instant,
+}
+
+/// How the tracer sees syscalls
+#[derive(Debug)]
+enum SyscallSource {
+    /// A GDB catchpoint, by number
+    Catchpoint(u32),
+    Proc(ProcWatch),
+}
+
+/// The trace log and the calls in progress
+#[derive(Debug, Default)]
+pub struct Tracer {
+    /// Parameter names from the debug info, for adapters that mix arguments
+    /// into the locals
+    parameters: HashMap<String, Vec<String>>,
+    /// Open calls by thread, innermost last
+    open: HashMap<i64, Vec<OpenCall>>,
+    syscalls: Option<SyscallSource>,
+    /// The syscall each thread is in, by number, and when it was entered
+    in_syscall: HashMap<i64, (u64, Instant)>,
+    entries: VecDeque<TraceEntry>,
+    last_seq: u64,
+    /// When tracing began, for `elapsed_us`
+    origin: Option<Instant>,
+}
+
+impl Tracer {
+    /// Trace the functions whose names match `pattern`, returning the names
+    /// and how many breakpoints the adapter set
+    pub async fn add(
+        &mut self,
+        sess: &mut DebugSession,
+        pattern: &str,
+    ) -> Result<(Vec<String>, usize)> {
+        refuse_while_holding(sess)?;
+        if !sess.supports_function_breakpoints() {
+            return Err(Error::Trace(
+                "the debug adapter cannot set function breakpoints".to_string(),
+            ));
+        }
+        let mut matched: Vec<String> = sess
+            .symbols()?
+            .functions
+            .iter()
+            .map(|function| base_name(&function.name))
+            .filter(|name| glob(pattern, name))
+            .map(String::from)
+            .collect();
+        matched.sort();
+        matched.dedup();
+        if matched.is_empty() {
+            return Err(Error::Trace(format!("no function matches '{}'", pattern)));
+        }
+
+        let mut functions = sess.trace_functions().to_vec();
+        for name in &matched {
+            if !functions.contains(name) {
+                functions.push(name.clone());
+            }
+        }
+        if functions.len() > MAX_FUNCTIONS {
+            return Err(Error::Trace(format!(
+                "'{}' would trace {} functions; trace at most {} at once",
+                pattern,
+                functions.len(),
+                MAX_FUNCTIONS
+            )));
+        }
+
+        // Without debug info the calls are still logged, only without arguments
+        let path = sess.symbols_path();
+        let mut parameters = dwarf::parameters(&path).unwrap_or_default();
+        parameters.retain(|name, _| matched.contains(name));
+        self.parameters.extend(parameters);
+
+        let set = sess.set_trace_functions(functions).await?;
+        self.origin.get_or_insert_with(Instant::now);
+        Ok((matched, set))
+    }
+
+    /// Remove the trace breakpoints, returning how many functions were traced
+    pub async fn stop(&mut self, session: &mut Option<DebugSession>) -> Result<usize> {
+        self.open.clear();
+        let Some(sess) = session.as_mut() else {
+            return Ok(0);
+        };
+        let traced = sess.trace_functions().len();
+        sess.set_trace_functions(Vec::new()).await?;
+        Ok(traced)
+    }
+
+    /// Trace the syscalls `filter` passes, in place of any traced before;
+    /// returns whether their return values will be known
+    pub async fn add_syscalls(
+        &mut self,
+        sess: &mut DebugSession,
+        filter: Option<&str>,
+    ) -> Result<bool> {
+        refuse_while_holding(sess)?;
+        let filter = Filter::parse(filter)?;
+        self.stop_syscalls(Some(&mut *sess)).await?;
+
+        let source =
+            if cfg!(target_arch = "x86_64") && matches!(sess.adapter_name(), "gdb" | "cuda-gdb") {
+                let mut command = "catch syscall".to_string();
+                for name in filter.syscall_names() {
+                    command.push(' ');
+                    command.push_str(name);
+                }
+                let output = gdb_command(sess, &command).await?;
+                let number = catchpoint_number(&output).ok_or_else(|| {
+                    Error::Trace(format!("GDB did not set a catchpoint: {}", output.trim()))
+                })?;
+                sess.set_catching_syscalls(true);
+                SyscallSource::Catchpoint(number)
+            } else {
+                let pid = sess.process_id().ok_or_else(|| {
+                    Error::Trace("the debug adapter did not report the program's process ID".into())
+                })?;
+                SyscallSource::Proc(ProcWatch::start(pid, filter)?)
+            };
+        let returns = matches!(source, SyscallSource::Catchpoint(_));
+        self.syscalls = Some(source);
+        self.origin.get_or_insert_with(Instant::now);
+        Ok(returns)
+    }
+
+    /// Stop tracing syscalls, returning whether they were traced
+    pub async fn stop_syscalls(&mut self, session: Option<&mut DebugSession>) -> Result<bool> {
+        self.in_syscall.clear();
+        let Some(source) = self.syscalls.take() else {
+            return Ok(false);
+        };
+        if let (SyscallSource::Catchpoint(number), Some(sess)) = (source, session) {
+            // A new session does not have the old one's catchpoint
+            if sess.catching_syscalls() && sess.state() != SessionState::Exited {
+                gdb_command(sess, &format!("delete {}", number)).await?;
+            }
+            sess.set_catching_syscalls(false);
+        }
+        Ok(true)
+    }
+
+    /// Log entries after `since`, oldest first
+    pub fn entries(&self, since: u64) -> Vec<TraceEntry> {
+        self.entries
+            .iter()
+            .filter(|entry| entry.seq > since)
+            .cloned()
+            .collect()
+    }
+
+    /// Number of the latest entry, 0 before the first
+    pub fn last_seq(&self) -> u64 {
+        self.last_seq
+    }
+
+    /// Drop the log; entry numbers carry on, so followers are not confused
+    pub fn clear(&mut self) {
+        self.entries.clear();
+    }
+
+    /// Look at the session's held stop, if any, and at the traced calls that
+    /// follow it closely; returns the events handled meanwhile
+    pub async fn resolve(&mut self, session: &mut Option<DebugSession>) -> Vec<Event> {
+        let mut events = Vec::new();
+        let deadline = tokio::time::Instant::now() + BURST;
+        let mut followed = false;
+        while let Some(sess) = session.as_mut() {
+            self.drain_syscalls();
+            if sess.recording() || sess.covering() || sess.timing() {
+                // Nothing is traced meanwhile and the stops are someone else's
+                break;
+            }
+            let Some(stop) = sess.held_stop().cloned() else {
+                // Right after a traced call the next is often close behind
+                if !followed || sess.state() != SessionState::Running {
+                    break;
+                }
+                match sess.next_event(deadline).await {
+                    Some(event) => {
+                        events.push(event);
+                        continue;
+                    }
+                    None => break,
+                }
+            };
+            match self.follow(sess, &stop).await {
+                Ok(true) => followed = true,
+                Ok(false) => {
+                    sess.release_stop();
+                    break;
+                }
+                Err(e) => {
+                    tracing::warn!("Could not follow a traced call: {}", e);
+                    sess.release_stop();
+                    break;
+                }
+            }
+        }
+        events
+    }
+
+    /// Log the call, return or syscall at a held stop and resume the
+    /// program; false if the stop is not the tracer's
+    async fn follow(&mut self, sess: &mut DebugSession, stop: &StoppedEventBody) -> Result<bool> {
+        let Some(thread_id) = stop.thread_id else {
+            return Ok(false);
+        };
+        let now = Instant::now();
+        if matches!(self.syscalls, Some(SyscallSource::Catchpoint(_)))
+            && self.caught_syscall(sess, thread_id, now).await
+        {
+            // A step that ran into the catchpoint still ends where it stopped
+            if sess.stepping() {
+                return Ok(false);
+            }
+        } else {
+            let frames = sess.stack_trace(Some(thread_id), MAX_FRAMES).await?;
+            if stop.reason == "step" && sess.trace_stepping() {
+                self.returned(thread_id, frames.len(), now);
+            } else {
+                let Some(top) = frames.first() else {
+                    return Ok(false);
+                };
+                let function = base_name(&top.name).to_string();
+                let breakpoint =
+                    matches!(stop.reason.as_str(), "breakpoint" | "function breakpoint");
+                let traced = breakpoint && sess.trace_functions().contains(&function);
+                if !traced || sess.user_breakpoint_at(top, &function) {
+                    return Ok(false);
+                }
+                // Calls as deep as this one that are still open returned unseen
+                self.returned(thread_id, frames.len() - 1, now);
+                let arguments = self.arguments(sess, top.id, &function).await;
+                let depth = self.depth(thread_id);
+                self.log(now, thread_id, depth, TraceKind::Call, function.clone(), arguments);
+                self.open.entry(thread_id).or_default().push(OpenCall {
+                    function,
+                    frames: frames.len(),
+                    started: now,
+                });
+            }
+        }
+
+        if self.depth(thread_id) > 0 {
+            sess.step_out_traced().await?;
+        } else {
+            sess.continue_execution().await?;
+        }
+        Ok(true)
+    }
+
+    /// Log the syscall entry or return a catchpoint stopped at; false if the
+    /// thread is not at one
+    async fn caught_syscall(
+        &mut self,
+        sess: &mut DebugSession,
+        thread_id: i64,
+        at: Instant,
+    ) -> bool {
+        // Anything but a syscall stop leaves -1 here
+        let Some(nr) = register(sess, "$orig_rax").await.filter(|&nr| nr >= 0) else {
+            return false;
+        };
+        let nr = nr as u64;
+        if self.in_syscall.get(&thread_id).is_some_and(|&(open, _)| open == nr) {
+            let value = register(sess, "$rax").await;
+            self.left(thread_id, at, value);
+        } else {
+            let mut args = [0; 6];
+            for (arg, name) in args.iter_mut().zip(ARGUMENT_REGISTERS) {
+                *arg = register(sess, name).await.unwrap_or_default() as u64;
+            }
+            // The return of the thread's previous syscall went unseen
+            self.left(thread_id, at, None);
+            let arguments = syscalls::arguments(sess.process_id(), nr, &args);
+            self.entered(thread_id, at, nr, arguments);
+        }
+        true
+    }
+
+    /// Log what the `/proc` watcher has seen since last time
+    fn drain_syscalls(&mut self) {
+        let observed = match &mut self.syscalls {
+            Some(SyscallSource::Proc(watch)) => watch.drain(),
+            _ => return,
+        };
+        for change in observed {
+            match change {
+                Observed::Entered { thread_id, at, nr, arguments } => {
+                    self.entered(thread_id, at, nr, arguments)
+                }
+                Observed::Left { thread_id, at } => self.left(thread_id, at, None),
+            }
+        }
+    }
+
+    fn entered(&mut self, thread_id: i64, at: Instant, nr: u64, arguments: String) {
+        let depth = self.depth(thread_id);
+        let name = syscalls::name(nr);
+        self.log(at, thread_id, depth, TraceKind::Syscall, name, Some(arguments));
+        self.in_syscall.insert(thread_id, (nr, at));
+    }
+
+    /// Close the thread's syscall, with its return value if known
+    fn left(&mut self, thread_id: i64, at: Instant, value: Option<i64>) {
+        let Some((nr, started)) = self.in_syscall.remove(&thread_id) else {
+            return;
+        };
+        let depth = self.depth(thread_id);
+        self.log(at, thread_id, depth, TraceKind::SyscallReturn, syscalls::name(nr), None);
+        if let Some(entry) = self.entries.back_mut() {
+            entry.duration_us = Some(at.saturating_duration_since(started).as_micros() as u64);
+            entry.result = value.map(|value| syscalls::result(nr, value));
+        }
+    }
+
+    /// Traced calls open on the thread
+    fn depth(&self, thread_id: i64) -> usize {
+        self.open.get(&thread_id).map_or(0, Vec::len)
+    }
+
+    /// Close the thread's open calls deeper than `frames`
+    fn returned(&mut self, thread_id: i64, frames: usize, at: Instant) {
+        let Some(calls) = self.open.get_mut(&thread_id) else {
+            return;
+        };
+        let mut closed = Vec::new();
+        while calls.last().is_some_and(|call| call.frames > frames) {
+            closed.extend(calls.pop().map(|call| (calls.len(), call)));
+        }
+        for (depth, call) in closed {
+            let duration = at.saturating_duration_since(call.started).as_micros() as u64;
+            self.log(at, thread_id, depth, TraceKind::Return, call.function, None);
+            if let Some(entry) = self.entries.back_mut() {
+                entry.duration_us = Some(duration);
+            }
+        }
+    }
+
+    /// The call's arguments as `name=value` pairs
+    async fn arguments(
+        &self,
+        sess: &mut DebugSession,
+        frame_id: i64,
+        function: &str,
+    ) -> Option<String> {
+        let scopes = sess.get_scopes(Some(frame_id)).await.ok()?;
+        let variables = match scopes.iter().find(|s| s.name.eq_ignore_ascii_case("arguments")) {
+            Some(scope) => sess.get_variables(scope.variables_reference).await.ok()?,
+            None => {
+                let names = self.parameters.get(function)?;
+                let scope = scopes
+                    .iter()
+                    .find(|s| s.name == "Locals" || s.name == "Local")
+                    .or(scopes.first())?;
+                let locals = sess.get_variables(scope.variables_reference).await.ok()?;
+                names
+                    .iter()
+                    .filter_map(|name| locals.iter().find(|local| local.name == *name).cloned())
+                    .collect()
+            }
+        };
+        let pairs: Vec<String> = variables
+            .iter()
+            .map(|variable| format!("{}={}", variable.name, variable.value))
+            .collect();
+        Some(pairs.join(", "))
+    }
+
+    fn log(
+        &mut self,
+        at: Instant,
+        thread_id: i64,
+        depth: usize,
+        kind: TraceKind,
+        function: String,
+        arguments: Option<String>,
+    ) {
+        let origin = *self.origin.get_or_insert(at);
+        self.last_seq += 1;
+        self.entries.push_back(TraceEntry {
+            seq: self.last_seq,
+            elapsed_us: at.saturating_duration_since(origin).as_micros() as u64,
+            thread_id,
+            depth,
+            kind,
+            function,
+            arguments,
+            duration_us: None,
+            result: None,
+        });
+        if self.entries.len() > MAX_ENTRIES {
+            self.entries.pop_front();
+        }
+    }
+}
+
+/// The recorder steps the program itself and holds every stop, and coverage
+/// and the timer hold those at breakpoints
+fn refuse_while_holding(sess: &DebugSession) -> Result<()> {
+    if sess.recording() {
+        return Err(Error::Trace("a recording is in progress; see 'record stop'".to_string()));
+    }
+    if sess.covering() {
+        return Err(Error::Trace("coverage is running; see 'coverage stop'".to_string()));
+    }
+    if sess.timing() {
+        return Err(Error::Trace("the timer is running; see 'timer stop'".to_string()));
+    }
+    Ok(())
+}
+
+/// Run a GDB command, pausing the program for it if it is running
+pub async fn gdb_command(sess: &mut DebugSession, command: &str) -> Result<String> {
+    let resume = sess.state() == SessionState::Running
+        && sess.interrupt(DebugSession::INTERRUPT_TIMEOUT).await?;
+    let output = sess.evaluate(command, None, "repl").await.map(|result| result.result);
+    if resume {
+        sess.continue_execution().await?;
+    }
+    output
+}
+
+/// The number in GDB's "Catchpoint 2 (syscalls 'read' [0])"
+fn catchpoint_number(output: &str) -> Option<u32> {
+    let rest = &output[output.find("Catchpoint ")? + "Catchpoint ".len()..];
+    let digits = rest.find(|c: char| !c.is_ascii_digit()).unwrap_or(rest.len());
+    rest[..digits].parse().ok()
+}
+
+/// A register of the stopped thread's top frame, as a signed integer
+async fn register(sess: &mut DebugSession, name: &str) -> Option<i64> {
+    let value = sess.evaluate(name, None, "watch").await.ok()?.result;
+    let value = value.split_whitespace().next()?;
+    match value.strip_prefix("0x") {
+        Some(hex) => u64::from_str_radix(hex, 16).ok().map(|value| value as i64),
+        None => value.parse().ok(),
+    }
+}
+
+/// Shell-style matching: `*` is any run of characters, `?` any one
+fn glob(pattern: &str, text: &str) -> bool {
+    let pattern: Vec<char> = pattern.chars().collect();
+    let text: Vec<char> = text.chars().collect();
+    let (mut p, mut t) = (0, 0);
+    // Where the last `*` was, and the text position it has swallowed up to
+    let mut star: Option<(usize, usize)> = None;
+    while t < text.len() {
+        if p < pattern.len() && (pattern[p] == '?' || pattern[p] == text[t]) {
+            p += 1;
+            t += 1;
+        } else if p < pattern.len() && pattern[p] == '*' {
+            star = Some((p, t));
+            p += 1;
+        } else if let Some((star_p, star_t)) = star {
+            p = star_p + 1;
+            t = star_t + 1;
+            star = Some((star_p, star_t + 1));
+        } else {
+            return false;
+        }
+    }
+    pattern[p..].iter().all(|&c| c == '*')
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::time::Duration;
+
+    #[test]
+    fn globs_match_whole_names() {
+        assert!(glob("main.*", "main.run"));
+        assert!(glob("main.*", "main."));
+        assert!(!glob("main.*", "runtime.main"));
+        assert!(glob("*::parse_?", "json::parse_a"));
+        assert!(!glob("*::parse_?", "json::parse_ab"));
+        assert!(glob("*", "anything"));
+        assert!(glob("a*b*c", "aXbYbZc"));
+        assert!(!glob("a*b*c", "aXbYbZ"));
+    }
+
+    #[test]
+    fn returns_close_deeper_calls_innermost_first() {
+        let mut tracer = Tracer::default();
+        let start = Instant::now();
+        for (function, frames) in [("main.run", 2), ("main.step", 3)] {
+            tracer.open.entry(1).or_default().push(OpenCall {
+                function: function.to_string(),
+                frames,
+                started: start,
+            });
+        }
+
+        // Back in main, below both calls
+        tracer.returned(1, 1, start + Duration::from_millis(3));
+        let entries = tracer.entries(0);
+        let closed: Vec<(&str, usize, Option<u64>)> = entries
+            .iter()
+            .map(|entry| (entry.function.as_str(), entry.depth, entry.duration_us))
+            .collect();
+        assert_eq!(closed, [("main.step", 1, Some(3000)), ("main.run", 0, Some(3000))]);
+        assert!(tracer.open[&1].is_empty());
+        assert_eq!(tracer.entries(1).len(), 1);
+    }
+}
@@ -0,0 +1,343 @@
+//! Container sizes for `track size`
+//!
+//! A tracker measures a container at every stop, or every so often while the
+//! program runs, pausing it the way `sample` does. The length and capacity
+//! come from the value the adapter renders (Delve's `len: 3, cap: 4`, lldb's
+//! `size=3`, `of length 3, capacity 4` from GDB's printers) or its count of
+//! indexed children, and the bytes from `sizeof` in C-like languages and
+//! `sys.getsizeof` in Python. A container that has grown at each of the last
+//! `threshold` measurements without shrinking is flagged, and every stop
+//! shows the warning until it shrinks. Like samples, trackers outlive
+//! sessions.
+
+use std::collections::VecDeque;
+use std::time::{Duration, Instant};
+
+use crate::common::time::timestamp;
+use crate::ipc::protocol::{TrackSize, TrackerInfo};
+
+use super::session::{DebugSession, SessionState};
+
+/// Sizes kept per container
+const MAX_SIZES: usize = 10_000;
+
+/// What an adapter's expressions can ask about sizes
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Language {
+    Go,
+    Python,
+    /// C, C++ and the other languages lldb and GDB take `sizeof` in
+    Native,
+    Other,
+}
+
+impl Language {
+    fn of(adapter: &str) -> Self {
+        match adapter {
+            "go" | "delve" | "dlv" => Self::Go,
+            "debugpy" => Self::Python,
+            "lldb" | "lldb-dap" | "codelldb" | "gdb" | "cuda-gdb" => Self::Native,
+            _ => Self::Other,
+        }
+    }
+}
+
+#[derive(Debug)]
+struct Tracker {
+    id: u32,
+    expression: String,
+    /// `None` to measure at stops
+    every: Option<Duration>,
+    due: Instant,
+    threshold: u32,
+    sizes: VecDeque<TrackSize>,
+    /// Measurements it has grown at since it last shrank
+    growths: u32,
+    /// The size those growths started from
+    from: Option<u64>,
+    /// Bytes per element, once asked
+    element: Option<Option<u64>>,
+    /// The stop last measured this session
+    measured: Option<u64>,
+}
+
+impl Tracker {
+    fn growing(&self) -> bool {
+        self.threshold > 0 && self.growths >= self.threshold
+    }
+
+    /// Count a measurement towards a run of growths
+    fn record(&mut self, size: TrackSize) {
+        let before = self.sizes.iter().rev().find_map(amount);
+        match (before, amount(&size)) {
+            (Some(before), Some(after)) if after > before => {
+                if self.growths == 0 {
+                    self.from = Some(before);
+                }
+                self.growths += 1;
+            }
+            (Some(before), Some(after)) if after < before => {
+                self.growths = 0;
+                self.from = None;
+            }
+            _ => {}
+        }
+        self.sizes.push_back(size);
+        if self.sizes.len() > MAX_SIZES {
+            self.sizes.pop_front();
+        }
+    }
+
+    fn warning(&self) -> Option<String> {
+        if !self.growing() {
+            return None;
+        }
+        let now = self.sizes.iter().rev().find_map(amount)?;
+        Some(format!(
+            "{} grew {} times in a row, from {} to {}",
+            self.expression,
+            self.growths,
+            self.from.unwrap_or_default(),
+            now
+        ))
+    }
+}
+
+/// The size growth is judged by: the length, or the capacity or bytes
+/// when the length isn't known
+fn amount(size: &TrackSize) -> Option<u64> {
+    size.len.or(size.cap).or(size.bytes)
+}
+
+/// The tracked containers and their sizes
+#[derive(Debug, Default)]
+pub struct Tracks {
+    next_id: u32,
+    entries: Vec<Tracker>,
+}
+
+impl Tracks {
+    /// Track a container, at stops or every `every`, and return its ID
+    pub fn add(&mut self, expression: String, every: Option<Duration>, threshold: u32) -> u32 {
+        self.next_id += 1;
+        self.entries.push(Tracker {
+            id: self.next_id,
+            expression,
+            every,
+            due: Instant::now(),
+            threshold,
+            sizes: VecDeque::new(),
+            growths: 0,
+            from: None,
+            element: None,
+            measured: None,
+        });
+        self.next_id
+    }
+
+    /// Stop tracking a container; returns whether it existed
+    pub fn remove(&mut self, id: u32) -> bool {
+        let before = self.entries.len();
+        self.entries.retain(|tracker| tracker.id != id);
+        self.entries.len() != before
+    }
+
+    /// The tracked containers, in the order they were added
+    pub fn list(&self) -> Vec<TrackerInfo> {
+        self.entries
+            .iter()
+            .map(|tracker| TrackerInfo {
+                id: tracker.id,
+                expression: tracker.expression.clone(),
+                every_ms: tracker.every.map(|every| every.as_millis() as u64),
+                threshold: tracker.threshold,
+                count: tracker.sizes.len(),
+                last: tracker.sizes.back().cloned(),
+                growths: tracker.growths,
+                growing: tracker.growing(),
+            })
+            .collect()
+    }
+
+    /// A container's expression and sizes, oldest first
+    pub fn history(&self, id: u32) -> Option<(String, Vec<TrackSize>)> {
+        let tracker = self.entries.iter().find(|tracker| tracker.id == id)?;
+        Some((tracker.expression.clone(), tracker.sizes.iter().cloned().collect()))
+    }
+
+    /// A line for each container that keeps growing
+    pub fn warnings(&self) -> Vec<String> {
+        self.entries.iter().filter_map(Tracker::warning).collect()
+    }
+
+    /// Measure the containers tracked at stops, once per stop
+    pub async fn at_stop(&mut self, session: &mut Option<DebugSession>) {
+        let Some(sess) = session.as_mut() else {
+            // Stop numbers start again with the next session
+            for tracker in &mut self.entries {
+                tracker.measured = None;
+            }
+            return;
+        };
+        if sess.state() != SessionState::Stopped || sess.held_stop().is_some() {
+            return;
+        }
+        let stop = sess.stop_count();
+        for tracker in &mut self.entries {
+            if tracker.every.is_none() && tracker.measured != Some(stop) {
+                tracker.measured = Some(stop);
+                let size = measure(sess, &tracker.expression, &mut tracker.element).await;
+                tracker.record(size);
+            }
+        }
+    }
+
+    /// Interrupt the program to measure the containers that are due
+    pub async fn sample(&mut self, session: &mut Option<DebugSession>) {
+        let now = Instant::now();
+        let due = |tracker: &Tracker| tracker.every.is_some() && tracker.due <= now;
+        if !self.entries.iter().any(due) {
+            return;
+        }
+        let Some(sess) = session.as_mut() else {
+            return;
+        };
+        if sess.state() != SessionState::Running {
+            return;
+        }
+
+        let interrupted = match sess.interrupt(DebugSession::INTERRUPT_TIMEOUT).await {
+            Ok(interrupted) => interrupted,
+            Err(e) => {
+                tracing::warn!("Could not interrupt the program to measure sizes: {}", e);
+                return;
+            }
+        };
+        if sess.state() == SessionState::Stopped {
+            for tracker in self.entries.iter_mut().filter(|tracker| due(tracker)) {
+                let size = measure(sess, &tracker.expression, &mut tracker.element).await;
+                tracker.record(size);
+                tracker.due = now + tracker.every.unwrap_or_default();
+            }
+        }
+        if interrupted {
+            if let Err(e) = sess.continue_execution().await {
+                tracing::warn!("Could not resume the program after measuring sizes: {}", e);
+            }
+        }
+    }
+}
+
+/// A container's length, capacity and bytes, as far as they can be told
+async fn measure(
+    sess: &mut DebugSession,
+    expression: &str,
+    element: &mut Option<Option<u64>>,
+) -> TrackSize {
+    let mut size = TrackSize {
+        stop: sess.stop_count(),
+        time: timestamp(),
+        len: None,
+        cap: None,
+        bytes: None,
+        error: None,
+    };
+    let body = match sess.evaluate(expression, None, "watch").await {
+        Ok(body) => body,
+        Err(e) => {
+            size.error = Some(e.to_string());
+            return size;
+        }
+    };
+    (size.len, size.cap) = rendered_size(&body.result);
+    if size.len.is_none() {
+        size.len = body.indexed_variables.and_then(|count| u64::try_from(count).ok());
+    }
+
+    let language = Language::of(sess.adapter_name());
+    if size.len.is_none() && matches!(language, Language::Go | Language::Python) {
+        size.len = count(sess, &format!("len({})", expression)).await;
+    }
+    if size.cap.is_none() && language == Language::Go {
+        size.cap = count(sess, &format!("cap({})", expression)).await;
+    }
+    size.bytes = match language {
+        Language::Python => {
+            count(sess, &format!("__import__('sys').getsizeof({})", expression)).await
+        }
+        Language::Native => {
+            if element.is_none() {
+                *element = Some(count(sess, &format!("sizeof(({})[0])", expression)).await);
+            }
+            element.flatten().zip(size.cap.or(size.len)).map(|(bytes, count)| bytes * count)
+        }
+        Language::Go | Language::Other => None,
+    };
+    size
+}
+
+/// The whole number an expression evaluates to, if it does
+async fn count(sess: &mut DebugSession, expression: &str) -> Option<u64> {
+    let body = sess.evaluate(expression, None, "watch").await.ok()?;
+    // lldb may put the type first, as in `(unsigned long) 8`
+    body.result.split_whitespace().last()?.parse().ok()
+}
+
+/// The length and capacity in a rendered value
+fn rendered_size(value: &str) -> (Option<u64>, Option<u64>) {
+    let find = |keys: &[&str]| keys.iter().find_map(|key| number_after(value, key));
+    (
+        find(&["len: ", "len=", "size=", "length=", "of length ", "Array("]),
+        find(&["cap: ", "cap=", "capacity=", "capacity "]),
+    )
+}
+
+fn number_after(value: &str, key: &str) -> Option<u64> {
+    let rest = &value[value.find(key)? + key.len()..];
+    let end = rest.find(|c: char| !c.is_ascii_digit()).unwrap_or(rest.len());
+    rest[..end].parse().ok()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn size(len: Option<u64>) -> TrackSize {
+        TrackSize {
+            stop: 0,
+            time: String::new(),
+            len,
+            cap: None,
+            bytes: None,
+            error: None,
+        }
+    }
+
+    #[test]
+    fn growing_is_flagged_until_the_container_shrinks() {
+        assert_eq!(rendered_size("[]int len: 3, cap: 4, [1,2,3]"), (Some(3), Some(4)));
+        assert_eq!(rendered_size("size=12"), (Some(12), None));
+        assert_eq!(
+            rendered_size("std::vector of length 3, capacity 4 = {1, 2, 3}"),
+            (Some(3), Some(4))
+        );
+        assert_eq!(rendered_size("{a = 1}"), (None, None));
+
+        let mut tracks = Tracks::default();
+        let id = tracks.add("items".into(), None, 3);
+        let tracker = &mut tracks.entries[0];
+        for len in [Some(1), Some(2), None, Some(2), Some(4)] {
+            tracker.record(size(len));
+        }
+        assert!(!tracker.growing());
+        tracker.record(size(Some(8)));
+        assert_eq!(tracks.warnings(), ["items grew 3 times in a row, from 1 to 8"]);
+        assert!(tracks.list()[0].growing);
+
+        tracks.entries[0].record(size(Some(5)));
+        assert!(tracks.warnings().is_empty());
+        assert_eq!(tracks.history(id).unwrap().1.len(), 7);
+        assert!(tracks.remove(id));
+        assert!(!tracks.remove(id));
+    }
+}
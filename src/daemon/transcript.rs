@@ -0,0 +1,172 @@
+//! Session transcripts
+//!
+//! While a transcript is recording, the daemon appends every CLI command
+//! with its output (sent by the CLI once the command finishes), the
+//! debuggee's stdout and stderr as they arrive, the stops between them, and
+//! notes from `transcript annotate`, each stamped with the UTC time. The file
+//! is plain text meant to be read or attached to a bug report as is.
+
+use std::fs::File;
+use std::io::Write;
+use std::path::{Path, PathBuf};
+
+use crate::common::time::timestamp;
+use crate::common::Result;
+use crate::ipc::protocol::StopRecord;
+
+/// An open transcript file
+#[derive(Debug)]
+pub struct Transcript {
+    path: PathBuf,
+    file: File,
+    /// The session's stop count when stops were last recorded
+    stops_seen: Option<u64>,
+}
+
+impl Transcript {
+    /// Start recording, appending to the file if it exists
+    pub fn start(path: &Path) -> Result<Self> {
+        let file = std::fs::OpenOptions::new()
+            .create(true)
+            .append(true)
+            .open(path)?;
+        let mut transcript = Self {
+            path: path.to_path_buf(),
+            file,
+            stops_seen: None,
+        };
+        transcript.write(&format!("[{}] transcript started\n", timestamp()));
+        Ok(transcript)
+    }
+
+    pub fn path(&self) -> &Path {
+        &self.path
+    }
+
+    /// A command line and everything it printed
+    pub fn record_command(&mut self, command_line: &str, output: &str) {
+        let mut entry = format!("[{}] $ debugger {}\n", timestamp(), command_line);
+        for line in output.lines() {
+            entry.push_str(line);
+            entry.push('\n');
+        }
+        self.write(&entry);
+    }
+
+    /// Output from the debuggee, one prefixed line per line of output
+    pub fn record_output(&mut self, category: &str, output: &str) {
+        let stamp = timestamp();
+        let mut entry = String::new();
+        for line in output.lines() {
+            entry.push_str(&format!("[{}] {}> {}\n", stamp, category, line));
+        }
+        self.write(&entry);
+    }
+
+    /// The stops among `stops` since the last look, `count` being the
+    /// session's stop count; the first look only notes where it stands
+    pub fn record_stops<'a>(&mut self, count: u64, stops: impl Iterator<Item = &'a StopRecord>) {
+        let seen = match self.stops_seen.replace(count) {
+            // A new session counts from 1 again
+            Some(seen) if seen <= count => seen,
+            Some(_) => 0,
+            None => return,
+        };
+        let mut entry = String::new();
+        for stop in stops.filter(|stop| stop.number > seen) {
+            let mut details = Vec::new();
+            if !stop.breakpoints.is_empty() {
+                let ids: Vec<String> = stop.breakpoints.iter().map(u32::to_string).collect();
+                details.push(format!("breakpoint {}", ids.join(", ")));
+            }
+            if let Some(thread) = stop.thread_id {
+                details.push(format!("thread {}", thread));
+            }
+            entry.push_str(&format!("[{}] stop {}: {}", stop.time, stop.number, stop.reason));
+            if !details.is_empty() {
+                entry.push_str(&format!(" ({})", details.join(", ")));
+            }
+            entry.push('\n');
+        }
+        self.write(&entry);
+    }
+
+    /// A note from `transcript annotate`
+    pub fn annotate(&mut self, note: &str) {
+        self.write(&format!("[{}] note: {}\n", timestamp(), note));
+    }
+
+    /// Write the closing line; the file is closed when the transcript drops
+    pub fn stop(mut self) {
+        self.write(&format!("[{}] transcript stopped\n", timestamp()));
+    }
+
+    fn write(&mut self, entry: &str) {
+        // A full disk should not fail the command being recorded
+        if let Err(e) = self.file.write_all(entry.as_bytes()) {
+            tracing::warn!("Cannot write transcript {}: {}", self.path.display(), e);
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn records_commands_output_and_notes() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("session.log");
+
+        let mut transcript = Transcript::start(&path).unwrap();
+        transcript.record_command("break main", "Breakpoint 1 set at main.c:3\n");
+        transcript.record_output("stdout", "hello\nworld\n");
+        transcript.annotate("count is off by one");
+        transcript.stop();
+
+        let text = std::fs::read_to_string(&path).unwrap();
+        let lines: Vec<&str> = text.lines().collect();
+        assert_eq!(lines.len(), 7);
+        assert!(lines[0].ends_with("] transcript started"));
+        assert!(lines[1].ends_with("] $ debugger break main"));
+        assert_eq!(lines[2], "Breakpoint 1 set at main.c:3");
+        assert!(lines[3].ends_with("] stdout> hello"));
+        assert!(lines[4].ends_with("] stdout> world"));
+        assert!(lines[5].ends_with("] note: count is off by one"));
+        assert!(lines[6].ends_with("] transcript stopped"));
+    }
+
+    #[test]
+    fn stops_are_recorded_once_from_where_recording_began() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("session.log");
+        let stop = |number, reason: &str, breakpoints: Vec<u32>| StopRecord {
+            number,
+            time: "2026-01-25 14:03:07".to_string(),
+            reason: reason.to_string(),
+            description: None,
+            thread_id: Some(1),
+            breakpoints,
+            function: None,
+            source: None,
+            line: None,
+        };
+        let stops = [stop(1, "entry", vec![]), stop(2, "breakpoint", vec![3])];
+
+        let mut transcript = Transcript::start(&path).unwrap();
+        transcript.record_stops(1, stops[..1].iter());
+        transcript.record_output("stdout", "hello\n");
+        transcript.record_stops(2, stops.iter());
+        transcript.record_stops(2, stops.iter());
+        // The next session's first stop
+        transcript.record_stops(1, stops[..1].iter());
+        drop(transcript);
+
+        let text = std::fs::read_to_string(&path).unwrap();
+        let lines: Vec<&str> = text.lines().skip(1).collect();
+        assert_eq!(lines.len(), 3);
+        assert!(lines[0].ends_with("] stdout> hello"));
+        assert_eq!(lines[1], "[2026-01-25 14:03:07] stop 2: breakpoint (breakpoint 3, thread 1)");
+        assert_eq!(lines[2], "[2026-01-25 14:03:07] stop 1: entry (thread 1)");
+    }
+}
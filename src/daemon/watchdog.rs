@@ -0,0 +1,103 @@
+//! Watchdog for a hung program
+//!
+//! With `set watchdog SECS` (which `--batch` and `--ci` runs arm from
+//! `--watchdog` or the config), a program that runs that long without the
+//! adapter sending a single event is taken to be hung. The actor interrupts
+//! it, records a backtrace of every thread, and then resumes it or ends the
+//! session as `watchdog-policy` says. This happens inside the actor, between
+//! two state snapshots, so an `await` waiting on the program never sees the
+//! interrupt.
+
+use std::time::Duration;
+
+use crate::common::config::WatchdogPolicy;
+use crate::common::settings::Settings;
+use crate::common::time::timestamp;
+use crate::common::Result;
+use crate::ipc::protocol::{StackFrameInfo, ThreadBacktrace, WatchdogFiring};
+
+use super::session::{DebugSession, SessionState};
+
+/// Frames captured per thread
+const BACKTRACE_LIMIT: usize = 64;
+
+/// Interrupt the program if it has run longer than `settings.watchdog`
+/// without an event, returning what was found
+pub async fn check(
+    session: &mut Option<DebugSession>,
+    settings: &Settings,
+) -> Option<WatchdogFiring> {
+    if settings.watchdog == 0 {
+        return None;
+    }
+    let sess = session.as_mut()?;
+    let idle = sess.idle_time()?;
+    if idle < Duration::from_secs(settings.watchdog) {
+        return None;
+    }
+    tracing::warn!(idle_secs = idle.as_secs(), "Watchdog interrupting a quiet program");
+
+    let mut firing = WatchdogFiring {
+        time: timestamp(),
+        idle_secs: idle.as_secs(),
+        policy: settings.watchdog_policy,
+        threads: Vec::new(),
+        error: None,
+    };
+    let interrupted = match sess.interrupt(DebugSession::INTERRUPT_TIMEOUT).await {
+        Ok(interrupted) => interrupted,
+        Err(e) => {
+            firing.error = Some(e.to_string());
+            false
+        }
+    };
+    if sess.state() == SessionState::Stopped {
+        match backtraces(sess, settings).await {
+            Ok(threads) => firing.threads = threads,
+            Err(e) => firing.error = Some(e.to_string()),
+        }
+    }
+
+    let outcome = match settings.watchdog_policy {
+        WatchdogPolicy::Resume if interrupted => sess.continue_execution().await,
+        // The interrupt never landed, or the program stopped or ended on its
+        // own meanwhile
+        WatchdogPolicy::Resume => Ok(()),
+        WatchdogPolicy::Abort => {
+            let stopped = sess.stop().await;
+            *session = None;
+            stopped
+        }
+    };
+    if let Err(e) = outcome {
+        firing.error.get_or_insert_with(|| e.to_string());
+    }
+    Some(firing)
+}
+
+/// Every thread's frames
+async fn backtraces(sess: &mut DebugSession, settings: &Settings) -> Result<Vec<ThreadBacktrace>> {
+    let mut backtraces = Vec::new();
+    for thread in sess.get_threads().await? {
+        let frames = sess.stack_trace(Some(thread.id), BACKTRACE_LIMIT).await?;
+        backtraces.push(ThreadBacktrace {
+            id: thread.id,
+            name: thread.name,
+            frames: frames
+                .iter()
+                .map(|f| StackFrameInfo {
+                    id: f.id,
+                    name: f.name.clone(),
+                    source: f
+                        .source
+                        .as_ref()
+                        .and_then(|s| s.path.as_deref())
+                        .map(|path| settings.local_path(path)),
+                    line: Some(f.line),
+                    column: Some(f.column),
+                })
+                .collect(),
+        });
+    }
+    Ok(backtraces)
+}
@@ -0,0 +1,139 @@
+//! Watch expressions
+//!
+//! The daemon keeps the watch list and each expression's value at every stop
+//! where it was evaluated, so `watch list` can flag values that changed since
+//! the previous stop and `watch history` can show how a value evolved. Like
+//! hooks, watches outlive sessions; their history does not.
+
+use std::collections::VecDeque;
+
+use crate::ipc::protocol::{WatchInfo, WatchSample};
+
+/// Values kept per expression
+const MAX_HISTORY: usize = 100;
+
+#[derive(Debug)]
+struct Watch {
+    id: u32,
+    expression: String,
+    history: VecDeque<WatchSample>,
+}
+
+/// The watch list
+#[derive(Debug, Default)]
+pub struct Watches {
+    next_id: u32,
+    entries: Vec<Watch>,
+}
+
+impl Watches {
+    /// Add an expression and return its ID
+    pub fn add(&mut self, expression: String) -> u32 {
+        self.next_id += 1;
+        self.entries.push(Watch {
+            id: self.next_id,
+            expression,
+            history: VecDeque::new(),
+        });
+        self.next_id
+    }
+
+    /// Remove a watch; returns whether it existed
+    pub fn remove(&mut self, id: u32) -> bool {
+        let before = self.entries.len();
+        self.entries.retain(|watch| watch.id != id);
+        self.entries.len() != before
+    }
+
+    /// IDs and expressions, in the order they were added
+    pub fn expressions(&self) -> Vec<(u32, String)> {
+        self.entries
+            .iter()
+            .map(|watch| (watch.id, watch.expression.clone()))
+            .collect()
+    }
+
+    /// Record a watch's value at a stop and describe it
+    ///
+    /// Evaluating again at the same stop (after `frame` or `up`, say)
+    /// replaces that stop's value instead of adding history. `None` means the
+    /// expression could not be evaluated.
+    pub fn record(&mut self, id: u32, stop: u64, value: Option<String>) -> Option<WatchInfo> {
+        let watch = self.entries.iter_mut().find(|watch| watch.id == id)?;
+
+        if watch.history.back().is_some_and(|last| last.stop == stop) {
+            watch.history.pop_back();
+        }
+        let previous = watch.history.back().and_then(|sample| sample.value.clone());
+        watch.history.push_back(WatchSample {
+            stop,
+            value: value.clone(),
+        });
+        if watch.history.len() > MAX_HISTORY {
+            watch.history.pop_front();
+        }
+
+        Some(WatchInfo {
+            id,
+            expression: watch.expression.clone(),
+            changed: previous.is_some() && value.is_some() && previous != value,
+            value,
+            previous,
+            error: None,
+        })
+    }
+
+    /// Describe a watch that cannot be evaluated now, without recording it
+    pub fn unavailable(&self, id: u32, error: String) -> Option<WatchInfo> {
+        let watch = self.entries.iter().find(|watch| watch.id == id)?;
+        Some(WatchInfo {
+            id,
+            expression: watch.expression.clone(),
+            value: None,
+            previous: watch.history.back().and_then(|sample| sample.value.clone()),
+            changed: false,
+            error: Some(error),
+        })
+    }
+
+    /// A watch's expression and recorded values, oldest first
+    pub fn history(&self, id: u32) -> Option<(String, Vec<WatchSample>)> {
+        let watch = self.entries.iter().find(|watch| watch.id == id)?;
+        Some((watch.expression.clone(), watch.history.iter().cloned().collect()))
+    }
+
+    /// Forget recorded values; stop numbers restart with each session
+    pub fn clear_history(&mut self) {
+        for watch in &mut self.entries {
+            watch.history.clear();
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn changes_compare_with_the_previous_stop() {
+        let mut watches = Watches::default();
+        let id = watches.add("counter".into());
+
+        let first = watches.record(id, 1, Some("0".into())).unwrap();
+        assert!(!first.changed);
+
+        let second = watches.record(id, 2, Some("4".into())).unwrap();
+        assert!(second.changed);
+        assert_eq!(second.previous.as_deref(), Some("0"));
+
+        // Re-evaluating at the same stop compares with stop 1 again
+        let again = watches.record(id, 2, Some("4".into())).unwrap();
+        assert!(again.changed);
+        assert_eq!(watches.history(id).unwrap().1.len(), 2);
+
+        watches.clear_history();
+        assert!(watches.history(id).unwrap().1.is_empty());
+        assert!(watches.remove(id));
+        assert!(watches.record(id, 3, None).is_none());
+    }
+}
@@ -346,6 +346,9 @@ impl DapClient {
                                     tracing::error!("Error processing DAP message: {}", e);
                                 }
                             }
+                            Err(e @ Error::DapMessageTooLarge { .. }) => {
+                                Self::reject_oversized(e, &pending).await;
+                            }
                             Err(e) => {
                                 // Check if this is an expected EOF (adapter exited)
                                 // We check the error message string as a fallback for various error types
@@ -409,6 +412,9 @@ impl DapClient {
                                     tracing::error!("Error processing DAP message: {}", e);
                                 }
                             }
+                            Err(e @ Error::DapMessageTooLarge { .. }) => {
+                                Self::reject_oversized(e, &pending).await;
+                            }
                             Err(e) => {
                                 let err_str = e.to_string().to_lowercase();
                                 let is_eof = err_str.contains("unexpected eof")
@@ -480,6 +486,18 @@ impl DapClient {
         Ok(())
     }
 
+    /// Fail the request a message too large to read answered; the adapter
+    /// itself is fine
+    async fn reject_oversized(error: Error, pending: &PendingResponses) {
+        tracing::warn!("Skipped an adapter message: {}", error);
+        let Error::DapMessageTooLarge { request_seq: Some(seq), .. } = error else {
+            return;
+        };
+        if let Some(tx) = pending.lock().await.remove(&seq) {
+            let _ = tx.send(Err(error));
+        }
+    }
+
     /// Take the event receiver (can only be called once)
     pub fn take_event_receiver(&mut self) -> Option<mpsc::UnboundedReceiver<Event>> {
         self.event_rx.take()
@@ -783,6 +801,23 @@ impl DapClient {
         Ok(response.breakpoints)
     }
 
+    /// Set instruction breakpoints
+    pub async fn set_instruction_breakpoints(
+        &mut self,
+        breakpoints: Vec<InstructionBreakpoint>,
+    ) -> Result<Vec<Breakpoint>> {
+        let args = SetInstructionBreakpointsArguments { breakpoints };
+
+        let response: SetBreakpointsResponseBody = self
+            .request(
+                "setInstructionBreakpoints",
+                Some(serde_json::to_value(&args)?),
+            )
+            .await?;
+
+        Ok(response.breakpoints)
+    }
+
     /// Continue execution
     pub async fn continue_execution(&mut self, thread_id: i64) -> Result<bool> {
         let args = ContinueArguments {
@@ -844,9 +879,20 @@ impl DapClient {
 
     /// Get stack trace
     pub async fn stack_trace(&mut self, thread_id: i64, levels: i64) -> Result<Vec<StackFrame>> {
+        self.stack_trace_from(thread_id, 0, levels).await
+    }
+
+    /// Get `levels` frames of a thread's stack, skipping the `start`
+    /// innermost
+    pub async fn stack_trace_from(
+        &mut self,
+        thread_id: i64,
+        start: i64,
+        levels: i64,
+    ) -> Result<Vec<StackFrame>> {
         let args = StackTraceArguments {
             thread_id,
-            start_frame: Some(0),
+            start_frame: Some(start),
             levels: Some(levels),
         };
 
@@ -863,6 +909,34 @@ impl DapClient {
         Ok(response.threads)
     }
 
+    /// Get the loaded modules
+    pub async fn modules(&mut self) -> Result<Vec<Module>> {
+        let response: ModulesResponseBody = self.request("modules", None).await?;
+        Ok(response.modules)
+    }
+
+    /// Disassemble `count` instructions starting `offset` instructions from
+    /// a memory reference
+    pub async fn disassemble(
+        &mut self,
+        memory_reference: &str,
+        offset: i64,
+        count: i64,
+    ) -> Result<Vec<DisassembledInstruction>> {
+        let args = DisassembleArguments {
+            memory_reference: memory_reference.to_string(),
+            instruction_offset: Some(offset),
+            instruction_count: count,
+            resolve_symbols: Some(true),
+        };
+
+        let response: DisassembleResponseBody = self
+            .request("disassemble", Some(serde_json::to_value(&args)?))
+            .await?;
+
+        Ok(response.instructions)
+    }
+
     /// Get scopes for a frame
     pub async fn scopes(&mut self, frame_id: i64) -> Result<Vec<Scope>> {
         let args = ScopesArguments { frame_id };
@@ -12,10 +12,27 @@ use tokio::io::{AsyncBufRead, AsyncBufReadExt, AsyncReadExt, AsyncWrite, AsyncWr
 
 use crate::common::Error;
 
+/// The largest message read; 100MB should be plenty for any DAP message
+pub const MAX_MESSAGE_BYTES: usize = 100 * 1024 * 1024;
+
+/// How much of a message is read at a time
+const CHUNK_BYTES: usize = 64 * 1024;
+
 /// Read a DAP message from the stream
 ///
-/// Parses the Content-Length header and reads the JSON body
+/// Parses the Content-Length header and reads the JSON body. A body larger
+/// than [`MAX_MESSAGE_BYTES`], such as a huge value the adapter rendered, is
+/// read through and dropped a chunk at a time, and comes back as
+/// [`Error::DapMessageTooLarge`] naming the request it answered, so the
+/// stream stays usable.
 pub async fn read_message<R: AsyncBufRead + Unpin>(reader: &mut R) -> Result<String, Error> {
+    read_message_within(reader, MAX_MESSAGE_BYTES).await
+}
+
+async fn read_message_within<R: AsyncBufRead + Unpin>(
+    reader: &mut R,
+    max_bytes: usize,
+) -> Result<String, Error> {
     // Read headers line by line until we get an empty line
     let mut content_length: Option<usize> = None;
 
@@ -52,27 +69,71 @@ pub async fn read_message<R: AsyncBufRead + Unpin>(reader: &mut R) -> Result<Str
         Error::DapProtocol("Missing Content-Length header".to_string())
     })?;
 
-    // Sanity check - 100MB should be plenty for any DAP message
-    if len > 100 * 1024 * 1024 {
-        return Err(Error::DapProtocol(format!(
-            "Content-Length too large: {} bytes",
-            len
-        )));
+    if len > max_bytes {
+        let request_seq = skip_body(reader, len).await?;
+        return Err(Error::DapMessageTooLarge {
+            bytes: len,
+            limit: max_bytes,
+            request_seq,
+        });
     }
 
-    // Read the JSON body
-    let mut body = vec![0u8; len];
-    reader.read_exact(&mut body).await.map_err(|e| {
-        if e.kind() == io::ErrorKind::UnexpectedEof {
-            Error::AdapterCrashed
-        } else {
-            Error::Io(e)
-        }
-    })?;
+    // The buffer grows as the body arrives rather than trusting the header
+    let mut body = Vec::with_capacity(len.min(CHUNK_BYTES));
+    let read = (&mut *reader).take(len as u64).read_to_end(&mut body).await?;
+    if read < len {
+        return Err(Error::AdapterCrashed);
+    }
 
     String::from_utf8(body).map_err(|e| Error::DapProtocol(format!("Invalid UTF-8: {}", e)))
 }
 
+/// Read through a body of `len` bytes without keeping it, returning the
+/// `request_seq` it holds, if it is a response
+async fn skip_body<R: AsyncBufRead + Unpin>(
+    reader: &mut R,
+    len: usize,
+) -> Result<Option<i64>, Error> {
+    const KEY: &[u8] = b"\"request_seq\":";
+    // The key and the longest number it can have
+    const TAIL: usize = KEY.len() + 24;
+    let mut request_seq = None;
+    let mut chunk = vec![0u8; CHUNK_BYTES];
+    // The end of the last chunk, in case the key is split across two
+    let mut window = Vec::with_capacity(CHUNK_BYTES + TAIL);
+    let mut left = len;
+    while left > 0 {
+        let want = left.min(CHUNK_BYTES);
+        let read = reader.read(&mut chunk[..want]).await?;
+        if read == 0 {
+            return Err(Error::AdapterCrashed);
+        }
+        left -= read;
+        if request_seq.is_some() {
+            continue;
+        }
+        window.extend_from_slice(&chunk[..read]);
+        request_seq = find_number(&window, KEY, left == 0);
+        let keep = window.len().saturating_sub(TAIL);
+        window.drain(..keep);
+    }
+    Ok(request_seq)
+}
+
+/// The number after `key` in `bytes`; `None` if it may run on past the end,
+/// unless this is the `last` of them
+fn find_number(bytes: &[u8], key: &[u8], last: bool) -> Option<i64> {
+    let start = bytes.windows(key.len()).position(|window| window == key)? + key.len();
+    let rest = &bytes[start..];
+    let digits = rest.iter().skip_while(|b| b.is_ascii_whitespace());
+    let skipped = rest.len() - digits.clone().count();
+    let count = digits.take_while(|b| b.is_ascii_digit()).count();
+    if count == 0 || (skipped + count == rest.len() && !last) {
+        return None;
+    }
+    std::str::from_utf8(&rest[skipped..skipped + count]).ok()?.parse().ok()
+}
+
 /// Write a DAP message to the stream
 ///
 /// Adds the Content-Length header and writes the JSON body
@@ -113,6 +174,43 @@ mod tests {
         assert_eq!(result, "{\"test\":true}");
     }
 
+    #[tokio::test]
+    async fn test_oversized_message_is_skipped_and_names_its_request() {
+        // Several chunks, so the key comes after the first has been dropped
+        let value = "x".repeat(3 * CHUNK_BYTES);
+        let body = format!(
+            "{{\"body\":{{\"result\":\"{}\"}},\"request_seq\": 42,\"type\":\"response\"}}",
+            value
+        );
+        let next = "{\"test\":true}";
+        let data = format!(
+            "Content-Length: {}\r\n\r\n{}Content-Length: {}\r\n\r\n{}",
+            body.len(),
+            body,
+            next.len(),
+            next
+        );
+        let mut reader = BufReader::new(Cursor::new(data.into_bytes()));
+
+        match read_message_within(&mut reader, CHUNK_BYTES).await {
+            Err(Error::DapMessageTooLarge { bytes, request_seq, .. }) => {
+                assert_eq!(bytes, body.len());
+                assert_eq!(request_seq, Some(42));
+            }
+            other => panic!("expected an oversized message, got {:?}", other.map(|_| ())),
+        }
+        assert_eq!(read_message(&mut reader).await.unwrap(), next);
+    }
+
+    #[test]
+    fn numbers_split_across_chunks_wait_for_the_next() {
+        let key = b"\"request_seq\":";
+        assert_eq!(find_number(b"..\"request_seq\":12", key, false), None);
+        assert_eq!(find_number(b"..\"request_seq\":12", key, true), Some(12));
+        assert_eq!(find_number(b"..\"request_seq\": 12,", key, false), Some(12));
+        assert_eq!(find_number(b"..\"request_seq\"", key, true), None);
+    }
+
     #[tokio::test]
     async fn test_write_message() {
         let mut output = Vec::new();
@@ -113,7 +113,7 @@ impl Default for InitializeArguments {
 ///
 /// This structure contains fields for multiple DAP adapters.
 /// Unused fields are skipped during serialization.
-#[derive(Debug, Clone, Serialize, Deserialize)]
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
 #[serde(rename_all = "camelCase")]
 pub struct LaunchArguments {
     pub program: String,
@@ -153,6 +153,16 @@ pub struct LaunchArguments {
     /// Stop at entry point (Delve uses stopAtEntry instead of stopOnEntry)
     #[serde(skip_serializing_if = "Option::is_none")]
     pub stop_at_entry: Option<bool>,
+    /// "remote" sends the program's stdout and stderr as output events
+    /// instead of writing them to Delve's own
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub output_mode: Option<String>,
+    /// The core dump to open in "core" mode
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub core_file_path: Option<String>,
+    /// Add a "Globals" scope with the package's variables
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub show_global_variables: Option<bool>,
 
     // === GDB-based adapters (GDB, CUDA-GDB) ===
     /// Stop at beginning of main (GDB uses stopAtBeginningOfMainSubprogram instead of stopOnEntry)
@@ -178,16 +188,38 @@ pub struct LaunchArguments {
     /// Patterns for files to skip during debugging
     #[serde(skip_serializing_if = "Option::is_none")]
     pub skip_files: Option<Vec<String>>,
+    /// "std" captures the process's stdout and stderr, not just the console API
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub output_capture: Option<String>,
 }
 
 /// Attach request arguments
-#[derive(Debug, Clone, Serialize, Deserialize)]
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
 #[serde(rename_all = "camelCase")]
 pub struct AttachArguments {
-    pub pid: u32,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub pid: Option<u32>,
     // lldb-dap specific
     #[serde(skip_serializing_if = "Option::is_none")]
     pub wait_for: Option<bool>,
+    /// The binary a core dump is from
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub program: Option<String>,
+    /// A core dump to open instead of attaching to a process
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub core_file: Option<String>,
+    /// A gdbserver-protocol stub to connect to, as in `localhost:1234`
+    /// (GDB)
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub target: Option<String>,
+    /// The same stub's port and host, as lldb-dap takes them
+    #[serde(rename = "gdb-remote-port", skip_serializing_if = "Option::is_none")]
+    pub gdb_remote_port: Option<u16>,
+    #[serde(rename = "gdb-remote-hostname", skip_serializing_if = "Option::is_none")]
+    pub gdb_remote_hostname: Option<String>,
+    /// lldb commands run before attaching (lldb-dap, CodeLLDB)
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub init_commands: Option<Vec<String>>,
 }
 
 /// SetBreakpoints request arguments
@@ -206,6 +238,13 @@ pub struct SetFunctionBreakpointsArguments {
     pub breakpoints: Vec<FunctionBreakpoint>,
 }
 
+/// SetInstructionBreakpoints request arguments
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct SetInstructionBreakpointsArguments {
+    pub breakpoints: Vec<InstructionBreakpoint>,
+}
+
 /// Continue request arguments
 #[derive(Debug, Clone, Serialize, Deserialize)]
 #[serde(rename_all = "camelCase")]
@@ -271,6 +310,18 @@ pub struct EvaluateArguments {
     pub context: Option<String>,
 }
 
+/// Disassemble request arguments
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct DisassembleArguments {
+    pub memory_reference: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub instruction_offset: Option<i64>,
+    pub instruction_count: i64,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub resolve_symbols: Option<bool>,
+}
+
 /// Disconnect request arguments
 #[derive(Debug, Clone, Serialize, Deserialize)]
 #[serde(rename_all = "camelCase")]
@@ -292,6 +343,8 @@ pub struct Capabilities {
     #[serde(default)]
     pub supports_function_breakpoints: bool,
     #[serde(default)]
+    pub supports_instruction_breakpoints: bool,
+    #[serde(default)]
     pub supports_conditional_breakpoints: bool,
     #[serde(default)]
     pub supports_hit_conditional_breakpoints: bool,
@@ -344,6 +397,13 @@ pub struct ThreadsResponseBody {
     pub threads: Vec<Thread>,
 }
 
+/// Modules response body
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ModulesResponseBody {
+    #[serde(default)]
+    pub modules: Vec<Module>,
+}
+
 /// Scopes response body
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct ScopesResponseBody {
@@ -365,6 +425,16 @@ pub struct EvaluateResponseBody {
     pub type_name: Option<String>,
     #[serde(default)]
     pub variables_reference: i64,
+    /// How many elements an array-like result has
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub indexed_variables: Option<i64>,
+}
+
+/// Disassemble response body
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct DisassembleResponseBody {
+    #[serde(default)]
+    pub instructions: Vec<DisassembledInstruction>,
 }
 
 /// Continue response body
@@ -415,6 +485,17 @@ pub struct FunctionBreakpoint {
     pub hit_condition: Option<String>,
 }
 
+/// Instruction breakpoint, at a memory reference in the process
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct InstructionBreakpoint {
+    pub instruction_reference: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub condition: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub hit_condition: Option<String>,
+}
+
 /// Breakpoint information
 #[derive(Debug, Clone, Serialize, Deserialize)]
 #[serde(rename_all = "camelCase")]
@@ -444,6 +525,21 @@ pub struct StackFrame {
     pub column: u32,
     #[serde(skip_serializing_if = "Option::is_none")]
     pub module_id: Option<Value>,
+    /// Memory reference of the frame's current instruction
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub instruction_pointer_reference: Option<String>,
+}
+
+/// Disassembled instruction
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct DisassembledInstruction {
+    pub address: String,
+    pub instruction: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub symbol: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub line: Option<u32>,
 }
 
 /// Thread
@@ -453,6 +549,22 @@ pub struct Thread {
     pub name: String,
 }
 
+/// A loaded executable or shared library
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct Module {
+    /// A number or a string, depending on the adapter
+    pub id: Value,
+    pub name: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub path: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub address_range: Option<String>,
+    /// Whether the adapter has read the module's symbols, in its own words
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub symbol_status: Option<String>,
+}
+
 /// Scope
 #[derive(Debug, Clone, Serialize, Deserialize)]
 #[serde(rename_all = "camelCase")]
@@ -517,6 +629,15 @@ pub struct ThreadEventBody {
     pub thread_id: i64,
 }
 
+/// Process event body
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ProcessEventBody {
+    pub name: String,
+    #[serde(default)]
+    pub system_process_id: Option<u32>,
+}
+
 /// Exited event body
 #[derive(Debug, Clone, Serialize, Deserialize)]
 #[serde(rename_all = "camelCase")]
@@ -542,6 +663,7 @@ pub enum Event {
     Exited(ExitedEventBody),
     Terminated(Option<TerminatedEventBody>),
     Thread(ThreadEventBody),
+    Process(ProcessEventBody),
     Output(OutputEventBody),
     Breakpoint { reason: String, breakpoint: Breakpoint },
     Unknown { event: String, body: Option<Value> },
@@ -598,6 +720,17 @@ impl Event {
                     body: msg.body.clone(),
                 }
             }
+            "process" => {
+                if let Some(body) = &msg.body {
+                    if let Ok(process) = serde_json::from_value(body.clone()) {
+                        return Event::Process(process);
+                    }
+                }
+                Event::Unknown {
+                    event: msg.event.clone(),
+                    body: msg.body.clone(),
+                }
+            }
             "output" => {
                 if let Some(body) = &msg.body {
                     if let Ok(output) = serde_json::from_value(body.clone()) {
@@ -1,8 +1,11 @@
 //! CLI-side IPC client for communicating with the daemon
 
+use std::sync::OnceLock;
+
 use tokio::io::{ReadHalf, WriteHalf};
 
-use crate::common::{Error, Result};
+use crate::common::config::{Config, RetryConfig};
+use crate::common::{error::IpcError, paths, Error, Result};
 
 use super::protocol::{Command, Request, Response};
 use super::transport::{self, Stream};
@@ -12,20 +15,43 @@ pub struct DaemonClient {
     reader: ReadHalf<Stream>,
     writer: WriteHalf<Stream>,
     next_id: u64,
+    /// Limit sent with each request, overriding `command-timeout`
+    timeout_secs: Option<u64>,
 }
 
 impl DaemonClient {
     /// Connect to the running daemon
     pub async fn connect() -> Result<Self> {
-        let stream = transport::connect().await.map_err(|e| {
-            if e.kind() == std::io::ErrorKind::NotFound
-                || e.kind() == std::io::ErrorKind::ConnectionRefused
-            {
-                Error::DaemonNotRunning
-            } else {
-                Error::DaemonConnectionFailed(e)
+        Self::connect_to(&paths::socket_name()).await
+    }
+
+    /// Connect to the daemon of a session other than the selected one
+    pub async fn connect_to_session(session: &str) -> Result<Self> {
+        Self::connect_to(&paths::socket_name_for(session)).await
+    }
+
+    /// Connect to the daemon listening on the socket `name`
+    pub(crate) async fn connect_to(name: &str) -> Result<Self> {
+        let mut delays = retry_policy().delays();
+        let stream = loop {
+            match transport::connect_to(name).await {
+                Ok(stream) => break stream,
+                // No socket means no daemon, however long we wait
+                Err(e) if e.kind() == std::io::ErrorKind::NotFound => {
+                    return Err(Error::DaemonNotRunning);
+                }
+                Err(e) => match delays.next() {
+                    Some(delay) => {
+                        tracing::debug!("Connecting to daemon failed ({}), retrying", e);
+                        tokio::time::sleep(delay).await;
+                    }
+                    None if e.kind() == std::io::ErrorKind::ConnectionRefused => {
+                        return Err(Error::DaemonNotRunning);
+                    }
+                    None => return Err(Error::DaemonConnectionFailed(e)),
+                },
             }
-        })?;
+        };
 
         let (reader, writer) = tokio::io::split(stream);
 
@@ -33,15 +59,38 @@ impl DaemonClient {
             reader,
             writer,
             next_id: 1,
+            timeout_secs: None,
         })
     }
 
+    /// Give up on later commands after `secs` seconds instead of the
+    /// daemon's `command-timeout`; `Some(0)` lets them run as long as they take
+    pub fn set_timeout(&mut self, secs: Option<u64>) {
+        self.timeout_secs = secs;
+    }
+
     /// Send a command and wait for the response
     pub async fn send_command(&mut self, command: Command) -> Result<serde_json::Value> {
+        match self.request(command).await? {
+            Ok(result) => Ok(result),
+            Err(error) => Err(error.into()),
+        }
+    }
+
+    /// Send a command and return the daemon's error as sent, keeping its
+    /// code, instead of converting it into an [`Error`]
+    pub async fn request(
+        &mut self,
+        command: Command,
+    ) -> Result<std::result::Result<serde_json::Value, IpcError>> {
         let id = self.next_id;
         self.next_id += 1;
 
-        let request = Request { id, command };
+        let request = Request {
+            id,
+            command,
+            timeout_secs: self.timeout_secs,
+        };
         let json = serde_json::to_vec(&request)?;
 
         transport::send_message(&mut self.writer, &json)
@@ -62,15 +111,13 @@ impl DaemonClient {
         }
 
         if response.success {
-            Ok(response.result.unwrap_or(serde_json::json!({})))
+            Ok(Ok(response.result.unwrap_or(serde_json::json!({}))))
         } else {
-            let error = response
-                .error
-                .unwrap_or_else(|| crate::common::error::IpcError {
-                    code: "UNKNOWN".to_string(),
-                    message: "Unknown error".to_string(),
-                });
-            Err(error.into())
+            Ok(Err(response.error.unwrap_or_else(|| IpcError {
+                code: "UNKNOWN".to_string(),
+                message: "Unknown error".to_string(),
+                timeout_secs: None,
+            })))
         }
     }
 
@@ -83,3 +130,9 @@ impl DaemonClient {
         }
     }
 }
+
+/// The configured retry policy, read once per process
+fn retry_policy() -> &'static RetryConfig {
+    static POLICY: OnceLock<RetryConfig> = OnceLock::new();
+    POLICY.get_or_init(|| Config::load().map(|config| config.retry).unwrap_or_default())
+}
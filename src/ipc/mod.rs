@@ -5,6 +5,7 @@
 
 pub mod client;
 pub mod protocol;
+pub mod state;
 pub mod transport;
 
 pub use client::DaemonClient;
@@ -6,6 +6,7 @@
 use serde::{Deserialize, Serialize};
 use std::path::PathBuf;
 
+use crate::common::config::WatchdogPolicy;
 use crate::common::error::IpcError;
 
 /// IPC request from CLI to daemon
@@ -15,6 +16,10 @@ pub struct Request {
     pub id: u64,
     /// The command to execute
     pub command: Command,
+    /// Give up after this many seconds instead of the `command-timeout`
+    /// setting; 0 for no limit
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub timeout_secs: Option<u64>,
 }
 
 /// IPC response from daemon to CLI
@@ -86,6 +91,24 @@ pub enum Command {
         adapter: Option<String>,
     },
 
+    /// Attach to `program` running under a gdbserver stub at `target`
+    AttachRemote {
+        target: String,
+        program: PathBuf,
+        adapter: Option<String>,
+    },
+
+    /// Open a core dump of `program`, stopped where it was dumped
+    CoreOpen {
+        program: PathBuf,
+        core: PathBuf,
+        adapter: Option<String>,
+        /// Where the program was loaded minus where it was linked, when the
+        /// core and adapter can't say
+        #[serde(default)]
+        slide: Option<u64>,
+    },
+
     /// Detach from process (keeps it running)
     Detach,
 
@@ -121,6 +144,9 @@ pub enum Command {
     /// Disable a breakpoint
     BreakpointDisable { id: u32 },
 
+    /// Revert the latest breakpoint removal or enable/disable
+    BreakpointUndo,
+
     // === Execution Control ===
     /// Continue execution
     Continue,
@@ -142,6 +168,13 @@ pub enum Command {
     StackTrace {
         thread_id: Option<i64>,
         limit: usize,
+        /// Frames to skip, innermost first
+        #[serde(default)]
+        start: usize,
+        /// Pick up where the last backtrace of the thread left off, in
+        /// place of `start`
+        #[serde(default)]
+        more: bool,
     },
 
     /// Get local variables
@@ -152,8 +185,15 @@ pub enum Command {
         expression: String,
         frame_id: Option<i64>,
         context: EvaluateContext,
+        /// Bytes of the value to pass on, instead of the `print-limit`
+        /// setting; 0 for all of it
+        #[serde(default)]
+        limit: Option<usize>,
     },
 
+    /// Record a failed `assert`, marking the session failed
+    AssertFailed { message: String },
+
     /// Get scopes for a frame
     Scopes { frame_id: i64 },
 
@@ -164,6 +204,13 @@ pub enum Command {
     /// List all threads
     Threads,
 
+    /// List the executable and shared libraries, and how far their symbols
+    /// have been read
+    Modules,
+
+    /// Where the program was loaded against where it was linked
+    ProcSlide,
+
     /// Switch to thread
     ThreadSelect { id: i64 },
 
@@ -180,6 +227,34 @@ pub enum Command {
     /// Get current position with source context
     Context { lines: usize },
 
+    /// Disassemble around the selected frame's current instruction
+    Disassemble { count: usize },
+
+    // === Symbols ===
+    /// Read the program's symbols from a separate debug file
+    SymbolsAdd { file: PathBuf },
+
+    /// Fuzzy search the program's functions or source files
+    Find {
+        kind: FindKind,
+        query: String,
+        limit: usize,
+    },
+
+    /// Read-only query of the program's DWARF
+    Dwarf { query: DwarfQuery },
+
+    /// Functions or files a name that did not resolve was probably meant to be
+    Suggest {
+        kind: FindKind,
+        name: String,
+        limit: usize,
+    },
+
+    /// The file and line a `BREAKPOINT_MARKER` comment in the program's
+    /// sources names
+    ResolveMarker { name: String },
+
     // === Async ===
     /// Wait for next stop event
     Await { timeout_secs: u64 },
@@ -189,13 +264,334 @@ pub enum Command {
     GetOutput {
         tail: Option<usize>,
         clear: bool,
+        /// Only what the program printed since the latest stop
+        #[serde(default)]
+        since_last_stop: bool,
     },
 
+    // === Watches ===
+    /// Add a watch expression
+    WatchAdd { expression: String },
+
+    /// Remove a watch expression
+    WatchRemove { id: u32 },
+
+    /// Evaluate every watch expression at the current stop
+    WatchList,
+
+    /// Values recorded for one watch expression
+    WatchHistory { id: u32 },
+
+    /// The session's stops so far, oldest first
+    StopHistory,
+
+    /// What the watchdog found each time it interrupted the program since
+    /// the last call
+    WatchdogFirings,
+
+    // === Sampling ===
+    /// Record an expression's value every `every_ms` while the program runs
+    SampleAdd { expression: String, every_ms: u64 },
+
+    /// Stop sampling an expression and drop its values
+    SampleRemove { id: u32 },
+
+    /// The sampled expressions
+    SampleList,
+
+    /// Every sampled value, oldest first
+    SampleExport,
+
+    /// Drop the sampled values, keeping the expressions
+    SampleClear,
+
+    // === Size tracking ===
+    /// Measure a container's size at every stop, or every `every_ms` while
+    /// the program runs, and warn once it has grown `threshold` times in a
+    /// row
+    TrackAdd {
+        expression: String,
+        every_ms: Option<u64>,
+        threshold: u32,
+    },
+
+    /// Stop tracking a container and drop its sizes
+    TrackRemove { id: u32 },
+
+    /// The tracked containers
+    TrackList,
+
+    /// A tracked container's sizes, oldest first
+    TrackHistory { id: u32 },
+
+    // === Timeline ===
+    /// The session's stops, output, threads and samples in the order they
+    /// happened
+    Timeline,
+
+    // === Tracing ===
+    /// Log calls to and returns from the functions matching a glob
+    TraceFunctions { pattern: String },
+
+    /// Log the syscalls `filter` passes, every one if `None`
+    TraceSyscalls { filter: Option<String> },
+
+    /// Trace log entries after `since`, oldest first
+    TraceLog { since: u64 },
+
+    /// Stop tracing, keeping the log
+    TraceStop,
+
+    /// Drop the trace log
+    TraceClear,
+
+    // === Recording ===
+    /// Step through the program from the current stop, recording every line
+    /// and the locals at `at` (at every step if empty), for at most `limit`
+    /// steps
+    RecordStart {
+        at: Vec<BreakpointLocation>,
+        limit: u64,
+        /// Step over calls rather than into them
+        over: bool,
+    },
+
+    /// Stop recording, leaving the program where it is
+    RecordStop,
+
+    /// The recorded steps, without their snapshots
+    ReplayList,
+
+    /// Show a recorded step instead of the program in `context`, `locals`,
+    /// `backtrace` and `print`; `step` counts from the current one if
+    /// `relative`
+    ReplaySeek { step: i64, relative: bool },
+
+    /// Show the program again
+    ReplayExit,
+
+    // === Profiling ===
+    /// Sample every thread's stack `hz` times a second while the program
+    /// runs, dropping the previous profile
+    ProfileStart { hz: u32 },
+
+    /// Stop sampling, keeping the profile
+    ProfileStop,
+
+    /// How often each stack was seen
+    ProfileData,
+
+    // === Coverage ===
+    /// Put a breakpoint on every line of code in the source files whose path
+    /// contains `file`, all of them if `None`, to see which lines run
+    CoverageStart { file: Option<String> },
+
+    /// Remove the coverage breakpoints left, keeping what was covered
+    CoverageStop,
+
+    /// Each covered file's lines of code and those that ran
+    CoverageData,
+
+    // === Timing ===
+    /// Time the way from one location to another, each a breakpoint
+    /// location or `@marker:NAME`, dropping the previous timings
+    TimerStart { from: String, to: String },
+
+    /// Remove the timer's breakpoints, keeping the timings
+    TimerStop,
+
+    /// The timings so far
+    TimerData,
+
+    // === Heap ===
+    /// Read the Go runtime's heap statistics at the current stop
+    HeapSnapshot,
+
+    /// How the statistics changed between two snapshots, the last two if
+    /// not given
+    HeapDiff { from: Option<u32>, to: Option<u32> },
+
+    // === Branch tracing ===
+    /// Have GDB record every branch the program takes in hardware
+    BtraceStart { format: BtraceFormat },
+
+    /// Stop recording branches and drop the trace
+    BtraceStop,
+
+    /// The recorded path to the current stop: its last `count` function
+    /// segments, or its last `count` instructions
+    BtraceList { instructions: bool, count: u32 },
+
+    // === Settings ===
+    /// Change a setting
+    Set { name: String, args: Vec<String> },
+
+    /// Get all settings
+    Settings,
+
+    // === Hooks ===
+    /// Replace the command list for a hook (an empty list removes it)
+    HookSet {
+        phase: HookPhase,
+        target: String,
+        commands: Vec<String>,
+    },
+
+    /// List all hooks
+    HookList,
+
+    /// Replace the commands run for an event (an empty list removes them)
+    EventHandlerSet { handler: EventHandlerInfo },
+
+    /// List all event handlers
+    EventHandlerList,
+
+    // === Transcript ===
+    /// Start recording a transcript to a file
+    TranscriptStart { path: PathBuf },
+
+    /// Stop recording
+    TranscriptStop,
+
+    /// Whether a transcript is recording, and where
+    TranscriptStatus,
+
+    /// Add a note to the transcript
+    TranscriptAnnotate { note: String },
+
+    /// Add a finished CLI command and its output to the transcript
+    TranscriptRecord { command_line: String, output: String },
+
+    /// Whether a transcript or a macro is recording
+    RecordingStatus,
+
+    // === Macros ===
+    /// Start recording commands into a macro
+    MacroStart { name: String },
+
+    /// Stop recording and return the macro's commands
+    MacroStop,
+
+    /// Add a successful CLI command to the macro being recorded
+    MacroRecord { command_line: String },
+
     // === Shutdown ===
     /// Shutdown the daemon
     Shutdown,
 }
 
+/// What `find` searches
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, clap::ValueEnum)]
+#[serde(rename_all = "snake_case")]
+pub enum FindKind {
+    /// Functions in the symbol table
+    #[value(alias = "function")]
+    Func,
+    /// Source files named by the debug info
+    File,
+}
+
+/// What `dwarf` shows
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize, clap::Subcommand)]
+#[serde(tag = "type", rename_all = "snake_case")]
+pub enum DwarfQuery {
+    /// Structs, classes, unions, enums, typedefs and base types
+    Types {
+        /// Only names containing this, ignoring case
+        #[arg(long)]
+        filter: Option<String>,
+        /// Maximum number of results
+        #[arg(long, default_value = "100")]
+        limit: usize,
+    },
+    /// Functions with code, and their address ranges
+    Functions {
+        /// Only names containing this, ignoring case
+        #[arg(long)]
+        filter: Option<String>,
+        /// Maximum number of results
+        #[arg(long, default_value = "100")]
+        limit: usize,
+    },
+    /// Line table rows: address to file, line and column
+    Lines {
+        /// Only source files whose path contains this, e.g. worker.c
+        file: Option<String>,
+        /// Maximum number of results
+        #[arg(long, default_value = "100")]
+        limit: usize,
+    },
+    /// Inlined calls and the address ranges of their code
+    Inlined {
+        /// Only calls of, or into, functions whose name contains this
+        #[arg(long)]
+        filter: Option<String>,
+        /// Maximum number of results
+        #[arg(long, default_value = "100")]
+        limit: usize,
+    },
+    /// One debugging information entry: its attributes and children
+    Die {
+        /// Offset in .debug_info, as the other queries print it (0x2d)
+        #[arg(value_parser = crate::symbols::dwarf::parse_offset)]
+        offset: u64,
+    },
+}
+
+/// When a hook runs relative to its command or event
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Hash, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum HookPhase {
+    /// Before the command runs
+    Pre,
+    /// After the command succeeds
+    Post,
+}
+
+impl std::fmt::Display for HookPhase {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            Self::Pre => write!(f, "hook-pre"),
+            Self::Post => write!(f, "hook-post"),
+        }
+    }
+}
+
+/// Program events that `on` handlers run for
+#[derive(
+    Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Serialize, Deserialize, clap::ValueEnum,
+)]
+#[serde(rename_all = "snake_case")]
+pub enum EventKind {
+    /// Every stop reported by `await`
+    Stop,
+    /// A stop at a breakpoint, by ID, function or file:line
+    Breakpoint,
+    /// The program exiting or terminating
+    Exit,
+    /// A stop for a signal or exception, e.g. SIGSEGV
+    Signal,
+}
+
+impl EventKind {
+    /// Whether handlers for this event name what they match, as in
+    /// `on breakpoint worker_start`
+    pub fn takes_filter(self) -> bool {
+        matches!(self, Self::Breakpoint | Self::Signal)
+    }
+}
+
+impl std::fmt::Display for EventKind {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            Self::Stop => write!(f, "stop"),
+            Self::Breakpoint => write!(f, "breakpoint"),
+            Self::Exit => write!(f, "exit"),
+            Self::Signal => write!(f, "signal"),
+        }
+    }
+}
+
 /// Breakpoint location specification
 #[derive(Debug, Clone, Serialize, Deserialize)]
 #[serde(tag = "type", rename_all = "snake_case")]
@@ -204,11 +600,19 @@ pub enum BreakpointLocation {
     Line { file: PathBuf, line: u32 },
     /// Function name
     Function { name: String },
+    /// An instruction's address, as in `*0x401136`
+    Address { address: u64 },
 }
 
 impl BreakpointLocation {
     /// Parse a location string like "file.rs:42" or "main"
     pub fn parse(s: &str) -> Result<Self, crate::common::Error> {
+        if let Some(address) = s.strip_prefix('*') {
+            let address = crate::symbols::symbolicate::parse_address(address)
+                .map_err(crate::common::Error::InvalidLocation)?;
+            return Ok(Self::Address { address });
+        }
+
         // Handle file:line format, careful with Windows paths like "C:\path\file.rs:10"
         // Strategy: find the last ':' that's followed by digits only
         if let Some(colon_idx) = s.rfind(':') {
@@ -242,6 +646,7 @@ impl std::fmt::Display for BreakpointLocation {
         match self {
             Self::Line { file, line } => write!(f, "{}:{}", file.display(), line),
             Self::Function { name } => write!(f, "{}", name),
+            Self::Address { address } => write!(f, "*{:#x}", address),
         }
     }
 }
@@ -274,6 +679,24 @@ pub struct StatusResult {
     pub selected_thread: Option<i64>,
     pub stopped_thread: Option<i64>,
     pub stopped_reason: Option<String>,
+    /// Debuggee process ID, if the adapter reported one
+    #[serde(default)]
+    pub pid: Option<u32>,
+    /// Index of the selected frame, once frames have been fetched
+    #[serde(default)]
+    pub selected_frame: Option<usize>,
+    /// Function of the selected frame
+    #[serde(default)]
+    pub function: Option<String>,
+    /// Number of enabled breakpoints
+    #[serde(default)]
+    pub breakpoints: usize,
+    /// Number of `assert`s that failed in this session
+    #[serde(default)]
+    pub failed_assertions: usize,
+    /// Seconds the program has run without the adapter sending an event
+    #[serde(default)]
+    pub idle_secs: Option<u64>,
 }
 
 /// Breakpoint information
@@ -290,7 +713,7 @@ pub struct BreakpointInfo {
 }
 
 /// Stack frame information
-#[derive(Debug, Serialize, Deserialize)]
+#[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct StackFrameInfo {
     pub id: i64,
     pub name: String,
@@ -307,13 +730,94 @@ pub struct ThreadInfo {
     pub state: Option<String>,
 }
 
+/// A loaded executable or shared library
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct ModuleInfo {
+    pub name: String,
+    pub path: Option<String>,
+    pub address_range: Option<String>,
+    pub symbols: SymbolState,
+}
+
+/// Where the program was loaded against where it was linked
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+pub struct SlideInfo {
+    /// Added to an address in the binary to give the one in the process
+    pub slide: u64,
+    /// The lowest address the binary was linked at
+    pub linked_at: u64,
+    /// Where that address is in the process
+    pub loaded_at: u64,
+    /// Whether the binary is position-independent; other binaries load
+    /// where they were linked
+    pub pie: bool,
+    pub source: SlideSource,
+}
+
+/// Where the load address was learned
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum SlideSource {
+    /// The binary isn't position-independent
+    Binary,
+    /// `/proc/PID/maps` of a local process
+    ProcMaps,
+    /// The adapter's modules
+    Modules,
+    /// GDB's `info proc mappings`
+    Mappings,
+    /// `core open --slide`
+    Given,
+}
+
+impl std::fmt::Display for SlideSource {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            Self::Binary => write!(f, "not position-independent"),
+            Self::ProcMaps => write!(f, "from /proc/PID/maps"),
+            Self::Modules => write!(f, "from the adapter's modules"),
+            Self::Mappings => write!(f, "from info proc mappings"),
+            Self::Given => write!(f, "given with --slide"),
+        }
+    }
+}
+
+/// How far a module's symbols have been read
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum SymbolState {
+    Loaded,
+    /// Read, but the module has no debug info
+    NoDebugInfo,
+    /// Waiting to be read in the background
+    Deferred,
+    /// The adapter could not read them
+    Failed,
+    Unknown,
+}
+
+impl std::fmt::Display for SymbolState {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            Self::Loaded => write!(f, "loaded"),
+            Self::NoDebugInfo => write!(f, "no debug info"),
+            Self::Deferred => write!(f, "deferred"),
+            Self::Failed => write!(f, "failed"),
+            Self::Unknown => write!(f, "unknown"),
+        }
+    }
+}
+
 /// Variable information
-#[derive(Debug, Serialize, Deserialize)]
+#[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct VariableInfo {
     pub name: String,
     pub value: String,
     pub type_name: Option<String>,
     pub variables_reference: i64,
+    /// The budget `value` was cut short at, if it was
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub truncated_at: Option<usize>,
 }
 
 /// Stop event result
@@ -331,6 +835,29 @@ pub struct StopResult {
     pub source: Option<String>,
     pub line: Option<u32>,
     pub column: Option<u32>,
+    /// The report written for a crash, with `set crash-report on`
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub crash_report: Option<Box<CrashSummary>>,
+    /// Tracked containers that keep growing
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub warnings: Vec<String>,
+}
+
+/// The gist of a crash report, shown with the stop
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct CrashSummary {
+    /// The report file
+    pub path: String,
+    /// The signal or exception, e.g. `SIGSEGV`
+    pub signal: String,
+    /// The faulting address, when the adapter tells it
+    pub address: Option<String>,
+    /// What is mapped at the address, or `unmapped`
+    pub mapping: Option<String>,
+    pub function: Option<String>,
+    /// `file:line` of the crashing frame
+    pub location: Option<String>,
+    pub threads: usize,
 }
 
 /// Evaluate result
@@ -339,6 +866,9 @@ pub struct EvaluateResult {
     pub result: String,
     pub type_name: Option<String>,
     pub variables_reference: i64,
+    /// The budget `result` was cut short at, if it was
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub truncated_at: Option<usize>,
 }
 
 /// Context result with source code
@@ -355,6 +885,330 @@ pub struct ContextResult {
     pub locals: Vec<VariableInfo>,
 }
 
+/// A disassembled instruction
+#[derive(Debug, Serialize, Deserialize)]
+pub struct InstructionInfo {
+    pub address: String,
+    pub instruction: String,
+    pub symbol: Option<String>,
+    pub line: Option<u32>,
+    /// Whether this is the frame's current instruction
+    pub is_current: bool,
+}
+
+/// A `find` result
+#[derive(Debug, Serialize, Deserialize)]
+pub struct FindMatch {
+    /// Function name or file path
+    pub name: String,
+    /// Declaring file of a function
+    pub file: Option<String>,
+    pub line: Option<u32>,
+    pub score: i64,
+}
+
+/// A watch expression and its value at the current stop
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct WatchInfo {
+    pub id: u32,
+    pub expression: String,
+    pub value: Option<String>,
+    /// Value at the previous stop where it was evaluated
+    pub previous: Option<String>,
+    /// Whether the value differs from `previous`
+    pub changed: bool,
+    /// Why the expression has no value now
+    pub error: Option<String>,
+}
+
+/// A watch expression's value at one stop
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct WatchSample {
+    pub stop: u64,
+    /// `None` if the expression could not be evaluated
+    pub value: Option<String>,
+}
+
+/// An expression sampled while the program runs
+#[derive(Debug, Serialize, Deserialize)]
+pub struct SamplerInfo {
+    pub id: u32,
+    pub expression: String,
+    pub every_ms: u64,
+    /// Values recorded so far
+    pub count: usize,
+    /// The latest value
+    pub last: Option<String>,
+}
+
+/// A container whose size is tracked
+#[derive(Debug, Serialize, Deserialize)]
+pub struct TrackerInfo {
+    pub id: u32,
+    pub expression: String,
+    /// `None` when it is measured at stops
+    pub every_ms: Option<u64>,
+    pub threshold: u32,
+    /// Sizes measured so far
+    pub count: usize,
+    pub last: Option<TrackSize>,
+    /// Measurements it has grown at since it last shrank
+    pub growths: u32,
+    pub growing: bool,
+}
+
+/// One measurement of a tracked container; the sizes the language or
+/// adapter can't tell are `None`
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct TrackSize {
+    /// The session's stop count when it was measured
+    pub stop: u64,
+    /// UTC time of the measurement
+    pub time: String,
+    pub len: Option<u64>,
+    pub cap: Option<u64>,
+    pub bytes: Option<u64>,
+    pub error: Option<String>,
+}
+
+/// One sampled value
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct SampleValue {
+    /// The sampled expression's ID
+    pub id: u32,
+    /// Milliseconds since sampling began
+    pub elapsed_ms: u64,
+    /// UTC time of the sample
+    pub time: String,
+    /// `None` if the expression could not be evaluated
+    pub value: Option<String>,
+    pub error: Option<String>,
+}
+
+/// A stack seen while profiling and how many times it was seen
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct ProfileStack {
+    /// Function names, outermost first
+    pub frames: Vec<String>,
+    pub count: u64,
+}
+
+/// A source file's lines of code and which of them ran
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct FileCoverage {
+    pub path: String,
+    /// Lines with code, ascending
+    pub lines: Vec<u32>,
+    /// The lines that ran, ascending
+    pub covered: Vec<u32>,
+}
+
+/// A heap counter that differs between two snapshots
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct HeapChange {
+    /// Its path under `runtime.memstats`, like `heapStats.stats[0].inHeap`
+    pub counter: String,
+    pub before: i64,
+    pub after: i64,
+    pub change: i64,
+}
+
+/// How the CPU records branches for `btrace`
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, clap::ValueEnum)]
+#[serde(rename_all = "snake_case")]
+pub enum BtraceFormat {
+    /// Intel Processor Trace
+    Pt,
+    /// Branch Trace Store, slower but on older CPUs
+    Bts,
+}
+
+/// A stretch of a branch trace spent in one function, between calls or
+/// returns
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct BtraceCall {
+    /// The segment's number in the whole trace
+    pub number: u64,
+    /// Call depth, 0 for the outermost function shown
+    pub depth: usize,
+    pub function: String,
+    /// First and last instruction numbers
+    pub instructions: Option<[u64; 2]>,
+    pub source: Option<String>,
+    /// First and last source lines
+    pub lines: Option<[u32; 2]>,
+}
+
+/// One traced instruction, or a gap in the trace
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct BtraceInstruction {
+    /// The instruction's number in the whole trace
+    pub number: u64,
+    /// `None` for a gap, whose message is the instruction
+    pub address: Option<String>,
+    /// The symbol and offset, like `main+4`
+    pub function: Option<String>,
+    pub instruction: String,
+}
+
+/// Whether a trace entry is a function's call or return, or a syscall's
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum TraceKind {
+    Call,
+    Return,
+    Syscall,
+    SyscallReturn,
+}
+
+/// One line of the trace log
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct TraceEntry {
+    /// Position in the log, counting from 1
+    pub seq: u64,
+    /// Microseconds since tracing began
+    pub elapsed_us: u64,
+    pub thread_id: i64,
+    /// Traced calls already open on the thread
+    pub depth: usize,
+    pub kind: TraceKind,
+    pub function: String,
+    /// `name=value` pairs for calls, the decoded arguments for syscalls
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub arguments: Option<String>,
+    /// Microseconds the call took, for returns
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub duration_us: Option<u64>,
+    /// What a syscall returned, when known
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub result: Option<String>,
+}
+
+/// Where the program was at one step of a recording
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct RecordedStep {
+    /// Counting from 1 for where recording began
+    pub step: u64,
+    pub thread_id: i64,
+    /// Innermost first
+    pub frames: Vec<StackFrameInfo>,
+    /// The innermost frame's locals, at the steps that took a snapshot
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub locals: Option<Vec<VariableInfo>>,
+}
+
+/// A line the program printed, whole even when it came in several chunks
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct OutputLine {
+    /// UTC time its first chunk arrived
+    pub time: String,
+    /// `stdout`, `stderr`, or the adapter's own category
+    pub stream: String,
+    /// The stop it came after, 0 before the first
+    pub stop: u64,
+    pub text: String,
+}
+
+/// What a timeline event is
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum TimelineKind {
+    /// A stop the user sees, other than for a signal
+    Stop,
+    /// A stop for a signal, exception or panic
+    Signal,
+    Output,
+    Sample,
+    /// A thread starting or exiting
+    Thread,
+    Exit,
+}
+
+/// Something that happened in the session, for `timeline`
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct TimelineEvent {
+    /// Milliseconds since the session began
+    pub elapsed_ms: u64,
+    /// UTC time it happened
+    pub time: String,
+    pub kind: TimelineKind,
+    /// The thread it happened on; output, samples and the exit have none
+    pub thread_id: Option<i64>,
+    /// The session's stop count at the time, a stop's own number
+    pub stop: u64,
+    pub text: String,
+}
+
+/// One stop of the program, kept for `report`
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct StopRecord {
+    /// Stop number, counting from 1 for the session's first stop
+    pub number: u64,
+    /// UTC time of the stop
+    pub time: String,
+    pub reason: String,
+    pub description: Option<String>,
+    pub thread_id: Option<i64>,
+    /// Breakpoints the stop hit
+    pub breakpoints: Vec<u32>,
+    /// Top frame of the stopped thread, once a backtrace has been fetched
+    pub function: Option<String>,
+    pub source: Option<String>,
+    pub line: Option<u32>,
+}
+
+/// The watchdog interrupting a program that ran too long without an event
+#[derive(Debug, Serialize, Deserialize)]
+pub struct WatchdogFiring {
+    /// UTC time of the interrupt
+    pub time: String,
+    /// Seconds the program had run without an event
+    pub idle_secs: u64,
+    /// `resume` or `abort`, as `watchdog-policy` said
+    pub policy: WatchdogPolicy,
+    /// Every thread's backtrace at the interrupt
+    pub threads: Vec<ThreadBacktrace>,
+    /// Why the backtraces, or the resume or abort, failed
+    pub error: Option<String>,
+}
+
+/// A thread and its frames, innermost first
+#[derive(Debug, Serialize, Deserialize)]
+pub struct ThreadBacktrace {
+    pub id: i64,
+    pub name: String,
+    pub frames: Vec<StackFrameInfo>,
+}
+
+/// A hook and the command lines it runs
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct HookInfo {
+    pub phase: HookPhase,
+    pub target: String,
+    pub commands: Vec<String>,
+}
+
+/// Commands run when an event happens
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct EventHandlerInfo {
+    pub event: EventKind,
+    /// Breakpoint or signal the handler is for; `*` matches any
+    pub filter: Option<String>,
+    /// Run `commands` with `sh -c` instead of as debugger commands
+    pub shell: bool,
+    pub commands: Vec<String>,
+}
+
+impl EventHandlerInfo {
+    /// The event as written after `on`, e.g. `signal SIGSEGV`
+    pub fn target(&self) -> String {
+        match &self.filter {
+            Some(filter) => format!("{} {}", self.event, filter),
+            None => self.event.to_string(),
+        }
+    }
+}
+
 /// A source line with its number
 #[derive(Debug, Serialize, Deserialize)]
 pub struct SourceLine {
@@ -401,6 +1255,21 @@ mod tests {
         }
     }
 
+    #[test]
+    fn test_parse_address() {
+        let loc = BreakpointLocation::parse("*0x401136").unwrap();
+        match &loc {
+            BreakpointLocation::Address { address } => assert_eq!(*address, 0x401136),
+            _ => panic!("Expected Address variant"),
+        }
+        assert_eq!(loc.to_string(), "*0x401136");
+        assert!(matches!(
+            BreakpointLocation::parse("*4198710").unwrap(),
+            BreakpointLocation::Address { address: 0x401136 }
+        ));
+        assert!(BreakpointLocation::parse("*main").is_err());
+    }
+
     #[cfg(windows)]
     #[test]
     fn test_parse_windows_path() {
@@ -0,0 +1,55 @@
+//! What every CLI invocation needs to know from the daemon before it runs
+//!
+//! Whether a transcript or macro is recording, which commands have hooks and
+//! whether output pages decide how each invocation runs, so asking the daemon would cost a round
+//! trip per command. The daemon instead keeps them in a file beside its
+//! socket, rewritten before it answers a command that changes them, and the
+//! CLI reads that.
+
+use std::io;
+use std::path::PathBuf;
+
+use serde::{Deserialize, Serialize};
+
+use crate::common::paths;
+
+/// The daemon's state as CLI invocations see it
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+pub struct ClientState {
+    /// Whether a transcript is recording
+    pub transcript: bool,
+    /// Name of the macro being recorded
+    pub macro_name: Option<String>,
+    /// Commands and events with a pre- or post-hook
+    pub hooked: Vec<String>,
+    /// `set pagination`, or `None` with no daemon to say
+    pub pagination: Option<bool>,
+}
+
+impl ClientState {
+    /// The selected session's state, or the default with no daemon running
+    pub fn read() -> Self {
+        std::fs::read(path())
+            .ok()
+            .and_then(|data| serde_json::from_slice(&data).ok())
+            .unwrap_or_default()
+    }
+
+    /// Replace the selected session's state, written aside and renamed so a
+    /// reader never sees half of it
+    pub fn write(&self) -> io::Result<()> {
+        let path = path();
+        let partial = path.with_extension("state.tmp");
+        std::fs::write(&partial, serde_json::to_vec(self)?)?;
+        std::fs::rename(&partial, &path)
+    }
+
+    /// Remove the state file as the daemon exits
+    pub fn remove() {
+        let _ = std::fs::remove_file(path());
+    }
+}
+
+fn path() -> PathBuf {
+    paths::client_state_path()
+}
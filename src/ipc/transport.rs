@@ -76,7 +76,12 @@ pub async fn create_listener() -> io::Result<Listener> {
 
 /// Connect to the daemon's IPC socket
 pub async fn connect() -> io::Result<Stream> {
-    let name = paths::socket_name();
+    connect_to(&paths::socket_name()).await
+}
+
+/// Connect to the IPC socket with the given name
+pub async fn connect_to(name: &str) -> io::Result<Stream> {
+    let name = name.to_string();
 
     #[cfg(unix)]
     let stream = {
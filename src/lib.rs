@@ -9,7 +9,9 @@ pub mod common;
 pub mod daemon;
 pub mod dap;
 pub mod ipc;
+pub mod sdk;
 pub mod setup;
+pub mod symbols;
 pub mod testing;
 
 // Re-export commonly used types for tests
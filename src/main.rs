@@ -3,25 +3,117 @@
 //! This CLI tool uses the Debug Adapter Protocol (DAP) to provide debugging
 //! capabilities through a simple command-line interface optimized for LLM agents.
 
-use clap::Parser;
+use std::ffi::OsString;
+use std::path::PathBuf;
+use std::time::Duration;
+
+use clap::error::{ContextKind, ContextValue, ErrorKind};
+use clap::{CommandFactory, Parser};
+use debugger::cli::batch::{self, BatchOptions};
+use debugger::cli::capture;
+use debugger::cli::ci::{self, CiOptions};
+use debugger::cli::macros;
+use debugger::cli::output::{self, OutputFormat};
+use debugger::cli::pager;
+use debugger::cli::suggest;
+use debugger::cli::theme::{self, Element};
+use debugger::cli::user;
+use debugger::cli::watchdog::WatchdogOptions;
 use debugger::commands::Commands;
-use debugger::common::logging;
+use debugger::common::config::WatchdogPolicy;
+use debugger::common::{logging, paths};
 use debugger::{cli, daemon};
 
 #[derive(Parser)]
 #[command(name = "debugger", about = "LLM-friendly debugger CLI")]
 #[command(version, long_about = None)]
 struct Cli {
+    /// Output format; json prints one versioned JSON object per line
+    #[arg(long, short = 'o', global = true, value_enum, default_value_t = OutputFormat::Text)]
+    output: OutputFormat,
+
+    /// Also copy the command's output, uncolored, to the clipboard
+    #[arg(long, global = true)]
+    copy: bool,
+
+    /// Non-interactive mode: never prompt, suppress banners, end the session
+    /// the batch started, and exit with the debuggee's exit code
+    #[arg(long)]
+    batch: bool,
+
+    /// Run commands from a file (repeatable)
+    #[arg(long, short = 'x', value_name = "FILE")]
+    command_file: Vec<PathBuf>,
+
+    /// Run a command (repeatable, also accepted as -ex)
+    #[arg(long = "ex", value_name = "COMMAND")]
+    ex: Vec<String>,
+
+    /// Keep running batch commands after one fails (still exits 1)
+    #[arg(long, conflicts_with = "stop_on_error")]
+    continue_on_error: bool,
+
+    /// Stop the batch at the first failing command [default]
+    #[arg(long)]
+    stop_on_error: bool,
+
+    /// CI mode: --batch with a time limit and a summary of assertions,
+    /// breakpoint hits and the program's exit status
+    #[arg(long)]
+    ci: bool,
+
+    /// Time limit for a --ci run, in seconds
+    #[arg(
+        long,
+        value_name = "SECS",
+        requires = "ci",
+        default_value_t = ci::DEFAULT_TIMEOUT_SECS
+    )]
+    ci_timeout: u64,
+
+    /// Write the --ci results as JUnit XML
+    #[arg(long, value_name = "FILE", requires = "ci")]
+    junit: Option<PathBuf>,
+
+    /// Write the --ci results as JSON
+    #[arg(long, value_name = "FILE", requires = "ci")]
+    report: Option<PathBuf>,
+
+    /// In --batch and --ci runs, interrupt a program that runs this many
+    /// seconds without an event and capture every thread's backtrace
+    #[arg(long, value_name = "SECS")]
+    watchdog: Option<u64>,
+
+    /// What the watchdog does after capturing the backtraces
+    #[arg(long, value_enum, value_name = "POLICY")]
+    watchdog_policy: Option<WatchdogPolicy>,
+
+    /// Use the named session's daemon instead of the default one
+    #[arg(long, global = true, value_name = "NAME")]
+    session: Option<String>,
+
+    /// Define ${NAME} for command files and -ex commands (repeatable)
+    #[arg(long = "define", short = 'D', value_name = "NAME=VALUE")]
+    define: Vec<String>,
+
     #[command(subcommand)]
-    command: Commands,
+    command: Option<Commands>,
 }
 
 #[tokio::main]
 async fn main() {
-    let cli = Cli::parse();
+    let cli = parse(batch::normalize_args(std::env::args_os()));
+    output::set_format(cli.output);
+
+    if let Some(session) = &cli.session {
+        if let Err(e) = paths::select_session(session) {
+            eprintln!("{} {e}", theme::paint_stderr(Element::Error, "Error:"));
+            std::process::exit(2);
+        }
+    }
 
     // Initialize logging differently for daemon vs CLI mode
-    let is_daemon = matches!(cli.command, Commands::Daemon);
+    let is_daemon = matches!(cli.command, Some(Commands::Daemon { action: None }));
     if is_daemon {
         if let Some(log_path) = logging::init_daemon() {
             eprintln!("Daemon logging to: {}", log_path.display());
@@ -30,13 +122,136 @@ async fn main() {
         logging::init_cli();
     }
 
-    let result = match cli.command {
-        Commands::Daemon => daemon::run().await,
-        command => cli::dispatch(command).await,
+    let copy = cli.copy;
+    let options = BatchOptions {
+        batch: cli.batch,
+        command_files: cli.command_file,
+        commands: cli.ex,
+        command: cli.command,
+        continue_on_error: cli.continue_on_error,
+        definitions: cli.define,
+        ci: cli.ci.then(|| CiOptions {
+            timeout: Duration::from_secs(cli.ci_timeout),
+            junit: cli.junit,
+            report: cli.report,
+        }),
+        watchdog: WatchdogOptions {
+            idle_secs: cli.watchdog,
+            policy: cli.watchdog_policy,
+        },
+    };
+
+    if !is_daemon {
+        // Transcript commands are recorded by the daemon itself, an MCP
+        // server's stdout is its protocol, and a batch is not one command a
+        // macro could replay
+        let record = !matches!(
+            options.command,
+            Some(
+                Commands::Transcript(_) | Commands::ServeMcp { .. } | Commands::Connect { .. }
+            )
+        );
+        let record_macro =
+            !options.requested() && options.command.as_ref().is_some_and(macros::recordable);
+        capture::begin(copy, record, record_macro);
+    }
+    if options.requested() {
+        let code = batch::run(options).await;
+        capture::end().await;
+        std::process::exit(code);
+    }
+
+    let Some(command) = options.command else {
+        let _ = Cli::command().print_help();
+        capture::end().await;
+        std::process::exit(2);
     };
+    let name = command.name();
 
-    if let Err(e) = result {
-        eprintln!("Error: {e}");
+    let result = match command {
+        Commands::Daemon { action: None } => daemon::run().await,
+        command => {
+            pager::start_for(&command);
+            cli::dispatch(command).await
+        }
+    };
+
+    if let Err(e) = &result {
+        capture::record_error(e.to_string());
+        if output::is_json() {
+            output::emit_error(name, e);
+        } else {
+            eprintln!("{} {e}", theme::paint_stderr(Element::Error, "Error:"));
+        }
+    }
+
+    pager::finish();
+    capture::end().await;
+    if result.is_err() {
         std::process::exit(1);
     }
 }
+
+/// Parse the command line, running a user-defined command named as the
+/// subcommand or offering to run clap's suggestion for a mistyped one
+fn parse(args: Vec<OsString>) -> Cli {
+    let error = match Cli::try_parse_from(&args) {
+        Ok(cli) => return cli,
+        Err(error) => error,
+    };
+
+    if let (ErrorKind::InvalidSubcommand, Some(ContextValue::String(typed))) =
+        (error.kind(), error.get(ContextKind::InvalidSubcommand))
+    {
+        if user::exists(typed) {
+            if let Some(position) = subcommand_index(&args) {
+                let mut args = args.clone();
+                args.splice(position..position, ["user".into(), "run".into()]);
+                return Cli::parse_from(args);
+            }
+        }
+    }
+
+    let interactive = !args.iter().any(|arg| arg == "--batch");
+    let (ErrorKind::InvalidSubcommand, true) = (error.kind(), interactive) else {
+        error.exit();
+    };
+    let typed = match error.get(ContextKind::InvalidSubcommand) {
+        Some(ContextValue::String(typed)) => typed.clone(),
+        _ => error.exit(),
+    };
+    let suggested = match error.get(ContextKind::SuggestedSubcommand) {
+        Some(ContextValue::String(suggested)) => suggested.clone(),
+        Some(ContextValue::Strings(suggested)) if !suggested.is_empty() => suggested[0].clone(),
+        _ => error.exit(),
+    };
+
+    let _ = error.print();
+    if !suggest::confirm(&format!("Run '{}' instead?", suggested)) {
+        std::process::exit(error.exit_code());
+    }
+
+    let mut args = args;
+    match subcommand_index(&args) {
+        Some(position) if args[position] == typed.as_str() => {
+            args[position] = OsString::from(&suggested);
+        }
+        _ => error.exit(),
+    }
+    Cli::parse_from(args)
+}
+
+/// Where clap finds the subcommand it did not know, so an option value
+/// spelled the same is left alone: parsed again as an external subcommand,
+/// it is the word before that command's arguments
+fn subcommand_index(args: &[OsString]) -> Option<usize> {
+    let matches = Cli::command()
+        .allow_external_subcommands(true)
+        .try_get_matches_from(args)
+        .ok()?;
+    let (_, external) = matches.subcommand()?;
+    let rest = external
+        .get_many::<OsString>("")
+        .map_or(0, |values| values.len());
+    args.len().checked_sub(rest + 1)
+}
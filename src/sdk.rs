@@ -0,0 +1,412 @@
+//! Debug sessions for other Rust programs
+//!
+//! The CLI's commands are thin clients of the session daemon; this module is
+//! another client, with typed results instead of text. A [`Session`] starts
+//! or joins the daemon's session and drives it like CLI commands would, so a
+//! terminal running `debugger connect` sees everything it does, and
+//! [`Session::events`] reports stops however they were caused.
+//!
+//! ```no_run
+//! use std::time::Duration;
+//! use debugger::sdk::{Launch, Session, Stop};
+//!
+//! # async fn example() -> debugger::Result<()> {
+//! let launch = Launch::new("./target/debug/app").breakpoint("worker.c:42");
+//! let mut session = Session::new(launch).await?;
+//! if let Stop::Stopped(stop) = session.wait(Duration::from_secs(30)).await? {
+//!     println!("stopped at line {:?}", stop.line);
+//!     println!("count = {}", session.eval("count").await?.result);
+//! }
+//! session.stop().await?;
+//! # Ok(())
+//! # }
+//! ```
+//!
+//! The daemon is started when needed from the `debugger` binary named by
+//! `$DEBUGGER_EXE`, or found on `PATH`. Sessions are selected as for the CLI:
+//! call [`crate::common::paths::select_session`] first to use a named one.
+
+use std::path::PathBuf;
+use std::time::Duration;
+
+use tokio::sync::mpsc;
+
+use crate::cli::follow::{Change, Follower, POLL_INTERVAL};
+use crate::cli::spawn;
+use crate::common::{Error, Result};
+use crate::ipc::protocol::{
+    BreakpointInfo, BreakpointLocation, Command, EvaluateContext, EvaluateResult,
+    StackFrameInfo, StatusResult, StopResult, ThreadInfo, VariableInfo,
+};
+use crate::ipc::DaemonClient;
+
+/// Environment variable naming the debugger binary to start the daemon from
+pub const EXE_VAR: &str = "DEBUGGER_EXE";
+
+/// How to start a program, for [`Session::new`]
+#[derive(Debug, Clone)]
+pub struct Launch {
+    program: PathBuf,
+    args: Vec<String>,
+    adapter: Option<String>,
+    stop_on_entry: bool,
+    breakpoints: Vec<String>,
+}
+
+impl Launch {
+    pub fn new(program: impl Into<PathBuf>) -> Self {
+        Self {
+            program: program.into(),
+            args: Vec::new(),
+            adapter: None,
+            stop_on_entry: false,
+            breakpoints: Vec::new(),
+        }
+    }
+
+    /// Pass an argument to the program
+    pub fn arg(mut self, arg: impl Into<String>) -> Self {
+        self.args.push(arg.into());
+        self
+    }
+
+    /// Use this adapter instead of the configured default
+    pub fn adapter(mut self, adapter: impl Into<String>) -> Self {
+        self.adapter = Some(adapter.into());
+        self
+    }
+
+    /// Stop at the program's entry point
+    pub fn stop_on_entry(mut self) -> Self {
+        self.stop_on_entry = true;
+        self
+    }
+
+    /// Set a breakpoint (`file:line` or a function) before the program runs
+    pub fn breakpoint(mut self, location: impl Into<String>) -> Self {
+        self.breakpoints.push(location.into());
+        self
+    }
+
+    fn into_command(self) -> Command {
+        Command::Start {
+            program: self.program.canonicalize().unwrap_or(self.program),
+            args: self.args,
+            adapter: self.adapter,
+            stop_on_entry: self.stop_on_entry,
+            initial_breakpoints: self.breakpoints,
+        }
+    }
+}
+
+/// How [`Session::wait`] ended
+#[derive(Debug)]
+pub enum Stop {
+    /// The program stopped, at a breakpoint, after a step or when paused
+    Stopped(StopResult),
+    /// The program ended, with its exit code if the adapter reported one
+    Exited(Option<i32>),
+}
+
+impl Stop {
+    fn from_value(value: serde_json::Value) -> Result<Self> {
+        Ok(match value["reason"].as_str() {
+            Some("exited") | Some("terminated") => {
+                Stop::Exited(value["exit_code"].as_i64().map(|code| code as i32))
+            }
+            _ => Stop::Stopped(serde_json::from_value(value)?),
+        })
+    }
+}
+
+/// A change in the session, from [`Session::events`]
+#[derive(Debug)]
+pub enum Event {
+    Running,
+    Stopped(StopResult),
+    Exited(Option<i32>),
+    /// The session ended; no events follow
+    Ended,
+}
+
+impl Event {
+    /// The event for a change, or `None` for a plain status report
+    fn from_change(change: Change) -> Option<Self> {
+        match change.event {
+            "running" => Some(Event::Running),
+            "stopped" => serde_json::from_value(change.data).ok().map(Event::Stopped),
+            "exited" => Some(Event::Exited(
+                change.data["exit_code"].as_i64().map(|code| code as i32),
+            )),
+            "ended" => Some(Event::Ended),
+            _ => None,
+        }
+    }
+}
+
+/// A debug session in the daemon
+pub struct Session {
+    client: DaemonClient,
+}
+
+impl Session {
+    /// Start debugging a program, starting the daemon if needed
+    pub async fn new(launch: Launch) -> Result<Self> {
+        let mut session = Self::spawn().await?;
+        session.client.send_command(launch.into_command()).await?;
+        Ok(session)
+    }
+
+    /// Attach to a running process
+    pub async fn attach(pid: u32, adapter: Option<String>) -> Result<Self> {
+        let mut session = Self::spawn().await?;
+        session
+            .client
+            .send_command(Command::Attach { pid, adapter })
+            .await?;
+        Ok(session)
+    }
+
+    /// Join the session the daemon already has, e.g. one started by the CLI
+    pub async fn connect() -> Result<Self> {
+        Ok(Self {
+            client: DaemonClient::connect().await?,
+        })
+    }
+
+    async fn spawn() -> Result<Self> {
+        let exe = std::env::var_os(EXE_VAR)
+            .map(PathBuf::from)
+            .or_else(|| which::which("debugger").ok())
+            .ok_or_else(|| {
+                Error::Internal(format!(
+                    "cannot find the debugger binary; put it on PATH or set {}",
+                    EXE_VAR
+                ))
+            })?;
+        spawn::ensure_daemon_running_from(&exe).await?;
+        Self::connect().await
+    }
+
+    /// Set a breakpoint at `file:line` or a function
+    pub async fn set_breakpoint(&mut self, location: &str) -> Result<BreakpointInfo> {
+        self.set_breakpoint_if(location, None).await
+    }
+
+    /// Set a breakpoint that only stops when `condition` is true
+    pub async fn set_breakpoint_if(
+        &mut self,
+        location: &str,
+        condition: Option<&str>,
+    ) -> Result<BreakpointInfo> {
+        let result = self
+            .client
+            .send_command(Command::BreakpointAdd {
+                location: BreakpointLocation::parse(location)?,
+                condition: condition.map(String::from),
+                hit_count: None,
+            })
+            .await?;
+        Ok(serde_json::from_value(result)?)
+    }
+
+    pub async fn remove_breakpoint(&mut self, id: u32) -> Result<()> {
+        self.client
+            .send_command(Command::BreakpointRemove {
+                id: Some(id),
+                all: false,
+            })
+            .await?;
+        Ok(())
+    }
+
+    pub async fn breakpoints(&mut self) -> Result<Vec<BreakpointInfo>> {
+        let result = self.client.send_command(Command::BreakpointList).await?;
+        Ok(serde_json::from_value(result["breakpoints"].clone())?)
+    }
+
+    /// Evaluate an expression in the selected frame, like `print`
+    pub async fn eval(&mut self, expression: &str) -> Result<EvaluateResult> {
+        let result = self
+            .client
+            .send_command(Command::Evaluate {
+                expression: expression.to_string(),
+                frame_id: None,
+                context: EvaluateContext::Watch,
+                limit: None,
+            })
+            .await?;
+        Ok(serde_json::from_value(result)?)
+    }
+
+    /// Local variables of the selected frame
+    pub async fn locals(&mut self) -> Result<Vec<VariableInfo>> {
+        let result = self
+            .client
+            .send_command(Command::Locals { frame_id: None })
+            .await?;
+        Ok(serde_json::from_value(result["variables"].clone())?)
+    }
+
+    /// Stack frames of the selected thread, innermost first
+    pub async fn backtrace(&mut self, limit: usize) -> Result<Vec<StackFrameInfo>> {
+        let result = self
+            .client
+            .send_command(Command::StackTrace {
+                thread_id: None,
+                limit,
+                start: 0,
+                more: false,
+            })
+            .await?;
+        Ok(serde_json::from_value(result["frames"].clone())?)
+    }
+
+    pub async fn threads(&mut self) -> Result<Vec<ThreadInfo>> {
+        let result = self.client.send_command(Command::Threads).await?;
+        Ok(serde_json::from_value(result["threads"].clone())?)
+    }
+
+    pub async fn status(&mut self) -> Result<StatusResult> {
+        let result = self.client.send_command(Command::Status).await?;
+        Ok(serde_json::from_value(result)?)
+    }
+
+    /// Resume the program
+    pub async fn cont(&mut self) -> Result<()> {
+        self.run(Command::Continue).await
+    }
+
+    /// Step over the current line
+    pub async fn next(&mut self) -> Result<()> {
+        self.run(Command::Next).await
+    }
+
+    /// Step into the current line's calls
+    pub async fn step(&mut self) -> Result<()> {
+        self.run(Command::StepIn).await
+    }
+
+    /// Run until the current function returns
+    pub async fn finish(&mut self) -> Result<()> {
+        self.run(Command::StepOut).await
+    }
+
+    pub async fn pause(&mut self) -> Result<()> {
+        self.run(Command::Pause).await
+    }
+
+    /// Wait for the program to stop or exit; returns at once if it already has
+    ///
+    /// The daemon waits in whole seconds, so `timeout` is rounded up to a
+    /// whole number of them, at least one.
+    pub async fn wait(&mut self, timeout: Duration) -> Result<Stop> {
+        let whole_secs = timeout.as_secs() + u64::from(timeout.subsec_nanos() > 0);
+        let result = self
+            .client
+            .send_command(Command::Await {
+                timeout_secs: whole_secs.max(1),
+            })
+            .await?;
+        Stop::from_value(result)
+    }
+
+    /// End the session, terminating the program
+    pub async fn stop(mut self) -> Result<()> {
+        self.run(Command::Stop).await
+    }
+
+    /// Detach from the program, leaving it running
+    pub async fn detach(mut self) -> Result<()> {
+        self.run(Command::Detach).await
+    }
+
+    /// Send any daemon command and return its JSON result
+    pub async fn send(&mut self, command: Command) -> Result<serde_json::Value> {
+        self.client.send_command(command).await
+    }
+
+    /// Changes in the session as they happen, whichever client causes them;
+    /// the channel closes after [`Event::Ended`] or when the daemon exits
+    pub async fn events(&self) -> Result<mpsc::Receiver<Event>> {
+        let mut client = DaemonClient::connect().await?;
+        let mut follower = Follower::default();
+        // The first check reports the current state, not a change
+        follower.poll(&mut client).await?;
+
+        let (events, receiver) = mpsc::channel(32);
+        tokio::spawn(async move {
+            loop {
+                tokio::time::sleep(POLL_INTERVAL).await;
+                let Ok(change) = follower.poll(&mut client).await else {
+                    return;
+                };
+                let Some(event) = change.and_then(Event::from_change) else {
+                    continue;
+                };
+                let ended = matches!(event, Event::Ended);
+                if events.send(event).await.is_err() || ended {
+                    return;
+                }
+            }
+        });
+        Ok(receiver)
+    }
+
+    async fn run(&mut self, command: Command) -> Result<()> {
+        self.client.send_command(command).await?;
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    #[test]
+    fn launch_builds_a_start_command() {
+        let launch = Launch::new("/bin/true")
+            .arg("-v")
+            .adapter("gdb")
+            .breakpoint("main");
+        match launch.into_command() {
+            Command::Start {
+                args,
+                adapter,
+                stop_on_entry,
+                initial_breakpoints,
+                ..
+            } => {
+                assert_eq!(args, ["-v"]);
+                assert_eq!(adapter.as_deref(), Some("gdb"));
+                assert!(!stop_on_entry);
+                assert_eq!(initial_breakpoints, ["main"]);
+            }
+            _ => panic!("expected a start command"),
+        }
+    }
+
+    #[test]
+    fn await_results_become_stops() {
+        let exited = Stop::from_value(json!({ "reason": "exited", "exit_code": 3 })).unwrap();
+        assert!(matches!(exited, Stop::Exited(Some(3))));
+
+        let stopped = Stop::from_value(json!({
+            "reason": "breakpoint",
+            "description": null,
+            "thread_id": 1,
+            "source": "main.c",
+            "line": 12,
+            "column": null,
+        }))
+        .unwrap();
+        assert!(matches!(stopped, Stop::Stopped(StopResult { line: Some(12), .. })));
+
+        let status = Change {
+            event: "status",
+            data: json!({}),
+        };
+        assert!(Event::from_change(status).is_none());
+    }
+}
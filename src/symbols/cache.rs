@@ -0,0 +1,80 @@
+//! Symbol indexes kept on disk between sessions
+//!
+//! Indexing a binary with hundreds of megabytes of debug info means reading
+//! its whole symbol table and every unit's header, which is most of the wait
+//! on `start` and `attach`. The result depends only on the binary, so it is
+//! saved under the binary's build ID and read back by the next session that
+//! debugs the same build. Binaries without a build ID, or without DWARF, are
+//! indexed every time.
+
+use std::fs;
+use std::path::{Path, PathBuf};
+
+use object::Object;
+use serde::{Deserialize, Serialize};
+
+use super::{Function, UnitIndex};
+
+/// Bumped when [`Stored`] changes, so older files are indexed again
+const FORMAT: u32 = 1;
+
+/// An index as it is saved
+#[derive(Debug, Serialize, Deserialize)]
+pub(super) struct Stored {
+    pub format: u32,
+    pub functions: Vec<Function>,
+    pub files: Vec<PathBuf>,
+    pub units: Vec<UnitIndex>,
+}
+
+impl Stored {
+    pub fn new(functions: Vec<Function>, files: Vec<PathBuf>, units: Vec<UnitIndex>) -> Self {
+        Self {
+            format: FORMAT,
+            functions,
+            files,
+            units,
+        }
+    }
+}
+
+/// The binary's GNU build ID or Mach-O UUID, in hex; only the headers and
+/// the note holding it are read
+pub fn build_id(path: &Path) -> Option<String> {
+    let cache = object::ReadCache::new(fs::File::open(path).ok()?);
+    let file = object::File::parse(&cache).ok()?;
+    let id = match file.build_id().ok().flatten() {
+        Some(id) => id.to_vec(),
+        None => file.mach_uuid().ok().flatten()?.to_vec(),
+    };
+    if id.is_empty() {
+        return None;
+    }
+    Some(id.iter().map(|byte| format!("{:02x}", byte)).collect())
+}
+
+/// The index saved for build `id`, if there is one this release can read
+pub(super) fn read(dir: &Path, id: &str) -> Option<Stored> {
+    let text = fs::read(file_name(dir, id)).ok()?;
+    let stored: Stored = serde_json::from_slice(&text).ok()?;
+    (stored.format == FORMAT).then_some(stored)
+}
+
+/// Save the index of build `id`. Sessions may index the same build at once,
+/// so it is written under a name of its own and renamed into place.
+pub(super) fn write(dir: &Path, id: &str, stored: &Stored) {
+    let path = file_name(dir, id);
+    let partial = dir.join(format!(".{}.{}", id, std::process::id()));
+    let written = fs::create_dir_all(dir)
+        .and_then(|_| serde_json::to_vec(stored).map_err(std::io::Error::other))
+        .and_then(|text| fs::write(&partial, text))
+        .and_then(|_| fs::rename(&partial, &path));
+    if let Err(e) = written {
+        let _ = fs::remove_file(&partial);
+        tracing::debug!("Could not save the symbol index {}: {}", path.display(), e);
+    }
+}
+
+fn file_name(dir: &Path, id: &str) -> PathBuf {
+    dir.join(format!("{}.json", id))
+}
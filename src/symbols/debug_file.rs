@@ -0,0 +1,171 @@
+//! Debug info a stripped binary keeps in a separate file
+//!
+//! Distributions strip their binaries and ship the debug info in packages
+//! that install it under `/usr/lib/debug`. A stripped binary names its debug
+//! file two ways, and both are looked for the way GDB does: by build ID, as
+//! `.build-id/ab/cdef….debug` under each debug file directory, and by the
+//! file name and CRC in its `.gnu_debuglink`, next to the binary, in its
+//! `.debug` directory, and under each debug file directory at the binary's
+//! own path. A file is taken only if its build ID or CRC matches, so debug
+//! info from another build never places a function wrongly.
+
+use std::fs;
+use std::io::Read;
+use std::path::{Path, PathBuf};
+
+use object::{Object, ObjectSection};
+
+/// Where debug info packages install, and where GDB looks by default
+pub const DEFAULT_DIRECTORY: &str = "/usr/lib/debug";
+
+/// How a candidate is known to belong to the binary
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum Check<'a> {
+    /// Its build ID is the binary's
+    BuildId(&'a [u8]),
+    /// Its CRC32 is the one `.gnu_debuglink` records
+    Crc(u32),
+}
+
+/// The separate debug file of the binary at `path`, if it has no debug info
+/// of its own and one can be found under `directories` or next to it
+pub fn find(path: &Path, directories: &[PathBuf]) -> Option<PathBuf> {
+    let data = object::ReadCache::new(fs::File::open(path).ok()?);
+    let file = object::File::parse(&data).ok()?;
+    if has_debug_info(&file) {
+        return None;
+    }
+    let build_id = file.build_id().ok().flatten().filter(|id| !id.is_empty());
+    let link = file.gnu_debuglink().ok().flatten();
+    let link = link.map(|(name, crc)| (String::from_utf8_lossy(name).into_owned(), crc));
+
+    // An attached program is read through /proc/<pid>/exe
+    let binary = fs::canonicalize(path).unwrap_or_else(|_| path.to_path_buf());
+    let link = link.as_ref().map(|(name, crc)| (name.as_str(), *crc));
+    let found = candidates(&binary, build_id, link, directories)
+        .into_iter()
+        .find(|(candidate, check)| *candidate != binary && matches(candidate, *check))
+        .map(|(candidate, _)| candidate);
+    if let Some(found) = &found {
+        tracing::debug!("Reading the symbols of {} from {}", path.display(), found.display());
+    }
+    found
+}
+
+/// Whether a file has DWARF of its own; a stripped one keeps only sections
+/// that have no contents in its debug file
+pub(super) fn has_debug_info<'a, R: object::ReadRef<'a>>(file: &object::File<'a, R>) -> bool {
+    file.section_by_name(".debug_info")
+        .or_else(|| file.section_by_name("__debug_info"))
+        .or_else(|| file.section_by_name(".zdebug_info"))
+        .is_some_and(|section| section.size() > 0)
+}
+
+/// Where a debug file may be, in the order GDB tries them
+fn candidates<'a>(
+    binary: &Path,
+    build_id: Option<&'a [u8]>,
+    link: Option<(&str, u32)>,
+    directories: &[PathBuf],
+) -> Vec<(PathBuf, Check<'a>)> {
+    let mut paths = Vec::new();
+    if let Some(id) = build_id.filter(|id| id.len() > 1) {
+        let hex: String = id.iter().map(|byte| format!("{:02x}", byte)).collect();
+        let name = format!("{}/{}.debug", &hex[..2], &hex[2..]);
+        for directory in directories {
+            paths.push((directory.join(".build-id").join(&name), Check::BuildId(id)));
+        }
+    }
+    if let Some((name, crc)) = link {
+        let binary_dir = binary.parent().unwrap_or(Path::new("/"));
+        paths.push((binary_dir.join(name), Check::Crc(crc)));
+        paths.push((binary_dir.join(".debug").join(name), Check::Crc(crc)));
+        let relative = binary_dir.strip_prefix("/").unwrap_or(binary_dir);
+        for directory in directories {
+            paths.push((directory.join(relative).join(name), Check::Crc(crc)));
+        }
+    }
+    paths
+}
+
+fn matches(path: &Path, check: Check<'_>) -> bool {
+    match check {
+        Check::BuildId(id) => {
+            let Ok(binary) = fs::File::open(path) else {
+                return false;
+            };
+            let data = object::ReadCache::new(binary);
+            object::File::parse(&data).is_ok_and(|file| {
+                file.build_id().ok().flatten() == Some(id) && has_debug_info(&file)
+            })
+        }
+        Check::Crc(crc) => match crc32(path) {
+            Ok(sum) if sum == crc => true,
+            Ok(_) => {
+                tracing::debug!("{} is from another build; its CRC differs", path.display());
+                false
+            }
+            Err(_) => false,
+        },
+    }
+}
+
+/// The CRC32 `.gnu_debuglink` records of a whole file
+fn crc32(path: &Path) -> std::io::Result<u32> {
+    let mut file = fs::File::open(path)?;
+    let mut crc = flate2::Crc::new();
+    let mut buffer = vec![0; 1 << 16];
+    loop {
+        match file.read(&mut buffer)? {
+            0 => return Ok(crc.sum()),
+            read => crc.update(&buffer[..read]),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn debug_files_are_looked_for_by_build_id_then_debuglink() {
+        let id = [0xab, 0xcd, 0xef, 0x01];
+        let directories = [PathBuf::from("/usr/lib/debug"), PathBuf::from("/opt/debug")];
+        let paths = candidates(
+            Path::new("/usr/bin/tool"),
+            Some(&id),
+            Some(("tool.debug", 7)),
+            &directories,
+        );
+        let expected = [
+            ("/usr/lib/debug/.build-id/ab/cdef01.debug", Check::BuildId(&id)),
+            ("/opt/debug/.build-id/ab/cdef01.debug", Check::BuildId(&id)),
+            ("/usr/bin/tool.debug", Check::Crc(7)),
+            ("/usr/bin/.debug/tool.debug", Check::Crc(7)),
+            ("/usr/lib/debug/usr/bin/tool.debug", Check::Crc(7)),
+            ("/opt/debug/usr/bin/tool.debug", Check::Crc(7)),
+        ];
+        let expected: Vec<_> =
+            expected.into_iter().map(|(path, check)| (PathBuf::from(path), check)).collect();
+        assert_eq!(paths, expected);
+
+        assert!(candidates(Path::new("/usr/bin/tool"), None, None, &directories).is_empty());
+    }
+
+    #[test]
+    fn files_match_by_crc() {
+        let path = std::env::temp_dir().join(format!("debug-file-test-{}", std::process::id()));
+        fs::write(&path, b"123456789").unwrap();
+        // The check value of the CRC-32 that zlib and `.gnu_debuglink` use
+        assert_eq!(crc32(&path).unwrap(), 0xcbf4_3926);
+        assert!(matches(&path, Check::Crc(0xcbf4_3926)));
+        assert!(!matches(&path, Check::Crc(1)));
+        let _ = fs::remove_file(&path);
+    }
+
+    #[test]
+    fn binaries_with_debug_info_need_no_debug_file() {
+        let exe = std::env::current_exe().unwrap();
+        assert_eq!(find(&exe, &[PathBuf::from(DEFAULT_DIRECTORY)]), None);
+    }
+}
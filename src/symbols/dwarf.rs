@@ -0,0 +1,731 @@
+//! Read-only queries over a binary's DWARF
+//!
+//! `dwarf` lists the types, functions, line table rows and inlined calls the
+//! debug info describes, and shows single entries (DIEs) by their
+//! `.debug_info` offset, the way `readelf --debug-dump` or `llvm-dwarfdump`
+//! would. Entries are named with their enclosing namespaces and types, so a
+//! Rust or C++ `Worker` lists as `pool::Worker`.
+//!
+//! A `-gsplit-dwarf` build's entries are read from its [`split`] files, and
+//! their offsets are within the `.dwo` or package contribution they are in.
+//!
+//! [`split`]: super::split
+
+use std::collections::{BTreeSet, HashMap};
+use std::path::Path;
+
+use gimli::{AttributeValue, DwTag};
+use serde::{Deserialize, Serialize};
+
+use crate::common::{Error, Result};
+
+use super::split::Split;
+use super::Reader;
+
+type Dwarf<'a> = gimli::Dwarf<Reader<'a>>;
+type Unit<'a> = gimli::Unit<Reader<'a>>;
+type Entry<'abbrev, 'unit, 'a> = gimli::DebuggingInformationEntry<'abbrev, 'unit, Reader<'a>>;
+type GimliResult<T> = std::result::Result<T, gimli::Error>;
+
+/// Tags and names of the entries enclosing an entry, outermost first
+type Scopes = [(DwTag, Option<String>)];
+
+/// Origins followed for an inherited attribute, in case bad debug info
+/// points them in a circle
+const MAX_ORIGIN_HOPS: usize = 8;
+
+/// A type the debug info defines
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct TypeEntry {
+    /// `.debug_info` offset, for `dwarf die`
+    pub offset: u64,
+    /// struct, class, union, enum, typedef or base
+    pub kind: String,
+    pub name: String,
+    /// Size in bytes
+    pub size: Option<u64>,
+    pub file: Option<String>,
+    pub line: Option<u32>,
+}
+
+/// A function with code in the binary
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct FunctionEntry {
+    pub offset: u64,
+    pub name: String,
+    /// First address of its code
+    pub low_pc: u64,
+    /// Address after the end of its code
+    pub high_pc: u64,
+    pub file: Option<String>,
+    pub line: Option<u32>,
+}
+
+/// A row of a line table
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct LineEntry {
+    pub address: u64,
+    pub file: String,
+    /// `None` for code no source line is responsible for
+    pub line: Option<u32>,
+    pub column: Option<u32>,
+    /// Whether the row is a recommended breakpoint location
+    pub is_stmt: bool,
+}
+
+/// Code a function was inlined into
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct InlinedEntry {
+    pub offset: u64,
+    /// The inlined function
+    pub name: String,
+    /// The function, or inlined call, it was inlined into
+    pub caller: Option<String>,
+    /// Address ranges of the inlined code, each end exclusive
+    pub ranges: Vec<(u64, u64)>,
+    /// Where the call was made
+    pub call_file: Option<String>,
+    pub call_line: Option<u32>,
+}
+
+/// One debugging information entry and its children
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct Die {
+    pub offset: u64,
+    /// e.g. DW_TAG_structure_type
+    pub tag: String,
+    pub attributes: Vec<DieAttribute>,
+    pub children: Vec<DieChild>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct DieAttribute {
+    /// e.g. DW_AT_name
+    pub name: String,
+    pub value: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct DieChild {
+    pub offset: u64,
+    pub tag: String,
+    pub name: Option<String>,
+}
+
+/// Parse a DIE offset as printed (`0x2d`) or in decimal
+pub fn parse_offset(text: &str) -> std::result::Result<u64, String> {
+    let text = text.trim().trim_start_matches('<').trim_end_matches('>');
+    match text.strip_prefix("0x").or_else(|| text.strip_prefix("0X")) {
+        Some(hex) => u64::from_str_radix(hex, 16),
+        None => text.parse(),
+    }
+    .map_err(|_| format!("'{}' is not an offset; use hex (0x2d) or decimal", text))
+}
+
+/// Types whose qualified name contains `filter`, ignoring case; each
+/// distinct type once, though every compile unit may repeat it
+pub fn types(path: &Path, filter: Option<&str>) -> Result<Vec<TypeEntry>> {
+    with_dwarf(path, |dwarf, split| {
+        let types = walk(dwarf, split, |dwarf, unit, entry, scopes, types| {
+            let Some(kind) = type_kind(entry.tag()) else {
+                return Ok(());
+            };
+            if flag(entry, gimli::DW_AT_declaration)? {
+                return Ok(());
+            }
+            let Some(name) = attr_string(dwarf, unit, entry, gimli::DW_AT_name)? else {
+                return Ok(());
+            };
+            let name = qualify(scopes, name);
+            if !matches(filter, &name) {
+                return Ok(());
+            }
+            let size = entry
+                .attr_value(gimli::DW_AT_byte_size)?
+                .and_then(|value| value.udata_value());
+
+            let (file, line) = location(
+                dwarf,
+                unit,
+                entry,
+                gimli::DW_AT_decl_file,
+                gimli::DW_AT_decl_line,
+            )?;
+            types.push(TypeEntry {
+                offset: offset(unit, entry),
+                kind: kind.to_string(),
+                name,
+                size,
+                file,
+                line,
+            });
+            Ok(())
+        })?;
+        // Units are read apart, so repeats are dropped once they are together
+        let mut seen = BTreeSet::new();
+        Ok(types
+            .into_iter()
+            .filter(|entry| seen.insert((entry.kind.clone(), entry.name.clone(), entry.size)))
+            .collect())
+    })
+}
+
+/// Functions with code whose qualified name contains `filter`
+pub fn functions(path: &Path, filter: Option<&str>) -> Result<Vec<FunctionEntry>> {
+    with_dwarf(path, |dwarf, split| {
+        walk(dwarf, split, |dwarf, unit, entry, scopes, functions| {
+            if entry.tag() != gimli::DW_TAG_subprogram {
+                return Ok(());
+            }
+            // Declarations and abstract instances of inlined functions have
+            // no code of their own
+            let ranges = ranges(dwarf, unit, entry)?;
+            let (Some(low_pc), Some(high_pc)) = (
+                ranges.iter().map(|range| range.0).min(),
+                ranges.iter().map(|range| range.1).max(),
+            ) else {
+                return Ok(());
+            };
+            let Some(name) = inherited_string(dwarf, unit, entry, gimli::DW_AT_name)? else {
+                return Ok(());
+            };
+            let name = qualify(scopes, name);
+            if !matches(filter, &name) {
+                return Ok(());
+            }
+
+            let (file, line) = inherited_location(dwarf, unit, entry)?;
+            functions.push(FunctionEntry {
+                offset: offset(unit, entry),
+                name,
+                low_pc,
+                high_pc,
+                file,
+                line,
+            });
+            Ok(())
+        })
+    })
+}
+
+/// Each function's parameter names in declaration order, by qualified name
+pub fn parameters(path: &Path) -> Result<HashMap<String, Vec<String>>> {
+    with_dwarf(path, |dwarf, split| {
+        let found = walk(dwarf, split, |dwarf, unit, entry, scopes, found| {
+            if entry.tag() != gimli::DW_TAG_formal_parameter {
+                return Ok(());
+            }
+            let Some(((gimli::DW_TAG_subprogram, Some(function)), outer)) = scopes.split_last()
+            else {
+                return Ok(());
+            };
+            let Some(name) = inherited_string(dwarf, unit, entry, gimli::DW_AT_name)? else {
+                return Ok(());
+            };
+            found.push((qualify(outer, function.clone()), name));
+            Ok(())
+        })?;
+        let mut parameters: HashMap<String, Vec<String>> = HashMap::new();
+        for (function, name) in found {
+            // A declaration and its definition list the same parameters
+            let names = parameters.entry(function).or_default();
+            if !names.contains(&name) {
+                names.push(name);
+            }
+        }
+        Ok(parameters)
+    })
+}
+
+/// Line table rows for source files whose path contains `file`
+pub fn lines(path: &Path, file: Option<&str>) -> Result<Vec<LineEntry>> {
+    with_dwarf(path, |dwarf, _| {
+        let units = super::each_unit(dwarf, |unit| {
+            let mut lines = Vec::new();
+            let Some(program) = unit.line_program.clone() else {
+                return Ok(lines);
+            };
+
+            let mut rows = program.rows();
+            while let Some((header, row)) = rows.next_row()? {
+                if row.end_sequence() {
+                    continue;
+                }
+                let Some(path) = row
+                    .file(header)
+                    .and_then(|entry| super::file_path(dwarf, unit, header, entry))
+                else {
+                    continue;
+                };
+                let path = path.to_string_lossy().into_owned();
+                if !matches(file, &path) {
+                    continue;
+                }
+                lines.push(LineEntry {
+                    address: row.address(),
+                    file: path,
+                    line: row.line().map(|line| line.get() as u32),
+                    column: match row.column() {
+                        gimli::ColumnType::LeftEdge => None,
+                        gimli::ColumnType::Column(column) => Some(column.get() as u32),
+                    },
+                    is_stmt: row.is_stmt(),
+                });
+            }
+            Ok(lines)
+        })?;
+        Ok(units.into_iter().flatten().collect())
+    })
+}
+
+/// Inlined calls whose function or caller contains `filter`
+pub fn inlined(path: &Path, filter: Option<&str>) -> Result<Vec<InlinedEntry>> {
+    with_dwarf(path, |dwarf, split| {
+        walk(dwarf, split, |dwarf, unit, entry, scopes, calls| {
+            if entry.tag() != gimli::DW_TAG_inlined_subroutine {
+                return Ok(());
+            }
+            let name = inherited_string(dwarf, unit, entry, gimli::DW_AT_name)?
+                .unwrap_or_else(|| "<unnamed>".to_string());
+            let caller = scopes
+                .iter()
+                .rev()
+                .find(|(tag, _)| {
+                    matches!(*tag, gimli::DW_TAG_subprogram | gimli::DW_TAG_inlined_subroutine)
+                })
+                .and_then(|(_, name)| name.clone());
+            if !matches(filter, &name) && !caller.as_ref().is_some_and(|c| matches(filter, c)) {
+                return Ok(());
+            }
+
+            let (call_file, call_line) = location(
+                dwarf,
+                unit,
+                entry,
+                gimli::DW_AT_call_file,
+                gimli::DW_AT_call_line,
+            )?;
+            calls.push(InlinedEntry {
+                offset: offset(unit, entry),
+                name,
+                caller,
+                ranges: ranges(dwarf, unit, entry)?,
+                call_file,
+                call_line,
+            });
+            Ok(())
+        })
+    })
+}
+
+/// The entry at `.debug_info` offset `at`, with its attributes and children
+pub fn die(path: &Path, at: u64) -> Result<Die> {
+    let found = with_dwarf(path, |dwarf, split| {
+        let target = gimli::DebugInfoOffset(at as usize);
+        let mut headers = dwarf.units();
+        while let Some(header) = headers.next()? {
+            if target.to_unit_offset(&header).is_none() {
+                continue;
+            }
+            return find_die(dwarf, &dwarf.unit(header)?, target);
+        }
+
+        // Split units' entries have offsets of their own, so they are only
+        // looked through for an offset the binary doesn't have
+        let mut headers = dwarf.units();
+        while let Some(header) = headers.next()? {
+            let unit = dwarf.unit(header)?;
+            if unit.dwo_id.is_none() {
+                continue;
+            }
+            let found =
+                split.with_unit(dwarf, &unit, |dwarf, unit| find_die(dwarf, unit, target))?;
+            if found.is_some() {
+                return Ok(found);
+            }
+        }
+        Ok(None)
+    })?;
+
+    found.ok_or_else(|| {
+        Error::Symbols(format!(
+            "{}: no debugging information entry at offset {:#x}",
+            path.display(),
+            at
+        ))
+    })
+}
+
+/// The entry of `unit` at `target`, if one starts there
+fn find_die(
+    dwarf: &Dwarf<'_>,
+    unit: &Unit<'_>,
+    target: gimli::DebugInfoOffset,
+) -> GimliResult<Option<Die>> {
+    let Some(unit_offset) = target.to_unit_offset(&unit.header) else {
+        return Ok(None);
+    };
+
+    // Only the start of an entry is an entry; anything else would parse as
+    // garbage
+    let mut entries = unit.entries();
+    let mut is_entry = false;
+    while let Some((_, entry)) = entries.next_dfs()? {
+        if entry.offset() == unit_offset {
+            is_entry = true;
+            break;
+        }
+    }
+    if !is_entry {
+        return Ok(None);
+    }
+
+    let mut tree = unit.entries_tree(Some(unit_offset))?;
+    let root = tree.root()?;
+    let entry = root.entry();
+    let mut attributes = Vec::new();
+    let mut attrs = entry.attrs();
+    while let Some(attr) = attrs.next()? {
+        attributes.push(DieAttribute {
+            name: attr.name().to_string(),
+            value: attr_text(dwarf, unit, attr.name(), attr.value()),
+        });
+    }
+    let tag = entry.tag().to_string();
+
+    let mut children = Vec::new();
+    let mut nodes = root.children();
+    while let Some(node) = nodes.next()? {
+        let child = node.entry();
+        children.push(DieChild {
+            offset: offset(unit, child),
+            tag: child.tag().to_string(),
+            name: attr_string(dwarf, unit, child, gimli::DW_AT_name)?,
+        });
+    }
+
+    Ok(Some(Die {
+        offset: target.0 as u64,
+        tag,
+        attributes,
+        children,
+    }))
+}
+
+/// Run a query over the DWARF of the binary at `path`, and its split units
+fn with_dwarf<T>(
+    path: &Path,
+    query: impl FnOnce(&Dwarf<'_>, &Split<'_>) -> GimliResult<T>,
+) -> Result<T> {
+    let data = super::read_binary(path)?;
+    let file = super::parse_binary(path, &data)?;
+    let sections = super::load_sections(&file)
+        .map_err(|e| Error::Symbols(format!("{}: {}", path.display(), e)))?;
+    let dwarf =
+        sections.borrow(|section| gimli::EndianSlice::new(section, super::endian(&file)));
+    super::split::with_split(path, |split| query(&dwarf, split))
+        .map_err(|e| Error::Symbols(format!("{}: {}", path.display(), e)))
+}
+
+/// Visit every entry with the entries enclosing it, and gather what the
+/// visits push in unit order. Units are visited on several threads at once,
+/// and a skeleton unit's entries are read from its split unit.
+fn walk<T: Send>(
+    dwarf: &Dwarf<'_>,
+    split: &Split<'_>,
+    visit: impl for<'b> Fn(
+            &Dwarf<'b>,
+            &Unit<'b>,
+            &Entry<'_, '_, 'b>,
+            &Scopes,
+            &mut Vec<T>,
+        ) -> GimliResult<()>
+        + Sync,
+) -> GimliResult<Vec<T>> {
+    let units = super::each_unit(dwarf, |unit| {
+        split.with_unit(dwarf, unit, |dwarf, unit| {
+            let mut found = Vec::new();
+            let mut scopes: Vec<(DwTag, Option<String>)> = Vec::new();
+            let mut depth = 0isize;
+
+            let mut entries = unit.entries();
+            while let Some((delta, entry)) = entries.next_dfs()? {
+                depth += delta;
+                scopes.truncate(depth.max(0) as usize);
+                visit(dwarf, unit, entry, &scopes, &mut found)?;
+                let name = match entry.tag() {
+                    gimli::DW_TAG_subprogram | gimli::DW_TAG_inlined_subroutine => {
+                        inherited_string(dwarf, unit, entry, gimli::DW_AT_name)?
+                    }
+                    _ => attr_string(dwarf, unit, entry, gimli::DW_AT_name)?,
+                };
+                scopes.push((entry.tag(), name));
+            }
+            Ok(found)
+        })
+    })?;
+    Ok(units.into_iter().flatten().collect())
+}
+
+/// `name` prefixed with the namespaces and types it is declared in
+fn qualify(scopes: &Scopes, name: String) -> String {
+    let mut parts: Vec<&str> = scopes
+        .iter()
+        .filter(|(tag, _)| {
+            matches!(
+                *tag,
+                gimli::DW_TAG_namespace
+                    | gimli::DW_TAG_structure_type
+                    | gimli::DW_TAG_class_type
+                    | gimli::DW_TAG_union_type
+            )
+        })
+        .filter_map(|(_, name)| name.as_deref())
+        .collect();
+    parts.push(&name);
+    parts.join("::")
+}
+
+fn type_kind(tag: DwTag) -> Option<&'static str> {
+    Some(match tag {
+        gimli::DW_TAG_structure_type => "struct",
+        gimli::DW_TAG_class_type => "class",
+        gimli::DW_TAG_union_type => "union",
+        gimli::DW_TAG_enumeration_type => "enum",
+        gimli::DW_TAG_typedef => "typedef",
+        gimli::DW_TAG_base_type => "base",
+        _ => return None,
+    })
+}
+
+fn matches(filter: Option<&str>, text: &str) -> bool {
+    filter.is_none_or(|filter| text.to_lowercase().contains(&filter.to_lowercase()))
+}
+
+fn offset(unit: &Unit<'_>, entry: &Entry<'_, '_, '_>) -> u64 {
+    entry
+        .offset()
+        .to_debug_info_offset(&unit.header)
+        .map_or(0, |offset| offset.0 as u64)
+}
+
+fn flag(entry: &Entry<'_, '_, '_>, name: gimli::DwAt) -> GimliResult<bool> {
+    Ok(matches!(entry.attr_value(name)?, Some(AttributeValue::Flag(true))))
+}
+
+fn attr_string(
+    dwarf: &Dwarf<'_>,
+    unit: &Unit<'_>,
+    entry: &Entry<'_, '_, '_>,
+    name: gimli::DwAt,
+) -> GimliResult<Option<String>> {
+    match entry.attr_value(name)? {
+        Some(value) => Ok(Some(dwarf.attr_string(unit, value)?.to_string_lossy().into_owned())),
+        None => Ok(None),
+    }
+}
+
+/// The entry an out-of-line or inlined instance, or a definition, takes its
+/// name and declaration from
+fn origin<'unit, 'a>(
+    unit: &'unit Unit<'a>,
+    entry: &Entry<'_, '_, 'a>,
+) -> GimliResult<Option<Entry<'unit, 'unit, 'a>>> {
+    for name in [gimli::DW_AT_abstract_origin, gimli::DW_AT_specification] {
+        if let Some(AttributeValue::UnitRef(offset)) = entry.attr_value(name)? {
+            return unit.entry(offset).map(Some);
+        }
+    }
+    Ok(None)
+}
+
+/// A string attribute of `entry`, or of the entries it is an instance of
+fn inherited_string(
+    dwarf: &Dwarf<'_>,
+    unit: &Unit<'_>,
+    entry: &Entry<'_, '_, '_>,
+    name: gimli::DwAt,
+) -> GimliResult<Option<String>> {
+    if let Some(value) = attr_string(dwarf, unit, entry, name)? {
+        return Ok(Some(value));
+    }
+    let mut current = origin(unit, entry)?;
+    for _ in 0..MAX_ORIGIN_HOPS {
+        let Some(entry) = current else { break };
+        if let Some(value) = attr_string(dwarf, unit, &entry, name)? {
+            return Ok(Some(value));
+        }
+        current = origin(unit, &entry)?;
+    }
+    Ok(None)
+}
+
+/// The declaration of `entry`, or of the entries it is an instance of
+fn inherited_location(
+    dwarf: &Dwarf<'_>,
+    unit: &Unit<'_>,
+    entry: &Entry<'_, '_, '_>,
+) -> GimliResult<(Option<String>, Option<u32>)> {
+    let decl = |entry: &Entry<'_, '_, '_>| {
+        location(dwarf, unit, entry, gimli::DW_AT_decl_file, gimli::DW_AT_decl_line)
+    };
+    let found = decl(entry)?;
+    if found.0.is_some() {
+        return Ok(found);
+    }
+    let mut current = origin(unit, entry)?;
+    for _ in 0..MAX_ORIGIN_HOPS {
+        let Some(entry) = current else { break };
+        let found = decl(&entry)?;
+        if found.0.is_some() {
+            return Ok(found);
+        }
+        current = origin(unit, &entry)?;
+    }
+    Ok((None, None))
+}
+
+/// A file and line attribute pair, e.g. DW_AT_decl_file and DW_AT_decl_line
+fn location(
+    dwarf: &Dwarf<'_>,
+    unit: &Unit<'_>,
+    entry: &Entry<'_, '_, '_>,
+    file: gimli::DwAt,
+    line: gimli::DwAt,
+) -> GimliResult<(Option<String>, Option<u32>)> {
+    let file = entry
+        .attr_value(file)?
+        .and_then(|value| file_name(dwarf, unit, &value));
+    let line = entry
+        .attr_value(line)?
+        .and_then(|value| value.udata_value())
+        .map(|line| line as u32);
+    Ok((file, line))
+}
+
+fn file_name(
+    dwarf: &Dwarf<'_>,
+    unit: &Unit<'_>,
+    value: &AttributeValue<Reader<'_>>,
+) -> Option<String> {
+    let index = match value {
+        AttributeValue::FileIndex(index) => *index,
+        value => value.udata_value()?,
+    };
+    let header = unit.line_program.as_ref()?.header();
+    let entry = header.file(index)?;
+    super::file_path(dwarf, unit, header, entry).map(|path| path.to_string_lossy().into_owned())
+}
+
+/// Address ranges of an entry's code, each end exclusive
+fn ranges(
+    dwarf: &Dwarf<'_>,
+    unit: &Unit<'_>,
+    entry: &Entry<'_, '_, '_>,
+) -> GimliResult<Vec<(u64, u64)>> {
+    let mut ranges = Vec::new();
+    let mut iter = dwarf.die_ranges(unit, entry)?;
+    while let Some(range) = iter.next()? {
+        if range.begin < range.end {
+            ranges.push((range.begin, range.end));
+        }
+    }
+    Ok(ranges)
+}
+
+/// An attribute's value as `dwarf die` prints it
+fn attr_text(
+    dwarf: &Dwarf<'_>,
+    unit: &Unit<'_>,
+    name: gimli::DwAt,
+    value: AttributeValue<Reader<'_>>,
+) -> String {
+    if matches!(name, gimli::DW_AT_decl_file | gimli::DW_AT_call_file) {
+        if let Some(file) = file_name(dwarf, unit, &value) {
+            return file;
+        }
+    }
+    if let Ok(text) = dwarf.attr_string(unit, value) {
+        return format!("\"{}\"", text.to_string_lossy());
+    }
+
+    match value {
+        AttributeValue::Addr(address) => format!("{:#x}", address),
+        AttributeValue::DebugAddrIndex(index) => match dwarf.address(unit, index) {
+            Ok(address) => format!("{:#x}", address),
+            Err(_) => format!("{:?}", value),
+        },
+        AttributeValue::DebugRngListsIndex(index) => match dwarf.ranges_offset(unit, index) {
+            Ok(target) => format!("ranges <{:#x}>", target.0),
+            Err(_) => format!("{:?}", value),
+        },
+        AttributeValue::DebugLocListsIndex(index) => match dwarf.locations_offset(unit, index) {
+            Ok(target) => format!("locations <{:#x}>", target.0),
+            Err(_) => format!("{:?}", value),
+        },
+        AttributeValue::UnitRef(target) => match target.to_debug_info_offset(&unit.header) {
+            Some(target) => format!("<{:#x}>", target.0),
+            None => format!("{:?}", value),
+        },
+        AttributeValue::DebugInfoRef(target) => format!("<{:#x}>", target.0),
+        AttributeValue::Flag(flag) => flag.to_string(),
+        AttributeValue::Language(language) => language.to_string(),
+        AttributeValue::Encoding(encoding) => encoding.to_string(),
+        AttributeValue::Accessibility(access) => access.to_string(),
+        AttributeValue::Inline(inline) => inline.to_string(),
+        AttributeValue::Exprloc(expression) => {
+            let bytes: Vec<String> =
+                expression.0.slice().iter().map(|byte| format!("{:02x}", byte)).collect();
+            format!("expression [{}]", bytes.join(" "))
+        }
+        AttributeValue::Sdata(data) => data.to_string(),
+        value => match value.udata_value() {
+            Some(data) => data.to_string(),
+            None => format!("{:?}", value),
+        },
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn parses_offsets_as_printed() {
+        assert_eq!(parse_offset("0x2d"), Ok(45));
+        assert_eq!(parse_offset("<0x2d>"), Ok(45));
+        assert_eq!(parse_offset("45"), Ok(45));
+        assert!(parse_offset("x2d").is_err());
+    }
+
+    #[test]
+    fn queries_own_debug_info() {
+        let exe = std::env::current_exe().unwrap();
+
+        let found = types(&exe, Some("dwarf::TypeEntry")).unwrap();
+        let entry = found
+            .iter()
+            .find(|t| t.name == "debugger::symbols::dwarf::TypeEntry")
+            .expect("TypeEntry is in the debug info");
+        assert_eq!(entry.kind, "struct");
+        let fields = die(&exe, entry.offset).unwrap().children;
+        assert!(fields
+            .iter()
+            .any(|child| child.tag == "DW_TAG_member" && child.name.as_deref() == Some("kind")));
+
+        let found = functions(&exe, Some("queries_own_debug_info")).unwrap();
+        let function = found.first().expect("this test is in the debug info");
+        assert!(function.low_pc < function.high_pc);
+
+        let entry = die(&exe, function.offset).unwrap();
+        assert_eq!(entry.tag, "DW_TAG_subprogram");
+        assert!(entry.attributes.iter().any(|a| a.name == "DW_AT_low_pc"));
+        assert!(die(&exe, entry.offset + 1).is_err());
+
+        let rows = lines(&exe, Some("symbols/dwarf.rs")).unwrap();
+        assert!(rows.iter().any(|row| row.line.is_some()));
+
+        let found = parameters(&exe).unwrap();
+        assert_eq!(found["debugger::symbols::dwarf::parse_offset"], ["text"]);
+    }
+}
@@ -0,0 +1,182 @@
+//! Fuzzy matching for `find` and "did you mean" suggestions
+//!
+//! A query is split on whitespace and every term must match the candidate as
+//! a case-insensitive subsequence, so `wrkr strt` finds `worker_start`.
+//! Matches score higher when they start words (after `.`, `_`, `:`, `/` or a
+//! lowercase-to-uppercase step), run consecutively, and skip few characters.
+//!
+//! Typos are not subsequences (`wroker`), so suggestions use edit distance
+//! instead: [`closest`] keeps candidates within a third of the name's length.
+
+/// Points for each matched character
+const MATCH: i64 = 1;
+/// Bonus for a match at the start of a word
+const WORD_START: i64 = 8;
+/// Bonus for a match right after the previous one
+const CONSECUTIVE: i64 = 4;
+
+/// Score a candidate against a query, or `None` if a term does not match
+pub fn score(query: &str, candidate: &str) -> Option<i64> {
+    let chars: Vec<char> = candidate.chars().collect();
+    let mut total = 0;
+    let mut terms = 0;
+
+    for term in query.split_whitespace() {
+        total += score_term(term, &chars)?;
+        terms += 1;
+    }
+
+    if terms == 0 {
+        return None;
+    }
+
+    // Among equal matches prefer the shorter name
+    Some(total - chars.len() as i64 / 4)
+}
+
+/// Best score for one term, trying each place its first character matches
+fn score_term(term: &str, candidate: &[char]) -> Option<i64> {
+    let term: Vec<char> = term.chars().flat_map(char::to_lowercase).collect();
+    let first = *term.first()?;
+
+    (0..candidate.len())
+        .filter(|&start| lower(candidate[start]) == first)
+        .filter_map(|start| score_from(&term, candidate, start))
+        .max()
+}
+
+/// Greedily match `term` starting at `start`
+fn score_from(term: &[char], candidate: &[char], start: usize) -> Option<i64> {
+    let mut score = 0;
+    let mut position = start;
+    let mut previous: Option<usize> = None;
+
+    for &wanted in term {
+        let found = (position..candidate.len()).find(|&i| lower(candidate[i]) == wanted)?;
+
+        score += MATCH;
+        if is_word_start(candidate, found) {
+            score += WORD_START;
+        }
+        match previous {
+            Some(previous) if found == previous + 1 => score += CONSECUTIVE,
+            Some(previous) => score -= (found - previous - 1).min(8) as i64,
+            None => {}
+        }
+
+        previous = Some(found);
+        position = found + 1;
+    }
+
+    Some(score)
+}
+
+fn is_word_start(candidate: &[char], index: usize) -> bool {
+    if index == 0 {
+        return true;
+    }
+    let previous = candidate[index - 1];
+    let current = candidate[index];
+    matches!(previous, '.' | '_' | ':' | '/' | '\\' | '-' | ' ' | '$')
+        || (previous.is_lowercase() && current.is_uppercase())
+}
+
+/// Case-insensitive edit distance, counting a swap of neighbors as one edit
+pub fn edit_distance(a: &str, b: &str) -> usize {
+    let a: Vec<char> = a.chars().map(lower).collect();
+    let b: Vec<char> = b.chars().map(lower).collect();
+
+    // Optimal string alignment: Levenshtein plus adjacent transpositions
+    let mut rows = vec![vec![0; b.len() + 1]; a.len() + 1];
+    for (i, row) in rows.iter_mut().enumerate() {
+        row[0] = i;
+    }
+    for j in 0..=b.len() {
+        rows[0][j] = j;
+    }
+
+    for i in 1..=a.len() {
+        for j in 1..=b.len() {
+            let cost = usize::from(a[i - 1] != b[j - 1]);
+            let mut best = (rows[i - 1][j] + 1)
+                .min(rows[i][j - 1] + 1)
+                .min(rows[i - 1][j - 1] + cost);
+            if i > 1 && j > 1 && a[i - 1] == b[j - 2] && a[i - 2] == b[j - 1] {
+                best = best.min(rows[i - 2][j - 2] + 1);
+            }
+            rows[i][j] = best;
+        }
+    }
+
+    rows[a.len()][b.len()]
+}
+
+/// Candidates close enough to `name` to be what was meant, closest first
+///
+/// Exact matches are left out: a name that resolves needs no suggestion,
+/// and one that failed to resolve as written would fail again.
+pub fn closest<'a>(
+    name: &str,
+    candidates: impl IntoIterator<Item = &'a str>,
+    limit: usize,
+) -> Vec<&'a str> {
+    let allowed = (name.chars().count() / 3).max(1);
+
+    let mut matches: Vec<(usize, &str)> = candidates
+        .into_iter()
+        .filter(|candidate| *candidate != name)
+        .map(|candidate| (edit_distance(name, candidate), candidate))
+        .filter(|(distance, _)| *distance <= allowed)
+        .collect();
+    matches.sort_by_key(|(distance, candidate)| (*distance, candidate.len()));
+    matches.dedup_by(|a, b| a.1 == b.1);
+    matches.truncate(limit);
+    matches.into_iter().map(|(_, candidate)| candidate).collect()
+}
+
+fn lower(c: char) -> char {
+    c.to_lowercase().next().unwrap_or(c)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn terms_match_as_subsequences() {
+        assert!(score("wrkr strt", "main.worker_start").is_some());
+        assert!(score("strt wrkr", "main.worker_start").is_some());
+        assert!(score("wrkr stop", "main.worker_start").is_none());
+        assert!(score("", "main").is_none());
+    }
+
+    #[test]
+    fn word_starts_and_short_names_rank_first() {
+        assert!(
+            score("wrkr strt", "main.worker_start")
+                > score("wrkr strt", "main.networkerror_restart")
+        );
+        assert!(
+            score("wrkr strt", "worker_start") > score("wrkr strt", "worker_start_all_threads")
+        );
+
+        assert!(score("thr go", "threaded.go") > score("thr go", "other/go.mod"));
+        assert!(score("getName", "Person::getName") > score("getName", "get_user_name"));
+    }
+
+    #[test]
+    fn typos_suggest_close_names() {
+        assert_eq!(edit_distance("wroker", "worker"), 1);
+        assert_eq!(edit_distance("contniue", "continue"), 1);
+        assert_eq!(edit_distance("Main", "main"), 0);
+        assert_eq!(edit_distance("", "abc"), 3);
+
+        let names = ["worker_start", "worker_stop", "main", "parse_args"];
+        assert_eq!(
+            closest("wroker_start", names, 3),
+            ["worker_start", "worker_stop"]
+        );
+        assert!(closest("main", names, 3).is_empty());
+        assert!(closest("xyz", names, 3).is_empty());
+    }
+}
@@ -0,0 +1,178 @@
+//! `BREAKPOINT_MARKER` comments in source files
+//!
+//! Fixtures name the places tests stop at with a comment on the line before,
+//! `// BREAKPOINT_MARKER: main_start`, so the line numbers can change without
+//! the tests. `@marker:main_start` names the line after the comment wherever
+//! a breakpoint location is taken. [`Markers`] maps the markers of a set of
+//! files to where they are, for the daemon and for tests alike:
+//!
+//! ```no_run
+//! use debugger::symbols::markers::Markers;
+//!
+//! let markers = Markers::read("tests/fixtures/simple.c".as_ref()).unwrap();
+//! let add_body = markers.find("add_body").unwrap();
+//! assert_eq!(add_body.to_string(), format!("tests/fixtures/simple.c:{}", add_body.line));
+//! ```
+
+use std::collections::{BTreeMap, HashMap};
+use std::fmt;
+use std::path::{Path, PathBuf};
+
+use crate::common::{Error, Result};
+
+/// The comment tag that introduces a marker name
+pub const TAG: &str = "BREAKPOINT_MARKER:";
+
+/// How a breakpoint location names a marker's line
+pub const PREFIX: &str = "@marker:";
+
+/// The marker a location such as `@marker:main_start` names
+pub fn reference(location: &str) -> Option<&str> {
+    location.strip_prefix(PREFIX).filter(|name| !name.is_empty())
+}
+
+/// The line a marker names, after its comment
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Location {
+    pub file: PathBuf,
+    pub line: u32,
+}
+
+impl fmt::Display for Location {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "{}:{}", self.file.display(), self.line)
+    }
+}
+
+/// The markers of some source files, by name
+#[derive(Debug, Clone, Default)]
+pub struct Markers {
+    found: BTreeMap<String, Vec<Location>>,
+}
+
+impl Markers {
+    /// The markers in one source file
+    pub fn read(path: &Path) -> Result<Self> {
+        let text = std::fs::read_to_string(path).map_err(|e| Error::FileRead {
+            path: path.display().to_string(),
+            error: e.to_string(),
+        })?;
+        let mut markers = Self::default();
+        markers.add(path, &text);
+        Ok(markers)
+    }
+
+    /// The markers in each of `paths` that can be read; a binary's line
+    /// table names files that are not on this machine
+    pub fn read_all<P: AsRef<Path>>(paths: impl IntoIterator<Item = P>) -> Self {
+        let mut markers = Self::default();
+        for path in paths {
+            let path = path.as_ref();
+            if let Ok(text) = std::fs::read_to_string(path) {
+                markers.add(path, &text);
+            }
+        }
+        markers
+    }
+
+    /// Add the markers in `text`, the contents of `file`
+    pub fn add(&mut self, file: &Path, text: &str) {
+        for (name, line) in scan(text) {
+            let location = Location { file: file.to_path_buf(), line };
+            self.found.entry(name).or_default().push(location);
+        }
+    }
+
+    /// Where a marker is; an error if no file has it or it is in more than
+    /// one place
+    pub fn find(&self, name: &str) -> Result<&Location> {
+        match self.found.get(name).map(Vec::as_slice) {
+            None | Some([]) => Err(Error::InvalidLocation(format!(
+                "no '{} {}' in the program's source files",
+                TAG, name
+            ))),
+            Some([location]) => Ok(location),
+            Some(locations) => Err(Error::InvalidLocation(format!(
+                "marker '{}' is in more than one place: {}",
+                name,
+                locations.iter().map(Location::to_string).collect::<Vec<_>>().join(", ")
+            ))),
+        }
+    }
+
+    /// Each marker's line, for tests of one file where the file goes without
+    /// saying; a marker in several places keeps its first
+    pub fn lines(&self) -> HashMap<String, u32> {
+        self.found
+            .iter()
+            .filter_map(|(name, locations)| Some((name.clone(), locations.first()?.line)))
+            .collect()
+    }
+
+    /// Every marker and where it is, by name, with a marker in several places
+    /// once for each
+    pub fn iter(&self) -> impl Iterator<Item = (&str, &Location)> {
+        self.found
+            .iter()
+            .flat_map(|(name, locations)| locations.iter().map(move |at| (name.as_str(), at)))
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.found.is_empty()
+    }
+}
+
+/// Every marker in a source file and the line it names, in file order
+pub fn scan(text: &str) -> Vec<(String, u32)> {
+    text.lines()
+        .enumerate()
+        .filter_map(|(index, line)| {
+            let at = line.find(TAG)?;
+            let name = line[at + TAG.len()..].split_whitespace().next()?;
+            // `enumerate` counts from 0 and the marker names the next line
+            Some((name.to_string(), index as u32 + 2))
+        })
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn markers_name_the_line_after_them() {
+        let text = "int main() {\n    // BREAKPOINT_MARKER: main_start\n    int x = 10;\n\
+                    # BREAKPOINT_MARKER: before_exit trailing words\n    return 0;\n\
+                    // BREAKPOINT_MARKER:\n}\n";
+        assert_eq!(
+            scan(text),
+            [("main_start".to_string(), 3), ("before_exit".to_string(), 5)]
+        );
+    }
+
+    #[test]
+    fn markers_map_names_to_their_files_and_lines() {
+        let mut markers = Markers::default();
+        markers.add(Path::new("main.c"), "// BREAKPOINT_MARKER: main_start\nint x;\n");
+        markers.add(
+            Path::new("utils.c"),
+            "// BREAKPOINT_MARKER: helper\nx;\n// BREAKPOINT_MARKER: cleanup\ny;\n",
+        );
+        markers.add(Path::new("other.c"), "\n// BREAKPOINT_MARKER: cleanup\nz;\n");
+
+        let helper = markers.find("helper").unwrap();
+        assert_eq!(helper, &Location { file: PathBuf::from("utils.c"), line: 2 });
+        assert_eq!(helper.to_string(), "utils.c:2");
+        let missing = markers.find("missing").unwrap_err().to_string();
+        assert!(missing.contains("no 'BREAKPOINT_MARKER: missing'"), "{}", missing);
+        let cleanup = markers.find("cleanup").unwrap_err().to_string();
+        assert!(cleanup.contains("utils.c:4, other.c:3"), "{}", cleanup);
+
+        assert_eq!(markers.lines()["main_start"], 2);
+        assert_eq!(markers.iter().count(), 4);
+
+        assert_eq!(reference("@marker:main_start"), Some("main_start"));
+        assert_eq!(reference("@marker:"), None);
+        assert_eq!(reference("main_start"), None);
+    }
+}
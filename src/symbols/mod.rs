@@ -0,0 +1,708 @@
+//! Symbols and source files read from the debugged binary
+//!
+//! DAP has no request for listing functions, so the daemon reads the
+//! program's symbol table itself, using DWARF for declaration lines and the
+//! list of source files. The index is built on first use and kept for the
+//! session, and saved in the [`cache`] for the next session on the same
+//! build.
+//!
+//! Only the symbol names and each compilation unit's header, code ranges
+//! and file names are read up front. A unit's entries, where declaration
+//! lines come from, are read the first time a function in it is asked about,
+//! from the binary or, for a `-gsplit-dwarf` build, its [`split`] files. A
+//! stripped binary is read through its separate [`debug_file`].
+
+pub mod cache;
+pub mod debug_file;
+pub mod dwarf;
+pub mod fuzzy;
+pub mod markers;
+pub mod symbolicate;
+mod split;
+
+use std::borrow::Cow;
+use std::collections::{BTreeSet, HashMap, HashSet};
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicUsize, Ordering};
+use std::sync::{Arc, Mutex, RwLock};
+use std::thread;
+
+use object::{Object, ObjectSection, ObjectSymbol, SymbolKind};
+use serde::{Deserialize, Serialize};
+
+use crate::common::{paths, Error, Result};
+
+/// A function from the symbol table
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct Function {
+    /// Demangled name, as accepted by `break`
+    pub name: String,
+    pub address: u64,
+}
+
+/// A compilation unit: where it starts in `.debug_info`, and the code it
+/// covers, each range's end exclusive
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub(crate) struct UnitIndex {
+    offset: usize,
+    ranges: Vec<(u64, u64)>,
+}
+
+/// Functions and source files of one binary
+#[derive(Debug, Default)]
+pub struct SymbolIndex {
+    pub functions: Vec<Function>,
+    pub files: Vec<PathBuf>,
+    /// The binary, for reading units' entries later
+    path: PathBuf,
+    units: Vec<UnitIndex>,
+    loaded: Mutex<Loaded>,
+}
+
+/// The units whose entries have been read, and the declarations found
+#[derive(Debug, Default)]
+struct Loaded {
+    units: HashSet<usize>,
+    declarations: Declarations,
+}
+
+/// A ranked `find` result
+#[derive(Debug)]
+pub struct Match<'a, T> {
+    pub item: &'a T,
+    pub score: i64,
+}
+
+impl SymbolIndex {
+    /// Index the symbol table and DWARF units of an executable or shared
+    /// library, or read the index saved for its build
+    pub fn load(path: &Path) -> Result<Self> {
+        Self::load_with_cache(path, paths::symbol_cache_dir().as_deref())
+    }
+
+    /// [`SymbolIndex::load`], keeping indexes in `cache` if given
+    pub fn load_with_cache(path: &Path, cache: Option<&Path>) -> Result<Self> {
+        let id = cache.and_then(|_| cache::build_id(path));
+        if let (Some(dir), Some(id)) = (cache, &id) {
+            if let Some(stored) = cache::read(dir, id) {
+                tracing::debug!("Read the symbol index of {} from the cache", path.display());
+                return Ok(Self::new(path, stored.functions, stored.files, stored.units));
+            }
+        }
+
+        let binary = open_binary(path)?;
+        let data = object::ReadCache::new(binary);
+        let file = parse_lazily(path, &data)?;
+
+        let mut seen = BTreeSet::new();
+        let mut functions = Vec::new();
+        for symbol in file.symbols().chain(file.dynamic_symbols()) {
+            if symbol.kind() != SymbolKind::Text || symbol.is_undefined() {
+                continue;
+            }
+            let Ok(name) = symbol.name() else { continue };
+            if name.is_empty() || !seen.insert((symbol.address(), name)) {
+                continue;
+            }
+            functions.push(Function {
+                name: demangle(name),
+                address: symbol.address(),
+            });
+        }
+
+        // Missing or unreadable DWARF only costs locations and the file list
+        let (units, files) = match index_units(&file) {
+            Ok(dwarf) => dwarf,
+            Err(e) => {
+                tracing::debug!("No usable DWARF in {}: {}", path.display(), e);
+                (Vec::new(), BTreeSet::new())
+            }
+        };
+        let files: Vec<PathBuf> = files.into_iter().collect();
+
+        // A stripped binary shares its build ID with its debug file, and is
+        // quick to index again once the debug file is installed
+        if let (Some(dir), Some(id), false) = (cache, &id, units.is_empty()) {
+            let stored = cache::Stored::new(functions, files, units);
+            cache::write(dir, id, &stored);
+            return Ok(Self::new(path, stored.functions, stored.files, stored.units));
+        }
+        Ok(Self::new(path, functions, files, units))
+    }
+
+    fn new(
+        path: &Path,
+        functions: Vec<Function>,
+        files: Vec<PathBuf>,
+        units: Vec<UnitIndex>,
+    ) -> Self {
+        Self {
+            functions,
+            files,
+            path: path.to_path_buf(),
+            units,
+            loaded: Mutex::default(),
+        }
+    }
+
+    /// Where a function is declared, when the binary has DWARF for it
+    pub fn declaration(&self, function: &Function) -> Option<(PathBuf, u32)> {
+        self.declarations([function]).pop().flatten()
+    }
+
+    /// Where each function is declared, reading the entries of the units
+    /// they are in that have not been read yet, all in one pass
+    pub fn declarations<'a>(
+        &self,
+        functions: impl IntoIterator<Item = &'a Function>,
+    ) -> Vec<Option<(PathBuf, u32)>> {
+        let functions: Vec<&Function> = functions.into_iter().collect();
+        let mut loaded = self.loaded.lock().unwrap_or_else(|poisoned| poisoned.into_inner());
+        let wanted: BTreeSet<usize> = functions
+            .iter()
+            .flat_map(|function| self.units_at(function.address))
+            .filter(|offset| !loaded.units.contains(offset))
+            .collect();
+        if !wanted.is_empty() {
+            if let Err(e) = self.read_units(&wanted, &mut loaded.declarations) {
+                tracing::debug!("Could not read units of {}: {}", self.path.display(), e);
+            }
+            // Units that failed are not tried again
+            loaded.units.extend(wanted);
+        }
+        functions
+            .iter()
+            .map(|function| loaded.declarations.get(&function.address).cloned())
+            .collect()
+    }
+
+    /// The units whose code includes `address`, or, for an address none
+    /// covers, the units that give no ranges
+    fn units_at(&self, address: u64) -> Vec<usize> {
+        let covering: Vec<usize> = self
+            .units
+            .iter()
+            .filter(|unit| unit.ranges.iter().any(|&(low, high)| low <= address && address < high))
+            .map(|unit| unit.offset)
+            .collect();
+        if !covering.is_empty() {
+            return covering;
+        }
+        self.units
+            .iter()
+            .filter(|unit| unit.ranges.is_empty())
+            .map(|unit| unit.offset)
+            .collect()
+    }
+
+    /// Read the declarations of the units at `offsets`, reading only the
+    /// binary's headers and DWARF sections
+    fn read_units(&self, offsets: &BTreeSet<usize>, into: &mut Declarations) -> Result<()> {
+        let binary = open_binary(&self.path)?;
+        let data = object::ReadCache::new(binary);
+        let file = parse_lazily(&self.path, &data)?;
+        let symbols = |e: gimli::Error| Error::Symbols(format!("{}: {}", self.path.display(), e));
+
+        let sections = load_sections(&file).map_err(symbols)?;
+        let dwarf = sections.borrow(|section| gimli::EndianSlice::new(section, endian(&file)));
+        split::with_split(&self.path, |split| {
+            for &offset in offsets {
+                let header = dwarf
+                    .debug_info
+                    .header_from_offset(gimli::DebugInfoOffset(offset))
+                    .map_err(symbols)?;
+                let unit = dwarf.unit(header).map_err(symbols)?;
+                split
+                    .with_unit(&dwarf, &unit, |dwarf, unit| unit_declarations(dwarf, unit, into))
+                    .map_err(symbols)?;
+            }
+            Ok(())
+        })
+    }
+
+    /// Functions matching a fuzzy query, best first
+    pub fn find_functions(&self, query: &str, limit: usize) -> Vec<Match<'_, Function>> {
+        rank(&self.functions, limit, |function| {
+            fuzzy::score(query, &function.name)
+        })
+    }
+
+    /// Function names a mistyped name was probably meant to be
+    ///
+    /// Names are compared whole and by their last component, so `wroker`
+    /// suggests `main.worker` and `ns::worker` as well as `worker`. A name
+    /// that exists gets no suggestions.
+    pub fn suggest_functions(&self, name: &str, limit: usize) -> Vec<&str> {
+        if self
+            .functions
+            .iter()
+            .any(|f| f.name == name || short_name(&f.name) == name)
+        {
+            return Vec::new();
+        }
+
+        let whole = fuzzy::closest(name, self.functions.iter().map(|f| f.name.as_str()), limit);
+        if !whole.is_empty() {
+            return whole;
+        }
+
+        let short: Vec<&str> = fuzzy::closest(
+            short_name(name),
+            self.functions.iter().map(|f| short_name(&f.name)),
+            limit,
+        );
+        self.functions
+            .iter()
+            .map(|f| f.name.as_str())
+            .filter(|full| short.contains(&short_name(full)) && *full != name)
+            .take(limit)
+            .collect()
+    }
+
+    /// Source files a mistyped file name was probably meant to be, compared
+    /// by file name; a file that exists gets no suggestions
+    pub fn suggest_files(&self, name: &str, limit: usize) -> Vec<&Path> {
+        if self.files.iter().any(|path| path.ends_with(name)) {
+            return Vec::new();
+        }
+
+        let wanted = Path::new(name)
+            .file_name()
+            .map(|name| name.to_string_lossy().into_owned())
+            .unwrap_or_else(|| name.to_string());
+        let names: Vec<String> = self
+            .files
+            .iter()
+            .filter_map(|path| path.file_name())
+            .map(|name| name.to_string_lossy().into_owned())
+            .collect();
+        let close = fuzzy::closest(&wanted, names.iter().map(String::as_str), limit);
+
+        self.files
+            .iter()
+            .filter(|path| {
+                path.file_name()
+                    .is_some_and(|name| close.contains(&name.to_string_lossy().as_ref()))
+            })
+            .map(PathBuf::as_path)
+            .take(limit)
+            .collect()
+    }
+
+    /// Source files matching a fuzzy query, best first
+    ///
+    /// File names are tried before full paths, so `thr go` prefers
+    /// `threaded.go` over a directory that happens to match.
+    pub fn find_files(&self, query: &str, limit: usize) -> Vec<Match<'_, PathBuf>> {
+        rank(&self.files, limit, |path| {
+            let name = path.file_name().map(|name| name.to_string_lossy());
+            name.and_then(|name| fuzzy::score(query, &name))
+                .or_else(|| fuzzy::score(query, &path.to_string_lossy()).map(|s| s - 10))
+        })
+    }
+
+    /// The file and line a `BREAKPOINT_MARKER` names, looking in the source
+    /// files that `local` finds on disk
+    pub fn find_marker(
+        &self,
+        name: &str,
+        local: impl Fn(&Path) -> PathBuf,
+    ) -> Result<(PathBuf, u32)> {
+        let markers = markers::Markers::read_all(self.files.iter().map(|file| local(file)));
+        let location = markers.find(name)?;
+        Ok((location.file.clone(), location.line))
+    }
+}
+
+/// The file to read symbols from for a session's program
+///
+/// Attached sessions record the program as `pid:<pid>`; on Linux the
+/// executable is still reachable through `/proc`.
+pub fn binary_path(program: &Path) -> PathBuf {
+    match program.to_str().and_then(|p| p.strip_prefix("pid:")) {
+        Some(pid) => PathBuf::from(format!("/proc/{}/exe", pid)),
+        None => program.to_path_buf(),
+    }
+}
+
+/// The file to read a binary's symbols from: its separate debug file if it
+/// is stripped and one is found under `directories`, or else the binary
+pub fn symbols_path(binary: &Path, directories: &[PathBuf]) -> PathBuf {
+    debug_file::find(binary, directories).unwrap_or_else(|| binary.to_path_buf())
+}
+
+/// A function name without the module an adapter may put in front of it or
+/// its parameter list: `parse` for ``a.out`parse(char const*)``
+pub fn base_name(name: &str) -> &str {
+    let name = name.rsplit_once('`').map_or(name, |(_, name)| name);
+    match name.find('(') {
+        Some(at) if at > 0 => name[..at].trim_end(),
+        _ => name.trim(),
+    }
+}
+
+/// The last component of a qualified name: `worker` in `main.worker` or
+/// `ns::Type::worker`
+fn short_name(name: &str) -> &str {
+    let after_colons = name.rsplit("::").next().unwrap_or(name);
+    after_colons.rsplit('.').next().unwrap_or(after_colons)
+}
+
+fn rank<T>(items: &[T], limit: usize, score: impl Fn(&T) -> Option<i64>) -> Vec<Match<'_, T>> {
+    let mut matches: Vec<Match<'_, T>> = items
+        .iter()
+        .filter_map(|item| score(item).map(|score| Match { item, score }))
+        .collect();
+    // Stable sort keeps symbol table order among equal scores
+    matches.sort_by(|a, b| b.score.cmp(&a.score));
+    matches.truncate(limit);
+    matches
+}
+
+fn open_binary(path: &Path) -> Result<std::fs::File> {
+    std::fs::File::open(path).map_err(|e| Error::FileRead {
+        path: path.display().to_string(),
+        error: e.to_string(),
+    })
+}
+
+fn read_binary(path: &Path) -> Result<Vec<u8>> {
+    std::fs::read(path).map_err(|e| Error::FileRead {
+        path: path.display().to_string(),
+        error: e.to_string(),
+    })
+}
+
+fn parse_binary<'a>(path: &Path, data: &'a [u8]) -> Result<object::File<'a>> {
+    object::File::parse(data).map_err(|e| Error::Symbols(format!("{}: {}", path.display(), e)))
+}
+
+/// Parse a binary read only as far as it is used, for DWARF sections that
+/// are a small part of a large file
+fn parse_lazily<'a>(
+    path: &Path,
+    data: &'a object::ReadCache<std::fs::File>,
+) -> Result<object::File<'a, &'a object::ReadCache<std::fs::File>>> {
+    object::File::parse(data).map_err(|e| Error::Symbols(format!("{}: {}", path.display(), e)))
+}
+
+/// Demangle Rust symbols; other names (C, Go) are used as they are
+fn demangle(name: &str) -> String {
+    match rustc_demangle::try_demangle(name) {
+        // `{:#}` drops the hash suffix
+        Ok(demangled) => format!("{:#}", demangled),
+        Err(_) => name.to_string(),
+    }
+}
+
+fn endian<'a, R: object::ReadRef<'a>>(file: &object::File<'a, R>) -> gimli::RunTimeEndian {
+    if file.is_little_endian() {
+        gimli::RunTimeEndian::Little
+    } else {
+        gimli::RunTimeEndian::Big
+    }
+}
+
+/// The DWARF sections of a binary; missing sections are empty
+fn load_sections<'data, R: object::ReadRef<'data>>(
+    file: &object::File<'data, R>,
+) -> std::result::Result<gimli::DwarfSections<Cow<'data, [u8]>>, gimli::Error> {
+    gimli::DwarfSections::load(|id| -> std::result::Result<Cow<'data, [u8]>, gimli::Error> {
+        Ok(file
+            .section_by_name(id.name())
+            .and_then(|section| section_data(&section))
+            .unwrap_or(Cow::Borrowed(&[])))
+    })
+}
+
+/// A section's contents, inflated if it is zlib-compressed, as the Go
+/// linker leaves its DWARF
+fn section_data<'data, R: object::ReadRef<'data>>(
+    section: &object::Section<'data, '_, R>,
+) -> Option<Cow<'data, [u8]>> {
+    let compressed = section.compressed_data().ok()?;
+    if compressed.format != object::CompressionFormat::Zlib {
+        return compressed.decompress().ok();
+    }
+    let mut data = Vec::with_capacity(compressed.uncompressed_size as usize);
+    let mut decoder = flate2::read::ZlibDecoder::new(compressed.data);
+    std::io::Read::read_to_end(&mut decoder, &mut data).ok()?;
+    Some(Cow::Owned(data))
+}
+
+type Declarations = HashMap<u64, (PathBuf, u32)>;
+
+/// Every unit of a binary's DWARF, with the code it covers, and every file
+/// named by a line table; no unit's entries are read beyond its first
+fn index_units<'a, R: object::ReadRef<'a>>(
+    file: &object::File<'a, R>,
+) -> std::result::Result<(Vec<UnitIndex>, BTreeSet<PathBuf>), gimli::Error> {
+    let sections = load_sections(file)?;
+    let dwarf = sections.borrow(|section| gimli::EndianSlice::new(section, endian(file)));
+
+    let indexed = each_unit(&dwarf, |unit| {
+        let mut files = Vec::new();
+        if let Some(program) = unit.line_program.as_ref() {
+            let line_header = program.header();
+            for entry in line_header.file_names() {
+                if let Some(path) = file_path(&dwarf, unit, line_header, entry) {
+                    files.push(path);
+                }
+            }
+        }
+
+        let mut ranges = Vec::new();
+        let mut found = dwarf.unit_ranges(unit)?;
+        while let Some(range) = found.next()? {
+            if range.begin < range.end {
+                ranges.push((range.begin, range.end));
+            }
+        }
+        let offset = unit.header.offset().as_debug_info_offset();
+        Ok((offset.map(|offset| UnitIndex { offset: offset.0, ranges }), files))
+    })?;
+
+    let mut units = Vec::new();
+    let mut files = BTreeSet::new();
+    for (unit, unit_files) in indexed {
+        units.extend(unit);
+        files.extend(unit_files);
+    }
+    Ok((units, files))
+}
+
+/// Add the declaration locations of a unit's functions, by address
+fn unit_declarations(
+    dwarf: &gimli::Dwarf<Reader<'_>>,
+    unit: &gimli::Unit<Reader<'_>>,
+    declarations: &mut Declarations,
+) -> std::result::Result<(), gimli::Error> {
+    let Some(program) = unit.line_program.as_ref() else {
+        return Ok(());
+    };
+    let line_header = program.header();
+
+    let mut entries = unit.entries();
+    while let Some((_, entry)) = entries.next_dfs()? {
+        if entry.tag() != gimli::DW_TAG_subprogram {
+            continue;
+        }
+        let address = match entry.attr_value(gimli::DW_AT_low_pc)? {
+            Some(gimli::AttributeValue::Addr(address)) => address,
+            Some(gimli::AttributeValue::DebugAddrIndex(index)) => dwarf.address(unit, index)?,
+            _ => continue,
+        };
+        let file_index = match entry.attr_value(gimli::DW_AT_decl_file)? {
+            Some(gimli::AttributeValue::FileIndex(index)) => index,
+            Some(value) => match value.udata_value() {
+                Some(index) => index,
+                None => continue,
+            },
+            None => continue,
+        };
+        let Some(line) = entry
+            .attr_value(gimli::DW_AT_decl_line)?
+            .and_then(|value| value.udata_value())
+        else {
+            continue;
+        };
+        let path = line_header
+            .file(file_index)
+            .and_then(|file| file_path(dwarf, unit, line_header, file));
+        if let Some(path) = path {
+            declarations.insert(address, (path, line as u32));
+        }
+    }
+    Ok(())
+}
+
+type Reader<'a> = gimli::EndianSlice<'a, gimli::RunTimeEndian>;
+
+/// Reports units read so far and units in all, during a read of every unit
+pub type Progress = dyn Fn(usize, usize) + Send + Sync;
+
+static PROGRESS: RwLock<Option<Arc<Progress>>> = RwLock::new(None);
+
+/// Report every read of all of a binary's units to `report`, until the
+/// returned guard is dropped; `symbolicate` draws a progress bar with it
+pub fn report_progress(report: impl Fn(usize, usize) + Send + Sync + 'static) -> ProgressGuard {
+    *PROGRESS.write().unwrap_or_else(|poisoned| poisoned.into_inner()) = Some(Arc::new(report));
+    ProgressGuard(())
+}
+
+/// Stops [`report_progress`]'s reports when dropped
+pub struct ProgressGuard(());
+
+impl Drop for ProgressGuard {
+    fn drop(&mut self) {
+        *PROGRESS.write().unwrap_or_else(|poisoned| poisoned.into_inner()) = None;
+    }
+}
+
+/// `read` every unit, on a thread per core, and return the results in unit
+/// order, or the first unit's error. Units are independent of each other,
+/// so a large binary's debug info is read as fast as the cores allow.
+fn each_unit<'a, T: Send>(
+    dwarf: &gimli::Dwarf<Reader<'a>>,
+    read: impl Fn(&gimli::Unit<Reader<'a>>) -> std::result::Result<T, gimli::Error> + Sync,
+) -> std::result::Result<Vec<T>, gimli::Error> {
+    let mut headers = Vec::new();
+    let mut found = dwarf.units();
+    while let Some(header) = found.next()? {
+        headers.push(header);
+    }
+    let total = headers.len();
+    let progress = PROGRESS.read().unwrap_or_else(|poisoned| poisoned.into_inner()).clone();
+    let workers = thread::available_parallelism().map_or(1, |n| n.get()).clamp(1, total.max(1));
+
+    // Workers take the next unit as they finish one, as units vary in size
+    let next = AtomicUsize::new(0);
+    let done = AtomicUsize::new(0);
+    let work = || {
+        let mut results = Vec::new();
+        loop {
+            let at = next.fetch_add(1, Ordering::Relaxed);
+            let Some(header) = headers.get(at) else {
+                break;
+            };
+            let result = dwarf.unit(*header).and_then(|unit| read(&unit));
+            let failed = result.is_err();
+            results.push((at, result));
+            if let Some(report) = &progress {
+                report(done.fetch_add(1, Ordering::Relaxed) + 1, total);
+            }
+            if failed {
+                // The units before this one were all taken, so they finish
+                next.store(total, Ordering::Relaxed);
+                break;
+            }
+        }
+        results
+    };
+    let mut results: Vec<_> = thread::scope(|scope| {
+        let running: Vec<_> = (0..workers).map(|_| scope.spawn(work)).collect();
+        running
+            .into_iter()
+            .flat_map(|worker| {
+                worker.join().unwrap_or_else(|panic| std::panic::resume_unwind(panic))
+            })
+            .collect()
+    });
+    results.sort_by_key(|(at, _)| *at);
+    results.into_iter().map(|(_, result)| result).collect()
+}
+
+/// Join a line table entry with its directory and the unit's `comp_dir`
+fn file_path(
+    dwarf: &gimli::Dwarf<Reader<'_>>,
+    unit: &gimli::Unit<Reader<'_>>,
+    header: &gimli::LineProgramHeader<Reader<'_>>,
+    file: &gimli::FileEntry<Reader<'_>>,
+) -> Option<PathBuf> {
+    let mut path = PathBuf::new();
+    if let Some(comp_dir) = &unit.comp_dir {
+        path.push(&*comp_dir.to_string_lossy());
+    }
+    if let Some(directory) = file.directory(header) {
+        let directory = dwarf.attr_string(unit, directory).ok()?;
+        // An absolute directory replaces comp_dir
+        path.push(&*directory.to_string_lossy());
+    }
+    let name = dwarf.attr_string(unit, file.path_name()).ok()?;
+    path.push(&*name.to_string_lossy());
+    Some(path)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn names_lose_module_and_parameters() {
+        assert_eq!(base_name("a.out`parse(char const*)"), "parse");
+        assert_eq!(base_name("ns::Worker::run(int) const"), "ns::Worker::run");
+        assert_eq!(base_name("main.run"), "main.run");
+    }
+
+    #[test]
+    fn loads_own_symbols_and_sources() {
+        let exe = std::env::current_exe().unwrap();
+        let index = SymbolIndex::load(&exe).unwrap();
+
+        let found = index.find_functions("lds own symbls", 5);
+        assert!(found.iter().any(|m| m
+            .item
+            .name
+            .ends_with("symbols::tests::loads_own_symbols_and_sources")));
+
+        let suggested = index.suggest_functions("loads_own_symbosl_and_sources", 3);
+        assert!(suggested
+            .iter()
+            .any(|name| name.ends_with("tests::loads_own_symbols_and_sources")));
+
+        let found = index.find_files("symbols mod", 50);
+        assert!(found.iter().any(|m| m.item.ends_with("src/symbols/mod.rs")));
+    }
+
+    #[test]
+    fn indexes_are_cached_by_build_id_and_units_read_when_asked() {
+        let exe = std::env::current_exe().unwrap();
+        let cache = std::env::temp_dir().join(format!("symbol-cache-test-{}", std::process::id()));
+        let index = SymbolIndex::load_with_cache(&exe, Some(&cache)).unwrap();
+        let name = "symbols::tests::indexes_are_cached_by_build_id_and_units_read_when_asked";
+        let this = index.functions.iter().find(|f| f.name.ends_with(name)).unwrap();
+        assert!(index.loaded.lock().unwrap().units.is_empty());
+
+        let (file, line) = index.declaration(this).expect("no declaration for this test");
+        assert!(file.ends_with("src/symbols/mod.rs"), "{}", file.display());
+        assert!(line > 0);
+        let read = index.loaded.lock().unwrap().units.len();
+        assert!(read > 0 && read < index.units.len(), "read {} units", read);
+
+        if let Some(id) = cache::build_id(&exe) {
+            assert!(cache.join(format!("{}.json", id)).is_file());
+            let cached = SymbolIndex::load_with_cache(&exe, Some(&cache)).unwrap();
+            assert_eq!(cached.functions.len(), index.functions.len());
+            assert_eq!(cached.declaration(this), Some((file, line)));
+        }
+        let _ = std::fs::remove_dir_all(&cache);
+    }
+
+    #[test]
+    fn units_read_in_parallel_come_back_in_order() {
+        let exe = std::env::current_exe().unwrap();
+        let data = read_binary(&exe).unwrap();
+        let file = parse_binary(&exe, &data).unwrap();
+        let sections = load_sections(&file).unwrap();
+        let dwarf = sections.borrow(|section| gimli::EndianSlice::new(section, endian(&file)));
+
+        let mut expected = Vec::new();
+        let mut headers = dwarf.units();
+        while let Some(header) = headers.next().unwrap() {
+            expected.push(header.offset());
+        }
+        let reports = Arc::new(Mutex::new(Vec::new()));
+        let recorded = Arc::clone(&reports);
+        let guard = report_progress(move |done, total| {
+            recorded.lock().unwrap().push((done, total));
+        });
+        let offsets = each_unit(&dwarf, |unit| Ok(unit.header.offset())).unwrap();
+        drop(guard);
+        assert_eq!(offsets, expected);
+
+        // Other tests may read units while this one reports, so only this
+        // read's last report is certain
+        let reports = reports.lock().unwrap();
+        assert!(reports.contains(&(expected.len(), expected.len())), "{:?}", reports.last());
+        assert!(reports.iter().all(|(done, total)| done <= total));
+    }
+
+    #[test]
+    fn attached_programs_read_proc_exe() {
+        assert_eq!(
+            binary_path(Path::new("pid:42")),
+            PathBuf::from("/proc/42/exe")
+        );
+        assert_eq!(binary_path(Path::new("./a.out")), PathBuf::from("./a.out"));
+    }
+}
@@ -0,0 +1,208 @@
+//! Debug info a binary built with `-gsplit-dwarf` keeps in other files
+//!
+//! The binary keeps a skeleton of each compilation unit: its code ranges,
+//! its line table, and the name and ID of the `.dwo` file holding the rest.
+//! A unit's entries, where functions, types and declarations are, are read
+//! from the `.dwp` package next to the binary if there is one, as `dwp` and
+//! `llvm-dwp` make them, and otherwise from the unit's `.dwo`. The `.dwo` is
+//! named relative to the unit's `comp_dir`, and is also looked for next to
+//! the binary, for builds that have been copied elsewhere.
+
+use std::borrow::Cow;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+use gimli::EndianSlice;
+
+use super::Reader;
+
+type Dwarf<'a> = gimli::Dwarf<Reader<'a>>;
+type Unit<'a> = gimli::Unit<Reader<'a>>;
+type GimliResult<T> = std::result::Result<T, gimli::Error>;
+
+/// Where the split units of one binary are read from
+pub(super) struct Split<'a> {
+    binary_dir: PathBuf,
+    package: Option<gimli::DwarfPackage<Reader<'a>>>,
+}
+
+/// Run `query` with the split units of the binary at `path`. Of the
+/// package, only its index is read up front.
+pub(super) fn with_split<T>(path: &Path, query: impl FnOnce(&Split<'_>) -> T) -> T {
+    // An attached program is read through /proc/<pid>/exe
+    let binary = fs::canonicalize(path).unwrap_or_else(|_| path.to_path_buf());
+    let binary_dir = binary.parent().map(Path::to_path_buf).unwrap_or_default();
+    let mut package_path = binary.into_os_string();
+    package_path.push(".dwp");
+
+    let data = fs::File::open(&package_path).ok().map(object::ReadCache::new);
+    let file = data.as_ref().and_then(|data| object::File::parse(data).ok());
+    let sections = file
+        .as_ref()
+        .and_then(|file| gimli::DwarfPackageSections::load(|id| dwo_section(file, id)).ok());
+    let package = file.as_ref().zip(sections.as_ref()).and_then(|(file, sections)| {
+        let endian = super::endian(file);
+        let package = sections.borrow(
+            |section| EndianSlice::new(section, endian),
+            EndianSlice::new(&[], endian),
+        );
+        package
+            .map_err(|e| tracing::debug!("Unreadable package {:?}: {}", package_path, e))
+            .ok()
+    });
+    query(&Split {
+        binary_dir,
+        package,
+    })
+}
+
+impl<'a> Split<'a> {
+    /// Run `read` on the unit holding `unit`'s entries: its split unit if
+    /// it is a skeleton, or else the unit itself. A skeleton whose split
+    /// unit can't be found is read as it is, which gives its line table
+    /// and code ranges but no entries.
+    pub fn with_unit<T>(
+        &self,
+        dwarf: &Dwarf<'a>,
+        unit: &Unit<'a>,
+        read: impl for<'b> FnOnce(&Dwarf<'b>, &Unit<'b>) -> GimliResult<T>,
+    ) -> GimliResult<T> {
+        let Some(id) = unit.dwo_id else {
+            return read(dwarf, unit);
+        };
+
+        if let Some(package) = &self.package {
+            if let Some(mut dwo) = package.find_cu(id, dwarf)? {
+                dwo.debug_line_str = dwarf.debug_line_str;
+                if let Some(split) = split_unit(&dwo, unit)? {
+                    return read(&dwo, &split);
+                }
+            }
+        }
+
+        let name = match unit.dwo_name()? {
+            Some(name) => dwarf.attr_string(unit, name)?.to_string_lossy().into_owned(),
+            None => return read(dwarf, unit),
+        };
+        let comp_dir = unit.comp_dir.map(|dir| PathBuf::from(&*dir.to_string_lossy()));
+        let paths = dwo_paths(Path::new(&name), comp_dir.as_deref(), &self.binary_dir);
+        let Some(data) = paths.iter().find_map(|path| fs::read(path).ok()) else {
+            tracing::debug!("No split DWARF file {} for unit {:#x}", name, id.0);
+            return read(dwarf, unit);
+        };
+        let Ok(file) = object::File::parse(&*data) else {
+            tracing::debug!("Unreadable split DWARF file {}", name);
+            return read(dwarf, unit);
+        };
+        let sections = gimli::DwarfSections::load(|id| dwo_section(&file, id))?;
+        let endian = super::endian(&file);
+        let mut dwo = sections.borrow(|section| EndianSlice::new(section, endian));
+        dwo.make_dwo(dwarf);
+        dwo.debug_line_str = dwarf.debug_line_str;
+        match split_unit(&dwo, unit)? {
+            Some(split) => read(&dwo, &split),
+            None => {
+                tracing::debug!("{} has no unit {:#x}", name, id.0);
+                read(dwarf, unit)
+            }
+        }
+    }
+}
+
+/// The unit in `dwo` with `skeleton`'s ID, with what it takes from the
+/// skeleton: its base addresses, line table and `comp_dir`. Declarations in
+/// a split unit name files from the skeleton's line table, whose strings
+/// are in the binary's `.debug_line_str`.
+fn split_unit<'b>(dwo: &Dwarf<'b>, skeleton: &Unit<'b>) -> GimliResult<Option<Unit<'b>>> {
+    let mut headers = dwo.units();
+    while let Some(header) = headers.next()? {
+        let mut unit = dwo.unit(header)?;
+        if unit.dwo_id != skeleton.dwo_id {
+            continue;
+        }
+        unit.copy_relocated_attributes(skeleton);
+        if skeleton.line_program.is_some() {
+            unit.line_program.clone_from(&skeleton.line_program);
+        }
+        if skeleton.comp_dir.is_some() {
+            unit.comp_dir = skeleton.comp_dir;
+        }
+        return Ok(Some(unit));
+    }
+    Ok(None)
+}
+
+/// Where a unit's `.dwo` may be, in the order tried: as named, against
+/// `comp_dir` if the name is relative, then next to the binary
+fn dwo_paths(name: &Path, comp_dir: Option<&Path>, binary_dir: &Path) -> Vec<PathBuf> {
+    let mut paths = vec![match comp_dir {
+        Some(dir) if name.is_relative() => dir.join(name),
+        _ => name.to_path_buf(),
+    }];
+    if name.is_relative() {
+        paths.push(binary_dir.join(name));
+    }
+    if let Some(file_name) = name.file_name() {
+        paths.push(binary_dir.join(file_name));
+    }
+    paths.dedup();
+    paths
+}
+
+/// A section of a `.dwo` or `.dwp`, which have `.dwo` names; missing
+/// sections are empty
+fn dwo_section<'data, R: object::ReadRef<'data>>(
+    file: &object::File<'data, R>,
+    id: gimli::SectionId,
+) -> GimliResult<Cow<'data, [u8]>> {
+    use object::Object;
+
+    Ok(id
+        .dwo_name()
+        .and_then(|name| file.section_by_name(name))
+        .and_then(|section| super::section_data(&section))
+        .unwrap_or(Cow::Borrowed(&[])))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::symbols::{endian, load_sections, parse_binary, read_binary};
+
+    #[test]
+    fn dwo_files_are_found_against_comp_dir_then_beside_the_binary() {
+        let binary_dir = Path::new("/srv/app");
+        assert_eq!(
+            dwo_paths(Path::new("obj/main.dwo"), Some(Path::new("/build")), binary_dir),
+            [
+                PathBuf::from("/build/obj/main.dwo"),
+                PathBuf::from("/srv/app/obj/main.dwo"),
+                PathBuf::from("/srv/app/main.dwo"),
+            ]
+        );
+        assert_eq!(
+            dwo_paths(Path::new("/build/main.dwo"), Some(Path::new("/build")), binary_dir),
+            [PathBuf::from("/build/main.dwo"), PathBuf::from("/srv/app/main.dwo")]
+        );
+        assert_eq!(
+            dwo_paths(Path::new("main.dwo"), None, binary_dir),
+            [PathBuf::from("main.dwo"), PathBuf::from("/srv/app/main.dwo")]
+        );
+    }
+
+    #[test]
+    fn binaries_without_split_units_are_read_as_they_are() {
+        let exe = std::env::current_exe().unwrap();
+        let data = read_binary(&exe).unwrap();
+        let file = parse_binary(&exe, &data).unwrap();
+        let sections = load_sections(&file).unwrap();
+        let dwarf = sections.borrow(|section| EndianSlice::new(section, endian(&file)));
+        let header = dwarf.units().next().unwrap().unwrap();
+        let unit = dwarf.unit(header).unwrap();
+        let offset = with_split(&exe, |split| {
+            assert!(split.package.is_none());
+            split.with_unit(&dwarf, &unit, |_, read| Ok(read.header.offset()))
+        });
+        assert_eq!(offset.unwrap(), unit.header.offset());
+    }
+}
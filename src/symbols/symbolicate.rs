@@ -0,0 +1,417 @@
+//! Resolving addresses from logs for `symbolicate`
+//!
+//! A crash reported from production often comes as nothing but addresses: a
+//! glibc or sanitizer backtrace, `pc=0x4566a1` in a Go signal report, or
+//! the `+0x1d` offsets into each function of a Go panic. `symbolicate` puts
+//! a function and source line to each of them from a copy of the binary,
+//! with the same symbol table and DWARF that `find` and `dwarf` read for a
+//! session's program. Offsets into the binary or a function need nothing
+//! else, but a position-independent executable's absolute addresses only
+//! mean something once the slide, the address it was loaded at minus the
+//! address it was linked at, is taken off.
+
+use std::path::{Path, PathBuf};
+
+use object::{Object, ObjectSection, ObjectSegment, ObjectSymbol, SectionKind, SymbolKind};
+use serde::{Deserialize, Serialize};
+
+use crate::common::Result;
+
+use super::debug_file;
+use super::dwarf::{self, FunctionEntry, InlinedEntry, LineEntry};
+
+/// One line of the log and the addresses in it that were placed
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct LogLine {
+    /// Line number in the log, from 1
+    pub number: usize,
+    pub text: String,
+    pub frames: Vec<Frame>,
+}
+
+/// Where an address in the log is in the binary
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct Frame {
+    /// The address or offset as the log has it
+    pub text: String,
+    /// The address in the binary it comes to
+    pub address: u64,
+    pub function: String,
+    /// Bytes into the function
+    pub offset: u64,
+    /// Functions inlined at the address, innermost first
+    pub inlined: Vec<String>,
+    pub file: Option<String>,
+    pub line: Option<u32>,
+}
+
+/// The addresses a log has and how many of them were placed
+#[derive(Debug, Default)]
+pub struct Symbolicated {
+    pub lines: Vec<LogLine>,
+    pub found: usize,
+    pub resolved: usize,
+}
+
+/// An address as a log can give it
+#[derive(Debug, PartialEq)]
+enum Reference<'a> {
+    /// As the program saw it, so after the slide
+    Absolute(u64),
+    /// From the start of the binary, as in `prog(+0x1189)`
+    Module(u64),
+    /// From the start of a function, as in `main+0x1d`
+    Function(&'a str, u64),
+    /// From the start of a Go function, as a traceback has it
+    Go(&'a str, u64),
+}
+
+#[derive(Debug)]
+struct Symbol {
+    name: String,
+    address: u64,
+    /// 0 when the symbol table doesn't say
+    size: u64,
+}
+
+/// A binary's functions, code and line table, for placing addresses
+#[derive(Debug, Default)]
+pub struct Symbolicator {
+    /// File name of the binary, as backtraces name it
+    name: String,
+    /// Where the binary is linked to be loaded
+    base: u64,
+    /// Address ranges of the code, each end exclusive
+    code: Vec<(u64, u64)>,
+    /// By address
+    symbols: Vec<Symbol>,
+    functions: Vec<FunctionEntry>,
+    /// By address
+    lines: Vec<LineEntry>,
+    inlined: Vec<InlinedEntry>,
+}
+
+impl Symbolicator {
+    /// Read the symbol table, and the DWARF if there is any, of a binary,
+    /// or of its separate debug file if it is stripped and one is installed
+    pub fn load(path: &Path) -> Result<Self> {
+        let data = super::read_binary(path)?;
+        let file = super::parse_binary(path, &data)?;
+
+        let base = file.segments().map(|segment| segment.address()).min().unwrap_or(0);
+        let code = file
+            .sections()
+            .filter(|section| section.kind() == SectionKind::Text)
+            .map(|section| (section.address(), section.address() + section.size()))
+            .collect();
+
+        // A debug file's code sections are empty, but its symbols are the
+        // binary's before it was stripped
+        let directories = [PathBuf::from(debug_file::DEFAULT_DIRECTORY)];
+        let debug = debug_file::find(path, &directories);
+        let debug_data = debug.as_deref().map(super::read_binary).transpose()?;
+        let symbols_path = debug.as_deref().unwrap_or(path);
+        let debug_object = debug_data
+            .as_deref()
+            .map(|data| super::parse_binary(symbols_path, data))
+            .transpose()?;
+        let symbols_file = debug_object.as_ref().unwrap_or(&file);
+
+        let mut symbols: Vec<Symbol> = Vec::new();
+        for symbol in symbols_file.symbols().chain(symbols_file.dynamic_symbols()) {
+            if symbol.kind() != SymbolKind::Text || symbol.is_undefined() {
+                continue;
+            }
+            let Ok(name) = symbol.name() else { continue };
+            if !name.is_empty() {
+                symbols.push(Symbol {
+                    name: super::demangle(name),
+                    address: symbol.address(),
+                    size: symbol.size(),
+                });
+            }
+        }
+        // The sized one of the symbols at an address, where any is
+        symbols.sort_by_key(|symbol| (symbol.address, std::cmp::Reverse(symbol.size)));
+        symbols.dedup_by_key(|symbol| symbol.address);
+
+        // Without DWARF, addresses still get functions
+        let functions = debug_info(symbols_path, "functions", dwarf::functions(symbols_path, None));
+        let mut lines = debug_info(symbols_path, "line table", dwarf::lines(symbols_path, None));
+        lines.sort_by_key(|line| line.address);
+        let inlined = debug_info(symbols_path, "inlined calls", dwarf::inlined(symbols_path, None));
+
+        Ok(Self {
+            name: path.file_name().unwrap_or_default().to_string_lossy().into_owned(),
+            base,
+            code,
+            symbols,
+            functions,
+            lines,
+            inlined,
+        })
+    }
+
+    /// Place every address in a log, taking `slide` off absolute ones
+    pub fn symbolicate(&self, log: &str, slide: u64) -> Symbolicated {
+        let mut result = Symbolicated::default();
+        let mut previous = None;
+        for (index, text) in log.lines().enumerate() {
+            let mut frames = Vec::new();
+            for (written, reference) in self.references(text, previous) {
+                result.found += 1;
+                let go = matches!(reference, Reference::Go(..));
+                let address = match reference {
+                    Reference::Absolute(address) => address.checked_sub(slide),
+                    Reference::Module(offset) => self.base.checked_add(offset),
+                    Reference::Function(name, offset) | Reference::Go(name, offset) => self
+                        .symbols
+                        .iter()
+                        .find(|symbol| symbol.name == name || symbol.name == super::demangle(name))
+                        .and_then(|symbol| symbol.address.checked_add(offset)),
+                };
+                if let Some(frame) = address.and_then(|address| self.lookup(address, go)) {
+                    frames.push(Frame { text: written.to_string(), ..frame });
+                }
+            }
+            result.resolved += frames.len();
+            if !frames.is_empty() {
+                result.lines.push(LogLine { number: index + 1, text: text.to_string(), frames });
+            }
+            previous = Some(text);
+        }
+        result
+    }
+
+    /// The function and line at an address in the binary; `returned` for
+    /// the return addresses of Go's callers, whose line Go looks up a byte
+    /// before, in the call
+    fn lookup(&self, address: u64, returned: bool) -> Option<Frame> {
+        if !self.code.iter().any(|(low, high)| (*low..*high).contains(&address)) {
+            return None;
+        }
+        let at = self.symbols.partition_point(|symbol| symbol.address <= address);
+        let symbol = at.checked_sub(1).map(|at| &self.symbols[at]);
+        let (function, start) = match symbol {
+            Some(symbol) if symbol.size == 0 || address < symbol.address + symbol.size => {
+                (symbol.name.clone(), symbol.address)
+            }
+            _ => {
+                let function = self
+                    .functions
+                    .iter()
+                    .filter(|function| (function.low_pc..function.high_pc).contains(&address))
+                    .min_by_key(|function| function.high_pc - function.low_pc)?;
+                (function.name.clone(), function.low_pc)
+            }
+        };
+
+        let wanted = if returned && address > start { address - 1 } else { address };
+        let row = self.lines.partition_point(|line| line.address <= wanted);
+        let line = row
+            .checked_sub(1)
+            .map(|row| &self.lines[row])
+            .filter(|line| line.address >= start);
+
+        let mut inlined: Vec<&InlinedEntry> = self
+            .inlined
+            .iter()
+            .filter(|call| call.ranges.iter().any(|(low, high)| (*low..*high).contains(&wanted)))
+            .collect();
+        // Inner calls cover less of the code than the calls around them
+        inlined.sort_by_key(|call| call.ranges.iter().map(|(low, high)| high - low).sum::<u64>());
+
+        Some(Frame {
+            text: String::new(),
+            address,
+            function,
+            offset: address - start,
+            inlined: inlined.into_iter().map(|call| call.name.clone()).collect(),
+            file: line.map(|line| line.file.clone()),
+            line: line.and_then(|line| line.line),
+        })
+    }
+
+    /// The addresses in a line of the log, each with its text; `previous`
+    /// line names the function of a Go frame's `\tfile.go:12 +0x1d`
+    fn references<'a>(
+        &self,
+        line: &'a str,
+        previous: Option<&'a str>,
+    ) -> Vec<(&'a str, Reference<'a>)> {
+        // `fp=`, `sp=` and `pc=` may follow with GOTRACEBACK=system
+        if let Some(at) = line.starts_with('\t').then(|| line.find(" +0x")).flatten() {
+            let written = line[at + 1..].split_whitespace().next().unwrap_or_default();
+            let function = previous.and_then(go_function);
+            let value = u64::from_str_radix(&written[3..], 16);
+            if let (Some(function), Ok(value)) = (function, value) {
+                return vec![(written, Reference::Go(function, value))];
+            }
+        }
+        let trimmed = line.trim();
+        if !trimmed.is_empty() && trimmed.bytes().all(|b| b.is_ascii_hexdigit()) {
+            if let Ok(address) = u64::from_str_radix(trimmed, 16) {
+                return vec![(trimmed, Reference::Absolute(address))];
+            }
+        }
+
+        let mut references = Vec::new();
+        for (at, _) in line.match_indices("0x") {
+            let before = line[..at].chars().next_back();
+            if before.is_some_and(|c| c.is_ascii_alphanumeric()) {
+                continue;
+            }
+            let digits = &line[at + 2..];
+            let end = digits.find(|c: char| !c.is_ascii_hexdigit()).unwrap_or(digits.len());
+            let Ok(value) = u64::from_str_radix(&digits[..end], 16) else {
+                continue;
+            };
+            if before != Some('+') {
+                references.push((&line[at..at + 2 + end], Reference::Absolute(value)));
+                continue;
+            }
+
+            // What the offset is from: `main`, `./prog`, or nothing, with
+            // glibc's `./prog(main+0x1d)` naming the module in front
+            let name_start = line[..at - 1]
+                .rfind(|c: char| c.is_whitespace() || matches!(c, '(' | '[' | '<'))
+                .map_or(0, |start| start + 1);
+            let name = &line[name_start..at - 1];
+            let module = line[..name_start]
+                .strip_suffix('(')
+                .map(|before| before.rsplit(char::is_whitespace).next().unwrap_or(before));
+            let ours = |module: &str| module.rsplit('/').next() == Some(self.name.as_str());
+            let reference = match module {
+                // Another library's
+                Some(module) if !module.is_empty() && !ours(module) => continue,
+                _ if name.is_empty() || ours(name) => Reference::Module(value),
+                _ if name.contains('/') => continue,
+                _ => Reference::Function(name, value),
+            };
+            references.push((&line[name_start..at + 2 + end], reference));
+        }
+        references
+    }
+}
+
+/// What the debug info has, or nothing if it can't be read
+fn debug_info<T>(path: &Path, what: &str, read: Result<Vec<T>>) -> Vec<T> {
+    read.unwrap_or_else(|e| {
+        tracing::debug!("No {} from {}: {}", what, path.display(), e);
+        Vec::new()
+    })
+}
+
+/// The function a Go traceback names on the line before a location:
+/// `main.(*Pool).run(0xc000010000, 0x1)`, or `created by main.main in
+/// goroutine 1`
+fn go_function(line: &str) -> Option<&str> {
+    let line = line.trim();
+    let line = line.strip_prefix("created by ").unwrap_or(line);
+    let line = line.split(" in goroutine ").next().unwrap_or(line);
+    let name = match line.strip_suffix(')') {
+        Some(_) => &line[..line.rfind('(')?],
+        None => line,
+    };
+    (!name.is_empty() && !name.contains(char::is_whitespace)).then_some(name)
+}
+
+/// Parse an address or slide as hex (`0x55d0c0a00000`) or decimal
+pub fn parse_address(text: &str) -> std::result::Result<u64, String> {
+    let text = text.trim();
+    match text.strip_prefix("0x").or_else(|| text.strip_prefix("0X")) {
+        Some(hex) => u64::from_str_radix(hex, 16),
+        None => text.parse(),
+    }
+    .map_err(|_| format!("'{}' is not an address; use hex (0x55d0c0a00000) or decimal", text))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn symbol(name: &str, address: u64, size: u64) -> Symbol {
+        Symbol { name: name.to_string(), address, size }
+    }
+
+    fn row(address: u64, line: u32) -> LineEntry {
+        LineEntry {
+            address,
+            file: "/src/app/main.go".to_string(),
+            line: Some(line),
+            column: None,
+            is_stmt: true,
+        }
+    }
+
+    #[test]
+    fn log_addresses_are_placed_in_the_binary() {
+        let binary = Symbolicator {
+            name: "prog".to_string(),
+            base: 0x400000,
+            code: vec![(0x401000, 0x402000)],
+            symbols: vec![
+                symbol("main.divide", 0x401000, 0x20),
+                symbol("main.main", 0x401020, 0x40),
+            ],
+            lines: vec![row(0x401000, 11), row(0x401010, 12), row(0x401020, 6), row(0x401038, 8)],
+            ..Symbolicator::default()
+        };
+
+        let panic = "panic: runtime error: integer divide by zero\n\
+                     \n\
+                     goroutine 1 [running]:\n\
+                     main.divide(...)\n\
+                     \t/build/main.go:12 +0x12\n\
+                     main.main()\n\
+                     \t/build/main.go:8 +0x1d\n\
+                     [signal SIGFPE: floating-point exception code=0x1 addr=0x0 pc=0x401012]";
+        let result = binary.symbolicate(panic, 0);
+        assert_eq!((result.found, result.resolved), (5, 3));
+        let frames: Vec<(&str, &str, u64, Option<u32>)> = result
+            .lines
+            .iter()
+            .flat_map(|line| &line.frames)
+            .map(|f| (f.text.as_str(), f.function.as_str(), f.offset, f.line))
+            .collect();
+        assert_eq!(
+            frames,
+            [
+                ("+0x12", "main.divide", 0x12, Some(12)),
+                // 0x40103d; Go's line for a caller is the call's, before it
+                ("+0x1d", "main.main", 0x1d, Some(8)),
+                ("0x401012", "main.divide", 0x12, Some(12)),
+            ]
+        );
+        assert_eq!(result.lines[0].number, 5);
+
+        let glibc = "./prog(main.main+0x4) [0x55d0c0a01024]\n\
+                     /opt/prog(+0x1010) [0x55d0c0a01010]\n\
+                     libc.so.6(__libc_start_main+0x80) [0x7f2a1c029e40]";
+        let result = binary.symbolicate(glibc, 0x55d0c0600000);
+        let frames: Vec<(&str, u64)> = result
+            .lines
+            .iter()
+            .flat_map(|line| &line.frames)
+            .map(|f| (f.text.as_str(), f.address))
+            .collect();
+        assert_eq!(
+            frames,
+            [
+                ("main.main+0x4", 0x401024),
+                ("0x55d0c0a01024", 0x401024),
+                ("+0x1010", 0x401010),
+                ("0x55d0c0a01010", 0x401010),
+            ]
+        );
+        assert_eq!((result.found, result.resolved), (5, 4));
+        let sanitizer = "#0 0x4011a6 in ?? (/prog+0x1030)\n401030";
+        assert_eq!(binary.symbolicate(sanitizer, 0).resolved, 2);
+
+        assert_eq!(go_function("main.(*Pool).run(0xc000010000, 0x1)"), Some("main.(*Pool).run"));
+        assert_eq!(go_function("created by main.main in goroutine 1"), Some("main.main"));
+        assert_eq!(go_function("goroutine 1 [running]:"), None);
+        assert_eq!(parse_address("0x1000"), Ok(4096));
+        assert!(parse_address("slide").is_err());
+    }
+}
@@ -7,6 +7,7 @@ use std::path::Path;
 use std::process::Stdio;
 
 use colored::Colorize;
+use serde::Serialize;
 use tokio::process::Command as TokioCommand;
 
 use crate::cli::spawn::ensure_daemon_running;
@@ -23,7 +24,7 @@ use super::config::{
 };
 
 /// Result of a test run
-#[derive(Debug)]
+#[derive(Debug, Serialize)]
 pub struct TestResult {
     pub name: String,
     pub passed: bool,
@@ -525,6 +526,8 @@ async fn execute_inspect_stack_step(
         .send_command(Command::StackTrace {
             thread_id: None,
             limit: 50,
+            start: 0,
+            more: false,
         })
         .await?;
 
@@ -594,6 +597,7 @@ async fn execute_check_output_step(
         .send_command(Command::GetOutput {
             tail: None,
             clear: false,
+            since_last_stop: false,
         })
         .await?;
 
@@ -644,6 +648,7 @@ async fn execute_evaluate_step(
             expression: expression.to_string(),
             frame_id: None,
             context: EvaluateContext::Watch,
+            limit: None,
         })
         .await;
 
@@ -855,10 +860,14 @@ fn parse_command(s: &str) -> Result<Command> {
         "backtrace" | "bt" => Ok(Command::StackTrace {
             thread_id: None,
             limit: 20,
+            start: 0,
+            more: false,
         }),
 
         "threads" => Ok(Command::Threads),
 
+        "modules" => Ok(Command::Modules),
+
         "thread" => {
             if args.is_empty() {
                 return Err(Error::Config("thread command requires an ID".to_string()));
@@ -898,6 +907,7 @@ fn parse_command(s: &str) -> Result<Command> {
                 } else {
                     EvaluateContext::Watch
                 },
+                limit: None,
             })
         }
 
@@ -933,7 +943,11 @@ fn parse_command(s: &str) -> Result<Command> {
                     }
                 }
             }
-            Ok(Command::GetOutput { tail, clear })
+            Ok(Command::GetOutput {
+                tail,
+                clear,
+                since_last_stop: false,
+            })
         }
 
         _ => Err(Error::Config(format!("Unknown command: {}", cmd))),
@@ -1134,7 +1148,8 @@ mod tests {
             parse_command("output -t 4 --clear").unwrap(),
             Command::GetOutput {
                 tail: Some(4),
-                clear: true
+                clear: true,
+                since_last_stop: false
             }
         ));
         assert!(parse_command("output --tail invalid").is_err());
@@ -0,0 +1,219 @@
+//! A build cache for the fixtures, keyed by what goes into a build
+//!
+//! A fixture's binary is decided by its sources, every other source file in
+//! a fixture directory such as `cgo/`, the compiler and its version, and the
+//! compiler's arguments and target architecture. Those are hashed into a
+//! key, and a build whose key is cached is linked or copied from the cache
+//! instead of compiled again, along with any split DWARF files beside it.
+//!
+//! The cache is `$DEBUGGER_FIXTURE_CACHE`, or `fixture-cache` in Cargo's
+//! target directory; `DEBUGGER_FIXTURE_CACHE=off` turns it off. `make
+//! fixtures` fills it with every fixture in every variant.
+
+use std::collections::HashMap;
+use std::env;
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::process::Command;
+use std::sync::Mutex;
+
+use super::{fixtures_dir, Build, Language};
+
+/// Extensions of the files that can change a fixture's binary
+const SOURCE_EXTENSIONS: &[&str] = &["c", "h", "cpp", "hpp", "rs", "go", "mod", "sum"];
+
+/// Environment variables read by the compilers themselves
+const COMPILER_VARIABLES: &[&str] = &["GOFLAGS", "CGO_CFLAGS", "CGO_LDFLAGS", "CC"];
+
+/// The cache directory, or `None` if the cache is off
+pub fn dir() -> Option<PathBuf> {
+    match env::var("DEBUGGER_FIXTURE_CACHE") {
+        Ok(value) if value == "off" || value == "0" => None,
+        Ok(value) if !value.trim().is_empty() => Some(PathBuf::from(value)),
+        _ => {
+            // The test binary is target/<profile>/deps/<name>
+            let exe = env::current_exe().ok()?;
+            Some(exe.parent()?.parent()?.parent()?.join("fixture-cache"))
+        }
+    }
+}
+
+/// The key of `build` compiled by `compiler`
+pub fn key(build: &Build, compiler: &str) -> String {
+    let mut hash = Fnv::new();
+    hash.write(compiler.as_bytes());
+    hash.write(version(build.language, compiler).as_bytes());
+    // Go cross-compiles with the same compiler and arguments
+    hash.write(build.arch.goarch().as_bytes());
+    // A package and loose .dwo files are built with the same arguments
+    hash.write(build.debug_info.suffix().unwrap_or_default().as_bytes());
+    // The output path differs from test to test, so it is left out
+    for arg in build.args(Path::new("")) {
+        hash.write(arg.as_bytes());
+    }
+    for variable in COMPILER_VARIABLES {
+        hash.write(env::var(variable).unwrap_or_default().as_bytes());
+    }
+    for file in inputs(build) {
+        hash.write(file.to_string_lossy().as_bytes());
+        hash.write(&fs::read(&file).unwrap_or_default());
+    }
+    format!("{:016x}", hash.finish())
+}
+
+/// Put the binary cached under `key` at `output`, and the files cached
+/// with it beside it; false if there is none
+pub fn fetch(cache: &Path, key: &str, output: &Path) -> bool {
+    let dir = cache.join(key);
+    let name = output.file_name().unwrap_or_default();
+    let (Some(output_dir), true) = (output.parent(), dir.join(name).is_file()) else {
+        return false;
+    };
+    let Ok(entries) = fs::read_dir(&dir) else {
+        return false;
+    };
+    // Files still being copied in start with a dot
+    let cached = entries
+        .filter_map(|entry| entry.ok())
+        .filter(|entry| !entry.file_name().to_string_lossy().starts_with('.'));
+    for entry in cached {
+        let target = output_dir.join(entry.file_name());
+        let _ = fs::remove_file(&target);
+        let linked = fs::hard_link(entry.path(), &target).is_ok()
+            || fs::copy(entry.path(), &target).is_ok();
+        if !linked {
+            return false;
+        }
+    }
+    true
+}
+
+/// Keep `files`, a binary and any files it reads beside it, under `key`.
+/// Tests build in parallel, so each is copied in under a name of its own
+/// and renamed into place, the binary last, as it marks the entry whole.
+pub fn store(cache: &Path, key: &str, files: &[PathBuf]) {
+    let dir = cache.join(key);
+    for file in files.iter().rev() {
+        let Some(name) = file.file_name() else {
+            continue;
+        };
+        let partial = dir.join(format!(".{}.{}", name.to_string_lossy(), std::process::id()));
+        let stored = fs::create_dir_all(&dir)
+            .and_then(|_| fs::copy(file, &partial))
+            .and_then(|_| fs::rename(&partial, dir.join(name)));
+        if let Err(e) = stored {
+            let _ = fs::remove_file(&partial);
+            eprintln!("Could not cache {}: {}", file.display(), e);
+            return;
+        }
+    }
+}
+
+/// The files a build reads: its sources and, for a fixture with a
+/// directory of its own, every source file there, sorted
+fn inputs(build: &Build) -> Vec<PathBuf> {
+    let mut files = build.sources.clone();
+    for source in &build.sources {
+        let Some(dir) = source.parent().filter(|dir| *dir != fixtures_dir()) else {
+            continue;
+        };
+        let Ok(entries) = fs::read_dir(dir) else {
+            continue;
+        };
+        files.extend(entries.filter_map(|entry| entry.ok()).map(|entry| entry.path()).filter(
+            |path| {
+                let extension = path.extension().and_then(|e| e.to_str()).unwrap_or_default();
+                SOURCE_EXTENSIONS.contains(&extension)
+            },
+        ));
+    }
+    files.sort();
+    files.dedup();
+    files
+}
+
+/// The compiler's version, as it prints it; asked once per compiler
+fn version(language: Language, compiler: &str) -> String {
+    static VERSIONS: Mutex<Option<HashMap<String, String>>> = Mutex::new(None);
+    let mut versions = VERSIONS.lock().unwrap_or_else(|poisoned| poisoned.into_inner());
+    let versions = versions.get_or_insert_with(HashMap::new);
+    if let Some(version) = versions.get(compiler) {
+        return version.clone();
+    }
+    let mut words = compiler.split_whitespace();
+    let mut command = Command::new(words.next().unwrap_or_default());
+    command.args(words);
+    match language {
+        Language::Go => command.arg("version"),
+        _ => command.arg("--version"),
+    };
+    let version = command
+        .output()
+        .map(|output| String::from_utf8_lossy(&output.stdout).into_owned())
+        .unwrap_or_default();
+    versions.insert(compiler.to_string(), version.clone());
+    version
+}
+
+/// 64-bit FNV-1a, which unlike std's hasher is the same in every release, so
+/// keys stay valid from one toolchain to the next
+struct Fnv(u64);
+
+impl Fnv {
+    fn new() -> Self {
+        Fnv(0xcbf2_9ce4_8422_2325)
+    }
+
+    /// Hash `bytes`, then a separator so `ab` + `c` differs from `a` + `bc`
+    fn write(&mut self, bytes: &[u8]) {
+        for &byte in bytes.iter().chain(&[0xff]) {
+            self.0 ^= u64::from(byte);
+            self.0 = self.0.wrapping_mul(0x0100_0000_01b3);
+        }
+    }
+
+    fn finish(&self) -> u64 {
+        self.0
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::fixturebuild::{Arch, DebugInfo, Optimization};
+
+    #[test]
+    fn keys_follow_the_inputs_and_cached_binaries_come_back() {
+        let simple = Build::c("simple");
+        assert_eq!(key(&simple, "cc"), key(&Build::c("simple"), "cc"));
+        assert_ne!(key(&simple, "cc"), key(&simple.clone().flag("-DX"), "cc"));
+        assert_ne!(key(&simple, "cc"), key(&simple.clone().optimization(Optimization::O2), "cc"));
+        assert_ne!(key(&simple, "cc"), key(&Build::c("threaded"), "cc"));
+        let go = Build::go("simple");
+        assert_ne!(key(&go, "go"), key(&go.clone().arch(Arch::Arm64), "go"));
+
+        let cgo = Build::of("cgo", Language::Go, &["cgo/main.go", "cgo/native.c"]);
+        let cgo_dir = fixtures_dir().join("cgo");
+        assert!(inputs(&cgo).contains(&cgo_dir.join("native.h")));
+        assert!(inputs(&cgo).contains(&cgo_dir.join("go.mod")));
+        assert_eq!(inputs(&simple), [fixtures_dir().join("simple.c")]);
+
+        let scratch = env::temp_dir().join(format!("fixture-cache-test-{}", std::process::id()));
+        let cache = scratch.join("cache");
+        fs::create_dir_all(&scratch).unwrap();
+        let built = scratch.join("simple");
+        fs::write(&built, "binary").unwrap();
+        let split = scratch.join("simple.dwo");
+        fs::write(&split, "split").unwrap();
+        let fetched = scratch.join("fetched").join("simple");
+        fs::create_dir_all(fetched.parent().unwrap()).unwrap();
+        assert!(!fetch(&cache, "0123", &fetched));
+        store(&cache, "0123", &[built.clone(), split]);
+        assert!(fetch(&cache, "0123", &fetched));
+        assert_eq!(fs::read_to_string(&fetched).unwrap(), "binary");
+        assert_eq!(fs::read_to_string(fetched.with_extension("dwo")).unwrap(), "split");
+        let dwo = Build::c("simple").debug_info(DebugInfo::Dwo);
+        assert_ne!(key(&dwo, "cc"), key(&dwo.clone().debug_info(DebugInfo::Package), "cc"));
+        let _ = fs::remove_dir_all(&scratch);
+    }
+}
@@ -0,0 +1,648 @@
+//! Compiling the C, C++, Rust and Go fixtures
+//!
+//! A [`Build`] is one fixture compiled one way. Debug info is always on;
+//! the optimization level and whether frame pointers are kept can be chosen,
+//! and [`Build::variants`] gives every combination, so a test can check that
+//! GDB and lldb still find breakpoints, frames and variables in optimized
+//! code as well as in debug builds. Go always keeps frame pointers, and its
+//! `-O0` is `-gcflags=all=-N -l`. [`Build::shared_library`] builds a library
+//! for a fixture to load at run time instead of a program.
+//!
+//! The compiler is `$CC` (C) or `$CXX` (C++) when set, and otherwise gcc or
+//! clang (g++ or clang++), whichever is found first, `$RUSTC` or rustc for
+//! Rust, and `$GO` or go for Go. `$DEBUGGER_FIXTURE_FLAGS` adds flags to every C and C++ build,
+//! and `$RUSTFLAGS` to every Rust one:
+//!
+//! ```bash
+//! CC=clang DEBUGGER_FIXTURE_FLAGS="-gdwarf-4" cargo test --test integration
+//! ```
+//!
+//! [`Build::debug_info`] splits a C or C++ build's DWARF 5 out of the
+//! binary, into a `.dwo` beside it (`-gsplit-dwarf`) or, packed with
+//! `$DWP`, `llvm-dwp` or `dwp`, into the binary's `.dwp`.
+//!
+//! [`Build::arch`] cross-compiles for another [`Arch`]: C and C++ with the
+//! cross toolchain's gcc (or `$CC_ARM64`, `$CXX_386` and so on), linked
+//! statically so qemu-user can run the result without a sysroot, and Go
+//! with `GOARCH` and cgo off. Rust fixtures are only built for the host.
+//!
+//! Binaries are kept in a [`cache`], so a fixture is only compiled again
+//! when its sources, compiler, flags or architecture change.
+
+#![allow(dead_code)]
+
+pub mod cache;
+
+use std::env;
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::process::Command;
+
+/// Where the fixture sources live
+pub fn fixtures_dir() -> PathBuf {
+    PathBuf::from(env!("CARGO_MANIFEST_DIR")).join("tests").join("fixtures")
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Language {
+    C,
+    Cpp,
+    Rust,
+    Go,
+}
+
+impl Language {
+    fn extension(self) -> &'static str {
+        match self {
+            Language::C => "c",
+            Language::Cpp => "cpp",
+            Language::Rust => "rs",
+            Language::Go => "go",
+        }
+    }
+
+    /// The variable that overrides the compiler, and the compilers to look
+    /// for without it
+    fn compilers(self) -> (&'static str, &'static [&'static str]) {
+        match self {
+            Language::C => ("CC", &["gcc", "clang"]),
+            Language::Cpp => ("CXX", &["g++", "clang++"]),
+            Language::Rust => ("RUSTC", &["rustc"]),
+            Language::Go => ("GO", &["go"]),
+        }
+    }
+}
+
+/// The architecture a fixture is built for
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Arch {
+    Native,
+    Arm64,
+    I386,
+    Riscv64,
+}
+
+impl Arch {
+    /// Every architecture other than the host's
+    pub const CROSS: [Arch; 3] = [Arch::Arm64, Arch::I386, Arch::Riscv64];
+
+    /// Go's name for it, which is also the suffix of a cross build's binary
+    pub fn goarch(self) -> &'static str {
+        match self {
+            Arch::Native => env::consts::ARCH,
+            Arch::Arm64 => "arm64",
+            Arch::I386 => "386",
+            Arch::Riscv64 => "riscv64",
+        }
+    }
+
+    /// The GNU triple its cross toolchains are prefixed with
+    pub fn triple(self) -> &'static str {
+        match self {
+            Arch::Native => "",
+            Arch::Arm64 => "aarch64-linux-gnu",
+            Arch::I386 => "i686-linux-gnu",
+            Arch::Riscv64 => "riscv64-linux-gnu",
+        }
+    }
+
+    /// The qemu-user emulator that runs its binaries, if installed; `None`
+    /// for the host, whose binaries run as they are
+    pub fn qemu(self) -> Option<String> {
+        let name = match self {
+            Arch::Native => return None,
+            Arch::Arm64 => "qemu-aarch64",
+            Arch::I386 => "qemu-i386",
+            Arch::Riscv64 => "qemu-riscv64",
+        };
+        [name.to_string(), format!("{}-static", name)]
+            .into_iter()
+            .find(|qemu| Command::new(qemu).arg("--version").output().is_ok())
+    }
+
+    /// The variable that overrides a language's cross compiler, and the
+    /// compilers to look for without it
+    fn cross_compilers(self, language: Language) -> (String, Vec<String>) {
+        let suffix = self.goarch().to_ascii_uppercase();
+        match language {
+            Language::Cpp => (format!("CXX_{}", suffix), vec![format!("{}-g++", self.triple())]),
+            _ => (format!("CC_{}", suffix), vec![format!("{}-gcc", self.triple())]),
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Optimization {
+    O0,
+    O2,
+}
+
+/// Where a C or C++ build's debug info is kept
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum DebugInfo {
+    /// In the binary, as the compiler emits it by default
+    Binary,
+    /// DWARF 5 split into `.dwo` files beside the binary
+    Dwo,
+    /// DWARF 5 split, then packed into the binary's `.dwp`
+    Package,
+}
+
+impl DebugInfo {
+    /// The suffix of a build's binary, as in `simple-dwp`
+    fn suffix(self) -> Option<&'static str> {
+        match self {
+            DebugInfo::Binary => None,
+            DebugInfo::Dwo => Some("dwo"),
+            DebugInfo::Package => Some("dwp"),
+        }
+    }
+}
+
+/// A fixture and how to compile it
+#[derive(Debug, Clone)]
+pub struct Build {
+    name: String,
+    language: Language,
+    sources: Vec<PathBuf>,
+    optimization: Optimization,
+    frame_pointers: bool,
+    shared: bool,
+    arch: Arch,
+    debug_info: DebugInfo,
+    flags: Vec<String>,
+}
+
+impl Build {
+    /// `tests/fixtures/NAME.c`, at `-O0` with frame pointers
+    pub fn c(name: &str) -> Self {
+        Self::of(name, Language::C, &[&format!("{}.c", name)])
+    }
+
+    /// `tests/fixtures/NAME.cpp`, at `-O0` with frame pointers
+    pub fn cpp(name: &str) -> Self {
+        Self::of(name, Language::Cpp, &[&format!("{}.cpp", name)])
+    }
+
+    /// `tests/fixtures/NAME.rs`, at `opt-level=0` with frame pointers
+    pub fn rust(name: &str) -> Self {
+        Self::of(name, Language::Rust, &[&format!("{}.rs", name)])
+    }
+
+    /// `tests/fixtures/NAME.go`, without optimizations or inlining
+    pub fn go(name: &str) -> Self {
+        Self::of(name, Language::Go, &[&format!("{}.go", name)])
+    }
+
+    /// A fixture of several files, relative to `tests/fixtures`, such as
+    /// `multi_source/main.c` and `multi_source/utils.c`
+    pub fn of(name: &str, language: Language, files: &[&str]) -> Self {
+        Self {
+            name: name.to_string(),
+            language,
+            sources: files.iter().map(|file| fixtures_dir().join(file)).collect(),
+            optimization: Optimization::O0,
+            frame_pointers: true,
+            shared: false,
+            arch: Arch::Native,
+            debug_info: DebugInfo::Binary,
+            flags: Vec::new(),
+        }
+    }
+
+    pub fn optimization(mut self, optimization: Optimization) -> Self {
+        self.optimization = optimization;
+        self
+    }
+
+    pub fn frame_pointers(mut self, keep: bool) -> Self {
+        self.frame_pointers = keep;
+        self
+    }
+
+    /// Build a shared library instead of a program: `-shared -fPIC` for C
+    /// and C++, a `cdylib` for Rust and `-buildmode=plugin` for Go
+    pub fn shared_library(mut self) -> Self {
+        self.shared = true;
+        self
+    }
+
+    /// Cross-compile for `arch`
+    pub fn arch(mut self, arch: Arch) -> Self {
+        self.arch = arch;
+        self
+    }
+
+    /// Keep a C or C++ build's debug info out of the binary
+    pub fn debug_info(mut self, debug_info: DebugInfo) -> Self {
+        self.debug_info = debug_info;
+        self
+    }
+
+    /// Pass another flag to the compiler
+    pub fn flag(mut self, flag: &str) -> Self {
+        self.flags.push(flag.to_string());
+        self
+    }
+
+    /// The fixture at `-O0` and `-O2`, each with and without frame pointers
+    /// (only with them for Go)
+    pub fn variants(&self) -> Vec<Build> {
+        let frame_pointers: &[bool] = match self.language {
+            Language::Go => &[true],
+            _ => &[true, false],
+        };
+        let mut builds = Vec::new();
+        for optimization in [Optimization::O0, Optimization::O2] {
+            for &frame_pointers in frame_pointers {
+                builds.push(
+                    self.clone()
+                        .optimization(optimization)
+                        .frame_pointers(frame_pointers),
+                );
+            }
+        }
+        builds
+    }
+
+    pub fn name(&self) -> &str {
+        &self.name
+    }
+
+    pub fn language(&self) -> Language {
+        self.language
+    }
+
+    pub fn target(&self) -> Arch {
+        self.arch
+    }
+
+    pub fn is_optimized(&self) -> bool {
+        self.optimization != Optimization::O0
+    }
+
+    /// The first source file, where a fixture's `main` is
+    pub fn main_source(&self) -> &Path {
+        &self.sources[0]
+    }
+
+    /// How it is compiled, as in `O2-nofp`
+    pub fn variant(&self) -> String {
+        let level = match self.optimization {
+            Optimization::O0 => "O0",
+            Optimization::O2 => "O2",
+        };
+        if self.frame_pointers {
+            level.to_string()
+        } else {
+            format!("{}-nofp", level)
+        }
+    }
+
+    /// The binary's file name: the fixture's, `_cpp` for C++, `_rs` for Rust
+    /// and `_go` for Go so the `simple`s can share a directory, then the
+    /// variant unless it is the default `-O0` with frame pointers, as in
+    /// `simple_cpp-O2-nofp`, then the architecture of a cross build, as in
+    /// `simple-O2-arm64`, then where split debug info went, as in
+    /// `simple-O2-dwo`. A shared library is `libNAME.so`, or `NAME.so`
+    /// for a Go plugin, as in `libplugin-O2.so` and `plugin_go.so`
+    pub fn output_name(&self) -> String {
+        let stem = match self.language {
+            Language::C => self.name.clone(),
+            Language::Cpp => format!("{}_cpp", self.name),
+            Language::Rust => format!("{}_rs", self.name),
+            Language::Go => format!("{}_go", self.name),
+        };
+        let mut name = if self.optimization == Optimization::O0 && self.frame_pointers {
+            stem
+        } else {
+            format!("{}-{}", stem, self.variant())
+        };
+        if self.arch != Arch::Native {
+            name = format!("{}-{}", name, self.arch.goarch());
+        }
+        if let Some(suffix) = self.debug_info.suffix() {
+            name = format!("{}-{}", name, suffix);
+        }
+        match (self.shared, self.language) {
+            (false, _) => name,
+            (true, Language::Go) => format!("{}.so", name),
+            (true, _) => format!("lib{}.so", name),
+        }
+    }
+
+    /// The compiler's arguments to write the binary to `output`
+    pub fn args(&self, output: &Path) -> Vec<String> {
+        match self.language {
+            Language::Rust => return self.rustc_args(output),
+            Language::Go => return self.go_args(output),
+            Language::C | Language::Cpp => {}
+        }
+        let mut args = vec![
+            "-g".to_string(),
+            match self.optimization {
+                Optimization::O0 => "-O0",
+                Optimization::O2 => "-O2",
+            }
+            .to_string(),
+            if self.frame_pointers {
+                "-fno-omit-frame-pointer"
+            } else {
+                "-fomit-frame-pointer"
+            }
+            .to_string(),
+            "-pthread".to_string(),
+        ];
+        if self.debug_info != DebugInfo::Binary {
+            args.extend(["-gdwarf-5".to_string(), "-gsplit-dwarf".to_string()]);
+        }
+        if self.shared {
+            args.extend(["-shared".to_string(), "-fPIC".to_string()]);
+        } else if self.arch != Arch::Native {
+            args.push("-static".to_string());
+        }
+        if let Ok(extra) = env::var("DEBUGGER_FIXTURE_FLAGS") {
+            args.extend(extra.split_whitespace().map(String::from));
+        }
+        args.extend(self.flags.iter().cloned());
+        args.push("-o".to_string());
+        args.push(output.to_string_lossy().into_owned());
+        args.extend(self.sources.iter().map(|source| source.to_string_lossy().into_owned()));
+        args
+    }
+
+    /// rustc's arguments; the one source is the crate root
+    fn rustc_args(&self, output: &Path) -> Vec<String> {
+        let mut args: Vec<String> = vec![
+            "-g".to_string(),
+            "-C".to_string(),
+            match self.optimization {
+                Optimization::O0 => "opt-level=0",
+                Optimization::O2 => "opt-level=2",
+            }
+            .to_string(),
+            "-C".to_string(),
+            if self.frame_pointers {
+                "force-frame-pointers=yes"
+            } else {
+                "force-frame-pointers=no"
+            }
+            .to_string(),
+            "--edition".to_string(),
+            "2021".to_string(),
+        ];
+        if self.shared {
+            args.extend(["--crate-type".to_string(), "cdylib".to_string()]);
+        }
+        if let Ok(extra) = env::var("RUSTFLAGS") {
+            args.extend(extra.split_whitespace().map(String::from));
+        }
+        args.extend(self.flags.iter().cloned());
+        args.push("-o".to_string());
+        args.push(output.to_string_lossy().into_owned());
+        args.push(self.sources[0].to_string_lossy().into_owned());
+        args
+    }
+
+    /// `go build`'s arguments; `$GOFLAGS` is read by go itself. A fixture of
+    /// several files is a module of its own, such as `cgo/` with its C
+    /// files, and is built as the package in [`Build::package_dir`]
+    fn go_args(&self, output: &Path) -> Vec<String> {
+        let mut args = vec!["build".to_string()];
+        if self.shared {
+            args.push("-buildmode=plugin".to_string());
+        }
+        if self.optimization == Optimization::O0 {
+            args.push("-gcflags=all=-N -l".to_string());
+        }
+        args.extend(self.flags.iter().cloned());
+        args.push("-o".to_string());
+        args.push(output.to_string_lossy().into_owned());
+        match self.package_dir() {
+            Some(_) => args.push(".".to_string()),
+            None => args.push(self.sources[0].to_string_lossy().into_owned()),
+        }
+        args
+    }
+
+    /// Where a Go fixture of several files is built from: the directory of
+    /// its `go.mod`, as go only builds a module's packages from inside it
+    pub fn package_dir(&self) -> Option<&Path> {
+        if self.language != Language::Go || self.sources.len() < 2 {
+            return None;
+        }
+        self.sources[0].parent()
+    }
+
+    /// The compiler for this build, if there is one: the language's, or
+    /// for a cross build of C or C++ the cross toolchain's
+    pub fn compiler(&self) -> Option<String> {
+        match (self.arch, self.language) {
+            (Arch::Native, language) | (_, language @ Language::Go) => compiler(language),
+            (_, Language::Rust) => None,
+            (arch, language) => {
+                let (variable, compilers) = arch.cross_compilers(language);
+                find_compiler(&variable, compilers.iter().map(String::as_str))
+            }
+        }
+    }
+
+    /// The `.dwo` or `.dwp` files beside a split build's binary at `output`:
+    /// `simple-dwo.dwo`, or one `.dwo` per source as `main-utils.dwo`
+    pub fn split_files(&self, output: &Path) -> Vec<PathBuf> {
+        let (Some(dir), Some(name)) = (output.parent(), output.file_name()) else {
+            return Vec::new();
+        };
+        let name = name.to_string_lossy();
+        let Ok(entries) = fs::read_dir(dir) else {
+            return Vec::new();
+        };
+        let mut files: Vec<PathBuf> = entries
+            .filter_map(|entry| entry.ok())
+            .map(|entry| entry.path())
+            .filter(|path| {
+                let file = path.file_name().unwrap_or_default().to_string_lossy();
+                let extension = path.extension().and_then(|e| e.to_str()).unwrap_or_default();
+                let ours = file.strip_prefix(&*name).is_some_and(|rest| {
+                    rest.starts_with('.') || rest.starts_with('-')
+                });
+                ours && matches!(extension, "dwo" | "dwp")
+            })
+            .collect();
+        files.sort();
+        files
+    }
+
+    /// Compile into `dir`, or take the binary from the cache, and return
+    /// the binary's path
+    pub fn compile(&self, dir: &Path) -> PathBuf {
+        let compiler = self.compiler().unwrap_or_else(|| {
+            let (variable, found) = match self.arch {
+                Arch::Native => {
+                    let (variable, found) = self.language.compilers();
+                    (variable.to_string(), found.iter().map(|c| c.to_string()).collect())
+                }
+                arch => arch.cross_compilers(self.language),
+            };
+            panic!(
+                "No {} compiler for {} found (tried ${}, {})",
+                self.language.extension(),
+                self.arch.goarch(),
+                variable,
+                found.join(", ")
+            )
+        });
+        let output = dir.join(self.output_name());
+        let cached = cache::dir().map(|cache| (cache::key(self, &compiler), cache));
+        if let Some((key, cache)) = &cached {
+            if cache::fetch(cache, key, &output) {
+                return output;
+            }
+        }
+        // A binary fetched before may be a link into the cache
+        let _ = fs::remove_file(&output);
+        for file in self.split_files(&output) {
+            let _ = fs::remove_file(file);
+        }
+
+        let mut words = compiler.split_whitespace();
+        let mut command = Command::new(words.next().unwrap());
+        if let Some(package) = self.package_dir() {
+            command.current_dir(package);
+        }
+        if self.language == Language::Go && self.arch != Arch::Native {
+            command.env("GOARCH", self.arch.goarch()).env("CGO_ENABLED", "0");
+        }
+        let status = command
+            .args(words)
+            .args(self.args(&output))
+            .status()
+            .unwrap_or_else(|e| panic!("Failed to run {}: {}", compiler, e));
+        assert!(
+            status.success(),
+            "Compiling the {} fixture {} ({}) failed",
+            self.language.extension(),
+            self.name,
+            self.variant()
+        );
+        if self.debug_info == DebugInfo::Package {
+            self.pack(&output);
+        }
+        if let Some((key, cache)) = &cached {
+            let mut files = vec![output.clone()];
+            files.extend(self.split_files(&output));
+            cache::store(cache, key, &files);
+        }
+        output
+    }
+
+    /// Pack a split build's `.dwo` files into the binary's `.dwp`, and
+    /// remove them so only the package is left to read
+    fn pack(&self, output: &Path) {
+        let packer = dwarf_packer().expect("No DWARF packer found (tried $DWP, llvm-dwp, dwp)");
+        let mut package = output.as_os_str().to_owned();
+        package.push(".dwp");
+        let status = Command::new(&packer)
+            .arg("-e")
+            .arg(output)
+            .arg("-o")
+            .arg(&package)
+            .status()
+            .unwrap_or_else(|e| panic!("Failed to run {}: {}", packer, e));
+        assert!(status.success(), "Packing the split DWARF of {} failed", self.name);
+        for file in self.split_files(output) {
+            if file.extension().is_some_and(|extension| extension == "dwo") {
+                let _ = fs::remove_file(file);
+            }
+        }
+    }
+}
+
+/// The compiler for a language, if there is one
+pub fn compiler(language: Language) -> Option<String> {
+    let (variable, compilers) = language.compilers();
+    find_compiler(variable, compilers.iter().copied())
+}
+
+/// The tool that packs `.dwo` files into a `.dwp`, if there is one
+pub fn dwarf_packer() -> Option<String> {
+    find_compiler("DWP", ["llvm-dwp", "dwp"].into_iter())
+}
+
+/// The tool that splits debug info from a binary and strips it, if there
+/// is one
+pub fn objcopy() -> Option<String> {
+    find_compiler("OBJCOPY", ["objcopy", "llvm-objcopy"].into_iter())
+}
+
+/// `$variable` if set, or else the first of `compilers` that runs
+fn find_compiler<'a>(
+    variable: &str,
+    mut compilers: impl Iterator<Item = &'a str>,
+) -> Option<String> {
+    if let Some(compiler) = env::var(variable).ok().filter(|value| !value.trim().is_empty()) {
+        return Some(compiler);
+    }
+    compilers
+        .find(|compiler| Command::new(compiler).arg("--version").output().is_ok())
+        .map(String::from)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn variants_name_their_binaries_and_flags() {
+        let build = Build::cpp("simple");
+        let names: Vec<String> = build.variants().iter().map(Build::output_name).collect();
+        assert_eq!(
+            names,
+            ["simple_cpp", "simple_cpp-O0-nofp", "simple_cpp-O2", "simple_cpp-O2-nofp"]
+        );
+        assert_eq!(Build::c("simple").output_name(), "simple");
+
+        let optimized = build.optimization(Optimization::O2).frame_pointers(false).flag("-DX");
+        let args = optimized.args(Path::new("/tmp/out"));
+        assert_eq!(&args[..4], ["-g", "-O2", "-fomit-frame-pointer", "-pthread"]);
+        assert!(args.ends_with(&[
+            "-DX".to_string(),
+            "-o".to_string(),
+            "/tmp/out".to_string(),
+            fixtures_dir().join("simple.cpp").to_string_lossy().into_owned(),
+        ]));
+
+        let rust = Build::rust("values").optimization(Optimization::O2);
+        assert_eq!(rust.output_name(), "values_rs-O2");
+        let args = rust.args(Path::new("/tmp/out"));
+        assert_eq!(&args[..5], ["-g", "-C", "opt-level=2", "-C", "force-frame-pointers=yes"]);
+        assert!(!args.contains(&"-pthread".to_string()));
+
+        let go = Build::go("crash");
+        let names: Vec<String> = go.variants().iter().map(Build::output_name).collect();
+        assert_eq!(names, ["crash_go", "crash_go-O2"]);
+        assert_eq!(go.args(Path::new("/tmp/out"))[..2], ["build", "-gcflags=all=-N -l"]);
+        let cgo = Build::of("cgo", Language::Go, &["cgo/main.go", "cgo/native.c"]);
+        assert_eq!(cgo.package_dir(), Some(fixtures_dir().join("cgo").as_path()));
+        assert_eq!(cgo.args(Path::new("/tmp/out")).last().unwrap(), ".");
+
+        let library = Build::of("plugin", Language::C, &["dlopen/plugin.c"]).shared_library();
+        let names: Vec<String> = library.variants().iter().map(Build::output_name).collect();
+        assert_eq!(names[..2], ["libplugin.so", "libplugin-O0-nofp.so"]);
+        assert_eq!(library.args(Path::new("/tmp/out"))[4..6], ["-shared", "-fPIC"]);
+        let plugin = Build::of("plugin", Language::Go, &["dlopen/plugin.go"]).shared_library();
+        assert_eq!(plugin.output_name(), "plugin_go.so");
+        assert_eq!(plugin.args(Path::new("/tmp/out"))[..2], ["build", "-buildmode=plugin"]);
+
+        let cross = Build::c("simple").optimization(Optimization::O2).arch(Arch::Arm64);
+        assert_eq!(cross.output_name(), "simple-O2-arm64");
+        assert!(cross.args(Path::new("/tmp/out")).contains(&"-static".to_string()));
+        assert_eq!(Build::go("simple").arch(Arch::I386).output_name(), "simple_go-386");
+        assert_eq!(Build::rust("values").arch(Arch::Riscv64).compiler(), None);
+
+        let split = Build::c("simple").optimization(Optimization::O2).debug_info(DebugInfo::Dwo);
+        assert_eq!(split.output_name(), "simple-O2-dwo");
+        assert_eq!(split.args(Path::new("/tmp/out"))[4..6], ["-gdwarf-5", "-gsplit-dwarf"]);
+        assert_eq!(Build::c("simple").debug_info(DebugInfo::Package).output_name(), "simple-dwp");
+    }
+}
@@ -0,0 +1,46 @@
+// Mixed Go and C test program for backtraces across cgo calls
+//
+// main.walk calls into C, which calls back into Go, which calls C again, so
+// a stop in native_leaf has Go and C frames interleaved on one stack:
+//
+//	native_leaf       C
+//	main.goVisit      Go, entered from C through crosscall2 and cgocallback
+//	native_walk       C
+//	runtime.cgocall   Go runtime, switching to the system stack
+//	main.walk         Go
+//	main.main         Go
+package main
+
+/*
+#include "native.h"
+*/
+import "C"
+
+import "fmt"
+
+// Neither Go function is inlined at -O2, so their frames stay on the stack
+
+//export goVisit
+//go:noinline
+func goVisit(depth C.int) C.int {
+	// BREAKPOINT_MARKER: go_callback
+	leaf := C.native_leaf(depth)
+	return leaf + 1
+}
+
+//go:noinline
+func walk(depth int) int {
+	// BREAKPOINT_MARKER: before_cgo_call
+	result := C.native_walk(C.int(depth))
+	// BREAKPOINT_MARKER: after_cgo_call
+	return int(result)
+}
+
+func main() {
+	// BREAKPOINT_MARKER: main_start
+	depth := 3
+	fmt.Printf("Walking from Go into C at depth %d\n", depth)
+	result := walk(depth)
+	fmt.Printf("Result: %d\n", result)
+	// BREAKPOINT_MARKER: before_exit
+}
@@ -0,0 +1,19 @@
+// The C half of the cgo fixture
+#include <stdio.h>
+
+#include "native.h"
+#include "_cgo_export.h"
+
+int native_walk(int depth) {
+    // BREAKPOINT_MARKER: c_walk
+    int visited = goVisit(depth);
+    printf("C saw %d from Go\n", visited);
+    fflush(stdout);
+    return visited * 2;
+}
+
+int native_leaf(int depth) {
+    // BREAKPOINT_MARKER: c_leaf
+    int value = depth * 10;
+    return value;
+}
@@ -0,0 +1,10 @@
+#ifndef NATIVE_H
+#define NATIVE_H
+
+// Calls back into Go's goVisit
+int native_walk(int depth);
+
+// The innermost frame, called from Go's goVisit
+int native_leaf(int depth);
+
+#endif
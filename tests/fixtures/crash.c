@@ -0,0 +1,63 @@
+// Crashing test program for signal and crash report tests
+//
+// Usage: crash segfault|divide|abort
+//
+// Each mode stops the program with a different signal from a function of its
+// own, with a marker on the statement before the fault:
+//   segfault - SIGSEGV writing through a NULL struct pointer (address 0x8)
+//   divide   - SIGFPE from an integer division by zero
+//   abort    - SIGABRT from abort() when a check fails
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+struct record {
+    long id;
+    int count;
+};
+
+// Read at run time, so that -O2 builds still fault where the source says
+struct record *volatile missing = NULL;
+volatile int divisor = 0;
+volatile int expected_total = 3;
+
+void write_through(struct record *record) {
+    // BREAKPOINT_MARKER: before_segfault
+    record->count = 1;
+}
+
+int divide(int total) {
+    // BREAKPOINT_MARKER: before_divide
+    int ratio = total / divisor;
+    return ratio;
+}
+
+void check_invariant(int total) {
+    if (total != expected_total) {
+        fprintf(stderr, "invariant failed: total %d != %d\n", total, expected_total);
+        // BREAKPOINT_MARKER: before_abort
+        abort();
+    }
+}
+
+int main(int argc, char *argv[]) {
+    // BREAKPOINT_MARKER: main_start
+    const char *mode = argc > 1 ? argv[1] : "segfault";
+    printf("Crashing with %s\n", mode);
+    fflush(stdout);
+
+    if (strcmp(mode, "segfault") == 0) {
+        write_through(missing);
+    } else if (strcmp(mode, "divide") == 0) {
+        printf("Ratio: %d\n", divide(10));
+    } else if (strcmp(mode, "abort") == 0) {
+        check_invariant(4);
+    } else {
+        fprintf(stderr, "unknown mode: %s\n", mode);
+        return 2;
+    }
+
+    // BREAKPOINT_MARKER: unreachable
+    printf("Did not crash\n");
+    return 1;
+}
@@ -0,0 +1,68 @@
+// Panicking Go test program for panic and crash report tests
+//
+// Usage: crash [index|nil]
+//
+//	index - an index out of range panic three calls deep, with deferred
+//	        calls in every frame that run, innermost first, as it unwinds
+//	nil   - a nil pointer dereference, a SIGSEGV that Go turns into a
+//	        panic with the deferred calls still run
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type record struct {
+	id    int64
+	count int
+}
+
+func cleanup(name string) {
+	fmt.Printf("cleanup %s\n", name)
+}
+
+func parse(items []string, index int) string {
+	defer cleanup("parse")
+	// BREAKPOINT_MARKER: before_panic
+	return items[index]
+}
+
+func process(items []string) string {
+	defer cleanup("process")
+	defer func() {
+		// Deferred in a frame the panic unwinds through, and does not recover
+		fmt.Println("deferred closure in process")
+	}()
+	return parse(items, len(items)+1)
+}
+
+func update(r *record) {
+	defer cleanup("update")
+	// BREAKPOINT_MARKER: before_nil
+	r.count = 1
+}
+
+func main() {
+	// BREAKPOINT_MARKER: main_start
+	mode := "index"
+	if len(os.Args) > 1 {
+		mode = os.Args[1]
+	}
+	fmt.Printf("Panicking with %s\n", mode)
+	defer cleanup("main")
+
+	switch mode {
+	case "index":
+		fmt.Println(process([]string{"a", "b"}))
+	case "nil":
+		var r *record
+		update(r)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown mode: %s\n", mode)
+		os.Exit(3)
+	}
+
+	// BREAKPOINT_MARKER: unreachable
+	fmt.Println("Did not panic")
+}
@@ -0,0 +1,45 @@
+// Test program that loads a shared library part way through its run
+//
+// The library's path is the first argument, or libplugin.so next to the
+// program. Nothing of the library is known until dlopen, so breakpoints in
+// it are pending until then.
+#include <dlfcn.h>
+#include <limits.h>
+#include <stdio.h>
+#include <string.h>
+
+// Read at run time, so that -O2 builds can't compute the result up front
+volatile int input = 7;
+
+int main(int argc, char *argv[]) {
+    // BREAKPOINT_MARKER: main_start
+    char path[PATH_MAX] = "libplugin.so";
+    if (argc > 1) {
+        snprintf(path, sizeof path, "%s", argv[1]);
+    } else if (strrchr(argv[0], '/')) {
+        int dir = (int)(strrchr(argv[0], '/') - argv[0]);
+        snprintf(path, sizeof path, "%.*s/libplugin.so", dir, argv[0]);
+    }
+    printf("Loading %s\n", path);
+    fflush(stdout);
+
+    // BREAKPOINT_MARKER: before_load
+    void *library = dlopen(path, RTLD_NOW);
+    if (!library) {
+        fprintf(stderr, "dlopen failed: %s\n", dlerror());
+        return 1;
+    }
+
+    // BREAKPOINT_MARKER: after_load
+    int (*compute)(int) = (int (*)(int))dlsym(library, "plugin_compute");
+    if (!compute) {
+        fprintf(stderr, "dlsym failed: %s\n", dlerror());
+        return 1;
+    }
+    int result = compute(input);
+    printf("Plugin computed %d\n", result);
+
+    dlclose(library);
+    // BREAKPOINT_MARKER: before_exit
+    return 0;
+}
@@ -0,0 +1,40 @@
+// Test program that opens a Go plugin part way through its run
+//
+// The plugin's path is the first argument, or plugin_go.so next to the
+// program. The plugin's functions are unknown until plugin.Open, so
+// breakpoints in them are pending until then.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"plugin"
+)
+
+func main() {
+	// BREAKPOINT_MARKER: main_start
+	path := filepath.Join(filepath.Dir(os.Args[0]), "plugin_go.so")
+	if len(os.Args) > 1 {
+		path = os.Args[1]
+	}
+	fmt.Printf("Loading %s\n", path)
+
+	// BREAKPOINT_MARKER: before_load
+	opened, err := plugin.Open(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "plugin.Open failed:", err)
+		os.Exit(1)
+	}
+
+	// BREAKPOINT_MARKER: after_load
+	symbol, err := opened.Lookup("Compute")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Lookup failed:", err)
+		os.Exit(1)
+	}
+	compute := symbol.(func(int) int)
+	result := compute(7)
+	fmt.Printf("Plugin computed %d\n", result)
+	// BREAKPOINT_MARKER: before_exit
+}
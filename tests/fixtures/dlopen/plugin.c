@@ -0,0 +1,23 @@
+// The shared library the dlopen fixture loads
+#include <stdio.h>
+
+static int calls;
+
+// Runs inside dlopen, before it returns
+__attribute__((constructor)) static void plugin_init(void) {
+    // BREAKPOINT_MARKER: plugin_init
+    printf("Plugin loaded\n");
+    fflush(stdout);
+}
+
+__attribute__((noinline)) int plugin_scale(int value) {
+    // BREAKPOINT_MARKER: plugin_scale
+    int scaled = value * 3;
+    return scaled;
+}
+
+int plugin_compute(int input) {
+    // BREAKPOINT_MARKER: plugin_compute
+    calls++;
+    return plugin_scale(input) + calls;
+}
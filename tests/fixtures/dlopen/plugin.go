@@ -0,0 +1,26 @@
+// The plugin the Go dlopen fixture opens
+package main
+
+import "fmt"
+
+var calls int
+
+// Runs inside plugin.Open, before it returns
+func init() {
+	// BREAKPOINT_MARKER: plugin_init
+	fmt.Println("Plugin loaded")
+}
+
+//go:noinline
+func scale(value int) int {
+	// BREAKPOINT_MARKER: plugin_scale
+	scaled := value * 3
+	return scaled
+}
+
+// Compute is what the host looks up
+func Compute(input int) int {
+	// BREAKPOINT_MARKER: plugin_compute
+	calls++
+	return scale(input) + calls
+}
@@ -0,0 +1,34 @@
+// Rust test program that panics, for stop-on-panic and backtrace tests
+//
+// The panic happens two calls below main, so a backtrace from the panic
+// shows `load_config` and `main` under the panic machinery.
+
+struct Config {
+    retries: u32,
+}
+
+fn parse_retries(text: &str) -> u32 {
+    // BREAKPOINT_MARKER: parse_retries
+    match text.parse() {
+        Ok(retries) => retries,
+        // BREAKPOINT_MARKER: before_panic
+        Err(e) => panic!("bad retries value {:?}: {}", text, e),
+    }
+}
+
+fn load_config(retries: &str) -> Config {
+    // BREAKPOINT_MARKER: load_config
+    Config {
+        retries: parse_retries(retries),
+    }
+}
+
+fn main() {
+    // BREAKPOINT_MARKER: main_start
+    let good = load_config("3");
+    println!("Retries: {}", good.retries);
+
+    // BREAKPOINT_MARKER: before_bad_config
+    let bad = load_config("three");
+    println!("Unreachable: {}", bad.retries);
+}
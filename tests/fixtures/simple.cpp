@@ -0,0 +1,76 @@
+// Simple C++ test program for debugger integration tests
+#include <cstdio>
+#include <string>
+#include <utility>
+#include <vector>
+
+// Read at run time, so that -O2 builds can't compute everything up front
+volatile int first = 10;
+volatile int second = 20;
+volatile int depth = 5;
+
+int add(int a, int b) {
+    // BREAKPOINT_MARKER: add_body
+    int result = a + b;
+    return result;
+}
+
+int factorial(int n) {
+    // BREAKPOINT_MARKER: factorial_body
+    if (n <= 1) {
+        return 1;
+    }
+    return n * factorial(n - 1);
+}
+
+namespace stats {
+
+class Counter {
+public:
+    explicit Counter(std::string name) : name_(std::move(name)) {}
+
+    void record(int value) {
+        // BREAKPOINT_MARKER: method_body
+        values_.push_back(value);
+    }
+
+    template <typename T>
+    T total() const {
+        T sum = 0;
+        for (int value : values_) {
+            sum += value;
+        }
+        return sum;
+    }
+
+    const std::string &name() const { return name_; }
+
+private:
+    std::string name_;
+    std::vector<int> values_;
+};
+
+}  // namespace stats
+
+int main(int argc, char *argv[]) {
+    // BREAKPOINT_MARKER: main_start
+    int x = first;
+    int y = second;
+
+    // BREAKPOINT_MARKER: before_add
+    int sum = add(x, y);
+    std::printf("Sum: %d\n", sum);
+
+    // BREAKPOINT_MARKER: before_factorial
+    int fact = factorial(depth);
+    std::printf("Factorial: %d\n", fact);
+
+    stats::Counter counter("results");
+    counter.record(sum);
+    counter.record(fact);
+    // BREAKPOINT_MARKER: after_record
+    std::printf("Total of %s: %ld\n", counter.name().c_str(), counter.total<long>());
+
+    // BREAKPOINT_MARKER: before_exit
+    return 0;
+}
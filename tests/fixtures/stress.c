@@ -0,0 +1,86 @@
+// Stress test program for the debugger's unwinding and variable reads
+//
+// Each mode, given as the first argument, builds one thing that is costly
+// to look at: a stack 10,000 calls deep (or as deep as the second argument
+// says), a function whose frame holds a megabyte, or tens of megabytes of
+// global arrays.
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#define FRAME_BYTES (1024 * 1024)
+#define TABLE_ENTRIES (8 * 1024 * 1024)
+#define RECORD_COUNT (256 * 1024)
+
+struct record {
+    int id;
+    double weight;
+    char label[16];
+};
+
+// 32 MiB and 8 MiB of globals, filled in at run time
+int big_table[TABLE_ENTRIES];
+struct record records[RECORD_COUNT];
+
+// Read at run time, so that -O2 builds can't fold the work away
+volatile int seed = 3;
+
+// Not inlined into itself, so every level is a real frame at -O2 too
+__attribute__((noinline)) int recurse(int depth) {
+    if (depth == 0) {
+        // BREAKPOINT_MARKER: recursion_bottom
+        return seed;
+    }
+    // Kept live across the call so the frames aren't turned into a loop
+    volatile int here = depth;
+    return recurse(depth - 1) + here % 2;
+}
+
+// volatile, so -O2 keeps the whole megabyte on the stack
+__attribute__((noinline)) int huge_frame(int fill) {
+    volatile char buffer[FRAME_BYTES];
+    for (size_t i = 0; i < sizeof buffer; i++) {
+        buffer[i] = (char)fill;
+    }
+    buffer[sizeof buffer - 1] = 0;
+    // BREAKPOINT_MARKER: huge_frame_body
+    int sum = buffer[0] + buffer[sizeof buffer / 2];
+    return sum;
+}
+
+long fill_globals(void) {
+    long total = 0;
+    for (int i = 0; i < TABLE_ENTRIES; i++) {
+        big_table[i] = i * seed;
+        total += big_table[i] & 1;
+    }
+    for (int i = 0; i < RECORD_COUNT; i++) {
+        records[i].id = i;
+        records[i].weight = i / 4.0;
+        snprintf(records[i].label, sizeof records[i].label, "record-%d", i);
+    }
+    // BREAKPOINT_MARKER: globals_ready
+    return total;
+}
+
+int main(int argc, char *argv[]) {
+    // BREAKPOINT_MARKER: main_start
+    const char *mode = argc > 1 ? argv[1] : "recurse";
+    printf("Stressing with %s\n", mode);
+
+    if (strcmp(mode, "recurse") == 0) {
+        int depth = argc > 2 ? atoi(argv[2]) : 10000;
+        printf("Recursion result: %d\n", recurse(depth));
+    } else if (strcmp(mode, "frame") == 0) {
+        printf("Frame result: %d\n", huge_frame('a' + seed));
+    } else if (strcmp(mode, "globals") == 0) {
+        printf("Odd entries: %ld\n", fill_globals());
+        printf("Last record: %s\n", records[RECORD_COUNT - 1].label);
+    } else {
+        fprintf(stderr, "Unknown mode %s\n", mode);
+        return 1;
+    }
+
+    // BREAKPOINT_MARKER: before_exit
+    return 0;
+}
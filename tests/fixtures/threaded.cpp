@@ -0,0 +1,82 @@
+// Multithreaded C++ test program for debugger integration tests
+#include <condition_variable>
+#include <cstdio>
+#include <mutex>
+#include <thread>
+#include <vector>
+
+constexpr int NUM_THREADS = 2;
+
+// std::barrier needs C++20; this works with any C++11 compiler
+class Barrier {
+public:
+    explicit Barrier(int count) : count_(count) {}
+
+    void wait() {
+        std::unique_lock<std::mutex> lock(mutex_);
+        int phase = phase_;
+        if (++waiting_ == count_) {
+            waiting_ = 0;
+            phase_++;
+            cond_.notify_all();
+        } else {
+            cond_.wait(lock, [&] { return phase != phase_; });
+        }
+    }
+
+private:
+    std::mutex mutex_;
+    std::condition_variable cond_;
+    int count_;
+    int waiting_ = 0;
+    int phase_ = 0;
+};
+
+// Shared state
+Barrier barrier(NUM_THREADS + 1);
+std::mutex counter_mutex;
+int shared_counter = 0;
+
+// Helper function called AFTER barrier - safe to break here
+// BREAKPOINT_MARKER: worker_body
+void worker_body(int thread_id) {
+    // BREAKPOINT_MARKER: worker_start
+    int local_count;
+    {
+        std::lock_guard<std::mutex> lock(counter_mutex);
+        local_count = ++shared_counter;
+    }
+
+    std::printf("Thread %d incremented counter to %d\n", thread_id, local_count);
+    // BREAKPOINT_MARKER: worker_end
+}
+
+void thread_func(int thread_id) {
+    // BREAKPOINT_MARKER: thread_entry (BEFORE barrier - do NOT break here)
+    // Breaking here causes deadlock: debugger stops this thread while other threads
+    // wait for all NUM_THREADS+1 threads (including stopped one) to reach barrier
+    barrier.wait();
+
+    // BREAKPOINT_MARKER: after_barrier (SAFE to break here - all threads synchronized)
+    worker_body(thread_id);
+}
+
+int main() {
+    // BREAKPOINT_MARKER: main_start
+    std::printf("Starting %d worker threads\n", NUM_THREADS);
+
+    std::vector<std::thread> threads;
+    for (int i = 0; i < NUM_THREADS; i++) {
+        threads.emplace_back(thread_func, i);
+    }
+
+    // BREAKPOINT_MARKER: main_wait
+    barrier.wait();
+
+    for (auto &thread : threads) {
+        thread.join();
+    }
+
+    std::printf("Final counter value: %d\n", shared_counter);
+    return 0;
+}
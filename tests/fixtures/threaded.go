@@ -1,27 +1,84 @@
 // Multithreaded test program for debugger integration tests
+//
+// How many workers run, how often each increments the shared counter and
+// whether they take the mutex to do it are set by flags or, when a flag is
+// not given, by environment variables, so one fixture serves thread listing,
+// thread-specific breakpoints, non-stop mode and data races:
+//
+//	-workers N     THREADED_WORKERS=N     workers to start (default 2)
+//	-iterations N  THREADED_ITERATIONS=N  increments per worker (default 1)
+//	-race          THREADED_RACE=1        increment without the mutex
 package main
 
 import (
+	"flag"
 	"fmt"
+	"os"
+	"runtime"
+	"strconv"
 	"sync"
 )
 
-const numWorkers = 2
-
 var sharedCounter int
 var counterMutex sync.Mutex
 
-func worker(id int, start chan bool, done *sync.WaitGroup) {
-	defer done.Done()
+// config is what the flags and environment asked for
+type config struct {
+	workers    int
+	iterations int
+	race       bool
+}
 
-	// BREAKPOINT_MARKER: thread_entry
-	<-start
+// envInt reads a count from the environment, or gives fallback
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value < 1 {
+		return fallback
+	}
+	return value
+}
 
-	// BREAKPOINT_MARKER: worker_start
+func parseConfig() config {
+	var cfg config
+	flag.IntVar(&cfg.workers, "workers", envInt("THREADED_WORKERS", 2), "workers to start")
+	flag.IntVar(&cfg.iterations, "iterations", envInt("THREADED_ITERATIONS", 1),
+		"increments per worker")
+	race, _ := strconv.ParseBool(os.Getenv("THREADED_RACE"))
+	flag.BoolVar(&cfg.race, "race", race, "increment the counter without the mutex")
+	flag.Parse()
+	return cfg
+}
+
+// increment adds one to the counter, under the mutex unless racing
+func increment(race bool) int {
+	if race {
+		// BREAKPOINT_MARKER: race_read
+		value := sharedCounter
+		// Let another worker read the same value before this one writes
+		runtime.Gosched()
+		// BREAKPOINT_MARKER: race_write
+		sharedCounter = value + 1
+		return value + 1
+	}
 	counterMutex.Lock()
 	sharedCounter++
 	localCount := sharedCounter
 	counterMutex.Unlock()
+	return localCount
+}
+
+func worker(id int, cfg config, start chan bool, done *sync.WaitGroup) {
+	defer done.Done()
+
+	// BREAKPOINT_MARKER: thread_entry
+	<-start
+
+	// BREAKPOINT_MARKER: worker_start
+	localCount := 0
+	for i := 0; i < cfg.iterations; i++ {
+		// BREAKPOINT_MARKER: worker_iteration
+		localCount = increment(cfg.race)
+	}
 
 	fmt.Printf("Worker %d incremented counter to %d\n", id, localCount)
 
@@ -30,27 +87,32 @@ func worker(id int, start chan bool, done *sync.WaitGroup) {
 
 func main() {
 	// BREAKPOINT_MARKER: main_start
-	fmt.Printf("Starting %d workers\n", numWorkers)
+	cfg := parseConfig()
+	fmt.Printf("Starting %d workers\n", cfg.workers)
 
 	// Go lacks pthread_barrier equivalent in stdlib; buffered channel provides
 	// deterministic start ordering without requiring all goroutines to synchronize.
 	// Workers proceed independently after receiving start signal (differs from C
 	// barrier which requires all threads to reach barrier before any proceed).
-	startChan := make(chan bool, numWorkers)
+	startChan := make(chan bool, cfg.workers)
 	var wg sync.WaitGroup
 
 	// Spawn workers
-	for i := 0; i < numWorkers; i++ {
+	for i := 0; i < cfg.workers; i++ {
 		wg.Add(1)
-		go worker(i, startChan, &wg)
+		go worker(i, cfg, startChan, &wg)
 	}
 
 	// BREAKPOINT_MARKER: main_wait
 	// Signal all workers to start (deterministic execution)
-	for i := 0; i < numWorkers; i++ {
+	for i := 0; i < cfg.workers; i++ {
 		startChan <- true
 	}
 
 	wg.Wait()
 	fmt.Printf("Final counter value: %d\n", sharedCounter)
+	if expected := cfg.workers * cfg.iterations; sharedCounter != expected {
+		fmt.Printf("Lost %d of %d increments to the race\n", expected-sharedCounter, expected)
+	}
+	// BREAKPOINT_MARKER: before_exit
 }
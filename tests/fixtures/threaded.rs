@@ -0,0 +1,54 @@
+// Multithreaded Rust test program for debugger integration tests
+use std::sync::{Arc, Barrier, Mutex};
+use std::thread;
+
+const NUM_THREADS: usize = 2;
+
+// Helper function called AFTER the barrier - safe to break here
+// BREAKPOINT_MARKER: worker_body
+fn worker_body(thread_id: usize, counter: &Mutex<u32>) {
+    // BREAKPOINT_MARKER: worker_start
+    let local_count = {
+        let mut count = counter.lock().unwrap();
+        *count += 1;
+        *count
+    };
+
+    println!("Thread {} incremented counter to {}", thread_id, local_count);
+    // BREAKPOINT_MARKER: worker_end
+}
+
+fn main() {
+    // Main thread + workers, as in threaded.c
+    let barrier = Arc::new(Barrier::new(NUM_THREADS + 1));
+    let counter = Arc::new(Mutex::new(0));
+
+    // BREAKPOINT_MARKER: main_start
+    println!("Starting {} worker threads", NUM_THREADS);
+
+    let workers: Vec<_> = (0..NUM_THREADS)
+        .map(|thread_id| {
+            let barrier = Arc::clone(&barrier);
+            let counter = Arc::clone(&counter);
+            thread::Builder::new()
+                .name(format!("worker-{}", thread_id))
+                .spawn(move || {
+                    // BREAKPOINT_MARKER: thread_entry (BEFORE barrier - do NOT break here)
+                    barrier.wait();
+
+                    // BREAKPOINT_MARKER: after_barrier (SAFE to break here)
+                    worker_body(thread_id, &counter);
+                })
+                .unwrap()
+        })
+        .collect();
+
+    // BREAKPOINT_MARKER: main_wait
+    barrier.wait();
+
+    for worker in workers {
+        worker.join().unwrap();
+    }
+
+    println!("Final counter value: {}", counter.lock().unwrap());
+}
@@ -0,0 +1,119 @@
+// Rust values for pretty-printing and breakpoint tests: Option and Result,
+// String and Vec, an enum with data, and trait objects
+
+mod geometry {
+    pub trait Shape {
+        fn area(&self) -> f64;
+        fn name(&self) -> &'static str;
+    }
+
+    #[derive(Debug)]
+    pub struct Circle {
+        pub radius: f64,
+    }
+
+    impl Circle {
+        pub fn new(radius: f64) -> Self {
+            // BREAKPOINT_MARKER: circle_new
+            Circle { radius }
+        }
+    }
+
+    impl Shape for Circle {
+        fn area(&self) -> f64 {
+            // BREAKPOINT_MARKER: circle_area
+            3.0 * self.radius * self.radius
+        }
+
+        fn name(&self) -> &'static str {
+            "circle"
+        }
+    }
+
+    #[derive(Debug)]
+    pub struct Square {
+        pub side: f64,
+    }
+
+    impl Shape for Square {
+        fn area(&self) -> f64 {
+            // BREAKPOINT_MARKER: square_area
+            self.side * self.side
+        }
+
+        fn name(&self) -> &'static str {
+            "square"
+        }
+    }
+}
+
+use geometry::{Circle, Shape, Square};
+
+#[derive(Debug)]
+enum Command {
+    Quit,
+    Move { x: i32, y: i32 },
+    Say(String),
+}
+
+#[derive(Debug)]
+struct Crab {
+    name: String,
+    age: u32,
+    tags: Vec<&'static str>,
+}
+
+fn parse_number(text: &str) -> Result<i32, String> {
+    text.parse().map_err(|_| format!("not a number: {}", text))
+}
+
+fn total_area(shapes: &[Box<dyn Shape>]) -> f64 {
+    // BREAKPOINT_MARKER: total_area
+    let mut total = 0.0;
+    for shape in shapes {
+        total += shape.area();
+    }
+    total
+}
+
+fn describe(crab: &Crab, command: &Command) -> String {
+    // BREAKPOINT_MARKER: describe_body
+    let action = match command {
+        Command::Quit => "quits".to_string(),
+        Command::Move { x, y } => format!("moves to ({}, {})", x, y),
+        Command::Say(text) => format!("says {:?}", text),
+    };
+    format!("{} ({}) {:?} {}", crab.name, crab.age, crab.tags, action)
+}
+
+fn main() {
+    // BREAKPOINT_MARKER: main_start
+    let some_number: Option<i32> = Some(42);
+    let no_number: Option<i32> = None;
+    let parsed = parse_number("7");
+    let failed = parse_number("seven");
+    let numbers = vec![1, 2, 3];
+    let greeting = String::from("hello");
+    let ferris = Crab {
+        name: String::from("Ferris"),
+        age: 8,
+        tags: vec!["rustacean", "crab"],
+    };
+    let command = Command::Move { x: 3, y: -4 };
+    let commands = [Command::Quit, Command::Say(greeting.clone())];
+    let shapes: Vec<Box<dyn Shape>> =
+        vec![Box::new(Circle::new(2.0)), Box::new(Square { side: 3.0 })];
+
+    // BREAKPOINT_MARKER: values_ready
+    println!("Options: {:?} {:?}", some_number, no_number);
+    println!("Results: {:?} {:?}", parsed, failed);
+    println!("Numbers: {:?} {}", numbers, greeting);
+    println!("{}", describe(&ferris, &command));
+    for command in &commands {
+        println!("{}", describe(&ferris, command));
+    }
+    let names: Vec<&str> = shapes.iter().map(|shape| shape.name()).collect();
+    println!("Total area of {:?}: {}", names, total_area(&shapes));
+
+    // BREAKPOINT_MARKER: before_exit
+}
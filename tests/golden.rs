@@ -0,0 +1,44 @@
+//! Golden-output tests: each `tests/golden/NAME.dbg` script is run and its
+//! transcript compared with `NAME.out` (see `sessiontest`)
+
+mod fixturebuild;
+mod sessiontest;
+
+use fixturebuild::Language;
+use sessiontest::Session;
+
+#[test]
+fn golden_user_commands() {
+    Session::new("user_commands").check("user_commands");
+}
+
+#[test]
+fn golden_batch_errors() {
+    Session::new("batch_errors").check("batch_errors");
+}
+
+#[test]
+fn golden_ci_summary() {
+    Session::new("ci_summary").check("ci_summary");
+}
+
+#[test]
+fn golden_source_definitions() {
+    Session::new("source_definitions").define("size", "7").check("source_definitions");
+}
+
+#[test]
+fn golden_fixture_stop() {
+    let Some(gdb) = sessiontest::gdb_available() else {
+        eprintln!("Skipping test: GDB ≥14.1 not available");
+        return;
+    };
+    if fixturebuild::compiler(Language::C).is_none() {
+        eprintln!("Skipping test: no C compiler");
+        return;
+    }
+    Session::new("fixture_stop")
+        .adapter("gdb", &gdb, &["-i=dap"])
+        .build_c("simple")
+        .check("fixture_stop");
+}
@@ -1,7 +1,7 @@
 //! End-to-end integration tests for the debugger CLI
 //!
 //! These tests verify the complete debugging workflow by:
-//! 1. Building test fixtures (C programs)
+//! 1. Building test fixtures (C, C++ and Rust programs, see `fixturebuild`)
 //! 2. Running the debugger against them
 //! 3. Verifying breakpoints, stepping, variable inspection, etc.
 
@@ -10,7 +10,12 @@ use std::env;
 use std::fs;
 use std::path::{Path, PathBuf};
 use std::process::{Command, Stdio};
-use std::time::Duration;
+use std::time::{Duration, Instant};
+
+mod fixturebuild;
+
+use debugger::symbols::markers::Markers;
+use fixturebuild::{Arch, Build, DebugInfo, Language};
 
 /// Test context with paths and cleanup
 struct TestContext {
@@ -62,58 +67,20 @@ impl TestContext {
 
     /// Build a C fixture
     fn build_c_fixture(&mut self, name: &str) -> &PathBuf {
-        let source = self.fixtures_dir.join(format!("{}.c", name));
-        let output = self.temp_dir.join(name);
-
-        // Try gcc first, then clang
-        let compiler = if Command::new("gcc").arg("--version").output().is_ok() {
-            "gcc"
-        } else if Command::new("clang").arg("--version").output().is_ok() {
-            "clang"
-        } else {
-            panic!("No C compiler found (tried gcc, clang)");
-        };
-
-        let status = Command::new(compiler)
-            .args([
-                "-g",           // Debug symbols
-                "-O0",          // No optimization
-                "-o",
-                output.to_str().unwrap(),
-                source.to_str().unwrap(),
-            ])
-            .status()
-            .expect("Failed to compile C fixture");
-
-        assert!(status.success(), "C compilation failed");
+        self.build_fixture(&Build::c(name))
+    }
 
-        self.binaries.insert(name.to_string(), output.clone());
-        self.binaries.get(name).unwrap()
+    /// Build a fixture as `build` says, keyed by its binary's name
+    fn build_fixture(&mut self, build: &Build) -> &PathBuf {
+        let output = build.compile(&self.temp_dir);
+        let name = build.output_name();
+        self.binaries.insert(name.clone(), output);
+        self.binaries.get(&name).unwrap()
     }
 
     /// Find breakpoint line numbers from markers in source
     fn find_breakpoint_markers(&self, source: &Path) -> HashMap<String, u32> {
-        let content = fs::read_to_string(source).expect("Failed to read source file");
-        let mut markers = HashMap::new();
-
-        for (line_num, line) in content.lines().enumerate() {
-            if let Some(marker_start) = line.find("BREAKPOINT_MARKER:") {
-                let marker_name = line[marker_start + "BREAKPOINT_MARKER:".len()..]
-                    .split_whitespace()
-                    .next()
-                    .unwrap()
-                    .to_string();
-                // Calculate breakpoint line:
-                // - enumerate() gives 0-indexed line numbers
-                // - DAP uses 1-indexed line numbers, so add 1
-                // - We want the NEXT line after the marker comment, so add another 1
-                // - Total: line_num + 2
-                let breakpoint_line = (line_num as u32) + 2;
-                markers.insert(marker_name, breakpoint_line);
-            }
-        }
-
-        markers
+        Markers::read(source).expect("Failed to read source file").lines()
     }
 
     /// Create a config file for the test
@@ -378,6 +345,37 @@ fn js_debug_available() -> Option<PathBuf> {
     None
 }
 
+/// The native debug adapters the fixture tests run under
+#[derive(Clone, Copy)]
+enum Adapter {
+    Gdb,
+    Lldb,
+}
+
+/// Run `test` with a context named `NAME_gdb` or `NAME_lldb` and configured
+/// for `adapter`, or skip it when the adapter isn't installed
+fn with_adapter(adapter: Adapter, name: &str, test: impl FnOnce(&mut TestContext)) {
+    let (found, suffix) = match adapter {
+        Adapter::Gdb => (gdb_available(), "gdb"),
+        Adapter::Lldb => (lldb_dap_available(), "lldb"),
+    };
+    let Some(path) = found else {
+        match adapter {
+            Adapter::Gdb => eprintln!("Skipping test: GDB ≥14.1 not available"),
+            Adapter::Lldb => eprintln!("Skipping test: lldb-dap not available"),
+        }
+        return;
+    };
+
+    let mut ctx = TestContext::new(&format!("{}_{}", name, suffix));
+    let path = path.to_str().unwrap();
+    match adapter {
+        Adapter::Gdb => ctx.create_config_with_args("gdb", path, &["-i=dap"]),
+        Adapter::Lldb => ctx.create_config("lldb-dap", path),
+    }
+    test(&mut ctx);
+}
+
 // ============== Tests ==============
 
 #[test]
@@ -429,6 +427,33 @@ fn test_breakpoint_location_parsing() {
     }
 }
 
+#[test]
+fn test_missing_marker_is_reported_before_launch() {
+    let mut ctx = TestContext::new("missing_marker");
+    if fixturebuild::compiler(Language::C).is_none() {
+        eprintln!("Skipping test: no C compiler");
+        return;
+    }
+    let binary = ctx.build_c_fixture("simple").clone();
+    // No adapter is needed: the marker is looked up before one is started
+    ctx.create_config("lldb-dap", "/nonexistent/lldb-dap");
+
+    let output = ctx.run_debugger(&[
+        "start",
+        binary.to_str().unwrap(),
+        "--break",
+        "@marker:no_such_marker",
+    ]);
+    let text = format!("{}{}", output.stdout, output.stderr);
+    assert!(!output.success, "Expected start to fail: {}", text);
+    assert!(
+        text.contains("no 'BREAKPOINT_MARKER: no_such_marker'"),
+        "Expected the missing marker to be named: {}",
+        text
+    );
+    ctx.cleanup_daemon();
+}
+
 #[test]
 #[ignore = "requires lldb-dap"]
 fn test_basic_debugging_workflow_c() {
@@ -691,6 +716,91 @@ fn test_stepping_c() {
     ctx.run_debugger(&["stop"]);
 }
 
+#[test]
+#[ignore = "requires lldb-dap"]
+fn test_address_breakpoint_moves_with_load_slide_c() {
+    use debugger::symbols::SymbolIndex;
+
+    let lldb_path = match lldb_dap_available() {
+        Some(path) => path,
+        None => {
+            eprintln!("Skipping test: lldb-dap not available");
+            return;
+        }
+    };
+
+    let mut ctx = TestContext::new("address_breakpoint_c");
+    ctx.create_config("lldb-dap", lldb_path.to_str().unwrap());
+
+    let binary = ctx.build_c_fixture("simple").clone();
+    let index = SymbolIndex::load_with_cache(&binary, None).unwrap();
+    let add = index.functions.iter().find(|function| function.name == "add").unwrap();
+
+    ctx.cleanup_daemon();
+
+    // Given before the program is loaded, the address is the binary's
+    let location = format!("*{:#x}", add.address);
+    ctx.run_debugger_ok(&[
+        "start",
+        binary.to_str().unwrap(),
+        "--stop-on-entry",
+        "--break",
+        &location,
+    ]);
+    let _ = ctx.run_debugger(&["await", "--timeout", "10"]);
+
+    let output = ctx.run_debugger_ok(&["-o", "json", "info", "proc", "slide"]);
+    let slide: serde_json::Value = serde_json::from_str(&output).expect("slide JSON");
+    let slide = slide["data"]["slide"].as_u64().unwrap();
+    let output = ctx.run_debugger_ok(&["breakpoint", "list"]);
+    assert!(
+        output.contains(&format!("*{:#x}", add.address + slide)),
+        "Expected the breakpoint at its address in the process: {}",
+        output
+    );
+
+    ctx.run_debugger_ok(&["continue"]);
+    let output = ctx.run_debugger_ok(&["await", "--timeout", "30"]);
+    assert!(output.contains("Stopped") || output.contains("breakpoint"));
+    let output = ctx.run_debugger_ok(&["backtrace", "--limit", "1"]);
+    assert!(output.contains("add"), "Expected to stop in add(): {}", output);
+
+    ctx.run_debugger(&["stop"]);
+}
+
+#[test]
+#[ignore = "requires lldb-dap"]
+fn test_address_breakpoint_given_after_the_first_stop_c() {
+    with_adapter(Adapter::Lldb, "address_breakpoint_after_stop", |ctx| {
+        let binary = ctx.build_c_fixture("simple").clone();
+        ctx.cleanup_daemon();
+
+        ctx.run_debugger_ok(&["start", binary.to_str().unwrap(), "--stop-on-entry"]);
+        let _ = ctx.run_debugger(&["await", "--timeout", "10"]);
+
+        // Once the program has stopped, the address is the process's
+        let output = ctx.run_debugger_ok(&["print", "&add"]);
+        let hex = output.split("0x").nth(1).expect("an address for add()");
+        let end = hex.find(|c: char| !c.is_ascii_hexdigit()).unwrap_or(hex.len());
+        let address = u64::from_str_radix(&hex[..end], 16).unwrap();
+        ctx.run_debugger_ok(&["break", &format!("*{:#x}", address)]);
+        let output = ctx.run_debugger_ok(&["breakpoint", "list"]);
+        assert!(
+            output.contains(&format!("*{:#x}", address)),
+            "Expected the breakpoint where it was given: {}",
+            output
+        );
+
+        ctx.run_debugger_ok(&["continue"]);
+        let output = ctx.run_debugger_ok(&["await", "--timeout", "30"]);
+        assert!(output.contains("Stopped") || output.contains("breakpoint"));
+        let output = ctx.run_debugger_ok(&["backtrace", "--limit", "1"]);
+        assert!(output.contains("add"), "Expected to stop in add(): {}", output);
+
+        ctx.run_debugger(&["stop"]);
+    });
+}
+
 #[test]
 #[ignore = "requires lldb-dap"]
 fn test_expression_evaluation_c() {
@@ -790,6 +900,15 @@ fn test_multiple_breakpoints_c() {
         output
     );
 
+    // Undo brings all three back under their old IDs
+    let output = ctx.run_debugger_ok(&["undo"]);
+    assert!(output.contains("1, 2, 3 restored"), "Expected restore: {}", output);
+    let output = ctx.run_debugger_ok(&["breakpoint", "list"]);
+    assert_eq!(output.matches("simple.c").count(), 3, "Expected 3 breakpoints: {}", output);
+
+    let output = ctx.run_debugger(&["undo"]);
+    assert!(!output.success, "Nothing should be left to undo");
+
     ctx.run_debugger(&["stop"]);
 }
 
@@ -1298,3 +1417,1237 @@ fn test_await_does_not_block_other_clients_gdb() {
 
     ctx.run_debugger(&["stop"]);
 }
+
+#[test]
+fn test_attach_reads_library_symbols_after_attaching_gdb() {
+    let gdb_path = match gdb_available() {
+        Some(path) => path,
+        None => {
+            eprintln!("Skipping test: GDB ≥14.1 not available");
+            return;
+        }
+    };
+
+    let mut ctx = TestContext::new("attach_deferred_gdb");
+    ctx.create_config_with_args("gdb", gdb_path.to_str().unwrap(), &["-i=dap"]);
+    let binary = ctx.build_c_fixture("attach_target").clone();
+    ctx.cleanup_daemon();
+
+    let mut target = Command::new(&binary)
+        .stdout(Stdio::null())
+        .spawn()
+        .expect("Failed to start attach_target");
+    let pid = target.id().to_string();
+    let attached = ctx.run_debugger(&["attach", &pid]);
+    if !attached.success {
+        let _ = target.kill();
+        let _ = target.wait();
+        eprintln!("Skipping test: could not attach ({})", attached.stderr.trim());
+        return;
+    }
+
+    // libc is left unread by the attach and read on one of the actor's ticks
+    let deadline = std::time::Instant::now() + Duration::from_secs(30);
+    let modules = loop {
+        let modules = ctx.run_debugger_ok(&["modules"]);
+        if !modules.contains("deferred") || std::time::Instant::now() >= deadline {
+            break modules;
+        }
+        std::thread::sleep(Duration::from_millis(200));
+    };
+    let libc = modules.lines().find(|line| line.contains("libc.so"));
+    assert!(
+        libc.is_some_and(|line| line.contains("loaded") || line.contains("no debug info")),
+        "Expected libc's symbols to be read: {}",
+        modules
+    );
+    assert!(!modules.contains("deferred"), "Libraries still unread: {}", modules);
+
+    ctx.run_debugger(&["stop"]);
+    let _ = target.kill();
+    let _ = target.wait();
+}
+
+/// The C, C++ and Rust fixtures, each with lines it prints however it is
+/// compiled
+fn native_fixtures() -> Vec<(Build, &'static [&'static str])> {
+    let multi_source = Build::of(
+        "multi_source",
+        Language::C,
+        &["multi_source/main.c", "multi_source/utils.c"],
+    );
+    vec![
+        (Build::c("simple"), &["Sum: 30", "Factorial: 120"]),
+        (Build::cpp("simple"), &["Sum: 30", "Factorial: 120", "Total of results: 150"]),
+        (Build::c("threaded"), &["Starting 2 worker threads", "Final counter value: 2"]),
+        (Build::cpp("threaded"), &["Starting 2 worker threads", "Final counter value: 2"]),
+        (multi_source, &["Sum: 15", "Product: 50"]),
+        (Build::rust("simple"), &["Sum: 30", "Factorial: 120"]),
+        (Build::rust("threaded"), &["Starting 2 worker threads", "Final counter value: 2"]),
+        (Build::go("threaded"), &["Starting 2 workers", "Final counter value: 2"]),
+        (Build::rust("values"), &["Options: Some(42) None", "Results: Ok(7)", "area of"]),
+        (cgo_fixture(), &["C saw 31 from Go", "Result: 62"]),
+    ]
+}
+
+/// Every fixture the tests compile, each in all its variants
+fn all_fixtures() -> Vec<Build> {
+    let mut fixtures: Vec<Build> = native_fixtures().into_iter().map(|(build, _)| build).collect();
+    fixtures.extend([
+        Build::c("attach_target"),
+        Build::c("crash"),
+        Build::go("crash"),
+        Build::c("stress"),
+        Build::rust("panicking"),
+    ]);
+    for language in [Language::C, Language::Go] {
+        let (host, library) = dlopen_fixture(language);
+        fixtures.extend([host, library]);
+    }
+    for arch in Arch::CROSS {
+        fixtures.extend(cross_fixtures(arch).into_iter().map(|(build, _)| build));
+    }
+    fixtures.iter().flat_map(Build::variants).collect()
+}
+
+/// `make fixtures`: compile everything into the fixture cache up front
+#[test]
+#[ignore = "fills the fixture cache; run with `make fixtures`"]
+fn populate_fixture_cache() {
+    let Some(cache) = fixturebuild::cache::dir() else {
+        eprintln!("The fixture cache is off");
+        return;
+    };
+    let ctx = TestContext::new("populate_fixture_cache");
+    for build in all_fixtures() {
+        if build.compiler().is_none() {
+            eprintln!("Skipping {}: no compiler for it", build.output_name());
+            continue;
+        }
+        build.compile(&ctx.temp_dir);
+    }
+    eprintln!("Fixtures cached in {}", cache.display());
+}
+
+#[test]
+fn test_native_fixtures_build_in_every_variant() {
+    let mut ctx = TestContext::new("native_fixture_variants");
+
+    for (fixture, expected) in native_fixtures() {
+        if fixturebuild::compiler(fixture.language()).is_none() {
+            eprintln!("Skipping {}: no compiler for it", fixture.name());
+            continue;
+        }
+        let markers = ctx.find_breakpoint_markers(fixture.main_source());
+        assert!(
+            markers.contains_key("main_start"),
+            "{} has no main_start marker",
+            fixture.main_source().display()
+        );
+
+        for build in fixture.variants() {
+            let binary = ctx.build_fixture(&build).clone();
+            let output = Command::new(&binary).output().expect("Failed to run fixture");
+            let stdout = String::from_utf8_lossy(&output.stdout);
+            assert!(output.status.success(), "{} failed: {:?}", build.output_name(), output);
+            for line in expected {
+                assert!(
+                    stdout.contains(line),
+                    "{} should print {:?}: {}",
+                    build.output_name(),
+                    line,
+                    stdout
+                );
+            }
+        }
+    }
+}
+
+/// Check the symbols of a build of `simple.c` read from wherever its debug
+/// info went
+fn check_simple_symbols(binary: &Path, build: &Build) {
+    use debugger::symbols::{dwarf, SymbolIndex};
+
+    let variant = build.output_name();
+    let index = SymbolIndex::load(binary).expect("Failed to read the fixture's symbols");
+    let (path, _) = index
+        .find_marker("factorial_body", |path| path.to_path_buf())
+        .unwrap_or_else(|e| panic!("{}: {}", variant, e));
+    assert_eq!(path.file_name().unwrap(), "simple.c", "{}", variant);
+    // Optimized builds declare functions through abstract origins, which
+    // the index doesn't follow
+    if !build.is_optimized() {
+        let factorial = index.functions.iter().find(|f| f.name == "factorial").unwrap();
+        let (file, line) = index
+            .declaration(factorial)
+            .unwrap_or_else(|| panic!("{}: no declaration for factorial", variant));
+        assert_eq!((file.file_name().unwrap().to_str(), line), (Some("simple.c"), 10));
+    }
+
+    let found = dwarf::functions(binary, Some("factorial")).unwrap();
+    let function = found
+        .iter()
+        .find(|function| function.name == "factorial")
+        .unwrap_or_else(|| panic!("{}: factorial is not in the debug info", variant));
+    assert_eq!(function.line, Some(10), "{}", variant);
+    let entry = dwarf::die(binary, function.offset).unwrap();
+    assert_eq!(entry.tag, "DW_TAG_subprogram", "{}", variant);
+    assert!(dwarf::types(binary, Some("int")).unwrap().iter().any(|t| t.name == "int"));
+    let rows = dwarf::lines(binary, Some("simple.c")).unwrap();
+    assert!(rows.iter().any(|row| row.line == Some(15)), "{}: no row for line 15", variant);
+}
+
+#[test]
+fn test_split_dwarf_fixture_symbols() {
+    if fixturebuild::compiler(Language::C).is_none() {
+        eprintln!("Skipping test: no C compiler");
+        return;
+    }
+    let mut ctx = TestContext::new("split_dwarf_fixture_symbols");
+    let mut kinds = vec![DebugInfo::Binary, DebugInfo::Dwo];
+    match fixturebuild::dwarf_packer() {
+        Some(_) => kinds.push(DebugInfo::Package),
+        None => eprintln!("Skipping .dwp builds: neither llvm-dwp nor dwp is installed"),
+    }
+    for kind in kinds {
+        for build in Build::c("simple").debug_info(kind).variants() {
+            let binary = ctx.build_fixture(&build).clone();
+            if kind != DebugInfo::Binary {
+                assert!(!build.split_files(&binary).is_empty(), "{}", build.output_name());
+            }
+            check_simple_symbols(&binary, &build);
+        }
+    }
+
+    // A build moved elsewhere, whose .dwo is no longer where its dwo_name
+    // says, finds it beside the binary
+    let build = Build::c("simple").debug_info(DebugInfo::Dwo);
+    let binary = ctx.build_fixture(&build).clone();
+    let moved = ctx.temp_dir.join("moved");
+    fs::create_dir_all(&moved).unwrap();
+    for file in std::iter::once(binary.clone()).chain(build.split_files(&binary)) {
+        fs::copy(&file, moved.join(file.file_name().unwrap())).unwrap();
+        fs::remove_file(&file).unwrap();
+    }
+    check_simple_symbols(&moved.join(binary.file_name().unwrap()), &build);
+}
+
+#[test]
+fn test_stripped_fixture_reads_its_debug_file() {
+    use debugger::symbols::{self, cache, SymbolIndex};
+
+    let (Some(_), Some(objcopy)) = (fixturebuild::compiler(Language::C), fixturebuild::objcopy())
+    else {
+        eprintln!("Skipping test: no C compiler or objcopy");
+        return;
+    };
+    let mut ctx = TestContext::new("stripped_fixture_debug_file");
+    let build = Build::c("simple");
+    let built = ctx.build_fixture(&build).clone();
+
+    // As a distribution packages it: the debug info goes to its own file,
+    // and the binary keeps only a link to it
+    let dir = ctx.temp_dir.join("stripped");
+    fs::create_dir_all(&dir).unwrap();
+    let binary = dir.join("simple");
+    let debug = dir.join("simple.debug");
+    fs::copy(&built, &binary).unwrap();
+    let objcopy = |args: &[&std::ffi::OsStr]| {
+        let status = Command::new(&objcopy).args(args).status().expect("Failed to run objcopy");
+        assert!(status.success(), "objcopy {:?} failed", args);
+    };
+    objcopy(&["--only-keep-debug".as_ref(), binary.as_ref(), debug.as_ref()]);
+    objcopy(&["--strip-all".as_ref(), binary.as_ref()]);
+    let link = format!("--add-gnu-debuglink={}", debug.display());
+    objcopy(&[link.as_ref(), binary.as_ref()]);
+
+    let stripped = SymbolIndex::load_with_cache(&binary, None).unwrap();
+    assert!(stripped.functions.iter().all(|function| function.name != "factorial"));
+    assert_eq!(symbols::symbols_path(&binary, &[]), debug);
+    check_simple_symbols(&debug, &build);
+
+    // A debug file from another build doesn't match the link's CRC
+    let renamed = dir.join("simple.debug.real");
+    fs::rename(&debug, &renamed).unwrap();
+    fs::copy(&built, &debug).unwrap();
+    assert_eq!(symbols::symbols_path(&binary, &[]), binary);
+    fs::remove_file(&debug).unwrap();
+
+    // Installed by build ID under a debug file directory
+    let Some(id) = cache::build_id(&binary) else {
+        eprintln!("Skipping build ID lookup: the fixture has no build ID");
+        return;
+    };
+    let directory = ctx.temp_dir.join("debug");
+    let by_id = directory.join(".build-id").join(&id[..2]).join(format!("{}.debug", &id[2..]));
+    fs::create_dir_all(by_id.parent().unwrap()).unwrap();
+    fs::rename(&renamed, &by_id).unwrap();
+    assert_eq!(symbols::symbols_path(&binary, &[]), binary);
+    assert_eq!(symbols::symbols_path(&binary, &[directory]), by_id);
+}
+
+#[test]
+fn test_threaded_go_takes_its_shape_from_flags_and_environment() {
+    if fixturebuild::compiler(Language::Go).is_none() {
+        eprintln!("Skipping test: go not available");
+        return;
+    }
+    let mut ctx = TestContext::new("threaded_go_parameters");
+    let build = Build::go("threaded");
+    let binary = ctx.build_fixture(&build).clone();
+    let markers = ctx.find_breakpoint_markers(build.main_source());
+    for marker in ["worker_iteration", "race_read", "race_write", "before_exit"] {
+        assert!(markers.contains_key(marker), "threaded.go has no {} marker", marker);
+    }
+
+    let run = |args: &[&str], env: &[(&str, &str)]| {
+        let output = Command::new(&binary)
+            .args(args)
+            .envs(env.iter().copied())
+            .output()
+            .expect("Failed to run fixture");
+        assert!(output.status.success(), "{:?} {:?} failed: {:?}", args, env, output);
+        String::from_utf8_lossy(&output.stdout).into_owned()
+    };
+    let final_value = |stdout: &str| -> usize {
+        let line = stdout.lines().find(|line| line.starts_with("Final counter value: "));
+        line.and_then(|line| line.rsplit(' ').next()?.parse().ok())
+            .unwrap_or_else(|| panic!("No final counter value: {}", stdout))
+    };
+
+    let env = [("THREADED_WORKERS", "3"), ("THREADED_ITERATIONS", "10")];
+    let stdout = run(&[], &env);
+    assert!(stdout.contains("Starting 3 workers"), "{}", stdout);
+    assert_eq!(final_value(&stdout), 30, "{}", stdout);
+    assert!(!stdout.contains("Lost"), "{}", stdout);
+
+    // Flags win over the environment
+    let stdout = run(&["-workers", "5"], &env);
+    assert!(stdout.contains("Starting 5 workers"), "{}", stdout);
+    assert_eq!(final_value(&stdout), 50, "{}", stdout);
+
+    // Without the mutex increments may be lost, but never gained
+    let stdout = run(&["-race", "-workers", "4", "-iterations", "500"], &[]);
+    let value = final_value(&stdout);
+    assert!(value <= 2000, "{}", stdout);
+    assert_eq!(value < 2000, stdout.contains("Lost"), "{}", stdout);
+    let env = [("THREADED_RACE", "1"), ("THREADED_WORKERS", "4")];
+    let stdout = run(&["-iterations", "500"], &env);
+    assert!(final_value(&stdout) <= 2000, "{}", stdout);
+}
+
+/// Stop in `add` and in `stats::Counter::record` in one build of the C++
+/// fixture, and check the stack reaches `main` with or without frame
+/// pointers; values are only checked at -O0, as -O2 may optimize them out
+fn check_cpp_session(ctx: &TestContext, binary: &Path, build: &Build) {
+    let variant = build.output_name();
+    let markers = ctx.find_breakpoint_markers(build.main_source());
+    let add_body_line = markers.get("add_body").expect("Missing add_body marker");
+
+    ctx.cleanup_daemon();
+    let breakpoint = format!("simple.cpp:{}", add_body_line);
+    ctx.run_debugger_ok(&["start", binary.to_str().unwrap(), "--break", &breakpoint]);
+
+    let output = ctx.run_debugger_ok(&["await", "--timeout", "30"]);
+    assert!(output.contains("simple.cpp"), "{}: expected a stop in add: {}", variant, output);
+    let output = ctx.run_debugger_ok(&["backtrace"]);
+    assert!(output.contains("main"), "{}: expected main in the stack: {}", variant, output);
+    ctx.run_debugger_ok(&["locals"]);
+    if !build.is_optimized() {
+        let output = ctx.run_debugger_ok(&["print", "a + b"]);
+        assert!(output.contains("30"), "{}: expected a+b=30: {}", variant, output);
+    }
+
+    ctx.run_debugger_ok(&["break", "stats::Counter::record"]);
+    ctx.run_debugger_ok(&["continue"]);
+    let output = ctx.run_debugger_ok(&["await", "--timeout", "30"]);
+    assert!(output.contains("record"), "{}: expected a stop in record: {}", variant, output);
+    let output = ctx.run_debugger_ok(&["backtrace"]);
+    assert!(output.contains("main"), "{}: expected main in the stack: {}", variant, output);
+    if !build.is_optimized() {
+        let output = ctx.run_debugger_ok(&["print", "value"]);
+        assert!(output.contains("30"), "{}: expected value=30: {}", variant, output);
+    }
+
+    let _ = ctx.run_debugger(&["stop"]);
+}
+
+#[test]
+fn test_cpp_fixture_variants_gdb() {
+    if fixturebuild::compiler(Language::Cpp).is_none() {
+        eprintln!("Skipping test: no C++ compiler");
+        return;
+    }
+
+    with_adapter(Adapter::Gdb, "cpp_variants", |ctx| {
+        for build in Build::cpp("simple").variants() {
+            let binary = ctx.build_fixture(&build).clone();
+            check_cpp_session(ctx, &binary, &build);
+        }
+    });
+}
+
+#[test]
+#[ignore = "requires lldb-dap"]
+fn test_cpp_fixture_variants_lldb() {
+    if fixturebuild::compiler(Language::Cpp).is_none() {
+        eprintln!("Skipping test: no C++ compiler");
+        return;
+    }
+
+    with_adapter(Adapter::Lldb, "cpp_variants", |ctx| {
+        for build in Build::cpp("simple").variants() {
+            let binary = ctx.build_fixture(&build).clone();
+            check_cpp_session(ctx, &binary, &build);
+        }
+    });
+}
+
+#[test]
+fn test_rust_fixture_symbols() {
+    use debugger::symbols::{dwarf, symbolicate::Symbolicator, SymbolIndex};
+
+    if fixturebuild::compiler(Language::Rust).is_none() {
+        eprintln!("Skipping test: no rustc");
+        return;
+    }
+    let mut ctx = TestContext::new("rust_fixture_symbols");
+    let source = ctx.fixtures_dir.join("values.rs");
+    let markers = ctx.find_breakpoint_markers(&source);
+
+    for build in Build::rust("values").variants() {
+        let variant = build.output_name();
+        let binary = ctx.build_fixture(&build).clone();
+        let index = SymbolIndex::load(&binary).expect("Failed to read the fixture's symbols");
+
+        // Demangled as `break` takes them, trait impls included
+        let found = index.find_functions("area", 50);
+        let names: Vec<&str> = found.iter().map(|found| found.item.name.as_str()).collect();
+        assert!(
+            names.contains(&"<values::geometry::Circle as values::geometry::Shape>::area"),
+            "{}: expected Circle's Shape::area: {:?}",
+            variant,
+            names
+        );
+        let describe = index
+            .functions
+            .iter()
+            .find(|function| function.name == "values::describe")
+            .unwrap_or_else(|| panic!("{}: no values::describe", variant));
+        assert_eq!(index.suggest_functions("values::describ", 1), ["values::describe"]);
+
+        // Markers are found through the line table's files
+        let (file, line) = index
+            .find_marker("circle_area", |path| path.to_path_buf())
+            .unwrap_or_else(|e| panic!("{}: {}", variant, e));
+        assert_eq!(file.file_name().unwrap(), "values.rs");
+        assert_eq!(line, markers["circle_area"]);
+
+        // DWARF qualifies functions by their module
+        let functions = dwarf::functions(&binary, Some("describe")).unwrap();
+        assert!(
+            functions.iter().any(|function| function.name == "values::describe"),
+            "{}: expected values::describe in the DWARF: {:?}",
+            variant,
+            functions.iter().map(|function| &function.name).collect::<Vec<_>>()
+        );
+
+        // Addresses in a log resolve into the Rust source
+        let symbolicator = Symbolicator::load(&binary).unwrap();
+        let log = format!("   3: {:#x} - <unknown>", describe.address + 1);
+        let result = symbolicator.symbolicate(&log, 0);
+        assert_eq!(result.resolved, 1, "{}: {:?}", variant, result);
+        let frame = &result.lines[0].frames[0];
+        assert_eq!(frame.function, "values::describe");
+        assert!(
+            frame.file.as_deref().is_some_and(|file| file.ends_with("values.rs")),
+            "{}: expected values.rs: {:?}",
+            variant,
+            frame
+        );
+    }
+
+    // Rust paths are function breakpoints, not file:line
+    use debugger::ipc::protocol::BreakpointLocation;
+    let trait_impl = "<values::geometry::Circle as values::geometry::Shape>::area";
+    for name in ["values::geometry::Circle::new", trait_impl] {
+        match BreakpointLocation::parse(name).unwrap() {
+            BreakpointLocation::Function { name: parsed } => assert_eq!(parsed, name),
+            other => panic!("Expected {} to be a function, got {:?}", name, other),
+        }
+    }
+
+    // A panic exits with 101 and says where it was
+    let binary = ctx.build_fixture(&Build::rust("panicking")).clone();
+    let output = Command::new(&binary).output().expect("Failed to run fixture");
+    let stderr = String::from_utf8_lossy(&output.stderr);
+    assert_eq!(output.status.code(), Some(101), "{:?}", output);
+    assert!(stderr.contains("panicked at"), "{}", stderr);
+    assert!(stderr.contains("panicking.rs"), "{}", stderr);
+}
+
+/// Stop in the Rust fixtures and check that Option, Result, String and
+/// struct values print, that line and function breakpoints resolve in trait
+/// methods called through `dyn Shape` and in plain functions, and that a
+/// panic stops with its callers on the stack
+fn check_rust_session(ctx: &TestContext, values: &Path, panicking: &Path) {
+    ctx.cleanup_daemon();
+    let binary = values.to_str().unwrap();
+    ctx.run_debugger_ok(&["start", binary, "--break", "@marker:values_ready"]);
+    let output = ctx.run_debugger_ok(&["await", "--timeout", "30"]);
+    assert!(output.contains("values.rs"), "Expected a stop in main: {}", output);
+
+    for (expression, expected) in [
+        ("some_number", &["Some", "42"][..]),
+        ("no_number", &["None"]),
+        ("parsed", &["Ok", "7"]),
+        ("failed", &["Err", "not a number"]),
+        ("greeting", &["hello"]),
+        ("ferris", &["Ferris", "8"]),
+        ("command", &["Move", "3", "-4"]),
+    ] {
+        let output = ctx.run_debugger_ok(&["print", expression]);
+        for text in expected {
+            assert!(output.contains(text), "Expected {} in {}: {}", text, expression, output);
+        }
+    }
+    let output = ctx.run_debugger_ok(&["locals"]);
+    assert!(output.contains("shapes"), "Expected shapes in the locals: {}", output);
+
+    ctx.run_debugger_ok(&["break", "values::describe"]);
+    ctx.run_debugger_ok(&["continue"]);
+    let output = ctx.run_debugger_ok(&["await", "--timeout", "30"]);
+    assert!(output.contains("describe"), "Expected a stop in describe: {}", output);
+
+    // Line breakpoint in a trait method reached through `dyn Shape`
+    ctx.run_debugger_ok(&["breakpoint", "remove", "--all"]);
+    ctx.run_debugger_ok(&["break", "@marker:circle_area"]);
+    ctx.run_debugger_ok(&["continue"]);
+    let output = ctx.run_debugger_ok(&["await", "--timeout", "30"]);
+    assert!(output.contains("area"), "Expected a stop in Circle::area: {}", output);
+    let output = ctx.run_debugger_ok(&["backtrace"]);
+    assert!(output.contains("total_area"), "Expected total_area in the stack: {}", output);
+    let output = ctx.run_debugger_ok(&["print", "self.radius"]);
+    assert!(output.contains('2'), "Expected radius 2: {}", output);
+    let _ = ctx.run_debugger(&["stop"]);
+
+    ctx.cleanup_daemon();
+    let binary = panicking.to_str().unwrap();
+    ctx.run_debugger_ok(&["start", binary, "--break", "core::panicking::panic_fmt"]);
+    let output = ctx.run_debugger_ok(&["await", "--timeout", "30"]);
+    assert!(output.contains("panic_fmt"), "Expected a stop in panic_fmt: {}", output);
+    let output = ctx.run_debugger_ok(&["backtrace"]);
+    for function in ["parse_retries", "load_config", "main"] {
+        assert!(output.contains(function), "Expected {} in the stack: {}", function, output);
+    }
+    let _ = ctx.run_debugger(&["stop"]);
+}
+
+#[test]
+fn test_rust_fixtures_gdb() {
+    if fixturebuild::compiler(Language::Rust).is_none() {
+        eprintln!("Skipping test: no rustc");
+        return;
+    }
+
+    with_adapter(Adapter::Gdb, "rust_fixtures", |ctx| {
+        let values = ctx.build_fixture(&Build::rust("values")).clone();
+        let panicking = ctx.build_fixture(&Build::rust("panicking")).clone();
+        check_rust_session(ctx, &values, &panicking);
+    });
+}
+
+#[test]
+#[ignore = "requires lldb-dap"]
+fn test_rust_fixtures_lldb() {
+    if fixturebuild::compiler(Language::Rust).is_none() {
+        eprintln!("Skipping test: no rustc");
+        return;
+    }
+
+    with_adapter(Adapter::Lldb, "rust_fixtures", |ctx| {
+        let values = ctx.build_fixture(&Build::rust("values")).clone();
+        let panicking = ctx.build_fixture(&Build::rust("panicking")).clone();
+        check_rust_session(ctx, &values, &panicking);
+    });
+}
+
+/// How the crash fixture fails in each mode: the signal it dies of, and the
+/// function the fault is in
+const CRASH_MODES: &[(&str, i32, &str, &str)] = &[
+    ("segfault", 11, "SIGSEGV", "write_through"),
+    ("divide", 8, "SIGFPE", "divide"),
+    ("abort", 6, "SIGABRT", "check_invariant"),
+];
+
+#[test]
+#[cfg(unix)]
+fn test_crash_fixtures_fault_as_expected() {
+    use std::os::unix::process::ExitStatusExt;
+
+    let mut ctx = TestContext::new("crash_fixtures");
+    if fixturebuild::compiler(Language::C).is_some() {
+        let markers = ctx.find_breakpoint_markers(&ctx.fixtures_dir.join("crash.c"));
+        for build in Build::c("crash").variants() {
+            let binary = ctx.build_fixture(&build).clone();
+            for (mode, signal, _, _) in CRASH_MODES {
+                assert!(markers.contains_key(&format!("before_{}", mode)));
+                let output = Command::new(&binary).arg(mode).output().expect("Failed to run");
+                assert_eq!(
+                    output.status.signal(),
+                    Some(*signal),
+                    "{} {} should die of signal {}: {:?}",
+                    build.output_name(),
+                    mode,
+                    signal,
+                    output
+                );
+                let stdout = String::from_utf8_lossy(&output.stdout);
+                assert!(stdout.contains(&format!("Crashing with {}", mode)), "{}", stdout);
+            }
+        }
+    }
+
+    if fixturebuild::compiler(Language::Go).is_none() {
+        eprintln!("Skipping the Go crash fixture: no go");
+        return;
+    }
+    let binary = ctx.build_fixture(&Build::go("crash")).clone();
+    let output = Command::new(&binary).arg("index").output().expect("Failed to run");
+    let stdout = String::from_utf8_lossy(&output.stdout);
+    let stderr = String::from_utf8_lossy(&output.stderr);
+    assert_eq!(output.status.code(), Some(2), "{:?}", output);
+    assert!(stderr.contains("panic: runtime error: index out of range [3]"), "{}", stderr);
+    assert!(stderr.contains("main.parse"), "{}", stderr);
+    // Deferred calls run innermost first as the panic unwinds
+    let cleanups: Vec<&str> = stdout.lines().filter(|line| line.starts_with("cleanup")).collect();
+    assert_eq!(cleanups, ["cleanup parse", "cleanup process", "cleanup main"]);
+
+    let output = Command::new(&binary).arg("nil").output().expect("Failed to run");
+    let stderr = String::from_utf8_lossy(&output.stderr);
+    assert_eq!(output.status.code(), Some(2), "{:?}", output);
+    assert!(stderr.contains("nil pointer dereference"), "{}", stderr);
+    assert!(stderr.contains("[signal SIGSEGV"), "{}", stderr);
+}
+
+/// Run the crash fixture in each mode to its marker before the fault, then
+/// on into the fault with crash reports on, and check the stop names the
+/// signal, the faulting function and, for the segfault, the address written
+fn check_crash_session(ctx: &TestContext, binary: &Path, build: &Build) {
+    for (mode, _, signal, function) in CRASH_MODES {
+        let variant = format!("{} {}", build.output_name(), mode);
+        ctx.cleanup_daemon();
+        let marker = format!("@marker:before_{}", mode);
+        ctx.run_debugger_ok(&["start", binary.to_str().unwrap(), "--break", &marker, "--", mode]);
+        let output = ctx.run_debugger_ok(&["await", "--timeout", "30"]);
+        assert!(output.contains("crash.c"), "{}: expected the marker: {}", variant, output);
+        ctx.run_debugger_ok(&["set", "crash-report", "on"]);
+
+        ctx.run_debugger_ok(&["continue"]);
+        let output = ctx.run_debugger_ok(&["await", "--timeout", "30"]);
+        assert!(output.contains(signal), "{}: expected {}: {}", variant, signal, output);
+        assert!(output.contains("Crash report"), "{}: expected a report: {}", variant, output);
+        if *mode == "segfault" {
+            assert!(output.contains("at 0x8"), "{}: expected 0x8: {}", variant, output);
+            assert!(output.contains(function), "{}: expected {}: {}", variant, function, output);
+        }
+
+        let output = ctx.run_debugger_ok(&["backtrace"]);
+        for name in [function, &"main"] {
+            assert!(output.contains(name), "{}: expected {}: {}", variant, name, output);
+        }
+        let _ = ctx.run_debugger(&["stop"]);
+    }
+}
+
+#[test]
+fn test_crash_fixtures_gdb() {
+    if fixturebuild::compiler(Language::C).is_none() {
+        eprintln!("Skipping test: no C compiler");
+        return;
+    }
+
+    with_adapter(Adapter::Gdb, "crash_fixtures", |ctx| {
+        for build in Build::c("crash").variants() {
+            let binary = ctx.build_fixture(&build).clone();
+            check_crash_session(ctx, &binary, &build);
+        }
+    });
+}
+
+#[test]
+#[ignore = "requires lldb-dap"]
+fn test_crash_fixtures_lldb() {
+    if fixturebuild::compiler(Language::C).is_none() {
+        eprintln!("Skipping test: no C compiler");
+        return;
+    }
+
+    with_adapter(Adapter::Lldb, "crash_fixtures", |ctx| {
+        let build = Build::c("crash");
+        let binary = ctx.build_fixture(&build).clone();
+        check_crash_session(ctx, &binary, &build);
+    });
+}
+
+/// The cgo fixture: Go calling C calling Go calling C
+fn cgo_fixture() -> Build {
+    Build::of("cgo", Language::Go, &["cgo/main.go", "cgo/native.c"])
+}
+
+/// Whether the cgo fixture can be built: it needs go and a C compiler
+fn cgo_available() -> bool {
+    fixturebuild::compiler(Language::Go).is_some() && fixturebuild::compiler(Language::C).is_some()
+}
+
+/// The functions a stop in `native_leaf` has on its stack, innermost first
+const CGO_STACK: &[&str] = &["native_leaf", "goVisit", "native_walk", "main.walk", "main.main"];
+
+#[test]
+fn test_cgo_fixture_symbols() {
+    use debugger::symbols::SymbolIndex;
+
+    if !cgo_available() {
+        eprintln!("Skipping test: cgo needs go and a C compiler");
+        return;
+    }
+    let mut ctx = TestContext::new("cgo_fixture_symbols");
+    for build in cgo_fixture().variants() {
+        let variant = build.output_name();
+        let binary = ctx.build_fixture(&build).clone();
+        let index = SymbolIndex::load(&binary).expect("Failed to read the fixture's symbols");
+        let functions =
+            ["native_leaf", "native_walk", "main.goVisit", "main.walk", "runtime.cgocall"];
+        for function in functions {
+            assert!(
+                index.functions.iter().any(|found| found.name == function),
+                "{}: expected {} in the symbols",
+                variant,
+                function
+            );
+        }
+
+        // Markers on both sides of the boundary are found through the line table
+        for (marker, file) in [("c_leaf", "native.c"), ("go_callback", "main.go")] {
+            let (path, _) = index
+                .find_marker(marker, |path| path.to_path_buf())
+                .unwrap_or_else(|e| panic!("{}: {}", variant, e));
+            assert_eq!(path.file_name().unwrap(), file, "{}: {}", variant, marker);
+        }
+    }
+}
+
+/// Stop on each side of the cgo boundary and check the backtrace has every
+/// frame from the stop back to `main.main`, in order
+fn check_cgo_session(ctx: &TestContext, binary: &Path) {
+    for (marker, stack) in [("c_leaf", CGO_STACK), ("go_callback", &CGO_STACK[1..])] {
+        ctx.cleanup_daemon();
+        let location = format!("@marker:{}", marker);
+        ctx.run_debugger_ok(&["start", binary.to_str().unwrap(), "--break", &location]);
+        let output = ctx.run_debugger_ok(&["await", "--timeout", "30"]);
+        assert!(output.contains("Stopped"), "{}: expected a stop: {}", marker, output);
+
+        let output = ctx.run_debugger_ok(&["backtrace"]);
+        let mut from = 0;
+        for function in stack {
+            let at = output[from..].find(function).unwrap_or_else(|| {
+                panic!("{}: expected {} after the frames before it: {}", marker, function, output)
+            });
+            from += at + function.len();
+        }
+        let _ = ctx.run_debugger(&["stop"]);
+    }
+}
+
+#[test]
+fn test_cgo_fixture_gdb() {
+    if !cgo_available() {
+        eprintln!("Skipping test: cgo needs go and a C compiler");
+        return;
+    }
+
+    with_adapter(Adapter::Gdb, "cgo_fixture", |ctx| {
+        let binary = ctx.build_fixture(&cgo_fixture()).clone();
+        check_cgo_session(ctx, &binary);
+    });
+}
+
+#[test]
+#[ignore = "requires lldb-dap"]
+fn test_cgo_fixture_lldb() {
+    if !cgo_available() {
+        eprintln!("Skipping test: cgo needs go and a C compiler");
+        return;
+    }
+
+    with_adapter(Adapter::Lldb, "cgo_fixture", |ctx| {
+        let binary = ctx.build_fixture(&cgo_fixture()).clone();
+        check_cgo_session(ctx, &binary);
+    });
+}
+
+/// The dlopen fixture: the host program and the library it loads mid-run,
+/// both in C or both in Go (with `plugin.Open`)
+fn dlopen_fixture(language: Language) -> (Build, Build) {
+    let extension = if language == Language::Go { "go" } else { "c" };
+    let host = Build::of("dlopen_host", language, &[&format!("dlopen/host.{}", extension)]);
+    let library = Build::of("plugin", language, &[&format!("dlopen/plugin.{}", extension)]);
+    (host, library.shared_library())
+}
+
+/// The dlopen fixture's languages that can be built here; a Go plugin needs
+/// cgo, so go and a C compiler
+fn dlopen_languages() -> Vec<Language> {
+    let mut languages = Vec::new();
+    if fixturebuild::compiler(Language::C).is_some() {
+        languages.push(Language::C);
+    }
+    if cgo_available() {
+        languages.push(Language::Go);
+    }
+    languages
+}
+
+#[test]
+fn test_dlopen_fixtures_load_their_libraries() {
+    use debugger::symbols::SymbolIndex;
+
+    let mut ctx = TestContext::new("dlopen_fixtures");
+    for language in dlopen_languages() {
+        let (host, library) = dlopen_fixture(language);
+        // A Go plugin only loads into a host built the same way
+        for (host, library) in host.variants().into_iter().zip(library.variants()) {
+            let variant = host.output_name();
+            let program = ctx.build_fixture(&host).clone();
+            let plugin = ctx.build_fixture(&library).clone();
+            let output = Command::new(&program).arg(&plugin).output().expect("Failed to run");
+            let stdout = String::from_utf8_lossy(&output.stdout);
+            assert!(output.status.success(), "{} failed: {:?}", variant, output);
+            for line in ["Plugin loaded", "Plugin computed 22"] {
+                assert!(stdout.contains(line), "{} should print {:?}: {}", variant, line, stdout);
+            }
+
+            // The library's functions are only in the library, so a
+            // breakpoint on them is pending until it loads
+            let compute = |name: &str| name == "plugin_compute" || name.ends_with(".Compute");
+            let index = SymbolIndex::load(&program).expect("Failed to read the host's symbols");
+            assert!(!index.functions.iter().any(|f| compute(&f.name)), "{}", variant);
+            let index = SymbolIndex::load(&plugin).expect("Failed to read the library's symbols");
+            assert!(index.functions.iter().any(|f| compute(&f.name)), "{}", variant);
+            let (path, _) = index
+                .find_marker("plugin_compute", |path| path.to_path_buf())
+                .unwrap_or_else(|e| panic!("{}: {}", variant, e));
+            assert_eq!(path, library.main_source(), "{}", variant);
+        }
+    }
+}
+
+/// Stop before the library loads, set breakpoints in it, which are pending,
+/// and check the program stops on each once it is loaded
+fn check_dlopen_session(ctx: &TestContext, host: &Path, plugin: &Path, library: &Build) {
+    let source = library.main_source();
+    let markers = ctx.find_breakpoint_markers(source);
+    let file = source.file_name().unwrap().to_str().unwrap();
+    ctx.cleanup_daemon();
+    let (host, plugin) = (host.to_str().unwrap(), plugin.to_str().unwrap());
+    ctx.run_debugger_ok(&["start", host, "--break", "@marker:before_load", "--", plugin]);
+    let output = ctx.run_debugger_ok(&["await", "--timeout", "30"]);
+    assert!(output.contains("host."), "{}: expected before_load: {}", file, output);
+
+    for marker in ["plugin_compute", "plugin_scale"] {
+        let location = format!("{}:{}", source.display(), markers[marker]);
+        let output = ctx.run_debugger_ok(&["break", &location]);
+        assert!(output.contains("pending"), "{}: expected {} pending: {}", file, marker, output);
+    }
+
+    for (marker, function) in [("plugin_compute", "ompute"), ("plugin_scale", "scale")] {
+        ctx.run_debugger_ok(&["continue"]);
+        let output = ctx.run_debugger_ok(&["await", "--timeout", "30"]);
+        let line = format!("{}:{}", file, markers[marker]);
+        assert!(output.contains(&line), "{}: expected a stop at {}: {}", file, line, output);
+        let output = ctx.run_debugger_ok(&["backtrace"]);
+        for name in [function, "main"] {
+            assert!(output.contains(name), "{}: expected {}: {}", file, name, output);
+        }
+    }
+
+    ctx.run_debugger_ok(&["continue"]);
+    let output = ctx.run_debugger_ok(&["await", "--timeout", "30"]);
+    assert!(output.contains("exited") || output.contains("terminated"));
+    let output = ctx.run_debugger_ok(&["output"]);
+    assert!(output.contains("Plugin computed 22"), "{}: expected the result: {}", file, output);
+    let _ = ctx.run_debugger(&["stop"]);
+}
+
+#[test]
+fn test_dlopen_fixtures_gdb() {
+    with_adapter(Adapter::Gdb, "dlopen_fixtures", |ctx| {
+        for language in dlopen_languages() {
+            let (host, library) = dlopen_fixture(language);
+            let program = ctx.build_fixture(&host).clone();
+            let plugin = ctx.build_fixture(&library).clone();
+            check_dlopen_session(ctx, &program, &plugin, &library);
+        }
+    });
+}
+
+#[test]
+#[ignore = "requires lldb-dap"]
+fn test_dlopen_fixture_lldb() {
+    with_adapter(Adapter::Lldb, "dlopen_fixtures", |ctx| {
+        let (host, library) = dlopen_fixture(Language::C);
+        let program = ctx.build_fixture(&host).clone();
+        let plugin = ctx.build_fixture(&library).clone();
+        check_dlopen_session(ctx, &program, &plugin, &library);
+    });
+}
+
+/// How long one command on the stress fixture may take, and how much it may
+/// print: a deep stack or a huge array must not be read whole
+const STRESS_TIME_BUDGET: Duration = Duration::from_secs(10);
+const STRESS_OUTPUT_BUDGET: usize = 64 * 1024;
+
+/// The stress fixture's modes and what each prints
+const STRESS_MODES: &[(&str, &str)] = &[
+    ("recurse", "Recursion result: 5003"),
+    ("frame", "Frame result: 200"),
+    ("globals", "Last record: record-262143"),
+];
+
+#[test]
+fn test_stress_fixture_runs_in_every_mode() {
+    if fixturebuild::compiler(Language::C).is_none() {
+        eprintln!("Skipping test: no C compiler");
+        return;
+    }
+    let mut ctx = TestContext::new("stress_fixture");
+    for build in Build::c("stress").variants() {
+        let binary = ctx.build_fixture(&build).clone();
+        for (mode, expected) in STRESS_MODES {
+            let output = Command::new(&binary).arg(mode).output().expect("Failed to run");
+            let stdout = String::from_utf8_lossy(&output.stdout);
+            let variant = format!("{} {}", build.output_name(), mode);
+            assert!(output.status.success(), "{} failed: {:?}", variant, output);
+            assert!(stdout.contains(expected), "{}: expected {:?}: {}", variant, expected, stdout);
+        }
+    }
+}
+
+/// Run a debugger command, failing if it takes or prints more than the
+/// stress budgets allow
+fn run_within_budget(ctx: &TestContext, args: &[&str]) -> String {
+    let started = Instant::now();
+    let output = ctx.run_debugger_ok(args);
+    let elapsed = started.elapsed();
+    assert!(elapsed < STRESS_TIME_BUDGET, "{:?} took {:?}", args, elapsed);
+    assert!(output.len() < STRESS_OUTPUT_BUDGET, "{:?} printed {} bytes", args, output.len());
+    output
+}
+
+/// Page through the 10,000-deep stack, and read the megabyte frame and the
+/// huge globals, each within the budgets
+fn check_stress_session(ctx: &TestContext, binary: &Path, build: &Build) {
+    let variant = build.output_name();
+    let program = binary.to_str().unwrap();
+    let start = |marker: &str, mode: &str| {
+        ctx.cleanup_daemon();
+        let location = format!("@marker:{}", marker);
+        ctx.run_debugger_ok(&["start", program, "--break", &location, "--", mode]);
+        let output = ctx.run_debugger_ok(&["await", "--timeout", "60"]);
+        assert!(output.contains("stress.c"), "{}: expected {}: {}", variant, marker, output);
+    };
+    let frames = |output: &str| output.lines().filter(|line| line.starts_with('#')).count();
+
+    start("recursion_bottom", "recurse");
+    let output = run_within_budget(ctx, &["backtrace"]);
+    assert_eq!(frames(&output), 20, "{}: {}", variant, output);
+    assert!(output.contains("backtrace --start 20"), "{}: expected more: {}", variant, output);
+    let output = run_within_budget(ctx, &["backtrace", "--more"]);
+    let first = output.lines().next().unwrap_or_default();
+    assert!(first.starts_with("#20 recurse"), "{}: expected frame 20: {}", variant, output);
+    let output = run_within_budget(ctx, &["backtrace", "--more", "--limit", "120"]);
+    assert_eq!(frames(&output), 120, "{}: {}", variant, output);
+    assert!(output.contains("#159 recurse"), "{}: expected frame 159: {}", variant, output);
+    let output = run_within_budget(ctx, &["backtrace", "--start", "5000", "--limit", "10"]);
+    let first = output.lines().next().unwrap_or_default();
+    assert!(first.starts_with("#5000 recurse"), "{}: expected frame 5000: {}", variant, output);
+    assert!(output.contains("backtrace --start 5010"), "{}: {}", variant, output);
+    // recurse(10000) down to recurse(0) is 10,001 frames, then main
+    let output = run_within_budget(ctx, &["backtrace", "--start", "10000", "--limit", "20"]);
+    assert!(output.contains("#10001 main"), "{}: expected main: {}", variant, output);
+    assert!(!output.contains("more frames"), "{}: expected the end: {}", variant, output);
+    let args = ["-o", "json", "backtrace", "--start", "10", "--limit", "5"];
+    let output = run_within_budget(ctx, &args);
+    let page: serde_json::Value = serde_json::from_str(&output).expect("backtrace JSON");
+    let data = &page["data"];
+    assert_eq!(data["frames"].as_array().map(Vec::len), Some(5), "{}: {}", variant, output);
+    assert_eq!(data["more"], true, "{}: {}", variant, output);
+
+    start("huge_frame_body", "frame");
+    let output = run_within_budget(ctx, &["locals"]);
+    assert!(output.contains("buffer"), "{}: expected buffer: {}", variant, output);
+    let output = run_within_budget(ctx, &["print", "buffer[4096]"]);
+    assert!(output.contains("100"), "{}: expected 'd': {}", variant, output);
+
+    start("globals_ready", "globals");
+    run_within_budget(ctx, &["print", "big_table"]);
+    let output = run_within_budget(ctx, &["print", "big_table", "--limit", "8"]);
+    let marker = "truncated at 8 bytes, use --limit to raise";
+    assert!(output.contains(marker), "{}: expected the marker: {}", variant, output);
+    let output = run_within_budget(ctx, &["print", "big_table[8388607]"]);
+    assert!(output.contains("25165821"), "{}: expected the last entry: {}", variant, output);
+    let output = run_within_budget(ctx, &["print", "records[262143].label"]);
+    assert!(output.contains("record-262143"), "{}: expected the label: {}", variant, output);
+    let _ = ctx.run_debugger(&["stop"]);
+}
+
+#[test]
+fn test_stress_fixture_gdb() {
+    if fixturebuild::compiler(Language::C).is_none() {
+        eprintln!("Skipping test: no C compiler");
+        return;
+    }
+
+    with_adapter(Adapter::Gdb, "stress_fixture", |ctx| {
+        for build in Build::c("stress").variants() {
+            let binary = ctx.build_fixture(&build).clone();
+            check_stress_session(ctx, &binary, &build);
+        }
+    });
+}
+
+#[test]
+#[ignore = "requires lldb-dap"]
+fn test_stress_fixture_lldb() {
+    if fixturebuild::compiler(Language::C).is_none() {
+        eprintln!("Skipping test: no C compiler");
+        return;
+    }
+
+    with_adapter(Adapter::Lldb, "stress_fixture", |ctx| {
+        let build = Build::c("stress");
+        let binary = ctx.build_fixture(&build).clone();
+        check_stress_session(ctx, &binary, &build);
+    });
+}
+
+/// The fixtures cross-compiled for `arch`, each with lines it prints
+fn cross_fixtures(arch: Arch) -> Vec<(Build, &'static [&'static str])> {
+    vec![
+        (Build::c("simple").arch(arch), &["Sum: 30", "Factorial: 120"]),
+        (Build::go("simple").arch(arch), &["Sum: 30", "Factorial: 120"]),
+    ]
+}
+
+/// What a cross build's ELF header should say, GDB's name for the
+/// architecture, registers that only it has, and mnemonics at least one of
+/// which a few instructions of its code will use
+struct CrossArch {
+    object: object::Architecture,
+    gdb: &'static str,
+    registers: &'static [&'static str],
+    mnemonics: &'static [&'static str],
+}
+
+fn cross_arch(arch: Arch) -> CrossArch {
+    match arch {
+        Arch::Arm64 => CrossArch {
+            object: object::Architecture::Aarch64,
+            gdb: "aarch64",
+            registers: &["$x0", "$x30", "$cpsr"],
+            mnemonics: &["ldr", "str", "stp", "ldp", "mov", "add", "bl"],
+        },
+        Arch::I386 => CrossArch {
+            object: object::Architecture::I386,
+            gdb: "i386",
+            registers: &["$eip", "$esp", "$eax", "$eflags"],
+            mnemonics: &["mov", "push", "lea", "add", "call", "sub"],
+        },
+        Arch::Riscv64 => CrossArch {
+            object: object::Architecture::Riscv64,
+            gdb: "riscv:rv64",
+            registers: &["$ra", "$a0", "$s0"],
+            mnemonics: &["addi", "sd", "ld", "sw", "lw", "mv", "jal", "auipc"],
+        },
+        Arch::Native => unreachable!("the host is not a cross architecture"),
+    }
+}
+
+#[test]
+fn test_cross_fixtures_build_for_every_arch() {
+    use debugger::symbols::SymbolIndex;
+    use object::Object;
+
+    let mut ctx = TestContext::new("cross_fixtures");
+    for arch in Arch::CROSS {
+        let expected_arch = cross_arch(arch).object;
+        let qemu = arch.qemu();
+        for (fixture, expected) in cross_fixtures(arch) {
+            if fixture.compiler().is_none() {
+                eprintln!("Skipping {}: no compiler for it", fixture.output_name());
+                continue;
+            }
+            for build in fixture.variants() {
+                let variant = build.output_name();
+                let binary = ctx.build_fixture(&build).clone();
+                let data = fs::read(&binary).expect("Failed to read the binary");
+                let file = object::File::parse(&*data).expect("Failed to parse the binary");
+                assert_eq!(file.architecture(), expected_arch, "{}", variant);
+
+                // Symbols and markers come from the binary alone, whatever it runs on
+                let index = SymbolIndex::load(&binary).expect("Failed to read the symbols");
+                let main = match build.language() {
+                    Language::Go => "main.main",
+                    _ => "main",
+                };
+                assert!(
+                    index.functions.iter().any(|function| function.name == main),
+                    "{}: expected {} in the symbols",
+                    variant,
+                    main
+                );
+                let (path, _) = index
+                    .find_marker("add_body", |path| path.to_path_buf())
+                    .unwrap_or_else(|e| panic!("{}: {}", variant, e));
+                assert_eq!(path, build.main_source(), "{}", variant);
+
+                let Some(qemu) = &qemu else {
+                    continue;
+                };
+                let output = Command::new(qemu).arg(&binary).output().expect("Failed to run");
+                let stdout = String::from_utf8_lossy(&output.stdout);
+                assert!(output.status.success(), "{} failed: {:?}", variant, output);
+                for line in expected {
+                    assert!(stdout.contains(line), "{}: expected {:?}: {}", variant, line, stdout);
+                }
+            }
+        }
+        if qemu.is_none() {
+            eprintln!("Not running the {} builds: no qemu-user for it", arch.goarch());
+        }
+    }
+}
+
+/// A GDB that debugs `arch`: gdb-multiarch, or a gdb built for every target,
+/// at a version with DAP support
+fn cross_gdb_available(arch: Arch) -> Option<PathBuf> {
+    use debugger::setup::adapters::gdb_common::{is_gdb_version_sufficient, parse_gdb_version};
+
+    ["gdb-multiarch", "gdb"].iter().find_map(|candidate| {
+        let path = which::which(candidate).ok()?;
+        let output = Command::new(&path).arg("--version").output().ok()?;
+        let version = parse_gdb_version(&String::from_utf8_lossy(&output.stdout))?;
+        if !is_gdb_version_sufficient(&version) {
+            return None;
+        }
+        let set = format!("set architecture {}", cross_arch(arch).gdb);
+        let output = Command::new(&path).args(["-batch", "-nx", "-ex", &set]).output().ok()?;
+        let stderr = String::from_utf8_lossy(&output.stderr);
+        (output.status.success() && stderr.trim().is_empty()).then_some(path)
+    })
+}
+
+/// A program running under `qemu -g`, waiting for a debugger on `port`;
+/// killed when dropped
+struct QemuStub {
+    child: std::process::Child,
+    port: u16,
+}
+
+impl QemuStub {
+    fn start(qemu: &str, binary: &Path) -> Self {
+        // Ask the kernel for a free port, then hand it to qemu
+        let port = std::net::TcpListener::bind("127.0.0.1:0")
+            .and_then(|listener| listener.local_addr())
+            .expect("Failed to find a free port")
+            .port();
+        let child = Command::new(qemu)
+            .args(["-g", &port.to_string()])
+            .arg(binary)
+            .stdout(Stdio::null())
+            .stderr(Stdio::null())
+            .spawn()
+            .unwrap_or_else(|e| panic!("Failed to run {}: {}", qemu, e));
+        // qemu listens before it runs anything; give it a moment to bind
+        let deadline = Instant::now() + Duration::from_secs(10);
+        while std::net::TcpStream::connect(("127.0.0.1", port)).is_err() {
+            assert!(Instant::now() < deadline, "{} never listened on {}", qemu, port);
+            std::thread::sleep(Duration::from_millis(50));
+        }
+        Self { child, port }
+    }
+}
+
+impl Drop for QemuStub {
+    fn drop(&mut self) {
+        let _ = self.child.kill();
+        let _ = self.child.wait();
+    }
+}
+
+/// Attach to `binary` under qemu, stop at a breakpoint in `add`, and read
+/// its arguments, the architecture's registers and its disassembly
+fn check_cross_session(ctx: &TestContext, qemu: &str, binary: &Path, build: &Build) {
+    let arch = cross_arch(build.target());
+    let variant = build.output_name();
+    let markers = ctx.find_breakpoint_markers(build.main_source());
+    let stub = QemuStub::start(qemu, binary);
+    ctx.cleanup_daemon();
+
+    let remote = format!("127.0.0.1:{}", stub.port);
+    let program = binary.to_str().unwrap();
+    let output = ctx.run_debugger_ok(&["attach", "--remote", &remote, "--program", program]);
+    assert!(output.contains("Attached"), "{}: {}", variant, output);
+    ctx.run_debugger_ok(&["break", "@marker:add_body"]);
+    ctx.run_debugger_ok(&["continue"]);
+    let output = ctx.run_debugger_ok(&["await", "--timeout", "60"]);
+    let source = build.main_source().file_name().unwrap().to_str().unwrap();
+    let location = format!("{}:{}", source, markers["add_body"]);
+    assert!(output.contains(&location), "{}: expected {}: {}", variant, location, output);
+
+    let output = ctx.run_debugger_ok(&["print", "a"]);
+    assert!(output.contains("10"), "{}: expected a = 10: {}", variant, output);
+    for register in ["$pc", "$sp"].iter().chain(arch.registers) {
+        let output = ctx.run_debugger_ok(&["print", register]);
+        assert!(
+            output.chars().any(|c| c.is_ascii_digit()),
+            "{}: expected a value for {}: {}",
+            variant,
+            register,
+            output
+        );
+    }
+    let output = ctx.run_debugger_ok(&["disassemble", "--count", "16"]);
+    assert!(
+        arch.mnemonics.iter().any(|mnemonic| output.contains(mnemonic)),
+        "{}: expected {} code: {}",
+        variant,
+        arch.gdb,
+        output
+    );
+
+    ctx.run_debugger_ok(&["continue"]);
+    let output = ctx.run_debugger_ok(&["await", "--timeout", "60"]);
+    assert!(output.contains("exited"), "{}: expected an exit: {}", variant, output);
+    let _ = ctx.run_debugger(&["stop"]);
+}
+
+#[test]
+fn test_cross_fixtures_gdb_under_qemu() {
+    for arch in Arch::CROSS {
+        let (Some(gdb_path), Some(qemu)) = (cross_gdb_available(arch), arch.qemu()) else {
+            eprintln!("Skipping {}: needs gdb-multiarch ≥14.1 and qemu-user", arch.goarch());
+            continue;
+        };
+        let mut ctx = TestContext::new(&format!("cross_fixtures_gdb_{}", arch.goarch()));
+        ctx.create_config_with_args("gdb", gdb_path.to_str().unwrap(), &["-i=dap"]);
+        for (build, _) in cross_fixtures(arch) {
+            if build.compiler().is_none() {
+                eprintln!("Skipping {}: no compiler for it", build.output_name());
+                continue;
+            }
+            let binary = ctx.build_fixture(&build).clone();
+            check_cross_session(&ctx, &qemu, &binary, &build);
+        }
+    }
+}
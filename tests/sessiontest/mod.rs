@@ -0,0 +1,345 @@
+//! Golden-output tests for debugger sessions
+//!
+//! A golden test is a command script, `tests/golden/NAME.dbg`, and the
+//! transcript it is expected to produce, `tests/golden/NAME.out`. The
+//! harness runs the script in batch mode against its own daemon and
+//! configuration, scrubs what differs between runs (addresses, PIDs, temp
+//! paths, timings) and compares the result with the golden file.
+//!
+//! Set `UPDATE_GOLDEN=1` to write the transcripts instead of comparing them,
+//! then review the diff like any other change:
+//!
+//! ```bash
+//! UPDATE_GOLDEN=1 cargo test --test golden
+//! ```
+//!
+//! Scripts see the fixtures built with [`Session::build_c`] and the values
+//! given with [`Session::define`] as `${NAME}`, and can pass extra flags on a
+//! leading `# args:` line, e.g.
+//! `# args: --ci --continue-on-error`.
+
+use std::env;
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::process::Command;
+
+use crate::fixturebuild::{self, Build};
+
+/// Whether goldens are being rewritten rather than checked
+pub fn updating() -> bool {
+    env::var("UPDATE_GOLDEN").is_ok_and(|value| !matches!(value.as_str(), "" | "0" | "false"))
+}
+
+/// Where the golden scripts and transcripts live
+pub fn golden_dir() -> PathBuf {
+    PathBuf::from(env!("CARGO_MANIFEST_DIR")).join("tests").join("golden")
+}
+
+/// A debugger with its own daemon, configuration and fixtures
+pub struct Session {
+    /// Temporary directory for this test
+    dir: PathBuf,
+    /// Path to the debugger binary
+    debugger_bin: PathBuf,
+    /// Path to the fixtures directory
+    fixtures_dir: PathBuf,
+    /// `-D NAME=VALUE` definitions for the scripts
+    defines: Vec<(String, String)>,
+}
+
+impl Session {
+    /// Create a session directory for the test `name`
+    pub fn new(name: &str) -> Self {
+        let dir = env::temp_dir().join("debugger-cli-golden").join(name);
+        let _ = fs::remove_dir_all(&dir);
+        for sub in ["config", "runtime", "data"] {
+            fs::create_dir_all(dir.join(sub)).expect("Failed to create test dir");
+        }
+
+        Self {
+            dir,
+            debugger_bin: find_debugger_binary(),
+            fixtures_dir: fixturebuild::fixtures_dir(),
+            defines: Vec::new(),
+        }
+    }
+
+    /// Use `adapter` for the session, as the default adapter
+    pub fn adapter(&mut self, name: &str, path: &Path, args: &[&str]) -> &mut Self {
+        let args = args
+            .iter()
+            .map(|a| format!("\"{}\"", a))
+            .collect::<Vec<_>>()
+            .join(", ");
+        let config = format!(
+            "[adapters.{name}]\npath = \"{path}\"\nargs = [{args}]\n\n\
+             [defaults]\nadapter = \"{name}\"\n",
+            name = name,
+            path = path.display(),
+            args = args,
+        );
+
+        let config_path = self.dir.join("config/debugger-cli/config.toml");
+        fs::create_dir_all(config_path.parent().unwrap()).expect("Failed to create config dir");
+        fs::write(&config_path, config).expect("Failed to write config");
+        self
+    }
+
+    /// Define `${name}` for the scripts
+    pub fn define(&mut self, name: &str, value: &str) -> &mut Self {
+        self.defines.push((name.to_string(), value.to_string()));
+        self
+    }
+
+    /// Compile the C fixture `name` with debug info and define `${name}` as
+    /// the program's path
+    pub fn build_c(&mut self, name: &str) -> &mut Self {
+        let output = Build::c(name).compile(&self.dir);
+        self.define(name, &output.to_string_lossy())
+    }
+
+    /// Run a script in batch mode and return its normalized transcript:
+    /// stdout, then stderr and the exit status when there is something to say
+    pub fn run_script(&self, script: &Path) -> String {
+        let text = fs::read_to_string(script).expect("Failed to read script");
+        let args: Vec<String> = text
+            .lines()
+            .next()
+            .and_then(|line| line.strip_prefix("# args:"))
+            .map(|args| args.split_whitespace().map(String::from).collect())
+            .unwrap_or_default();
+
+        let mut command = self.command();
+        if !args.iter().any(|arg| arg == "--ci") {
+            command.arg("--batch");
+        }
+        command.args(&args);
+        for (name, value) in &self.defines {
+            command.arg("-D").arg(format!("{}={}", name, value));
+        }
+        let output = command
+            .arg("-x")
+            .arg(script)
+            .output()
+            .expect("Failed to run debugger");
+
+        let mut transcript = String::from_utf8_lossy(&output.stdout).to_string();
+        let stderr = String::from_utf8_lossy(&output.stderr);
+        if !stderr.is_empty() {
+            transcript.push_str("--- stderr\n");
+            transcript.push_str(&stderr);
+        }
+        match output.status.code() {
+            Some(0) => {}
+            Some(code) => transcript.push_str(&format!("--- exit status {}\n", code)),
+            None => transcript.push_str("--- killed by a signal\n"),
+        }
+
+        normalize(
+            &transcript,
+            &[
+                (self.dir.as_path(), "<tmp>"),
+                (self.fixtures_dir.as_path(), "<fixtures>"),
+                (golden_dir().as_path(), "<golden>"),
+            ],
+        )
+    }
+
+    /// Run `tests/golden/NAME.dbg` and compare it with `NAME.out`
+    pub fn check(&self, name: &str) {
+        let dir = golden_dir();
+        let actual = self.run_script(&dir.join(format!("{}.dbg", name)));
+        assert_golden(&dir.join(format!("{}.out", name)), &actual);
+    }
+
+    fn command(&self) -> Command {
+        let mut command = Command::new(&self.debugger_bin);
+        command
+            .current_dir(&self.dir)
+            .env("XDG_CONFIG_HOME", self.dir.join("config"))
+            .env("XDG_RUNTIME_DIR", self.dir.join("runtime"))
+            .env("XDG_DATA_HOME", self.dir.join("data"))
+            .env("NO_COLOR", "1");
+        command
+    }
+}
+
+impl Drop for Session {
+    fn drop(&mut self) {
+        let _ = self.command().args(["daemon", "stop"]).output();
+    }
+}
+
+/// Compare `actual` with the golden file at `path`, or write it there when
+/// updating
+pub fn assert_golden(path: &Path, actual: &str) {
+    if updating() {
+        fs::write(path, actual).expect("Failed to write golden file");
+        return;
+    }
+
+    let expected = fs::read_to_string(path).unwrap_or_else(|_| {
+        panic!(
+            "Missing golden file {}; run with UPDATE_GOLDEN=1 to create it",
+            path.display()
+        )
+    });
+    if expected != actual {
+        panic!(
+            "Output differs from {} (run with UPDATE_GOLDEN=1 to accept it):\n{}",
+            path.display(),
+            diff(&expected, actual)
+        );
+    }
+}
+
+/// The lines of two transcripts that differ, `-` expected and `+` actual
+fn diff(expected: &str, actual: &str) -> String {
+    let expected: Vec<&str> = expected.lines().collect();
+    let actual: Vec<&str> = actual.lines().collect();
+    let mut out = String::new();
+    for i in 0..expected.len().max(actual.len()) {
+        match (expected.get(i), actual.get(i)) {
+            (Some(e), Some(a)) if e == a => out.push_str(&format!("  {}\n", e)),
+            (e, a) => {
+                if let Some(e) = e {
+                    out.push_str(&format!("- {}\n", e));
+                }
+                if let Some(a) = a {
+                    out.push_str(&format!("+ {}\n", a));
+                }
+            }
+        }
+    }
+    out
+}
+
+/// Scrub what changes from run to run: the given paths, hex addresses,
+/// process IDs and timings, and the list of settings an unknown one is
+/// answered with, which grows with every new setting
+pub fn normalize(text: &str, paths: &[(&Path, &str)]) -> String {
+    let mut text = text.to_string();
+    for (path, placeholder) in paths {
+        text = text.replace(&*path.to_string_lossy(), placeholder);
+    }
+    text.lines()
+        .map(|line| scrub_line(line) + "\n")
+        .collect()
+}
+
+fn scrub_line(line: &str) -> String {
+    if let Some((before, _)) = line.split_once("Settings: ") {
+        return format!("{}Settings: <settings>", scrub_line(before));
+    }
+    let chars: Vec<char> = line.chars().collect();
+    let mut out = String::new();
+    let mut i = 0;
+    while i < chars.len() {
+        let boundary = i == 0 || !chars[i - 1].is_alphanumeric();
+
+        // Hex addresses
+        if boundary
+            && chars[i] == '0'
+            && matches!(chars.get(i + 1), Some('x' | 'X'))
+            && chars.get(i + 2).is_some_and(|c| c.is_ascii_hexdigit())
+        {
+            i += 2;
+            while chars.get(i).is_some_and(|c| c.is_ascii_hexdigit()) {
+                i += 1;
+            }
+            out.push_str("<addr>");
+            continue;
+        }
+
+        // Timings like 1.5s or 20ms
+        if boundary && chars[i].is_ascii_digit() {
+            let mut end = i;
+            while chars.get(end).is_some_and(|c| c.is_ascii_digit() || *c == '.') {
+                end += 1;
+            }
+            let unit = ["ms", "s"].into_iter().find(|unit| {
+                let unit: Vec<char> = unit.chars().collect();
+                chars.get(end..end + unit.len()) == Some(&unit[..])
+                    && !chars.get(end + unit.len()).is_some_and(|c| c.is_alphanumeric())
+            });
+            if let Some(unit) = unit {
+                out.push_str("<time>");
+                i = end + unit.len();
+                continue;
+            }
+            // A process ID follows "pid" or "process"
+            let before = out.to_ascii_lowercase();
+            let before = before.trim_end_matches([' ', ':', '=']);
+            if before.ends_with("pid") || before.ends_with("process") {
+                out.push_str("<pid>");
+                i = end;
+                continue;
+            }
+            out.extend(&chars[i..end]);
+            i = end;
+            continue;
+        }
+
+        out.push(chars[i]);
+        i += 1;
+    }
+    out
+}
+
+/// GDB, if it is new enough to speak DAP
+pub fn gdb_available() -> Option<PathBuf> {
+    use debugger::setup::adapters::gdb_common::{is_gdb_version_sufficient, parse_gdb_version};
+
+    let path = which::which("gdb").ok()?;
+    let output = Command::new(&path).arg("--version").output().ok()?;
+    let version = parse_gdb_version(&String::from_utf8_lossy(&output.stdout))?;
+    is_gdb_version_sufficient(&version).then_some(path)
+}
+
+/// Find the debugger binary, building it if needed
+fn find_debugger_binary() -> PathBuf {
+    let manifest_dir = env!("CARGO_MANIFEST_DIR");
+    let candidates = [
+        PathBuf::from(manifest_dir).join("target/debug/debugger"),
+        PathBuf::from(manifest_dir).join("target/release/debugger"),
+    ];
+    if let Some(found) = candidates.iter().find(|candidate| candidate.exists()) {
+        return found.clone();
+    }
+
+    let status = Command::new("cargo")
+        .args(["build"])
+        .current_dir(manifest_dir)
+        .status()
+        .expect("Failed to build debugger");
+    assert!(status.success(), "Failed to build debugger");
+    candidates[0].clone()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn normalize_scrubs_what_changes_between_runs() {
+        let tmp = Path::new("/tmp/debugger-cli-golden/x");
+        assert_eq!(
+            normalize(
+                "Attached to process 4242 (pid: 4242) at 0x7ffd1234abcd\n\
+                 Program: /tmp/debugger-cli-golden/x/simple\n\
+                 CI passed in 1.5s, await took 20ms\n\
+                 Stopped at simple.c:19 in thread 1\n",
+                &[(tmp, "<tmp>")]
+            ),
+            "Attached to process <pid> (pid: <pid>) at <addr>\n\
+             Program: <tmp>/simple\n\
+             CI passed in <time>, await took <time>\n\
+             Stopped at simple.c:19 in thread 1\n"
+        );
+    }
+
+    #[test]
+    fn diff_marks_changed_lines() {
+        assert_eq!(diff("a\nb\n", "a\nc\nd\n"), "  a\n- b\n+ c\n+ d\n");
+    }
+}